@@ -0,0 +1,221 @@
+// This package defines the types for Flux API version 12.
+package v12
+
+import (
+	"context"
+	"time"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/api/v11"
+	"github.com/weaveworks/flux/image"
+	"github.com/weaveworks/flux/job"
+)
+
+// The types of LoopEvent.
+const (
+	LoopEventSync      = "sync"
+	LoopEventImagePoll = "image-poll"
+	LoopEventJob       = "job"
+	LoopEventGit       = "git"
+)
+
+// LoopEvent is something that happened in the daemon's loop, e.g., a
+// sync starting or failing.
+type LoopEvent struct {
+	Time    time.Time
+	Type    string
+	Message string
+	// If the event is of something failing, the error
+	Error string
+}
+
+type LoopEventsOptions struct {
+	// If not zero, only events after this time are given
+	Since time.Time
+	// If not empty, only events of these types are given
+	Types []string
+}
+
+// NamespaceSync is how far a namespace has been synced: the
+// revision at which all its resources were last applied (and, with
+// the health gate, ready), and if a later revision failed, which,
+// and why.
+type NamespaceSync struct {
+	Namespace string
+	// The last revision at which everything in the namespace was
+	// synced; empty if it hasn't been, by this daemon
+	Revision string
+	// If the namespace failed to sync at a later revision, that
+	// revision, and the errors
+	FailedRevision string
+	Errors         []string
+}
+
+// NamespacesSync is the per-namespace sync status, along with the
+// aggregate: the revision most recently synced, which is where the
+// sync tag is, and whether every namespace is at it. SkippedFiles are
+// the manifest files left out of the last sync for being too large;
+// if there are any, not everything is synced. PendingDeletions are
+// the resources removed from the manifests that will be garbage
+// collected once the grace period is over.
+type NamespacesSync struct {
+	Revision         string
+	AllSynced        bool
+	Namespaces       []NamespaceSync
+	SkippedFiles     []string
+	PendingDeletions []PendingDeletion
+}
+
+// PendingDeletion is a resource waiting out the grace period before
+// being garbage collected, and when that will be over.
+type PendingDeletion struct {
+	ID          flux.ResourceID
+	DeleteAfter time.Time
+}
+
+type MergePreviewOptions struct {
+	// The branch to preview merging into the branch that's synced
+	Branch string
+}
+
+// MergePreview is what syncing would change, were a branch merged
+// into the branch that's synced.
+type MergePreview struct {
+	Branch string
+	// The revision of the synced branch, and of the branch merged
+	// into it
+	BaseRevision   string
+	BranchRevision string
+	// If the branch can't be merged cleanly, the files with
+	// conflicts; in which case there are no changes
+	Conflicts []string
+	Changes   []ResourceChange
+}
+
+// ResourceChange is a change that syncing would make to a resource.
+type ResourceChange struct {
+	ID     flux.ResourceID
+	Source string
+	// The resource would be created
+	Missing bool
+	// The resource would be deleted by garbage collection; in which
+	// case there's no Source or Diff
+	Deleted bool
+	// What would change, as a unified diff of the manifest against
+	// the resource in the cluster; this may be truncated
+	Diff string
+	// The diff has been truncated
+	Truncated bool
+}
+
+// DaemonConfig is the configuration a daemon is running with: each of
+// its flags, whether given or left at the default, with secrets
+// redacted.
+type DaemonConfig struct {
+	Version string
+	Flags   []ConfigFlag
+}
+
+// ConfigFlag is one of the daemon's flags and its value.
+type ConfigFlag struct {
+	Name string
+	// The value, as it would be given on the command line; for a flag
+	// that can be given more than once, each of its values
+	Values []string
+	// Whether the flag can be given more than once
+	Repeated bool
+	// The flag was given, rather than left at its default
+	Explicit bool
+	// The value has been left out, or has had a password taken out,
+	// since it's secret
+	Redacted bool
+}
+
+// ClusterVersions gives the versions of kubectl and of the API server
+// the daemon applies to, as detected when it started; either is empty
+// if it couldn't be detected.
+type ClusterVersions struct {
+	Kubectl string
+	Server  string
+}
+
+type JobHistoryOptions struct {
+	// If more than zero, at most this many jobs are given
+	Limit int
+}
+
+type AutomationPreviewOptions struct {
+	// If not empty, only workloads in this namespace are previewed
+	Namespace string
+}
+
+// AutomationPreview is what automation would do were it to run now:
+// for each automated workload, whether its containers would be
+// updated, to which images, and if not, why not.
+type AutomationPreview struct {
+	Workloads []WorkloadAutomationPreview
+}
+
+// WorkloadAutomationPreview is what automation would do to a
+// workload.
+type WorkloadAutomationPreview struct {
+	ID flux.ResourceID
+	// If the workload wouldn't be updated this time, the reason
+	// (e.g., it's locked, or outside its automation schedule); the
+	// containers still say what they would be updated to otherwise
+	HeldBack   string `json:",omitempty"`
+	Containers []ContainerAutomationPreview
+}
+
+// ContainerAutomationPreview is what automation would do to a
+// container.
+type ContainerAutomationPreview struct {
+	Name    string
+	Current image.Ref
+	// Whether the container would be updated, and if so, to what
+	Update bool
+	Target image.Ref
+	// Why the container would or wouldn't be updated
+	Reason string
+}
+
+type Server interface {
+	v11.Server
+
+	// LoopEvents gives the most recent events from the daemon's loop,
+	// oldest first.
+	LoopEvents(ctx context.Context, opts LoopEventsOptions) ([]LoopEvent, error)
+
+	// NamespaceSyncStatus gives how far each namespace has been
+	// synced.
+	NamespaceSyncStatus(ctx context.Context) (NamespacesSync, error)
+
+	// MergePreview gives what syncing would change, were the branch
+	// given merged into the branch that's synced, without merging or
+	// applying anything.
+	MergePreview(ctx context.Context, opts MergePreviewOptions) (MergePreview, error)
+
+	// DaemonConfig gives the configuration the daemon is running
+	// with.
+	DaemonConfig(ctx context.Context) (DaemonConfig, error)
+
+	// JobHistory gives the records of the most recent jobs, most
+	// recent first.
+	JobHistory(ctx context.Context, opts JobHistoryOptions) ([]job.Record, error)
+
+	// AutomationPreview gives what automation would do were it to
+	// run now, without committing anything.
+	AutomationPreview(ctx context.Context, opts AutomationPreviewOptions) (AutomationPreview, error)
+
+	// SyncedResources gives the resources in the cluster that syncs
+	// have applied, of whatever kind, as YAML.
+	SyncedResources(ctx context.Context) ([]byte, error)
+
+	// ClusterVersions gives the versions of kubectl and the API
+	// server.
+	ClusterVersions(ctx context.Context) (ClusterVersions, error)
+}
+
+type Upstream interface {
+	v11.Upstream
+}
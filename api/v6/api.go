@@ -2,6 +2,7 @@ package v6
 
 import (
 	"context"
+	"time"
 
 	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/cluster"
@@ -43,6 +44,18 @@ type ControllerStatus struct {
 	Locked     bool
 	Ignore     bool
 	Policies   map[string]string
+	// The git revision the workload was last successfully synced
+	// from, or empty if it hasn't been synced since the daemon
+	// started.
+	SyncedRevision string
+	// If the workload has been removed from the git repo and will be
+	// deleted once the garbage collection grace period is over, when
+	// that will be; otherwise, zero.
+	DeleteAfter time.Time
+	// If the workload has a sync interval of its own (the
+	// sync-interval annotation) shorter than the daemon's, how often
+	// it's synced; otherwise, zero.
+	SyncInterval time.Duration
 }
 
 // --- config types
@@ -53,10 +66,24 @@ type GitRemoteConfig struct {
 	Path   string `json:"path"`
 }
 
+// SyncLeadership says whether a daemon is the one syncing, when there
+// are several daemons electing a leader.
+type SyncLeadership string
+
+const (
+	SyncLeadershipNone     SyncLeadership = "" // no leader election
+	SyncLeadershipLeader   SyncLeadership = "leader"
+	SyncLeadershipFollower SyncLeadership = "follower"
+)
+
 type GitConfig struct {
 	Remote       GitRemoteConfig   `json:"remote"`
 	PublicSSHKey ssh.PublicKey     `json:"publicSSHKey"`
 	Status       git.GitRepoStatus `json:"status"`
+	Leadership   SyncLeadership    `json:"leadership,omitempty"`
+	// If the repo isn't ready, why not; e.g., the error from the
+	// last attempt to clone it
+	Error string `json:"error,omitempty"`
 }
 
 type Deprecated interface {
@@ -0,0 +1,136 @@
+// Package audit records the changes people ask fluxd to make (e.g.,
+// locking a workload, or releasing an image), and with what outcome,
+// for compliance. Audit records are kept separately from the logs,
+// and written to one or more sinks.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/job"
+)
+
+// Actions that are audited.
+const (
+	ActionLock       = "lock"
+	ActionUnlock     = "unlock"
+	ActionAutomate   = "automate"
+	ActionDeautomate = "deautomate"
+	ActionPolicy     = "policy" // any other change of policy
+	ActionRelease    = "release"
+	ActionResetSync  = "reset_sync"
+	ActionMarkSynced = "mark_synced"
+)
+
+// Outcomes of an action. Each action is recorded when it's requested,
+// then again once it has succeeded or failed; so an action is on
+// record even if, say, pushing its commit fails.
+const (
+	OutcomeRequested = "requested"
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+)
+
+// Record is an audit record, for an action taken on the workloads
+// given.
+type Record struct {
+	Time      time.Time         `json:"time"`
+	JobID     job.ID            `json:"jobID"`
+	Action    string            `json:"action"`
+	Outcome   string            `json:"outcome"`
+	Actor     string            `json:"actor"`
+	Message   string            `json:"message,omitempty"`
+	Workloads []flux.ResourceID `json:"workloads,omitempty"`
+	// For actions that aren't about particular workloads (e.g., a
+	// release of all workloads), what was asked for
+	Spec     string `json:"spec,omitempty"`
+	Revision string `json:"revision,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Sink is somewhere audit records are written.
+type Sink interface {
+	Record(context.Context, Record) error
+}
+
+// Sinks writes records to each of the sinks in turn, returning the
+// first error (if any) once all have been tried.
+type Sinks []Sink
+
+func (sinks Sinks) Record(ctx context.Context, r Record) error {
+	var firstErr error
+	for _, s := range sinks {
+		if err := s.Record(ctx, r); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// FileSink appends records to a file, as one line of JSON each.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens the file given for appending to, creating it if
+// necessary.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: f}, nil
+}
+
+func (s *FileSink) Record(_ context.Context, r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// WebhookSink posts each record, as JSON, to a URL.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *WebhookSink) Record(ctx context.Context, r Record) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("audit webhook responded with %s", resp.Status)
+	}
+	return nil
+}
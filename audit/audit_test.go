@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weaveworks/flux"
+)
+
+func record(outcome string) Record {
+	return Record{
+		Time:      time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC),
+		JobID:     "job1",
+		Action:    ActionLock,
+		Outcome:   outcome,
+		Actor:     "Jane Doe <jane@example.com>",
+		Workloads: []flux.ResourceID{flux.MustParseResourceID("default:deployment/helloworld")},
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, sink.Record(context.Background(), record(OutcomeRequested)))
+	assert.NoError(t, sink.Record(context.Background(), record(OutcomeSucceeded)))
+
+	// Opening it again appends
+	sink, err = NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, sink.Record(context.Background(), record(OutcomeFailed)))
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	if assert.Len(t, lines, 3) {
+		var r Record
+		assert.NoError(t, json.Unmarshal([]byte(lines[2]), &r))
+		assert.Equal(t, record(OutcomeFailed), r)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var received []Record
+	fail, hits := false, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var rec Record
+		if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+			t.Error(err)
+		}
+		received = append(received, rec)
+	}))
+	defer server.Close()
+
+	sink := &WebhookSink{URL: server.URL}
+	assert.NoError(t, sink.Record(context.Background(), record(OutcomeRequested)))
+	assert.Equal(t, []Record{record(OutcomeRequested)}, received)
+
+	// Every sink is tried, even if one fails
+	fail = true
+	other := &WebhookSink{URL: server.URL}
+	assert.Error(t, Sinks{sink, other}.Record(context.Background(), record(OutcomeSucceeded)))
+	assert.Equal(t, 3, hits)
+}
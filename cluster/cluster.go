@@ -2,6 +2,7 @@ package cluster
 
 import (
 	"errors"
+	"time"
 
 	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/policy"
@@ -30,10 +31,93 @@ type Cluster interface {
 	IsAllowedResource(flux.ResourceID) bool
 	Ping() error
 	Export() ([]byte, error)
-	Sync(SyncSet) error
+	// Sync applies the resources given, and reports what happened
+	// to them; an error may be given along with the summary if some
+	// resources failed to sync.
+	Sync(SyncSet) (SyncSummary, error)
 	PublicSSHKey(regenerate bool) (ssh.PublicKey, error)
 }
 
+// DisruptionBudget is a limit on how many of a set of pods may be
+// disrupted at once, e.g., a Kubernetes PodDisruptionBudget.
+type DisruptionBudget struct {
+	// Identifies the budget, for reporting
+	Name string
+	// How many more pods covered by the budget may be disrupted
+	// right now
+	Allowed int
+	// Whether as many of the pods covered are healthy as the budget
+	// wants; if so, and it allows no disruptions, it never will
+	// (e.g., because minAvailable is the number of replicas)
+	Healthy bool
+	// Those of the workloads asked about that have pods covered by
+	// the budget
+	Workloads []flux.ResourceID
+}
+
+// ReadinessChecker is implemented by clusters that can tell whether
+// resources other than workloads (e.g., custom resources) are ready,
+// so that waiting for workloads to be ready can wait for those too.
+type ReadinessChecker interface {
+	// NotReady gives the reason each of the resources given isn't
+	// ready. Those it can't tell the readiness of, or that aren't in
+	// the cluster, are left out.
+	NotReady([]resource.Resource) (map[flux.ResourceID]string, error)
+}
+
+// DisruptionBudgeter is implemented by clusters that can report the
+// disruption budgets covering the pods of workloads, so updates to
+// them can be paced to respect the budgets.
+type DisruptionBudgeter interface {
+	DisruptionBudgets([]flux.ResourceID) ([]DisruptionBudget, error)
+}
+
+// ValueResolver is implemented by clusters that fill in values kept
+// elsewhere (e.g., in Vault) when applying resources. Those values can
+// change when the resources don't, so a sync of resources with such
+// values can't be skipped for the resources being unchanged.
+type ValueResolver interface {
+	ResolvesValues(resources map[string]resource.Resource) bool
+}
+
+// SyncedCounter is implemented by clusters that can count the
+// resources in them applied by syncs of the sync set named, whether
+// or not they'd be garbage collected.
+type SyncedCounter interface {
+	SyncedCount(syncSetName string) (int, error)
+}
+
+// SyncedExporter is implemented by clusters that can export, as
+// YAML, the resources in them applied by syncs of the sync set
+// named, of whatever kind.
+type SyncedExporter interface {
+	ExportSynced(syncSetName string) ([]byte, error)
+}
+
+// SyncedFingerprinter is implemented by clusters that can give a
+// fingerprint of the resources in them applied by syncs of the sync
+// set named, that changes when any of those resources is changed,
+// deleted or replaced, so that a sync can tell whether the cluster has
+// been changed since the last.
+type SyncedFingerprinter interface {
+	SyncedFingerprint(syncSetName string) (string, error)
+}
+
+// PendingDeletion is a resource no longer in the manifests of a sync
+// set, that will be garbage collected once the grace period is over,
+// unless it's restored before then.
+type PendingDeletion struct {
+	ID          flux.ResourceID
+	DeleteAfter time.Time
+}
+
+// PendingDeleter is implemented by clusters that wait out a grace
+// period before garbage collecting resources, and can give those
+// applied by syncs of the sync set named that are still waiting.
+type PendingDeleter interface {
+	PendingDeletions(syncSetName string) []PendingDeletion
+}
+
 // RolloutStatus describes numbers of pods in different states and
 // the messages about unexpected rollout progress
 // a rollout status might be:
@@ -76,6 +160,10 @@ type Workload struct {
 	// Errors during the recurring sync from the Git repository to the
 	// cluster will surface here.
 	SyncError error
+	// If the workload has been removed from the Git repository, and
+	// is waiting out the grace period before being garbage collected,
+	// the time after which it will be deleted.
+	DeleteAfter time.Time
 
 	Containers ContainersOrExcuse
 }
@@ -0,0 +1,122 @@
+package kubernetes
+
+import (
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"gopkg.in/yaml.v2"
+
+	"github.com/weaveworks/flux/cluster"
+)
+
+// How resources are grouped into batches, when applying in batches.
+const (
+	// Resources with the same API group (e.g., cert-manager.io) are
+	// batched together
+	ApplyBatchByGroup = "group"
+	// Resources of the same kind are batched together
+	ApplyBatchByKind = "kind"
+)
+
+type applyBatch struct {
+	key  string
+	objs []applyObject
+}
+
+// batchKey gives what the object is batched by: the API group in its
+// apiVersion ("core" for the core group), or its kind.
+func (c *Kubectl) batchKey(obj applyObject) string {
+	if c.ApplyBatchBy == ApplyBatchByKind {
+		_, kind, _ := obj.ResourceID.Components()
+		return kind
+	}
+	var manifest struct {
+		APIVersion string `yaml:"apiVersion"`
+	}
+	if err := yaml.Unmarshal(obj.Payload, &manifest); err == nil {
+		if parts := strings.SplitN(manifest.APIVersion, "/", 2); len(parts) == 2 {
+			return parts[0]
+		}
+	}
+	return "core"
+}
+
+// makeBatches splits the objects given into batches of at most
+// ApplyBatchSize objects with the same key (see batchKey). The
+// objects are given in the order they should be applied in; but
+// objects of kinds with the same rank (see rankOfKind) don't depend
+// on one another, so within a rank they're grouped by key first (see
+// groupWithinRanks). A new batch is started whenever the key changes,
+// as well as when a batch is full.
+func (c *Kubectl) makeBatches(objs []applyObject) []applyBatch {
+	var batches []applyBatch
+	for _, obj := range c.groupWithinRanks(objs) {
+		key := c.batchKey(obj)
+		if n := len(batches); n > 0 && batches[n-1].key == key && len(batches[n-1].objs) < c.ApplyBatchSize {
+			batches[n-1].objs = append(batches[n-1].objs, obj)
+			continue
+		}
+		batches = append(batches, applyBatch{key: key, objs: []applyObject{obj}})
+	}
+	return batches
+}
+
+// groupWithinRanks reorders the objects given so that, within each
+// run of objects with kinds of the same rank, those with the same key
+// come together, keys coming in the order they first appear. The
+// runs stay in the order given; and so do objects from the same file,
+// as with sortForApply.
+func (c *Kubectl) groupWithinRanks(objs []applyObject) []applyObject {
+	grouped := make([]applyObject, 0, len(objs))
+	for start := 0; start < len(objs); {
+		_, kind, _ := objs[start].ResourceID.Components()
+		rank := rankOfKind(kind)
+		var keys []string
+		byKey := map[string][]applyObject{}
+		end := start
+		for ; end < len(objs); end++ {
+			if _, kind, _ := objs[end].ResourceID.Components(); rankOfKind(kind) != rank {
+				break
+			}
+			key := c.batchKey(objs[end])
+			if _, ok := byKey[key]; !ok {
+				keys = append(keys, key)
+			}
+			byKey[key] = append(byKey[key], objs[end])
+		}
+		for _, key := range keys {
+			grouped = append(grouped, byKey[key]...)
+		}
+		start = end
+	}
+	keepFileOrder(grouped)
+	return grouped
+}
+
+// applyInBatches applies the objects given with the function given,
+// in batches (see makeBatches) with a pause of ApplyBatchDelay
+// between each, so that controllers which react to each resource
+// aren't given too many at once. If ApplyBatchSize is zero, the
+// objects are applied all together.
+func (c *Kubectl) applyInBatches(logger log.Logger, objs []applyObject, apply func([]applyObject) cluster.SyncError) cluster.SyncError {
+	if c.ApplyBatchSize <= 0 || len(objs) == 0 {
+		return apply(objs)
+	}
+	batches := c.makeBatches(objs)
+	var errs cluster.SyncError
+	for i, batch := range batches {
+		if i > 0 && c.ApplyBatchDelay > 0 {
+			c.sleep(c.ApplyBatchDelay)
+		}
+		logger.Log("info", "applying batch", "batch", i+1, "of", len(batches), c.batchBy(), batch.key, "count", len(batch.objs))
+		errs = append(errs, apply(batch.objs)...)
+	}
+	return errs
+}
+
+func (c *Kubectl) batchBy() string {
+	if c.ApplyBatchBy == ApplyBatchByKind {
+		return ApplyBatchByKind
+	}
+	return ApplyBatchByGroup
+}
@@ -0,0 +1,70 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/rest"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster"
+)
+
+func batchObject(apiVersion, kind, name string) applyObject {
+	return applyObject{
+		ResourceID: flux.MakeResourceID("test", kind, name),
+		Payload:    []byte("apiVersion: " + apiVersion + "\nkind: " + kind + "\nmetadata:\n  name: " + name + "\n"),
+	}
+}
+
+func TestApplyInBatches(t *testing.T) {
+	objs := []applyObject{
+		batchObject("v1", "ConfigMap", "a"),
+		batchObject("v1", "Service", "b"),
+		batchObject("cert-manager.io/v1", "Certificate", "c"),
+		batchObject("cert-manager.io/v1", "Certificate", "d"),
+		batchObject("cert-manager.io/v1", "Issuer", "e"),
+		batchObject("apps/v1", "Deployment", "f"),
+	}
+	kubectl := NewKubectl("kubectl", &rest.Config{})
+	kubectl.ApplyBatchSize = 2
+
+	var batches [][]string
+	apply := func(objs []applyObject) cluster.SyncError {
+		var names []string
+		for _, obj := range objs {
+			_, _, name := obj.ResourceID.Components()
+			names = append(names, name)
+		}
+		batches = append(batches, names)
+		return nil
+	}
+	kubectl.applyInBatches(log.NewNopLogger(), objs, apply)
+	assert.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}, {"f"}}, batches)
+
+	batches = nil
+	kubectl.ApplyBatchBy = ApplyBatchByKind
+	kubectl.applyInBatches(log.NewNopLogger(), objs, apply)
+	assert.Equal(t, [][]string{{"a"}, {"b"}, {"c", "d"}, {"e"}, {"f"}}, batches)
+
+	batches = nil
+	kubectl.ApplyBatchSize = 0
+	kubectl.applyInBatches(log.NewNopLogger(), objs, apply)
+	assert.Equal(t, [][]string{{"a", "b", "c", "d", "e", "f"}}, batches)
+
+	// kinds of the same rank are grouped, whatever order they're in,
+	// but not moved past kinds of another rank
+	objs = []applyObject{
+		batchObject("v1", "ConfigMap", "a"),
+		batchObject("cert-manager.io/v1", "Certificate", "b"),
+		batchObject("example.com/v1", "Widget", "c"),
+		batchObject("cert-manager.io/v1", "Certificate", "d"),
+		batchObject("v1", "Service", "e"),
+	}
+	batches = nil
+	kubectl.ApplyBatchSize = 2
+	kubectl.ApplyBatchBy = ApplyBatchByGroup
+	kubectl.applyInBatches(log.NewNopLogger(), objs, apply)
+	assert.Equal(t, [][]string{{"a"}, {"b", "d"}, {"c"}, {"e"}}, batches)
+}
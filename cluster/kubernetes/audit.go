@@ -0,0 +1,113 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	apiv1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/audit"
+	kresource "github.com/weaveworks/flux/cluster/kubernetes/resource"
+)
+
+const EventReasonAudit = "Audit"
+
+// AuditEvents is an audit.Sink that emits a Kubernetes Event for each
+// record, on each workload in the record. Records not about
+// particular workloads are emitted in Namespace (usually the one
+// fluxd runs in). Unlike SyncEvents, these are never rate limited or
+// deduplicated, since each is an audit record.
+type AuditEvents struct {
+	EventsAPI v1.EventsGetter
+	Namespace string
+}
+
+func (e *AuditEvents) Record(_ context.Context, r audit.Record) error {
+	message := fmt.Sprintf("%s %s by %q", r.Action, r.Outcome, r.Actor)
+	if r.Spec != "" {
+		message += ": " + r.Spec
+	}
+	if r.Revision != "" {
+		message += fmt.Sprintf(" (revision %s)", r.Revision)
+	}
+	if r.Message != "" {
+		message += fmt.Sprintf("; message: %q", r.Message)
+	}
+	if r.Error != "" {
+		message += "; error: " + r.Error
+	}
+	eventType := apiv1.EventTypeNormal
+	if r.Outcome == audit.OutcomeFailed {
+		eventType = apiv1.EventTypeWarning
+	}
+
+	if len(r.Workloads) == 0 {
+		return e.emit(eventType, message, r.Time, apiv1.ObjectReference{
+			Kind:      "Namespace",
+			Name:      e.Namespace,
+			Namespace: e.Namespace,
+		})
+	}
+	for _, id := range r.Workloads {
+		if err := e.emit(eventType, message, r.Time, workloadReference(id)); err != nil {
+			return errors.Wrapf(err, "emitting audit event for %s", id)
+		}
+	}
+	return nil
+}
+
+func (e *AuditEvents) emit(eventType, message string, at time.Time, obj apiv1.ObjectReference) error {
+	namespace := obj.Namespace
+	if namespace == "" {
+		namespace = meta_v1.NamespaceDefault
+	}
+	ts := meta_v1.NewTime(at)
+	event := &apiv1.Event{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", obj.Name, time.Now().UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: obj,
+		Reason:         EventReasonAudit,
+		Message:        message,
+		Type:           eventType,
+		Source:         apiv1.EventSource{Component: eventComponent},
+		FirstTimestamp: ts,
+		LastTimestamp:  ts,
+		Count:          1,
+	}
+	_, err := e.EventsAPI.Events(namespace).Create(event)
+	return err
+}
+
+// workloadReference refers to the workload given. The kind in a
+// resource ID is lower case, so is given its usual form where it's
+// one of the workload kinds we know.
+func workloadReference(id flux.ResourceID) apiv1.ObjectReference {
+	ns, kind, name := id.Components()
+	if ns == kresource.ClusterScope {
+		ns = ""
+	}
+	if k, ok := workloadKinds[kind]; ok {
+		kind = k
+	}
+	return apiv1.ObjectReference{
+		Kind:      kind,
+		Namespace: ns,
+		Name:      name,
+	}
+}
+
+var workloadKinds = map[string]string{
+	"deployment":      "Deployment",
+	"daemonset":       "DaemonSet",
+	"statefulset":     "StatefulSet",
+	"cronjob":         "CronJob",
+	"fluxhelmrelease": "FluxHelmRelease",
+	"helmrelease":     "HelmRelease",
+}
@@ -0,0 +1,106 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// DefaultBatchIgnoreFields are the fields of Jobs and CronJobs that
+// are left out when deciding whether a manifest differs from what's
+// in the cluster, since they are filled in or changed by the
+// controllers.
+var DefaultBatchIgnoreFields = []string{
+	"status",
+	"spec.selector",
+	"spec.template.metadata.labels",
+}
+
+// isBatchKind says whether resources of the kind given run to
+// completion, and are therefore treated specially when syncing.
+func isBatchKind(kind string) bool {
+	switch strings.ToLower(kind) {
+	case "job", "cronjob":
+		return true
+	}
+	return false
+}
+
+// batchResourceUnchanged reports whether applying the manifest to the
+// cluster resource given would change nothing, other than fields in
+// ignoreFields (given as dot-separated paths). Fields that appear in
+// the cluster resource but not in the manifest -- e.g., those
+// defaulted by the API server -- don't count as changes. This lets us
+// avoid re-applying Jobs that have already run, since they are
+// mutated by the job controller, and can't be updated in most
+// respects anyway.
+func batchResourceUnchanged(manifest []byte, cres *kuberesource, ignoreFields []string) bool {
+	var desired map[string]interface{}
+	if err := yaml.Unmarshal(manifest, &desired); err != nil {
+		return false
+	}
+	for _, field := range ignoreFields {
+		deleteField(desired, strings.Split(field, "."))
+	}
+	return isSubset(desired, cres.obj.Object)
+}
+
+func deleteField(obj map[string]interface{}, path []string) {
+	if len(path) == 0 {
+		return
+	}
+	if len(path) == 1 {
+		delete(obj, path[0])
+		return
+	}
+	if child, ok := obj[path[0]].(map[string]interface{}); ok {
+		deleteField(child, path[1:])
+	}
+}
+
+// isSubset reports whether everything in `desired` is also in
+// `actual`. Lists must have the same number of elements, each a
+// subset of its counterpart.
+func isSubset(desired, actual interface{}) bool {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, v := range d {
+			av, ok := a[k]
+			if !ok {
+				// a field explicitly given as null is the same as it
+				// being absent
+				if v == nil {
+					continue
+				}
+				return false
+			}
+			if !isSubset(v, av) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok || len(a) != len(d) {
+			return false
+		}
+		for i := range d {
+			if !isSubset(d[i], a[i]) {
+				return false
+			}
+		}
+		return true
+	case nil:
+		return actual == nil
+	default:
+		// Numbers may be decoded as different types from the
+		// manifest and from the API, so compare scalars by their
+		// printed form.
+		return fmt.Sprint(desired) == fmt.Sprint(actual)
+	}
+}
@@ -0,0 +1,151 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const jobManifest = `---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  namespace: default
+spec:
+  backoffLimit: 4
+  template:
+    metadata:
+      labels:
+        app: migrate
+    spec:
+      restartPolicy: Never
+      containers:
+      - name: migrate
+        image: quay.io/weaveworks/migrate:1.0
+`
+
+// completedJob is what jobManifest looks like in the cluster, after
+// the job has run to completion.
+const completedJob = `---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  namespace: default
+  uid: 6c5c9150-a0f4-11e8-a1e3-0800279b0a8c
+  resourceVersion: "4321"
+spec:
+  backoffLimit: 4
+  completions: 1
+  parallelism: 1
+  selector:
+    matchLabels:
+      controller-uid: 6c5c9150-a0f4-11e8-a1e3-0800279b0a8c
+  template:
+    metadata:
+      labels:
+        app: migrate
+        controller-uid: 6c5c9150-a0f4-11e8-a1e3-0800279b0a8c
+        job-name: migrate
+    spec:
+      restartPolicy: Never
+      terminationGracePeriodSeconds: 30
+      containers:
+      - name: migrate
+        image: quay.io/weaveworks/migrate:1.0
+        imagePullPolicy: IfNotPresent
+status:
+  completionTime: 2018-08-15T14:52:11Z
+  conditions:
+  - type: Complete
+    status: "True"
+  startTime: 2018-08-15T14:51:56Z
+  succeeded: 1
+`
+
+func clusterJob(t *testing.T, def string) *kuberesource {
+	var obj map[string]interface{}
+	if err := yaml.Unmarshal([]byte(def), &obj); err != nil {
+		t.Fatal(err)
+	}
+	return &kuberesource{obj: &unstructured.Unstructured{Object: obj}, namespaced: true}
+}
+
+func TestIsBatchKind(t *testing.T) {
+	for kind, expected := range map[string]bool{
+		"Job":        true,
+		"CronJob":    true,
+		"cronjob":    true,
+		"Deployment": false,
+		"Pod":        false,
+	} {
+		if isBatchKind(kind) != expected {
+			t.Errorf("expected isBatchKind(%q) to be %v", kind, expected)
+		}
+	}
+}
+
+func TestBatchResourceUnchanged_CompletedJob(t *testing.T) {
+	cres := clusterJob(t, completedJob)
+	if !batchResourceUnchanged([]byte(jobManifest), cres, DefaultBatchIgnoreFields) {
+		t.Error("expected completed job to be considered unchanged")
+	}
+}
+
+func TestBatchResourceUnchanged_CompletedJobChanged(t *testing.T) {
+	cres := clusterJob(t, completedJob)
+	changed := []byte(`---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  namespace: default
+spec:
+  backoffLimit: 4
+  template:
+    spec:
+      restartPolicy: Never
+      containers:
+      - name: migrate
+        image: quay.io/weaveworks/migrate:1.1
+`)
+	if batchResourceUnchanged(changed, cres, DefaultBatchIgnoreFields) {
+		t.Error("expected job with changed image to be considered changed")
+	}
+}
+
+func TestBatchResourceUnchanged_IgnoreFields(t *testing.T) {
+	cres := clusterJob(t, completedJob)
+	// The label has been changed in the cluster, e.g., by a
+	// controller; it only counts if it isn't ignored.
+	cres.obj.Object["spec"].(map[string]interface{})["template"].(map[string]interface{})["metadata"] = map[string]interface{}{
+		"labels": map[string]interface{}{"app": "something-else"},
+	}
+	if !batchResourceUnchanged([]byte(jobManifest), cres, DefaultBatchIgnoreFields) {
+		t.Error("expected job to be considered unchanged when differing field is ignored")
+	}
+	if batchResourceUnchanged([]byte(jobManifest), cres, []string{"status"}) {
+		t.Error("expected job to be considered changed when differing field is not ignored")
+	}
+}
+
+func TestBatchResourceUnchanged_NewAnnotation(t *testing.T) {
+	cres := clusterJob(t, completedJob)
+	// e.g., a new checksum annotation, because the manifest changed
+	withAnnotation := []byte(`---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  namespace: default
+  annotations:
+    flux.weave.works/sync-checksum: abc123
+spec:
+  backoffLimit: 4
+`)
+	if batchResourceUnchanged(withAnnotation, cres, DefaultBatchIgnoreFields) {
+		t.Error("expected job with new annotation to be considered changed")
+	}
+}
@@ -0,0 +1,108 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/weaveworks/flux"
+)
+
+// DefaultBootstrapKinds are the kinds of resource applied first when
+// bootstrapping a fresh cluster, in this order, since most other
+// resources depend on them being there.
+var DefaultBootstrapKinds = []string{
+	"namespace",
+	"customresourcedefinition",
+	"serviceaccount",
+	"clusterrole",
+	"role",
+	"clusterrolebinding",
+	"rolebinding",
+}
+
+const defaultBootstrapTimeout = 15 * time.Minute
+
+// bootstrapWaves takes the resources of the kinds given out of the
+// waves given, and puts them in waves of their own in front of the
+// rest, one for each kind in the order given; so each kind is applied
+// and ready (e.g., each CRD is established) before anything that may
+// depend on it. This overrides the wave annotations of those
+// resources. Every wave is marked as part of the bootstrap, so it's
+// waited on for at least the bootstrap timeout.
+func bootstrapWaves(waves []wave, kinds []string) []wave {
+	if len(waves) == 0 {
+		return waves
+	}
+	first := waves[0].number
+	byKind := map[string]*wave{}
+	var leading []*wave
+	for _, kind := range kinds {
+		kind = strings.ToLower(kind)
+		if _, ok := byKind[kind]; ok {
+			continue
+		}
+		w := &wave{number: first, bootstrapKind: kind, bootstrap: true, timeouts: map[flux.ResourceID]time.Duration{}}
+		byKind[kind] = w
+		leading = append(leading, w)
+	}
+
+	var rest []wave
+	for _, w := range waves {
+		remaining := wave{number: w.number, bootstrap: true, timeouts: w.timeouts}
+		for _, obj := range w.objs {
+			_, kind, _ := obj.ResourceID.Components()
+			if b, ok := byKind[kind]; ok {
+				b.objs = append(b.objs, obj)
+				if timeout, ok := w.timeouts[obj.ResourceID]; ok {
+					b.timeouts[obj.ResourceID] = timeout
+				}
+				continue
+			}
+			remaining.objs = append(remaining.objs, obj)
+		}
+		if len(remaining.objs) > 0 {
+			rest = append(rest, remaining)
+		}
+	}
+
+	var result []wave
+	for _, w := range leading {
+		if len(w.objs) > 0 {
+			result = append(result, *w)
+		}
+	}
+	return append(result, rest...)
+}
+
+// bootstrapKinds gives the kinds of resource to apply first in a
+// bootstrap sync.
+func (c *Kubectl) bootstrapKinds() []string {
+	if len(c.BootstrapKinds) == 0 {
+		return DefaultBootstrapKinds
+	}
+	return c.BootstrapKinds
+}
+
+func (w wave) String() string {
+	if w.bootstrapKind != "" {
+		return fmt.Sprintf("bootstrap wave (%s)", w.bootstrapKind)
+	}
+	return fmt.Sprintf("wave %d", w.number)
+}
+
+// bootstrapTimeout gives the timeout given, or the bootstrap timeout
+// if the wave is part of a bootstrap and that's longer.
+func (c *Kubectl) bootstrapTimeout(w wave, timeout time.Duration) time.Duration {
+	if !w.bootstrap {
+		return timeout
+	}
+	least := c.BootstrapTimeout
+	if least == 0 {
+		least = defaultBootstrapTimeout
+	}
+	if timeout < least {
+		return least
+	}
+	return timeout
+}
@@ -0,0 +1,171 @@
+package kubernetes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster"
+	kresource "github.com/weaveworks/flux/cluster/kubernetes/resource"
+	"github.com/weaveworks/flux/resource"
+)
+
+// Workloads with the config_hash policy get this annotation in their
+// pod template, with a hash of the ConfigMaps and Secrets they refer
+// to; so when any of those changes, the pod template changes, and the
+// workload is rolled out.
+const configHashAnnotation = kresource.PolicyPrefix + "config-hash"
+
+// configHashes gives a hash of each ConfigMap and Secret in the
+// sync set, by resource ID.
+func configHashes(syncSet cluster.SyncSet) map[flux.ResourceID]string {
+	hashes := map[flux.ResourceID]string{}
+	for _, res := range syncSet.Resources {
+		id := res.ResourceID()
+		if _, kind, _ := id.Components(); kind == "configmap" || kind == "secret" {
+			sum := sha256.Sum256(res.Bytes())
+			hashes[id] = hex.EncodeToString(sum[:])
+		}
+	}
+	return hashes
+}
+
+// podTemplatePath gives the path to the pod template in workloads of
+// the kind given, or nil if it doesn't have one that can be changed.
+// Jobs are left out, since their pod template can't be updated.
+func podTemplatePath(kind string) []string {
+	switch kind {
+	case "deployment", "daemonset", "statefulset":
+		return []string{"spec", "template"}
+	case "cronjob":
+		return []string{"spec", "jobTemplate", "spec", "template"}
+	}
+	return nil
+}
+
+// configRefs gives the ConfigMaps and Secrets referred to in a pod
+// spec, by volumes, envFrom, or env; in the namespace given, since
+// they can only be referred to from the same namespace.
+func configRefs(namespace string, podSpec map[interface{}]interface{}) flux.ResourceIDSet {
+	refs := flux.ResourceIDSet{}
+	add := func(kind string, name interface{}) {
+		if n, ok := name.(string); ok && n != "" {
+			refs.Add([]flux.ResourceID{flux.MakeResourceID(namespace, kind, n)})
+		}
+	}
+
+	for _, v := range asSlice(podSpec["volumes"]) {
+		volume := asMap(v)
+		add("configmap", asMap(volume["configMap"])["name"])
+		add("secret", asMap(volume["secret"])["secretName"])
+		for _, source := range asSlice(asMap(volume["projected"])["sources"]) {
+			add("configmap", asMap(asMap(source)["configMap"])["name"])
+			add("secret", asMap(asMap(source)["secret"])["name"])
+		}
+	}
+	var containers []interface{}
+	containers = append(containers, asSlice(podSpec["initContainers"])...)
+	containers = append(containers, asSlice(podSpec["containers"])...)
+	for _, c := range containers {
+		container := asMap(c)
+		for _, from := range asSlice(container["envFrom"]) {
+			add("configmap", asMap(asMap(from)["configMapRef"])["name"])
+			add("secret", asMap(asMap(from)["secretRef"])["name"])
+		}
+		for _, env := range asSlice(container["env"]) {
+			valueFrom := asMap(asMap(env)["valueFrom"])
+			add("configmap", asMap(valueFrom["configMapKeyRef"])["name"])
+			add("secret", asMap(valueFrom["secretKeyRef"])["name"])
+		}
+	}
+	return refs
+}
+
+// withConfigHash annotates the pod template of the workload given
+// with a hash of the ConfigMaps and Secrets it refers to that are in
+// the sync set. Referenced configs that aren't in the sync set (e.g.,
+// because they're created some other way) are not included.
+func withConfigHash(res resource.Resource, hashes map[flux.ResourceID]string) ([]byte, error) {
+	namespace, kind, _ := res.ResourceID().Components()
+	path := podTemplatePath(kind)
+	if path == nil {
+		return nil, fmt.Errorf("config_hash is not supported for resources of kind %s", kind)
+	}
+
+	definition := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(res.Bytes(), &definition); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to parse yaml from %s", res.Source()))
+	}
+	template := definition
+	for _, field := range path {
+		template = asMap(template[field])
+		if template == nil {
+			return nil, fmt.Errorf("no pod template at %v", path)
+		}
+	}
+
+	var configs []flux.ResourceID
+	for id := range configRefs(namespace, asMap(template["spec"])) {
+		if _, ok := hashes[id]; ok {
+			configs = append(configs, id)
+		}
+	}
+	if len(configs) == 0 {
+		return res.Bytes(), nil
+	}
+	sort.Slice(configs, func(i, j int) bool {
+		return configs[i].String() < configs[j].String()
+	})
+	hasher := sha256.New()
+	for _, id := range configs {
+		fmt.Fprintf(hasher, "%s=%s\n", id, hashes[id])
+	}
+
+	metadata := asMap(template["metadata"])
+	if metadata == nil {
+		metadata = map[interface{}]interface{}{}
+		template["metadata"] = metadata
+	}
+	annotations := asMap(metadata["annotations"])
+	if annotations == nil {
+		annotations = map[interface{}]interface{}{}
+		metadata["annotations"] = annotations
+	}
+	annotations[configHashAnnotation] = hex.EncodeToString(hasher.Sum(nil))
+
+	bytes, err := yaml.Marshal(definition)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to serialize yaml after adding config hash")
+	}
+	return bytes, nil
+}
+
+// changedConfigNamespaces gives the namespaces in which a ConfigMap or
+// Secret has changed. In an incremental sync, workloads in these
+// namespaces with the config_hash policy are applied even if they
+// haven't changed themselves, in case their hash has; those whose
+// configs haven't changed will come out unchanged.
+func changedConfigNamespaces(changed flux.ResourceIDSet) map[string]bool {
+	namespaces := map[string]bool{}
+	for id := range changed {
+		if ns, kind, _ := id.Components(); kind == "configmap" || kind == "secret" {
+			namespaces[ns] = true
+		}
+	}
+	return namespaces
+}
+
+func asMap(v interface{}) map[interface{}]interface{} {
+	m, _ := v.(map[interface{}]interface{})
+	return m
+}
+
+func asSlice(v interface{}) []interface{} {
+	s, _ := v.([]interface{})
+	return s
+}
@@ -0,0 +1,88 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+
+	"github.com/weaveworks/flux"
+	kresource "github.com/weaveworks/flux/cluster/kubernetes/resource"
+)
+
+func TestWithConfigHash(t *testing.T) {
+	const manifest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: foo
+  annotations:
+    flux.weave.works/config_hash: "true"
+spec:
+  template:
+    spec:
+      volumes:
+      - name: config
+        configMap:
+          name: app-config
+      containers:
+      - name: app
+        image: app:v1
+        envFrom:
+        - secretRef:
+            name: app-secrets
+        env:
+        - name: NOT_IN_GIT
+          valueFrom:
+            configMapKeyRef:
+              name: made-by-hand
+              key: value
+`
+	res, err := kresource.ParseMultidoc([]byte(manifest), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dep := res["foo:deployment/app"]
+
+	hashOf := func(hashes map[flux.ResourceID]string) string {
+		out, err := withConfigHash(dep, hashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var def struct {
+			Spec struct {
+				Template struct {
+					Metadata struct {
+						Annotations map[string]string
+					}
+				}
+			}
+		}
+		if err := yaml.Unmarshal(out, &def); err != nil {
+			t.Fatal(err)
+		}
+		return def.Spec.Template.Metadata.Annotations[configHashAnnotation]
+	}
+
+	configMap := flux.MustParseResourceID("foo:configmap/app-config")
+	secret := flux.MustParseResourceID("foo:secret/app-secrets")
+
+	// Nothing referred to is in git, so there's no hash
+	assert.Equal(t, "", hashOf(map[flux.ResourceID]string{}))
+
+	hashes := map[flux.ResourceID]string{configMap: "a", secret: "b"}
+	first := hashOf(hashes)
+	assert.NotEqual(t, "", first)
+	assert.Equal(t, first, hashOf(hashes))
+
+	// A change to either config changes the hash
+	hashes[secret] = "c"
+	assert.NotEqual(t, first, hashOf(hashes))
+	hashes[secret] = "b"
+	hashes[configMap] = "d"
+	assert.NotEqual(t, first, hashOf(hashes))
+
+	// A config of the same name in another namespace isn't referred to
+	other := map[flux.ResourceID]string{flux.MustParseResourceID("bar:configmap/app-config"): "a"}
+	assert.Equal(t, "", hashOf(other))
+}
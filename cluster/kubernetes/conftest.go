@@ -0,0 +1,160 @@
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux/cluster"
+	"github.com/weaveworks/flux/policy"
+	"github.com/weaveworks/flux/resource"
+)
+
+// ConftestValidator checks manifests against Rego policies before
+// they are applied, using conftest
+// (https://github.com/open-policy-agent/conftest). A resource fails
+// validation if any `deny` (or `violation`) rule matches it; `warn`
+// rules are only logged by conftest, and don't affect the outcome.
+type ConftestValidator struct {
+	// Path to the conftest executable
+	Exe string
+	// Files or directories of policies
+	Policies []string
+	// If non-empty, only the policies in these Rego packages are
+	// checked. Otherwise, policies in any package are.
+	Namespaces []string
+}
+
+// PullBundle downloads a bundle of policies (from any URL that
+// `conftest pull` understands, e.g., an OCI registry or git repo)
+// into the directory given, and checks those policies along with
+// any others.
+func (v *ConftestValidator) PullBundle(url, dir string) error {
+	cmd := exec.Command(v.Exe, "pull", "--policy", dir, url)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Errorf("pulling policy bundle %s: %s", url, strings.TrimSpace(stderr.String()))
+	}
+	v.Policies = append(v.Policies, dir)
+	return nil
+}
+
+// Validate checks the resources given against the policies, and
+// returns a cluster.SyncError giving the resources that were denied,
+// along with the policies that denied them, if any were. Resources
+// that are ignored by flux aren't checked, since they won't be
+// applied.
+func (v *ConftestValidator) Validate(resources map[string]resource.Resource) error {
+	dir, err := ioutil.TempDir("", "flux-conftest")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]resource.Resource{}
+	var paths []string
+	for _, res := range resources {
+		if res.Policies().Has(policy.Ignore) {
+			continue
+		}
+		path := filepath.Join(dir, fmt.Sprintf("%d.yaml", len(paths)))
+		if err := ioutil.WriteFile(path, res.Bytes(), 0600); err != nil {
+			return err
+		}
+		files[path] = res
+		paths = append(paths, path)
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	args := []string{"test", "--no-color", "--output", "json"}
+	for _, p := range v.Policies {
+		args = append(args, "--policy", p)
+	}
+	if len(v.Namespaces) == 0 {
+		args = append(args, "--all-namespaces")
+	}
+	for _, ns := range v.Namespaces {
+		args = append(args, "--namespace", ns)
+	}
+	cmd := exec.Command(v.Exe, append(args, paths...)...)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	// conftest exits non-zero when there are failures, so only treat
+	// that as an error if there's no report to go with it.
+	runErr := cmd.Run()
+	syncErr, err := parseConftestOutput(stdout.Bytes(), files)
+	if err != nil {
+		if runErr != nil {
+			msg := strings.TrimSpace(stderr.String())
+			if msg == "" {
+				msg = runErr.Error()
+			}
+			return errors.Errorf("running conftest: %s", msg)
+		}
+		return errors.Wrap(err, "parsing conftest output")
+	}
+	if len(syncErr) > 0 {
+		return syncErr
+	}
+	return nil
+}
+
+type conftestResult struct {
+	Filename string               `json:"filename"`
+	Failures []conftestResultItem `json:"failures"`
+}
+
+type conftestResultItem struct {
+	Msg      string `json:"msg"`
+	Metadata struct {
+		Query string `json:"query"`
+	} `json:"metadata"`
+}
+
+// parseConftestOutput gives an error for each resource (looked up
+// by the name of the file it was written to) that has failures in
+// the JSON output from conftest.
+func parseConftestOutput(output []byte, files map[string]resource.Resource) (cluster.SyncError, error) {
+	var results []conftestResult
+	if err := json.Unmarshal(output, &results); err != nil {
+		return nil, err
+	}
+	var errs cluster.SyncError
+	for _, result := range results {
+		if len(result.Failures) == 0 {
+			continue
+		}
+		res, ok := files[result.Filename]
+		if !ok {
+			continue
+		}
+		var denials []string
+		for _, f := range result.Failures {
+			if f.Metadata.Query != "" {
+				denials = append(denials, fmt.Sprintf("%s (%s)", f.Msg, f.Metadata.Query))
+			} else {
+				denials = append(denials, f.Msg)
+			}
+		}
+		errs = append(errs, cluster.ResourceError{
+			ResourceID: res.ResourceID(),
+			Source:     res.Source(),
+			Error:      errors.New("denied by policy: " + strings.Join(denials, "; ")),
+		})
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].ResourceID.String() < errs[j].ResourceID.String()
+	})
+	return errs, nil
+}
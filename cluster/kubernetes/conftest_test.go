@@ -0,0 +1,59 @@
+package kubernetes
+
+import (
+	"strings"
+	"testing"
+
+	kresource "github.com/weaveworks/flux/cluster/kubernetes/resource"
+	"github.com/weaveworks/flux/resource"
+)
+
+func TestParseConftestOutput(t *testing.T) {
+	const defs = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: ok
+  namespace: foo
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: privileged
+  namespace: foo
+`
+	manifests, err := kresource.ParseMultidoc([]byte(defs), "deployments.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]resource.Resource{
+		"/tmp/0.yaml": manifests["foo:deployment/ok"],
+		"/tmp/1.yaml": manifests["foo:deployment/privileged"],
+	}
+
+	const output = `[
+  {"filename": "/tmp/0.yaml", "namespace": "main", "successes": 2},
+  {"filename": "/tmp/1.yaml", "namespace": "main", "successes": 0, "failures": [
+    {"msg": "privileged must not run privileged containers", "metadata": {"query": "data.main.deny"}},
+    {"msg": "privileged must have an owner label"}
+  ]}
+]`
+	errs, err := parseConftestOutput([]byte(output), files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expected one resource to be denied, got %v", errs)
+	}
+	if errs[0].ResourceID.String() != "foo:deployment/privileged" || errs[0].Source != "deployments.yaml" {
+		t.Errorf("unexpected resource denied: %s (%s)", errs[0].ResourceID, errs[0].Source)
+	}
+	msg := errs[0].Error.Error()
+	if !strings.Contains(msg, "(data.main.deny)") || !strings.Contains(msg, "owner label") {
+		t.Errorf("expected both denials to be reported, got %q", msg)
+	}
+
+	if _, err := parseConftestOutput([]byte("not json"), files); err == nil {
+		t.Error("expected error for unparseable output")
+	}
+}
@@ -0,0 +1,134 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"gopkg.in/yaml.v2"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster"
+)
+
+// How long to wait for CRDs to be established before applying the
+// custom resources they define, if Kubectl.CRDEstablishTimeout is
+// zero.
+const defaultCRDEstablishTimeout = time.Minute
+
+// crdFields are the fields of a CustomResourceDefinition needed to
+// tell which resources it defines.
+type crdFields struct {
+	Spec struct {
+		Group string `yaml:"group"`
+		Names struct {
+			Kind string `yaml:"kind"`
+		} `yaml:"names"`
+	} `yaml:"spec"`
+}
+
+// splitCRDs picks out, from the objects given, the CRDs, and the
+// custom resources of the kinds those CRDs define; the rest are
+// everything else. Each keeps the order it was given in. The map
+// returned gives, for each of the custom resources, the CRD that
+// defines it.
+func splitCRDs(objs []applyObject) (crds, defined, rest []applyObject, definedBy map[flux.ResourceID]applyObject) {
+	byGroupKind := map[string]applyObject{}
+	for _, obj := range objs {
+		if _, kind, _ := obj.ResourceID.Components(); kind != "customresourcedefinition" {
+			continue
+		}
+		var crd crdFields
+		if err := yaml.Unmarshal(obj.Payload, &crd); err == nil && crd.Spec.Names.Kind != "" {
+			byGroupKind[crd.Spec.Group+"/"+strings.ToLower(crd.Spec.Names.Kind)] = obj
+		}
+	}
+
+	definedBy = map[flux.ResourceID]applyObject{}
+	for _, obj := range objs {
+		_, kind, _ := obj.ResourceID.Components()
+		if kind == "customresourcedefinition" {
+			crds = append(crds, obj)
+			continue
+		}
+		if len(byGroupKind) > 0 {
+			var manifest struct {
+				APIVersion string `yaml:"apiVersion"`
+			}
+			if err := yaml.Unmarshal(obj.Payload, &manifest); err == nil {
+				group := strings.SplitN(manifest.APIVersion, "/", 2)[0]
+				if crd, ok := byGroupKind[group+"/"+kind]; ok {
+					defined = append(defined, obj)
+					definedBy[obj.ResourceID] = crd
+					continue
+				}
+			}
+		}
+		rest = append(rest, obj)
+	}
+	return crds, defined, rest, definedBy
+}
+
+// applyCRDsFirst applies the objects in the wave given with the
+// function given. If the wave includes both CRDs and custom
+// resources of the kinds they define, the custom resources would
+// fail to apply until the CRDs are established; so the other objects
+// are applied first, then the custom resources once their CRDs are
+// established. A custom resource whose CRD isn't established within
+// CRDEstablishTimeout is not applied, and reported as an error.
+func (c *Kubectl) applyCRDsFirst(logger log.Logger, w wave, apply func([]applyObject) cluster.SyncError) cluster.SyncError {
+	crds, defined, rest, definedBy := splitCRDs(w.objs)
+	if len(defined) == 0 {
+		return apply(w.objs)
+	}
+
+	first := make([]applyObject, 0, len(crds)+len(rest))
+	first = append(append(first, crds...), rest...)
+	errs := apply(first)
+
+	failed := map[flux.ResourceID]bool{}
+	for _, e := range errs {
+		failed[e.ResourceID] = true
+	}
+	timeout := c.CRDEstablishTimeout
+	if timeout == 0 {
+		timeout = defaultCRDEstablishTimeout
+	}
+	timeout = c.bootstrapTimeout(w, timeout)
+	// Waiting uses the readiness timeout for each object, so give
+	// each CRD the establish timeout.
+	crdWave := wave{number: w.number, timeouts: map[flux.ResourceID]time.Duration{}}
+	var waitFor []applyObject
+	for _, crd := range crds {
+		// A CRD that failed to apply may have been established
+		// before, so its custom resources are applied regardless.
+		if !failed[crd.ResourceID] {
+			waitFor = append(waitFor, crd)
+			crdWave.timeouts[crd.ResourceID] = timeout
+		}
+	}
+	begin := time.Now()
+	timedOut, err := c.waitForReady(crdWave, waitFor)
+	logger.Log("info", "waited for CRDs to be established", "wave", w, "count", len(waitFor), "timed_out", len(timedOut), "took", time.Since(begin), "err", err)
+
+	notEstablished := map[flux.ResourceID]error{}
+	for _, t := range timedOut {
+		notEstablished[t.obj.ResourceID] = fmt.Errorf("not applied, since %s after %s", t.reason, t.timeout)
+	}
+	if err != nil {
+		for _, crd := range waitFor {
+			notEstablished[crd.ResourceID] = fmt.Errorf("not applied, since %s could not be checked for being established: %s", crd.ResourceID, err)
+		}
+	}
+
+	var ready []applyObject
+	for _, obj := range defined {
+		if crdErr, ok := notEstablished[definedBy[obj.ResourceID].ResourceID]; ok {
+			errs = append(errs, cluster.ResourceError{ResourceID: obj.ResourceID, Source: obj.Source, Error: crdErr})
+			continue
+		}
+		ready = append(ready, obj)
+	}
+	return append(errs, apply(ready)...)
+}
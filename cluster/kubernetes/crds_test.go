@@ -0,0 +1,99 @@
+package kubernetes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/rest"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster"
+)
+
+func crdObjects() []applyObject {
+	return []applyObject{
+		{
+			ResourceID: flux.MakeResourceID("test", "Widget", "w"),
+			Payload:    []byte("apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: w\n"),
+		},
+		{
+			ResourceID: flux.MakeResourceID("", "CustomResourceDefinition", "widgets.example.com"),
+			Payload:    []byte("apiVersion: apiextensions.k8s.io/v1beta1\nkind: CustomResourceDefinition\nmetadata:\n  name: widgets.example.com\nspec:\n  group: example.com\n  names:\n    kind: Widget\n"),
+		},
+		{
+			ResourceID: flux.MakeResourceID("test", "Gadget", "g"),
+			Payload:    []byte("apiVersion: other.com/v1\nkind: Gadget\nmetadata:\n  name: g\n"),
+		},
+		waveObject("app", ""),
+	}
+}
+
+func objectNames(objs []applyObject) []string {
+	var names []string
+	for _, obj := range objs {
+		_, _, name := obj.ResourceID.Components()
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestSplitCRDs(t *testing.T) {
+	crds, defined, rest, definedBy := splitCRDs(crdObjects())
+	assert.Equal(t, []string{"widgets.example.com"}, objectNames(crds))
+	assert.Equal(t, []string{"w"}, objectNames(defined))
+	assert.Equal(t, []string{"g", "app"}, objectNames(rest))
+	assert.Equal(t, crds[0].ResourceID, definedBy[defined[0].ResourceID].ResourceID)
+}
+
+func fakeKubectlGet(t *testing.T, output string) (string, func()) {
+	dir, err := ioutil.TempDir("", "flux-test-kubectl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "kubectl")
+	script := "#!/bin/sh\ncat >/dev/null\necho '" + output + "'\n"
+	if err := ioutil.WriteFile(exe, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return exe, func() { os.RemoveAll(dir) }
+}
+
+func TestApplyCRDsFirst(t *testing.T) {
+	exe, cleanup := fakeKubectlGet(t, `{"kind": "CustomResourceDefinition", "metadata": {"name": "widgets.example.com"}, "status": {"conditions": [{"type": "Established", "status": "True"}]}}`)
+	defer cleanup()
+	kubectl := NewKubectl(exe, &rest.Config{})
+	kubectl.wavePollInterval = time.Millisecond
+
+	var applied [][]string
+	errs := kubectl.applyCRDsFirst(log.NewNopLogger(), wave{objs: crdObjects()}, func(objs []applyObject) cluster.SyncError {
+		applied = append(applied, objectNames(objs))
+		return nil
+	})
+	assert.Len(t, errs, 0)
+	assert.Equal(t, [][]string{{"widgets.example.com", "g", "app"}, {"w"}}, applied)
+}
+
+func TestApplyCRDsFirstTimeout(t *testing.T) {
+	exe, cleanup := fakeKubectlGet(t, `{"kind": "CustomResourceDefinition", "metadata": {"name": "widgets.example.com"}, "status": {}}`)
+	defer cleanup()
+	kubectl := NewKubectl(exe, &rest.Config{})
+	kubectl.CRDEstablishTimeout = 10 * time.Millisecond
+	kubectl.wavePollInterval = time.Millisecond
+
+	var applied []string
+	errs := kubectl.applyCRDsFirst(log.NewNopLogger(), wave{objs: crdObjects()}, func(objs []applyObject) cluster.SyncError {
+		applied = append(applied, objectNames(objs)...)
+		return nil
+	})
+	assert.Equal(t, []string{"widgets.example.com", "g", "app"}, applied)
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, "test:widget/w", errs[0].ResourceID.String())
+		assert.True(t, strings.Contains(errs[0].Error.Error(), "is not established"), errs[0].Error.Error())
+	}
+}
@@ -0,0 +1,117 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	"github.com/pkg/errors"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/weaveworks/flux/cluster"
+)
+
+// What to do with a resource that has been terminating for longer
+// than the stuck deletion timeout.
+const (
+	// Report it as failing to sync, until it goes away
+	StuckDeletionWait = "wait"
+	// Log it, and carry on as if it had gone away
+	StuckDeletionSkip = "skip"
+)
+
+var (
+	stuckDeletions = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "flux",
+		Subsystem: "cluster",
+		Name:      "stuck_deletions",
+		Help:      "Number of resources to be garbage collected that have been terminating for longer than the stuck deletion timeout, as of the last sync.",
+	}, []string{})
+)
+
+// DefaultStuckDeletionTimeout is how long a resource may be
+// terminating before it's considered stuck, if not configured.
+const DefaultStuckDeletionTimeout = 5 * time.Minute
+
+// terminatingSince gives the time at which the resource was marked
+// for deletion, and whether it has been.
+func (r *kuberesource) terminatingSince() (time.Time, bool) {
+	ts := r.obj.GetDeletionTimestamp()
+	if ts == nil {
+		return time.Time{}, false
+	}
+	return ts.Time, true
+}
+
+// handleTerminating deals with a resource garbage collection would
+// delete, but which is already being deleted. Deleting it again
+// would only wait on the same finalizers, so if it hasn't gone away
+// within the timeout it's reported as stuck, then either waited on,
+// skipped, or (only for the kinds given) has its finalizers removed.
+// It returns whether the resource is stuck, and an error if it is to
+// be reported as failing to sync.
+func (c *Cluster) handleTerminating(logger log.Logger, res *kuberesource, since, now time.Time, summary cluster.SyncSummary) (bool, *cluster.ResourceError) {
+	id := res.ResourceID()
+	_, kind, _ := id.Components()
+	timeout := c.StuckDeletionTimeout
+	if timeout == 0 {
+		timeout = DefaultStuckDeletionTimeout
+	}
+	if now.Sub(since) < timeout {
+		logger.Log("debug", "resource is being deleted; waiting for it to go away", "resource", id, "since", since)
+		summary.Add(cluster.SyncSkipped, kind)
+		return false, nil
+	}
+
+	finalizers := res.obj.GetFinalizers()
+	logger.Log("warning", "resource stuck in deletion", "resource", id, "since", since, "finalizers", strings.Join(finalizers, ","))
+
+	if c.removeFinalizersFor(kind) {
+		if err := c.removeFinalizers(res); err != nil {
+			return true, &cluster.ResourceError{
+				ResourceID: id,
+				Source:     "<cluster>",
+				Error:      errors.Wrap(err, "removing finalizers from resource stuck in deletion"),
+			}
+		}
+		logger.Log("warning", "removed finalizers from resource stuck in deletion", "resource", id, "finalizers", strings.Join(finalizers, ","))
+		summary.Add(cluster.SyncDeleted, kind)
+		return true, nil
+	}
+
+	if c.StuckDeletionAction == StuckDeletionSkip {
+		summary.Add(cluster.SyncSkipped, kind)
+		return true, nil
+	}
+	return true, &cluster.ResourceError{
+		ResourceID: id,
+		Source:     "<cluster>",
+		Error:      fmt.Errorf("stuck in deletion since %s, waiting on finalizers [%s]", since.Format(time.RFC3339), strings.Join(finalizers, ", ")),
+	}
+}
+
+func (c *Cluster) removeFinalizersFor(kind string) bool {
+	for _, k := range c.RemoveFinalizersKinds {
+		if strings.EqualFold(k, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeFinalizers clears the finalizers of a resource, so that
+// deletion can complete. This side-steps whatever clean-up the
+// finalizers were guarding, so is only done when asked for.
+func (c *Cluster) removeFinalizers(res *kuberesource) error {
+	patch := []byte(`{"metadata":{"finalizers":null}}`)
+	client := c.client.dynamicClient.Resource(res.gvr)
+	if res.namespaced {
+		_, err := client.Namespace(res.obj.GetNamespace()).Patch(res.obj.GetName(), types.MergePatchType, patch)
+		return err
+	}
+	_, err := client.Patch(res.obj.GetName(), types.MergePatchType, patch)
+	return err
+}
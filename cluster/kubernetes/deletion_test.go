@@ -0,0 +1,68 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/weaveworks/flux/cluster"
+)
+
+func TestHandleTerminating(t *testing.T) {
+	now := time.Now()
+	terminating := func(since time.Time) *kuberesource {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":       "cm",
+				"namespace":  "foo",
+				"finalizers": []interface{}{"example.com/cleanup"},
+			},
+		}}
+		ts := metav1.NewTime(since)
+		obj.SetDeletionTimestamp(&ts)
+		return &kuberesource{obj: obj, namespaced: true}
+	}
+
+	c := &Cluster{logger: log.NewNopLogger(), StuckDeletionTimeout: time.Minute}
+
+	res := terminating(now.Add(-30 * time.Second))
+	since, ok := res.terminatingSince()
+	assert.True(t, ok)
+	summary := cluster.SyncSummary{}
+	stuck, err := c.handleTerminating(log.NewNopLogger(), res, since, now, summary)
+	assert.False(t, stuck)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, summary[cluster.SyncSkipped]["configmap"])
+
+	res = terminating(now.Add(-2 * time.Minute))
+	since, _ = res.terminatingSince()
+	stuck, err = c.handleTerminating(log.NewNopLogger(), res, since, now, cluster.SyncSummary{})
+	assert.True(t, stuck)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error.Error(), "example.com/cleanup")
+	}
+
+	c.StuckDeletionAction = StuckDeletionSkip
+	summary = cluster.SyncSummary{}
+	stuck, err = c.handleTerminating(log.NewNopLogger(), res, since, now, summary)
+	assert.True(t, stuck)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, summary[cluster.SyncSkipped]["configmap"])
+
+	notTerminating := &kuberesource{obj: &unstructured.Unstructured{Object: map[string]interface{}{}}}
+	_, ok = notTerminating.terminatingSince()
+	assert.False(t, ok)
+}
+
+func TestRemoveFinalizersFor(t *testing.T) {
+	c := &Cluster{RemoveFinalizersKinds: []string{"ConfigMap", "secret"}}
+	assert.True(t, c.removeFinalizersFor("configmap"))
+	assert.True(t, c.removeFinalizersFor("secret"))
+	assert.False(t, c.removeFinalizersFor("deployment"))
+}
@@ -0,0 +1,86 @@
+package kubernetes
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster"
+)
+
+// DisruptionBudgets reports the PodDisruptionBudgets covering the
+// pods of the workloads given, that is, those with a selector
+// matching the labels in a workload's pod template. Workloads that
+// can't be found, or aren't allowed, are left out.
+func (c *Cluster) DisruptionBudgets(ids []flux.ResourceID) ([]cluster.DisruptionBudget, error) {
+	budgets := map[string]*cluster.DisruptionBudget{}
+	var order []string
+	pdbsByNamespace := map[string][]policyv1beta1.PodDisruptionBudget{}
+
+	for _, id := range ids {
+		if !c.IsAllowedResource(id) {
+			continue
+		}
+		ns, kind, name := id.Components()
+		resourceKind, ok := resourceKinds[kind]
+		if !ok {
+			continue
+		}
+		workload, err := resourceKind.getWorkload(c, ns, name)
+		if err != nil {
+			if apierrors.IsForbidden(err) || apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		podLabels := workload.podTemplate.Labels
+		if len(podLabels) == 0 {
+			continue
+		}
+
+		pdbs, ok := pdbsByNamespace[ns]
+		if !ok {
+			list, err := c.client.PolicyV1beta1().PodDisruptionBudgets(ns).List(meta_v1.ListOptions{})
+			if err != nil {
+				return nil, errors.Wrapf(err, "listing PodDisruptionBudgets in namespace %s", ns)
+			}
+			pdbs = list.Items
+			pdbsByNamespace[ns] = pdbs
+		}
+
+		for _, pdb := range pdbs {
+			if pdb.Spec.Selector == nil {
+				continue
+			}
+			selector, err := meta_v1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			// As with the disruption controller, an empty selector
+			// matches no pods
+			if err != nil || selector.Empty() || !selector.Matches(labels.Set(podLabels)) {
+				continue
+			}
+			key := fmt.Sprintf("%s/%s", pdb.Namespace, pdb.Name)
+			budget, ok := budgets[key]
+			if !ok {
+				budget = &cluster.DisruptionBudget{
+					Name:    key,
+					Allowed: int(pdb.Status.PodDisruptionsAllowed),
+					Healthy: pdb.Status.ExpectedPods > 0 && pdb.Status.CurrentHealthy >= pdb.Status.DesiredHealthy,
+				}
+				budgets[key] = budget
+				order = append(order, key)
+			}
+			budget.Workloads = append(budget.Workloads, id)
+		}
+	}
+
+	var result []cluster.DisruptionBudget
+	for _, key := range order {
+		result = append(result, *budgets[key])
+	}
+	return result, nil
+}
@@ -0,0 +1,247 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/weaveworks/flux/cluster"
+	"github.com/weaveworks/flux/policy"
+)
+
+// Diffs longer than this many lines are truncated when reporting
+// drift.
+const maxDriftDiffLines = 40
+
+// Drift compares the resources in the sync set with those in the
+// cluster, and reports those that are missing or differ. Only the
+// fields given in a manifest are compared, since the cluster will
+// fill in defaults and status for everything else.
+//
+// Encrypted manifests, and Secrets with values kept in Vault, are not
+// compared, and the contents of Secrets are never included in diffs.
+// Containers and volumes injected into workloads, as given by
+// InjectedContainers and InjectedVolumes, are not counted as drift.
+//
+// If garbage collection is enabled, the resources it would delete
+// when the sync set is synced are reported too.
+func (c *Cluster) Drift(syncSet cluster.SyncSet) ([]cluster.Drift, error) {
+	clusterResources, err := c.getAllowedResourcesBySelector("")
+	if err != nil {
+		return nil, errors.Wrap(err, "collating resources in cluster for drift detection")
+	}
+
+	var drifts []cluster.Drift
+	for _, res := range syncSet.Resources {
+		id := res.ResourceID()
+		if !c.IsAllowedResource(id) || res.Policies().Has(policy.Ignore) {
+			continue
+		}
+		if c.Decrypter != nil && c.Decrypter.matches(res.Source(), res.Bytes()) {
+			continue
+		}
+		_, kind, _ := id.Components()
+		if c.Secrets != nil && c.Secrets.matches(kind, res.Bytes()) {
+			continue
+		}
+		cres, ok := clusterResources[id.String()]
+		if !ok {
+			drifts = append(drifts, cluster.Drift{ResourceID: id, Source: res.Source(), Missing: true})
+			continue
+		}
+		if cres.Policies().Has(policy.Ignore) {
+			continue
+		}
+		manifest := res.Bytes()
+		if !c.keepStatus(kind) {
+			// The status isn't applied, so can't have drifted
+			if manifest, _, err = stripStatus(manifest); err != nil {
+				c.logger.Log("warning", "could not compare resource with cluster", "resource", id, "err", err)
+				continue
+			}
+		}
+		live := c.withoutInjected(kind, manifest, cres.obj.Object)
+		diff, err := diffManifest(manifest, live, kind == "secret")
+		if err != nil {
+			c.logger.Log("warning", "could not compare resource with cluster", "resource", id, "err", err)
+			continue
+		}
+		if diff != "" {
+			truncated := truncateLines(diff, maxDriftDiffLines)
+			drifts = append(drifts, cluster.Drift{ResourceID: id, Source: res.Source(), Diff: truncated, Truncated: truncated != diff})
+		}
+	}
+	if c.GC {
+		deleted, err := c.garbageIn(syncSet)
+		if err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, deleted...)
+	}
+	sort.Slice(drifts, func(i, j int) bool {
+		return drifts[i].ResourceID.String() < drifts[j].ResourceID.String()
+	})
+	return drifts, nil
+}
+
+// garbageIn gives the resources garbage collection would delete were
+// the sync set given synced: those applied by earlier syncs of the
+// sync set that aren't in it, less those collectGarbage keeps
+// regardless. Resources that would wait out a grace period first
+// are included.
+func (c *Cluster) garbageIn(syncSet cluster.SyncSet) ([]cluster.Drift, error) {
+	var includeKind func(schema.GroupVersionKind) bool
+	if len(c.GCKinds) > 0 {
+		includeKind = c.isGCKind
+	}
+	clusterResources, err := c.getAllowedGCMarkedResourcesInSyncSet(syncSet.Name, includeKind)
+	if err != nil {
+		return nil, errors.Wrap(err, "collating resources in cluster for previewing garbage collection")
+	}
+	inSyncSet := map[string]bool{}
+	for _, res := range syncSet.Resources {
+		inSyncSet[res.ResourceID().String()] = true
+	}
+
+	var deleted []cluster.Drift
+	for id, res := range clusterResources {
+		switch {
+		case inSyncSet[id], res.Policies().Has(policy.Ignore):
+			continue
+		case c.GCSelector != nil && !c.GCSelector.Matches(labels.Set(res.obj.GetLabels())):
+			continue
+		case isAutoCreated(res) && c.keepAutoCreatedNamespace(log.NewNopLogger(), res):
+			continue
+		}
+		deleted = append(deleted, cluster.Drift{ResourceID: res.ResourceID(), Deleted: true})
+	}
+	return deleted, nil
+}
+
+// diffManifest compares the manifest with the live object, returning
+// a diff if they differ, or the empty string if not. If redact is
+// true, only the fact they differ is reported. The diff isn't
+// truncated, however long it is.
+func diffManifest(manifest []byte, live map[string]interface{}, redact bool) (string, error) {
+	var desired interface{}
+	if err := yaml.Unmarshal(manifest, &desired); err != nil {
+		return "", errors.Wrap(err, "parsing manifest")
+	}
+	desired = dropNulls(desired)
+	if redact {
+		// stringData is write-only, so can't be compared
+		if m, ok := desired.(map[string]interface{}); ok {
+			delete(m, "stringData")
+		}
+	}
+	// Go via JSON, so numbers are represented the same way in both
+	liveBytes, err := json.Marshal(live)
+	if err != nil {
+		return "", err
+	}
+	var actual interface{}
+	if err := json.Unmarshal(liveBytes, &actual); err != nil {
+		return "", err
+	}
+
+	projected := project(desired, actual)
+	if reflect.DeepEqual(desired, projected) {
+		return "", nil
+	}
+	if redact {
+		return "(contents differ; not shown)", nil
+	}
+
+	desiredYAML, err := yaml.Marshal(desired)
+	if err != nil {
+		return "", err
+	}
+	projectedYAML, err := yaml.Marshal(projected)
+	if err != nil {
+		return "", err
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(desiredYAML)),
+		B:        difflib.SplitLines(string(projectedYAML)),
+		FromFile: "git",
+		ToFile:   "cluster",
+		Context:  2,
+	})
+	if err != nil {
+		return "", err
+	}
+	return diff, nil
+}
+
+// project gives the parts of the live value that correspond to the
+// parts of the desired value; i.e., map entries not in desired are
+// left out.
+func project(desired, live interface{}) interface{} {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return live
+		}
+		projected := map[string]interface{}{}
+		for k, v := range d {
+			if lv, ok := l[k]; ok {
+				projected[k] = project(v, lv)
+			}
+		}
+		return projected
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(d) {
+			return live
+		}
+		projected := make([]interface{}, len(d))
+		for i := range d {
+			projected[i] = project(d[i], l[i])
+		}
+		return projected
+	default:
+		return live
+	}
+}
+
+// dropNulls removes map entries with null values (e.g.,
+// `creationTimestamp: null`, common in generated manifests), since
+// they have no effect when applied.
+func dropNulls(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			if item == nil {
+				delete(v, k)
+			} else {
+				v[k] = dropNulls(item)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = dropNulls(v[i])
+		}
+	}
+	return value
+}
+
+func truncateLines(s string, max int) string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) <= max {
+		return s
+	}
+	return strings.Join(lines[:max], "") + fmt.Sprintf("... (%d more lines)\n", len(lines)-max)
+}
@@ -0,0 +1,163 @@
+package kubernetes
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffManifest(t *testing.T) {
+	const manifest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: dep
+  namespace: foo
+  creationTimestamp: null
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v1
+`
+	live := func(replicas int64) map[string]interface{} {
+		return map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":              "dep",
+				"namespace":         "foo",
+				"uid":               "1234",
+				"creationTimestamp": "2019-01-01T00:00:00Z",
+				"labels":            map[string]interface{}{"flux.weave.works/sync-gc-mark": "sha256.abc"},
+			},
+			"spec": map[string]interface{}{
+				"replicas":             replicas,
+				"revisionHistoryLimit": int64(10),
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"containers": []interface{}{
+							map[string]interface{}{"name": "app", "image": "app:v1", "imagePullPolicy": "IfNotPresent"},
+						},
+					},
+				},
+			},
+			"status": map[string]interface{}{"replicas": replicas},
+		}
+	}
+
+	diff, err := diffManifest([]byte(manifest), live(2), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != "" {
+		t.Errorf("expected no drift for defaulted fields, got:\n%s", diff)
+	}
+
+	diff, err = diffManifest([]byte(manifest), live(3), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, "-  replicas: 2") || !strings.Contains(diff, "+  replicas: 3") {
+		t.Errorf("expected diff of replicas, got:\n%s", diff)
+	}
+
+	diff, err = diffManifest([]byte(manifest), live(3), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff == "" || strings.Contains(diff, "replicas") {
+		t.Errorf("expected redacted diff, got:\n%s", diff)
+	}
+}
+
+func TestDiffWithoutInjected(t *testing.T) {
+	const manifest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: dep
+  namespace: foo
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v1
+        volumeMounts:
+        - name: config
+          mountPath: /etc/app
+      volumes:
+      - name: config
+        configMap:
+          name: app
+`
+	live := map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "dep", "namespace": "foo"},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"initContainers": []interface{}{
+						map[string]interface{}{"name": "istio-init", "image": "proxyv2"},
+					},
+					"containers": []interface{}{
+						map[string]interface{}{"name": "app", "image": "app:v1", "volumeMounts": []interface{}{
+							map[string]interface{}{"name": "config", "mountPath": "/etc/app"},
+							map[string]interface{}{"name": "istio-envoy", "mountPath": "/etc/istio/proxy"},
+						}},
+						map[string]interface{}{"name": "istio-proxy", "image": "proxyv2"},
+					},
+					"volumes": []interface{}{
+						map[string]interface{}{"name": "config", "configMap": map[string]interface{}{"name": "app"}},
+						map[string]interface{}{"name": "istio-envoy", "emptyDir": map[string]interface{}{}},
+					},
+				},
+			},
+		},
+	}
+
+	c := &Cluster{}
+	diff, err := diffManifest([]byte(manifest), c.withoutInjected("deployment", []byte(manifest), live), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff == "" {
+		t.Error("expected injected sidecar to be reported as drift when no patterns are given")
+	}
+
+	c.InjectedContainers = []string{"istio-*"}
+	c.InjectedVolumes = []string{"istio-*"}
+	diff, err = diffManifest([]byte(manifest), c.withoutInjected("deployment", []byte(manifest), live), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != "" {
+		t.Errorf("expected no drift for injected containers and volumes, got:\n%s", diff)
+	}
+	containers := live["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})["containers"].([]interface{})
+	if len(containers) != 2 {
+		t.Error("expected the live object to be left as it was")
+	}
+
+	// Containers in the manifest are still compared, even if they match
+	c.InjectedContainers = []string{"*"}
+	diff, err = diffManifest([]byte(manifest), c.withoutInjected("deployment", []byte(manifest), live), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != "" {
+		t.Errorf("expected no drift, got:\n%s", diff)
+	}
+}
+
+func TestTruncateLines(t *testing.T) {
+	s := strings.Repeat("line\n", 10)
+	if truncateLines(s, 20) != s {
+		t.Error("expected short text to be left alone")
+	}
+	truncated := truncateLines(s, 3)
+	if !strings.HasPrefix(truncated, "line\nline\nline\n...") {
+		t.Errorf("unexpected truncation: %q", truncated)
+	}
+}
@@ -0,0 +1,85 @@
+package kubernetes
+
+import (
+	"fmt"
+
+	"github.com/go-kit/kit/log"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	eventComponent = "flux"
+
+	EventReasonSynced     = "Synced"
+	EventReasonSyncFailed = "SyncFailed"
+
+	// These are the defaults of client-go's event spam filter
+	DefaultEventsPerSecond = 1.0 / 300
+	DefaultEventsBurst     = 25
+)
+
+// SyncEventsConfig is used to configure SyncEvents.
+type SyncEventsConfig struct {
+	EventsAPI v1.EventsGetter
+	// Events about each resource are emitted at this rate on
+	// average, allowing up to Burst at once
+	PerSecond float32
+	Burst     int
+	Logger    log.Logger
+}
+
+// SyncEvents emits Kubernetes Events on synced resources, saying
+// whether they were applied or failed, and from which revision; so
+// that `kubectl describe` shows the outcome of syncing.
+//
+// Events are emitted with client-go's event recorder. To avoid
+// flooding the API server, it counts repeats of an event against
+// the event already emitted rather than emitting another, and drops
+// events about a resource emitted faster than the configured rate.
+type SyncEvents struct {
+	SyncEventsConfig
+
+	recorder record.EventRecorder
+}
+
+func NewSyncEvents(config SyncEventsConfig) *SyncEvents {
+	if config.PerSecond == 0 {
+		config.PerSecond = DefaultEventsPerSecond
+	}
+	if config.Burst == 0 {
+		config.Burst = DefaultEventsBurst
+	}
+	broadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		QPS:       config.PerSecond,
+		BurstSize: config.Burst,
+	})
+	broadcaster.StartLogging(func(format string, args ...interface{}) {
+		config.Logger.Log("debug", fmt.Sprintf(format, args...))
+	})
+	broadcaster.StartRecordingToSink(&v1.EventSinkImpl{Interface: config.EventsAPI.Events("")})
+	return &SyncEvents{
+		SyncEventsConfig: config,
+		recorder:         broadcaster.NewRecorder(scheme.Scheme, apiv1.EventSource{Component: eventComponent}),
+	}
+}
+
+// record emits an event for the resource given. A nil syncErr means
+// the resource was applied.
+func (e *SyncEvents) record(res *kuberesource, revision string, syncErr error) {
+	eventType, reason := apiv1.EventTypeNormal, EventReasonSynced
+	message := "Applied"
+	if revision != "" {
+		message = fmt.Sprintf("Applied revision %s", revision)
+	}
+	if syncErr != nil {
+		eventType, reason = apiv1.EventTypeWarning, EventReasonSyncFailed
+		message = fmt.Sprintf("Failed to apply: %s", syncErr.Error())
+		if revision != "" {
+			message = fmt.Sprintf("Failed to apply revision %s: %s", revision, syncErr.Error())
+		}
+	}
+	e.recorder.Event(res.obj, eventType, reason, message)
+}
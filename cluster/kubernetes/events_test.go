@@ -0,0 +1,87 @@
+package kubernetes
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSyncEvents(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	events := NewSyncEvents(SyncEventsConfig{
+		EventsAPI: client.CoreV1(),
+		PerSecond: 0.0001,
+		Burst:     3,
+		Logger:    log.NewNopLogger(),
+	})
+
+	res := &kuberesource{
+		obj: &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":      "dep",
+				"namespace": "foo",
+				"uid":       "1234",
+			},
+		}},
+		namespaced: true,
+	}
+
+	list := func() []apiv1.Event {
+		list, err := client.CoreV1().Events("foo").List(meta_v1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return list.Items
+	}
+	// Events are emitted in the background, so wait for them
+	waitFor := func(n int) []apiv1.Event {
+		deadline := time.Now().Add(5 * time.Second)
+		items := list()
+		for len(items) < n && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			items = list()
+		}
+		return items
+	}
+
+	events.record(res, "abc123", nil)
+	items := waitFor(1)
+	if assert.Len(t, items, 1) {
+		assert.Equal(t, apiv1.EventTypeNormal, items[0].Type)
+		assert.Equal(t, EventReasonSynced, items[0].Reason)
+		assert.Equal(t, "1234", string(items[0].InvolvedObject.UID))
+		assert.Contains(t, items[0].Message, "abc123")
+	}
+
+	events.record(res, "def456", errors.New("boom"))
+	items = waitFor(2)
+	if assert.Len(t, items, 2) {
+		var warning *apiv1.Event
+		for i := range items {
+			if items[i].Type == apiv1.EventTypeWarning {
+				warning = &items[i]
+			}
+		}
+		if assert.NotNil(t, warning) {
+			assert.Equal(t, EventReasonSyncFailed, warning.Reason)
+			assert.Contains(t, warning.Message, "boom")
+		}
+	}
+
+	// The burst for the resource is used up after this, so the last
+	// is dropped
+	events.record(res, "789abc", nil)
+	assert.Len(t, waitFor(3), 3)
+	events.record(res, "fedcba", errors.New("bang"))
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, list(), 3)
+}
@@ -0,0 +1,356 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	// A token is got afresh this long before it's due to expire, so
+	// that it doesn't expire part way through (e.g.) a sync
+	execTokenRefreshMargin = time.Minute
+	// After the plugin fails, it's not run again for this long, so
+	// that every request doesn't run it
+	execPluginFailureBackoff = 10 * time.Second
+	// How long to let the plugin run
+	execPluginTimeout = time.Minute
+)
+
+// ExecPlugin is a credential plugin, as given in the `exec` entry of
+// a user in a kubeconfig file; it prints an ExecCredential with a
+// bearer token.
+type ExecPlugin struct {
+	Command    string
+	Args       []string
+	Env        []string // as `NAME=value`
+	APIVersion string
+}
+
+// CredentialPluginError is returned when the credential plugin can't
+// be run, or doesn't give a token.
+type CredentialPluginError struct {
+	Command string
+	Err     error
+	Stderr  string
+}
+
+func (e *CredentialPluginError) Error() string {
+	msg := fmt.Sprintf("credential plugin %s failed: %s", e.Command, e.Err)
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+// ExecCredentials gets bearer tokens from a credential plugin, for
+// both the Kubernetes API clients and kubectl, so that the plugin is
+// run once per token rather than once per client or per kubectl
+// invocation. The token is kept until shortly before it expires (or
+// indefinitely, if the plugin doesn't say when it expires), or until
+// the API server rejects it as unauthorized.
+type ExecCredentials struct {
+	plugin ExecPlugin
+	logger log.Logger
+
+	mu       sync.Mutex
+	token    string
+	expiry   time.Time // zero if the token doesn't expire
+	lastErr  error
+	failedAt time.Time
+	// closed once the plugin run in progress, if there is one, is
+	// done; the plugin is run without holding mu
+	fetching chan struct{}
+
+	// the kubeconfig file last written for kubectl, and the token in
+	// it; see Kubeconfig
+	kubeconfigMu    sync.Mutex
+	kubeconfigDir   string
+	kubeconfigToken string
+
+	// for testing
+	now func() time.Time
+	run func(ExecPlugin) ([]byte, error)
+}
+
+func NewExecCredentials(plugin ExecPlugin, logger log.Logger) *ExecCredentials {
+	return &ExecCredentials{
+		plugin: plugin,
+		logger: logger,
+		now:    time.Now,
+		run:    runExecPlugin,
+	}
+}
+
+// UseExecCredentials arranges for the config given to get its
+// credentials from an ExecCredentials, if it has a credential plugin,
+// and returns the ExecCredentials; otherwise, it returns nil and
+// leaves the config as it is.
+func UseExecCredentials(config *rest.Config, logger log.Logger) *ExecCredentials {
+	if config.ExecProvider == nil {
+		return nil
+	}
+	plugin := ExecPlugin{
+		Command:    config.ExecProvider.Command,
+		Args:       config.ExecProvider.Args,
+		APIVersion: config.ExecProvider.APIVersion,
+	}
+	for _, env := range config.ExecProvider.Env {
+		plugin.Env = append(plugin.Env, env.Name+"="+env.Value)
+	}
+	creds := NewExecCredentials(plugin, logger)
+	config.ExecProvider = nil
+	creds.Apply(config)
+	return creds
+}
+
+// Apply makes the config given use the token from the plugin for
+// each request.
+func (c *ExecCredentials) Apply(config *rest.Config) {
+	wrap := config.WrapTransport
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return &execCredentialsRoundTripper{creds: c, next: rt}
+	}
+}
+
+// Token returns a token from the plugin, running it if there's no
+// token or the token is about to expire. If the plugin fails, a
+// token that hasn't expired yet is returned anyway, since it may
+// still be good; otherwise, the error is returned, and it's
+// returned again without running the plugin until the backoff has
+// elapsed. The plugin is run by one caller at a time; others asking
+// for a token meanwhile wait for it, rather than run it again.
+func (c *ExecCredentials) Token() (string, error) {
+	c.mu.Lock()
+	for c.fetching != nil {
+		wait := c.fetching
+		c.mu.Unlock()
+		<-wait
+		c.mu.Lock()
+	}
+	defer c.mu.Unlock()
+	now := c.now()
+	if c.token != "" && (c.expiry.IsZero() || now.Add(execTokenRefreshMargin).Before(c.expiry)) {
+		return c.token, nil
+	}
+	if c.lastErr != nil && now.Sub(c.failedAt) < execPluginFailureBackoff {
+		if c.token != "" && now.Before(c.expiry) {
+			return c.token, nil
+		}
+		return "", c.lastErr
+	}
+
+	done := make(chan struct{})
+	c.fetching = done
+	c.mu.Unlock()
+	token, expiry, err := c.fetch()
+	c.mu.Lock()
+	c.fetching = nil
+	close(done)
+	if err != nil {
+		c.lastErr, c.failedAt = err, now
+		if c.token != "" && now.Before(c.expiry) {
+			c.logger.Log("warning", "could not refresh credentials; using the current token until it expires", "auth", "exec", "expires", c.expiry.Format(time.RFC3339), "err", err)
+			return c.token, nil
+		}
+		c.logger.Log("err", err, "auth", "exec")
+		c.token, c.expiry = "", time.Time{}
+		return "", err
+	}
+	if c.lastErr != nil {
+		c.logger.Log("info", "credential plugin succeeded after failing", "auth", "exec")
+	}
+	c.token, c.expiry, c.lastErr = token, expiry, nil
+	return token, nil
+}
+
+// Kubeconfig gives the path of a kubeconfig file for kubectl, to
+// connect as the config given does but with a token from the plugin;
+// that way the token isn't on kubectl's command line, where anything
+// on the host could see it. The file is readable only by fluxd, and
+// is written afresh when the token changes.
+func (c *ExecCredentials) Kubeconfig(config *rest.Config) (string, error) {
+	token, err := c.Token()
+	if err != nil {
+		return "", err
+	}
+	c.kubeconfigMu.Lock()
+	defer c.kubeconfigMu.Unlock()
+	if c.kubeconfigDir == "" {
+		dir, err := ioutil.TempDir("", "flux-kubeconfig")
+		if err != nil {
+			return "", errors.Wrap(err, "creating directory for kubeconfig")
+		}
+		c.kubeconfigDir = dir
+	}
+	path := filepath.Join(c.kubeconfigDir, "kubeconfig")
+	if c.kubeconfigToken == token {
+		return path, nil
+	}
+
+	contents, err := clientcmd.Write(*tokenKubeconfig(config, token))
+	if err != nil {
+		return "", errors.Wrap(err, "writing kubeconfig")
+	}
+	// Written elsewhere and moved into place, so a kubectl reading the
+	// file meanwhile sees either the old token or the new one
+	f, err := ioutil.TempFile(c.kubeconfigDir, "kubeconfig")
+	if err != nil {
+		return "", errors.Wrap(err, "writing kubeconfig")
+	}
+	_, err = f.Write(contents)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", errors.Wrap(err, "writing kubeconfig")
+	}
+	c.kubeconfigToken = token
+	return path, nil
+}
+
+// tokenKubeconfig gives a kubeconfig for the server in the config
+// given, authenticating with the token given (and any client
+// certificate in the config).
+func tokenKubeconfig(config *rest.Config, token string) *clientcmdapi.Config {
+	const name = "flux"
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters[name] = &clientcmdapi.Cluster{
+		Server:                   config.Host,
+		InsecureSkipTLSVerify:    config.Insecure,
+		CertificateAuthority:     config.CAFile,
+		CertificateAuthorityData: config.CAData,
+	}
+	kubeconfig.AuthInfos[name] = &clientcmdapi.AuthInfo{
+		Token:                 token,
+		ClientCertificate:     config.CertFile,
+		ClientCertificateData: config.CertData,
+		ClientKey:             config.KeyFile,
+		ClientKeyData:         config.KeyData,
+	}
+	kubeconfig.Contexts[name] = &clientcmdapi.Context{Cluster: name, AuthInfo: name}
+	kubeconfig.CurrentContext = name
+	return kubeconfig
+}
+
+// invalidate forgets the token given, if it's the one cached, so
+// that the next request runs the plugin again. It's for when the API
+// server says a token is unauthorized, e.g., because it was revoked
+// before it was due to expire.
+func (c *ExecCredentials) invalidate(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token == token {
+		c.token, c.expiry = "", time.Time{}
+	}
+}
+
+// execCredential is the output of the plugin; the fields are the
+// same in each of the API versions.
+type execCredential struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Status     *struct {
+		Token               string     `json:"token"`
+		ExpirationTimestamp *time.Time `json:"expirationTimestamp,omitempty"`
+	} `json:"status"`
+}
+
+func (c *ExecCredentials) fetch() (string, time.Time, error) {
+	output, err := c.run(c.plugin)
+	if err != nil {
+		pluginErr := &CredentialPluginError{Command: c.plugin.Command, Err: err}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			pluginErr.Stderr = strings.TrimSpace(string(exitErr.Stderr))
+		}
+		return "", time.Time{}, pluginErr
+	}
+	var cred execCredential
+	if err := json.Unmarshal(output, &cred); err != nil {
+		return "", time.Time{}, &CredentialPluginError{Command: c.plugin.Command, Err: fmt.Errorf("decoding ExecCredential: %s", err)}
+	}
+	if c.plugin.APIVersion != "" && cred.APIVersion != c.plugin.APIVersion {
+		return "", time.Time{}, &CredentialPluginError{Command: c.plugin.Command, Err: fmt.Errorf("got ExecCredential of version %q, expected %q", cred.APIVersion, c.plugin.APIVersion)}
+	}
+	if cred.Status == nil || cred.Status.Token == "" {
+		// Client certificates aren't supported, since kubectl is
+		// given only a token
+		return "", time.Time{}, &CredentialPluginError{Command: c.plugin.Command, Err: fmt.Errorf("no token in ExecCredential")}
+	}
+	var expiry time.Time
+	if cred.Status.ExpirationTimestamp != nil {
+		expiry = *cred.Status.ExpirationTimestamp
+	}
+	return cred.Status.Token, expiry, nil
+}
+
+func runExecPlugin(plugin ExecPlugin) ([]byte, error) {
+	apiVersion := plugin.APIVersion
+	if apiVersion == "" {
+		apiVersion = "client.authentication.k8s.io/v1alpha1"
+	}
+	info, err := json.Marshal(map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       "ExecCredential",
+		"spec":       map[string]interface{}{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), execPluginTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, plugin.Command, plugin.Args...)
+	cmd.Env = append(append(os.Environ(), plugin.Env...), "KUBERNETES_EXEC_INFO="+string(info))
+	// With Stderr left nil, what the plugin prints there is kept in
+	// the *exec.ExitError if it fails
+	return cmd.Output()
+}
+
+type execCredentialsRoundTripper struct {
+	creds *ExecCredentials
+	next  http.RoundTripper
+}
+
+func (rt *execCredentialsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" {
+		return rt.next.RoundTrip(req)
+	}
+	token, err := rt.creds.Token()
+	if err != nil {
+		return nil, err
+	}
+	authed := new(http.Request)
+	*authed = *req
+	authed.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		authed.Header[k] = v
+	}
+	authed.Header.Set("Authorization", "Bearer "+token)
+	resp, err := rt.next.RoundTrip(authed)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		rt.creds.logger.Log("err", "API server rejected the token from the credential plugin as unauthorized; it will be run again for the next request", "auth", "exec", "url", req.URL.Path)
+		rt.creds.invalidate(token)
+	}
+	return resp, err
+}
@@ -0,0 +1,191 @@
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// fakePlugin gives a new token each time it's run, expiring an hour
+// after the time given, or fails if fail is set.
+type fakePlugin struct {
+	runs int
+	now  time.Time
+	fail bool
+}
+
+func (p *fakePlugin) run(ExecPlugin) ([]byte, error) {
+	p.runs++
+	if p.fail {
+		return nil, errors.New("no credentials")
+	}
+	expiry := p.now.Add(time.Hour).UTC().Format(time.RFC3339)
+	return []byte(fmt.Sprintf(`{"apiVersion":"client.authentication.k8s.io/v1beta1","kind":"ExecCredential","status":{"token":"token-%d","expirationTimestamp":%q}}`, p.runs, expiry)), nil
+}
+
+func TestExecCredentialsCachesAndRefreshes(t *testing.T) {
+	plugin := &fakePlugin{now: time.Now()}
+	now := plugin.now
+	creds := NewExecCredentials(ExecPlugin{Command: "get-token", APIVersion: "client.authentication.k8s.io/v1beta1"}, log.NewNopLogger())
+	creds.run = plugin.run
+	creds.now = func() time.Time { return now }
+
+	token, err := creds.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "token-1", token)
+	token, _ = creds.Token()
+	assert.Equal(t, "token-1", token)
+	assert.Equal(t, 1, plugin.runs, "expected the token to be cached")
+
+	// close to expiry, the token is got afresh
+	now = now.Add(time.Hour - execTokenRefreshMargin/2)
+	plugin.now = now
+	token, _ = creds.Token()
+	assert.Equal(t, "token-2", token)
+
+	// if the plugin fails, the token is used until it expires
+	now = now.Add(time.Hour - 5*time.Second)
+	plugin.fail = true
+	token, err = creds.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "token-2", token)
+
+	// and once it's expired, the failure is returned, without
+	// running the plugin again until the backoff has elapsed
+	now = now.Add(6 * time.Second)
+	runs := plugin.runs
+	_, err = creds.Token()
+	assert.Equal(t, runs, plugin.runs)
+	_, ok := err.(*CredentialPluginError)
+	assert.True(t, ok, "expected a CredentialPluginError, got %v", err)
+
+	now = now.Add(execPluginFailureBackoff)
+	plugin.fail = false
+	plugin.now = now
+	token, err = creds.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "token-4", token)
+}
+
+func TestExecCredentialsPluginRunOutsideLock(t *testing.T) {
+	plugin := &fakePlugin{now: time.Now()}
+	started, release := make(chan struct{}), make(chan struct{})
+	creds := NewExecCredentials(ExecPlugin{Command: "get-token"}, log.NewNopLogger())
+	creds.run = func(p ExecPlugin) ([]byte, error) {
+		close(started)
+		<-release
+		return plugin.run(p)
+	}
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 5)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], _ = creds.Token()
+		}(i)
+	}
+	<-started
+
+	// while the plugin runs, the credentials can still be used
+	invalidated := make(chan struct{})
+	go func() {
+		creds.invalidate("token-0")
+		close(invalidated)
+	}()
+	select {
+	case <-invalidated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("invalidating the token waited for the plugin")
+	}
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, 1, plugin.runs, "expected those waiting for a token to share the plugin's run")
+	for _, token := range tokens {
+		assert.Equal(t, "token-1", token)
+	}
+}
+
+func TestExecCredentialsKubeconfig(t *testing.T) {
+	plugin := &fakePlugin{now: time.Now()}
+	creds := NewExecCredentials(ExecPlugin{Command: "get-token"}, log.NewNopLogger())
+	creds.run = plugin.run
+	config := &rest.Config{Host: "https://cluster.example.com", TLSClientConfig: rest.TLSClientConfig{CAData: []byte("ca")}}
+
+	path, err := creds.Kubeconfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(path))
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	kubeconfig, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	context := kubeconfig.Contexts[kubeconfig.CurrentContext]
+	assert.Equal(t, "token-1", kubeconfig.AuthInfos[context.AuthInfo].Token)
+	assert.Equal(t, "https://cluster.example.com", kubeconfig.Clusters[context.Cluster].Server)
+	assert.Equal(t, []byte("ca"), kubeconfig.Clusters[context.Cluster].CertificateAuthorityData)
+
+	// the file is written again only once there's a new token
+	again, _ := creds.Kubeconfig(config)
+	assert.Equal(t, path, again)
+	assert.Equal(t, 1, plugin.runs)
+
+	creds.invalidate("token-1")
+	plugin.now = time.Now()
+	path, err = creds.Kubeconfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, _ := ioutil.ReadFile(path)
+	assert.True(t, strings.Contains(string(contents), "token-2"), "expected the new token in the kubeconfig, got:\n%s", contents)
+	files, _ := ioutil.ReadDir(filepath.Dir(path))
+	assert.Len(t, files, 1, "expected only the kubeconfig in its directory")
+}
+
+func TestExecCredentialsRoundTripper(t *testing.T) {
+	var authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		if len(authorizations) == 2 {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	plugin := &fakePlugin{now: time.Now()}
+	creds := NewExecCredentials(ExecPlugin{Command: "get-token"}, log.NewNopLogger())
+	creds.run = plugin.run
+	client := &http.Client{Transport: &execCredentialsRoundTripper{creds: creds, next: http.DefaultTransport}}
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	// the second request is unauthorized, so the token is dropped
+	assert.Equal(t, []string{"Bearer token-1", "Bearer token-1", "Bearer token-2"}, authorizations)
+}
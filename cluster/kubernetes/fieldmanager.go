@@ -0,0 +1,131 @@
+package kubernetes
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/weaveworks/flux/cluster"
+	kresource "github.com/weaveworks/flux/cluster/kubernetes/resource"
+)
+
+// Resources with this annotation are applied, with server-side apply,
+// as the field manager given, rather than one given by path or the
+// default. This lets changes be attributed to (e.g.) the team owning
+// the resource, when there are conflicts.
+const fieldManagerAnnotation = kresource.PolicyPrefix + "field-manager"
+
+// DefaultFieldManager is the field manager resources are applied as
+// with server-side apply, if no other is given.
+const DefaultFieldManager = "flux"
+
+// Kubernetes limits field manager names to this length
+const maxFieldManagerLength = 128
+
+var fieldManagerRE = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._:/-]*[A-Za-z0-9])?$`)
+
+// ValidateFieldManager checks that the name given can be used as a
+// field manager.
+func ValidateFieldManager(name string) error {
+	if len(name) > maxFieldManagerLength {
+		return fmt.Errorf("field manager %q is longer than %d characters", name, maxFieldManagerLength)
+	}
+	if !fieldManagerRE.MatchString(name) {
+		return fmt.Errorf("field manager %q must start and end with a letter or digit, and contain only letters, digits, and '.', '_', ':', '/' or '-'", name)
+	}
+	return nil
+}
+
+// FieldManagerRule says which field manager to apply the resources
+// in some files as.
+type FieldManagerRule struct {
+	// A directory in the repo (e.g., "teams/payments"), all of whose
+	// files the rule is for; or a glob pattern for the files (e.g.,
+	// "*/payments-*.yaml")
+	Path    string
+	Manager string
+}
+
+// ParseFieldManagerRule parses a rule given as <path>=<manager>.
+func ParseFieldManagerRule(s string) (FieldManagerRule, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return FieldManagerRule{}, fmt.Errorf("field manager rule %q is not of the form <path>=<manager>", s)
+	}
+	rule := FieldManagerRule{Path: filepath.Clean(parts[0]), Manager: parts[1]}
+	if err := ValidateFieldManager(rule.Manager); err != nil {
+		return FieldManagerRule{}, err
+	}
+	if _, err := filepath.Match(rule.Path, ""); err != nil {
+		return FieldManagerRule{}, fmt.Errorf("field manager rule %q has an invalid pattern: %v", s, err)
+	}
+	return rule, nil
+}
+
+func (r FieldManagerRule) matches(source string) bool {
+	if strings.HasPrefix(source, r.Path+string(filepath.Separator)) {
+		return true
+	}
+	ok, _ := filepath.Match(r.Path, source)
+	return ok
+}
+
+// fieldManagerFor gives the field manager to apply the object given
+// as: the one it's annotated with, if any; otherwise that of the
+// first rule matching the file it's from; otherwise FieldManager, or
+// DefaultFieldManager if that's empty.
+func (c *Kubectl) fieldManagerFor(obj applyObject) (string, error) {
+	var manifest struct {
+		Metadata struct {
+			Annotations map[string]string `yaml:"annotations"`
+		} `yaml:"metadata"`
+	}
+	if err := yaml.Unmarshal(obj.Payload, &manifest); err != nil {
+		return "", err
+	}
+	if name, ok := manifest.Metadata.Annotations[fieldManagerAnnotation]; ok {
+		if err := ValidateFieldManager(name); err != nil {
+			return "", fmt.Errorf("invalid value of annotation %s: %v", fieldManagerAnnotation, err)
+		}
+		return name, nil
+	}
+	for _, rule := range c.FieldManagerRules {
+		if rule.matches(obj.Source) {
+			return rule.Manager, nil
+		}
+	}
+	if c.FieldManager != "" {
+		return c.FieldManager, nil
+	}
+	return DefaultFieldManager, nil
+}
+
+type fieldManagerRun struct {
+	manager string
+	objs    []applyObject
+}
+
+// groupByFieldManager splits the objects given into runs of
+// consecutive objects with the same field manager, so that they can
+// be applied in the order given. Objects whose field manager can't be
+// worked out are returned as errors.
+func (c *Kubectl) groupByFieldManager(objs []applyObject) ([]fieldManagerRun, cluster.SyncError) {
+	var runs []fieldManagerRun
+	var errs cluster.SyncError
+	for _, obj := range objs {
+		manager, err := c.fieldManagerFor(obj)
+		if err != nil {
+			errs = append(errs, cluster.ResourceError{ResourceID: obj.ResourceID, Source: obj.Source, Error: err})
+			continue
+		}
+		if n := len(runs); n > 0 && runs[n-1].manager == manager {
+			runs[n-1].objs = append(runs[n-1].objs, obj)
+			continue
+		}
+		runs = append(runs, fieldManagerRun{manager: manager, objs: []applyObject{obj}})
+	}
+	return runs, errs
+}
@@ -0,0 +1,88 @@
+package kubernetes
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/weaveworks/flux/cluster"
+	fluxmetrics "github.com/weaveworks/flux/metrics"
+)
+
+var (
+	forcedApplies = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "cluster",
+		Name:      "forced_applies_total",
+		Help:      "Count of resources applied with --force, because their kind is configured to be.",
+	}, []string{"kind", fluxmetrics.LabelSuccess})
+)
+
+// mayForceApply decides whether the object given is to be applied
+// with `kubectl apply --force`, which deletes and creates a resource
+// again if patching it keeps conflicting. That's only done for the
+// kinds in ForceApplyKinds; and, as when recreating, resources of
+// kinds that hold state must also be annotated as safe to recreate.
+func (c *Kubectl) mayForceApply(logger log.Logger, obj applyObject) bool {
+	_, kind, _ := obj.ResourceID.Components()
+	optedIn := false
+	for _, k := range c.ForceApplyKinds {
+		if strings.ToLower(k) == kind {
+			optedIn = true
+			break
+		}
+	}
+	if !optedIn {
+		return false
+	}
+	if isStatefulKind(kind) {
+		ok, err := recreateAnnotated(obj)
+		if err != nil || !ok {
+			logger.Log("info", "not applying resource with --force", "resource", obj.ResourceID, "reason", fmt.Sprintf("kind %s holds state, so the resource must be annotated %s: \"true\"", kind, recreateAnnotation))
+			return false
+		}
+	}
+	return true
+}
+
+// splitForced splits the objects given into those to be applied as
+// usual, and those to be applied with `--force`, keeping the order of
+// each.
+func (c *Kubectl) splitForced(logger log.Logger, objs []applyObject) (normal, forced []applyObject) {
+	if len(c.ForceApplyKinds) == 0 {
+		return objs, nil
+	}
+	for _, obj := range objs {
+		if c.mayForceApply(logger, obj) {
+			forced = append(forced, obj)
+		} else {
+			normal = append(normal, obj)
+		}
+	}
+	return normal, forced
+}
+
+// forceApply applies each of the objects given with `kubectl apply
+// --force`, one by one, so that each can be logged and counted.
+func (c *Kubectl) forceApply(logger log.Logger, objs []applyObject, summary cluster.SyncSummary) (errs cluster.SyncError) {
+	for _, obj := range objs {
+		_, kind, _ := obj.ResourceID.Components()
+		logger.Log("info", "applying resource with --force; it will be deleted and created again if it conflicts", "resource", obj.ResourceID, "source", obj.Source)
+		output, err := c.doCommand(logger, bytes.NewReader(obj.Payload), "apply", "--force")
+		forcedApplies.With("kind", kind, fluxmetrics.LabelSuccess, fmt.Sprint(err == nil)).Add(1)
+		if err != nil {
+			errs = append(errs, cluster.ResourceError{
+				ResourceID: obj.ResourceID,
+				Source:     obj.Source,
+				Error:      err,
+			})
+			continue
+		}
+		countOutcomes(output, summary)
+	}
+	return errs
+}
@@ -0,0 +1,97 @@
+package kubernetes
+
+import (
+	"encoding/json"
+
+	"github.com/go-kit/kit/log"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster"
+	kresource "github.com/weaveworks/flux/cluster/kubernetes/resource"
+	"github.com/weaveworks/flux/policy"
+	"github.com/weaveworks/flux/resource"
+)
+
+// Resources with this annotation are applied at each sync regardless
+// of the rules that would otherwise have them left alone: the ignore
+// annotation, in the manifest or in the cluster, and the fields
+// disregarded for Jobs and CronJobs (`--sync-batch-ignore-fields`).
+//
+// With the value "true", the resource is forced for as long as it
+// has the annotation. With the value "once", given on the cluster
+// resource (e.g., with `kubectl annotate`), it's forced at the next
+// sync only: once applied, the annotation is removed, and the rules
+// apply again.
+const forceSyncAnnotation = kresource.PolicyPrefix + string(forceSyncPolicy)
+
+// The annotation as it appears among the policies of a manifest
+const forceSyncPolicy = policy.Policy("force-sync")
+
+const (
+	forceSyncAlways = "true"
+	forceSyncOnce   = "once"
+)
+
+// forceSync says whether the resource is to be applied regardless of
+// ignore rules, and whether it's only for this sync, in which case
+// the annotation on the cluster resource is to be removed afterwards.
+// "once" has effect only on the cluster resource, since in a
+// manifest it would just be applied again.
+func forceSync(res resource.Resource, cres *kuberesource) (force, once bool) {
+	if cres != nil {
+		switch cres.obj.GetAnnotations()[forceSyncAnnotation] {
+		case forceSyncOnce:
+			return true, true
+		case forceSyncAlways:
+			return true, false
+		}
+	}
+	v, _ := res.Policies().Get(forceSyncPolicy)
+	return v == forceSyncAlways, false
+}
+
+// clearForceSync removes the force-sync annotation from the cluster
+// resource, so that it's treated as usual from the next sync on.
+func (c *Cluster) clearForceSync(res *kuberesource) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				forceSyncAnnotation: nil,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	client := c.client.dynamicClient.Resource(res.gvr)
+	if res.namespaced {
+		_, err = client.Namespace(res.obj.GetNamespace()).Patch(res.obj.GetName(), types.MergePatchType, patch)
+		return err
+	}
+	_, err = client.Patch(res.obj.GetName(), types.MergePatchType, patch)
+	return err
+}
+
+// clearForcedOnce removes the force-sync annotation from each of the
+// resources given, unless it failed to apply, in which case it's
+// forced again at the next sync.
+func (c *Cluster) clearForcedOnce(logger log.Logger, forced map[flux.ResourceID]*kuberesource, errs cluster.SyncError) {
+	if len(forced) == 0 {
+		return
+	}
+	failed := flux.ResourceIDSet{}
+	for _, e := range errs {
+		failed.Add([]flux.ResourceID{e.ResourceID})
+	}
+	for id, res := range forced {
+		if failed.Contains(id) {
+			continue
+		}
+		if err := c.clearForceSync(res); err != nil {
+			logger.Log("warning", "could not remove force-sync annotation after applying resource; it will be forced again", "resource", id, "err", err)
+			continue
+		}
+		logger.Log("info", "removed force-sync annotation after applying resource once", "resource", id)
+	}
+}
@@ -0,0 +1,40 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ParseGCKinds parses kinds of resource given as
+// `<group>/<version>/<kind>`, as for `kubectl apply --prune-whitelist`;
+// e.g., `apps/v1/Deployment`, or `core/v1/ConfigMap` for the core
+// group.
+func ParseGCKinds(specs []string) ([]schema.GroupVersionKind, error) {
+	var kinds []schema.GroupVersionKind
+	for _, spec := range specs {
+		parts := strings.Split(spec, "/")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("%q is not of the form <group>/<version>/<kind>", spec)
+		}
+		group := parts[0]
+		if group == "core" {
+			group = ""
+		}
+		kinds = append(kinds, schema.GroupVersionKind{Group: group, Version: parts[1], Kind: parts[2]})
+	}
+	return kinds, nil
+}
+
+// isGCKind says whether resources of the kind given may be garbage
+// collected, since it's among GCKinds. The kind is compared without
+// regard to case, since it's often written in lower case.
+func (c *Cluster) isGCKind(gvk schema.GroupVersionKind) bool {
+	for _, k := range c.GCKinds {
+		if k.Group == gvk.Group && k.Version == gvk.Version && strings.EqualFold(k.Kind, gvk.Kind) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package kubernetes
+
+import (
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	kresource "github.com/weaveworks/flux/cluster/kubernetes/resource"
+)
+
+// A manifest can have `metadata.generateName` rather than a name, in
+// which case the API server makes up a name when the resource is
+// created, and it can only be created, not applied. To keep from
+// creating another resource from the manifest on every sync, the
+// resource is annotated with the ID of the manifest it was created
+// from; resources in the cluster with the annotation are identified
+// by it, rather than by their generated name, so they're matched
+// with the manifest in later syncs, and applied to by name
+// thereafter.
+const generatedFromAnnotation = kresource.PolicyPrefix + "sync-generated-from"
+
+// withGeneratedName prepares a definition for applying, if it has
+// `generateName` rather than a name: it is annotated with the ID of
+// the manifest, and, if it was created in an earlier sync, given the
+// name of the resource that was created. It returns true if the
+// definition has no name and must be created, rather than applied.
+func withGeneratedName(def []byte, id string, cres *kuberesource) ([]byte, bool, error) {
+	definition := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(def, &definition); err != nil {
+		return nil, false, errors.Wrap(err, "parsing definition for generated name")
+	}
+	metadata, _ := definition["metadata"].(map[interface{}]interface{})
+	if metadata == nil {
+		return def, false, nil
+	}
+	if name, _ := metadata["name"].(string); name != "" {
+		return def, false, nil
+	}
+	if generateName, _ := metadata["generateName"].(string); generateName == "" {
+		return def, false, nil
+	}
+
+	annotations, _ := metadata["annotations"].(map[interface{}]interface{})
+	if annotations == nil {
+		annotations = map[interface{}]interface{}{}
+	}
+	annotations[generatedFromAnnotation] = id
+	metadata["annotations"] = annotations
+
+	create := true
+	if cres != nil {
+		metadata["name"] = cres.obj.GetName()
+		delete(metadata, "generateName")
+		create = false
+	}
+
+	bytes, err := yaml.Marshal(definition)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "serializing definition with generated name")
+	}
+	return bytes, create, nil
+}
+
+// splitCreated splits the objects given into those to be applied, and
+// those to be created since they have no name yet, keeping the order
+// of each.
+func splitCreated(objs []applyObject) (applied, created []applyObject) {
+	for _, obj := range objs {
+		if obj.Create {
+			created = append(created, obj)
+		} else {
+			applied = append(applied, obj)
+		}
+	}
+	return applied, created
+}
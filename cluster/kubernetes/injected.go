@@ -0,0 +1,134 @@
+package kubernetes
+
+import (
+	"github.com/ghodss/yaml"
+	"github.com/ryanuber/go-glob"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// podSpecPath gives the path to the pod spec in resources of the kind
+// given, or nil if they don't have one.
+func podSpecPath(kind string) []string {
+	switch kind {
+	case "pod":
+		return []string{"spec"}
+	case "job":
+		return []string{"spec", "template", "spec"}
+	}
+	if path := podTemplatePath(kind); path != nil {
+		return append(path, "spec")
+	}
+	return nil
+}
+
+// withoutInjected gives the live object without the containers and
+// volumes that were injected into its pod spec (e.g., by a service
+// mesh's mutating webhook), so they aren't reported as drift: those
+// with names matching InjectedContainers or InjectedVolumes, that
+// aren't in the manifest given. The mounts of the volumes left out
+// are left out of the containers that remain, too. The live object
+// given is not changed.
+func (c *Cluster) withoutInjected(kind string, manifest []byte, live map[string]interface{}) map[string]interface{} {
+	path := podSpecPath(kind)
+	if path == nil || (len(c.InjectedContainers) == 0 && len(c.InjectedVolumes) == 0) {
+		return live
+	}
+	livePodSpec, ok := nestedMap(live, path)
+	if !ok {
+		return live
+	}
+	var desired map[string]interface{}
+	if err := yaml.Unmarshal(manifest, &desired); err != nil {
+		return live
+	}
+	desiredPodSpec, _ := nestedMap(desired, path)
+
+	live = runtime.DeepCopyJSON(live)
+	livePodSpec, _ = nestedMap(live, path)
+	for _, field := range []string{"containers", "initContainers"} {
+		removeInjected(livePodSpec, desiredPodSpec, field, c.InjectedContainers)
+	}
+	removedVolumes := removeInjected(livePodSpec, desiredPodSpec, "volumes", c.InjectedVolumes)
+	if len(removedVolumes) > 0 {
+		for _, field := range []string{"containers", "initContainers"} {
+			items, _ := livePodSpec[field].([]interface{})
+			for _, item := range items {
+				if container, ok := item.(map[string]interface{}); ok {
+					removeNamed(container, "volumeMounts", removedVolumes)
+				}
+			}
+		}
+	}
+	return live
+}
+
+// removeInjected removes the entries of the list field given from the
+// live pod spec whose names match the patterns given and aren't in
+// the same list in the desired pod spec, returning the names removed.
+func removeInjected(live, desired map[string]interface{}, field string, patterns []string) map[string]bool {
+	if len(patterns) == 0 {
+		return nil
+	}
+	inManifest := map[string]bool{}
+	if items, ok := desired[field].([]interface{}); ok {
+		for _, item := range items {
+			inManifest[entryName(item)] = true
+		}
+	}
+	injected := map[string]bool{}
+	items, _ := live[field].([]interface{})
+	for _, item := range items {
+		name := entryName(item)
+		if name == "" || inManifest[name] {
+			continue
+		}
+		for _, pattern := range patterns {
+			if glob.Glob(pattern, name) {
+				injected[name] = true
+				break
+			}
+		}
+	}
+	removeNamed(live, field, injected)
+	return injected
+}
+
+// removeNamed removes the entries with the names given from the list
+// field given.
+func removeNamed(obj map[string]interface{}, field string, names map[string]bool) {
+	items, ok := obj[field].([]interface{})
+	if !ok || len(names) == 0 {
+		return
+	}
+	var kept []interface{}
+	for _, item := range items {
+		if !names[entryName(item)] {
+			kept = append(kept, item)
+		}
+	}
+	if len(kept) == 0 {
+		delete(obj, field)
+		return
+	}
+	obj[field] = kept
+}
+
+func entryName(item interface{}) string {
+	if m, ok := item.(map[string]interface{}); ok {
+		if name, ok := m["name"].(string); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+func nestedMap(obj map[string]interface{}, path []string) (map[string]interface{}, bool) {
+	for _, field := range path {
+		next, ok := obj[field].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		obj = next
+	}
+	return obj, true
+}
@@ -3,7 +3,9 @@ package kubernetes
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	k8syaml "github.com/ghodss/yaml"
 	"github.com/go-kit/kit/log"
@@ -11,6 +13,8 @@ import (
 	apiv1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/discovery"
 	k8sclientdynamic "k8s.io/client-go/dynamic"
 	k8sclient "k8s.io/client-go/kubernetes"
@@ -83,6 +87,54 @@ func isAddon(obj k8sObject) bool {
 type Cluster struct {
 	// Do garbage collection when syncing resources
 	GC bool
+	// If non-zero, resources are only garbage collected once they
+	// have been missing from the sync set for at least this long
+	GCGracePeriod time.Duration
+	// If not nil, only resources matching this selector (as well as
+	// carrying fluxd's own garbage collection mark) are garbage
+	// collected; others are left alone, even if missing from the sync
+	// set
+	GCSelector labels.Selector
+	// If not empty, only resources of these kinds are garbage
+	// collected, and only these kinds are listed when looking for
+	// resources to garbage collect; see ParseGCKinds
+	GCKinds []schema.GroupVersionKind
+	// How long a resource to be garbage collected may be terminating
+	// (e.g., waiting on finalizers) before it's considered stuck;
+	// DefaultStuckDeletionTimeout if zero
+	StuckDeletionTimeout time.Duration
+	// What to do with stuck deletions; StuckDeletionWait (the
+	// default) or StuckDeletionSkip
+	StuckDeletionAction string
+	// Kinds of resource whose finalizers are removed once their
+	// deletion is stuck. This skips whatever clean-up the finalizers
+	// guard, so should be used with care.
+	RemoveFinalizersKinds []string
+	// If not nil, used to decrypt manifests before applying them
+	Decrypter *SOPSDecrypter
+	// If non-nil, references in Secrets to values kept in Vault are
+	// resolved before applying them
+	Secrets *VaultResolver
+	// Fields of Jobs and CronJobs to disregard when deciding whether
+	// they need to be applied
+	BatchIgnoreFields []string
+	// Kinds of resource whose status is applied as given in their
+	// manifests; for others, it's removed; see stripStatus
+	KeepStatusKinds []string
+	// What to do with resources in namespaces that don't exist, and
+	// aren't defined in the manifests; MissingNamespacesApply (the
+	// default), MissingNamespacesFail or MissingNamespacesCreate
+	MissingNamespaces string
+	// Glob patterns for the names of containers and volumes that are
+	// injected into workloads' pod specs (e.g., by a service mesh's
+	// mutating webhook), and so aren't reported as drift when not in
+	// the manifests
+	InjectedContainers []string
+	InjectedVolumes    []string
+	// If not nil, the tunnel through which the API server is reached
+	Tunnel *SSHTunnel
+	// If not nil, used to emit events on synced resources
+	Events *SyncEvents
 
 	client  ExtendedClient
 	applier Applier
@@ -92,15 +144,33 @@ type Cluster struct {
 	sshKeyRing ssh.KeyRing
 
 	// syncErrors keeps a record of all per-resource errors during
-	// the sync from Git repo to the cluster.
-	syncErrors   map[flux.ResourceID]error
+	// the sync from Git repo to the cluster, by sync set, since
+	// each sync set is synced (and fails) apart from the others.
+	syncErrors   map[string]map[flux.ResourceID]error
 	muSyncErrors sync.RWMutex
 
+	// pendingDeletes records when each resource waiting out the
+	// garbage collection grace period was first found to be missing
+	// from its sync set, by sync set.
+	pendingDeletes   map[string]map[flux.ResourceID]time.Time
+	muPendingDeletes sync.RWMutex
+
 	allowedNamespaces []string
 	loggedAllowedNS   map[string]bool // to keep track of whether we've logged a problem with seeing an allowed namespace
 
+	// loggedStatus records the resources that have had their status
+	// removed, so it's logged prominently only once for each
+	loggedStatus   map[flux.ResourceID]bool
+	muLoggedStatus sync.Mutex
+
 	imageExcludeList []string
 	mu               sync.Mutex
+
+	// In a cluster synced to for validation (see ForValidation), the
+	// decrypter and resolver of the cluster proper, used only to
+	// recognise the resources needing them, which are left out
+	skipDecrypter *SOPSDecrypter
+	skipSecrets   *VaultResolver
 }
 
 // NewCluster returns a usable cluster.
@@ -120,6 +190,31 @@ func NewCluster(client ExtendedClient, applier Applier, sshKeyRing ssh.KeyRing,
 
 // --- cluster.Cluster
 
+// ForValidation gives a Cluster for syncing to another cluster (e.g.,
+// staging; see sync.Validation) with the client and applier given,
+// in the same way as this one. The secrets of this cluster aren't
+// given to the other: files encrypted with SOPS, and Secrets with
+// values in Vault, aren't applied to it. Nor does it have this
+// cluster's SSH tunnel or events.
+func (c *Cluster) ForValidation(client ExtendedClient, applier Applier, logger log.Logger) *Cluster {
+	v := NewCluster(client, applier, c.sshKeyRing, logger, c.allowedNamespaces, c.imageExcludeList)
+	v.GC = c.GC
+	v.GCGracePeriod = c.GCGracePeriod
+	v.GCSelector = c.GCSelector
+	v.GCKinds = c.GCKinds
+	v.StuckDeletionTimeout = c.StuckDeletionTimeout
+	v.StuckDeletionAction = c.StuckDeletionAction
+	v.RemoveFinalizersKinds = c.RemoveFinalizersKinds
+	v.BatchIgnoreFields = c.BatchIgnoreFields
+	v.KeepStatusKinds = c.KeepStatusKinds
+	v.MissingNamespaces = c.MissingNamespaces
+	v.InjectedContainers = c.InjectedContainers
+	v.InjectedVolumes = c.InjectedVolumes
+	v.skipDecrypter = c.Decrypter
+	v.skipSecrets = c.Secrets
+	return v
+}
+
 // SomeWorkloads returns the workloads named, missing out any that don't
 // exist in the cluster or aren't in an allowed namespace.
 // They do not necessarily have to be returned in the order requested.
@@ -145,9 +240,8 @@ func (c *Cluster) SomeWorkloads(ids []flux.ResourceID) (res []cluster.Workload,
 		}
 
 		if !isAddon(workload) {
-			c.muSyncErrors.RLock()
-			workload.syncError = c.syncErrors[id]
-			c.muSyncErrors.RUnlock()
+			workload.syncError = c.syncError(id)
+			workload.deleteAfter = c.deleteAfter(id)
 			workloads = append(workloads, workload.toClusterWorkload(id))
 		}
 	}
@@ -187,9 +281,8 @@ func (c *Cluster) AllWorkloads(namespace string) (res []cluster.Workload, err er
 			for _, workload := range workloads {
 				if !isAddon(workload) {
 					id := flux.MakeResourceID(ns.Name, kind, workload.name)
-					c.muSyncErrors.RLock()
-					workload.syncError = c.syncErrors[id]
-					c.muSyncErrors.RUnlock()
+					workload.syncError = c.syncError(id)
+					workload.deleteAfter = c.deleteAfter(id)
 					allworkloads = append(allworkloads, workload.toClusterWorkload(id))
 				}
 			}
@@ -199,16 +292,112 @@ func (c *Cluster) AllWorkloads(namespace string) (res []cluster.Workload, err er
 	return allworkloads, nil
 }
 
-func (c *Cluster) setSyncErrors(errs cluster.SyncError) {
+// deleteAfter gives the time after which the resource will be
+// garbage collected, if it is pending deletion; otherwise, the zero
+// time.
+func (c *Cluster) deleteAfter(id flux.ResourceID) time.Time {
+	c.muPendingDeletes.RLock()
+	defer c.muPendingDeletes.RUnlock()
+	for _, pending := range c.pendingDeletes {
+		if since, ok := pending[id]; ok {
+			return since.Add(c.GCGracePeriod)
+		}
+	}
+	return time.Time{}
+}
+
+// PendingDeletions gives the resources applied by syncs of the sync
+// set named that are waiting out the grace period before being
+// garbage collected, ordered by ID.
+func (c *Cluster) PendingDeletions(syncSetName string) []cluster.PendingDeletion {
+	c.muPendingDeletes.RLock()
+	defer c.muPendingDeletes.RUnlock()
+	var result []cluster.PendingDeletion
+	for id, since := range c.pendingDeletes[syncSetName] {
+		result = append(result, cluster.PendingDeletion{ID: id, DeleteAfter: since.Add(c.GCGracePeriod)})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID.String() < result[j].ID.String()
+	})
+	return result
+}
+
+// syncError gives the error recorded for the resource by the last
+// sync of a sync set that failed to apply it, if any.
+func (c *Cluster) syncError(id flux.ResourceID) error {
+	c.muSyncErrors.RLock()
+	defer c.muSyncErrors.RUnlock()
+	for _, errs := range c.syncErrors {
+		if err, ok := errs[id]; ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncSetErrors gives the errors recorded for the sync set named,
+// creating the record if there isn't one. It must be called with
+// muSyncErrors held.
+func (c *Cluster) syncSetErrors(syncSetName string) map[flux.ResourceID]error {
+	if c.syncErrors == nil {
+		c.syncErrors = make(map[string]map[flux.ResourceID]error)
+	}
+	errs, ok := c.syncErrors[syncSetName]
+	if !ok {
+		errs = make(map[flux.ResourceID]error)
+		c.syncErrors[syncSetName] = errs
+	}
+	return errs
+}
+
+func (c *Cluster) setSyncErrors(syncSetName string, errs cluster.SyncError) {
+	c.muSyncErrors.Lock()
+	defer c.muSyncErrors.Unlock()
+	delete(c.syncErrors, syncSetName)
+	recorded := c.syncSetErrors(syncSetName)
+	for _, e := range errs {
+		recorded[e.ResourceID] = e.Error
+	}
+}
+
+// setNamespaceSyncErrors replaces the recorded sync errors of the
+// sync set named for the resources in the namespace given, leaving
+// those for other namespaces as they were.
+func (c *Cluster) setNamespaceSyncErrors(syncSetName, namespace string, errs cluster.SyncError) {
 	c.muSyncErrors.Lock()
 	defer c.muSyncErrors.Unlock()
-	c.syncErrors = make(map[flux.ResourceID]error)
+	recorded := c.syncSetErrors(syncSetName)
+	for id := range recorded {
+		if inNamespace(id, namespace) {
+			delete(recorded, id)
+		}
+	}
 	for _, e := range errs {
-		c.syncErrors[e.ResourceID] = e.Error
+		recorded[e.ResourceID] = e.Error
+	}
+}
+
+// setResourceSyncErrors replaces the recorded sync errors of the sync
+// set named for the resources given, leaving those for other
+// resources as they were.
+func (c *Cluster) setResourceSyncErrors(syncSetName string, ids []flux.ResourceID, errs cluster.SyncError) {
+	c.muSyncErrors.Lock()
+	defer c.muSyncErrors.Unlock()
+	recorded := c.syncSetErrors(syncSetName)
+	for _, id := range ids {
+		delete(recorded, id)
+	}
+	for _, e := range errs {
+		recorded[e.ResourceID] = e.Error
 	}
 }
 
 func (c *Cluster) Ping() error {
+	if c.Tunnel != nil {
+		if err := c.Tunnel.Healthy(); err != nil {
+			return err
+		}
+	}
 	_, err := c.client.coreClient.Discovery().ServerVersion()
 	return err
 }
@@ -0,0 +1,253 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// The annotation on the lock ConfigMap recording who holds the
+	// lease, and until when.
+	leaderAnnotation = "flux.weave.works/leader"
+
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// LeaderElectorConfig is used to configure a LeaderElector.
+type LeaderElectorConfig struct {
+	ConfigMapAPI  v1.ConfigMapInterface
+	ConfigMapName string        // e.g., "flux-leader"
+	Identity      string        // unique to this instance; e.g., the pod name
+	LeaseDuration time.Duration // how long a lease lasts without being renewed
+	RetryPeriod   time.Duration // how often to try to acquire or renew the lease
+	Logger        log.Logger
+}
+
+// leaderRecord is what's stored in the lock annotation.
+type leaderRecord struct {
+	HolderIdentity       string    `json:"holderIdentity"`
+	LeaseDurationSeconds int       `json:"leaseDurationSeconds"`
+	AcquireTime          time.Time `json:"acquireTime"`
+	RenewTime            time.Time `json:"renewTime"`
+}
+
+// LeaderElector decides which of several fluxd instances gets to
+// sync, by having them compete for a lease recorded in an annotation
+// on a ConfigMap. (Lease resources would be the natural thing to use,
+// but aren't available in all the API versions we support, nor in the
+// version of client-go vendored, whose tools/leaderelection can't be
+// stopped while it's waiting to acquire the lease.)
+//
+// Updates to the ConfigMap are guarded by its resourceVersion, so
+// only one instance can succeed in taking the lease at a time; the
+// holder must renew the lease before it expires, otherwise another
+// instance will take it over. As with client-go's leader election,
+// each instance times the lease from when it last saw the record
+// change, by its own clock, rather than from the renew time the holder
+// recorded; so clocks that disagree between instances can't make a
+// lease look expired while it's being renewed, or held when it isn't.
+type LeaderElector struct {
+	LeaderElectorConfig
+	// C is signalled whenever this instance gains or loses the lease
+	C chan struct{}
+
+	mu        sync.RWMutex
+	leader    bool
+	lastRenew time.Time
+
+	// the leader record as last seen, and when (by the local clock)
+	// it was first seen like that; only accessed from the goroutine
+	// running Start
+	observedRecord string
+	observedTime   time.Time
+}
+
+func NewLeaderElector(config LeaderElectorConfig) *LeaderElector {
+	if config.LeaseDuration == 0 {
+		config.LeaseDuration = DefaultLeaseDuration
+	}
+	if config.RetryPeriod == 0 {
+		config.RetryPeriod = DefaultRetryPeriod
+	}
+	return &LeaderElector{
+		LeaderElectorConfig: config,
+		C:                   make(chan struct{}, 1),
+	}
+}
+
+// IsLeader reports whether this instance currently holds the lease.
+func (e *LeaderElector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.leader
+}
+
+// Changed returns a channel that is signalled when leadership
+// changes.
+func (e *LeaderElector) Changed() <-chan struct{} {
+	return e.C
+}
+
+// Start competes for the lease until told to stop, at which point
+// the lease is given up (if held) so another instance can take over
+// straight away.
+func (e *LeaderElector) Start(stop <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(e.RetryPeriod)
+	defer ticker.Stop()
+	for {
+		e.tryAcquireOrRenew(time.Now())
+		select {
+		case <-stop:
+			if e.IsLeader() {
+				if err := e.release(); err != nil {
+					e.Logger.Log("err", errors.Wrap(err, "releasing leader lease"))
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *LeaderElector) tryAcquireOrRenew(now time.Time) {
+	ok, err := e.acquireOrRenew(now)
+	if err != nil {
+		e.Logger.Log("err", errors.Wrap(err, "acquiring leader lease"))
+	}
+
+	e.mu.Lock()
+	wasLeader := e.leader
+	switch {
+	case ok:
+		e.leader = true
+		e.lastRenew = now
+	case err == nil:
+		// Someone else holds the lease
+		e.leader = false
+	case e.leader && now.Sub(e.lastRenew) >= e.LeaseDuration:
+		// We couldn't renew the lease in time, so must assume
+		// someone else has (or soon will have) taken it over.
+		e.leader = false
+	}
+	isLeader := e.leader
+	e.mu.Unlock()
+
+	if isLeader != wasLeader {
+		e.Logger.Log("leader", isLeader, "identity", e.Identity)
+		select {
+		case e.C <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// acquireOrRenew tries to take or renew the lease, returning true if
+// it is now held by this instance.
+func (e *LeaderElector) acquireOrRenew(now time.Time) (bool, error) {
+	record := leaderRecord{
+		HolderIdentity:       e.Identity,
+		LeaseDurationSeconds: int(e.LeaseDuration / time.Second),
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+
+	cm, err := e.ConfigMapAPI.Get(e.ConfigMapName, meta_v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		recordBytes, err := json.Marshal(record)
+		if err != nil {
+			return false, err
+		}
+		_, err = e.ConfigMapAPI.Create(&apiv1.ConfigMap{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:        e.ConfigMapName,
+				Annotations: map[string]string{leaderAnnotation: string(recordBytes)},
+			},
+		})
+		if apierrors.IsAlreadyExists(err) {
+			// Someone else got there first
+			return false, nil
+		}
+		if err == nil {
+			e.observedRecord = string(recordBytes)
+			e.observedTime = now
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var current leaderRecord
+	recordStr, ok := cm.Annotations[leaderAnnotation]
+	if ok {
+		if err := json.Unmarshal([]byte(recordStr), &current); err != nil {
+			return false, errors.Wrap(err, "parsing leader record")
+		}
+	}
+	if recordStr != e.observedRecord || e.observedTime.IsZero() {
+		e.observedRecord = recordStr
+		e.observedTime = now
+	}
+	expiry := e.observedTime.Add(time.Duration(current.LeaseDurationSeconds) * time.Second)
+	if current.HolderIdentity != "" && current.HolderIdentity != e.Identity && now.Before(expiry) {
+		return false, nil
+	}
+	if current.HolderIdentity == e.Identity {
+		record.AcquireTime = current.AcquireTime
+	}
+
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+	if cm.Annotations == nil {
+		cm.Annotations = map[string]string{}
+	}
+	cm.Annotations[leaderAnnotation] = string(recordBytes)
+	// This will fail with a conflict if the ConfigMap has been
+	// updated since we fetched it, e.g., because another instance
+	// took the lease.
+	if _, err = e.ConfigMapAPI.Update(cm); err != nil {
+		if apierrors.IsConflict(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	e.observedRecord = string(recordBytes)
+	e.observedTime = now
+	return true, nil
+}
+
+// release gives up the lease, by expiring it.
+func (e *LeaderElector) release() error {
+	cm, err := e.ConfigMapAPI.Get(e.ConfigMapName, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	var current leaderRecord
+	if err := json.Unmarshal([]byte(cm.Annotations[leaderAnnotation]), &current); err != nil {
+		return errors.Wrap(err, "parsing leader record")
+	}
+	if current.HolderIdentity != e.Identity {
+		return nil
+	}
+	current.HolderIdentity = ""
+	recordBytes, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	cm.Annotations[leaderAnnotation] = string(recordBytes)
+	_, err = e.ConfigMapAPI.Update(cm)
+	return err
+}
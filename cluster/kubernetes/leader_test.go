@@ -0,0 +1,112 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	corefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLeaderElection(t *testing.T) {
+	client := corefake.NewSimpleClientset()
+	configMaps := client.CoreV1().ConfigMaps("flux")
+
+	newElector := func(identity string) *LeaderElector {
+		return NewLeaderElector(LeaderElectorConfig{
+			ConfigMapAPI:  configMaps,
+			ConfigMapName: "flux-leader",
+			Identity:      identity,
+			LeaseDuration: 10 * time.Second,
+			Logger:        log.NewNopLogger(),
+		})
+	}
+	a, b := newElector("a"), newElector("b")
+
+	now := time.Now()
+	a.tryAcquireOrRenew(now)
+	if !a.IsLeader() {
+		t.Fatal("expected first instance to become leader")
+	}
+	select {
+	case <-a.Changed():
+	default:
+		t.Error("expected change in leadership to be signalled")
+	}
+
+	b.tryAcquireOrRenew(now.Add(time.Second))
+	if b.IsLeader() {
+		t.Fatal("expected second instance not to become leader while the lease is held")
+	}
+
+	// The leader renews, so keeps the lease
+	a.tryAcquireOrRenew(now.Add(5 * time.Second))
+	b.tryAcquireOrRenew(now.Add(12 * time.Second))
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatal("expected leader to keep the lease by renewing it")
+	}
+
+	// The leader goes away; once the lease expires, timed from when
+	// the other instance saw it renewed, the other instance takes
+	// over
+	b.tryAcquireOrRenew(now.Add(21 * time.Second))
+	if b.IsLeader() {
+		t.Fatal("expected second instance not to take over before the lease expired")
+	}
+	b.tryAcquireOrRenew(now.Add(23 * time.Second))
+	if !b.IsLeader() {
+		t.Fatal("expected second instance to take over once the lease expired")
+	}
+	a.tryAcquireOrRenew(now.Add(24 * time.Second))
+	if a.IsLeader() {
+		t.Fatal("expected first instance to notice it has lost the lease")
+	}
+}
+
+func TestLeaderElectionClockSkew(t *testing.T) {
+	client := corefake.NewSimpleClientset()
+	configMaps := client.CoreV1().ConfigMaps("flux")
+
+	a := NewLeaderElector(LeaderElectorConfig{ConfigMapAPI: configMaps, ConfigMapName: "flux-leader", Identity: "a", LeaseDuration: 10 * time.Second, Logger: log.NewNopLogger()})
+	b := NewLeaderElector(LeaderElectorConfig{ConfigMapAPI: configMaps, ConfigMapName: "flux-leader", Identity: "b", LeaseDuration: 10 * time.Second, Logger: log.NewNopLogger()})
+
+	// The leader's clock is an hour behind; its renewals still count
+	now := time.Now()
+	a.tryAcquireOrRenew(now.Add(-time.Hour))
+	b.tryAcquireOrRenew(now)
+	a.tryAcquireOrRenew(now.Add(-time.Hour + 5*time.Second))
+	b.tryAcquireOrRenew(now.Add(9 * time.Second))
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatal("expected the leader to keep the lease, though its clock is behind")
+	}
+
+	// A leader whose clock is ahead doesn't keep the lease once it
+	// stops renewing it
+	c := NewLeaderElector(LeaderElectorConfig{ConfigMapAPI: client.CoreV1().ConfigMaps("other"), ConfigMapName: "flux-leader", Identity: "c", LeaseDuration: 10 * time.Second, Logger: log.NewNopLogger()})
+	d := NewLeaderElector(LeaderElectorConfig{ConfigMapAPI: client.CoreV1().ConfigMaps("other"), ConfigMapName: "flux-leader", Identity: "d", LeaseDuration: 10 * time.Second, Logger: log.NewNopLogger()})
+	c.tryAcquireOrRenew(now.Add(time.Hour))
+	d.tryAcquireOrRenew(now)
+	d.tryAcquireOrRenew(now.Add(11 * time.Second))
+	if !d.IsLeader() {
+		t.Fatal("expected the other instance to take over once the lease expired, though the leader's clock is ahead")
+	}
+}
+
+func TestLeaderElectionRelease(t *testing.T) {
+	client := corefake.NewSimpleClientset()
+	configMaps := client.CoreV1().ConfigMaps("flux")
+
+	a := NewLeaderElector(LeaderElectorConfig{ConfigMapAPI: configMaps, ConfigMapName: "flux-leader", Identity: "a", Logger: log.NewNopLogger()})
+	b := NewLeaderElector(LeaderElectorConfig{ConfigMapAPI: configMaps, ConfigMapName: "flux-leader", Identity: "b", Logger: log.NewNopLogger()})
+
+	now := time.Now()
+	a.tryAcquireOrRenew(now)
+	if err := a.release(); err != nil {
+		t.Fatal(err)
+	}
+	// Having been given up, the lease can be taken straight away
+	b.tryAcquireOrRenew(now.Add(time.Second))
+	if !b.IsLeader() {
+		t.Fatal("expected second instance to take over a released lease")
+	}
+}
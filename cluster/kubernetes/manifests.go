@@ -1,7 +1,10 @@
 package kubernetes
 
 import (
+	"github.com/go-kit/kit/log"
+
 	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster"
 	kresource "github.com/weaveworks/flux/cluster/kubernetes/resource"
 	"github.com/weaveworks/flux/image"
 	"github.com/weaveworks/flux/resource"
@@ -24,10 +27,46 @@ type namespacer interface {
 // manifests that would be given a default namespace when applied.
 type Manifests struct {
 	Namespacer namespacer
+	// If not nil, used to evaluate `.jsonnet` files into manifests
+	Jsonnet *kresource.Jsonnet
+	// If not nil, used to evaluate CUE packages into manifests, as
+	// well as those loaded from files
+	CUE *kresource.CUE
+	// If true, the paths given are layers, each overriding the ones
+	// before it; see kresource.LoadLayered
+	Layered bool
+	// If non-zero, files larger than this many bytes are skipped
+	// without being parsed, and reported with a
+	// cluster.SkippedFilesError
+	MaxFileSize int64
+	// What to do when a resource is defined more than once; one of
+	// the kresource.Duplicates* values, or empty for
+	// kresource.DuplicatesFail
+	Duplicates string
+	// Rewrites of deprecated API versions, applied to manifests as
+	// they're loaded, so they can still be applied while the files
+	// are migrated
+	APIVersions []kresource.APIVersionRewrite
+	// If not nil, definitions that are ignored in favour of another
+	// definition of the same resource, and API versions that are
+	// rewritten, are logged here
+	Logger log.Logger
 }
 
-func postProcess(manifests map[string]kresource.KubeManifest, nser namespacer) (map[string]resource.Resource, error) {
-	result := map[string]resource.Resource{}
+// duplicateResolver picks which of two definitions of a resource to
+// use.
+type duplicateResolver func(id string, a, b kresource.KubeManifest) (kresource.KubeManifest, error)
+
+// postProcess fills in the namespaces of the manifests given, and
+// indexes them by resource ID. Two manifests can end up with the same
+// ID once they have their namespace (e.g., if one gives the default
+// namespace explicitly, and the other leaves it out); these are
+// resolved with the duplicateResolver given, or if nil, are an error.
+func postProcess(manifests map[string]kresource.KubeManifest, nser namespacer, resolve duplicateResolver) (map[string]resource.Resource, error) {
+	if resolve == nil {
+		resolve = failOnDuplicate
+	}
+	defined := map[string]kresource.KubeManifest{}
 	for _, km := range manifests {
 		if nser != nil {
 			ns, err := nser.EffectiveNamespace(km)
@@ -36,17 +75,100 @@ func postProcess(manifests map[string]kresource.KubeManifest, nser namespacer) (
 			}
 			km.SetNamespace(ns)
 		}
-		result[km.ResourceID().String()] = km
+		id := km.ResourceID().String()
+		if alreadyDefined, ok := defined[id]; ok {
+			used, err := resolve(id, alreadyDefined, km)
+			if err != nil {
+				return nil, err
+			}
+			km = used
+		}
+		defined[id] = km
+	}
+	result := map[string]resource.Resource{}
+	for id, km := range defined {
+		result[id] = km
 	}
 	return result, nil
 }
 
+func failOnDuplicate(id string, a, b kresource.KubeManifest) (kresource.KubeManifest, error) {
+	_, _, err := kresource.ResolveDuplicate(kresource.DuplicatesFail, id, a, b)
+	return nil, err
+}
+
+// resolveDuplicate resolves two definitions of the same resource
+// according to c.Duplicates, logging the one that's ignored.
+func (c *Manifests) resolveDuplicate(id string, a, b kresource.KubeManifest) (kresource.KubeManifest, error) {
+	used, ignored, err := kresource.ResolveDuplicate(c.Duplicates, id, a, b)
+	if err != nil {
+		return nil, err
+	}
+	c.logIgnored(id, used, ignored)
+	return used, nil
+}
+
+func (c *Manifests) logIgnored(id string, used, ignored kresource.KubeManifest) {
+	if c.Logger != nil {
+		c.Logger.Log("warning", "resource is defined more than once; ignoring all but one definition", "resource", id, "used", used.Source(), "ignored", ignored.Source(), "duplicates", c.Duplicates)
+	}
+}
+
+func (c *Manifests) logRewritten(m kresource.KubeManifest, r kresource.APIVersionRewrite) {
+	if c.Logger != nil {
+		c.Logger.Log("info", "rewrote deprecated API version", "resource", m.ResourceID(), "source", m.Source(), "from", r.From, "to", r.To)
+	}
+}
+
 func (c *Manifests) LoadManifests(base string, paths []string) (map[string]resource.Resource, error) {
-	manifests, err := kresource.Load(base, paths)
+	var skipped []cluster.SkippedFile
+	opts := kresource.LoadOptions{
+		Jsonnet:     c.Jsonnet,
+		MaxFileSize: c.MaxFileSize,
+		Skip: func(source string, size int64) {
+			skipped = append(skipped, cluster.SkippedFile{Source: source, Size: size})
+		},
+		Duplicates:  c.Duplicates,
+		Ignore:      c.logIgnored,
+		APIVersions: c.APIVersions,
+		Rewritten:   c.logRewritten,
+	}
+	var manifests map[string]kresource.KubeManifest
+	var err error
+	if c.Layered {
+		manifests, err = kresource.LoadLayered(base, paths, opts)
+	} else {
+		manifests, err = kresource.LoadWithOptions(base, paths, opts)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return postProcess(manifests, c.Namespacer)
+	if c.CUE != nil {
+		evaluated, err := c.CUE.Load(base)
+		if err != nil {
+			return nil, err
+		}
+		for id, obj := range evaluated {
+			r, err := kresource.RewriteAPIVersion(obj, c.APIVersions)
+			if err != nil {
+				return nil, err
+			}
+			if r != nil {
+				c.logRewritten(obj, *r)
+			}
+			if alreadyDefined, ok := manifests[id]; ok {
+				if obj, err = c.resolveDuplicate(id, alreadyDefined, obj); err != nil {
+					return nil, err
+				}
+			}
+			manifests[id] = obj
+		}
+	}
+	result, err := postProcess(manifests, c.Namespacer, c.resolveDuplicate)
+	if err == nil && len(skipped) > 0 {
+		err = &cluster.SkippedFilesError{Files: skipped, Limit: c.MaxFileSize}
+	}
+	return result, err
 }
 
 func (c *Manifests) UpdateImage(def []byte, id flux.ResourceID, container string, image image.Ref) ([]byte, error) {
@@ -0,0 +1,231 @@
+package kubernetes
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster"
+	kresource "github.com/weaveworks/flux/cluster/kubernetes/resource"
+)
+
+// What to do with resources in namespaces that neither exist in the
+// cluster nor are defined in the manifests.
+const (
+	// Apply them anyway, so each fails as kubectl reports (the
+	// default)
+	MissingNamespacesApply = "apply"
+	// Don't apply them, and fail each, listing the missing
+	// namespaces
+	MissingNamespacesFail = "fail"
+	// Create the namespaces first, with autoCreatedLabel
+	MissingNamespacesCreate = "create"
+)
+
+// Namespaces created by fluxd because resources were to be put in
+// them have this label. They're garbage collected, once no manifests
+// refer to them, and fluxd has no resources in them.
+const autoCreatedLabel = kresource.PolicyPrefix + "auto-created"
+
+// The source given for auto-created namespaces, since they aren't in
+// any file
+const autoCreatedSource = "<auto-created>"
+
+// namespaceID gives the resource ID of the namespace given.
+func namespaceID(namespace string) flux.ResourceID {
+	return flux.MakeResourceID(kresource.ClusterScope, "namespace", namespace)
+}
+
+// isAutoCreated says whether the cluster resource is a namespace
+// fluxd created because resources were to be put in it.
+func isAutoCreated(res *kuberesource) bool {
+	return res.obj.GetKind() == "Namespace" && res.obj.GetLabels()[autoCreatedLabel] == "true"
+}
+
+// referencedNamespaces gives the namespaces the resources in the sync
+// set are in (or just its namespace, if it's limited to one), other
+// than those defined among them, with the resources in each.
+func (c *Cluster) referencedNamespaces(syncSet cluster.SyncSet) map[string][]flux.ResourceID {
+	defined := map[flux.ResourceID]bool{}
+	referenced := map[string][]flux.ResourceID{}
+	for _, res := range syncSet.Resources {
+		id := res.ResourceID()
+		if !c.IsAllowedResource(id) {
+			continue
+		}
+		ns, kind, _ := id.Components()
+		if kind == "namespace" {
+			defined[id] = true
+		}
+		if syncSet.Namespace != "" && ns != syncSet.Namespace {
+			continue
+		}
+		if ns != kresource.ClusterScope {
+			referenced[ns] = append(referenced[ns], id)
+		}
+	}
+	for ns := range referenced {
+		if defined[namespaceID(ns)] {
+			delete(referenced, ns)
+		}
+	}
+	return referenced
+}
+
+// ensureNamespaces deals with the namespaces the resources in the
+// sync set refer to, but that aren't defined among them, according
+// to MissingNamespaces. Namespaces auto-created at an earlier sync
+// are recorded in the checksums, so they're not garbage collected
+// while still referred to. It returns the errors for resources not
+// applied because their namespace is missing.
+func (c *Cluster) ensureNamespaces(logger log.Logger, syncSet cluster.SyncSet, clusterResources map[string]*kuberesource, cs *changeSet, checksums map[string]string) cluster.SyncError {
+	referenced := c.referencedNamespaces(syncSet)
+	var missing []string
+	for ns := range referenced {
+		id := namespaceID(ns)
+		if cres, ok := clusterResources[id.String()]; ok {
+			if isAutoCreated(cres) {
+				checksums[id.String()] = cres.GetChecksum()
+			}
+			continue
+		}
+		missing = append(missing, ns)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+
+	switch c.MissingNamespaces {
+	case MissingNamespacesCreate:
+		var errs cluster.SyncError
+		for _, ns := range missing {
+			id := namespaceID(ns)
+			manifest := fmt.Sprintf("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: %s\n  labels:\n    %s: \"true\"\n", ns, autoCreatedLabel)
+			manifests, err := kresource.ParseMultidoc([]byte(manifest), autoCreatedSource)
+			if err != nil {
+				errs = append(errs, cluster.ResourceError{ResourceID: id, Source: autoCreatedSource, Error: err})
+				continue
+			}
+			csum := sha1.Sum([]byte(manifest))
+			checkHex := hex.EncodeToString(csum[:])
+			payload, err := applyMetadata(manifests[id.String()], syncSet.Name, checkHex)
+			if err != nil {
+				errs = append(errs, cluster.ResourceError{ResourceID: id, Source: autoCreatedSource, Error: err})
+				continue
+			}
+			checksums[id.String()] = checkHex
+			cs.stage("apply", id, autoCreatedSource, 0, payload)
+			logger.Log("info", "creating namespace that resources are to be put in, since it does not exist", "namespace", ns, "resources", len(referenced[ns]))
+		}
+		return errs
+	case MissingNamespacesFail:
+		missingSet := map[string]bool{}
+		for _, ns := range missing {
+			missingSet[ns] = true
+		}
+		var errs cluster.SyncError
+		var staged []applyObject
+		for _, obj := range cs.objs["apply"] {
+			ns, _, _ := obj.ResourceID.Components()
+			if !missingSet[ns] {
+				staged = append(staged, obj)
+				continue
+			}
+			errs = append(errs, cluster.ResourceError{
+				ResourceID: obj.ResourceID,
+				Source:     obj.Source,
+				Error:      fmt.Errorf("not applied, since namespace %q does not exist, and is not defined in the manifests", ns),
+			})
+		}
+		cs.objs["apply"] = staged
+		logger.Log("err", "not applying resources in namespaces that don't exist", "namespaces", strings.Join(missing, ","), "resources", len(errs))
+		return errs
+	}
+	return nil
+}
+
+// namespaceIsEmpty says whether the namespace given has nothing in
+// it, of any kind, whether synced by fluxd or not, other than what
+// Kubernetes puts in every namespace. A kind that can't be listed
+// means the namespace can't be known to be empty.
+func (c *Cluster) namespaceIsEmpty(namespace string) (bool, error) {
+	resources, err := c.client.discoveryClient.ServerResources()
+	if err != nil {
+		return false, err
+	}
+	for _, resource := range resources {
+		groupVersion, err := schema.ParseGroupVersion(resource.GroupVersion)
+		if err != nil {
+			return false, err
+		}
+		for _, apiResource := range resource.APIResources {
+			// Subresources (e.g., pods/log) aren't listed separately
+			if !apiResource.Namespaced || strings.Contains(apiResource.Name, "/") || !hasVerb(apiResource.Verbs, "list") {
+				continue
+			}
+			if apiResource.Kind == "Event" {
+				continue
+			}
+			gvr := groupVersion.WithResource(apiResource.Name)
+			list, err := c.client.dynamicClient.Resource(gvr).Namespace(namespace).List(meta_v1.ListOptions{})
+			if err != nil {
+				return false, errors.Wrapf(err, "listing %s in namespace %s", gvr, namespace)
+			}
+			for _, item := range list.Items {
+				if !isNamespaceDefault(item) {
+					return false, nil
+				}
+			}
+		}
+	}
+	return true, nil
+}
+
+// isNamespaceDefault says whether the resource is one of those that
+// Kubernetes creates in every namespace.
+func isNamespaceDefault(obj unstructured.Unstructured) bool {
+	switch obj.GetKind() {
+	case "ServiceAccount":
+		return obj.GetName() == "default"
+	case "ConfigMap":
+		return obj.GetName() == "kube-root-ca.crt"
+	case "Secret":
+		secretType, _, _ := unstructured.NestedString(obj.Object, "type")
+		return secretType == "kubernetes.io/service-account-token" && obj.GetAnnotations()["kubernetes.io/service-account.name"] == "default"
+	}
+	return false
+}
+
+func hasVerb(verbs []string, verb string) bool {
+	for _, v := range verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+// keepAutoCreatedNamespace says whether an auto-created namespace,
+// no longer referred to, has to be kept for now because there are
+// still resources in it; deleting it would delete them too.
+func (c *Cluster) keepAutoCreatedNamespace(logger log.Logger, res *kuberesource) bool {
+	empty, err := c.namespaceIsEmpty(res.obj.GetName())
+	if err != nil {
+		logger.Log("warning", "not deleting auto-created namespace; could not check that it is empty", "resource", res.ResourceID(), "err", err)
+		return true
+	}
+	if !empty {
+		logger.Log("info", "not deleting auto-created namespace yet; there are still resources in it", "resource", res.ResourceID())
+	}
+	return !empty
+}
@@ -14,32 +14,40 @@ import (
 
 func (m *Manifests) UpdatePolicies(def []byte, id flux.ResourceID, update policy.Update) ([]byte, error) {
 	ns, kind, name := id.Components()
-	add, del := update.Add, update.Remove
 
 	// We may be sent the pseudo-policy `policy.TagAll`, which means
 	// apply this filter to all containers. To do so, we need to know
 	// what all the containers are.
-	if tagAll, ok := update.Add.Get(policy.TagAll); ok {
-		add = add.Without(policy.TagAll)
+	if _, ok := update.Add.Get(policy.TagAll); ok {
 		containers, err := extractContainers(def, id)
 		if err != nil {
 			return nil, err
 		}
-
-		for _, container := range containers {
-			if tagAll == policy.PatternAll.String() {
-				del = del.Add(policy.TagPrefix(container.Name))
-			} else {
-				add = add.Set(policy.TagPrefix(container.Name), tagAll)
-			}
+		names := make([]string, len(containers))
+		for i, container := range containers {
+			names[i] = container.Name
 		}
+		update = update.ExpandTagAll(names)
 	}
+	add, del := update.Add, update.Remove
 
 	var args []string
 	for pol, val := range add {
 		if policy.Tag(pol) && !policy.NewPattern(val).Valid() {
 			return nil, fmt.Errorf("invalid tag pattern: %q", val)
 		}
+		if pol == policy.AutomationSchedule {
+			if _, err := policy.ParseSchedule(val); err != nil {
+				return nil, fmt.Errorf("invalid automation schedule: %s", err)
+			}
+		}
+		if policy.TagExclude(pol) {
+			for _, exclude := range policy.NewPatterns(val) {
+				if !exclude.Valid() {
+					return nil, fmt.Errorf("invalid tag exclusion pattern: %q", exclude)
+				}
+			}
+		}
 		args = append(args, fmt.Sprintf("%s%s=%s", kresource.PolicyPrefix, pol, val))
 	}
 	for pol, _ := range del {
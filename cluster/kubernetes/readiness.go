@@ -0,0 +1,316 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/jsonpath"
+
+	"github.com/weaveworks/flux"
+	kresource "github.com/weaveworks/flux/cluster/kubernetes/resource"
+	"github.com/weaveworks/flux/resource"
+)
+
+// ReadinessCheck decides whether a resource is ready, from what the
+// API server reports for it; it's for kinds of resource (typically
+// custom resources) that flux doesn't otherwise know how to tell are
+// ready. It's written in the style of `kubectl wait --for`, as one or
+// more terms joined by `&&`, all of which must hold:
+//
+//   - `condition=Ready` holds when the resource has a status
+//     condition of type Ready with status "True", and
+//     `condition=Ready=False` when it has status "False";
+//   - `jsonpath={.status.phase}=Running` holds when the field at the
+//     path has the value given;
+//   - `jsonpath={.status.ready}` holds when the field at the path is
+//     there, and isn't false, zero or empty.
+//
+// Paths are JSONPath templates as kubectl uses them (see
+// k8s.io/client-go/util/jsonpath), so can have fields, indexes,
+// slices, recursive descent and filters
+// (`.conditions[?(@.type=="Synced")]`). A path that matches more than
+// one value holds only if each of them does.
+type ReadinessCheck struct {
+	expr  string
+	terms []readinessTerm
+}
+
+type readinessTerm struct {
+	// the path of the field, as given, and as a JSONPath template
+	path     string
+	template string
+	// the value the field must have, if hasValue; otherwise, it must
+	// be truthy
+	value    string
+	hasValue bool
+}
+
+// DefaultReadinessChecks are the readiness checks used for common
+// kinds of custom resource, by kind and API group; they can be
+// overridden with Kubectl.ReadinessChecks.
+var DefaultReadinessChecks = map[string]string{
+	"helmrelease.flux.weave.works":                            "condition=Released",
+	"helmrelease.helm.fluxcd.io":                              "condition=Released",
+	"kustomization.kustomize.toolkit.fluxcd.io":               "condition=Ready",
+	"certificate.cert-manager.io":                             "condition=Ready",
+	"issuer.cert-manager.io":                                  "condition=Ready",
+	"clusterissuer.cert-manager.io":                           "condition=Ready",
+	"apiservice.apiregistration.k8s.io":                       "condition=Available",
+	"provider.pkg.crossplane.io":                              "condition=Installed&&condition=Healthy",
+	"configuration.pkg.crossplane.io":                         "condition=Installed&&condition=Healthy",
+	"compositeresourcedefinition.apiextensions.crossplane.io": "condition=Established",
+}
+
+var defaultReadinessChecks = mustParseReadinessChecks(DefaultReadinessChecks)
+
+func mustParseReadinessChecks(exprs map[string]string) map[string]ReadinessCheck {
+	checks := map[string]ReadinessCheck{}
+	for kind, expr := range exprs {
+		check, err := ParseReadinessCheck(expr)
+		if err != nil {
+			panic(fmt.Sprintf("readiness check for %s: %v", kind, err))
+		}
+		checks[kind] = check
+	}
+	return checks
+}
+
+// ParseReadinessCheck parses an expression as described for
+// ReadinessCheck.
+func ParseReadinessCheck(expr string) (ReadinessCheck, error) {
+	check := ReadinessCheck{expr: expr}
+	for _, t := range strings.Split(expr, "&&") {
+		term, err := parseReadinessTerm(strings.TrimSpace(t))
+		if err != nil {
+			return check, err
+		}
+		check.terms = append(check.terms, term)
+	}
+	return check, nil
+}
+
+func parseReadinessTerm(s string) (readinessTerm, error) {
+	switch {
+	case strings.HasPrefix(s, "condition="):
+		cond := strings.TrimPrefix(s, "condition=")
+		status := "True"
+		if parts := strings.SplitN(cond, "=", 2); len(parts) == 2 {
+			cond, status = parts[0], parts[1]
+		}
+		if cond == "" || status == "" {
+			return readinessTerm{}, fmt.Errorf("expected condition=<type>[=<status>], got %q", s)
+		}
+		path := fmt.Sprintf(`.status.conditions[?(@.type==%q)].status`, cond)
+		term := readinessTerm{path: path, template: "{" + path + "}", value: status, hasValue: true}
+		if _, err := term.jsonPath(); err != nil {
+			return readinessTerm{}, fmt.Errorf("in %q: %v", s, err)
+		}
+		return term, nil
+	case strings.HasPrefix(s, "jsonpath="):
+		rest := strings.TrimPrefix(s, "jsonpath=")
+		end := strings.LastIndex(rest, "}")
+		if !strings.HasPrefix(rest, "{") || end < 0 {
+			return readinessTerm{}, fmt.Errorf("expected jsonpath={<path>}[=<value>], got %q", s)
+		}
+		path := rest[1:end]
+		if !strings.HasPrefix(path, ".") {
+			return readinessTerm{}, fmt.Errorf("in %q: path should start with '.'", s)
+		}
+		term := readinessTerm{path: path, template: rest[:end+1]}
+		if _, err := term.jsonPath(); err != nil {
+			return readinessTerm{}, fmt.Errorf("in %q: %v", s, err)
+		}
+		if value := rest[end+1:]; value != "" {
+			if !strings.HasPrefix(value, "=") || len(value) == 1 {
+				return readinessTerm{}, fmt.Errorf("expected jsonpath={<path>}[=<value>], got %q", s)
+			}
+			term.value, term.hasValue = value[1:], true
+		}
+		return term, nil
+	}
+	return readinessTerm{}, fmt.Errorf("expected condition=... or jsonpath=..., got %q", s)
+}
+
+// jsonPath parses the term's template. It's parsed afresh for each
+// use, since a parsed JSONPath keeps state while it's evaluated, and
+// checks may be evaluated at the same time.
+func (t readinessTerm) jsonPath() (*jsonpath.JSONPath, error) {
+	jp := jsonpath.New(t.path).AllowMissingKeys(true)
+	if err := jp.Parse(t.template); err != nil {
+		return nil, err
+	}
+	return jp, nil
+}
+
+// lookup gives the values at the term's path in the object given.
+func (t readinessTerm) lookup(object interface{}) ([]string, error) {
+	jp, err := t.jsonPath()
+	if err != nil {
+		return nil, err
+	}
+	results, err := jp.FindResults(object)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, result := range results {
+		for _, v := range result {
+			if !v.IsValid() {
+				continue
+			}
+			values = append(values, stringValue(v.Interface()))
+		}
+	}
+	return values, nil
+}
+
+func stringValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		bytes, _ := json.Marshal(v)
+		return string(bytes)
+	}
+}
+
+// ready evaluates the check against the object given (as decoded
+// from JSON); if it's not ready, it gives a reason.
+func (r ReadinessCheck) ready(object interface{}) (bool, string) {
+	for _, term := range r.terms {
+		values, err := term.lookup(object)
+		if err != nil {
+			return false, fmt.Sprintf("cannot evaluate %s: %v", term.path, err)
+		}
+		if term.hasValue {
+			if len(values) == 0 {
+				return false, fmt.Sprintf("has no %s (expected %q)", term.path, term.value)
+			}
+			for _, s := range values {
+				if s != term.value {
+					return false, fmt.Sprintf("has %s %q (expected %q)", term.path, s, term.value)
+				}
+			}
+			continue
+		}
+		if len(values) == 0 {
+			return false, fmt.Sprintf("does not have %s set", term.path)
+		}
+		for _, s := range values {
+			if s == "" || s == "false" || s == "0" {
+				return false, fmt.Sprintf("does not have %s set", term.path)
+			}
+		}
+	}
+	return true, ""
+}
+
+func (r ReadinessCheck) String() string {
+	return r.expr
+}
+
+// readinessCheckFor gives the readiness check for the kind and API
+// version given, if there is one: from those given (by kind and API
+// group, or by kind alone), or otherwise from the defaults.
+func readinessCheckFor(checks map[string]ReadinessCheck, kind, apiVersion string) (ReadinessCheck, bool) {
+	kind = strings.ToLower(kind)
+	group := ""
+	if parts := strings.SplitN(apiVersion, "/", 2); len(parts) == 2 {
+		group = strings.ToLower(parts[0])
+	}
+	withGroup := kind
+	if group != "" {
+		withGroup = kind + "." + group
+	}
+	if check, ok := checks[withGroup]; ok {
+		return check, true
+	}
+	if check, ok := checks[kind]; ok {
+		return check, true
+	}
+	check, ok := defaultReadinessChecks[withGroup]
+	return check, ok
+}
+
+// NotReady gives the reason each of the resources given that has a
+// readiness check (see ReadinessCheck) isn't ready, going by the
+// resource in the cluster. Those without a check, and those not in
+// the cluster, are left out.
+func (c *Cluster) NotReady(resources []resource.Resource) (map[flux.ResourceID]string, error) {
+	var checks map[string]ReadinessCheck
+	if kubectl, ok := c.applier.(*Kubectl); ok {
+		checks = kubectl.ReadinessChecks
+	}
+	reasons := map[flux.ResourceID]string{}
+	for _, res := range resources {
+		id := res.ResourceID()
+		manifest, ok := res.(kresource.KubeManifest)
+		if !ok || !c.IsAllowedResource(id) {
+			continue
+		}
+		if _, ok := readinessCheckFor(checks, manifest.GetKind(), manifest.GroupVersion()); !ok {
+			continue
+		}
+		object, err := c.getObject(manifest.GroupVersion(), manifest.GetKind(), id)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting %s to check it's ready", id)
+		}
+		ready, reason, err := isReady(object, checks)
+		if err != nil {
+			return nil, errors.Wrapf(err, "checking %s is ready", id)
+		}
+		if !ready {
+			reasons[id] = reason
+		}
+	}
+	return reasons, nil
+}
+
+// getObject gets the resource given, of the API version and kind
+// given, from the cluster, as JSON.
+func (c *Cluster) getObject(groupVersion, kind string, id flux.ResourceID) ([]byte, error) {
+	obj, err := c.getUnstructured(groupVersion, kind, id)
+	if err != nil {
+		return nil, err
+	}
+	return obj.MarshalJSON()
+}
+
+// getUnstructured gets the resource given, of the API version and
+// kind given, from the cluster.
+func (c *Cluster) getUnstructured(groupVersion, kind string, id flux.ResourceID) (*unstructured.Unstructured, error) {
+	gv, err := schema.ParseGroupVersion(groupVersion)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := c.client.discoveryClient.ServerResourcesForGroupVersion(groupVersion)
+	if err != nil {
+		return nil, err
+	}
+	namespace, _, name := id.Components()
+	for _, apiResource := range resources.APIResources {
+		if apiResource.Kind != kind || strings.Contains(apiResource.Name, "/") {
+			continue
+		}
+		client := c.client.dynamicClient.Resource(gv.WithResource(apiResource.Name))
+		if apiResource.Namespaced {
+			return client.Namespace(namespace).Get(name, meta_v1.GetOptions{})
+		}
+		return client.Get(name, meta_v1.GetOptions{})
+	}
+	return nil, fmt.Errorf("no API resource for kind %s in %s", kind, groupVersion)
+}
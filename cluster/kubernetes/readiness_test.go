@@ -0,0 +1,109 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseReadinessCheck(t *testing.T) {
+	for _, expr := range []string{
+		"condition=Ready",
+		"condition=Ready=False",
+		"jsonpath={.status.phase}=Running",
+		"jsonpath={.status.ready}",
+		`jsonpath={.status.conditions[?(@.type=="Synced")].status}=True && condition=Ready`,
+		"jsonpath={.status.replicas[0]}=1",
+		"jsonpath={.status..phase}=Running",
+		`jsonpath={.status.conditions[?(@.type!="Ready")].status}=True`,
+	} {
+		if _, err := ParseReadinessCheck(expr); err != nil {
+			t.Errorf("expected %q to parse, got %v", expr, err)
+		}
+	}
+	for _, expr := range []string{
+		"",
+		"ready",
+		"condition=",
+		"jsonpath=.status.phase",
+		"jsonpath={status.phase}",
+		"jsonpath={.status.phase}Running",
+		"jsonpath={}",
+		"jsonpath={.status.conditions[?(@.type==Ready)]}",
+		"jsonpath={.status.conditions[x]}",
+		"condition=Ready&&",
+	} {
+		if _, err := ParseReadinessCheck(expr); err == nil {
+			t.Errorf("expected %q not to parse", expr)
+		}
+	}
+}
+
+func TestReadinessCheckReady(t *testing.T) {
+	var object interface{}
+	if err := json.Unmarshal([]byte(`{
+  "status": {
+    "phase": "Running",
+    "ready": true,
+    "replicas": [3],
+    "count": 0,
+    "conditions": [
+      {"type": "Synced", "status": "True"},
+      {"type": "Ready", "status": "False"}
+    ]
+  }
+}`), &object); err != nil {
+		t.Fatal(err)
+	}
+	for expr, expected := range map[string]bool{
+		"condition=Synced":                                               true,
+		"condition=Ready":                                                false,
+		"condition=Ready=False":                                          true,
+		"condition=Missing":                                              false,
+		"jsonpath={.status.phase}=Running":                               true,
+		"jsonpath={.status.phase}=Pending":                               false,
+		"jsonpath={.status.ready}":                                       true,
+		"jsonpath={.status.count}":                                       false,
+		"jsonpath={.status.missing}":                                     false,
+		"jsonpath={.status.replicas[0]}=3":                               true,
+		"condition=Synced&&condition=Ready":                              false,
+		"condition=Synced&&jsonpath={.status.ready}":                     true,
+		"jsonpath={..phase}=Running":                                     true,
+		"jsonpath={.status.conditions[*].status}":                        true,
+		"jsonpath={.status.conditions[*].status}=True":                   false,
+		`jsonpath={.status.conditions[?(@.status=="True")].type}=Synced`: true,
+	} {
+		check, err := ParseReadinessCheck(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ready, reason := check.ready(object)
+		if ready != expected {
+			t.Errorf("expected ready=%v for %q (reason: %q)", expected, expr, reason)
+		}
+		if !ready && reason == "" {
+			t.Errorf("expected a reason for not being ready, for %q", expr)
+		}
+	}
+}
+
+func TestReadinessCheckFor(t *testing.T) {
+	byKind, _ := ParseReadinessCheck("condition=Available")
+	byGroup, _ := ParseReadinessCheck("condition=Issued")
+	checks := map[string]ReadinessCheck{
+		"certificate":             byKind,
+		"certificate.example.com": byGroup,
+	}
+
+	if check, ok := readinessCheckFor(checks, "Certificate", "example.com/v1"); !ok || check.String() != byGroup.String() {
+		t.Errorf("expected the check for the kind and group, got %q", check)
+	}
+	if check, ok := readinessCheckFor(checks, "Certificate", "cert-manager.io/v1"); !ok || check.String() != byKind.String() {
+		t.Errorf("expected the check for the kind to take precedence over the default, got %q", check)
+	}
+	if check, ok := readinessCheckFor(nil, "Certificate", "cert-manager.io/v1"); !ok || check.String() != DefaultReadinessChecks["certificate.cert-manager.io"] {
+		t.Errorf("expected the default check, got %q", check)
+	}
+	if _, ok := readinessCheckFor(nil, "Widget", "example.com/v1"); ok {
+		t.Error("expected no check for a kind without one")
+	}
+}
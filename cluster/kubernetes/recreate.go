@@ -0,0 +1,119 @@
+package kubernetes
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v2"
+
+	kresource "github.com/weaveworks/flux/cluster/kubernetes/resource"
+	fluxmetrics "github.com/weaveworks/flux/metrics"
+)
+
+// Resources of kinds that hold state must also carry this
+// annotation, with the value "true", to be recreated.
+const recreateAnnotation = kresource.PolicyPrefix + "recreate"
+
+var (
+	recreations = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "cluster",
+		Name:      "recreations_total",
+		Help:      "Count of resources deleted and created again because a change to them couldn't be applied.",
+	}, []string{"kind", fluxmetrics.LabelSuccess})
+)
+
+// Messages the API server gives when a change can't be made to an
+// existing resource.
+var immutableFieldMessages = []string{
+	"field is immutable",
+	"may not change once set",
+	"Forbidden: updates to",
+}
+
+func isImmutableFieldError(err error) bool {
+	for _, msg := range immutableFieldMessages {
+		if strings.Contains(err.Error(), msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// isStatefulKind reports whether deleting a resource of the kind
+// given is likely to lose data.
+func isStatefulKind(kind string) bool {
+	switch strings.ToLower(kind) {
+	case "namespace", "persistentvolume", "persistentvolumeclaim", "statefulset":
+		return true
+	}
+	return false
+}
+
+// mayRecreate decides whether the object given can be deleted and
+// created again, returning an error saying why not if it can't.
+func (c *Kubectl) mayRecreate(obj applyObject) error {
+	_, kind, _ := obj.ResourceID.Components()
+	optedIn := false
+	for _, k := range c.RecreateKinds {
+		if strings.ToLower(k) == kind {
+			optedIn = true
+			break
+		}
+	}
+	if !optedIn {
+		return fmt.Errorf("kind %s is not configured to be recreated", kind)
+	}
+	if isStatefulKind(kind) {
+		ok, err := recreateAnnotated(obj)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("kind %s holds state, so the resource must be annotated %s: \"true\" to be recreated", kind, recreateAnnotation)
+		}
+	}
+	return nil
+}
+
+// recreateAnnotated reports whether the object given has been
+// annotated as safe to recreate.
+func recreateAnnotated(obj applyObject) (bool, error) {
+	var manifest struct {
+		Metadata struct {
+			Annotations map[string]string `yaml:"annotations"`
+		} `yaml:"metadata"`
+	}
+	if err := yaml.Unmarshal(obj.Payload, &manifest); err != nil {
+		return false, err
+	}
+	return manifest.Metadata.Annotations[recreateAnnotation] == "true", nil
+}
+
+// recreate deletes the object given and applies it again, after
+// applying it failed with applyErr because of a change to an
+// immutable field. If the object can't be recreated, applyErr is
+// returned.
+func (c *Kubectl) recreate(logger log.Logger, obj applyObject, applyErr error) (string, error) {
+	if err := c.mayRecreate(obj); err != nil {
+		logger.Log("info", "not recreating resource", "resource", obj.ResourceID, "reason", err)
+		return "", applyErr
+	}
+	_, kind, _ := obj.ResourceID.Components()
+	logger.Log("warning", "deleting and recreating resource, since a change to an immutable field could not be applied", "resource", obj.ResourceID, "source", obj.Source, "err", applyErr)
+
+	var err error
+	defer func() {
+		recreations.With("kind", kind, fluxmetrics.LabelSuccess, fmt.Sprint(err == nil)).Add(1)
+	}()
+	if _, err = c.doCommand(logger, bytes.NewReader(obj.Payload), "delete"); err != nil {
+		return "", err
+	}
+	var output string
+	output, err = c.doCommand(logger, bytes.NewReader(obj.Payload), "apply")
+	return output, err
+}
@@ -0,0 +1,44 @@
+package kubernetes
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/weaveworks/flux"
+)
+
+func TestIsImmutableFieldError(t *testing.T) {
+	for msg, expected := range map[string]bool{
+		`The Service "foo" is invalid: spec.clusterIP: Invalid value: "": field is immutable`:                                                                             true,
+		`The Job "bar" is invalid: spec.selector: Invalid value: ...: field is immutable`:                                                                                 true,
+		`The StatefulSet "baz" is invalid: spec: Forbidden: updates to statefulset spec for fields other than 'replicas', 'template', and 'updateStrategy' are forbidden`: true,
+		`error: unable to recognize "STDIN": no matches for kind "Thing"`:                                                                                                 false,
+	} {
+		if isImmutableFieldError(errors.New(msg)) != expected {
+			t.Errorf("expected %v for %q", expected, msg)
+		}
+	}
+}
+
+func TestMayRecreate(t *testing.T) {
+	kubectl := &Kubectl{RecreateKinds: []string{"Service", "statefulset"}}
+	object := func(id string, annotations string) applyObject {
+		return applyObject{
+			ResourceID: flux.MustParseResourceID(id),
+			Payload:    []byte("metadata:\n  annotations:\n" + annotations),
+		}
+	}
+
+	if err := kubectl.mayRecreate(object("default:service/foo", "")); err != nil {
+		t.Errorf("expected service to be recreated, got %v", err)
+	}
+	if err := kubectl.mayRecreate(object("default:job/foo", "")); err == nil {
+		t.Error("expected job not to be recreated, since it is not opted in")
+	}
+	if err := kubectl.mayRecreate(object("default:statefulset/foo", "")); err == nil {
+		t.Error("expected statefulset not to be recreated without annotation")
+	}
+	if err := kubectl.mayRecreate(object("default:statefulset/foo", "    flux.weave.works/recreate: \"true\"\n")); err != nil {
+		t.Errorf("expected annotated statefulset to be recreated, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package resource
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// APIVersionRewrite says to give manifests of a kind (or of any kind,
+// if Kind is empty) with the API version From the API version To
+// instead, e.g., so that Deployments still given as
+// extensions/v1beta1 are applied as apps/v1 once the cluster no
+// longer serves extensions/v1beta1.
+type APIVersionRewrite struct {
+	Kind string
+	From string
+	To   string
+}
+
+func (r APIVersionRewrite) String() string {
+	if r.Kind == "" {
+		return r.From + "=" + r.To
+	}
+	return r.Kind + ":" + r.From + "=" + r.To
+}
+
+// ParseAPIVersionRewrite parses a rewrite given as
+// `[<kind>:]<from>=<to>`, e.g., `Deployment:extensions/v1beta1=apps/v1`.
+func ParseAPIVersionRewrite(s string) (APIVersionRewrite, error) {
+	var r APIVersionRewrite
+	versions := s
+	if i := strings.Index(s, ":"); i >= 0 {
+		r.Kind, versions = s[:i], s[i+1:]
+		if r.Kind == "" {
+			return r, fmt.Errorf("API version rewrite %q has an empty kind", s)
+		}
+	}
+	parts := strings.Split(versions, "=")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return r, fmt.Errorf("API version rewrite %q should be given as [<kind>:]<from>=<to>", s)
+	}
+	r.From, r.To = parts[0], parts[1]
+	if r.From == r.To {
+		return r, fmt.Errorf("API version rewrite %q doesn't change the API version", s)
+	}
+	return r, nil
+}
+
+// The top-level apiVersion field of a manifest (each item of a List
+// has its own bytes, so this applies to items too).
+var apiVersionLine = regexp.MustCompile(`(?m)^apiVersion:[ \t]*["']?([^"'\s#]+)["']?[ \t]*(#.*)?\r?$`)
+
+// RewriteAPIVersion gives the manifest the API version from the
+// first of the rewrites that applies to it, if any, changing both
+// what's reported by GroupVersion and the bytes that are applied
+// (though not the file it came from). It returns the rewrite used, or
+// nil if none applies; and an error if one applies but the manifest
+// can't be rewritten, e.g., because it's JSON rather than YAML.
+func RewriteAPIVersion(m KubeManifest, rewrites []APIVersionRewrite) (*APIVersionRewrite, error) {
+	for i := range rewrites {
+		r := &rewrites[i]
+		if r.From != m.GroupVersion() || (r.Kind != "" && r.Kind != m.GetKind()) {
+			continue
+		}
+		rw, ok := m.(interface{ rewriteAPIVersion(string) bool })
+		if !ok || !rw.rewriteAPIVersion(r.To) {
+			return nil, fmt.Errorf("cannot rewrite the API version of %s in %s from %s to %s: no top-level apiVersion line", m.ResourceID(), m.Source(), r.From, r.To)
+		}
+		return r, nil
+	}
+	return nil, nil
+}
+
+func (o *baseObject) rewriteAPIVersion(to string) bool {
+	loc := apiVersionLine.FindSubmatchIndex(o.bytes)
+	if loc == nil || string(o.bytes[loc[2]:loc[3]]) != o.APIVersion {
+		return false
+	}
+	// Replace only the version, so that the rest of the line (e.g., a
+	// comment) and the rest of the manifest are left as they are
+	rewritten := make([]byte, 0, len(o.bytes)+len(to))
+	rewritten = append(rewritten, o.bytes[:loc[2]]...)
+	rewritten = append(rewritten, to...)
+	rewritten = append(rewritten, o.bytes[loc[3]:]...)
+	o.bytes = rewritten
+	o.APIVersion = to
+	return true
+}
@@ -0,0 +1,138 @@
+package resource
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CUE evaluates CUE packages into manifests, when loading manifests
+// from a repo. Each package may evaluate to a single manifest, or an
+// array (possibly nested) of manifests; or, given an expression, the
+// value of that expression in the package is used instead.
+//
+// Imports are confined to the repo: the CUE module a package belongs
+// to (the nearest directory above it with a `cue.mod`, looking above
+// the repo too, as cue does; or the package itself if there is none)
+// must be in the repo, and
+// mustn't contain symlinks to anything outside the repo. CUE resolves
+// imports within the module, so this keeps evaluation to the repo.
+type CUE struct {
+	// Path to the cue executable
+	Exe string
+	// Directories, relative to the repo, of the packages to evaluate
+	Packages []string
+	// Tags given to every package, as `name=value` (as with `cue
+	// export -t`)
+	Tags []string
+	// If not empty, the expression in each package that gives the
+	// manifests (as with `cue export -e`)
+	Expression string
+}
+
+// Load evaluates each of the packages in the repo at base, and
+// returns the manifests from them all. The manifests from a package
+// have the package's directory as their source.
+func (c *CUE) Load(base string) (map[string]KubeManifest, error) {
+	root, err := filepath.EvalSymlinks(base)
+	if err != nil {
+		return nil, err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	objs := map[string]KubeManifest{}
+	for _, pkg := range c.Packages {
+		docs, err := c.evaluate(root, pkg)
+		if err != nil {
+			return nil, err
+		}
+		for id, obj := range docs {
+			if alreadyDefined, ok := objs[id]; ok {
+				return nil, fmt.Errorf(`duplicate definition of '%s' (in %s and %s)`, id, alreadyDefined.Source(), obj.Source())
+			}
+			objs[id] = obj
+		}
+	}
+	return objs, nil
+}
+
+// evaluate renders the package in the directory pkg, relative to
+// root, into manifests.
+func (c *CUE) evaluate(root, pkg string) (map[string]KubeManifest, error) {
+	source := filepath.Clean(pkg)
+	dir, err := confine(root, filepath.Join(root, pkg))
+	if err != nil {
+		return nil, errors.Wrapf(err, "CUE package %q", pkg)
+	}
+	if err := checkCUEModule(root, dir); err != nil {
+		return nil, errors.Wrapf(err, "checking CUE package %q", pkg)
+	}
+
+	args := []string{"export", "--out", "json"}
+	if c.Expression != "" {
+		args = append(args, "-e", c.Expression)
+	}
+	for _, tag := range c.Tags {
+		args = append(args, "-t", tag)
+	}
+	args = append(args, ".")
+	cmd := exec.Command(c.Exe, args...)
+	cmd.Dir = dir
+	// Don't let imports be fetched from a module registry; anything
+	// imported must be in the repo.
+	cmd.Env = append(os.Environ(), "CUE_REGISTRY=none")
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("evaluating CUE package %q: %s", source, msg)
+	}
+	return parseEvaluated(stdout.Bytes(), "cue", source)
+}
+
+// checkCUEModule makes sure the CUE module containing the package in
+// dir is within root, and has no symlinks to anything outside root.
+// The module is found as cue finds it, so that it's the same one cue
+// evaluates the package in: the search for a `cue.mod` (a directory,
+// or a file in older modules) goes on above root to the top of the
+// filesystem, and a module found above root is outside the repo.
+func checkCUEModule(root, dir string) error {
+	module := dir
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, "cue.mod")); err == nil {
+			module = d
+			break
+		}
+		if d == filepath.Dir(d) {
+			break
+		}
+	}
+	if _, err := confine(root, module); err != nil {
+		return err
+	}
+	return filepath.Walk(module, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if _, err := confine(root, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
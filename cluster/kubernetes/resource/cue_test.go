@@ -0,0 +1,99 @@
+package resource
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/weaveworks/flux/cluster/kubernetes/testfiles"
+)
+
+func TestCheckCUEModule(t *testing.T) {
+	dir, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+	outside, cleanupOutside := testfiles.TempDir(t)
+	defer cleanupOutside()
+
+	repo := filepath.Join(dir, "repo")
+	for _, d := range []string{
+		filepath.Join(repo, "ok", "cue.mod"),
+		filepath.Join(repo, "ok", "app"),
+		filepath.Join(repo, "escape", "cue.mod", "pkg"),
+		filepath.Join(repo, "escape", "app"),
+	} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(repo, "escape", "cue.mod", "pkg", "example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := filepath.EvalSymlinks(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkCUEModule(root, filepath.Join(root, "ok", "app")); err != nil {
+		t.Errorf("expected module to be allowed, got %v", err)
+	}
+	if err := checkCUEModule(root, filepath.Join(root, "escape", "app")); err == nil {
+		t.Error("expected module with a symlink outside the repo to be rejected")
+	}
+
+	// cue looks for the module above the repo too, so a package in
+	// the repo that isn't in a module of its own is in that one
+	if err := os.MkdirAll(filepath.Join(repo, "loose", "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkCUEModule(root, filepath.Join(root, "loose", "app")); err != nil {
+		t.Errorf("expected package outside any module to be allowed, got %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cue.mod"), []byte("module: \"example.com\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkCUEModule(root, filepath.Join(root, "loose", "app")); err == nil {
+		t.Error("expected package in a module above the repo to be rejected")
+	}
+	if err := checkCUEModule(root, filepath.Join(root, "ok", "app")); err != nil {
+		t.Errorf("expected module in the repo to be allowed, got %v", err)
+	}
+}
+
+func TestCUELoad(t *testing.T) {
+	dir, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+	if err := os.MkdirAll(filepath.Join(dir, "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Stands in for `cue export`, giving the arguments it was run
+	// with as an annotation
+	exe := filepath.Join(dir, "cue")
+	script := `#!/bin/sh
+echo '[{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "config", "namespace": "default", "annotations": {"args": "'"$*"'"}}}]'
+`
+	if err := ioutil.WriteFile(exe, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &CUE{Exe: exe, Packages: []string{"app"}, Tags: []string{"env=prod"}, Expression: "objects"}
+	objs, err := c.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, ok := objs["default:configmap/config"]
+	if !ok {
+		t.Fatalf("expected the ConfigMap to be loaded, got %v", objs)
+	}
+	if obj.Source() != "app" {
+		t.Errorf("expected source %q, got %q", "app", obj.Source())
+	}
+	if !strings.Contains(string(obj.Bytes()), "export --out json -e objects -t env=prod .") {
+		t.Errorf("unexpected arguments to cue: %s", obj.Bytes())
+	}
+
+	if _, err := (&CUE{Exe: exe, Packages: []string{"../elsewhere"}}).Load(dir); err == nil {
+		t.Error("expected a package outside the repo to be rejected")
+	}
+}
@@ -0,0 +1,64 @@
+package resource
+
+import (
+	"fmt"
+	"strings"
+)
+
+// What to do when more than one manifest defines the same resource.
+const (
+	// Refuse to load the manifests, with a *DuplicateError
+	DuplicatesFail = "fail"
+	// Use the definition that comes first, in order of file path
+	// then of position in the file
+	DuplicatesFirst = "first"
+	// Use the definition that comes last, in the same order
+	DuplicatesLast = "last"
+)
+
+// DuplicateError is returned when a resource is defined more than
+// once, and duplicates aren't allowed.
+type DuplicateError struct {
+	ID      string
+	Sources []string
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf(`duplicate definition of '%s' (in %s)`, e.ID, strings.Join(e.Sources, " and "))
+}
+
+// ValidDuplicates says whether the policy given is one of the
+// Duplicates* values, or empty (meaning DuplicatesFail).
+func ValidDuplicates(policy string) bool {
+	switch policy {
+	case "", DuplicatesFail, DuplicatesFirst, DuplicatesLast:
+		return true
+	}
+	return false
+}
+
+// ResolveDuplicate picks which of two definitions of the resource
+// with the ID given to use, according to the policy given. The
+// outcome doesn't depend on which definition was come across first,
+// so it's the same however the files were walked.
+func ResolveDuplicate(policy, id string, a, b KubeManifest) (used, ignored KubeManifest, err error) {
+	first, last := a, b
+	if definedBefore(b, a) {
+		first, last = b, a
+	}
+	switch policy {
+	case DuplicatesFirst:
+		return first, last, nil
+	case DuplicatesLast:
+		return last, first, nil
+	default:
+		return nil, nil, &DuplicateError{ID: id, Sources: []string{first.Source(), last.Source()}}
+	}
+}
+
+func definedBefore(a, b KubeManifest) bool {
+	if a.Source() != b.Source() {
+		return a.Source() < b.Source()
+	}
+	return a.Position() < b.Position()
+}
@@ -0,0 +1,467 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// Jsonnet evaluates `.jsonnet` files into manifests, when loading
+// manifests from a repo. The files may evaluate to a single manifest,
+// or an array (possibly nested) of manifests.
+//
+// Imports are confined to the repo: before a file is evaluated, it
+// and everything it imports are checked for imports of paths outside
+// the repo, and if there are any the file is rejected. (Jsonnet only
+// allows imports of literal strings, so this can be done by lexing
+// the source and decoding the strings imported, without evaluating
+// anything.)
+type Jsonnet struct {
+	// Path to the jsonnet executable
+	Exe string
+	// Directories, relative to the repo, in which to look for
+	// imports (as with `jsonnet -J`)
+	ImportPaths []string
+	// Top-level arguments given to every file, as `name=value`
+	TLAStrs []string
+	// If not nil, gives values to make available to every file, as
+	// the external variable `values` (an object with a string for
+	// each value); e.g., from a ConfigMap in the cluster
+	Values func() (map[string]string, error)
+}
+
+// evaluate renders the file at path (which is under base) into
+// manifests, using source, the path relative to base, to identify
+// them.
+func (j *Jsonnet) evaluate(base, path, source string) (map[string]KubeManifest, error) {
+	root, err := filepath.EvalSymlinks(base)
+	if err != nil {
+		return nil, err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var importDirs []string
+	for _, p := range j.ImportPaths {
+		dir, err := confine(root, filepath.Join(root, p))
+		if err != nil {
+			return nil, errors.Wrapf(err, "jsonnet import path %q", p)
+		}
+		importDirs = append(importDirs, dir)
+	}
+	if err := j.checkImports(root, path, importDirs, map[string]bool{}); err != nil {
+		return nil, errors.Wrapf(err, "checking imports of %q", source)
+	}
+
+	args := []string{}
+	for _, dir := range importDirs {
+		args = append(args, "--jpath", dir)
+	}
+	for _, tla := range j.TLAStrs {
+		args = append(args, "--tla-str", tla)
+	}
+	if j.Values != nil {
+		values, err := j.Values()
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting values for %q", source)
+		}
+		valuesJSON, err := json.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--ext-code", "values="+string(valuesJSON))
+	}
+	args = append(args, path)
+	cmd := exec.Command(j.Exe, args...)
+	// Only the import paths checked above are searched
+	cmd.Env = withoutEnv(os.Environ(), "JSONNET_PATH")
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("evaluating jsonnet in %q: %s", source, msg)
+	}
+
+	return parseEvaluated(stdout.Bytes(), "jsonnet", source)
+}
+
+// parseEvaluated parses the JSON output of a tool (e.g., jsonnet)
+// into manifests, identified by source.
+func parseEvaluated(output []byte, tool, source string) (map[string]KubeManifest, error) {
+	var value interface{}
+	if err := json.Unmarshal(output, &value); err != nil {
+		return nil, errors.Wrapf(err, "parsing output of %s for %q", tool, source)
+	}
+	var docs []interface{}
+	if err := flattenJsonnetOutput(value, &docs); err != nil {
+		return nil, errors.Wrapf(err, "output of %s for %q", tool, source)
+	}
+
+	var multidoc bytes.Buffer
+	for _, doc := range docs {
+		bytes, err := yaml.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		multidoc.WriteString("---\n")
+		multidoc.Write(bytes)
+	}
+	return ParseMultidoc(multidoc.Bytes(), source)
+}
+
+// flattenJsonnetOutput collects the manifests in the value given,
+// which is expected to be a manifest or an array of values that are
+// themselves manifests or arrays.
+func flattenJsonnetOutput(value interface{}, docs *[]interface{}) error {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if err := flattenJsonnetOutput(item, docs); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		if _, ok := v["kind"]; !ok {
+			return errors.New("expected a manifest, or an array of manifests, but got an object without `kind`")
+		}
+		*docs = append(*docs, v)
+		return nil
+	case nil:
+		return nil
+	default:
+		return fmt.Errorf("expected a manifest, or an array of manifests, but got %T", value)
+	}
+}
+
+// checkImports makes sure the file at path, and everything it
+// imports, doesn't import anything outside root.
+func (j *Jsonnet) checkImports(root, path string, importDirs []string, seen map[string]bool) error {
+	path, err := confine(root, path)
+	if err != nil {
+		return err
+	}
+	if seen[path] {
+		return nil
+	}
+	seen[path] = true
+
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	imports, err := jsonnetImports(string(src))
+	if err != nil {
+		return errors.Wrapf(err, "reading imports of %q", path)
+	}
+	for _, imported := range imports {
+		if filepath.IsAbs(imported) {
+			return fmt.Errorf("import of absolute path %q is not allowed", imported)
+		}
+		// Imports are resolved relative to the importing file first,
+		// then the import paths in order.
+		candidates := []string{filepath.Join(filepath.Dir(path), imported)}
+		for _, dir := range importDirs {
+			candidates = append(candidates, filepath.Join(dir, imported))
+		}
+		found := false
+		for _, candidate := range candidates {
+			if _, err := os.Stat(candidate); err != nil {
+				continue
+			}
+			if err := j.checkImports(root, candidate, importDirs, seen); err != nil {
+				return errors.Wrapf(err, "in import %q", imported)
+			}
+			found = true
+			break
+		}
+		if !found {
+			// Let jsonnet report it; but it mustn't be anywhere
+			// outside the repo, either.
+			if _, err := confine(root, candidates[0]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonnetImports gives the paths imported, with `import`, `importstr`
+// or `importbin`, by the jsonnet source given. The source is lexed
+// only so far as to skip comments and strings, and decode the strings
+// imported, which may be in any of jsonnet's forms of string.
+func jsonnetImports(src string) ([]string, error) {
+	var imports []string
+	i := 0
+	for {
+		var err error
+		if i, err = skipJsonnetSpace(src, i); err != nil {
+			return nil, err
+		}
+		switch {
+		case i >= len(src):
+			return imports, nil
+		case isJsonnetStringStart(src[i:]):
+			_, n, err := jsonnetString(src[i:])
+			if err != nil {
+				return nil, err
+			}
+			i += n
+		case isJsonnetIdentChar(src[i], false):
+			start := i
+			for i < len(src) && isJsonnetIdentChar(src[i], true) {
+				i++
+			}
+			word := src[start:i]
+			if word != "import" && word != "importstr" && word != "importbin" {
+				continue
+			}
+			if i, err = skipJsonnetSpace(src, i); err != nil {
+				return nil, err
+			}
+			if !isJsonnetStringStart(src[i:]) {
+				return nil, fmt.Errorf("%s of something other than a string literal", word)
+			}
+			path, n, err := jsonnetString(src[i:])
+			if err != nil {
+				return nil, err
+			}
+			imports = append(imports, path)
+			i += n
+		case src[i] >= '0' && src[i] <= '9':
+			// A number, which may have letters in it (e.g., 1e5),
+			// but is not an identifier
+			for i < len(src) && isJsonnetIdentChar(src[i], true) {
+				i++
+			}
+		default:
+			i++
+		}
+	}
+}
+
+// skipJsonnetSpace gives the index of the first thing in src, from i,
+// that is not whitespace or a comment.
+func skipJsonnetSpace(src string, i int) (int, error) {
+	for i < len(src) {
+		switch {
+		case src[i] == ' ' || src[i] == '\t' || src[i] == '\n' || src[i] == '\r':
+			i++
+		case src[i] == '#' || strings.HasPrefix(src[i:], "//"):
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				return len(src), nil
+			}
+			i += end + 1
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return 0, errors.New("unterminated comment")
+			}
+			i += 2 + end + 2
+		default:
+			return i, nil
+		}
+	}
+	return i, nil
+}
+
+func isJsonnetIdentChar(c byte, digits bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (digits && c >= '0' && c <= '9')
+}
+
+func isJsonnetStringStart(src string) bool {
+	return strings.HasPrefix(src, `"`) || strings.HasPrefix(src, "'") ||
+		strings.HasPrefix(src, `@"`) || strings.HasPrefix(src, "@'") ||
+		strings.HasPrefix(src, "|||")
+}
+
+// jsonnetString decodes the string literal at the start of src,
+// giving its value and its length in src.
+func jsonnetString(src string) (string, int, error) {
+	var value bytes.Buffer
+	switch {
+	case strings.HasPrefix(src, "|||"):
+		return jsonnetTextBlock(src)
+	case src[0] == '@':
+		// Verbatim; the only escape is a doubled quote
+		quote := src[1]
+		for i := 2; i < len(src); i++ {
+			if src[i] != quote {
+				value.WriteByte(src[i])
+				continue
+			}
+			if i+1 < len(src) && src[i+1] == quote {
+				value.WriteByte(quote)
+				i++
+				continue
+			}
+			return value.String(), i + 1, nil
+		}
+	default:
+		quote := src[0]
+		for i := 1; i < len(src); i++ {
+			switch src[i] {
+			case quote:
+				return value.String(), i + 1, nil
+			case '\\':
+				i++
+				if i >= len(src) {
+					break
+				}
+				switch c := src[i]; c {
+				case '"', '\'', '\\', '/':
+					value.WriteByte(c)
+				case 'b':
+					value.WriteByte('\b')
+				case 'f':
+					value.WriteByte('\f')
+				case 'n':
+					value.WriteByte('\n')
+				case 'r':
+					value.WriteByte('\r')
+				case 't':
+					value.WriteByte('\t')
+				case 'u':
+					r, n, err := jsonnetUnicodeEscape(src[i-1:])
+					if err != nil {
+						return "", 0, err
+					}
+					value.WriteRune(r)
+					i += n - 2
+				default:
+					return "", 0, fmt.Errorf("unknown escape sequence in string literal: \\%c", c)
+				}
+			default:
+				value.WriteByte(src[i])
+			}
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
+
+// jsonnetUnicodeEscape decodes the \uXXXX escape at the start of src,
+// or the pair of them if the first is half of a surrogate pair,
+// giving the rune and the length of the escape.
+func jsonnetUnicodeEscape(src string) (rune, int, error) {
+	hex := func(s string) (rune, error) {
+		if len(s) < 6 || s[0] != '\\' || s[1] != 'u' {
+			return 0, errors.New("truncated unicode escape in string literal")
+		}
+		n, err := strconv.ParseUint(s[2:6], 16, 16)
+		if err != nil {
+			return 0, fmt.Errorf("invalid unicode escape in string literal: %s", s[:6])
+		}
+		return rune(n), nil
+	}
+	r, err := hex(src)
+	if err != nil {
+		return 0, 0, err
+	}
+	if utf16.IsSurrogate(r) {
+		if r2, err := hex(src[6:]); err == nil {
+			return utf16.DecodeRune(r, r2), 12, nil
+		}
+	}
+	return r, 6, nil
+}
+
+// jsonnetTextBlock decodes the text block (`|||` ... `|||`) at the
+// start of src, giving its value and its length in src. Each line of
+// the block has the indentation of its first line removed.
+func jsonnetTextBlock(src string) (string, int, error) {
+	var value bytes.Buffer
+	i := len("|||")
+	chomp := strings.HasPrefix(src[i:], "-")
+	if chomp {
+		i++
+	}
+	for i < len(src) && (src[i] == ' ' || src[i] == '\t' || src[i] == '\r') {
+		i++
+	}
+	if i >= len(src) || src[i] != '\n' {
+		return "", 0, errors.New("text block must start with a new line after |||")
+	}
+	i++
+	for i < len(src) && src[i] == '\n' {
+		value.WriteByte('\n')
+		i++
+	}
+	start := i
+	for i < len(src) && (src[i] == ' ' || src[i] == '\t') {
+		i++
+	}
+	indent := src[start:i]
+	if indent == "" {
+		return "", 0, errors.New("text block's first line must start with whitespace")
+	}
+	i = start
+	for strings.HasPrefix(src[i:], indent) {
+		i += len(indent)
+		end := strings.IndexByte(src[i:], '\n')
+		if end < 0 {
+			return "", 0, errors.New("unterminated text block")
+		}
+		value.WriteString(src[i : i+end+1])
+		i += end + 1
+		for i < len(src) && src[i] == '\n' {
+			value.WriteByte('\n')
+			i++
+		}
+	}
+	for i < len(src) && (src[i] == ' ' || src[i] == '\t') {
+		i++
+	}
+	if !strings.HasPrefix(src[i:], "|||") {
+		return "", 0, errors.New("text block not terminated with |||")
+	}
+	s := value.String()
+	if chomp {
+		s = strings.TrimSuffix(s, "\n")
+	}
+	return s, i + len("|||"), nil
+}
+
+// withoutEnv gives the environment given without the variable named.
+func withoutEnv(env []string, name string) []string {
+	var result []string
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, name+"=") {
+			result = append(result, kv)
+		}
+	}
+	return result
+}
+
+// confine returns the path given with symlinks resolved, or an error
+// if that is outside root.
+func confine(root, path string) (string, error) {
+	resolved := filepath.Clean(path)
+	if evaled, err := filepath.EvalSymlinks(path); err == nil {
+		resolved = evaled
+	}
+	resolved, err := filepath.Abs(resolved)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q is outside the repo", path)
+	}
+	return resolved, nil
+}
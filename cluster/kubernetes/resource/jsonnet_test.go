@@ -0,0 +1,140 @@
+package resource
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/flux/cluster/kubernetes/testfiles"
+)
+
+func TestJsonnetCheckImports(t *testing.T) {
+	dir, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+	outside, cleanupOutside := testfiles.TempDir(t)
+	defer cleanupOutside()
+
+	repo := filepath.Join(dir, "repo")
+	for path, content := range map[string]string{
+		filepath.Join(repo, "lib", "ok.libsonnet"):     `{ name: 'ok' }`,
+		filepath.Join(repo, "ok.jsonnet"):              `local lib = import "lib/ok.libsonnet"; lib`,
+		filepath.Join(repo, "vendored.jsonnet"):        `import "ok.libsonnet"`,
+		filepath.Join(repo, "escape.jsonnet"):          `import "../outside.libsonnet"`,
+		filepath.Join(repo, "absolute.jsonnet"):        `importstr "/etc/passwd"`,
+		filepath.Join(repo, "indirect.jsonnet"):        `import 'lib/escape.libsonnet'`,
+		filepath.Join(repo, "lib", "escape.libsonnet"): `import "../../outside.libsonnet"`,
+		filepath.Join(repo, "verbatim.jsonnet"):        `import @"../outside.libsonnet"`,
+		filepath.Join(repo, "textblock.jsonnet"):       "importstr |||-\n  ../outside.libsonnet\n|||",
+		filepath.Join(repo, "escaped.jsonnet"):         `import "\u002e\u002e/outside.libsonnet"`,
+		filepath.Join(repo, "commented.jsonnet"):       "// import \"../outside.libsonnet\"\nimport 'ok.libsonnet' /* import '/etc' */",
+		filepath.Join(dir, "outside.libsonnet"):        `{}`,
+		filepath.Join(outside, "secret.libsonnet"):     `{}`,
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.libsonnet"), filepath.Join(repo, "lib", "link.libsonnet")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(repo, "symlink.jsonnet"), []byte(`import "lib/link.libsonnet"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := filepath.EvalSymlinks(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := &Jsonnet{}
+	libDir := filepath.Join(root, "lib")
+	for file, ok := range map[string]bool{
+		"ok.jsonnet":        true,
+		"vendored.jsonnet":  true,
+		"escape.jsonnet":    false,
+		"absolute.jsonnet":  false,
+		"indirect.jsonnet":  false,
+		"symlink.jsonnet":   false,
+		"verbatim.jsonnet":  false,
+		"textblock.jsonnet": false,
+		"escaped.jsonnet":   false,
+		"commented.jsonnet": true,
+	} {
+		err := j.checkImports(root, filepath.Join(root, file), []string{libDir}, map[string]bool{})
+		if ok && err != nil {
+			t.Errorf("%s: expected imports to be allowed, got %v", file, err)
+		}
+		if !ok && err == nil {
+			t.Errorf("%s: expected imports to be rejected", file)
+		}
+	}
+
+	if _, err := confine(root, filepath.Join(root, "..")); err == nil {
+		t.Error("expected parent of repo to be rejected as an import path")
+	}
+}
+
+func TestJsonnetImports(t *testing.T) {
+	for src, expected := range map[string][]string{
+		`import "a.libsonnet"`:                    {"a.libsonnet"},
+		`importstr 'a\'b'`:                        {"a'b"},
+		`importbin @'a''b\n'`:                     {`a'b\n`},
+		`import "\u0061\/b"`:                      {"a/b"},
+		"import |||\n  a.libsonnet\n|||":          {"a.libsonnet\n"},
+		"import |||-\n    a\n    b\n  |||":        {"a\nb"},
+		`local s = "import 'x'"; s`:               nil,
+		"# import 'x'\n/* import 'y' */ myimport": nil,
+		`{ a: import "a", b: importstr "b" }`:     {"a", "b"},
+	} {
+		imports, err := jsonnetImports(src)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", src, err)
+			continue
+		}
+		if !reflect.DeepEqual(imports, expected) {
+			t.Errorf("%q: expected %q, got %q", src, expected, imports)
+		}
+	}
+
+	for _, bad := range []string{
+		`import`,
+		`local p = "a"; import p`,
+		`import ("a")`,
+		`import "a`,
+		"import |||\na\n|||",
+		`import "\q"`,
+	} {
+		if _, err := jsonnetImports(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestFlattenJsonnetOutput(t *testing.T) {
+	deployment := map[string]interface{}{"kind": "Deployment"}
+	service := map[string]interface{}{"kind": "Service"}
+
+	var docs []interface{}
+	value := []interface{}{deployment, []interface{}{service, nil}}
+	if err := flattenJsonnetOutput(value, &docs); err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 {
+		t.Errorf("expected two manifests, got %#v", docs)
+	}
+
+	for _, bad := range []interface{}{
+		"a string",
+		map[string]interface{}{"metadata": map[string]interface{}{}},
+		[]interface{}{deployment, 5.0},
+	} {
+		docs = nil
+		if err := flattenJsonnetOutput(bad, &docs); err == nil {
+			t.Errorf("expected error for %#v", bad)
+		}
+	}
+}
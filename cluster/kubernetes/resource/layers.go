@@ -0,0 +1,173 @@
+package resource
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// LoadLayered loads the manifests from each of the paths given, as a
+// layer over those before it: a resource defined in more than one
+// layer is the result of merging its definitions, in order, with
+// `mergeManifest`. Within a layer, a resource may be defined only
+// once, as with Load.
+//
+// The merged manifest has the source of the last layer to define the
+// resource, since that's where changes to it (e.g., by automation)
+// will have effect.
+func LoadLayered(base string, paths []string, opts LoadOptions) (map[string]KubeManifest, error) {
+	objs := map[string]KubeManifest{}
+	for _, path := range paths {
+		layer, err := LoadWithOptions(base, []string{path}, opts)
+		if err != nil {
+			return nil, err
+		}
+		for id, obj := range layer {
+			under, ok := objs[id]
+			if !ok {
+				objs[id] = obj
+				continue
+			}
+			merged, err := mergeManifest(under.Bytes(), obj.Bytes())
+			if err != nil {
+				return nil, errors.Wrapf(err, "merging definition of '%s' in %s over that in %s", id, obj.Source(), under.Source())
+			}
+			docs, err := ParseMultidoc(merged, obj.Source())
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing merged definition of '%s'", id)
+			}
+			mergedObj, ok := docs[id]
+			if !ok || len(docs) != 1 {
+				return nil, fmt.Errorf("merged definition of '%s' from %s and %s does not define the same resource", id, under.Source(), obj.Source())
+			}
+			objs[id] = mergedObj
+		}
+	}
+	return objs, nil
+}
+
+// mergeManifest merges the overlay manifest over the base manifest,
+// and returns the result as YAML. The rules are:
+//
+//   - maps are merged key by key, recursively;
+//   - a value of `null` in the overlay removes the key;
+//   - a list of maps each having a `name` field (e.g., containers,
+//     env entries, volumes) is merged by name: entries with the same
+//     name are merged, and others from the overlay are appended;
+//   - any other value (including other lists) in the overlay replaces
+//     that in the base.
+//
+// The overlay giving a different type of value for a field than the
+// base (e.g., a scalar where the base has a map) is an error, since
+// it's likely that the definitions conflict rather than one being
+// meant to override the other.
+func mergeManifest(base, overlay []byte) ([]byte, error) {
+	var b, o map[string]interface{}
+	if err := yaml.Unmarshal(base, &b); err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(overlay, &o); err != nil {
+		return nil, err
+	}
+	merged, err := mergeValue("", b, o)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(merged)
+}
+
+func mergeValue(path string, base, overlay interface{}) (interface{}, error) {
+	switch o := overlay.(type) {
+	case map[string]interface{}:
+		b, ok := base.(map[string]interface{})
+		if !ok {
+			if base == nil {
+				return o, nil
+			}
+			return nil, conflict(path, base, overlay)
+		}
+		for k, v := range o {
+			if v == nil {
+				delete(b, k)
+				continue
+			}
+			merged, err := mergeValue(path+"."+k, b[k], v)
+			if err != nil {
+				return nil, err
+			}
+			b[k] = merged
+		}
+		return b, nil
+	case []interface{}:
+		b, ok := base.([]interface{})
+		if !ok {
+			if base == nil {
+				return o, nil
+			}
+			return nil, conflict(path, base, overlay)
+		}
+		if len(o) > 0 && isNamedList(b) && isNamedList(o) {
+			return mergeNamedList(path, b, o)
+		}
+		return o, nil
+	default:
+		switch base.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, conflict(path, base, overlay)
+		}
+		return o, nil
+	}
+}
+
+func mergeNamedList(path string, base, overlay []interface{}) ([]interface{}, error) {
+	index := map[interface{}]int{}
+	for i, item := range base {
+		index[item.(map[string]interface{})["name"]] = i
+	}
+	for _, item := range overlay {
+		name := item.(map[string]interface{})["name"]
+		if i, ok := index[name]; ok {
+			merged, err := mergeValue(fmt.Sprintf("%s[%v]", path, name), base[i], item)
+			if err != nil {
+				return nil, err
+			}
+			base[i] = merged
+			continue
+		}
+		index[name] = len(base)
+		base = append(base, item)
+	}
+	return base, nil
+}
+
+// isNamedList says whether the list is made up of maps that all have
+// a name.
+func isNamedList(list []interface{}) bool {
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if _, ok := m["name"]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func conflict(path string, base, overlay interface{}) error {
+	return fmt.Errorf("conflicting definitions of field %s: %s in one layer, %s in the other", strings.TrimPrefix(path, "."), kindOfValue(base), kindOfValue(overlay))
+}
+
+func kindOfValue(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "a map"
+	case []interface{}:
+		return "a list"
+	default:
+		return "a value"
+	}
+}
@@ -0,0 +1,156 @@
+package resource
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weaveworks/flux/cluster/kubernetes/testfiles"
+)
+
+func TestMergeManifest(t *testing.T) {
+	const base = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: demo
+  labels:
+    tier: web
+    remove: me
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v1
+        args: ["--verbose"]
+      - name: sidecar
+        image: sidecar:v1
+`
+	const overlay = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: demo
+  labels:
+    remove: null
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v2
+        args: ["--quiet"]
+      - name: metrics
+        image: metrics:v1
+`
+	merged, err := mergeManifest([]byte(base), []byte(overlay))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual map[string]interface{}
+	if err := yaml.Unmarshal(merged, &actual); err != nil {
+		t.Fatal(err)
+	}
+	var expected map[string]interface{}
+	if err := yaml.Unmarshal([]byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: demo
+  labels:
+    tier: web
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v2
+        args: ["--quiet"]
+      - name: sidecar
+        image: sidecar:v1
+      - name: metrics
+        image: metrics:v1
+`), &expected); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expected, actual)
+
+	_, err = mergeManifest([]byte(base), []byte(`metadata:
+  labels: none
+`))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "metadata.labels")
+	}
+}
+
+func TestLoadLayered(t *testing.T) {
+	dir, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+
+	files := map[string]string{
+		"base/app.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: demo
+data:
+  level: info
+  colour: blue
+`,
+		"base/other.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: other
+  namespace: demo
+`,
+		"prod/app.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: demo
+data:
+  level: warn
+`,
+	}
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objs, err := LoadLayered(dir, []string{filepath.Join(dir, "base"), filepath.Join(dir, "prod")}, LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, objs, 2)
+	config, ok := objs["demo:configmap/config"]
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.Equal(t, "prod/app.yaml", config.Source())
+	assert.Contains(t, string(config.Bytes()), "level: warn")
+	assert.Contains(t, string(config.Bytes()), "colour: blue")
+
+	// A resource defined twice in the same layer is still an error
+	dup := filepath.Join(dir, "prod", "dup.yaml")
+	if err := ioutil.WriteFile(dup, []byte(files["prod/app.yaml"]), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadLayered(dir, []string{filepath.Join(dir, "base"), filepath.Join(dir, "prod")}, LoadOptions{})
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "duplicate definition"))
+	}
+}
@@ -15,10 +15,63 @@ import (
 // based on the file(s) therein. Resources are named according to the
 // file content, rather than the file name of directory structure.
 func Load(base string, paths []string) (map[string]KubeManifest, error) {
+	return LoadWithJsonnet(base, paths, nil)
+}
+
+// LoadWithJsonnet is like Load, but if jsonnet is not nil, it's also
+// used to evaluate any `.jsonnet` files into manifests.
+func LoadWithJsonnet(base string, paths []string, jsonnet *Jsonnet) (map[string]KubeManifest, error) {
+	return LoadWithOptions(base, paths, LoadOptions{Jsonnet: jsonnet})
+}
+
+// LoadOptions are the options for LoadWithOptions.
+type LoadOptions struct {
+	// If not nil, used to evaluate `.jsonnet` files into manifests
+	Jsonnet *Jsonnet
+	// If non-zero, files larger than this many bytes aren't read;
+	// it's an error to come across one, unless Skip is given
+	MaxFileSize int64
+	// If not nil, called with each file that's too large, which is
+	// then skipped rather than being an error
+	Skip func(source string, size int64)
+	// What to do when a resource is defined more than once; one of
+	// the Duplicates* values, or empty for DuplicatesFail
+	Duplicates string
+	// If not nil, called with each definition that's ignored, since
+	// another definition of the same resource is used instead
+	Ignore func(id string, used, ignored KubeManifest)
+	// Rewrites of deprecated API versions to apply to the manifests;
+	// see RewriteAPIVersion
+	APIVersions []APIVersionRewrite
+	// If not nil, called with each manifest that's had its API
+	// version rewritten, and the rewrite
+	Rewritten func(m KubeManifest, r APIVersionRewrite)
+}
+
+// LoadWithOptions is like Load, with the options given.
+func LoadWithOptions(base string, paths []string, opts LoadOptions) (map[string]KubeManifest, error) {
+	jsonnet := opts.Jsonnet
 	if _, err := os.Stat(base); os.IsNotExist(err) {
 		return nil, fmt.Errorf("git path %q not found", base)
 	}
 	objs := map[string]KubeManifest{}
+	add := func(id string, obj KubeManifest) error {
+		if err := opts.rewriteAPIVersion(obj); err != nil {
+			return err
+		}
+		if alreadyDefined, ok := objs[id]; ok {
+			used, ignored, err := ResolveDuplicate(opts.Duplicates, id, alreadyDefined, obj)
+			if err != nil {
+				return err
+			}
+			if opts.Ignore != nil {
+				opts.Ignore(id, used, ignored)
+			}
+			obj = used
+		}
+		objs[id] = obj
+		return nil
+	}
 	charts, err := newChartTracker(base)
 	if err != nil {
 		return nil, errors.Wrapf(err, "walking %q for chartdirs", base)
@@ -37,24 +90,39 @@ func Load(base string, paths []string) (map[string]KubeManifest, error) {
 				return nil
 			}
 
-			if !info.IsDir() && filepath.Ext(path) == ".yaml" || filepath.Ext(path) == ".yml" {
-				bytes, err := ioutil.ReadFile(path)
-				if err != nil {
-					return errors.Wrapf(err, "unable to read file at %q", path)
-				}
+			isJsonnet := jsonnet != nil && !info.IsDir() && filepath.Ext(path) == ".jsonnet"
+			if !info.IsDir() && filepath.Ext(path) == ".yaml" || filepath.Ext(path) == ".yml" || isJsonnet {
 				source, err := filepath.Rel(base, path)
 				if err != nil {
 					return errors.Wrapf(err, "path to scan %q is not under base %q", path, base)
 				}
-				docsInFile, err := ParseMultidoc(bytes, source)
+				if opts.MaxFileSize > 0 && info.Size() > opts.MaxFileSize {
+					if opts.Skip == nil {
+						return fmt.Errorf("file %s is %d bytes, larger than the limit of %d bytes", source, info.Size(), opts.MaxFileSize)
+					}
+					opts.Skip(source, info.Size())
+					return nil
+				}
+				if !isJsonnet {
+					bytes, err := ioutil.ReadFile(path)
+					if err != nil {
+						return errors.Wrapf(err, "unable to read file at %q", path)
+					}
+					// Each document is added as it's parsed, so that
+					// a resource defined twice in the same file is
+					// treated like one defined in two files
+					return parseMultidoc(bytes, source, func(obj KubeManifest) error {
+						return add(obj.ResourceID().String(), obj)
+					})
+				}
+				docsInFile, err := jsonnet.evaluate(base, path, source)
 				if err != nil {
 					return err
 				}
 				for id, obj := range docsInFile {
-					if alreadyDefined, ok := objs[id]; ok {
-						return fmt.Errorf(`duplicate definition of '%s' (in %s and %s)`, id, alreadyDefined.Source(), source)
+					if err := add(id, obj); err != nil {
+						return err
 					}
-					objs[id] = obj
 				}
 			}
 			return nil
@@ -67,6 +135,17 @@ func Load(base string, paths []string) (map[string]KubeManifest, error) {
 	return objs, nil
 }
 
+func (opts LoadOptions) rewriteAPIVersion(obj KubeManifest) error {
+	r, err := RewriteAPIVersion(obj, opts.APIVersions)
+	if err != nil {
+		return err
+	}
+	if r != nil && opts.Rewritten != nil {
+		opts.Rewritten(obj, *r)
+	}
+	return nil
+}
+
 type chartTracker map[string]bool
 
 func newChartTracker(root string) (chartTracker, error) {
@@ -128,6 +207,19 @@ func looksLikeChart(dir string) bool {
 // constructs an object set from the resources represented therein.
 func ParseMultidoc(multidoc []byte, source string) (map[string]KubeManifest, error) {
 	objs := map[string]KubeManifest{}
+	if err := parseMultidoc(multidoc, source, func(obj KubeManifest) error {
+		objs[obj.ResourceID().String()] = obj
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return objs, nil
+}
+
+// parseMultidoc parses the manifests in a multidoc YAML, calling add
+// with each in turn (including each item of a List), and stopping if
+// it returns an error.
+func parseMultidoc(multidoc []byte, source string, add func(KubeManifest) error) error {
 	chunks := bufio.NewScanner(bytes.NewReader(multidoc))
 	initialBuffer := make([]byte, 4096)     // Matches startBufSize in bufio/scan.go
 	chunks.Buffer(initialBuffer, 1024*1024) // Allow growth to 1MB
@@ -135,6 +227,16 @@ func ParseMultidoc(multidoc []byte, source string) (map[string]KubeManifest, err
 
 	var obj KubeManifest
 	var err error
+	// Each manifest is given its position in the file (counting the
+	// items of Lists individually), so that the order of documents
+	// can be honoured when applying.
+	position := 0
+	setPosition := func(m KubeManifest) {
+		if p, ok := m.(interface{ setPosition(int) }); ok {
+			p.setPosition(position)
+		}
+		position++
+	}
 	for chunks.Scan() {
 		// It's not guaranteed that the return value of Bytes() will not be mutated later:
 		// https://golang.org/pkg/bufio/#Scanner.Bytes
@@ -143,7 +245,7 @@ func ParseMultidoc(multidoc []byte, source string) (map[string]KubeManifest, err
 		bytes2 := make([]byte, len(bytes), cap(bytes))
 		copy(bytes2, bytes)
 		if obj, err = unmarshalObject(source, bytes2); err != nil {
-			return nil, errors.Wrapf(err, "parsing YAML doc from %q", source)
+			return errors.Wrapf(err, "parsing YAML doc from %q", source)
 		}
 		if obj == nil {
 			continue
@@ -152,17 +254,23 @@ func ParseMultidoc(multidoc []byte, source string) (map[string]KubeManifest, err
 		// contained resources we are after.
 		if list, ok := obj.(*List); ok {
 			for _, item := range list.Items {
-				objs[item.ResourceID().String()] = item
+				setPosition(item)
+				if err := add(item); err != nil {
+					return err
+				}
 			}
 		} else {
-			objs[obj.ResourceID().String()] = obj
+			setPosition(obj)
+			if err := add(obj); err != nil {
+				return err
+			}
 		}
 	}
 
 	if err := chunks.Err(); err != nil {
-		return objs, errors.Wrapf(err, "scanning multidoc from %q", source)
+		return errors.Wrapf(err, "scanning multidoc from %q", source)
 	}
-	return objs, nil
+	return nil
 }
 
 // ---
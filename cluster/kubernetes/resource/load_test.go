@@ -2,8 +2,10 @@ package resource
 
 import (
 	"bytes"
+	"io/ioutil"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -50,6 +52,7 @@ metadata:
 	}
 
 	objA := base("test", "Deployment", "", "a-deployment")
+	objA.position = 1
 	objB := base("test", "Deployment", "b-namespace", "b-deployment")
 	expected := map[string]resource.Resource{
 		objA.ResourceID().String(): &Deployment{baseObject: objA},
@@ -82,6 +85,7 @@ metadata:
 	}
 
 	objA := base("test", "Deployment", "", "a-deployment")
+	objA.position = 1
 	objB := base("test", "Deployment", "b-namespace", "b-deployment")
 	expected := map[string]resource.Resource{
 		objA.ResourceID().String(): &Deployment{baseObject: objA},
@@ -101,6 +105,52 @@ metadata:
 	}
 }
 
+func TestParsePositions(t *testing.T) {
+	docs := `---
+kind: ServiceAccount
+metadata:
+  name: sa
+  namespace: ns
+---
+# just a comment
+---
+kind: List
+items:
+- kind: Role
+  metadata:
+    name: role
+    namespace: ns
+- kind: RoleBinding
+  metadata:
+    name: binding
+    namespace: ns
+---
+kind: Deployment
+metadata:
+  name: dep
+  namespace: ns
+`
+	objs, err := ParseMultidoc([]byte(docs), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range []string{
+		"ns:serviceaccount/sa",
+		"ns:role/role",
+		"ns:rolebinding/binding",
+		"ns:deployment/dep",
+	} {
+		obj, ok := objs[id]
+		if !ok {
+			t.Errorf("expected %q to be parsed", id)
+			continue
+		}
+		if obj.Position() != i {
+			t.Errorf("expected %q at position %d, got %d", id, i, obj.Position())
+		}
+	}
+}
+
 func TestParseSomeLong(t *testing.T) {
 	doc := `---
 kind: ConfigMap
@@ -211,6 +261,160 @@ func TestLoadSome(t *testing.T) {
 	}
 }
 
+func TestLoadMaxFileSize(t *testing.T) {
+	dir, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+	if err := testfiles.WriteTestFiles(dir); err != nil {
+		t.Fatal(err)
+	}
+	var limit int64
+	for _, content := range testfiles.Files {
+		if int64(len(content)) > limit {
+			limit = int64(len(content))
+		}
+	}
+	big := "---\nkind: Deployment\nmetadata:\n  name: big\n  namespace: default\n# " + strings.Repeat("x", int(limit)) + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "big.yaml"), []byte(big), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a way to skip it, a file that's too large is an error
+	if _, err := LoadWithOptions(dir, []string{dir}, LoadOptions{MaxFileSize: limit}); err == nil {
+		t.Error("expected an error loading a file larger than the limit")
+	}
+
+	var skipped []string
+	objs, err := LoadWithOptions(dir, []string{dir}, LoadOptions{
+		MaxFileSize: limit,
+		Skip: func(source string, size int64) {
+			skipped = append(skipped, source)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"big.yaml"}, skipped)
+	assert.Len(t, objs, len(testfiles.ResourceMap))
+	if _, ok := objs["default:deployment/big"]; ok {
+		t.Error("expected the large file not to have been loaded")
+	}
+}
+
+func TestLoadDuplicates(t *testing.T) {
+	dir, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+	dup := "---\napiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: dup\n  namespace: default\n"
+	files := map[string]string{
+		"a.yaml": dup,
+		// defined twice in the same file, too
+		"b.yaml": dup + dup,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := LoadWithOptions(dir, []string{dir}, LoadOptions{})
+	dupErr, ok := err.(*DuplicateError)
+	if !ok {
+		t.Fatalf("expected a DuplicateError, got %v", err)
+	}
+	assert.Equal(t, "default:deployment/dup", dupErr.ID)
+	assert.Equal(t, []string{"a.yaml", "b.yaml"}, dupErr.Sources)
+
+	for policy, expected := range map[string]struct {
+		source   string
+		position int
+	}{
+		DuplicatesFirst: {"a.yaml", 0},
+		DuplicatesLast:  {"b.yaml", 1},
+	} {
+		var ignored []string
+		objs, err := LoadWithOptions(dir, []string{dir}, LoadOptions{
+			Duplicates: policy,
+			Ignore: func(id string, used, dropped KubeManifest) {
+				ignored = append(ignored, dropped.Source())
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj := objs["default:deployment/dup"]
+		assert.Equal(t, expected.source, obj.Source(), policy)
+		assert.Equal(t, expected.position, obj.Position(), policy)
+		assert.Len(t, ignored, 2, policy)
+	}
+}
+
+func TestLoadAPIVersionRewrites(t *testing.T) {
+	dir, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+	content := `---
+apiVersion: extensions/v1beta1 # deprecated
+kind: Deployment
+metadata:
+  name: old
+  namespace: default
+---
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: old
+  namespace: default
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: "apps/v1beta2"
+  kind: DaemonSet
+  metadata:
+    name: listed
+    namespace: default
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "old.yaml"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var rewrites []APIVersionRewrite
+	for _, arg := range []string{"Deployment:extensions/v1beta1=apps/v1", "apps/v1beta2=apps/v1"} {
+		r, err := ParseAPIVersionRewrite(arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rewrites = append(rewrites, r)
+	}
+	var rewritten []string
+	objs, err := LoadWithOptions(dir, []string{dir}, LoadOptions{
+		APIVersions: rewrites,
+		Rewritten: func(m KubeManifest, r APIVersionRewrite) {
+			rewritten = append(rewritten, m.ResourceID().String()+" "+r.String())
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.ElementsMatch(t, []string{
+		"default:deployment/old Deployment:extensions/v1beta1=apps/v1",
+		"default:daemonset/listed apps/v1beta2=apps/v1",
+	}, rewritten)
+
+	dep := objs["default:deployment/old"]
+	assert.Equal(t, "apps/v1", dep.GroupVersion())
+	assert.Contains(t, string(dep.Bytes()), "apiVersion: apps/v1 # deprecated\n")
+	ds := objs["default:daemonset/listed"]
+	assert.Equal(t, "apps/v1", ds.GroupVersion())
+	assert.Contains(t, string(ds.Bytes()), "apiVersion: apps/v1\n")
+	// only Deployments are rewritten from extensions/v1beta1
+	assert.Equal(t, "extensions/v1beta1", objs["default:ingress/old"].GroupVersion())
+
+	for _, bad := range []string{"apps/v1", ":extensions/v1beta1=apps/v1", "apps/v1=apps/v1", "a=b=c"} {
+		if _, err := ParseAPIVersionRewrite(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
 func TestChartTracker(t *testing.T) {
 	dir, cleanup := testfiles.TempDir(t)
 	defer cleanup()
@@ -25,22 +25,28 @@ type KubeManifest interface {
 	GetKind() string
 	GetNamespace() string
 	SetNamespace(string)
+	// Position gives the index of the manifest among those in the
+	// file it was loaded from, so that document order can be
+	// respected when applying.
+	Position() int
 }
 
 // -- unmarshaling code for specific object and field types
 
 // struct to embed in objects, to provide default implementation
 type baseObject struct {
-	source string
-	bytes  []byte
+	source   string
+	bytes    []byte
+	position int
 
 	// these are present for unmarshalling into the struct
 	APIVersion string `yaml:"apiVersion"`
 	Kind       string `yaml:"kind"`
 	Meta       struct {
-		Namespace   string            `yaml:"namespace"`
-		Name        string            `yaml:"name"`
-		Annotations map[string]string `yaml:"annotations,omitempty"`
+		Namespace    string            `yaml:"namespace"`
+		Name         string            `yaml:"name"`
+		GenerateName string            `yaml:"generateName,omitempty"`
+		Annotations  map[string]string `yaml:"annotations,omitempty"`
 	} `yaml:"metadata"`
 }
 
@@ -59,12 +65,29 @@ func (o baseObject) GetKind() string {
 	return o.Kind
 }
 
+// ResourceID gives the ID of the resource. A manifest with
+// `generateName` rather than a name is identified by its
+// `generateName`, since the name it gets in the cluster is different
+// each time it's created.
 func (o baseObject) ResourceID() flux.ResourceID {
 	ns := o.Meta.Namespace
 	if ns == "" {
 		ns = ClusterScope
 	}
-	return flux.MakeResourceID(ns, o.Kind, o.Meta.Name)
+	name := o.Meta.Name
+	if name == "" {
+		name = o.Meta.GenerateName
+	}
+	return flux.MakeResourceID(ns, o.Kind, name)
+}
+
+// Position implements KubeManifest.Position
+func (o baseObject) Position() int {
+	return o.position
+}
+
+func (o *baseObject) setPosition(i int) {
+	o.position = i
 }
 
 // SetNamespace implements KubeManifest.SetNamespace, so things with
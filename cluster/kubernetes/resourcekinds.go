@@ -2,6 +2,7 @@ package kubernetes
 
 import (
 	"strings"
+	"time"
 
 	apiapps "k8s.io/api/apps/v1"
 	apibatch "k8s.io/api/batch/v1beta1"
@@ -55,6 +56,7 @@ type workload struct {
 	status      string
 	rollout     cluster.RolloutStatus
 	syncError   error
+	deleteAfter time.Time
 	podTemplate apiv1.PodTemplateSpec
 }
 
@@ -101,14 +103,15 @@ func (w workload) toClusterWorkload(resourceID flux.ResourceID) cluster.Workload
 	}
 
 	return cluster.Workload{
-		ID:         resourceID,
-		Status:     w.status,
-		Rollout:    w.rollout,
-		SyncError:  w.syncError,
-		Antecedent: antecedent,
-		Labels:     w.GetLabels(),
-		Policies:   policies,
-		Containers: cluster.ContainersOrExcuse{Containers: clusterContainers, Excuse: excuse},
+		ID:          resourceID,
+		Status:      w.status,
+		Rollout:     w.rollout,
+		SyncError:   w.syncError,
+		DeleteAfter: w.deleteAfter,
+		Antecedent:  antecedent,
+		Labels:      w.GetLabels(),
+		Policies:    policies,
+		Containers:  cluster.ContainersOrExcuse{Containers: clusterContainers, Excuse: excuse},
 	}
 }
 
@@ -0,0 +1,67 @@
+package kubernetes
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+
+	fluxmetrics "github.com/weaveworks/flux/metrics"
+)
+
+// DefaultApplyRetries is the number of times a resource that failed
+// to apply with a transient error is tried again within a sync, if
+// not configured otherwise.
+const DefaultApplyRetries = 3
+
+// How long to wait before the first in-sync retry; this is doubled
+// for each retry after.
+const defaultApplyRetryInterval = 500 * time.Millisecond
+
+var (
+	applyRetries = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "cluster",
+		Name:      "apply_retries_total",
+		Help:      "Count of resources applied again, within the same sync, after failing with a transient error.",
+	}, []string{"kind", fluxmetrics.LabelSuccess})
+)
+
+// Messages the API server gives when a change conflicts with another
+// made at the same time; these are worth trying again straight away,
+// rather than waiting for the next sync.
+var retryableMessages = []string{
+	"the object has been modified; please apply your changes to the latest version and try again",
+	"Error from server (Conflict)",
+	"resource version changed",
+}
+
+func isRetryableError(err error) bool {
+	for _, msg := range retryableMessages {
+		if strings.Contains(err.Error(), msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryApply applies an object again, after it failed with a
+// retryable error, up to ApplyRetries times (with a backoff between
+// attempts). It returns the outcome of the last attempt.
+func (c *Kubectl) retryApply(logger log.Logger, obj applyObject, args []string, err error) (string, error) {
+	_, kind, _ := obj.ResourceID.Components()
+	interval := c.applyRetryInterval
+	output := ""
+	for attempt := 1; attempt <= c.ApplyRetries && err != nil && isRetryableError(err); attempt++ {
+		logger.Log("info", "retrying apply after transient error", "resource", obj.ResourceID, "attempt", attempt, "err", err)
+		c.sleep(interval)
+		interval *= 2
+		output, err = c.doCommand(logger, bytes.NewReader(obj.Payload), args...)
+		applyRetries.With("kind", kind, fluxmetrics.LabelSuccess, fmt.Sprint(err == nil)).Add(1)
+	}
+	return output, err
+}
@@ -0,0 +1,80 @@
+package kubernetes
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster"
+)
+
+const defaultSelfTimeout = 5 * time.Minute
+
+// splitSelf takes the object for fluxd's own workload, if it's among
+// those given, out of them.
+func (c *Kubectl) splitSelf(objs []applyObject) ([]applyObject, *applyObject) {
+	if c.SelfWorkload == (flux.ResourceID{}) {
+		return objs, nil
+	}
+	for i, obj := range objs {
+		if obj.ResourceID == c.SelfWorkload {
+			rest := make([]applyObject, 0, len(objs)-1)
+			rest = append(append(rest, objs[:i]...), objs[i+1:]...)
+			return rest, &obj
+		}
+	}
+	return objs, nil
+}
+
+// applySelf applies fluxd's own workload, after everything else, so
+// that a change which stops fluxd working can't leave a sync half
+// done. It's checked with a dry run first (server-side, if kubectl
+// can; see selfCheckArgs), and not applied if that fails; once applied, it's waited on to be ready,
+// and reported as failing to sync if it isn't ready in time, since
+// that's likely to mean the new version of fluxd doesn't work (though
+// the old one carries on while its replacement isn't ready).
+func (c *Kubectl) applySelf(logger log.Logger, obj applyObject, apply func([]applyObject) cluster.SyncError) cluster.SyncError {
+	logger = log.With(logger, "resource", obj.ResourceID)
+	logger.Log("info", "applying fluxd's own workload, after everything else")
+	if !obj.Create {
+		args, serverSide := c.selfCheckArgs()
+		if _, err := c.doCommand(logger, bytes.NewReader(obj.Payload), args...); err != nil {
+			check := "a client-side dry run"
+			if serverSide {
+				check = "a server-side dry run"
+			}
+			return cluster.SyncError{{
+				ResourceID: obj.ResourceID,
+				Source:     obj.Source,
+				Error:      fmt.Errorf("not applying fluxd's own workload, since it fails %s: %s", check, err),
+			}}
+		}
+	}
+	if errs := apply([]applyObject{obj}); len(errs) > 0 {
+		return errs
+	}
+
+	timeout := c.SelfTimeout
+	if timeout == 0 {
+		timeout = defaultSelfTimeout
+	}
+	w := wave{timeouts: map[flux.ResourceID]time.Duration{obj.ResourceID: timeout}}
+	begin := time.Now()
+	timedOut, err := c.waitForReady(w, []applyObject{obj})
+	logger.Log("info", "waited for fluxd's own workload to be ready", "took", time.Since(begin), "ready", err == nil && len(timedOut) == 0, "err", err)
+	if err != nil {
+		return cluster.SyncError{{ResourceID: obj.ResourceID, Source: obj.Source, Error: fmt.Errorf("applied fluxd's own workload, but could not check it's ready: %s", err)}}
+	}
+	if len(timedOut) > 0 {
+		t := timedOut[0]
+		return cluster.SyncError{{
+			ResourceID: obj.ResourceID,
+			Source:     obj.Source,
+			Error:      fmt.Errorf("applied fluxd's own workload, but timed out after %s waiting for %s; the new version of fluxd may not work", t.timeout, t.reason),
+		}}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package kubernetes
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/weaveworks/flux/resource"
+)
+
+// SOPSDecrypter decrypts manifests that have been encrypted with
+// SOPS (https://github.com/mozilla/sops), just before they are
+// applied. The keys used for decryption are found by sops itself,
+// e.g., from an age key file, cloud KMS credentials in the
+// environment, or the GPG keyring.
+type SOPSDecrypter struct {
+	// Path to the sops executable
+	Exe string
+	// If non-empty, only files with paths (relative to the repo)
+	// matching one of these glob patterns are decrypted. Otherwise,
+	// any manifest carrying SOPS metadata is decrypted.
+	FilePatterns []string
+	// Extra environment entries (e.g., SOPS_AGE_KEY_FILE=...) for
+	// running sops
+	Env []string
+}
+
+// matches reports whether the manifest given should be decrypted.
+func (d *SOPSDecrypter) matches(source string, manifest []byte) bool {
+	if len(d.FilePatterns) > 0 {
+		for _, pattern := range d.FilePatterns {
+			if ok, _ := filepath.Match(pattern, source); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return hasSOPSMetadata(manifest)
+}
+
+// hasSOPSMetadata reports whether a YAML document has the top-level
+// `sops` entry that sops adds when encrypting.
+func hasSOPSMetadata(manifest []byte) bool {
+	var doc struct {
+		SOPS struct {
+			MAC string `yaml:"mac"`
+		} `yaml:"sops"`
+	}
+	if err := yaml.Unmarshal(manifest, &doc); err != nil {
+		return false
+	}
+	return doc.SOPS.MAC != ""
+}
+
+// decrypt returns the decrypted manifest. Error messages are taken
+// from what sops reports, and never include the manifest itself.
+func (d *SOPSDecrypter) decrypt(source string, manifest []byte) ([]byte, error) {
+	cmd := exec.Command(d.Exe, "--decrypt", "--input-type", "yaml", "--output-type", "yaml", "/dev/stdin")
+	cmd.Env = append(os.Environ(), d.Env...)
+	cmd.Stdin = bytes.NewReader(manifest)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, errors.Errorf("decrypting %s with sops: %s", source, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// rewrittenResource stands in for a resource whose manifest has been
+// rewritten before applying (e.g., decrypted), so that it is the
+// rewritten manifest that gets applied.
+type rewrittenResource struct {
+	resource.Resource
+	bytes []byte
+}
+
+func (r rewrittenResource) Bytes() []byte {
+	return r.bytes
+}
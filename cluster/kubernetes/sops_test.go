@@ -0,0 +1,41 @@
+package kubernetes
+
+import (
+	"testing"
+)
+
+func TestSOPSDecrypterMatches(t *testing.T) {
+	const encrypted = `apiVersion: v1
+kind: Secret
+metadata:
+  name: creds
+data:
+  password: ENC[AES256_GCM,data:abcd,iv:efgh,tag:ijkl,type:str]
+sops:
+  mac: ENC[AES256_GCM,data:mnop,iv:qrst,tag:uvwx,type:str]
+  version: 3.5.0
+`
+	const plain = `apiVersion: v1
+kind: Secret
+metadata:
+  name: creds
+data:
+  password: aHVudGVyMg==
+`
+
+	byMarker := &SOPSDecrypter{}
+	if !byMarker.matches("secrets/creds.yaml", []byte(encrypted)) {
+		t.Error("expected encrypted manifest to match by content")
+	}
+	if byMarker.matches("secrets/creds.yaml", []byte(plain)) {
+		t.Error("expected plain manifest not to match by content")
+	}
+
+	byFilename := &SOPSDecrypter{FilePatterns: []string{"secrets/*.enc.yaml"}}
+	if !byFilename.matches("secrets/creds.enc.yaml", []byte(plain)) {
+		t.Error("expected manifest to match by filename")
+	}
+	if byFilename.matches("secrets/creds.yaml", []byte(encrypted)) {
+		t.Error("expected manifest not to match by filename")
+	}
+}
@@ -0,0 +1,62 @@
+package kubernetes
+
+import (
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/weaveworks/flux"
+)
+
+// stripStatus gives the manifest without its top-level `status`
+// field, and whether it had one. The status of a resource belongs to
+// its controller, so a status in a manifest (usually copied from the
+// output of `kubectl get`) at best does nothing, and at worst
+// overwrites what the controller has recorded.
+func stripStatus(manifest []byte) ([]byte, bool, error) {
+	definition := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(manifest, &definition); err != nil {
+		return nil, false, errors.Wrap(err, "parsing manifest to remove its status")
+	}
+	if _, ok := definition["status"]; !ok {
+		return manifest, false, nil
+	}
+	delete(definition, "status")
+	bytes, err := yaml.Marshal(definition)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "serializing manifest after removing its status")
+	}
+	return bytes, true, nil
+}
+
+// keepStatus says whether the status of resources of the kind given
+// is to be applied as it is in their manifests, rather than removed.
+func (c *Cluster) keepStatus(kind string) bool {
+	for _, k := range c.KeepStatusKinds {
+		if strings.EqualFold(k, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// logStrippedStatus logs that the status was removed from the
+// manifest of the resource given: at info level the first time for
+// each resource, so it can be taken out of the manifest, and at debug
+// level after that, so as not to fill the log at each sync.
+func (c *Cluster) logStrippedStatus(logger log.Logger, id flux.ResourceID, source string) {
+	c.muLoggedStatus.Lock()
+	if c.loggedStatus == nil {
+		c.loggedStatus = map[flux.ResourceID]bool{}
+	}
+	logged := c.loggedStatus[id]
+	c.loggedStatus[id] = true
+	c.muLoggedStatus.Unlock()
+	level := "info"
+	if logged {
+		level = "debug"
+	}
+	logger.Log(level, "removed status from manifest before applying it; the status belongs to the resource's controller", "resource", id, "source", source)
+}
@@ -0,0 +1,45 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestStripStatus(t *testing.T) {
+	const withStatus = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: 2
+status:
+  readyReplicas: 2
+`
+	out, stripped, err := stripStatus([]byte(withStatus))
+	assert.NoError(t, err)
+	assert.True(t, stripped)
+	var got map[string]interface{}
+	assert.NoError(t, yaml.Unmarshal(out, &got))
+	assert.NotContains(t, got, "status")
+	assert.Contains(t, got, "spec")
+
+	const withoutStatus = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  status: fine
+`
+	out, stripped, err = stripStatus([]byte(withoutStatus))
+	assert.NoError(t, err)
+	assert.False(t, stripped)
+	assert.Equal(t, withoutStatus, string(out))
+}
+
+func TestKeepStatus(t *testing.T) {
+	c := &Cluster{KeepStatusKinds: []string{"APIService"}}
+	assert.True(t, c.keepStatus("apiservice"))
+	assert.False(t, c.keepStatus("deployment"))
+}
@@ -8,24 +8,30 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"sort"
 	"strings"
 	"time"
 
+	k8syaml "github.com/ghodss/yaml"
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
 	"github.com/imdario/mergo"
 	"github.com/pkg/errors"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	"gopkg.in/yaml.v2"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	rest "k8s.io/client-go/rest"
 
 	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/cluster"
 	kresource "github.com/weaveworks/flux/cluster/kubernetes/resource"
+	fluxmetrics "github.com/weaveworks/flux/metrics"
 	"github.com/weaveworks/flux/policy"
 	"github.com/weaveworks/flux/resource"
 )
@@ -40,13 +46,25 @@ const (
 	checksumAnnotation = kresource.PolicyPrefix + "sync-checksum"
 )
 
+var (
+	applyDuration = prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+		Namespace: "flux",
+		Subsystem: "cluster",
+		Name:      "apply_duration_seconds",
+		Help:      "Duration of each attempt at applying resources, whether together or one by one, by the kinds applied.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30},
+	}, []string{"kinds", fluxmetrics.LabelSuccess})
+)
+
 // Sync takes a definition of what should be running in the cluster,
 // and attempts to make the cluster conform. An error return does not
 // necessarily indicate complete failure; some resources may succeed
 // in being synced, and some may fail (for example, they may be
-// malformed).
-func (c *Cluster) Sync(syncSet cluster.SyncSet) error {
+// malformed). What happened to each resource is counted in the
+// summary returned, whether or not there's an error.
+func (c *Cluster) Sync(syncSet cluster.SyncSet) (cluster.SyncSummary, error) {
 	logger := log.With(c.logger, "method", "Sync")
+	summary := cluster.SyncSummary{}
 
 	// Keep track of the checksum of each resource, so we can compare
 	// them during garbage collection.
@@ -56,89 +74,354 @@ func (c *Cluster) Sync(syncSet cluster.SyncSet) error {
 	// _ignored_ resources alone.
 	clusterResources, err := c.getAllowedResourcesBySelector("")
 	if err != nil {
-		return errors.Wrap(err, "collating resources in cluster for sync")
+		return summary, errors.Wrap(err, "collating resources in cluster for sync")
 	}
 
+	hashes := configHashes(syncSet)
+	changedConfigs := changedConfigNamespaces(syncSet.Changed)
+
 	cs := makeChangeSet()
+	cs.bootstrap = syncSet.Bootstrap
 	var errs cluster.SyncError
+	// Resources forced for this sync only, which have the force-sync
+	// annotation removed once applied
+	forcedOnce := map[flux.ResourceID]*kuberesource{}
 	for _, res := range syncSet.Resources {
 		resID := res.ResourceID()
 		if !c.IsAllowedResource(resID) {
 			continue
 		}
+		if syncSet.Namespace != "" && !inNamespace(resID, syncSet.Namespace) {
+			continue
+		}
 		id := resID.String()
+		namespace, kind, _ := resID.Components()
+		// Remember where the resource came in its file, before it
+		// gets wrapped (e.g., by decryption), so the order of
+		// documents can be kept when applying.
+		position := 0
+		if km, ok := res.(kresource.KubeManifest); ok {
+			position = km.Position()
+		}
+		// Values kept in Vault are filled in before the checksum is
+		// taken, so that it changes when they do. If they can't be,
+		// the resource fails below, once it's known to be applied.
+		var resolveErr error
+		if c.Secrets != nil && c.Secrets.matches(kind, res.Bytes()) {
+			var resolved []byte
+			if resolved, resolveErr = c.Secrets.resolve(namespace, res.Source(), res.Bytes()); resolveErr == nil {
+				res = rewrittenResource{Resource: res, bytes: resolved}
+			}
+		}
 		// make a record of the checksum, whether we stage it to
 		// be applied or not, so that we don't delete it later.
 		csum := sha1.Sum(res.Bytes())
 		checkHex := hex.EncodeToString(csum[:])
 		checksums[id] = checkHex
-		if res.Policies().Has(policy.Ignore) {
-			logger.Log("info", "not applying resource; ignore annotation in file", "resource", res.ResourceID(), "source", res.Source())
+		if (c.skipDecrypter != nil && c.skipDecrypter.matches(res.Source(), res.Bytes())) ||
+			(c.skipSecrets != nil && c.skipSecrets.matches(kind, res.Bytes())) {
+			logger.Log("debug", "not applying resource to validation cluster; it needs secrets of the cluster proper", "resource", res.ResourceID(), "source", res.Source())
+			summary.Add(cluster.SyncSkipped, kind)
 			continue
 		}
+		// The force-sync annotation overrides the rules that would
+		// have the resource left alone; each time it does, that's
+		// logged, since it's otherwise a surprise.
+		force, forceOnce := forceSync(res, clusterResources[id])
+		if res.Policies().Has(policy.Ignore) {
+			if !force {
+				logger.Log("debug", "not applying resource; ignore annotation in file", "resource", res.ResourceID(), "source", res.Source())
+				summary.Add(cluster.SyncSkipped, kind)
+				continue
+			}
+			logger.Log("info", "applying resource despite ignore annotation in file; it has the force-sync annotation", "resource", res.ResourceID(), "source", res.Source())
+		}
 		// It's possible to give a cluster resource the "ignore"
 		// annotation directly -- e.g., with `kubectl annotate` -- so
 		// we need to examine the cluster resource here too.
 		if cres, ok := clusterResources[id]; ok && cres.Policies().Has(policy.Ignore) {
-			logger.Log("info", "not applying resource; ignore annotation in cluster resource", "resource", cres.ResourceID())
+			if !force {
+				logger.Log("debug", "not applying resource; ignore annotation in cluster resource", "resource", cres.ResourceID())
+				summary.Add(cluster.SyncSkipped, kind)
+				continue
+			}
+			logger.Log("info", "applying resource despite ignore annotation in cluster resource; it has the force-sync annotation", "resource", cres.ResourceID())
+		}
+		hashConfig := res.Policies().Has(policy.ConfigHash)
+		if syncSet.Changed != nil && !force && !(hashConfig && changedConfigs[namespace]) && !c.needsApply(syncSet.Name, resID, checkHex, clusterResources[id], syncSet.Changed) {
+			summary.Add(cluster.SyncUnchanged, kind)
+			continue
+		}
+		if c.Decrypter != nil && c.Decrypter.matches(res.Source(), res.Bytes()) {
+			plaintext, err := c.Decrypter.decrypt(res.Source(), res.Bytes())
+			if err != nil {
+				errs = append(errs, cluster.ResourceError{ResourceID: res.ResourceID(), Source: res.Source(), Error: err})
+				continue
+			}
+			res = rewrittenResource{Resource: res, bytes: plaintext}
+		}
+		if resolveErr != nil {
+			errs = append(errs, cluster.ResourceError{ResourceID: res.ResourceID(), Source: res.Source(), Error: resolveErr})
 			continue
 		}
+		// Encrypted Secrets can only be looked at once decrypted
+		if c.Secrets != nil && c.Secrets.matches(kind, res.Bytes()) {
+			resolved, err := c.Secrets.resolve(namespace, res.Source(), res.Bytes())
+			if err != nil {
+				errs = append(errs, cluster.ResourceError{ResourceID: res.ResourceID(), Source: res.Source(), Error: err})
+				continue
+			}
+			res = rewrittenResource{Resource: res, bytes: resolved}
+		}
+		if hashConfig {
+			withHash, err := withConfigHash(res, hashes)
+			if err != nil {
+				errs = append(errs, cluster.ResourceError{ResourceID: res.ResourceID(), Source: res.Source(), Error: err})
+				continue
+			}
+			res = rewrittenResource{Resource: res, bytes: withHash}
+		}
 		resBytes, err := applyMetadata(res, syncSet.Name, checkHex)
+		if err == nil && !c.keepStatus(kind) {
+			var stripped bool
+			if resBytes, stripped, err = stripStatus(resBytes); stripped {
+				c.logStrippedStatus(logger, resID, res.Source())
+			}
+		}
+		var create bool
 		if err == nil {
-			cs.stage("apply", res.ResourceID(), res.Source(), resBytes)
+			resBytes, create, err = withGeneratedName(resBytes, id, clusterResources[id])
+		}
+		if err == nil {
+			// Jobs are mutated by their controller once created, and
+			// are mostly immutable, so re-applying them is at best
+			// churn; leave them be unless there's a real change.
+			if cres, ok := clusterResources[id]; ok && isBatchKind(kind) && batchResourceUnchanged(resBytes, cres, c.BatchIgnoreFields) {
+				if !force {
+					logger.Log("debug", "not applying resource; unchanged in cluster", "resource", resID)
+					summary.Add(cluster.SyncUnchanged, kind)
+					continue
+				}
+				logger.Log("info", "applying resource despite it differing only in ignored fields; it has the force-sync annotation", "resource", resID)
+			}
+			if forceOnce {
+				forcedOnce[resID] = clusterResources[id]
+			}
+			if create {
+				cs.stageCreate(res.ResourceID(), res.Source(), position, resBytes)
+			} else {
+				cs.stage("apply", res.ResourceID(), res.Source(), position, resBytes)
+			}
 		} else {
 			errs = append(errs, cluster.ResourceError{ResourceID: res.ResourceID(), Source: res.Source(), Error: err})
 			break
 		}
 	}
 
+	errs = append(errs, c.ensureNamespaces(logger, syncSet, clusterResources, &cs, checksums)...)
+
+	c.muSyncErrors.RLock()
+	errored := map[flux.ResourceID]error{}
+	for id, err := range c.syncErrors[syncSet.Name] {
+		errored[id] = err
+	}
+	c.muSyncErrors.RUnlock()
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.muSyncErrors.RLock()
-	if applyErrs := c.applier.apply(logger, cs, c.syncErrors); len(applyErrs) > 0 {
+	// The lock is let go while waiting between applies (e.g., for
+	// resources to be ready), so other syncs aren't held up meanwhile
+	cs.unlocked = func(wait func()) {
+		c.mu.Unlock()
+		defer c.mu.Lock()
+		wait()
+	}
+	if applyErrs := c.applier.apply(logger, cs, errored, summary); len(applyErrs) > 0 {
 		errs = append(errs, applyErrs...)
 	}
-	c.muSyncErrors.RUnlock()
+	c.clearForcedOnce(logger, forcedOnce, errs)
 
-	if c.GC {
-		deleteErrs, gcFailure := c.collectGarbage(syncSet, checksums, logger)
+	if c.Events != nil {
+		c.recordSyncEvents(logger, syncSet.Revision, cs.objs["apply"], errs, clusterResources)
+	}
+
+	// A sync of a single namespace doesn't see the whole repo, so
+	// can't tell what's been removed from it.
+	if c.GC && syncSet.Namespace == "" && !syncSet.NoGC && !syncSet.Partial {
+		deleteErrs, gcFailure := c.collectGarbage(syncSet, checksums, logger, summary)
 		if gcFailure != nil {
-			return gcFailure
+			return summary, gcFailure
 		}
 		errs = append(errs, deleteErrs...)
 	}
 
+	for _, e := range errs {
+		_, kind, _ := e.ResourceID.Components()
+		summary.Add(cluster.SyncFailed, kind)
+	}
+
+	switch {
+	case syncSet.Namespace != "":
+		c.setNamespaceSyncErrors(syncSet.Name, syncSet.Namespace, errs)
+	case syncSet.Partial:
+		var ids []flux.ResourceID
+		for _, res := range syncSet.Resources {
+			ids = append(ids, res.ResourceID())
+		}
+		c.setResourceSyncErrors(syncSet.Name, ids, errs)
+	}
+
 	// If `nil`, errs is a cluster.SyncError(nil) rather than error(nil), so it cannot be returned directly.
 	if errs == nil {
-		return nil
+		return summary, nil
 	}
 
 	// It is expected that Cluster.Sync is invoked with *all* resources.
 	// Otherwise it will override previously recorded sync errors.
-	c.setSyncErrors(errs)
-	return errs
+	if syncSet.Namespace == "" && !syncSet.Partial {
+		c.setSyncErrors(syncSet.Name, errs)
+	}
+	return summary, errs
+}
+
+// inNamespace says whether the resource is in the namespace given, or
+// is the namespace itself.
+func inNamespace(id flux.ResourceID, namespace string) bool {
+	ns, kind, name := id.Components()
+	if ns == kresource.ClusterScope {
+		return kind == "namespace" && name == namespace
+	}
+	return ns == namespace
+}
+
+// recordSyncEvents emits events on the resources that were applied,
+// or that failed to be. Events refer to resources by UID, so those
+// that weren't in the cluster before the apply are each looked up
+// afterwards; except those created from a manifest with
+// `generateName`, which can't be looked up by name, and those that
+// failed to be created.
+func (c *Cluster) recordSyncEvents(logger log.Logger, revision string, applied []applyObject, errs cluster.SyncError, before map[string]*kuberesource) {
+	failed := map[flux.ResourceID]error{}
+	for _, e := range errs {
+		failed[e.ResourceID] = e.Error
+		if res, ok := before[e.ResourceID.String()]; ok {
+			c.Events.record(res, revision, e.Error)
+		}
+	}
+
+	for _, obj := range applied {
+		if _, ok := failed[obj.ResourceID]; ok {
+			continue
+		}
+		res, ok := before[obj.ResourceID.String()]
+		if !ok {
+			if obj.Create {
+				continue
+			}
+			var err error
+			if res, err = c.getCreated(obj); err != nil {
+				logger.Log("err", errors.Wrap(err, "getting created resource for event"), "resource", obj.ResourceID)
+				continue
+			}
+		}
+		c.Events.record(res, revision, nil)
+	}
+}
+
+// getCreated gets the resource in the cluster that the object given
+// was applied as.
+func (c *Cluster) getCreated(obj applyObject) (*kuberesource, error) {
+	var manifest struct {
+		APIVersion string `yaml:"apiVersion"`
+		Kind       string `yaml:"kind"`
+	}
+	if err := yaml.Unmarshal(obj.Payload, &manifest); err != nil {
+		return nil, err
+	}
+	u, err := c.getUnstructured(manifest.APIVersion, manifest.Kind, obj.ResourceID)
+	if err != nil {
+		return nil, err
+	}
+	return &kuberesource{obj: u, namespaced: u.GetNamespace() != ""}, nil
+}
+
+// needsApply decides, in an incremental sync, whether a resource
+// should be applied. Besides those that have changed in git, that
+// includes resources that are missing from the cluster, that failed
+// to sync last time, or that were last applied from a different
+// manifest (e.g., because the daemon was restarted mid-sync).
+func (c *Cluster) needsApply(syncSetName string, id flux.ResourceID, checksum string, cres *kuberesource, changed flux.ResourceIDSet) bool {
+	if changed.Contains(id) || cres == nil || cres.GetChecksum() != checksum {
+		return true
+	}
+	c.muSyncErrors.RLock()
+	defer c.muSyncErrors.RUnlock()
+	_, errored := c.syncErrors[syncSetName][id]
+	return errored
 }
 
 func (c *Cluster) collectGarbage(
 	syncSet cluster.SyncSet,
 	checksums map[string]string,
-	logger log.Logger) (cluster.SyncError, error) {
+	logger log.Logger,
+	summary cluster.SyncSummary) (cluster.SyncError, error) {
 
 	orphanedResources := makeChangeSet()
 
-	clusterResources, err := c.getAllowedGCMarkedResourcesInSyncSet(syncSet.Name)
+	var includeKind func(schema.GroupVersionKind) bool
+	if len(c.GCKinds) > 0 {
+		includeKind = c.isGCKind
+	}
+	clusterResources, err := c.getAllowedGCMarkedResourcesInSyncSet(syncSet.Name, includeKind)
 	if err != nil {
 		return nil, errors.Wrap(err, "collating resources in cluster for calculating garbage collection")
 	}
 
+	now := time.Now()
+	c.muPendingDeletes.Lock()
+	defer c.muPendingDeletes.Unlock()
+	pendingDeletes := map[flux.ResourceID]time.Time{}
+	var terminatingErrs cluster.SyncError
+	var stuck int
+
 	for resourceID, res := range clusterResources {
 		actual := res.GetChecksum()
 		expected, ok := checksums[resourceID]
 
 		switch {
+		case res.Policies().Has(policy.Ignore):
+			logger.Log("debug", "not considering resource for deletion; ignore annotation in cluster resource", "resource", resourceID)
+			continue
+		case !ok && c.GCSelector != nil && !c.GCSelector.Matches(labels.Set(res.obj.GetLabels())):
+			logger.Log("info", "not deleting resource; it does not match the garbage collection selector", "resource", resourceID, "selector", c.GCSelector)
+			continue
+		case !ok && isAutoCreated(res) && c.keepAutoCreatedNamespace(logger, res):
+			continue
 		case !ok: // was not recorded as having been staged for application
+			if c.GCGracePeriod > 0 {
+				since, pending := c.pendingDeletes[syncSet.Name][res.ResourceID()]
+				if !pending {
+					since = now
+				}
+				if now.Sub(since) < c.GCGracePeriod {
+					if !pending {
+						c.logger.Log("info", "cluster resource not in resources to be synced; deleting after grace period", "resource", resourceID, "after", since.Add(c.GCGracePeriod))
+					}
+					pendingDeletes[res.ResourceID()] = since
+					continue
+				}
+			}
+			if since, terminating := res.terminatingSince(); terminating {
+				isStuck, err := c.handleTerminating(logger, res, since, now, summary)
+				if isStuck {
+					stuck++
+				}
+				if err != nil {
+					terminatingErrs = append(terminatingErrs, *err)
+				}
+				continue
+			}
 			c.logger.Log("info", "cluster resource not in resources to be synced; deleting", "resource", resourceID)
-			orphanedResources.stage("delete", res.ResourceID(), "<cluster>", res.IdentifyingBytes())
+			orphanedResources.stage("delete", res.ResourceID(), "<cluster>", 0, res.IdentifyingBytes())
 		case actual != expected:
 			c.logger.Log("warning", "resource to be synced has not been updated; skipping", "resource", resourceID)
 			continue
@@ -148,7 +431,24 @@ func (c *Cluster) collectGarbage(
 		}
 	}
 
-	return c.applier.apply(logger, orphanedResources, nil), nil
+	for id := range c.pendingDeletes[syncSet.Name] {
+		if _, stillPending := pendingDeletes[id]; !stillPending {
+			if _, ok := checksums[id.String()]; ok {
+				c.logger.Log("info", "cluster resource is back in resources to be synced; not deleting", "resource", id)
+			}
+		}
+	}
+	switch {
+	case len(pendingDeletes) == 0:
+		delete(c.pendingDeletes, syncSet.Name)
+	case c.pendingDeletes == nil:
+		c.pendingDeletes = map[string]map[flux.ResourceID]time.Time{syncSet.Name: pendingDeletes}
+	default:
+		c.pendingDeletes[syncSet.Name] = pendingDeletes
+	}
+	stuckDeletions.Set(float64(stuck))
+
+	return append(terminatingErrs, c.applier.apply(logger, orphanedResources, nil, summary)...), nil
 }
 
 // --- internals in support of Sync
@@ -156,11 +456,18 @@ func (c *Cluster) collectGarbage(
 type kuberesource struct {
 	obj        *unstructured.Unstructured
 	namespaced bool
+	gvr        schema.GroupVersionResource
 }
 
 // ResourceID returns the ResourceID for this resource loaded from the
-// cluster.
+// cluster. A resource created from a manifest with `generateName` is
+// identified as the manifest is; see `generatedFromAnnotation`.
 func (r *kuberesource) ResourceID() flux.ResourceID {
+	if from, ok := r.obj.GetAnnotations()[generatedFromAnnotation]; ok {
+		if id, err := flux.ParseResourceID(from); err == nil {
+			return id
+		}
+	}
 	ns, kind, name := r.obj.GetNamespace(), r.obj.GetKind(), r.obj.GetName()
 	if !r.namespaced {
 		ns = kresource.ClusterScope
@@ -193,6 +500,13 @@ func (r *kuberesource) GetGCMark() string {
 }
 
 func (c *Cluster) getAllowedResourcesBySelector(selector string) (map[string]*kuberesource, error) {
+	return c.getAllowedResources(selector, nil)
+}
+
+// getAllowedResources lists the resources matching the selector, of
+// each kind for which includeKind is true, or of every kind if it's
+// nil.
+func (c *Cluster) getAllowedResources(selector string, includeKind func(schema.GroupVersionKind) bool) (map[string]*kuberesource, error) {
 	listOptions := meta_v1.ListOptions{}
 	if selector != "" {
 		listOptions.LabelSelector = selector
@@ -224,6 +538,9 @@ func (c *Cluster) getAllowedResourcesBySelector(selector string) (map[string]*ku
 			if err != nil {
 				return nil, err
 			}
+			if includeKind != nil && !includeKind(groupVersion.WithKind(apiResource.Kind)) {
+				continue
+			}
 			gvr := groupVersion.WithResource(apiResource.Name)
 			list, err := c.listAllowedResources(apiResource.Namespaced, gvr, listOptions)
 			if err != nil {
@@ -246,7 +563,7 @@ func (c *Cluster) getAllowedResourcesBySelector(selector string) (map[string]*ku
 				}
 				// TODO(michael) also exclude anything that has an ownerReference (that isn't "standard"?)
 
-				res := &kuberesource{obj: &list[i], namespaced: apiResource.Namespaced}
+				res := &kuberesource{obj: &list[i], namespaced: apiResource.Namespaced, gvr: gvr}
 				result[res.ResourceID().String()] = res
 			}
 		}
@@ -283,8 +600,11 @@ func (c *Cluster) listAllowedResources(
 	return result, nil
 }
 
-func (c *Cluster) getAllowedGCMarkedResourcesInSyncSet(syncSetName string) (map[string]*kuberesource, error) {
-	allGCMarkedResources, err := c.getAllowedResourcesBySelector(gcMarkLabel) // means "gcMarkLabel exists"
+// getAllowedGCMarkedResourcesInSyncSet lists the resources marked as
+// having been synced in the sync set given, of each kind for which
+// includeKind is true, or of every kind if it's nil.
+func (c *Cluster) getAllowedGCMarkedResourcesInSyncSet(syncSetName string, includeKind func(schema.GroupVersionKind) bool) (map[string]*kuberesource, error) {
+	allGCMarkedResources, err := c.getAllowedResources(gcMarkLabel, includeKind) // means "gcMarkLabel exists"
 	if err != nil {
 		return nil, err
 	}
@@ -299,6 +619,87 @@ func (c *Cluster) getAllowedGCMarkedResourcesInSyncSet(syncSetName string) (map[
 	return allowedSyncSetGCMarkedResources, nil
 }
 
+// ResolvesValues says whether any of the resources given are Secrets
+// with values kept in Vault.
+func (c *Cluster) ResolvesValues(resources map[string]resource.Resource) bool {
+	if c.Secrets == nil {
+		return false
+	}
+	for _, res := range resources {
+		_, kind, _ := res.ResourceID().Components()
+		if c.Secrets.matches(kind, res.Bytes()) {
+			return true
+		}
+	}
+	return false
+}
+
+// SyncedCount gives the number of resources in the cluster that were
+// applied by syncs of the sync set named.
+func (c *Cluster) SyncedCount(syncSetName string) (int, error) {
+	resources, err := c.getAllowedGCMarkedResourcesInSyncSet(syncSetName, nil)
+	if err != nil {
+		return 0, err
+	}
+	return len(resources), nil
+}
+
+// SyncedFingerprint gives a hash of the identity and version of each
+// resource in the cluster applied by syncs of the sync set named. A
+// resource being deleted or replaced changes its UID; having its
+// spec changed, its generation; or if it has no generation (e.g., a
+// ConfigMap), any change at all changes its resourceVersion. So does
+// a sync applying a manifest that's changed, through its checksum.
+func (c *Cluster) SyncedFingerprint(syncSetName string) (string, error) {
+	resources, err := c.getAllowedGCMarkedResourcesInSyncSet(syncSetName, nil)
+	if err != nil {
+		return "", err
+	}
+	var ids []string
+	for id := range resources {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	hash := sha256.New()
+	for _, id := range ids {
+		obj := resources[id].obj
+		version := fmt.Sprintf("generation %d", obj.GetGeneration())
+		if obj.GetGeneration() == 0 {
+			version = "resourceVersion " + obj.GetResourceVersion()
+		}
+		fmt.Fprintf(hash, "%s %s %s %s\n", id, obj.GetUID(), version, resources[id].GetChecksum())
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ExportSynced gives the resources applied by syncs of the sync set
+// named, as a stream of YAML documents ordered by resource ID.
+func (c *Cluster) ExportSynced(syncSetName string) ([]byte, error) {
+	resources, err := c.getAllowedGCMarkedResourcesInSyncSet(syncSetName, nil)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for id := range resources {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var buffer bytes.Buffer
+	for _, id := range ids {
+		// An unstructured object has its own apiVersion and kind, so
+		// is written as it is rather than with appendYAML
+		yamlBytes, err := k8syaml.Marshal(resources[id].obj.Object)
+		if err != nil {
+			return nil, errors.Wrapf(err, "exporting %s", id)
+		}
+		buffer.WriteString("---\n")
+		buffer.Write(yamlBytes)
+	}
+	return buffer.Bytes(), nil
+}
+
 func applyMetadata(res resource.Resource, syncSetName, checksum string) ([]byte, error) {
 	definition := map[interface{}]interface{}{}
 	if err := yaml.Unmarshal(res.Bytes(), &definition); err != nil {
@@ -345,40 +746,183 @@ func makeGCMark(syncSetName, resourceID string) string {
 type applyObject struct {
 	ResourceID flux.ResourceID
 	Source     string
+	Position   int // among the documents in Source
 	Payload    []byte
+	// Create rather than apply it, since it has no name yet; see
+	// `withGeneratedName`
+	Create bool
 }
 
 type changeSet struct {
 	objs map[string][]applyObject
+	// whether the changes bootstrap a fresh cluster; see
+	// cluster.SyncSet
+	bootstrap bool
+	// if not nil, runs the function given (which waits) without
+	// holding the lock of the cluster applying the changes
+	unlocked func(func())
 }
 
 func makeChangeSet() changeSet {
 	return changeSet{objs: make(map[string][]applyObject)}
 }
 
-func (c *changeSet) stage(cmd string, id flux.ResourceID, source string, bytes []byte) {
-	c.objs[cmd] = append(c.objs[cmd], applyObject{id, source, bytes})
+func (c *changeSet) stage(cmd string, id flux.ResourceID, source string, position int, bytes []byte) {
+	c.objs[cmd] = append(c.objs[cmd], applyObject{ResourceID: id, Source: source, Position: position, Payload: bytes})
+}
+
+// stageCreate stages an object to be created along with those
+// applied, in the same order and waves.
+func (c *changeSet) stageCreate(id flux.ResourceID, source string, position int, bytes []byte) {
+	c.objs["apply"] = append(c.objs["apply"], applyObject{ResourceID: id, Source: source, Position: position, Payload: bytes, Create: true})
 }
 
-// Applier is something that will apply a changeset to the cluster.
+// Applier is something that will apply a changeset to the cluster,
+// counting what happened to each resource in the summary given.
 type Applier interface {
-	apply(log.Logger, changeSet, map[flux.ResourceID]error) cluster.SyncError
+	apply(log.Logger, changeSet, map[flux.ResourceID]error, cluster.SyncSummary) cluster.SyncError
 }
 
 type Kubectl struct {
-	exe    string
-	config *rest.Config
+	// Extra environment entries for running kubectl, e.g., to use
+	// a proxy
+	Env []string
+	// The version of kubectl, as given by ClientVersion, if known;
+	// it decides which flags are used for options that need a
+	// particular version (see CheckVersion)
+	Version string
+	// If not empty, kubectl is given this kubeconfig file to connect
+	// with, rather than flags from the config it was created with,
+	// since a cluster in a kubeconfig file may have (e.g.) its
+	// certificate authority inline
+	Kubeconfig string
+	// If not nil, kubectl is given a kubeconfig with a token from
+	// these (in place of Kubeconfig), rather than running the
+	// kubeconfig user's credential plugin itself
+	Credentials *ExecCredentials
+	// Kinds of resource (e.g., "service") to delete and create again
+	// when a change to them can't be applied because it touches an
+	// immutable field
+	RecreateKinds []string
+	// Kinds of resource (e.g., a custom resource that its operator
+	// also changes) to apply with `kubectl apply --force`, so that
+	// they're deleted and created again if patching them keeps
+	// conflicting; see `forceApply`
+	ForceApplyKinds []string
+	// Check each resource with a server-side dry run before applying
+	// it, and only apply those that pass; see `dryRunFilter`
+	ServerDryRun bool
+	// Apply resources with server-side apply, as the field manager
+	// given by `fieldManagerFor`
+	ServerSideApply bool
+	// The field manager to apply resources as, when no rule or
+	// annotation says otherwise; if empty, DefaultFieldManager
+	FieldManager string
+	// Rules giving the field manager for resources by the file
+	// they're in; the first that matches is used
+	FieldManagerRules []FieldManagerRule
+	// How long to wait for each wave of resources (see
+	// `syncWaveAnnotation`) to be ready before giving up on the
+	// waves after it; if zero, defaultWaveTimeout
+	WaveTimeout time.Duration
+	// Readiness timeouts for particular kinds of resource (e.g.,
+	// "job"), overriding WaveTimeout; see `readinessTimeout`
+	WaveTimeoutKinds map[string]time.Duration
+	// What to do when a resource in a wave times out waiting to be
+	// ready; WaveTimeoutFail (the default) or WaveTimeoutProceed
+	WaveTimeoutAction string
+	// How long to wait for CRDs to be established before applying
+	// the custom resources they define in the same wave; if zero,
+	// defaultCRDEstablishTimeout
+	CRDEstablishTimeout time.Duration
+	// Readiness checks for kinds of resource, by lowercase kind and
+	// optionally API group (e.g., "certificate.cert-manager.io"),
+	// used instead of the built-in checks and DefaultReadinessChecks;
+	// see isReady
+	ReadinessChecks map[string]ReadinessCheck
+	// How many times to try applying a resource again, within the
+	// same sync, when it fails with a transient error (e.g., a
+	// conflict); see `retryApply`
+	ApplyRetries int
+	// If non-zero, resources are applied in batches of at most this
+	// many with the same API group (or kind, according to
+	// ApplyBatchBy), pausing for ApplyBatchDelay between batches;
+	// see applyInBatches
+	ApplyBatchSize  int
+	ApplyBatchBy    string
+	ApplyBatchDelay time.Duration
+	// In a bootstrap sync (see cluster.SyncSet), resources of these
+	// kinds are applied first, in waves of their own in the order
+	// given; if empty, DefaultBootstrapKinds
+	BootstrapKinds []string
+	// In a bootstrap sync, the least time to wait for resources to
+	// be ready and CRDs to be established; if zero,
+	// defaultBootstrapTimeout
+	BootstrapTimeout time.Duration
+	// If not empty, the ID of fluxd's own workload, which is applied
+	// after everything else; see applySelf
+	SelfWorkload flux.ResourceID
+	// How long to wait for fluxd's own workload to be ready once
+	// applied; if zero, defaultSelfTimeout
+	SelfTimeout time.Duration
+
+	exe                string
+	config             *rest.Config
+	wavePollInterval   time.Duration
+	applyRetryInterval time.Duration
+	// see changeSet.unlocked
+	unlocked func(func())
 }
 
 func NewKubectl(exe string, config *rest.Config) *Kubectl {
 	return &Kubectl{
-		exe:    exe,
-		config: config,
+		exe:                exe,
+		config:             config,
+		ApplyRetries:       DefaultApplyRetries,
+		applyRetryInterval: defaultApplyRetryInterval,
 	}
 }
 
-func (c *Kubectl) connectArgs() []string {
+// ForConfig gives a copy of the Kubectl, applying in the same way but
+// to the cluster with the config given (e.g., a validation cluster;
+// see sync.Validation). The copy has none of the ways of connecting
+// to this cluster: its Env, Kubeconfig and Credentials are empty.
+// Nor is fluxd's own workload applied last, since it's not in the
+// other cluster.
+func (c *Kubectl) ForConfig(config *rest.Config) *Kubectl {
+	copied := *c
+	copied.config = config
+	copied.Env = nil
+	copied.Kubeconfig = ""
+	copied.Credentials = nil
+	copied.SelfWorkload = flux.ResourceID{}
+	copied.SelfTimeout = 0
+	return &copied
+}
+
+// sleep pauses for the duration given, between applying resources.
+// While applying a changeSet for a cluster, that's done without
+// holding the cluster's lock (see changeSet.unlocked).
+func (c *Kubectl) sleep(d time.Duration) {
+	if c.unlocked == nil {
+		time.Sleep(d)
+		return
+	}
+	c.unlocked(func() { time.Sleep(d) })
+}
+
+func (c *Kubectl) connectArgs() ([]string, error) {
 	var args []string
+	if c.Credentials != nil {
+		kubeconfig, err := c.Credentials.Kubeconfig(c.config)
+		if err != nil {
+			return nil, err
+		}
+		return append(args, fmt.Sprintf("--kubeconfig=%s", kubeconfig)), nil
+	}
+	if c.Kubeconfig != "" {
+		return append(args, fmt.Sprintf("--kubeconfig=%s", c.Kubeconfig)), nil
+	}
 	if c.config.Host != "" {
 		args = append(args, fmt.Sprintf("--server=%s", c.config.Host))
 	}
@@ -400,7 +944,7 @@ func (c *Kubectl) connectArgs() []string {
 	if c.config.BearerToken != "" {
 		args = append(args, fmt.Sprintf("--token=%s", c.config.BearerToken))
 	}
-	return args
+	return args, nil
 }
 
 // rankOfKind returns an int denoting the position of the given kind
@@ -447,18 +991,67 @@ func (objs applyOrder) Less(i, j int) bool {
 	return ranki < rankj
 }
 
-func (c *Kubectl) apply(logger log.Logger, cs changeSet, errored map[flux.ResourceID]error) (errs cluster.SyncError) {
-	f := func(objs []applyObject, cmd string, args ...string) {
+// sortForApply puts objects in the order they should be applied:
+// generally, by the rank of their kind, so that things are created
+// before the things that depend on them; but objects from the same
+// file are kept in the order they appear in the file, since that can
+// express dependencies the ranking doesn't know about. To do both,
+// the objects are first sorted by rank, then the places taken by
+// each file's objects are refilled with those objects in document
+// order.
+func sortForApply(objs []applyObject) {
+	sort.Sort(applyOrder(objs))
+	keepFileOrder(objs)
+}
+
+// keepFileOrder refills the places taken by each file's objects, in
+// those given, with the same objects in the order they appear in the
+// file.
+func keepFileOrder(objs []applyObject) {
+	slots := map[string][]int{}
+	for i, obj := range objs {
+		slots[obj.Source] = append(slots[obj.Source], i)
+	}
+	for _, indices := range slots {
+		if len(indices) < 2 {
+			continue
+		}
+		inFile := make([]applyObject, len(indices))
+		for i, index := range indices {
+			inFile[i] = objs[index]
+		}
+		sort.SliceStable(inFile, func(i, j int) bool {
+			return inFile[i].Position < inFile[j].Position
+		})
+		for i, index := range indices {
+			objs[index] = inFile[i]
+		}
+	}
+}
+
+func (c *Kubectl) apply(logger log.Logger, cs changeSet, errored map[flux.ResourceID]error, summary cluster.SyncSummary) (errs cluster.SyncError) {
+	if cs.unlocked != nil {
+		unlocking := *c
+		unlocking.unlocked = cs.unlocked
+		c = &unlocking
+	}
+	f := func(objs []applyObject, cmd string, args ...string) (errs cluster.SyncError) {
 		if len(objs) == 0 {
-			return
+			return nil
 		}
 		logger.Log("cmd", cmd, "args", strings.Join(args, " "), "count", len(objs))
-		args = append(args, cmd)
+		args = append([]string{cmd}, args...)
 
 		var multi, single []applyObject
-		if len(errored) == 0 {
+		switch {
+		case cmd == "create":
+			// A create can't be retried, since it creates another
+			// resource each time (e.g., with generateName); so each is
+			// done on its own, and only once
+			single = objs
+		case len(errored) == 0:
 			multi = objs
-		} else {
+		default:
 			for _, obj := range objs {
 				if _, ok := errored[obj.ResourceID]; ok {
 					// Resources that errored before shall be applied separately
@@ -471,20 +1064,44 @@ func (c *Kubectl) apply(logger log.Logger, cs changeSet, errored map[flux.Resour
 		}
 
 		if len(multi) > 0 {
-			if err := c.doCommand(logger, makeMultidoc(multi), args...); err != nil {
-				single = append(single, multi...)
+			begin := time.Now()
+			output, err := c.doCommand(logger, makeMultidoc(multi), args...)
+			if err == nil {
+				countOutcomes(output, summary)
+				if cmd != "delete" {
+					observeApplyDuration(multi, time.Since(begin), nil)
+				}
+			} else {
+				// These are applied again one by one below, and timed
+				// then, in the order given. What was reported is
+				// discarded, since it'll be reported again.
+				single = objs
 			}
 		}
 		for _, obj := range single {
+			begin := time.Now()
 			r := bytes.NewReader(obj.Payload)
-			if err := c.doCommand(logger, r, args...); err != nil {
+			output, err := c.doCommand(logger, r, args...)
+			if err != nil && cmd == "apply" && isRetryableError(err) {
+				output, err = c.retryApply(logger, obj, args, err)
+			}
+			if err != nil && cmd == "apply" && isImmutableFieldError(err) {
+				output, err = c.recreate(logger, obj, err)
+			}
+			if cmd != "delete" {
+				observeApplyDuration([]applyObject{obj}, time.Since(begin), err)
+			}
+			if err != nil {
 				errs = append(errs, cluster.ResourceError{
 					ResourceID: obj.ResourceID,
 					Source:     obj.Source,
 					Error:      err,
 				})
+			} else {
+				countOutcomes(output, summary)
 			}
 		}
+		return errs
 	}
 
 	// When deleting objects, the only real concern is that we don't
@@ -495,17 +1112,151 @@ func (c *Kubectl) apply(logger log.Logger, cs changeSet, errored map[flux.Resour
 	// least.
 	objs := cs.objs["delete"]
 	sort.Sort(sort.Reverse(applyOrder(objs)))
-	f(objs, "delete")
-
-	objs = cs.objs["apply"]
-	sort.Sort(applyOrder(objs))
-	f(objs, "apply")
+	// Don't wait for deletions to complete; anything held up by
+	// finalizers is dealt with by later syncs.
+	errs = append(errs, f(objs, "delete", "--wait=false")...)
+
+	objs, self := c.splitSelf(cs.objs["apply"])
+	sortForApply(objs)
+	waves, waveErrs := groupWaves(objs)
+	errs = append(errs, waveErrs...)
+	if cs.bootstrap {
+		waves = bootstrapWaves(waves, c.bootstrapKinds())
+		logger.Log("info", "bootstrapping cluster; applying resources of these kinds first", "kinds", strings.Join(c.bootstrapKinds(), ","))
+	}
+	applyWave := func(objs []applyObject) cluster.SyncError {
+		// Each wave is dry-run only once the waves before it are
+		// applied, since it may depend on them (e.g., for CRDs).
+		// Those to be created can't be dry-run, or applied in any
+		// of the ways below.
+		objs, created := splitCreated(objs)
+		var rejected cluster.SyncError
+		if c.ServerDryRun {
+			objs, rejected = c.dryRunFilter(logger, objs)
+		}
+		normal, forced := c.splitForced(logger, objs)
+		applyErrs := rejected
+		if c.ServerSideApply {
+			runs, managerErrs := c.groupByFieldManager(normal)
+			applyErrs = append(applyErrs, managerErrs...)
+			for _, run := range runs {
+				applyErrs = append(applyErrs, f(run.objs, "apply", "--server-side", "--field-manager="+run.manager)...)
+			}
+		} else {
+			applyErrs = append(applyErrs, f(normal, "apply")...)
+		}
+		applyErrs = append(applyErrs, f(created, "create")...)
+		return append(applyErrs, c.forceApply(logger, forced, summary)...)
+	}
+	errs = append(errs, c.applyWaves(logger, waves, func(objs []applyObject) cluster.SyncError {
+		return c.applyInBatches(logger, objs, applyWave)
+	})...)
+	if self != nil {
+		errs = append(errs, c.applySelf(logger, *self, applyWave)...)
+	}
 	return errs
 }
 
-func (c *Kubectl) doCommand(logger log.Logger, r io.Reader, args ...string) error {
+// observeApplyDuration records how long an attempt at applying the
+// objects given took, labelled with the kinds of those objects. It's
+// a variable so that tests can see what's observed.
+var observeApplyDuration = func(objs []applyObject, took time.Duration, err error) {
+	applyDuration.With("kinds", applyKinds(objs), fluxmetrics.LabelSuccess, fmt.Sprint(err == nil)).Observe(took.Seconds())
+}
+
+// applyKinds gives the kinds of the objects given, each once, sorted
+// and separated by commas. There are only as many of these as there
+// are combinations of kinds applied together, so it's fit for a
+// label.
+func applyKinds(objs []applyObject) string {
+	seen := map[string]bool{}
+	var kinds []string
+	for _, obj := range objs {
+		_, kind, _ := obj.ResourceID.Components()
+		if !seen[kind] {
+			seen[kind] = true
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Strings(kinds)
+	return strings.Join(kinds, ",")
+}
+
+// dryRunFilter applies the objects given with a server-side dry run,
+// so they go through validation and admission control without being
+// persisted, and returns those that passed, and errors for those
+// that were rejected. The objects are dry-run together first, and
+// only one by one if that fails, in the same way as a real apply.
+//
+// Objects in the same wave are dry-run together, before any of them
+// is applied; so an object in a namespace, or of a kind defined by a
+// CRD, created in the same sync can't pass. Those are let through to
+// be applied after their namespace or CRD, rather than rejected.
+func (c *Kubectl) dryRunFilter(logger log.Logger, objs []applyObject) ([]applyObject, cluster.SyncError) {
+	if len(objs) == 0 {
+		return objs, nil
+	}
+	args := c.serverDryRunArgs()
+	if _, err := c.doCommand(logger, makeMultidoc(objs), args...); err == nil {
+		return objs, nil
+	}
+	var passed []applyObject
+	var rejected cluster.SyncError
+	for _, obj := range objs {
+		_, err := c.doCommand(logger, bytes.NewReader(obj.Payload), args...)
+		// Changes to immutable fields can be dealt with when
+		// applying for real, by recreating the resource
+		if err != nil && isImmutableFieldError(err) && c.mayRecreate(obj) == nil {
+			err = nil
+		}
+		if err != nil && isMissingDependencyError(err) {
+			logger.Log("dry-run", "skipped", "resource", obj.ResourceID, "reason", "its namespace or kind doesn't exist yet", "err", err)
+			err = nil
+		}
+		if err != nil {
+			logger.Log("dry-run", "rejected", "resource", obj.ResourceID, "err", err)
+			rejected = append(rejected, cluster.ResourceError{
+				ResourceID: obj.ResourceID,
+				Source:     obj.Source,
+				Error:      errors.Wrap(err, "rejected in server-side dry run"),
+			})
+			continue
+		}
+		passed = append(passed, obj)
+	}
+	return passed, rejected
+}
+
+// missingDependencyMessages are what kubectl says when a resource
+// can't be checked because its namespace, or the CRD defining its
+// kind, doesn't exist.
+var missingDependencyMessages = []string{
+	"no matches for kind",
+	"the server could not find the requested resource",
+}
+
+// isMissingDependencyError reports whether the error given, from a
+// dry run, is because the resource's namespace or kind doesn't exist
+// yet.
+func isMissingDependencyError(err error) bool {
+	msg := err.Error()
+	for _, m := range missingDependencyMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return strings.Contains(msg, "NotFound") && strings.Contains(msg, "namespaces \"")
+}
+
+// doCommand runs kubectl with the input and arguments given,
+// returning what it printed.
+func (c *Kubectl) doCommand(logger log.Logger, r io.Reader, args ...string) (string, error) {
 	args = append(args, "-f", "-")
-	cmd := c.kubectlCommand(args...)
+	cmd, err := c.kubectlCommand(args...)
+	if err != nil {
+		logger.Log("cmd", "kubectl "+strings.Join(args, " "), "err", err)
+		return "", err
+	}
 	cmd.Stdin = r
 	stderr := &bytes.Buffer{}
 	cmd.Stderr = stderr
@@ -513,13 +1264,40 @@ func (c *Kubectl) doCommand(logger log.Logger, r io.Reader, args ...string) erro
 	cmd.Stdout = stdout
 
 	begin := time.Now()
-	err := cmd.Run()
+	err = cmd.Run()
 	if err != nil {
 		err = errors.Wrap(errors.New(strings.TrimSpace(stderr.String())), "running kubectl")
 	}
 
-	logger.Log("cmd", "kubectl "+strings.Join(args, " "), "took", time.Since(begin), "err", err, "output", strings.TrimSpace(stdout.String()))
-	return err
+	output := strings.TrimSpace(stdout.String())
+	logger.Log("cmd", "kubectl "+strings.Join(args, " "), "took", time.Since(begin), "err", err, "output", output)
+	return output, err
+}
+
+// countOutcomes counts the outcomes kubectl reports, one per line,
+// in the summary given. kubectl reports each object as, e.g.,
+// `deployment.apps/helloworld configured` or (when deleting, in
+// some versions) `deployment.extensions "helloworld" deleted`.
+func countOutcomes(output string, summary cluster.SyncSummary) {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		outcome := fields[len(fields)-1]
+		// Server-side apply doesn't say whether anything changed
+		if outcome == "serverside-applied" {
+			outcome = cluster.SyncConfigured
+		}
+		switch outcome {
+		case cluster.SyncCreated, cluster.SyncConfigured, cluster.SyncUnchanged, cluster.SyncDeleted:
+		default:
+			continue
+		}
+		kind := strings.SplitN(fields[0], "/", 2)[0]
+		kind = strings.SplitN(kind, ".", 2)[0]
+		summary.Add(outcome, kind)
+	}
 }
 
 func makeMultidoc(objs []applyObject) *bytes.Buffer {
@@ -531,6 +1309,30 @@ func makeMultidoc(objs []applyObject) *bytes.Buffer {
 	return buf
 }
 
-func (c *Kubectl) kubectlCommand(args ...string) *exec.Cmd {
-	return exec.Command(c.exe, append(c.connectArgs(), args...)...)
+func (c *Kubectl) kubectlCommand(args ...string) (*exec.Cmd, error) {
+	connect, err := c.connectArgs()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(c.exe, append(connect, args...)...)
+	if c.config.UserAgent != "" {
+		cmd.Args[0] = kubectlProgramName(c.config.UserAgent)
+	}
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	return cmd, nil
+}
+
+// kubectlProgramName gives the name to run kubectl as, so that its
+// requests can be told apart as fluxd's. This sets only the prefix
+// of kubectl's user-agent, not the whole of it: kubectl has no flag
+// for its user-agent, nor can one be given in a kubeconfig, and it
+// always uses the base name it's run as, followed by its version
+// (e.g., `kubectl/v1.14.0 (linux/amd64) kubernetes/641856d`). So the
+// slashes in the user-agent, which would be taken as a path, are
+// replaced, and the API server sees `fluxd/1.13.0 prod` as
+// `fluxd-1.13.0 prod/v1.14.0 (linux/amd64) kubernetes/641856d`.
+func kubectlProgramName(userAgent string) string {
+	return strings.Replace(userAgent, "/", "-", -1)
 }
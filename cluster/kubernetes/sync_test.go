@@ -1,10 +1,16 @@
 package kubernetes
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ghodss/yaml"
 	"github.com/go-kit/kit/log"
@@ -12,6 +18,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	//	"k8s.io/apimachinery/pkg/runtime/serializer"
@@ -23,6 +30,7 @@ import (
 	"k8s.io/client-go/discovery"
 	k8sclient "k8s.io/client-go/kubernetes"
 	corefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
 	k8s_testing "k8s.io/client-go/testing"
 
 	"github.com/weaveworks/flux"
@@ -107,7 +115,7 @@ func groupVersionResource(res *unstructured.Unstructured) schema.GroupVersionRes
 	return schema.GroupVersionResource{Group: gvk.Group, Version: gvk.Version, Resource: strings.ToLower(gvk.Kind) + "s"}
 }
 
-func (a fakeApplier) apply(_ log.Logger, cs changeSet, errored map[flux.ResourceID]error) cluster.SyncError {
+func (a fakeApplier) apply(_ log.Logger, cs changeSet, errored map[flux.ResourceID]error, summary cluster.SyncSummary) cluster.SyncError {
 	var errs []cluster.ResourceError
 
 	operate := func(obj applyObject, cmd string) {
@@ -148,10 +156,27 @@ func (a fakeApplier) apply(_ log.Logger, cs changeSet, errored map[flux.Resource
 		}
 		name := res.GetName()
 
-		if cmd == "apply" {
+		_, kind, _ := obj.ResourceID.Components()
+		if cmd == "apply" && obj.Create {
+			// As the API server does, for `kubectl create`
+			name = res.GetGenerateName() + fmt.Sprintf("%05d", rand.Intn(100000))
+			res.SetName(name)
+			if _, err := dc.Create(res); err != nil {
+				errs = append(errs, cluster.ResourceError{obj.ResourceID, obj.Source, err})
+				return
+			}
+			summary.Add(cluster.SyncCreated, kind)
+		} else if cmd == "apply" {
+			if name == "" {
+				// As `kubectl apply` refuses to
+				errs = append(errs, cluster.ResourceError{obj.ResourceID, obj.Source, fmt.Errorf("cannot use generate name with apply")})
+				return
+			}
+			outcome := cluster.SyncConfigured
 			_, err := dc.Get(name, metav1.GetOptions{})
 			switch {
 			case errors.IsNotFound(err):
+				outcome = cluster.SyncCreated
 				_, err = dc.Create(res) //, &metav1.CreateOptions{})
 			case err == nil:
 				_, err = dc.Update(res) //, &metav1.UpdateOptions{})
@@ -160,6 +185,7 @@ func (a fakeApplier) apply(_ log.Logger, cs changeSet, errored map[flux.Resource
 				errs = append(errs, cluster.ResourceError{obj.ResourceID, obj.Source, err})
 				return
 			}
+			summary.Add(outcome, kind)
 			if res.GetKind() == "Namespace" {
 				// We also create namespaces in the core fake client since the dynamic client
 				// and core clients don't share resources
@@ -186,6 +212,7 @@ func (a fakeApplier) apply(_ log.Logger, cs changeSet, errored map[flux.Resource
 				errs = append(errs, cluster.ResourceError{obj.ResourceID, obj.Source, err})
 				return
 			}
+			summary.Add(cluster.SyncDeleted, kind)
 			if res.GetKind() == "Namespace" {
 				// We also create namespaces in the core fake client since the dynamic client
 				// and core clients don't share resources
@@ -243,7 +270,7 @@ func setup(t *testing.T) (*Cluster, *fakeApplier) {
 
 func TestSyncNop(t *testing.T) {
 	kube, mock := setup(t)
-	if err := kube.Sync(cluster.SyncSet{}); err != nil {
+	if _, err := kube.Sync(cluster.SyncSet{}); err != nil {
 		t.Errorf("%#v", err)
 	}
 	if mock.commandRun {
@@ -304,7 +331,7 @@ metadata:
 		}
 	}
 
-	test := func(t *testing.T, kube *Cluster, defs, expectedAfterSync string, expectErrors bool) {
+	testWithOptions := func(t *testing.T, kube *Cluster, opts sync.Options, defs, expectedAfterSync string, expectErrors bool) cluster.SyncSummary {
 		saved := getDefaultNamespace
 		getDefaultNamespace = func() (string, error) { return defaultTestNamespace, nil }
 		defer func() { getDefaultNamespace = saved }()
@@ -319,12 +346,12 @@ metadata:
 		}
 
 		// Needed to get from KubeManifest to resource.Resource
-		resources, err := postProcess(resources0, namespacer)
+		resources, err := postProcess(resources0, namespacer, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		err = sync.Sync("testset", resources, kube)
+		summary, err := sync.SyncWithOptions("testset", resources, kube, opts)
 		if !expectErrors && err != nil {
 			t.Error(err)
 		}
@@ -334,7 +361,7 @@ metadata:
 		}
 
 		// Now check that the resources were created
-		actual, err := kube.getAllowedGCMarkedResourcesInSyncSet("testset")
+		actual, err := kube.getAllowedGCMarkedResourcesInSyncSet("testset", nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -356,6 +383,11 @@ metadata:
 			// no need to compare values, since we already considered
 			// the intersection of actual and expected above.
 		}
+		return summary
+	}
+
+	test := func(t *testing.T, kube *Cluster, defs, expectedAfterSync string, expectErrors bool) cluster.SyncSummary {
+		return testWithOptions(t, kube, sync.Options{}, defs, expectedAfterSync, expectErrors)
 	}
 
 	t.Run("sync adds and GCs resources", func(t *testing.T) {
@@ -375,6 +407,223 @@ metadata:
 		test(t, kube, "", "", false)
 	})
 
+	t.Run("sync summarises what happened to resources", func(t *testing.T) {
+		kube, _ := setup(t)
+		kube.GC = true
+
+		summary := test(t, kube, ns1+defs1+defs2, ns1+defs1+defs2, false)
+		assert.Equal(t, cluster.SyncSummary{
+			cluster.SyncCreated: {"namespace": 1, "deployment": 2},
+		}, summary)
+
+		summary = test(t, kube, ns1+defs1, ns1+defs1, false)
+		assert.Equal(t, cluster.SyncSummary{
+			cluster.SyncConfigured: {"namespace": 1, "deployment": 1},
+			cluster.SyncDeleted:    {"deployment": 1},
+		}, summary)
+		assert.Equal(t, "created 0, configured 2, unchanged 0, deleted 1, skipped 0, failed 0", summary.String())
+	})
+
+	t.Run("sync of a namespace leaves other namespaces alone", func(t *testing.T) {
+		kube, _ := setup(t)
+		kube.GC = true
+
+		test(t, kube, ns1+defs1+ns3+defs3, ns1+defs1+ns3+defs3, false)
+		// Resources outside the namespace are neither applied nor
+		// garbage collected
+		opts := sync.Options{Namespace: "foobar"}
+		summary := testWithOptions(t, kube, opts, ns1+defs2, ns1+defs1+defs2+ns3+defs3, false)
+		assert.Equal(t, cluster.SyncSummary{
+			cluster.SyncConfigured: {"namespace": 1},
+			cluster.SyncCreated:    {"deployment": 1},
+		}, summary)
+		testWithOptions(t, kube, opts, defs3, ns1+defs1+defs2+ns3+defs3, false)
+		// A full sync still cleans up
+		test(t, kube, ns1+defs2, ns1+defs2, false)
+	})
+
+	t.Run("sync matches resources with generateName across syncs", func(t *testing.T) {
+		kube, _ := setup(t)
+		kube.GC = true
+
+		const generated = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  generateName: gen-
+  namespace: foobar
+spec:
+  replicas: 1
+`
+		const generatedChanged = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  generateName: gen-
+  namespace: foobar
+spec:
+  replicas: 2
+`
+		deploymentsInCluster := func() []unstructured.Unstructured {
+			list, err := kube.client.dynamicClient.Resource(schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}).Namespace("foobar").List(metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return list.Items
+		}
+
+		summary := test(t, kube, ns1+generated, ns1+generated, false)
+		assert.Equal(t, 1, summary[cluster.SyncCreated]["deployment"])
+		deps := deploymentsInCluster()
+		if assert.Len(t, deps, 1) {
+			assert.NotEqual(t, "", deps[0].GetName())
+		}
+		name := deps[0].GetName()
+
+		// Syncing again applies to the same resource, rather than
+		// creating another
+		summary = test(t, kube, ns1+generated, ns1+generated, false)
+		assert.Equal(t, 0, summary[cluster.SyncCreated]["deployment"])
+		deps = deploymentsInCluster()
+		if assert.Len(t, deps, 1) {
+			assert.Equal(t, name, deps[0].GetName())
+		}
+
+		// .. including when the manifest changes
+		test(t, kube, ns1+generatedChanged, ns1+generatedChanged, false)
+		deps = deploymentsInCluster()
+		if assert.Len(t, deps, 1) {
+			assert.Equal(t, name, deps[0].GetName())
+		}
+
+		// Once the manifest is removed, it's garbage collected
+		test(t, kube, ns1, ns1, false)
+		assert.Len(t, deploymentsInCluster(), 0)
+	})
+
+	t.Run("sync with GC grace period only deletes once the period is over", func(t *testing.T) {
+		kube, _ := setup(t)
+		kube.GC = true
+		kube.GCGracePeriod = time.Hour
+
+		test(t, kube, ns1+defs1+defs2, ns1+defs1+defs2, false)
+		// defs2 removed; it should be kept for now
+		test(t, kube, ns1+defs1, ns1+defs1+defs2, false)
+		if len(kube.pendingDeletes) == 0 {
+			t.Fatal("expected resources to be pending deletion")
+		}
+		pending := kube.PendingDeletions("testset")
+		if assert.NotEmpty(t, pending) {
+			assert.True(t, pending[0].DeleteAfter.After(time.Now().Add(50*time.Minute)))
+		}
+		assert.Empty(t, kube.PendingDeletions("another-sync-set"))
+		// defs2 restored; the pending deletion should be cancelled
+		test(t, kube, ns1+defs1+defs2, ns1+defs1+defs2, false)
+		if len(kube.pendingDeletes) != 0 {
+			t.Fatalf("expected no resources pending deletion, got %v", kube.pendingDeletes)
+		}
+
+		// defs2 removed, and the grace period elapses
+		test(t, kube, ns1+defs1, ns1+defs1+defs2, false)
+		for _, pending := range kube.pendingDeletes {
+			for id := range pending {
+				pending[id] = time.Now().Add(-2 * time.Hour)
+			}
+		}
+		test(t, kube, ns1+defs1, ns1+defs1, false)
+		if len(kube.pendingDeletes) != 0 {
+			t.Fatalf("expected no resources pending deletion, got %v", kube.pendingDeletes)
+		}
+	})
+
+	t.Run("sync only deletes resources matching the garbage collection selector", func(t *testing.T) {
+		kube, _ := setup(t)
+		kube.GC = true
+		kube.GCSelector = labels.SelectorFromSet(labels.Set{"prune": "true"})
+
+		const prunable = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: dep4
+  namespace: foobar
+  labels:
+    prune: "true"
+`
+		test(t, kube, ns1+defs1+prunable, ns1+defs1+prunable, false)
+		// Only dep4 has the label, so the rest stays
+		test(t, kube, "", ns1+defs1, false)
+	})
+
+	t.Run("sync only deletes resources of the garbage collection kinds", func(t *testing.T) {
+		kube, _ := setup(t)
+		kube.GC = true
+		kinds, err := ParseGCKinds([]string{"apps/v1/Deployment"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		kube.GCKinds = kinds
+
+		test(t, kube, ns1+defs1, ns1+defs1, false)
+		// Only the deployments are of a kind given, so the namespace
+		// stays
+		test(t, kube, "", ns1, false)
+	})
+
+	t.Run("sync creates missing namespaces, and GCs them once empty", func(t *testing.T) {
+		kube, _ := setup(t)
+		kube.GC = true
+		kube.MissingNamespaces = MissingNamespacesCreate
+
+		test(t, kube, defs1, ns1+defs1, false)
+		// The deployment is deleted first, then its namespace, at the
+		// next sync
+		test(t, kube, "", ns1, false)
+		test(t, kube, "", "", false)
+	})
+
+	t.Run("sync doesn't GC an auto-created namespace with unmanaged resources in it", func(t *testing.T) {
+		kube, _ := setup(t)
+		kube.GC = true
+		kube.MissingNamespaces = MissingNamespacesCreate
+
+		test(t, kube, defs1, ns1+defs1, false)
+
+		const unmanaged = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  namespace: foobar
+  name: unmanaged
+`
+		var obj map[string]interface{}
+		err := yaml.Unmarshal([]byte(unmanaged), &obj)
+		assert.NoError(t, err)
+		res := &unstructured.Unstructured{Object: obj}
+		dc := kube.client.dynamicClient.Resource(groupVersionResource(res)).Namespace(res.GetNamespace())
+		_, err = dc.Create(res)
+		assert.NoError(t, err)
+
+		// The synced deployment is deleted, but the namespace stays
+		// while the unmanaged deployment is in it
+		test(t, kube, "", ns1, false)
+		test(t, kube, "", ns1, false)
+		_, err = dc.Get(res.GetName(), metav1.GetOptions{})
+		assert.NoError(t, err)
+
+		// Once that's gone, so is the namespace
+		err = dc.Delete(res.GetName(), &metav1.DeleteOptions{})
+		assert.NoError(t, err)
+		test(t, kube, "", "", false)
+	})
+
+	t.Run("sync fails resources in missing namespaces", func(t *testing.T) {
+		kube, _ := setup(t)
+		kube.MissingNamespaces = MissingNamespacesFail
+
+		test(t, kube, defs1+ns3+defs3, ns3+defs3, true)
+	})
+
 	t.Run("sync won't incorrectly delete non-namespaced resources", func(t *testing.T) {
 		kube, _ := setup(t)
 		kube.GC = true
@@ -571,6 +820,89 @@ spec:
 		test(t, kube, ns1+mod1, ns1+dep1, false)
 	})
 
+	t.Run("sync updates a cluster resource marked with ignore if it's forced", func(t *testing.T) {
+		const dep1 = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  namespace: foobar
+  name: dep1
+spec:
+  metadata:
+    labels:
+      app: original
+`
+		kube, _ := setup(t)
+		test(t, kube, ns1+dep1, ns1+dep1, false)
+
+		rc := kube.client.dynamicClient.Resource(schema.GroupVersionResource{
+			Group:    "apps",
+			Version:  "v1",
+			Resource: "deployments",
+		}).Namespace("foobar")
+		res, err := rc.Get("dep1", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		annots := res.GetAnnotations()
+		annots["flux.weave.works/ignore"] = "true"
+		annots["flux.weave.works/force-sync"] = "once"
+		res.SetAnnotations(annots)
+		if _, err = rc.Update(res); err != nil {
+			t.Fatal(err)
+		}
+
+		const mod1 = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  namespace: foobar
+  name: dep1
+spec:
+  metadata:
+    labels:
+      app: modified
+`
+		test(t, kube, ns1+mod1, ns1+mod1, false)
+		res, err = rc.Get("dep1", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := res.GetAnnotations()["flux.weave.works/force-sync"]; ok {
+			t.Error("expected the force-sync annotation to be removed once the resource was applied")
+		}
+	})
+
+	t.Run("sync doesn't delete a cluster resource marked with ignore", func(t *testing.T) {
+		kube, _ := setup(t)
+		kube.GC = true
+
+		// dep1 is created through syncing, so gets a GC mark
+		test(t, kube, ns1+defs1, ns1+defs1, false)
+
+		// Now mark it as ignored in the cluster
+		rc := kube.client.dynamicClient.Resource(schema.GroupVersionResource{
+			Group:    "apps",
+			Version:  "v1",
+			Resource: "deployments",
+		}).Namespace("foobar")
+		res, err := rc.Get("dep1", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		annots := res.GetAnnotations()
+		annots["flux.weave.works/ignore"] = "true"
+		res.SetAnnotations(annots)
+		if _, err = rc.Update(res); err != nil {
+			t.Fatal(err)
+		}
+
+		// Removing dep1 from the repo doesn't mean it's deleted
+		test(t, kube, ns1, ns1+defs1, false)
+		_, err = rc.Get("dep1", metav1.GetOptions{})
+		assert.NoError(t, err)
+	})
+
 	t.Run("sync doesn't update or delete a pre-existing resource marked with ignore", func(t *testing.T) {
 		kube, _ := setup(t)
 
@@ -653,3 +985,611 @@ func TestApplyOrder(t *testing.T) {
 		}
 	}
 }
+
+// TestCountOutcomes checks that what kubectl reports is counted
+// correctly.
+func TestCountOutcomes(t *testing.T) {
+	const output = `namespace/foo unchanged
+deployment.apps/helloworld configured
+service/helloworld created
+customresourcedefinition.apiextensions.k8s.io/things.example.com created
+deployment.extensions "old" deleted
+Warning: something to ignore`
+	summary := cluster.SyncSummary{}
+	countOutcomes(output, summary)
+	assert.Equal(t, cluster.SyncSummary{
+		cluster.SyncUnchanged:  {"namespace": 1},
+		cluster.SyncConfigured: {"deployment": 1},
+		cluster.SyncCreated:    {"service": 1, "customresourcedefinition": 1},
+		cluster.SyncDeleted:    {"deployment": 1},
+	}, summary)
+}
+
+// TestApplyOrderWithinFile checks that objects from the same file are
+// applied in the order they appear in the file, while everything
+// else is ordered by kind.
+func TestApplyOrderWithinFile(t *testing.T) {
+	objs := []applyObject{
+		{ResourceID: flux.MakeResourceID("test", "Deployment", "a-deploy"), Source: "other.yaml"},
+		{ResourceID: flux.MakeResourceID("test", "Secret", "secret"), Source: "bootstrap.yaml", Position: 0},
+		{ResourceID: flux.MakeResourceID("test", "Deployment", "b-deploy"), Source: "bootstrap.yaml", Position: 1},
+		{ResourceID: flux.MakeResourceID("test", "ConfigMap", "config"), Source: "bootstrap.yaml", Position: 2},
+		{ResourceID: flux.MakeResourceID("", "Namespace", "namespace"), Source: "ns.yaml"},
+	}
+	sortForApply(objs)
+	for i, name := range []string{"namespace", "secret", "b-deploy", "a-deploy", "config"} {
+		_, _, objName := objs[i].ResourceID.Components()
+		if objName != name {
+			t.Errorf("Expected %q at position %d, got %q", name, i, objName)
+		}
+	}
+}
+
+// orderRecordingApplier records the order in which objects would be
+// applied.
+type orderRecordingApplier struct {
+	applied []flux.ResourceID
+}
+
+func (a *orderRecordingApplier) apply(_ log.Logger, cs changeSet, _ map[flux.ResourceID]error, _ cluster.SyncSummary) cluster.SyncError {
+	objs := cs.objs["apply"]
+	sortForApply(objs)
+	for _, obj := range objs {
+		a.applied = append(a.applied, obj.ResourceID)
+	}
+	return nil
+}
+
+// waitingApplier waits as Kubectl does between applies, taking the
+// cluster's lock meanwhile, as another sync would.
+type waitingApplier struct {
+	cluster *Cluster
+	locked  bool
+}
+
+func (a *waitingApplier) apply(_ log.Logger, cs changeSet, _ map[flux.ResourceID]error, _ cluster.SyncSummary) cluster.SyncError {
+	cs.unlocked(func() {
+		locked := make(chan struct{})
+		go func() {
+			a.cluster.mu.Lock()
+			a.cluster.mu.Unlock()
+			close(locked)
+		}()
+		select {
+		case <-locked:
+			a.locked = true
+		case <-time.After(5 * time.Second):
+		}
+	})
+	return nil
+}
+
+// TestSyncLetsGoOfLockWhileWaiting checks that a sync doesn't hold
+// the cluster's lock while waiting between applies.
+func TestSyncLetsGoOfLockWhileWaiting(t *testing.T) {
+	kube, _ := setup(t)
+	applier := &waitingApplier{cluster: kube}
+	kube.applier = applier
+	if _, err := kube.Sync(cluster.SyncSet{}); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, applier.locked, "expected the lock to be let go while waiting")
+}
+
+// TestSyncKeepsOrderWithinFile checks that document order in a file
+// survives loading and syncing.
+func TestSyncKeepsOrderWithinFile(t *testing.T) {
+	const defs = `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config-b
+  namespace: foo
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config-a
+  namespace: foo
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: account
+  namespace: foo
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: dep
+  namespace: foo
+`
+	clients := fakeClients()
+	applier := &orderRecordingApplier{}
+	kube := &Cluster{applier: applier, client: clients, logger: log.NewNopLogger()}
+
+	manifests, err := kresource.ParseMultidoc([]byte(defs), "bootstrap.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources, err := postProcess(manifests, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sync.Sync("testset", resources, kube); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []flux.ResourceID{
+		flux.MustParseResourceID("foo:configmap/config-b"),
+		flux.MustParseResourceID("foo:configmap/config-a"),
+		flux.MustParseResourceID("foo:serviceaccount/account"),
+		flux.MustParseResourceID("foo:deployment/dep"),
+	}
+	assert.Equal(t, expected, applier.applied)
+}
+
+// TestSyncValidationLeavesOutSecrets checks that a cluster synced to
+// for validation isn't given the resources that need the secrets of
+// the cluster proper.
+func TestSyncValidationLeavesOutSecrets(t *testing.T) {
+	const config = `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: foo
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: from-vault
+  namespace: foo
+stringData:
+  password: vault://secret/data/app#password
+`
+	const encrypted = `---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: encrypted
+  namespace: foo
+`
+	clients := fakeClients()
+	proper := &Cluster{
+		client:    clients,
+		logger:    log.NewNopLogger(),
+		Decrypter: &SOPSDecrypter{FilePatterns: []string{"secrets/*.yaml"}},
+		Secrets:   &VaultResolver{},
+	}
+	applier := &orderRecordingApplier{}
+	validation := proper.ForValidation(clients, applier, log.NewNopLogger())
+
+	manifests, err := kresource.ParseMultidoc([]byte(config), "config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets, err := kresource.ParseMultidoc([]byte(encrypted), "secrets/creds.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, m := range secrets {
+		manifests[id] = m
+	}
+	resources, err := postProcess(manifests, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sync.Sync("testset", resources, validation); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []flux.ResourceID{flux.MustParseResourceID("foo:configmap/config")}, applier.applied)
+}
+
+// TestSyncIncremental checks that an incremental sync applies only
+// the resources that have changed, or otherwise need applying.
+func TestSyncIncremental(t *testing.T) {
+	const defs = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: dep1
+  namespace: foobar
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: dep2
+  namespace: foobar
+`
+	kube, _ := setup(t)
+	manifests, err := kresource.ParseMultidoc([]byte(defs), "deployments.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources, err := postProcess(manifests, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dep1 := flux.MustParseResourceID("foobar:deployment/dep1")
+
+	summary, err := sync.Sync("testset", resources, kube)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, summary.Count(cluster.SyncCreated))
+
+	// Nothing has changed, so nothing should be applied
+	summary, err = sync.SyncWithOptions("testset", resources, kube, sync.Options{Changed: flux.ResourceIDSet{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, cluster.SyncSummary{cluster.SyncUnchanged: {"deployment": 2}}, summary)
+
+	// Only the resource that changed should be applied
+	summary, err = sync.SyncWithOptions("testset", resources, kube, sync.Options{Changed: flux.ResourceIDSet{dep1: struct{}{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, cluster.SyncSummary{
+		cluster.SyncConfigured: {"deployment": 1},
+		cluster.SyncUnchanged:  {"deployment": 1},
+	}, summary)
+
+	// A resource that failed to sync should be tried again
+	kube.setSyncErrors("testset", cluster.SyncError{{ResourceID: dep1, Error: fmt.Errorf("failed")}})
+	summary, err = sync.SyncWithOptions("testset", resources, kube, sync.Options{Changed: flux.ResourceIDSet{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, summary.Count(cluster.SyncConfigured))
+
+	// Errors recorded for another sync set don't replace those of
+	// this one, so the resource is still tried again
+	dep2 := flux.MustParseResourceID("foobar:deployment/dep2")
+	kube.setSyncErrors("otherset", cluster.SyncError{{ResourceID: dep2, Error: fmt.Errorf("failed")}})
+	assert.Error(t, kube.syncError(dep1))
+	summary, err = sync.SyncWithOptions("testset", resources, kube, sync.Options{Changed: flux.ResourceIDSet{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, summary.Count(cluster.SyncConfigured))
+}
+
+func TestKubectlUserAgent(t *testing.T) {
+	kubectl := NewKubectl("/usr/local/bin/kubectl", &rest.Config{Host: "https://cluster.example.com", UserAgent: "fluxd/1.13.0 prod"})
+	cmd, err := kubectl.kubectlCommand("apply", "-f", "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "/usr/local/bin/kubectl", cmd.Path)
+	assert.Equal(t, []string{"fluxd-1.13.0 prod", "--server=https://cluster.example.com", "apply", "-f", "-"}, cmd.Args)
+
+	// Without a user-agent, kubectl is run as itself
+	kubectl = NewKubectl("/usr/local/bin/kubectl", &rest.Config{Host: "https://cluster.example.com"})
+	if cmd, err = kubectl.kubectlCommand("apply"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "/usr/local/bin/kubectl", cmd.Args[0])
+}
+
+func TestExportSynced(t *testing.T) {
+	const defs = `---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: foobar
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: dep1
+  namespace: foobar
+`
+	kube, _ := setup(t)
+	manifests, err := kresource.ParseMultidoc([]byte(defs), "resources.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources, err := postProcess(manifests, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sync.Sync("testset", resources, kube); err != nil {
+		t.Fatal(err)
+	}
+
+	// Resources of any kind are exported, but not those synced by
+	// another sync set
+	export, err := kube.ExportSynced("testset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := kresource.ParseMultidoc(export, "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for id := range exported {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	assert.Equal(t, []string{"<cluster>:namespace/foobar", "foobar:deployment/dep1"}, ids)
+
+	export, err = kube.ExportSynced("otherset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, export)
+}
+
+func TestSyncedFingerprint(t *testing.T) {
+	const defs = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: dep1
+  namespace: foobar
+`
+	kube, _ := setup(t)
+	manifests, err := kresource.ParseMultidoc([]byte(defs), "resources.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources, err := postProcess(manifests, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sync.Sync("testset", resources, kube); err != nil {
+		t.Fatal(err)
+	}
+
+	synced, err := kube.SyncedFingerprint("testset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := kube.SyncedFingerprint("testset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, synced, again)
+
+	// Deleting a synced resource changes the fingerprint
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	if err := kube.client.dynamicClient.Resource(gvr).Namespace("foobar").Delete("dep1", &metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := kube.SyncedFingerprint("testset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, synced, deleted)
+}
+
+// TestDryRunFilter checks that only the objects passing a dry run
+// are kept, when the objects can't all be dry-run together.
+func TestDryRunFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-test-kubectl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Stands in for kubectl, rejecting any input mentioning
+	// "privileged", and failing as kubectl does for anything in a
+	// namespace or of a kind that doesn't exist yet
+	exe := filepath.Join(dir, "kubectl")
+	script := `#!/bin/sh
+input=$(cat)
+case "$input" in
+*privileged*) echo 'admission webhook denied the request' >&2; exit 1;;
+*newns*) echo 'Error from server (NotFound): error when creating "STDIN": namespaces "newns" not found' >&2; exit 1;;
+*widget*) echo 'error: unable to recognize "STDIN": no matches for kind "Widget" in version "example.com/v1"' >&2; exit 1;;
+esac
+`
+	if err := ioutil.WriteFile(exe, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	kubectl := NewKubectl(exe, &rest.Config{})
+
+	objs := []applyObject{
+		{ResourceID: flux.MakeResourceID("test", "Deployment", "ok"), Payload: []byte("name: ok")},
+		{ResourceID: flux.MakeResourceID("test", "Deployment", "bad"), Source: "bad.yaml", Payload: []byte("name: privileged")},
+	}
+	passed, rejected := kubectl.dryRunFilter(log.NewNopLogger(), objs)
+	if assert.Len(t, passed, 1) {
+		assert.Equal(t, objs[0].ResourceID, passed[0].ResourceID)
+	}
+	if assert.Len(t, rejected, 1) {
+		assert.Equal(t, objs[1].ResourceID, rejected[0].ResourceID)
+		assert.Equal(t, "bad.yaml", rejected[0].Source)
+		assert.Contains(t, rejected[0].Error.Error(), "admission webhook denied")
+	}
+
+	passed, rejected = kubectl.dryRunFilter(log.NewNopLogger(), objs[:1])
+	assert.Len(t, passed, 1)
+	assert.Len(t, rejected, 0)
+
+	// Those that depend on a namespace or CRD in the same sync can't
+	// be checked until that's applied, so are let through
+	objs = []applyObject{
+		{ResourceID: flux.MakeResourceID("newns", "Deployment", "new"), Payload: []byte("name: new\nnamespace: newns")},
+		{ResourceID: flux.MakeResourceID("test", "Widget", "w"), Payload: []byte("kind: widget")},
+	}
+	passed, rejected = kubectl.dryRunFilter(log.NewNopLogger(), objs)
+	assert.Len(t, passed, 2)
+	assert.Len(t, rejected, 0)
+}
+
+// TestApplyDurationObserved checks that resources applied together
+// are timed together, labelled with their kinds, and that resources
+// applied one by one after failing together are timed only then.
+func TestApplyDurationObserved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-test-kubectl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Stands in for kubectl, rejecting any input mentioning "privileged"
+	exe := filepath.Join(dir, "kubectl")
+	script := "#!/bin/sh\nif grep -q privileged; then echo 'admission webhook denied the request' >&2; exit 1; fi\n"
+	if err := ioutil.WriteFile(exe, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	kubectl := NewKubectl(exe, &rest.Config{})
+
+	var observed []string
+	defer func(observe func([]applyObject, time.Duration, error)) { observeApplyDuration = observe }(observeApplyDuration)
+	observeApplyDuration = func(objs []applyObject, _ time.Duration, err error) {
+		observed = append(observed, fmt.Sprintf("%s %t", applyKinds(objs), err == nil))
+	}
+
+	ok := changeSet{objs: map[string][]applyObject{"apply": {
+		{ResourceID: flux.MakeResourceID("test", "Deployment", "ok"), Payload: []byte("metadata: {name: ok}")},
+		{ResourceID: flux.MakeResourceID("test", "ConfigMap", "config"), Payload: []byte("metadata: {name: config}")},
+		{ResourceID: flux.MakeResourceID("test", "Deployment", "other"), Payload: []byte("metadata: {name: other}")},
+	}}}
+	errs := kubectl.apply(log.NewNopLogger(), ok, nil, cluster.SyncSummary{})
+	assert.Len(t, errs, 0)
+	// all applied in one go, so timed once
+	assert.Equal(t, []string{"configmap,deployment true"}, observed)
+
+	observed = nil
+	cs := changeSet{objs: map[string][]applyObject{"apply": {
+		{ResourceID: flux.MakeResourceID("test", "Deployment", "privileged"), Payload: []byte("metadata: {name: privileged}")},
+		{ResourceID: flux.MakeResourceID("test", "ConfigMap", "config"), Payload: []byte("metadata: {name: config}")},
+		{ResourceID: flux.MakeResourceID("test", "Deployment", "ok"), Payload: []byte("metadata: {name: ok}")},
+	}}}
+	errs = kubectl.apply(log.NewNopLogger(), cs, nil, cluster.SyncSummary{})
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, flux.MakeResourceID("test", "Deployment", "privileged"), errs[0].ResourceID)
+	}
+	// they fail together, so are each timed on their own
+	assert.Equal(t, []string{"configmap true", "deployment true", "deployment false"}, observed)
+}
+
+func TestRetryApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-test-kubectl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Stands in for kubectl, reporting a conflict the first two times
+	// it's run
+	exe := filepath.Join(dir, "kubectl")
+	count := filepath.Join(dir, "count")
+	script := fmt.Sprintf(`#!/bin/sh
+echo run >> %s
+if [ $(wc -l < %s) -le 2 ]; then
+  echo 'Error from server (Conflict): Operation cannot be fulfilled on deployments.apps "ok": the object has been modified; please apply your changes to the latest version and try again' >&2
+  exit 1
+fi
+echo 'deployment.apps/ok configured'
+`, count, count)
+	if err := ioutil.WriteFile(exe, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	kubectl := NewKubectl(exe, &rest.Config{})
+	kubectl.applyRetryInterval = time.Millisecond
+
+	obj := applyObject{ResourceID: flux.MakeResourceID("test", "Deployment", "ok"), Payload: []byte("name: ok")}
+	_, err = kubectl.doCommand(log.NewNopLogger(), bytes.NewReader(obj.Payload), "apply")
+	if err == nil || !isRetryableError(err) {
+		t.Fatalf("expected a retryable error, got %v", err)
+	}
+
+	kubectl.ApplyRetries = 1
+	_, err = kubectl.retryApply(log.NewNopLogger(), obj, []string{"apply"}, err)
+	if err == nil {
+		t.Fatal("expected an error after one retry")
+	}
+
+	kubectl.ApplyRetries = 3
+	output, err := kubectl.retryApply(log.NewNopLogger(), obj, []string{"apply"}, err)
+	assert.NoError(t, err)
+	assert.Contains(t, output, "configured")
+
+	// Errors that aren't transient aren't retried
+	_, err = kubectl.retryApply(log.NewNopLogger(), obj, []string{"apply"}, errors.NewBadRequest("invalid"))
+	assert.Error(t, err)
+}
+
+func TestSplitForced(t *testing.T) {
+	kubectl := NewKubectl("kubectl", &rest.Config{})
+	objs := []applyObject{
+		{ResourceID: flux.MakeResourceID("test", "Deployment", "dep"), Payload: []byte("metadata: {name: dep}")},
+		{ResourceID: flux.MakeResourceID("test", "Widget", "w1"), Payload: []byte("metadata: {name: w1}")},
+		{ResourceID: flux.MakeResourceID("test", "StatefulSet", "db"), Payload: []byte("metadata: {name: db}")},
+		{ResourceID: flux.MakeResourceID("test", "StatefulSet", "cache"), Payload: []byte("metadata: {name: cache, annotations: {flux.weave.works/recreate: 'true'}}")},
+	}
+
+	normal, forced := kubectl.splitForced(log.NewNopLogger(), objs)
+	assert.Equal(t, objs, normal)
+	assert.Empty(t, forced)
+
+	kubectl.ForceApplyKinds = []string{"Widget", "statefulset"}
+	normal, forced = kubectl.splitForced(log.NewNopLogger(), objs)
+	// A StatefulSet holds state, so is only forced if annotated
+	assert.Equal(t, []applyObject{objs[0], objs[2]}, normal)
+	assert.Equal(t, []applyObject{objs[1], objs[3]}, forced)
+}
+
+func TestSplitSelf(t *testing.T) {
+	kubectl := NewKubectl("kubectl", &rest.Config{})
+	objs := []applyObject{
+		{ResourceID: flux.MakeResourceID("test", "Deployment", "app")},
+		{ResourceID: flux.MakeResourceID("flux", "Deployment", "flux")},
+		{ResourceID: flux.MakeResourceID("flux", "Service", "flux")},
+	}
+
+	others, self := kubectl.splitSelf(objs)
+	assert.Equal(t, objs, others)
+	assert.Nil(t, self)
+
+	kubectl.SelfWorkload = flux.MustParseResourceID("flux:deployment/flux")
+	others, self = kubectl.splitSelf(objs)
+	assert.Equal(t, []applyObject{objs[0], objs[2]}, others)
+	if assert.NotNil(t, self) {
+		assert.Equal(t, objs[1], *self)
+	}
+	// The objects given are left as they were
+	assert.Equal(t, "flux:deployment/flux", objs[1].ResourceID.String())
+}
+
+func TestGroupByFieldManager(t *testing.T) {
+	kubectl := NewKubectl("kubectl", &rest.Config{})
+	kubectl.FieldManager = "platform"
+	for _, r := range []string{"teams/payments=payments-team", "*/search-*.yaml=search-team"} {
+		rule, err := ParseFieldManagerRule(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kubectl.FieldManagerRules = append(kubectl.FieldManagerRules, rule)
+	}
+	objs := []applyObject{
+		{ResourceID: flux.MakeResourceID("test", "Deployment", "a"), Source: "teams/payments/a.yaml", Payload: []byte("metadata: {name: a}")},
+		{ResourceID: flux.MakeResourceID("test", "Deployment", "b"), Source: "teams/payments/sub/b.yaml", Payload: []byte("metadata: {name: b}")},
+		{ResourceID: flux.MakeResourceID("test", "Deployment", "c"), Source: "apps/search-c.yaml", Payload: []byte("metadata: {name: c}")},
+		{ResourceID: flux.MakeResourceID("test", "Deployment", "d"), Source: "teams/payments/d.yaml", Payload: []byte("metadata: {name: d, annotations: {flux.weave.works/field-manager: owner-d}}")},
+		{ResourceID: flux.MakeResourceID("test", "Deployment", "e"), Source: "other/e.yaml", Payload: []byte("metadata: {name: e}")},
+		{ResourceID: flux.MakeResourceID("test", "Deployment", "f"), Source: "other/f.yaml", Payload: []byte("metadata: {name: f, annotations: {flux.weave.works/field-manager: '-bad'}}")},
+	}
+
+	runs, errs := kubectl.groupByFieldManager(objs)
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, objs[5].ResourceID, errs[0].ResourceID)
+	}
+	var managers []string
+	var counts []int
+	for _, run := range runs {
+		managers = append(managers, run.manager)
+		counts = append(counts, len(run.objs))
+	}
+	assert.Equal(t, []string{"payments-team", "search-team", "owner-d", "platform"}, managers)
+	assert.Equal(t, []int{2, 1, 1, 1}, counts)
+
+	for _, invalid := range []string{"", "no-manager", "path=", "path=has space", "path=" + strings.Repeat("x", 129)} {
+		if _, err := ParseFieldManagerRule(invalid); err == nil {
+			t.Errorf("expected rule %q to be invalid", invalid)
+		}
+	}
+}
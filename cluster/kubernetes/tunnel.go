@@ -0,0 +1,270 @@
+package kubernetes
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	"github.com/pkg/errors"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	DefaultTunnelLocalPort = 1080
+
+	tunnelMinBackoff = time.Second
+	tunnelMaxBackoff = 30 * time.Second
+	// How long the tunnel has to stay up for the backoff to be reset
+	tunnelStableAfter = time.Minute
+	// How long to wait for the tunnel's local port to accept
+	// connections after starting ssh
+	tunnelStartTimeout = 10 * time.Second
+)
+
+var (
+	tunnelUp = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "flux",
+		Subsystem: "cluster",
+		Name:      "tunnel_up",
+		Help:      "Whether the SSH tunnel to the Kubernetes API server is up (1) or not (0).",
+	}, []string{})
+	tunnelRestarts = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "cluster",
+		Name:      "tunnel_restarts_total",
+		Help:      "Count of times the SSH tunnel to the Kubernetes API server was restarted after exiting.",
+	}, []string{})
+)
+
+// SSHTunnelConfig is used to configure an SSHTunnel.
+type SSHTunnelConfig struct {
+	Exe            string // path to the ssh executable
+	Destination    string // the bastion, as [user@]host
+	Port           int    // the bastion's SSH port; if zero, ssh's default is used
+	KeyFile        string // if given, the private key to use
+	KnownHostsFile string // if given, the bastion's host key must be in this file
+	LocalPort      int    // where to listen (on localhost) for proxied connections
+	Logger         log.Logger
+}
+
+// SSHTunnel runs ssh as a SOCKS proxy through a bastion host, so
+// that an API server that can only be reached from the bastion can be
+// used. The ssh process is restarted (with backoff) whenever it
+// exits, e.g., because the connection dropped.
+type SSHTunnel struct {
+	SSHTunnelConfig
+
+	mu      sync.RWMutex
+	up      bool
+	lastErr error
+}
+
+func NewSSHTunnel(config SSHTunnelConfig) *SSHTunnel {
+	if config.LocalPort == 0 {
+		config.LocalPort = DefaultTunnelLocalPort
+	}
+	tunnelUp.Set(0)
+	return &SSHTunnel{
+		SSHTunnelConfig: config,
+		lastErr:         errors.New("not started yet"),
+	}
+}
+
+func (t *SSHTunnel) localAddr() string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(t.LocalPort))
+}
+
+// ProxyURL gives the URL of the SOCKS proxy the tunnel provides.
+func (t *SSHTunnel) ProxyURL() *url.URL {
+	return &url.URL{Scheme: "socks5", Host: t.localAddr()}
+}
+
+// Healthy returns nil if the tunnel is up, or otherwise an error
+// saying why not.
+func (t *SSHTunnel) Healthy() error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.up {
+		return nil
+	}
+	return errors.Wrap(t.lastErr, "SSH tunnel to "+t.Destination+" is down")
+}
+
+// Apply makes the REST client config given use the tunnel for its
+// connections.
+func (t *SSHTunnel) Apply(config *rest.Config) {
+	proxyURL := t.ProxyURL()
+	wrap := config.WrapTransport
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if transport, ok := rt.(*http.Transport); ok {
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+		if wrap != nil {
+			return wrap(rt)
+		}
+		return rt
+	}
+}
+
+// Env gives the environment entries to supply to other programs
+// (i.e., kubectl) so they use the tunnel.
+func (t *SSHTunnel) Env() []string {
+	proxy := t.ProxyURL().String()
+	return []string{
+		"HTTPS_PROXY=" + proxy, "https_proxy=" + proxy,
+		"NO_PROXY=", "no_proxy=",
+	}
+}
+
+func (t *SSHTunnel) args() []string {
+	args := []string{
+		"-N",
+		"-D", t.localAddr(),
+		"-o", "BatchMode=yes",
+		"-o", "ExitOnForwardFailure=yes",
+		// Notice, and exit, when the connection has gone away
+		"-o", "ServerAliveInterval=10",
+		"-o", "ServerAliveCountMax=3",
+	}
+	if t.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(t.Port))
+	}
+	if t.KeyFile != "" {
+		args = append(args, "-i", t.KeyFile)
+	}
+	if t.KnownHostsFile != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+t.KnownHostsFile, "-o", "StrictHostKeyChecking=yes")
+	}
+	return append(args, t.Destination)
+}
+
+func (t *SSHTunnel) setUp(up bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.up = up
+	t.lastErr = err
+	if up {
+		tunnelUp.Set(1)
+	} else {
+		tunnelUp.Set(0)
+	}
+}
+
+// Start runs the tunnel until told to stop.
+func (t *SSHTunnel) Start(stop <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	backoff := tunnelMinBackoff
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			tunnelRestarts.Add(1)
+		}
+		began := time.Now()
+		err := t.run(stop)
+		select {
+		case <-stop:
+			t.setUp(false, errors.New("stopped"))
+			return
+		default:
+		}
+
+		t.setUp(false, err)
+		if time.Since(began) > tunnelStableAfter {
+			backoff = tunnelMinBackoff
+		}
+		t.Logger.Log("err", errors.Wrap(err, "SSH tunnel exited"), "retry", backoff)
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > tunnelMaxBackoff {
+			backoff = tunnelMaxBackoff
+		}
+	}
+}
+
+// run starts ssh and waits for it to exit, or for the stop signal (in
+// which case, it kills ssh).
+func (t *SSHTunnel) run(stop <-chan struct{}) error {
+	cmd := exec.Command(t.Exe, t.args()...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	exitErr := func(err error) error {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.New(msg)
+		}
+		if err == nil {
+			return errors.New("ssh exited")
+		}
+		return err
+	}
+
+	// Wait until the proxy is accepting connections before declaring
+	// the tunnel up.
+	deadline := time.After(tunnelStartTimeout)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+waitForPort:
+	for {
+		select {
+		case <-stop:
+			cmd.Process.Kill()
+			<-exited
+			return nil
+		case err := <-exited:
+			return exitErr(err)
+		case <-deadline:
+			cmd.Process.Kill()
+			<-exited
+			return fmt.Errorf("timed out waiting for SSH tunnel to listen on %s", t.localAddr())
+		case <-ticker.C:
+			if conn, err := net.DialTimeout("tcp", t.localAddr(), time.Second); err == nil {
+				conn.Close()
+				break waitForPort
+			}
+		}
+	}
+
+	t.setUp(true, nil)
+	t.Logger.Log("tunnel", "up", "destination", t.Destination, "proxy", t.localAddr())
+
+	select {
+	case <-stop:
+		cmd.Process.Kill()
+		<-exited
+		return nil
+	case err := <-exited:
+		return exitErr(err)
+	}
+}
+
+// Wait waits for the tunnel to come up, returning an error if it
+// doesn't within the timeout given.
+func (t *SSHTunnel) Wait(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := t.Healthy()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,33 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSHTunnelArgs(t *testing.T) {
+	tunnel := NewSSHTunnel(SSHTunnelConfig{
+		Exe:            "ssh",
+		Destination:    "flux@bastion.example.com",
+		Port:           2222,
+		KeyFile:        "/etc/fluxd/tunnel/identity",
+		KnownHostsFile: "/etc/fluxd/tunnel/known_hosts",
+		Logger:         log.NewNopLogger(),
+	})
+	args := tunnel.args()
+	assert.Equal(t, "flux@bastion.example.com", args[len(args)-1])
+	assert.Contains(t, args, "127.0.0.1:1080")
+	assert.Contains(t, args, "2222")
+	assert.Contains(t, args, "/etc/fluxd/tunnel/identity")
+	assert.Contains(t, args, "UserKnownHostsFile=/etc/fluxd/tunnel/known_hosts")
+	assert.Equal(t, "socks5://127.0.0.1:1080", tunnel.ProxyURL().String())
+}
+
+func TestSSHTunnelHealthy(t *testing.T) {
+	tunnel := NewSSHTunnel(SSHTunnelConfig{Destination: "bastion", Logger: log.NewNopLogger()})
+	assert.Error(t, tunnel.Healthy())
+	tunnel.setUp(true, nil)
+	assert.NoError(t, tunnel.Healthy())
+}
@@ -0,0 +1,104 @@
+package kubernetes
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// DefaultValuesPollInterval is how often a ValuesConfigMap is
+// checked for changes, if not given.
+const DefaultValuesPollInterval = time.Minute
+
+// ValuesConfigMap supplies values for rendering manifests from the
+// data of a ConfigMap in the cluster, which something other than
+// fluxd may update. It checks the ConfigMap at intervals, and says
+// when it has changed, so that the manifests can be rendered and
+// applied again.
+//
+// The ConfigMap not existing is not an error: there are just no
+// values, until it's created.
+type ValuesConfigMap struct {
+	Client    v1.ConfigMapsGetter
+	Namespace string
+	Name      string
+	// How often to check for changes; DefaultValuesPollInterval if
+	// zero
+	Interval time.Duration
+	Logger   log.Logger
+
+	mu      sync.Mutex
+	missing bool // whether it's been reported as missing
+}
+
+// Values gives the data in the ConfigMap, or no values if there is
+// no such ConfigMap.
+func (v *ValuesConfigMap) Values() (map[string]string, error) {
+	data, _, err := v.get()
+	if data == nil {
+		data = map[string]string{}
+	}
+	return data, err
+}
+
+// get gives the data and resource version of the ConfigMap, or
+// nothing if it doesn't exist.
+func (v *ValuesConfigMap) get() (map[string]string, string, error) {
+	cm, err := v.Client.ConfigMaps(v.Namespace).Get(v.Name, meta_v1.GetOptions{})
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if apierrors.IsNotFound(err) {
+		if !v.missing {
+			v.Logger.Log("warning", "values configmap not found; rendering manifests without values", "namespace", v.Namespace, "name", v.Name)
+			v.missing = true
+		}
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "getting values configmap %s/%s", v.Namespace, v.Name)
+	}
+	if v.missing {
+		v.Logger.Log("info", "values configmap found", "namespace", v.Namespace, "name", v.Name)
+		v.missing = false
+	}
+	return cm.Data, cm.ResourceVersion, nil
+}
+
+// Loop checks the ConfigMap at intervals until told to stop, calling
+// changed whenever it has been created, updated or deleted.
+func (v *ValuesConfigMap) Loop(stop <-chan struct{}, wg *sync.WaitGroup, changed func()) {
+	defer wg.Done()
+	interval := v.Interval
+	if interval == 0 {
+		interval = DefaultValuesPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	_, lastVersion, err := v.get()
+	if err != nil {
+		v.Logger.Log("err", err)
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			_, version, err := v.get()
+			if err != nil {
+				v.Logger.Log("err", err)
+				continue
+			}
+			if version != lastVersion {
+				v.Logger.Log("info", "values configmap changed; asking for a sync", "namespace", v.Namespace, "name", v.Name, "version", version)
+				lastVersion = version
+				changed()
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValuesConfigMap(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	values := &ValuesConfigMap{
+		Client:    client.CoreV1(),
+		Namespace: "flux",
+		Name:      "values",
+		Logger:    log.NewNopLogger(),
+	}
+
+	// A missing ConfigMap just means no values
+	data, err := values.Values()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{}, data)
+	_, version, err := values.get()
+	assert.NoError(t, err)
+	assert.Equal(t, "", version)
+
+	cm := &apiv1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: "flux", Name: "values", ResourceVersion: "1"},
+		Data:       map[string]string{"replicas": "3"},
+	}
+	if _, err := client.CoreV1().ConfigMaps("flux").Create(cm); err != nil {
+		t.Fatal(err)
+	}
+	data, err = values.Values()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"replicas": "3"}, data)
+	_, version, err = values.get()
+	assert.NoError(t, err)
+	assert.Equal(t, "1", version)
+}
@@ -0,0 +1,264 @@
+package kubernetes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// References to secret values in Vault look like
+// `vault://<path>#<key>`.
+const vaultScheme = "vault://"
+
+const defaultVaultTimeout = 10 * time.Second
+
+// VaultResolver fills in the values in Secrets that refer to values
+// kept in Vault (https://www.vaultproject.io/), just before they are
+// applied. A value in the `data` or `stringData` of a Secret manifest
+// that is exactly a reference, `vault://<path>#<key>`, is replaced
+// with the value of the key at that path (base64-encoded, for
+// `data`). The values fetched are only ever in the manifests given to
+// kubectl; they are not written to disk, and not logged, nor are they
+// in any error returned.
+//
+// A Secret may only refer to the paths allowed for its namespace; so,
+// e.g., a team that can write the manifests for one namespace can't
+// have fluxd fetch another team's secrets from Vault into it.
+type VaultResolver struct {
+	// The address of the Vault server, e.g., https://vault:8200
+	Addr string
+	// A file to read the token to authenticate with from, each time
+	// it's needed, so it can be renewed (e.g., by a Vault agent);
+	// if empty, Token is used
+	TokenFile string
+	Token     string
+	// The path prefixes that Secrets in each namespace may refer to,
+	// by namespace; those given for the namespace "*" are allowed in
+	// every namespace
+	AllowedPaths map[string][]string
+	Client       *http.Client
+}
+
+// ParseVaultAllowedPath parses a path prefix allowed for the Secrets
+// in a namespace, given as `<namespace>=<path prefix>`.
+func ParseVaultAllowedPath(s string) (namespace, prefix string, err error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.Trim(parts[1], "/") == "" {
+		return "", "", fmt.Errorf("expected <namespace>=<path prefix>, got %q", s)
+	}
+	prefix = strings.Trim(parts[1], "/")
+	if !validVaultPath(prefix) {
+		return "", "", fmt.Errorf("invalid Vault path prefix %q", parts[1])
+	}
+	return parts[0], prefix, nil
+}
+
+// NewVaultClient gives an HTTP client for talking to Vault, which
+// trusts the CA certificates in caFile, and presents the client
+// certificate in certFile with the key in keyFile, if they're given.
+func NewVaultClient(caFile, certFile, keyFile string) (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading Vault CA certificates")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading Vault client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{
+		Timeout: defaultVaultTimeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
+
+// validVaultPath says whether the path given is made of plain
+// segments, with none empty, nor `.` or `..`, which could otherwise
+// be used to get out from under an allowed prefix.
+func validVaultPath(path string) bool {
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// allowed says whether Secrets in the namespace given may refer to the
+// path given.
+func (r *VaultResolver) allowed(namespace, path string) bool {
+	if !validVaultPath(path) {
+		return false
+	}
+	for _, ns := range []string{namespace, "*"} {
+		for _, prefix := range r.AllowedPaths[ns] {
+			if path == prefix || strings.HasPrefix(path, prefix+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseVaultRef parses a reference to a value in Vault; ok is false
+// if the value given isn't a reference.
+func parseVaultRef(value string) (path, key string, ok bool) {
+	if !strings.HasPrefix(value, vaultScheme) {
+		return "", "", false
+	}
+	ref := strings.TrimPrefix(value, vaultScheme)
+	hash := strings.LastIndex(ref, "#")
+	if hash <= 0 || hash == len(ref)-1 {
+		return "", "", false
+	}
+	return strings.Trim(ref[:hash], "/"), ref[hash+1:], true
+}
+
+type secretManifest struct {
+	Data       map[string]string `yaml:"data"`
+	StringData map[string]string `yaml:"stringData"`
+}
+
+// matches reports whether the manifest given, of the kind given, has
+// references to values in Vault.
+func (r *VaultResolver) matches(kind string, manifest []byte) bool {
+	if kind != "secret" {
+		return false
+	}
+	var secret secretManifest
+	if err := yaml.Unmarshal(manifest, &secret); err != nil {
+		return false
+	}
+	for _, values := range []map[string]string{secret.Data, secret.StringData} {
+		for _, value := range values {
+			if _, _, ok := parseVaultRef(value); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolve returns the manifest, of a Secret in the namespace given,
+// with the references to values in Vault replaced with the values.
+// Each path is only fetched once.
+func (r *VaultResolver) resolve(namespace, source string, manifest []byte) ([]byte, error) {
+	definition := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(manifest, &definition); err != nil {
+		return nil, errors.Wrap(err, "parsing manifest to resolve Vault references")
+	}
+	fetched := map[string]map[string]interface{}{}
+	for _, field := range []string{"data", "stringData"} {
+		values, ok := definition[field].(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		for name, v := range values {
+			value, _ := v.(string)
+			path, key, ok := parseVaultRef(value)
+			if !ok {
+				continue
+			}
+			if !r.allowed(namespace, path) {
+				return nil, fmt.Errorf("resolving %s for %v in %s: path %q is not allowed for Secrets in namespace %q", value, name, source, path, namespace)
+			}
+			secret, ok := fetched[path]
+			if !ok {
+				var err error
+				if secret, err = r.read(path); err != nil {
+					return nil, errors.Wrapf(err, "resolving %s for %v in %s", value, name, source)
+				}
+				fetched[path] = secret
+			}
+			resolved, ok := secret[key].(string)
+			if !ok {
+				return nil, fmt.Errorf("resolving %s for %v in %s: no string value for key %q at %q", value, name, source, key, path)
+			}
+			if field == "data" {
+				resolved = base64.StdEncoding.EncodeToString([]byte(resolved))
+			}
+			values[name] = resolved
+		}
+	}
+	bytes, err := yaml.Marshal(definition)
+	if err != nil {
+		return nil, errors.Wrap(err, "serializing manifest after resolving Vault references")
+	}
+	return bytes, nil
+}
+
+// read fetches the secret at the path given. For secrets in a KV
+// version 2 engine, the path has to include `data/` (e.g.,
+// `secret/data/app`), as with the Vault HTTP API; the values are
+// then found inside the secret's data.
+func (r *VaultResolver) read(path string) (map[string]interface{}, error) {
+	token := r.Token
+	if r.TokenFile != "" {
+		bytes, err := ioutil.ReadFile(r.TokenFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading Vault token")
+		}
+		token = strings.TrimSpace(string(bytes))
+	}
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		segments = append(segments, url.PathEscape(segment))
+	}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(r.Addr, "/")+"/v1/"+strings.Join(segments, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	client := r.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultVaultTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching from Vault")
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []string               `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, errors.Wrap(err, "decoding response from Vault")
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(body.Errors) > 0 {
+			return nil, fmt.Errorf("vault responded %s: %s", resp.Status, strings.Join(body.Errors, "; "))
+		}
+		return nil, fmt.Errorf("vault responded %s", resp.Status)
+	}
+	if data, ok := body.Data["data"].(map[string]interface{}); ok {
+		if _, ok := body.Data["metadata"]; ok {
+			return data, nil
+		}
+	}
+	return body.Data, nil
+}
@@ -0,0 +1,115 @@
+package kubernetes
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestVaultResolve(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("X-Vault-Token") != "s3cr3t-token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/app":
+			w.Write([]byte(`{"data":{"data":{"password":"hunter2","user":"app"},"metadata":{"version":3}}}`))
+		case "/v1/kv/app":
+			w.Write([]byte(`{"data":{"token":"abc123"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	defer server.Close()
+
+	const manifest = `apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+  namespace: foo
+data:
+  password: vault://secret/data/app#password
+  plain: c2hoCg==
+stringData:
+  user: vault://secret/data/app#user
+  token: vault://kv/app#token
+`
+	r := &VaultResolver{Addr: server.URL, Token: "s3cr3t-token", AllowedPaths: map[string][]string{
+		"foo": {"secret/data/app"},
+		"*":   {"kv"},
+	}}
+	assert.True(t, r.matches("secret", []byte(manifest)))
+	assert.False(t, r.matches("configmap", []byte(manifest)))
+
+	resolved, err := r.resolve("foo", "app.yaml", []byte(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, requests, "each path should be fetched once")
+	var secret secretManifest
+	if err := yaml.Unmarshal(resolved, &secret); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("hunter2")), secret.Data["password"])
+	assert.Equal(t, "c2hoCg==", secret.Data["plain"])
+	assert.Equal(t, "app", secret.StringData["user"])
+	assert.Equal(t, "abc123", secret.StringData["token"])
+
+	_, err = r.resolve("foo", "app.yaml", []byte(strings.Replace(manifest, "#token", "#missing", 1)))
+	assert.Error(t, err)
+
+	// Another namespace can't refer to paths allowed only for foo,
+	// nor can foo get out from under its paths
+	requests = 0
+	_, err = r.resolve("bar", "app.yaml", []byte(manifest))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "not allowed")
+	}
+	assert.Equal(t, 0, requests, "nothing should be fetched for paths not allowed")
+	_, err = r.resolve("foo", "app.yaml", []byte(strings.Replace(manifest, "secret/data/app#user", "secret/data/app/../other#user", 1)))
+	assert.Error(t, err)
+	_, err = r.resolve("foo", "app.yaml", []byte(strings.Replace(manifest, "secret/data/app#user", "secret/data/application#user", 1)))
+	assert.Error(t, err)
+
+	r.Token = "wrong"
+	_, err = r.resolve("foo", "app.yaml", []byte(manifest))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "permission denied")
+		assert.NotContains(t, err.Error(), "hunter2")
+	}
+}
+
+func TestParseVaultAllowedPath(t *testing.T) {
+	ns, prefix, err := ParseVaultAllowedPath("team-a=/secret/data/team-a/")
+	assert.NoError(t, err)
+	assert.Equal(t, "team-a", ns)
+	assert.Equal(t, "secret/data/team-a", prefix)
+
+	for _, bad := range []string{"team-a", "=secret", "team-a=", "team-a=/", "team-a=secret/../other"} {
+		_, _, err := ParseVaultAllowedPath(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestParseVaultRef(t *testing.T) {
+	for value, ok := range map[string]bool{
+		"vault://secret/data/app#password": true,
+		"vault://secret#":                  false,
+		"vault://#key":                     false,
+		"vault://secret":                   false,
+		"hunter2":                          false,
+	} {
+		_, _, got := parseVaultRef(value)
+		assert.Equal(t, ok, got, value)
+	}
+}
@@ -0,0 +1,127 @@
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ClientVersion gives the version of kubectl (e.g., "v1.11.3").
+func (c *Kubectl) ClientVersion() (string, error) {
+	cmd := exec.Command(c.exe, "version", "--client", "-o", "json")
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrap(errors.New(strings.TrimSpace(stderr.String())), "running kubectl version")
+	}
+	var version struct {
+		ClientVersion struct {
+			GitVersion string `json:"gitVersion"`
+		} `json:"clientVersion"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &version); err != nil {
+		return "", errors.Wrap(err, "parsing output of kubectl version")
+	}
+	return version.ClientVersion.GitVersion, nil
+}
+
+// The first minor versions of kubectl (in major version 1) with the
+// flags the options that need them use.
+const (
+	// --server-dry-run
+	serverDryRunMinor = 12
+	// --dry-run=server, replacing --server-dry-run
+	dryRunServerMinor = 18
+	// --server-side and --field-manager
+	serverSideApplyMinor = 18
+)
+
+// minorVersion gives the minor version of kubectl, if Version is
+// known.
+func (c *Kubectl) minorVersion() (int, bool) {
+	if c.Version == "" {
+		return 0, false
+	}
+	major, minor, err := parseVersion(c.Version)
+	if err != nil || major != 1 {
+		return 0, false
+	}
+	return minor, true
+}
+
+// CheckVersion returns an error if any of the options set needs a
+// newer version of kubectl than Version. If the version isn't known,
+// nothing is ruled out.
+func (c *Kubectl) CheckVersion() error {
+	minor, ok := c.minorVersion()
+	if !ok {
+		return nil
+	}
+	if c.ServerDryRun && minor < serverDryRunMinor {
+		return fmt.Errorf("a server-side dry run needs kubectl v1.%d or later, but kubectl is %s", serverDryRunMinor, c.Version)
+	}
+	if c.ServerSideApply && minor < serverSideApplyMinor {
+		return fmt.Errorf("server-side apply needs kubectl v1.%d or later, but kubectl is %s", serverSideApplyMinor, c.Version)
+	}
+	return nil
+}
+
+// serverDryRunArgs gives the arguments to `kubectl` for a server-side
+// dry run of applying resources, with the flag for its version.
+func (c *Kubectl) serverDryRunArgs() []string {
+	if minor, ok := c.minorVersion(); ok && minor >= dryRunServerMinor {
+		return []string{"apply", "--dry-run=server"}
+	}
+	return []string{"apply", "--server-dry-run"}
+}
+
+// selfCheckArgs gives the arguments to `kubectl` for checking fluxd's
+// own workload before applying it: a server-side dry run, if kubectl
+// is known to have one; otherwise (e.g., with the kubectl in the
+// image), a client-side dry run, which still validates the manifest
+// against the API server's schema.
+func (c *Kubectl) selfCheckArgs() (args []string, serverSide bool) {
+	if minor, ok := c.minorVersion(); ok && minor >= serverDryRunMinor {
+		return c.serverDryRunArgs(), true
+	}
+	return []string{"apply", "--dry-run", "--validate=true"}, false
+}
+
+var versionRE = regexp.MustCompile(`^v?(\d+)\.(\d+)`)
+
+// VersionSkew gives the number of minor versions between two
+// Kubernetes versions (e.g., "v1.11.3" and "v1.13.0-gke.1"),
+// regardless of which is newer.
+func VersionSkew(a, b string) (int, error) {
+	majorA, minorA, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	majorB, minorB, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	if majorA != majorB {
+		return 0, fmt.Errorf("major versions of %s and %s differ", a, b)
+	}
+	if minorA > minorB {
+		return minorA - minorB, nil
+	}
+	return minorB - minorA, nil
+}
+
+func parseVersion(version string) (major, minor int, err error) {
+	matches := versionRE.FindStringSubmatch(version)
+	if matches == nil {
+		return 0, 0, fmt.Errorf("unable to parse version %q", version)
+	}
+	major, _ = strconv.Atoi(matches[1])
+	minor, _ = strconv.Atoi(matches[2])
+	return major, minor, nil
+}
@@ -0,0 +1,64 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckVersion(t *testing.T) {
+	kubectl := &Kubectl{ServerDryRun: true}
+	// If the version isn't known, it's not ruled out
+	assert.NoError(t, kubectl.CheckVersion())
+	assert.Equal(t, []string{"apply", "--server-dry-run"}, kubectl.serverDryRunArgs())
+
+	kubectl.Version = "v1.11.3"
+	assert.Error(t, kubectl.CheckVersion())
+
+	kubectl.Version = "v1.12.0"
+	assert.NoError(t, kubectl.CheckVersion())
+	assert.Equal(t, []string{"apply", "--server-dry-run"}, kubectl.serverDryRunArgs())
+
+	kubectl.Version = "v1.18.2"
+	assert.NoError(t, kubectl.CheckVersion())
+	assert.Equal(t, []string{"apply", "--dry-run=server"}, kubectl.serverDryRunArgs())
+
+	// There's a check of fluxd's own workload whatever the version
+	args, serverSide := (&Kubectl{Version: "v1.11.3"}).selfCheckArgs()
+	assert.Equal(t, []string{"apply", "--dry-run", "--validate=true"}, args)
+	assert.False(t, serverSide)
+	args, serverSide = (&Kubectl{Version: "v1.14.0"}).selfCheckArgs()
+	assert.Equal(t, []string{"apply", "--server-dry-run"}, args)
+	assert.True(t, serverSide)
+
+	kubectl = &Kubectl{ServerSideApply: true, Version: "v1.17.4"}
+	assert.Error(t, kubectl.CheckVersion())
+	kubectl.Version = "v1.18.0"
+	assert.NoError(t, kubectl.CheckVersion())
+}
+
+func TestVersionSkew(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		skew int
+	}{
+		{"v1.11.3", "v1.11.0", 0},
+		{"v1.11.3", "v1.13.0-gke.1", 2},
+		{"v1.14.1", "1.12", 2},
+	} {
+		skew, err := VersionSkew(c.a, c.b)
+		if err != nil {
+			t.Errorf("%s vs %s: %s", c.a, c.b, err)
+			continue
+		}
+		if skew != c.skew {
+			t.Errorf("%s vs %s: expected skew %d, got %d", c.a, c.b, c.skew, skew)
+		}
+	}
+
+	for _, c := range [][2]string{{"v1.11.3", "v2.0.0"}, {"v1.11.3", "unknown"}} {
+		if _, err := VersionSkew(c[0], c[1]); err == nil {
+			t.Errorf("%s vs %s: expected error", c[0], c[1])
+		}
+	}
+}
@@ -0,0 +1,469 @@
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	"github.com/pkg/errors"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster"
+	kresource "github.com/weaveworks/flux/cluster/kubernetes/resource"
+)
+
+// Resources with this annotation are applied in waves, in ascending
+// order of the (integer) value; resources without it are in wave 0.
+// Each wave must be ready before the next is applied.
+const syncWaveAnnotation = kresource.PolicyPrefix + "sync-wave"
+
+// Resources with this annotation are waited on for the (duration)
+// value to be ready, rather than for the timeout for their kind.
+const syncWaveTimeoutAnnotation = kresource.PolicyPrefix + "sync-wave-timeout"
+
+const (
+	defaultWaveTimeout      = 5 * time.Minute
+	defaultWavePollInterval = 2 * time.Second
+)
+
+// What to do when a resource in a wave isn't ready within its
+// readiness timeout.
+const (
+	// Don't apply the waves after it, and report each of their
+	// resources as failing to sync
+	WaveTimeoutFail = "fail"
+	// Report the resource as failing to sync, and carry on applying
+	// the waves after it
+	WaveTimeoutProceed = "proceed"
+)
+
+var (
+	readinessTimeouts = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "cluster",
+		Name:      "readiness_timeouts_total",
+		Help:      "Count of resources in a wave that weren't ready within their readiness timeout.",
+	}, []string{"kind"})
+)
+
+type wave struct {
+	number int
+	objs   []applyObject
+	// readiness timeouts given by annotation, by resource
+	timeouts map[flux.ResourceID]time.Duration
+	// whether the wave is part of a bootstrap sync, and if it's one
+	// of the waves put first in one, the kind of its resources; see
+	// bootstrapWaves
+	bootstrap     bool
+	bootstrapKind string
+}
+
+// waveAnnotations gives the wave the object has been annotated with,
+// or 0 if it has no annotation; and the readiness timeout it has
+// been annotated with, or 0 if it has none.
+func waveAnnotations(obj applyObject) (int, time.Duration, error) {
+	var manifest struct {
+		Metadata struct {
+			Annotations map[string]string `yaml:"annotations"`
+		} `yaml:"metadata"`
+	}
+	if err := yaml.Unmarshal(obj.Payload, &manifest); err != nil {
+		return 0, 0, err
+	}
+	var n int
+	if value, ok := manifest.Metadata.Annotations[syncWaveAnnotation]; ok {
+		var err error
+		if n, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+			return 0, 0, fmt.Errorf("value of annotation %s must be an integer, got %q", syncWaveAnnotation, value)
+		}
+	}
+	var timeout time.Duration
+	if value, ok := manifest.Metadata.Annotations[syncWaveTimeoutAnnotation]; ok {
+		var err error
+		if timeout, err = time.ParseDuration(strings.TrimSpace(value)); err != nil || timeout <= 0 {
+			return 0, 0, fmt.Errorf("value of annotation %s must be a positive duration (e.g., 10m), got %q", syncWaveTimeoutAnnotation, value)
+		}
+	}
+	return n, timeout, nil
+}
+
+// groupWaves splits the objects given into waves, in the order in
+// which they should be applied. Within a wave, objects keep the order
+// they were given in. Objects with an invalid wave or readiness
+// timeout annotation aren't included in any wave, and are returned as
+// errors.
+func groupWaves(objs []applyObject) ([]wave, cluster.SyncError) {
+	var errs cluster.SyncError
+	byNumber := map[int]*wave{}
+	var numbers []int
+	for _, obj := range objs {
+		n, timeout, err := waveAnnotations(obj)
+		if err != nil {
+			errs = append(errs, cluster.ResourceError{ResourceID: obj.ResourceID, Source: obj.Source, Error: err})
+			continue
+		}
+		w, ok := byNumber[n]
+		if !ok {
+			w = &wave{number: n, timeouts: map[flux.ResourceID]time.Duration{}}
+			byNumber[n] = w
+			numbers = append(numbers, n)
+		}
+		w.objs = append(w.objs, obj)
+		if timeout > 0 {
+			w.timeouts[obj.ResourceID] = timeout
+		}
+	}
+	sort.Ints(numbers)
+	waves := make([]wave, len(numbers))
+	for i, n := range numbers {
+		waves[i] = *byNumber[n]
+	}
+	return waves, errs
+}
+
+// applyWaves applies each wave in turn with the function given,
+// waiting for the resources in a wave to be ready before applying
+// the next. The resources that fail to be applied (as reported in
+// the errors accumulated by apply) aren't waited for. Within a wave,
+// custom resources are applied once their CRDs are established (see
+// applyCRDsFirst). If a resource in a wave isn't ready within its
+// readiness timeout (see readinessTimeout), then with
+// WaveTimeoutProceed it's reported as an error and the next wave is
+// applied anyway; otherwise, the waves after it aren't applied, and
+// an error is returned for each resource in them.
+func (c *Kubectl) applyWaves(logger log.Logger, waves []wave, apply func([]applyObject) cluster.SyncError) cluster.SyncError {
+	var errs cluster.SyncError
+	for i, w := range waves {
+		waveErrs := c.applyCRDsFirst(logger, w, apply)
+		errs = append(errs, waveErrs...)
+		if i == len(waves)-1 {
+			break
+		}
+
+		failed := map[flux.ResourceID]bool{}
+		for _, e := range waveErrs {
+			failed[e.ResourceID] = true
+		}
+		var waitFor []applyObject
+		for _, obj := range w.objs {
+			if !failed[obj.ResourceID] {
+				waitFor = append(waitFor, obj)
+			}
+		}
+		begin := time.Now()
+		timedOut, err := c.waitForReady(w, waitFor)
+		logger.Log("wave", w, "count", len(waitFor), "timed_out", len(timedOut), "took", time.Since(begin), "err", err)
+		for _, t := range timedOut {
+			_, kind, _ := t.obj.ResourceID.Components()
+			readinessTimeouts.With("kind", kind).Add(1)
+			logger.Log("warning", "resource not ready within its readiness timeout", "wave", w, "resource", t.obj.ResourceID, "timeout", t.timeout, "reason", t.reason)
+		}
+		if err == nil && len(timedOut) > 0 {
+			if c.WaveTimeoutAction == WaveTimeoutProceed {
+				for _, t := range timedOut {
+					errs = append(errs, cluster.ResourceError{
+						ResourceID: t.obj.ResourceID,
+						Source:     t.obj.Source,
+						Error:      fmt.Errorf("timed out after %s waiting for %s; applied the waves after it anyway", t.timeout, t.reason),
+					})
+				}
+				continue
+			}
+			reasons := make([]string, len(timedOut))
+			for j, t := range timedOut {
+				reasons[j] = fmt.Sprintf("%s (after %s)", t.reason, t.timeout)
+			}
+			err = fmt.Errorf("timed out waiting for %s", strings.Join(reasons, "; "))
+		}
+		if err != nil {
+			for _, later := range waves[i+1:] {
+				for _, obj := range later.objs {
+					errs = append(errs, cluster.ResourceError{
+						ResourceID: obj.ResourceID,
+						Source:     obj.Source,
+						Error:      errors.Wrapf(err, "not applied, since %s is not ready", w),
+					})
+				}
+			}
+			break
+		}
+	}
+	return errs
+}
+
+// readinessTimeout gives how long to wait for an object in the wave
+// given to be ready: the duration it's annotated with, if any;
+// otherwise, the timeout for its kind in WaveTimeoutKinds, if there
+// is one; otherwise WaveTimeout, or defaultWaveTimeout if that's
+// zero. In a bootstrap sync, it's at least the bootstrap timeout.
+func (c *Kubectl) readinessTimeout(w wave, obj applyObject) time.Duration {
+	if timeout, ok := w.timeouts[obj.ResourceID]; ok {
+		return c.bootstrapTimeout(w, timeout)
+	}
+	_, kind, _ := obj.ResourceID.Components()
+	for k, timeout := range c.WaveTimeoutKinds {
+		if strings.ToLower(k) == kind {
+			return c.bootstrapTimeout(w, timeout)
+		}
+	}
+	if c.WaveTimeout != 0 {
+		return c.bootstrapTimeout(w, c.WaveTimeout)
+	}
+	return c.bootstrapTimeout(w, defaultWaveTimeout)
+}
+
+// readinessTimedOut records an object that wasn't ready within its
+// readiness timeout.
+type readinessTimedOut struct {
+	obj     applyObject
+	timeout time.Duration
+	reason  string
+}
+
+// waitForReady polls the cluster until each of the objects given is
+// either ready (see isReady), or has been waited on for its readiness
+// timeout, and returns those that timed out. If readiness can't be
+// checked by the time the longest of the timeouts has elapsed, it
+// returns an error.
+func (c *Kubectl) waitForReady(w wave, objs []applyObject) ([]readinessTimedOut, error) {
+	if len(objs) == 0 {
+		return nil, nil
+	}
+	interval := c.wavePollInterval
+	if interval == 0 {
+		interval = defaultWavePollInterval
+	}
+	begin := time.Now()
+	timeouts := make([]time.Duration, len(objs))
+	var longest time.Duration
+	for i, obj := range objs {
+		timeouts[i] = c.readinessTimeout(w, obj)
+		if timeouts[i] > longest {
+			longest = timeouts[i]
+		}
+	}
+
+	var timedOut []readinessTimedOut
+	for {
+		reasons, err := c.notReady(objs)
+		if err == nil {
+			var waiting []applyObject
+			var waitingTimeouts []time.Duration
+			for i, obj := range objs {
+				switch {
+				case reasons[i] == "":
+				case time.Since(begin) > timeouts[i]:
+					timedOut = append(timedOut, readinessTimedOut{obj: obj, timeout: timeouts[i], reason: reasons[i]})
+				default:
+					waiting = append(waiting, obj)
+					waitingTimeouts = append(waitingTimeouts, timeouts[i])
+				}
+			}
+			if len(waiting) == 0 {
+				return timedOut, nil
+			}
+			objs, timeouts = waiting, waitingTimeouts
+		} else if time.Since(begin) > longest {
+			return timedOut, errors.Wrap(err, "checking readiness")
+		}
+		c.sleep(interval)
+	}
+}
+
+// notReady gives, for each of the objects given in the same order, a
+// description of why it isn't ready yet, or the empty string if it
+// is.
+func (c *Kubectl) notReady(objs []applyObject) ([]string, error) {
+	cmd, err := c.kubectlCommand("get", "-o", "json", "-f", "-")
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdin = makeMultidoc(objs)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.New(strings.TrimSpace(stderr.String()))
+	}
+
+	// A single object is printed as itself; several, as a List
+	var list struct {
+		Items []json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &list); err != nil {
+		return nil, err
+	}
+	items := list.Items
+	if items == nil {
+		items = []json.RawMessage{stdout.Bytes()}
+	}
+
+	if len(items) != len(objs) {
+		return nil, fmt.Errorf("expected %d objects from kubectl get, got %d", len(objs), len(items))
+	}
+
+	notReady := make([]string, len(items))
+	for i, item := range items {
+		ready, reason, err := isReady(item, c.ReadinessChecks)
+		if err != nil {
+			return nil, err
+		}
+		if !ready {
+			notReady[i] = reason
+		}
+	}
+	return notReady, nil
+}
+
+type readinessFields struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name       string `json:"name"`
+		Namespace  string `json:"namespace"`
+		Generation int64  `json:"generation"`
+	} `json:"metadata"`
+	Spec struct {
+		Replicas *int32 `json:"replicas"`
+	} `json:"spec"`
+	Status struct {
+		ObservedGeneration     int64  `json:"observedGeneration"`
+		Replicas               int32  `json:"replicas"`
+		UpdatedReplicas        int32  `json:"updatedReplicas"`
+		ReadyReplicas          int32  `json:"readyReplicas"`
+		AvailableReplicas      int32  `json:"availableReplicas"`
+		CurrentRevision        string `json:"currentRevision"`
+		UpdateRevision         string `json:"updateRevision"`
+		DesiredNumberScheduled int32  `json:"desiredNumberScheduled"`
+		UpdatedNumberScheduled int32  `json:"updatedNumberScheduled"`
+		NumberAvailable        int32  `json:"numberAvailable"`
+		Phase                  string `json:"phase"`
+		Conditions             []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+func (r readinessFields) condition(conditionType string) bool {
+	for _, c := range r.Status.Conditions {
+		if c.Type == conditionType {
+			return c.Status == "True"
+		}
+	}
+	return false
+}
+
+// isReady decides whether an object, as reported by the API server,
+// is ready for things that depend on it to be applied; if not, it
+// gives a reason. What ready means depends on the kind:
+//
+//   - Deployments, StatefulSets and DaemonSets are ready when they
+//     have finished rolling out, and all replicas are available;
+//   - Jobs are ready when they have completed;
+//   - Pods are ready when they report as ready, or have succeeded;
+//   - PersistentVolumeClaims are ready when bound;
+//   - CustomResourceDefinitions are ready when established;
+//   - Namespaces are ready when active;
+//   - anything else is ready as soon as it exists.
+//
+// If there's a readiness check for the kind, in those given or in
+// DefaultReadinessChecks, it's used instead; and if the object
+// reports a status.observedGeneration, it must be that of the latest
+// spec, since until then the status is out of date.
+func isReady(object []byte, checks map[string]ReadinessCheck) (bool, string, error) {
+	var r readinessFields
+	if err := json.Unmarshal(object, &r); err != nil {
+		return false, "", err
+	}
+	name := strings.ToLower(r.Kind) + "/" + r.Metadata.Name
+	if r.Metadata.Namespace != "" {
+		name = r.Metadata.Namespace + ":" + name
+	}
+	notReady := func(why string, args ...interface{}) (bool, string, error) {
+		return false, name + " " + fmt.Sprintf(why, args...), nil
+	}
+
+	if check, ok := readinessCheckFor(checks, r.Kind, r.APIVersion); ok {
+		var obj interface{}
+		if err := json.Unmarshal(object, &obj); err != nil {
+			return false, "", err
+		}
+		if observed := r.Status.ObservedGeneration; observed > 0 && observed < r.Metadata.Generation {
+			return notReady("has a status for generation %d, not the latest, %d", observed, r.Metadata.Generation)
+		}
+		if ready, reason := check.ready(obj); !ready {
+			return notReady("%s", reason)
+		}
+		return true, "", nil
+	}
+
+	replicas := int32(1)
+	if r.Spec.Replicas != nil {
+		replicas = *r.Spec.Replicas
+	}
+	switch strings.ToLower(r.Kind) {
+	case "deployment", "statefulset", "daemonset":
+		if r.Status.ObservedGeneration < r.Metadata.Generation {
+			return notReady("has not been processed by its controller")
+		}
+	}
+
+	switch strings.ToLower(r.Kind) {
+	case "deployment":
+		if r.Status.UpdatedReplicas < replicas || r.Status.Replicas > r.Status.UpdatedReplicas {
+			return notReady("is rolling out (%d of %d replicas updated)", r.Status.UpdatedReplicas, replicas)
+		}
+		if r.Status.AvailableReplicas < replicas {
+			return notReady("has %d of %d replicas available", r.Status.AvailableReplicas, replicas)
+		}
+	case "statefulset":
+		if r.Status.UpdateRevision != "" && r.Status.CurrentRevision != r.Status.UpdateRevision {
+			return notReady("is rolling out")
+		}
+		if r.Status.ReadyReplicas < replicas {
+			return notReady("has %d of %d replicas ready", r.Status.ReadyReplicas, replicas)
+		}
+	case "daemonset":
+		desired := r.Status.DesiredNumberScheduled
+		if r.Status.UpdatedNumberScheduled < desired {
+			return notReady("is rolling out (%d of %d pods updated)", r.Status.UpdatedNumberScheduled, desired)
+		}
+		if r.Status.NumberAvailable < desired {
+			return notReady("has %d of %d pods available", r.Status.NumberAvailable, desired)
+		}
+	case "job":
+		if r.condition("Failed") {
+			// This won't change without intervention, but isn't
+			// treated differently, so it's reported on timeout
+			return notReady("has failed")
+		}
+		if !r.condition("Complete") {
+			return notReady("has not completed")
+		}
+	case "pod":
+		if r.Status.Phase != "Succeeded" && !r.condition("Ready") {
+			return notReady("is not ready (phase %s)", r.Status.Phase)
+		}
+	case "persistentvolumeclaim":
+		if r.Status.Phase != "Bound" {
+			return notReady("is not bound")
+		}
+	case "customresourcedefinition":
+		if !r.condition("Established") {
+			return notReady("is not established")
+		}
+	case "namespace":
+		if r.Status.Phase != "" && r.Status.Phase != "Active" {
+			return notReady("is %s", strings.ToLower(r.Status.Phase))
+		}
+	}
+	return true, "", nil
+}
@@ -0,0 +1,212 @@
+package kubernetes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/rest"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster"
+)
+
+func waveObject(name, wave string) applyObject {
+	payload := "metadata:\n  name: " + name + "\n"
+	if wave != "" {
+		payload += "  annotations:\n    flux.weave.works/sync-wave: \"" + wave + "\"\n"
+	}
+	return applyObject{
+		ResourceID: flux.MakeResourceID("test", "Deployment", name),
+		Payload:    []byte(payload),
+	}
+}
+
+func TestGroupWaves(t *testing.T) {
+	waves, errs := groupWaves([]applyObject{
+		waveObject("a", "1"),
+		waveObject("b", ""),
+		waveObject("c", "-1"),
+		waveObject("d", "1"),
+		waveObject("e", "first"),
+	})
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, "test:deployment/e", errs[0].ResourceID.String())
+	}
+	var got [][]string
+	for _, w := range waves {
+		var names []string
+		for _, obj := range w.objs {
+			_, _, name := obj.ResourceID.Components()
+			names = append(names, name)
+		}
+		got = append(got, names)
+	}
+	assert.Equal(t, [][]string{{"c"}, {"b"}, {"a", "d"}}, got)
+}
+
+func TestIsReady(t *testing.T) {
+	for object, expected := range map[string]bool{
+		`{"kind": "Deployment", "metadata": {"name": "d", "generation": 2}, "spec": {"replicas": 2}, "status": {"observedGeneration": 2, "replicas": 2, "updatedReplicas": 2, "availableReplicas": 2}}`: true,
+		`{"kind": "Deployment", "metadata": {"name": "d", "generation": 2}, "spec": {"replicas": 2}, "status": {"observedGeneration": 1, "replicas": 2, "updatedReplicas": 2, "availableReplicas": 2}}`: false,
+		`{"kind": "Deployment", "metadata": {"name": "d", "generation": 2}, "spec": {"replicas": 2}, "status": {"observedGeneration": 2, "replicas": 3, "updatedReplicas": 2, "availableReplicas": 2}}`: false,
+		`{"kind": "StatefulSet", "metadata": {"name": "s"}, "status": {"readyReplicas": 1, "currentRevision": "a", "updateRevision": "b"}}`:                                                             false,
+		`{"kind": "DaemonSet", "metadata": {"name": "ds"}, "status": {"desiredNumberScheduled": 3, "updatedNumberScheduled": 3, "numberAvailable": 3}}`:                                                 true,
+		`{"kind": "Job", "metadata": {"name": "j"}, "status": {"conditions": [{"type": "Complete", "status": "True"}]}}`:                                                                                true,
+		`{"kind": "Job", "metadata": {"name": "j"}, "status": {}}`:                                                                                                                                          false,
+		`{"kind": "PersistentVolumeClaim", "metadata": {"name": "p"}, "status": {"phase": "Pending"}}`:                                                                                                      false,
+		`{"kind": "ConfigMap", "metadata": {"name": "c"}}`:                                                                                                                                                  true,
+		`{"apiVersion": "cert-manager.io/v1", "kind": "Certificate", "metadata": {"name": "c"}, "status": {"conditions": [{"type": "Ready", "status": "False"}]}}`:                                          false,
+		`{"apiVersion": "cert-manager.io/v1", "kind": "Certificate", "metadata": {"name": "c"}, "status": {"conditions": [{"type": "Ready", "status": "True"}]}}`:                                           true,
+		`{"apiVersion": "cert-manager.io/v1", "kind": "Certificate", "metadata": {"name": "c", "generation": 3}, "status": {"observedGeneration": 2, "conditions": [{"type": "Ready", "status": "True"}]}}`: false,
+		`{"apiVersion": "cert-manager.io/v1", "kind": "Certificate", "metadata": {"name": "c", "generation": 3}, "status": {"observedGeneration": 3, "conditions": [{"type": "Ready", "status": "True"}]}}`: true,
+	} {
+		ready, reason, err := isReady([]byte(object), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ready != expected {
+			t.Errorf("expected ready=%v for %s (reason: %q)", expected, object, reason)
+		}
+		if !ready && reason == "" {
+			t.Errorf("expected a reason for not being ready: %s", object)
+		}
+	}
+}
+
+// TestApplyWavesTimeout checks that waves after one that doesn't
+// become ready aren't applied.
+func TestApplyWavesTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-test-kubectl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Stands in for `kubectl get`, reporting a job that never completes
+	exe := filepath.Join(dir, "kubectl")
+	script := "#!/bin/sh\ncat >/dev/null\necho '{\"kind\": \"Job\", \"metadata\": {\"name\": \"migrate\"}, \"status\": {}}'\n"
+	if err := ioutil.WriteFile(exe, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	kubectl := NewKubectl(exe, &rest.Config{})
+	kubectl.WaveTimeout = 10 * time.Millisecond
+	kubectl.wavePollInterval = time.Millisecond
+
+	waves, _ := groupWaves([]applyObject{waveObject("migrate", "-1"), waveObject("app", "")})
+	var applied []string
+	errs := kubectl.applyWaves(log.NewNopLogger(), waves, func(objs []applyObject) cluster.SyncError {
+		for _, obj := range objs {
+			_, _, name := obj.ResourceID.Components()
+			applied = append(applied, name)
+		}
+		return nil
+	})
+	assert.Equal(t, []string{"migrate"}, applied)
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, "test:deployment/app", errs[0].ResourceID.String())
+		assert.True(t, strings.Contains(errs[0].Error.Error(), "migrate has not completed"), errs[0].Error.Error())
+	}
+}
+
+// TestApplyWavesTimeoutProceed checks that, with WaveTimeoutProceed,
+// the waves after one that doesn't become ready are applied, and the
+// resource that timed out is reported.
+func TestApplyWavesTimeoutProceed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-test-kubectl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	exe := filepath.Join(dir, "kubectl")
+	script := "#!/bin/sh\ncat >/dev/null\necho '{\"kind\": \"Job\", \"metadata\": {\"name\": \"migrate\"}, \"status\": {}}'\n"
+	if err := ioutil.WriteFile(exe, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	kubectl := NewKubectl(exe, &rest.Config{})
+	kubectl.WaveTimeout = time.Hour
+	kubectl.WaveTimeoutKinds = map[string]time.Duration{"deployment": 10 * time.Millisecond}
+	kubectl.WaveTimeoutAction = WaveTimeoutProceed
+	kubectl.wavePollInterval = time.Millisecond
+
+	waves, _ := groupWaves([]applyObject{waveObject("migrate", "-1"), waveObject("app", "")})
+	var applied []string
+	errs := kubectl.applyWaves(log.NewNopLogger(), waves, func(objs []applyObject) cluster.SyncError {
+		for _, obj := range objs {
+			_, _, name := obj.ResourceID.Components()
+			applied = append(applied, name)
+		}
+		return nil
+	})
+	assert.Equal(t, []string{"migrate", "app"}, applied)
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, "test:deployment/migrate", errs[0].ResourceID.String())
+		assert.True(t, strings.Contains(errs[0].Error.Error(), "migrate has not completed"), errs[0].Error.Error())
+	}
+}
+
+func TestReadinessTimeout(t *testing.T) {
+	kubectl := NewKubectl("kubectl", &rest.Config{})
+	kubectl.WaveTimeoutKinds = map[string]time.Duration{"deployment": time.Minute}
+
+	annotated := waveObject("annotated", "")
+	annotated.Payload = []byte("metadata:\n  name: annotated\n  annotations:\n    flux.weave.works/sync-wave-timeout: 30s\n")
+	invalid := waveObject("invalid", "")
+	invalid.Payload = []byte("metadata:\n  name: invalid\n  annotations:\n    flux.weave.works/sync-wave-timeout: soon\n")
+	service := waveObject("svc", "")
+	service.ResourceID = flux.MakeResourceID("test", "Service", "svc")
+
+	waves, errs := groupWaves([]applyObject{annotated, invalid, waveObject("plain", ""), service})
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, "test:deployment/invalid", errs[0].ResourceID.String())
+	}
+	if !assert.Len(t, waves, 1) || !assert.Len(t, waves[0].objs, 3) {
+		return
+	}
+	w := waves[0]
+	assert.Equal(t, 30*time.Second, kubectl.readinessTimeout(w, w.objs[0]))
+	assert.Equal(t, time.Minute, kubectl.readinessTimeout(w, w.objs[1]))
+	assert.Equal(t, defaultWaveTimeout, kubectl.readinessTimeout(w, w.objs[2]))
+}
+
+func TestBootstrapWaves(t *testing.T) {
+	obj := func(kind, name string) applyObject {
+		return applyObject{ResourceID: flux.MakeResourceID("test", kind, name)}
+	}
+	waves := []wave{
+		{number: -1, objs: []applyObject{obj("Deployment", "early"), obj("Namespace", "ns")}},
+		{number: 0, objs: []applyObject{obj("CustomResourceDefinition", "crd"), obj("Deployment", "app"), obj("Role", "role")}},
+		{number: 1, objs: []applyObject{obj("Namespace", "late")}},
+	}
+	got := bootstrapWaves(waves, []string{"Namespace", "CustomResourceDefinition", "ClusterRole", "Role"})
+
+	var names []string
+	var groups [][]string
+	for _, w := range got {
+		assert.True(t, w.bootstrap)
+		names = append(names, w.String())
+		var group []string
+		for _, o := range w.objs {
+			_, _, name := o.ResourceID.Components()
+			group = append(group, name)
+		}
+		groups = append(groups, group)
+	}
+	// Waves left empty, and kinds with no resources, are dropped
+	assert.Equal(t, []string{
+		"bootstrap wave (namespace)",
+		"bootstrap wave (customresourcedefinition)",
+		"bootstrap wave (role)",
+		"wave -1",
+		"wave 0",
+	}, names)
+	assert.Equal(t, [][]string{{"ns", "late"}, {"crd"}, {"role"}, {"early"}, {"app"}}, groups)
+
+	k := &Kubectl{WaveTimeout: time.Minute, BootstrapTimeout: time.Hour}
+	assert.Equal(t, time.Hour, k.readinessTimeout(got[0], got[0].objs[0]))
+	assert.Equal(t, time.Minute, k.readinessTimeout(wave{}, got[0].objs[0]))
+}
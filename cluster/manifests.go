@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/image"
@@ -20,6 +21,34 @@ func ErrResourceNotFound(name string) error {
 	return ManifestError{fmt.Errorf("manifest for resource %s not found under manifests path", name)}
 }
 
+// SkippedFilesError is returned by LoadManifests, along with the
+// manifests from the other files, when files were skipped without
+// being parsed because they're larger than the limit on the size of
+// files loaded. Use LoadManifestsSkipping to get it separately from
+// other errors. A sync can go ahead with the manifests that were
+// loaded, so long as it doesn't garbage collect anything, since the
+// resources in the files skipped would look as though they'd been
+// removed.
+type SkippedFilesError struct {
+	Files []SkippedFile
+	// The limit on the size of files, in bytes
+	Limit int64
+}
+
+// SkippedFile is a file skipped when loading manifests.
+type SkippedFile struct {
+	Source string
+	Size   int64
+}
+
+func (err *SkippedFilesError) Error() string {
+	var files []string
+	for _, f := range err.Files {
+		files = append(files, fmt.Sprintf("%s (%d bytes)", f.Source, f.Size))
+	}
+	return fmt.Sprintf("skipped %d files larger than the limit of %d bytes: %s", len(err.Files), err.Limit, strings.Join(files, ", "))
+}
+
 // Manifests represents how a set of files are used as definitions of
 // resources, e.g., in Kubernetes, YAML files describing Kubernetes
 // resources.
@@ -35,11 +64,25 @@ type Manifests interface {
 	UpdatePolicies([]byte, flux.ResourceID, policy.Update) ([]byte, error)
 }
 
+// LoadManifestsSkipping loads manifests with m, as LoadManifests
+// does, but gives any files skipped for being too large separately,
+// rather than as an error, so the manifests loaded can still be
+// used.
+func LoadManifestsSkipping(m Manifests, baseDir string, paths []string) (map[string]resource.Resource, *SkippedFilesError, error) {
+	resources, err := m.LoadManifests(baseDir, paths)
+	if skipped, ok := err.(*SkippedFilesError); ok {
+		return resources, skipped, nil
+	}
+	return resources, nil, err
+}
+
 // UpdateManifest looks for the manifest for the identified resource,
 // reads its contents, applies f(contents), and writes the results
 // back to the file.
 func UpdateManifest(m Manifests, root string, paths []string, id flux.ResourceID, f func(manifest []byte) ([]byte, error)) error {
-	resources, err := m.LoadManifests(root, paths)
+	// A file that's skipped for being too large can't be the one to
+	// update, so it doesn't matter here
+	resources, _, err := LoadManifestsSkipping(m, root, paths)
 	if err != nil {
 		return err
 	}
@@ -20,6 +20,9 @@ type Mock struct {
 	UpdateImageFunc       func(def []byte, id flux.ResourceID, container string, newImageID image.Ref) ([]byte, error)
 	LoadManifestsFunc     func(base string, paths []string) (map[string]resource.Resource, error)
 	UpdatePoliciesFunc    func([]byte, flux.ResourceID, policy.Update) ([]byte, error)
+	SyncedCountFunc       func(syncSetName string) (int, error)
+	SyncedFingerprintFunc func(syncSetName string) (string, error)
+	PendingDeletionsFunc  func(syncSetName string) []PendingDeletion
 }
 
 func (m *Mock) AllWorkloads(maybeNamespace string) ([]Workload, error) {
@@ -42,8 +45,34 @@ func (m *Mock) Export() ([]byte, error) {
 	return m.ExportFunc()
 }
 
-func (m *Mock) Sync(c SyncSet) error {
-	return m.SyncFunc(c)
+func (m *Mock) Sync(c SyncSet) (SyncSummary, error) {
+	return SyncSummary{}, m.SyncFunc(c)
+}
+
+// SyncedCount gives nothing synced, unless SyncedCountFunc is given.
+func (m *Mock) SyncedCount(syncSetName string) (int, error) {
+	if m.SyncedCountFunc == nil {
+		return 0, nil
+	}
+	return m.SyncedCountFunc(syncSetName)
+}
+
+// SyncedFingerprint gives the same fingerprint each time, unless
+// SyncedFingerprintFunc is given.
+func (m *Mock) SyncedFingerprint(syncSetName string) (string, error) {
+	if m.SyncedFingerprintFunc == nil {
+		return "", nil
+	}
+	return m.SyncedFingerprintFunc(syncSetName)
+}
+
+// PendingDeletions gives nothing pending deletion, unless
+// PendingDeletionsFunc is given.
+func (m *Mock) PendingDeletions(syncSetName string) []PendingDeletion {
+	if m.PendingDeletionsFunc == nil {
+		return nil
+	}
+	return m.PendingDeletionsFunc(syncSetName)
 }
 
 func (m *Mock) PublicSSHKey(regenerate bool) (ssh.PublicKey, error) {
@@ -1,6 +1,8 @@
 package cluster
 
 import (
+	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/weaveworks/flux"
@@ -16,9 +18,33 @@ import (
 // distinguish the resources from a set from other resources -- e.g.,
 // cluster resources not marked as belonging to a set will not be
 // deleted by garbage collection.
+//
+// If Changed is not nil, the sync is incremental: only the resources
+// in Changed need be applied (though others may be, e.g., if they
+// are missing from the cluster). The rest of the resources must
+// still be included, so they are not garbage collected.
 type SyncSet struct {
 	Name      string
 	Resources []resource.Resource
+	Changed   flux.ResourceIDSet
+	// The revision the resources come from, if known; for reporting
+	Revision string
+	// If not empty, only resources in this namespace (and the
+	// namespace itself) are applied, and nothing is garbage
+	// collected.
+	Namespace string
+	// If set, nothing is garbage collected, e.g., because not all
+	// the manifests could be loaded
+	NoGC bool
+	// If set, the sync is bootstrapping a fresh cluster, so is done
+	// more carefully: e.g., by applying the resources that others
+	// depend on first, and waiting longer for them to be ready
+	Bootstrap bool
+	// If set, only some of the resources are given (e.g., those due
+	// to be synced again sooner than the rest), so nothing is garbage
+	// collected, and the sync errors for other resources are left as
+	// they were.
+	Partial bool
 }
 
 type ResourceError struct {
@@ -36,3 +62,96 @@ func (err SyncError) Error() string {
 	}
 	return strings.Join(errs, "; ")
 }
+
+// Validator checks resources before they are synced, e.g., against
+// policies for what may run in the cluster. If any resources fail,
+// it returns a SyncError giving the reason for each.
+type Validator interface {
+	Validate(map[string]resource.Resource) error
+}
+
+// What can happen to a resource in a sync.
+const (
+	SyncCreated    = "created"
+	SyncConfigured = "configured" // applied, and changed as a result
+	SyncUnchanged  = "unchanged"  // applied, but already as it should be
+	SyncDeleted    = "deleted"
+	SyncSkipped    = "skipped" // not applied, e.g., because it's ignored
+	SyncFailed     = "failed"
+)
+
+// SyncOutcomes lists the outcomes, in the order they are reported.
+var SyncOutcomes = []string{SyncCreated, SyncConfigured, SyncUnchanged, SyncDeleted, SyncSkipped, SyncFailed}
+
+// SyncSummary counts the resources in a sync, by outcome (one of the
+// Sync* constants), then by kind (as in a resource ID).
+type SyncSummary map[string]map[string]int
+
+// Add counts a resource of the kind given as having the outcome
+// given.
+func (s SyncSummary) Add(outcome, kind string) {
+	byKind, ok := s[outcome]
+	if !ok {
+		byKind = map[string]int{}
+		s[outcome] = byKind
+	}
+	byKind[kind]++
+}
+
+// Count gives the number of resources with the outcome given.
+func (s SyncSummary) Count(outcome string) int {
+	var total int
+	for _, n := range s[outcome] {
+		total += n
+	}
+	return total
+}
+
+// String gives the count for each outcome, e.g., "created 1,
+// configured 3, unchanged 42, deleted 0, skipped 0, failed 0".
+func (s SyncSummary) String() string {
+	counts := make([]string, len(SyncOutcomes))
+	for i, outcome := range SyncOutcomes {
+		counts[i] = fmt.Sprintf("%s %d", outcome, s.Count(outcome))
+	}
+	return strings.Join(counts, ", ")
+}
+
+// KindsString gives the counts for an outcome broken down by kind,
+// e.g., "deployment 2, service 1".
+func (s SyncSummary) KindsString(outcome string) string {
+	var kinds []string
+	for kind := range s[outcome] {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	counts := make([]string, len(kinds))
+	for i, kind := range kinds {
+		counts[i] = fmt.Sprintf("%s %d", kind, s[outcome][kind])
+	}
+	return strings.Join(counts, ", ")
+}
+
+// Drift describes how a resource running in the cluster differs from
+// its manifest.
+type Drift struct {
+	ResourceID flux.ResourceID
+	Source     string
+	// The resource isn't in the cluster at all
+	Missing bool
+	// The resource isn't in the sync set, and would be deleted by
+	// garbage collection; in which case there's no Source
+	Deleted bool
+	// The manifest compared with the resource in the cluster, as a
+	// unified diff; this may be truncated
+	Diff string
+	// The diff has been truncated
+	Truncated bool
+}
+
+// DriftDetector is implemented by clusters that can compare the
+// resources in a SyncSet with what's running, without applying
+// anything.
+type DriftDetector interface {
+	Drift(SyncSet) ([]Drift, error)
+}
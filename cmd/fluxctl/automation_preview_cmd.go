@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux/api/v12"
+)
+
+type automationPreviewOpts struct {
+	*rootOpts
+	namespace    string
+	outputFormat string
+}
+
+func newAutomationPreview(parent *rootOpts) *automationPreviewOpts {
+	return &automationPreviewOpts{rootOpts: parent}
+}
+
+func (opts *automationPreviewOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "preview-automation",
+		Short: "Show what automation would do were it to run now: which automated workloads would be updated, to which images, and why the others wouldn't be; nothing is committed.",
+		Example: makeExample(
+			"fluxctl preview-automation",
+			"fluxctl preview-automation --namespace=prod --output-format=json",
+		),
+		RunE: opts.RunE,
+	}
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "", "Confine the preview to workloads in this namespace; if not given, preview all namespaces")
+	cmd.Flags().StringVarP(&opts.outputFormat, "output-format", "o", "", "Output format; \"json\" or \"yaml\" print the preview as data")
+	return cmd
+}
+
+func (opts *automationPreviewOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errorWantedNoArgs
+	}
+	if err := checkOutputFormat(opts.outputFormat, ""); err != nil {
+		return err
+	}
+
+	preview, err := opts.API.AutomationPreview(context.Background(), v12.AutomationPreviewOptions{Namespace: opts.namespace})
+	if err != nil {
+		return err
+	}
+	if isStructuredOutput(opts.outputFormat) {
+		if preview.Workloads == nil {
+			preview.Workloads = []v12.WorkloadAutomationPreview{}
+		}
+		return printStructured(cmd.OutOrStdout(), opts.outputFormat, preview)
+	}
+
+	out := cmd.OutOrStdout()
+	if len(preview.Workloads) == 0 {
+		fmt.Fprintln(out, "No automated workloads.")
+		return nil
+	}
+	w := tabwriter.NewWriter(out, 0, 2, 2, ' ', 0)
+	updated := printAutomationPreview(w, preview)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\n%d of %d automated workloads would be updated. This is a preview; nothing has been committed.\n", updated, len(preview.Workloads))
+	return nil
+}
+
+// printAutomationPreview prints a line for each container of each
+// workload previewed (or one for the workload, if it has none), and
+// returns the number of workloads that would be updated.
+func printAutomationPreview(out io.Writer, preview v12.AutomationPreview) int {
+	var updated int
+	fmt.Fprintf(out, "WORKLOAD\tCONTAINER\tCURRENT\tTARGET\tOUTCOME\n")
+	for _, wp := range preview.Workloads {
+		if len(wp.Containers) == 0 {
+			fmt.Fprintf(out, "%s\t\t\t\theld back: %s\n", wp.ID, wp.HeldBack)
+			continue
+		}
+		var updating bool
+		for i, c := range wp.Containers {
+			workload := ""
+			if i == 0 {
+				workload = wp.ID.String()
+			}
+			var target, outcome string
+			switch {
+			case c.Update && wp.HeldBack != "":
+				target = c.Target.String()
+				outcome = "held back: " + wp.HeldBack
+			case c.Update:
+				target = c.Target.String()
+				outcome = "update: " + c.Reason
+				updating = true
+			default:
+				outcome = "no update: " + c.Reason
+			}
+			fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\n", workload, c.Name, c.Current, target, outcome)
+		}
+		if updating {
+			updated++
+		}
+	}
+	return updated
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/api/v10"
+	"github.com/weaveworks/flux/api/v11"
+	"github.com/weaveworks/flux/api/v6"
+	"github.com/weaveworks/flux/policy"
+	"github.com/weaveworks/flux/update"
+)
+
+type checkAutomationOpts struct {
+	*rootOpts
+	namespace string
+	workload  string
+}
+
+func newCheckAutomation(parent *rootOpts) *checkAutomationOpts {
+	return &checkAutomationOpts{rootOpts: parent}
+}
+
+func (opts *checkAutomationOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check-automation",
+		Short: "Explain whether, and why not, a workload's images are being automatically updated.",
+		Example: makeExample(
+			"fluxctl check-automation --workload=default:deployment/foo",
+			"fluxctl check-automation default:deployment/foo",
+		),
+		RunE: opts.RunE,
+	}
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "Workload namespace")
+	cmd.Flags().StringVarP(&opts.workload, "workload", "w", "", "Workload to check")
+	return cmd
+}
+
+func (opts *checkAutomationOpts) RunE(cmd *cobra.Command, args []string) error {
+	switch {
+	case len(args) > 1:
+		return newUsageError("expected at most one workload argument")
+	case len(args) == 1 && opts.workload != "":
+		return newUsageError("can't specify the workload both as an argument and with --workload")
+	case len(args) == 1:
+		opts.workload = args[0]
+	case opts.workload == "":
+		return newUsageError("-w, --workload is required")
+	}
+
+	id, err := flux.ParseResourceIDOptionalNamespace(opts.namespace, opts.workload)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	workloads, err := opts.API.ListServicesWithOptions(ctx, v11.ListServicesOptions{Services: []flux.ResourceID{id}})
+	if err != nil {
+		return err
+	}
+	var workload *v6.ControllerStatus
+	for i := range workloads {
+		if workloads[i].ID == id {
+			workload = &workloads[i]
+		}
+	}
+
+	var images *v6.ImageStatus
+	if workload != nil {
+		statuses, err := opts.API.ListImagesWithOptions(ctx, v10.ListImagesOptions{Spec: update.MakeResourceSpec(id)})
+		if err != nil {
+			return err
+		}
+		for i := range statuses {
+			if statuses[i].ID == id {
+				images = &statuses[i]
+			}
+		}
+	}
+
+	out := newTabwriter()
+	fmt.Fprintln(out, "CHECK\tRESULT\tREASON")
+	for _, c := range automationChecks(id, workload, images) {
+		result := "PASS"
+		if !c.pass {
+			result = "FAIL"
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\n", c.name, result, c.reason)
+	}
+	out.Flush()
+	return nil
+}
+
+type automationCheck struct {
+	name   string
+	pass   bool
+	reason string
+}
+
+// automationChecks runs through the conditions an automation run
+// considers for a workload, in the order they are considered. Once a
+// check fails that would rule the workload out altogether, no further
+// checks are reported.
+func automationChecks(id flux.ResourceID, workload *v6.ControllerStatus, images *v6.ImageStatus) []automationCheck {
+	var checks []automationCheck
+	fail := func(name, reason string) []automationCheck {
+		return append(checks, automationCheck{name, false, reason})
+	}
+	pass := func(name, reason string) {
+		checks = append(checks, automationCheck{name, true, reason})
+	}
+
+	if workload == nil {
+		return fail("workload", fmt.Sprintf("%s was not found in the cluster", id))
+	}
+	pass("workload", fmt.Sprintf("%s is running in the cluster", id))
+
+	switch workload.ReadOnly {
+	case v6.ReadOnlyOK:
+		pass("manifest", "workload is defined in the git repo")
+	case v6.ReadOnlyMissing:
+		return fail("manifest", "workload is not defined in the git repo")
+	case v6.ReadOnlySystem:
+		return fail("manifest", "workload is a system workload")
+	case v6.ReadOnlyNoRepo:
+		return fail("manifest", "no git repo is configured")
+	case v6.ReadOnlyNotReady:
+		return fail("manifest", "the git repo is not ready")
+	default:
+		return fail("manifest", fmt.Sprintf("workload is read-only (%s)", workload.ReadOnly))
+	}
+
+	if !workload.Automated {
+		return fail("automated", fmt.Sprintf("workload does not have the %q policy", policy.Automated))
+	}
+	pass("automated", fmt.Sprintf("workload has the %q policy", policy.Automated))
+
+	if workload.Locked {
+		return fail("locked", "workload is locked")
+	}
+	pass("locked", "workload is not locked")
+
+	if workload.Ignore {
+		return fail("ignored", "workload is ignored")
+	}
+	pass("ignored", "workload is not ignored")
+
+	if images == nil || len(images.Containers) == 0 {
+		return fail("containers", "no containers found for workload")
+	}
+
+	policies := policy.Set{}
+	for k, v := range workload.Policies {
+		policies = policies.Set(policy.Policy(k), v)
+	}
+	for _, c := range images.Containers {
+		prefix := "container " + c.Name + ": "
+		if !policy.ContainerAutomated(policies, c.Name) {
+			checks = append(checks, automationCheck{prefix + "automated", false, fmt.Sprintf("container is excluded by the %q or %q policy", policy.AutomationContainers, policy.AutomationExcludeContainers)})
+			continue
+		}
+		pattern := policy.GetTagPattern(policies, c.Name)
+		pass(prefix+"tag filter", fmt.Sprintf("using pattern %s", pattern))
+
+		if c.AvailableError != "" {
+			checks = append(checks, automationCheck{prefix + "images", false, c.AvailableError})
+			continue
+		}
+		if c.AvailableImagesCount == 0 {
+			checks = append(checks, automationCheck{prefix + "images", false, "no images found in the registry"})
+			continue
+		}
+		var lastFetched time.Time
+		for _, img := range c.Available {
+			if img.LastFetched.After(lastFetched) {
+				lastFetched = img.LastFetched
+			}
+		}
+		if lastFetched.IsZero() {
+			pass(prefix+"images", fmt.Sprintf("%d image(s) available", c.AvailableImagesCount))
+		} else {
+			pass(prefix+"images", fmt.Sprintf("%d image(s) available, last fetched %s", c.AvailableImagesCount, lastFetched.Format(time.RFC822)))
+		}
+
+		if c.FilteredImagesCount == 0 {
+			checks = append(checks, automationCheck{prefix + "tags matched", false, fmt.Sprintf("no tags match %s", pattern)})
+			continue
+		}
+		pass(prefix+"tags matched", fmt.Sprintf("%d tag(s) match %s", c.FilteredImagesCount, pattern))
+
+		if c.NewFilteredImagesCount == 0 {
+			checks = append(checks, automationCheck{prefix + "newer image", false, fmt.Sprintf("already running the latest matching image %s", c.Current.ID)})
+			continue
+		}
+		pass(prefix+"newer image", fmt.Sprintf("%s is newer than %s", c.LatestFiltered.ID, c.Current.ID))
+	}
+	return checks
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/api/v6"
+	"github.com/weaveworks/flux/image"
+)
+
+func TestAutomationChecks(t *testing.T) {
+	id := flux.MustParseResourceID("default:deployment/foo")
+	current, _ := image.ParseRef("quay.io/weaveworks/foo:1.0")
+	newer, _ := image.ParseRef("quay.io/weaveworks/foo:1.1")
+
+	lastResult := func(checks []automationCheck) automationCheck {
+		return checks[len(checks)-1]
+	}
+
+	checks := automationChecks(id, nil, nil)
+	if len(checks) != 1 || checks[0].pass {
+		t.Errorf("expected a single failed check for a missing workload, got %+v", checks)
+	}
+
+	workload := &v6.ControllerStatus{ID: id, ReadOnly: v6.ReadOnlyOK}
+	if c := lastResult(automationChecks(id, workload, nil)); c.pass || c.name != "automated" {
+		t.Errorf("expected automated check to fail, got %+v", c)
+	}
+
+	workload.Automated = true
+	workload.Locked = true
+	if c := lastResult(automationChecks(id, workload, nil)); c.pass || c.name != "locked" {
+		t.Errorf("expected locked check to fail, got %+v", c)
+	}
+
+	workload.Locked = false
+	images := &v6.ImageStatus{
+		ID: id,
+		Containers: []v6.Container{{
+			Name:                 "foo",
+			Current:              image.Info{ID: current},
+			AvailableImagesCount: 2,
+			FilteredImagesCount:  0,
+		}},
+	}
+	workload.Policies = map[string]string{"tag.foo": "semver:~2"}
+	if c := lastResult(automationChecks(id, workload, images)); c.pass || c.name != "container foo: tags matched" {
+		t.Errorf("expected tags matched check to fail, got %+v", c)
+	}
+
+	images.Containers[0].FilteredImagesCount = 2
+	images.Containers[0].NewFilteredImagesCount = 1
+	images.Containers[0].LatestFiltered = image.Info{ID: newer}
+	for _, c := range automationChecks(id, workload, images) {
+		if !c.pass {
+			t.Errorf("expected all checks to pass, got %+v", c)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/api"
+	transport "github.com/weaveworks/flux/http"
+	"github.com/weaveworks/flux/http/client"
+)
+
+// compareTimeout is how long to wait for each fluxd to give its
+// resources
+const compareTimeout = 2 * time.Minute
+
+type compareOpts struct {
+	*rootOpts
+	clusterB     string
+	outputFormat string
+}
+
+func newCompare(parent *rootOpts) *compareOpts {
+	return &compareOpts{rootOpts: parent}
+}
+
+func (opts *compareOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "compare",
+		Short: "Compare the resources synced to two clusters, as given by their fluxd, and report where they differ.",
+		Example: makeExample(
+			"fluxctl compare --cluster-b=http://fluxd.standby.example.com:3030/api/flux",
+			"fluxctl --url=http://fluxd.active.example.com:3030/api/flux compare --cluster-b=http://fluxd.standby.example.com:3030/api/flux",
+		),
+		RunE: opts.RunE,
+	}
+	cmd.Flags().StringVar(&opts.clusterB, "cluster-b", "", "Base URL of the Flux API for the second cluster; the first is the fluxd connected to as usual (e.g., with --url or a port forward)")
+	cmd.Flags().StringVarP(&opts.outputFormat, "output-format", "o", "", "Output format; \"json\" or \"yaml\" print the differences as data")
+	return cmd
+}
+
+func (opts *compareOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errorWantedNoArgs
+	}
+	if opts.clusterB == "" {
+		return newUsageError("--cluster-b is required")
+	}
+	if err := checkOutputFormat(opts.outputFormat, ""); err != nil {
+		return err
+	}
+
+	apiA, err := opts.clientFor(opts.URL)
+	if err != nil {
+		return err
+	}
+	apiB, err := opts.clientFor(opts.clusterB)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	exportA, err := apiA.SyncedResources(ctx)
+	if err != nil {
+		return fmt.Errorf("getting synced resources from cluster A: %v", err)
+	}
+	exportB, err := apiB.SyncedResources(ctx)
+	if err != nil {
+		return fmt.Errorf("getting synced resources from cluster B: %v", err)
+	}
+	comparison, err := compareExports(exportA, exportB)
+	if err != nil {
+		return err
+	}
+	if isStructuredOutput(opts.outputFormat) {
+		return printStructured(cmd.OutOrStdout(), opts.outputFormat, comparison)
+	}
+
+	out := cmd.OutOrStdout()
+	if len(comparison.Differences) == 0 {
+		fmt.Fprintf(out, "The clusters have the same %d resources.\n", comparison.Compared)
+		return nil
+	}
+	fmt.Fprintf(out, "Compared %d resources; %d differ:\n", comparison.Compared, len(comparison.Differences))
+	for _, diff := range comparison.Differences {
+		switch diff.OnlyIn {
+		case "":
+			fmt.Fprintf(out, "\n%s differs:\n", diff.ID)
+			for _, field := range diff.Fields {
+				fmt.Fprintf(out, "  %s: %s (A), %s (B)\n", field.Path, orNone(field.A), orNone(field.B))
+			}
+		default:
+			fmt.Fprintf(out, "\n%s is only in cluster %s\n", diff.ID, diff.OnlyIn)
+		}
+	}
+	return errors.New("the clusters differ")
+}
+
+// clientFor gives an API client for the fluxd at the URL given,
+// authenticating with the same token as the usual connection. Unlike
+// the usual connection, it gives up on a fluxd that doesn't answer
+// within compareTimeout, rather than waiting indefinitely.
+func (opts *compareOpts) clientFor(base string) (api.Server, error) {
+	if _, err := url.Parse(base); err != nil {
+		return nil, fmt.Errorf("parsing URL %q: %v", base, err)
+	}
+	httpClient := &http.Client{Timeout: compareTimeout}
+	return client.New(httpClient, transport.NewAPIRouter(), base, client.Token(opts.Token)), nil
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
+
+// clusterComparison is the outcome of comparing two clusters'
+// exports: how many resources were looked at, and those that differ.
+type clusterComparison struct {
+	Compared    int                 `json:"compared"`
+	Differences []clusterDifference `json:"differences"`
+}
+
+// clusterDifference is a resource that is only in one cluster ("A"
+// or "B"), or that's in both but with different fields.
+type clusterDifference struct {
+	ID     string            `json:"id"`
+	OnlyIn string            `json:"onlyIn,omitempty"`
+	Fields []fieldDifference `json:"fields,omitempty"`
+}
+
+// fieldDifference is a field, given by its path (e.g.,
+// `spec.template.spec.containers[0].image`), with the value it has in
+// each cluster, or the empty string if it isn't there.
+type fieldDifference struct {
+	Path string `json:"path"`
+	A    string `json:"a,omitempty"`
+	B    string `json:"b,omitempty"`
+}
+
+// Fields that differ from one cluster to another however the
+// resources were created, so aren't compared
+var (
+	volatileMetadata = []string{
+		"uid", "resourceVersion", "generation", "creationTimestamp", "selfLink", "managedFields", "ownerReferences",
+	}
+	volatileAnnotations = []string{
+		"deployment.kubernetes.io/revision",
+		"kubectl.kubernetes.io/last-applied-configuration",
+	}
+	volatileLabels = []string{
+		// this depends on the sync set, so differs between daemons
+		// with different git config
+		"flux.weave.works/sync-gc-mark",
+	}
+)
+
+// compareExports compares two exports of resources, as given by
+// the SyncedResources API.
+func compareExports(a, b []byte) (clusterComparison, error) {
+	var comparison clusterComparison
+	resourcesA, err := flattenExport(a)
+	if err != nil {
+		return comparison, fmt.Errorf("parsing export from cluster A: %v", err)
+	}
+	resourcesB, err := flattenExport(b)
+	if err != nil {
+		return comparison, fmt.Errorf("parsing export from cluster B: %v", err)
+	}
+
+	ids := map[string]struct{}{}
+	for id := range resourcesA {
+		ids[id] = struct{}{}
+	}
+	for id := range resourcesB {
+		ids[id] = struct{}{}
+	}
+	var sorted []string
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+	comparison.Compared = len(sorted)
+
+	for _, id := range sorted {
+		fieldsA, inA := resourcesA[id]
+		fieldsB, inB := resourcesB[id]
+		switch {
+		case !inB:
+			comparison.Differences = append(comparison.Differences, clusterDifference{ID: id, OnlyIn: "A"})
+		case !inA:
+			comparison.Differences = append(comparison.Differences, clusterDifference{ID: id, OnlyIn: "B"})
+		default:
+			if fields := compareFields(fieldsA, fieldsB); len(fields) > 0 {
+				comparison.Differences = append(comparison.Differences, clusterDifference{ID: id, Fields: fields})
+			}
+		}
+	}
+	return comparison, nil
+}
+
+func compareFields(a, b map[string]string) []fieldDifference {
+	paths := map[string]struct{}{}
+	for path := range a {
+		paths[path] = struct{}{}
+	}
+	for path := range b {
+		paths[path] = struct{}{}
+	}
+	var diffs []fieldDifference
+	for path := range paths {
+		if a[path] != b[path] {
+			diffs = append(diffs, fieldDifference{Path: path, A: a[path], B: b[path]})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+	return diffs
+}
+
+// flattenExport parses the resources in an export, and gives the
+// fields of each (less those that are expected to vary) by path, by
+// resource ID.
+func flattenExport(export []byte) (map[string]map[string]string, error) {
+	resources := map[string]map[string]string{}
+	yamls := bufio.NewScanner(bytes.NewReader(export))
+	yamls.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	yamls.Split(splitYAMLDocument)
+	for yamls.Scan() {
+		var object map[interface{}]interface{}
+		if err := yaml.Unmarshal(yamls.Bytes(), &object); err != nil {
+			return nil, err
+		}
+		if len(object) == 0 {
+			continue
+		}
+		kind, _ := object["kind"].(string)
+		metadata, _ := object["metadata"].(map[interface{}]interface{})
+		name, _ := metadata["name"].(string)
+		namespace, _ := metadata["namespace"].(string)
+		if kind == "" || name == "" {
+			continue
+		}
+		if namespace == "" {
+			namespace = "<cluster>"
+		}
+
+		delete(object, "status")
+		for _, field := range volatileMetadata {
+			delete(metadata, field)
+		}
+		if annotations, ok := metadata["annotations"].(map[interface{}]interface{}); ok {
+			for _, a := range volatileAnnotations {
+				delete(annotations, a)
+			}
+		}
+		if labels, ok := metadata["labels"].(map[interface{}]interface{}); ok {
+			for _, l := range volatileLabels {
+				delete(labels, l)
+			}
+		}
+		if spec, ok := object["spec"].(map[interface{}]interface{}); ok {
+			deleteNested(spec, "template", "metadata", "creationTimestamp")
+		}
+
+		fields := map[string]string{}
+		flatten("", object, fields)
+		resources[flux.MakeResourceID(namespace, kind, name).String()] = fields
+	}
+	return resources, yamls.Err()
+}
+
+// flatten records the leaves of the value given by their path,
+// formatted so that a string is distinguishable from (e.g.) a number.
+func flatten(path string, value interface{}, into map[string]string) {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		for k, v := range value {
+			key := fmt.Sprint(k)
+			if strings.ContainsAny(key, ".[]") {
+				key = fmt.Sprintf("[%q]", key)
+			} else if path != "" {
+				key = "." + key
+			}
+			flatten(path+key, v, into)
+		}
+	case []interface{}:
+		for i, v := range value {
+			flatten(fmt.Sprintf("%s[%d]", path, i), v, into)
+		}
+	case string:
+		into[path] = fmt.Sprintf("%q", value)
+	case nil:
+		return
+	default:
+		into[path] = fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const exportA = `---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: default
+  uid: 1234
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: helloworld
+  namespace: default
+  resourceVersion: "100"
+  labels:
+    flux.weave.works/sync-gc-mark: sha256.aaa
+spec:
+  replicas: 2
+  template:
+    metadata:
+      creationTimestamp: null
+    spec:
+      containers:
+      - name: hello
+        image: quay.io/weaveworks/helloworld:1.0
+status:
+  readyReplicas: 2
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: only-in-a
+  namespace: default
+`
+
+const exportB = `---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: default
+  uid: 5678
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: helloworld
+  namespace: default
+  resourceVersion: "200"
+  labels:
+    flux.weave.works/sync-gc-mark: sha256.bbb
+spec:
+  replicas: "2"
+  template:
+    spec:
+      containers:
+      - name: hello
+        image: quay.io/weaveworks/helloworld:1.1
+status:
+  readyReplicas: 1
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: only-in-b
+  namespace: default
+`
+
+func TestCompareExports(t *testing.T) {
+	comparison, err := compareExports([]byte(exportA), []byte(exportB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := clusterComparison{
+		Compared: 4,
+		Differences: []clusterDifference{
+			{ID: "default:daemonset/only-in-b", OnlyIn: "B"},
+			{ID: "default:deployment/helloworld", Fields: []fieldDifference{
+				{Path: "spec.replicas", A: "2", B: `"2"`},
+				{Path: "spec.template.spec.containers[0].image", A: `"quay.io/weaveworks/helloworld:1.0"`, B: `"quay.io/weaveworks/helloworld:1.1"`},
+			}},
+			{ID: "default:deployment/only-in-a", OnlyIn: "A"},
+		},
+	}
+	if !reflect.DeepEqual(comparison, expected) {
+		t.Errorf("expected\n%+v\ngot\n%+v", expected, comparison)
+	}
+
+	same, err := compareExports([]byte(exportA), []byte(exportA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same.Compared != 3 || len(same.Differences) != 0 {
+		t.Errorf("expected no differences comparing an export with itself, got %+v", same)
+	}
+}
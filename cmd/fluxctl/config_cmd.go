@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux/api/v12"
+)
+
+// Formats for fluxctl config, besides JSON and YAML
+const (
+	// each flag and its value, as YAML (the default)
+	configFormatFlags = "flags"
+	// the flags given as a YAML list of command-line arguments, e.g.,
+	// for the args of the fluxd container
+	configFormatArgs = "args"
+)
+
+type configOpts struct {
+	*rootOpts
+	explicit     bool
+	outputFormat string
+}
+
+func newConfig(parent *rootOpts) *configOpts {
+	return &configOpts{rootOpts: parent}
+}
+
+func (opts *configOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Show the configuration the daemon is running with, i.e., each of its flags, with secrets redacted.",
+		Example: makeExample(
+			"fluxctl config",
+			"fluxctl config --explicit --output-format=args",
+		),
+		RunE: opts.RunE,
+	}
+	cmd.Flags().BoolVar(&opts.explicit, "explicit", false, "Only show the flags that were given, rather than left at their defaults")
+	cmd.Flags().StringVarP(&opts.outputFormat, "output-format", "o", configFormatFlags, "Output format; \"flags\" shows each flag and its value as YAML, \"args\" shows a YAML list of arguments for fluxd, and \"json\" or \"yaml\" print the configuration as data")
+	return cmd
+}
+
+func (opts *configOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errorWantedNoArgs
+	}
+	if err := checkOutputFormat(opts.outputFormat, configFormatFlags, configFormatArgs); err != nil {
+		return err
+	}
+
+	config, err := opts.API.DaemonConfig(context.Background())
+	if err != nil {
+		return err
+	}
+	if opts.explicit {
+		var flags []v12.ConfigFlag
+		for _, f := range config.Flags {
+			if f.Explicit {
+				flags = append(flags, f)
+			}
+		}
+		config.Flags = flags
+	}
+	if isStructuredOutput(opts.outputFormat) {
+		return printStructured(cmd.OutOrStdout(), opts.outputFormat, config)
+	}
+	if opts.outputFormat == configFormatArgs {
+		return printConfigArgs(cmd.OutOrStdout(), config)
+	}
+	return printConfigFlags(cmd.OutOrStdout(), config)
+}
+
+// printConfigFlags writes each flag and its value as YAML, with
+// comments marking those left at their defaults, and those
+// redacted. The values are all strings, as they'd be given on the
+// command line.
+func printConfigFlags(out io.Writer, config v12.DaemonConfig) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# fluxd %s\n", config.Version)
+	for _, f := range config.Flags {
+		var value interface{}
+		if f.Repeated {
+			value = f.Values
+			if f.Values == nil {
+				value = []string{}
+			}
+		} else if len(f.Values) > 0 {
+			value = f.Values[0]
+		} else {
+			value = ""
+		}
+		doc, err := yaml.Marshal(map[string]interface{}{f.Name: value})
+		if err != nil {
+			return err
+		}
+		var notes []string
+		if !f.Explicit {
+			notes = append(notes, "default")
+		}
+		if f.Redacted {
+			notes = append(notes, "redacted")
+		}
+		lines := strings.SplitN(string(doc), "\n", 2)
+		buf.WriteString(lines[0])
+		if len(notes) > 0 {
+			fmt.Fprintf(&buf, " # %s", strings.Join(notes, ", "))
+		}
+		buf.WriteString("\n")
+		if len(lines) > 1 {
+			buf.WriteString(lines[1])
+		}
+	}
+	return writeValidYAML(out, buf.Bytes())
+}
+
+// printConfigArgs writes the flags that were given explicitly as a
+// YAML list of command-line arguments; the others needn't be given,
+// to have their defaults. Flags with redacted values are left out,
+// with a comment, since the secret has to be given again.
+func printConfigArgs(out io.Writer, config v12.DaemonConfig) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# fluxd %s\n", config.Version)
+	for _, f := range config.Flags {
+		switch {
+		case !f.Explicit:
+			continue
+		case f.Redacted:
+			fmt.Fprintf(&buf, "# --%s is redacted; give it again\n", f.Name)
+			continue
+		case f.Repeated && len(f.Values) == 0:
+			// There's no way to give an empty list; leaving the flag
+			// out gives the default, which may not be empty
+			fmt.Fprintf(&buf, "# --%s was given with no values\n", f.Name)
+			continue
+		}
+		for _, v := range f.Values {
+			arg, err := yaml.Marshal([]string{fmt.Sprintf("--%s=%s", f.Name, v)})
+			if err != nil {
+				return err
+			}
+			buf.Write(arg)
+		}
+	}
+	return writeValidYAML(out, buf.Bytes())
+}
+
+// writeValidYAML writes the YAML given, after checking it parses, so
+// that it's never written in a form that can't be used again.
+func writeValidYAML(out io.Writer, doc []byte) error {
+	var v interface{}
+	if err := yaml.Unmarshal(doc, &v); err != nil {
+		return fmt.Errorf("generated invalid YAML: %s", err)
+	}
+	_, err := out.Write(doc)
+	return err
+}
@@ -2,14 +2,23 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"text/tabwriter"
 
+	"github.com/ghodss/yaml"
 	"github.com/spf13/cobra"
 )
 
+// Formats for printing structured data instead of tables
+const (
+	outputFormatJSON = "json"
+	outputFormatYAML = "yaml"
+)
+
 type outputOpts struct {
 	verbosity int
 }
@@ -29,3 +38,40 @@ func makeExample(examples ...string) string {
 	}
 	return strings.TrimSuffix(buf.String(), "\n")
 }
+
+// checkOutputFormat returns a usage error unless the format given is
+// one of those given, or a format for structured data.
+func checkOutputFormat(format string, others ...string) error {
+	switch format {
+	case outputFormatJSON, outputFormatYAML:
+		return nil
+	}
+	for _, f := range others {
+		if format == f {
+			return nil
+		}
+	}
+	return newUsageError(fmt.Sprintf("unknown output format %q", format))
+}
+
+func isStructuredOutput(format string) bool {
+	return format == outputFormatJSON || format == outputFormatYAML
+}
+
+// printStructured writes the value given as JSON or YAML. The field
+// names are those used in the API, so they are as stable as the API.
+func printStructured(out io.Writer, format string, v interface{}) error {
+	switch format {
+	case outputFormatYAML:
+		bytes, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(bytes)
+		return err
+	default:
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+}
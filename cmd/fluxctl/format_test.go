@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/api/v6"
+)
+
+func TestCheckOutputFormat(t *testing.T) {
+	for _, format := range []string{"", "wide", "json", "yaml"} {
+		if err := checkOutputFormat(format, "", "wide"); err != nil {
+			t.Errorf("expected %q to be accepted, got %v", format, err)
+		}
+	}
+	if err := checkOutputFormat("wide", ""); err == nil {
+		t.Error("expected \"wide\" to be rejected when not given as a format")
+	}
+}
+
+func TestPrintStructured(t *testing.T) {
+	workloads := []v6.ControllerStatus{{
+		ID:     flux.MustParseResourceID("default:deployment/foo"),
+		Status: "ready",
+	}}
+
+	for format, expected := range map[string]string{
+		"json": `"ID": "default:deployment/foo"`,
+		"yaml": `ID: default:deployment/foo`,
+	} {
+		buf := &bytes.Buffer{}
+		if err := printStructured(buf, format, workloads); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(buf.Bytes(), []byte(expected)) {
+			t.Errorf("expected %s output to contain %q, got:\n%s", format, expected, buf.String())
+		}
+	}
+}
@@ -9,9 +9,10 @@ import (
 
 type identityOpts struct {
 	*rootOpts
-	regenerate  bool
-	fingerprint bool
-	visual      bool
+	regenerate   bool
+	fingerprint  bool
+	visual       bool
+	outputFormat string
 }
 
 func newIdentity(parent *rootOpts) *identityOpts {
@@ -27,13 +28,17 @@ func (opts *identityOpts) Command() *cobra.Command {
 	cmd.Flags().BoolVarP(&opts.regenerate, "regenerate", "r", false, `Generate a new identity`)
 	cmd.Flags().BoolVarP(&opts.fingerprint, "fingerprint", "l", false, `Show fingerprint of public key`)
 	cmd.Flags().BoolVarP(&opts.visual, "visual", "v", false, `Show ASCII art representation with fingerprint (implies -l)`)
+	cmd.Flags().StringVarP(&opts.outputFormat, "output-format", "o", "", `Output format; "json" or "yaml" print the public key and its fingerprints as data`)
 	return cmd
 }
 
-func (opts *identityOpts) RunE(_ *cobra.Command, args []string) error {
+func (opts *identityOpts) RunE(cmd *cobra.Command, args []string) error {
 	if len(args) > 0 {
 		return errorWantedNoArgs
 	}
+	if err := checkOutputFormat(opts.outputFormat, ""); err != nil {
+		return err
+	}
 
 	ctx := context.Background()
 
@@ -42,6 +47,9 @@ func (opts *identityOpts) RunE(_ *cobra.Command, args []string) error {
 		return err
 	}
 	publicSSHKey := repoConfig.PublicSSHKey
+	if isStructuredOutput(opts.outputFormat) {
+		return printStructured(cmd.OutOrStdout(), opts.outputFormat, publicSSHKey)
+	}
 
 	if opts.visual {
 		opts.fingerprint = true
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux/api/v12"
+	"github.com/weaveworks/flux/job"
+)
+
+type jobsOpts struct {
+	*rootOpts
+	history      bool
+	limit        int
+	outputFormat string
+}
+
+func newJobs(parent *rootOpts) *jobsOpts {
+	return &jobsOpts{rootOpts: parent}
+}
+
+func (opts *jobsOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "jobs",
+		Short: "Show the jobs (releases, policy changes, syncs and so on) that are queued or running, or with --history, those that have finished too.",
+		Example: makeExample(
+			"fluxctl jobs",
+			"fluxctl jobs --history --limit=20",
+		),
+		RunE: opts.RunE,
+	}
+	cmd.Flags().BoolVar(&opts.history, "history", false, "Include the jobs that have finished, as far back as the daemon keeps a record of")
+	cmd.Flags().IntVar(&opts.limit, "limit", 0, "With --history, show at most this many of the most recent jobs")
+	cmd.Flags().StringVarP(&opts.outputFormat, "output-format", "o", "", "Output format; \"json\" or \"yaml\" print the jobs as data")
+	return cmd
+}
+
+func (opts *jobsOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errorWantedNoArgs
+	}
+	if err := checkOutputFormat(opts.outputFormat, ""); err != nil {
+		return err
+	}
+	if opts.limit != 0 && !opts.history {
+		return newUsageError("--limit can only be given with --history")
+	}
+
+	query := v12.JobHistoryOptions{}
+	if opts.history {
+		query.Limit = opts.limit
+	}
+	records, err := opts.API.JobHistory(context.Background(), query)
+	if err != nil {
+		return err
+	}
+	if !opts.history {
+		var pending []job.Record
+		for _, r := range records {
+			if r.Status == job.StatusQueued || r.Status == job.StatusRunning {
+				pending = append(pending, r)
+			}
+		}
+		records = pending
+	}
+	if isStructuredOutput(opts.outputFormat) {
+		if records == nil {
+			records = []job.Record{}
+		}
+		return printStructured(cmd.OutOrStdout(), opts.outputFormat, records)
+	}
+
+	if len(records) == 0 {
+		if opts.history {
+			fmt.Fprintln(cmd.OutOrStdout(), "No jobs on record.")
+		} else {
+			fmt.Fprintln(cmd.OutOrStdout(), "No jobs queued or running.")
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 2, 2, ' ', 0)
+	printJobRecords(w, records)
+	return w.Flush()
+}
+
+func printJobRecords(out io.Writer, records []job.Record) {
+	fmt.Fprintf(out, "ID\tTYPE\tUSER\tQUEUED\tSTATUS\tDURATION\tREVISION\tERROR\n")
+	for _, r := range records {
+		var duration string
+		if r.Status == job.StatusSucceeded || r.Status == job.StatusFailed {
+			duration = r.Duration.Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.Type, r.User, r.Queued.Local().Format(time.RFC3339), r.Status, duration, abbreviateRevision(r.Revision), r.Err)
+	}
+}
@@ -17,9 +17,10 @@ import (
 
 type imageListOpts struct {
 	*rootOpts
-	namespace string
-	workload  string
-	limit     int
+	namespace    string
+	workload     string
+	limit        int
+	outputFormat string
 
 	// Deprecated
 	controller string
@@ -39,6 +40,7 @@ func (opts *imageListOpts) Command() *cobra.Command {
 	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "", "Namespace")
 	cmd.Flags().StringVarP(&opts.workload, "workload", "w", "", "Show images for this workload")
 	cmd.Flags().IntVarP(&opts.limit, "limit", "l", 10, "Number of images to show (0 for all)")
+	cmd.Flags().StringVarP(&opts.outputFormat, "output-format", "o", "", "Output format; \"json\" or \"yaml\" print the images (all of them, regardless of --limit) as data")
 
 	// Deprecated
 	cmd.Flags().StringVarP(&opts.controller, "controller", "c", "", "Show images for this controller")
@@ -51,6 +53,9 @@ func (opts *imageListOpts) RunE(cmd *cobra.Command, args []string) error {
 	if len(args) != 0 {
 		return errorWantedNoArgs
 	}
+	if err := checkOutputFormat(opts.outputFormat, ""); err != nil {
+		return err
+	}
 
 	imageOpts := v10.ListImagesOptions{
 		Spec:      update.ResourceSpecAll,
@@ -81,6 +86,10 @@ func (opts *imageListOpts) RunE(cmd *cobra.Command, args []string) error {
 
 	sort.Sort(imageStatusByName(workloads))
 
+	if isStructuredOutput(opts.outputFormat) {
+		return printStructured(cmd.OutOrStdout(), opts.outputFormat, workloads)
+	}
+
 	out := newTabwriter()
 
 	fmt.Fprintln(out, "WORKLOAD\tCONTAINER\tIMAGE\tCREATED")
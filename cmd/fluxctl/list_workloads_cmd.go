@@ -16,6 +16,7 @@ type workloadListOpts struct {
 	*rootOpts
 	namespace     string
 	allNamespaces bool
+	outputFormat  string
 }
 
 func newWorkloadList(parent *rootOpts) *workloadListOpts {
@@ -32,6 +33,7 @@ func (opts *workloadListOpts) Command() *cobra.Command {
 	}
 	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "default", "Confine query to namespace")
 	cmd.Flags().BoolVarP(&opts.allNamespaces, "all-namespaces", "a", false, "Query across all namespaces")
+	cmd.Flags().StringVarP(&opts.outputFormat, "output-format", "o", "", "Output format; \"wide\" includes the git revision each workload was last synced from, and \"json\" or \"yaml\" print the workloads as data")
 	return cmd
 }
 
@@ -40,6 +42,11 @@ func (opts *workloadListOpts) RunE(cmd *cobra.Command, args []string) error {
 		return errorWantedNoArgs
 	}
 
+	if err := checkOutputFormat(opts.outputFormat, "", "wide"); err != nil {
+		return err
+	}
+	wide := opts.outputFormat == "wide"
+
 	if opts.allNamespaces {
 		opts.namespace = ""
 	}
@@ -53,17 +60,29 @@ func (opts *workloadListOpts) RunE(cmd *cobra.Command, args []string) error {
 
 	sort.Sort(workloadStatusByName(workloads))
 
+	if isStructuredOutput(opts.outputFormat) {
+		return printStructured(cmd.OutOrStdout(), opts.outputFormat, workloads)
+	}
+
 	w := newTabwriter()
-	fmt.Fprintf(w, "WORKLOAD\tCONTAINER\tIMAGE\tRELEASE\tPOLICY\n")
+	if wide {
+		fmt.Fprintf(w, "WORKLOAD\tCONTAINER\tIMAGE\tRELEASE\tPOLICY\tSYNCED\n")
+	} else {
+		fmt.Fprintf(w, "WORKLOAD\tCONTAINER\tIMAGE\tRELEASE\tPOLICY\n")
+	}
 	for _, workload := range workloads {
+		var synced string
+		if wide {
+			synced = "\t" + syncedRevision(workload)
+		}
 		if len(workload.Containers) > 0 {
 			c := workload.Containers[0]
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", workload.ID, c.Name, c.Current.ID, workload.Status, policies(workload))
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s%s\n", workload.ID, c.Name, c.Current.ID, workload.Status, policies(workload), synced)
 			for _, c := range workload.Containers[1:] {
 				fmt.Fprintf(w, "\t%s\t%s\t\t\n", c.Name, c.Current.ID)
 			}
 		} else {
-			fmt.Fprintf(w, "%s\t\t\t\t\n", workload.ID)
+			fmt.Fprintf(w, "%s\t\t\t\t%s\n", workload.ID, synced)
 		}
 	}
 	w.Flush()
@@ -98,3 +117,16 @@ func policies(s v6.ControllerStatus) string {
 	sort.Strings(ps)
 	return strings.Join(ps, ",")
 }
+
+// syncedRevision gives the (abbreviated) revision a workload was last
+// synced from, for display.
+func syncedRevision(s v6.ControllerStatus) string {
+	switch {
+	case s.SyncedRevision == "":
+		return "<not synced>"
+	case len(s.SyncedRevision) > 7:
+		return s.SyncedRevision[:7]
+	default:
+		return s.SyncedRevision
+	}
+}
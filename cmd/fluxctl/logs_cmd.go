@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux/api/v12"
+)
+
+type logsOpts struct {
+	*rootOpts
+	follow       bool
+	since        time.Duration
+	types        []string
+	pollInterval time.Duration
+	outputFormat string
+}
+
+func newLogs(parent *rootOpts) *logsOpts {
+	return &logsOpts{rootOpts: parent, pollInterval: 2 * time.Second}
+}
+
+var loopEventTypes = []string{v12.LoopEventSync, v12.LoopEventImagePoll, v12.LoopEventJob, v12.LoopEventGit}
+
+func (opts *logsOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logs",
+		Short: "Show the recent events from the daemon's loop (syncs, image polls, jobs and git refreshes).",
+		Example: makeExample(
+			"fluxctl logs --follow",
+			"fluxctl logs --since=1h --type=sync",
+		),
+		RunE: opts.RunE,
+	}
+	cmd.Flags().BoolVarP(&opts.follow, "follow", "f", false, "Keep showing events as they happen")
+	cmd.Flags().DurationVar(&opts.since, "since", 0, "Only show events from this long ago onwards (e.g., 10m); by default, all those the daemon has kept are shown")
+	cmd.Flags().StringSliceVar(&opts.types, "type", nil, fmt.Sprintf("Only show events of these types; any of %v", loopEventTypes))
+	cmd.Flags().StringVarP(&opts.outputFormat, "output-format", "o", "", "Output format; \"json\" or \"yaml\" print each event as data, as a JSON object or a YAML document")
+	return cmd
+}
+
+func (opts *logsOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return errorWantedNoArgs
+	}
+	if err := checkOutputFormat(opts.outputFormat, ""); err != nil {
+		return err
+	}
+	for _, t := range opts.types {
+		known := false
+		for _, k := range loopEventTypes {
+			known = known || t == k
+		}
+		if !known {
+			return newUsageError(fmt.Sprintf("unknown event type %q; expected any of %v", t, loopEventTypes))
+		}
+	}
+
+	ctx := context.Background()
+	query := v12.LoopEventsOptions{Types: opts.types}
+	if opts.since > 0 {
+		query.Since = time.Now().Add(-opts.since)
+	}
+	for {
+		events, err := opts.API.LoopEvents(ctx, query)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			// Events are printed one at a time, rather than as a
+			// list, so that they can be followed
+			switch {
+			case opts.outputFormat == outputFormatYAML:
+				fmt.Fprintln(cmd.OutOrStdout(), "---")
+				fallthrough
+			case isStructuredOutput(opts.outputFormat):
+				if err := printStructured(cmd.OutOrStdout(), opts.outputFormat, e); err != nil {
+					return err
+				}
+			default:
+				printLoopEvent(cmd.OutOrStdout(), e)
+			}
+			// Later queries are for events after those already
+			// shown, according to the daemon's clock
+			query.Since = e.Time
+		}
+		if !opts.follow {
+			return nil
+		}
+		time.Sleep(opts.pollInterval)
+	}
+}
+
+func printLoopEvent(out io.Writer, e v12.LoopEvent) {
+	line := fmt.Sprintf("%s %-10s %s", e.Time.Local().Format(time.RFC3339), e.Type, e.Message)
+	if e.Error != "" {
+		line += ": " + e.Error
+	}
+	fmt.Fprintln(out, line)
+}
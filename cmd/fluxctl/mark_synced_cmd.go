@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux/update"
+)
+
+type markSyncedOpts struct {
+	syncTagOpts
+}
+
+func newMarkSynced(parent *rootOpts) *markSyncedOpts {
+	return &markSyncedOpts{syncTagOpts{rootOpts: parent}}
+}
+
+func (opts *markSyncedOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "mark-synced",
+		Short: "Move the sync tag to a revision without applying anything, as though it had been synced.",
+		Long: `Move the sync tag to a revision without applying anything, as though it had been synced.
+
+This is for recovering when the manifests have been applied some other
+way, e.g., by hand with kubectl. The revision must be in the history
+of the branch that's synced. Nothing changed up to the revision is
+applied because of it; with --sync-incremental, the next sync applies
+only what's changed since.`,
+		Example: makeExample(
+			"fluxctl mark-synced --revision=3f2c9a1",
+		),
+		RunE: opts.RunE,
+	}
+	opts.addFlags(cmd)
+	return cmd
+}
+
+func (opts *markSyncedOpts) RunE(cmd *cobra.Command, args []string) error {
+	return opts.moveSyncTag(cmd, args, syncTagMove{
+		spec: func(revision string) update.Spec {
+			return update.Spec{Type: update.Mark, Spec: update.MarkSynced{Revision: revision}}
+		},
+		warning: "This moves the sync tag to %s without applying anything; changes up to then are taken to have been applied already.",
+		refusal: "not moving the sync tag",
+		failure: "Failed to move sync tag",
+		done:    "Marked %s as synced; nothing was applied.",
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux/api/v12"
+)
+
+type mergePreviewOpts struct {
+	*rootOpts
+	branch       string
+	outputFormat string
+	maxDiffLines int
+}
+
+func newMergePreview(parent *rootOpts) *mergePreviewOpts {
+	return &mergePreviewOpts{rootOpts: parent}
+}
+
+func (opts *mergePreviewOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "preview-merge",
+		Short: "Show what syncing would change, were a branch merged into the branch that's synced; nothing is merged or applied.",
+		Example: makeExample(
+			"fluxctl preview-merge --branch=feature/new-ingress",
+			"fluxctl preview-merge --branch=feature/new-ingress --output-format=markdown --max-diff-lines=20",
+		),
+		RunE: opts.RunE,
+	}
+	cmd.Flags().StringVarP(&opts.branch, "branch", "b", "", "The branch to preview merging")
+	cmd.Flags().StringVarP(&opts.outputFormat, "output-format", "o", "", "Output format; \"json\" or \"yaml\" print the preview as data, and \"ci-json\" or \"markdown\" print a report for CI, e.g., to post on a pull request")
+	cmd.Flags().IntVar(&opts.maxDiffLines, "max-diff-lines", 0, "With --output-format=ci-json or markdown, cut each diff down to this many lines, if not zero")
+	return cmd
+}
+
+func (opts *mergePreviewOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errorWantedNoArgs
+	}
+	if opts.branch == "" {
+		return newUsageError("-b, --branch is required")
+	}
+	if err := checkOutputFormat(opts.outputFormat, "", mergeFormatCIJSON, mergeFormatMarkdown); err != nil {
+		return err
+	}
+
+	preview, err := opts.API.MergePreview(context.Background(), v12.MergePreviewOptions{Branch: opts.branch})
+	if err != nil {
+		return err
+	}
+	if isStructuredOutput(opts.outputFormat) {
+		return printStructured(cmd.OutOrStdout(), opts.outputFormat, preview)
+	}
+
+	out := cmd.OutOrStdout()
+	if opts.outputFormat == mergeFormatCIJSON || opts.outputFormat == mergeFormatMarkdown {
+		report := makeMergeReport(preview, opts.maxDiffLines)
+		if opts.outputFormat == mergeFormatCIJSON {
+			err = printMergeReportJSON(out, report)
+		} else {
+			err = printMergeReportMarkdown(out, report)
+		}
+		if err == nil && len(report.Conflicts) > 0 {
+			err = errors.New("the branch does not merge cleanly")
+		}
+		return err
+	}
+	if len(preview.Conflicts) > 0 {
+		fmt.Fprintf(out, "Merging %s (%s) into %s has conflicts in:\n", preview.Branch, abbreviateRevision(preview.BranchRevision), abbreviateRevision(preview.BaseRevision))
+		for _, file := range preview.Conflicts {
+			fmt.Fprintf(out, "  %s\n", file)
+		}
+		return errors.New("the branch does not merge cleanly")
+	}
+	if len(preview.Changes) == 0 {
+		fmt.Fprintf(out, "Merging %s (%s) into %s would change nothing in the cluster.\n", preview.Branch, abbreviateRevision(preview.BranchRevision), abbreviateRevision(preview.BaseRevision))
+		return nil
+	}
+	fmt.Fprintf(out, "Merging %s (%s) into %s would change %d resources:\n", preview.Branch, abbreviateRevision(preview.BranchRevision), abbreviateRevision(preview.BaseRevision), len(preview.Changes))
+	for _, change := range preview.Changes {
+		switch {
+		case change.Deleted:
+			fmt.Fprintf(out, "\n%s: would be deleted\n", change.ID)
+			continue
+		case change.Missing:
+			fmt.Fprintf(out, "\n%s (%s): would be created\n", change.ID, change.Source)
+			continue
+		}
+		fmt.Fprintf(out, "\n%s (%s):\n%s", change.ID, change.Source, change.Diff)
+	}
+	return nil
+}
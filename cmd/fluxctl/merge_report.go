@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/weaveworks/flux/api/v12"
+)
+
+// Formats for fluxctl preview-merge meant for CI, e.g., for posting
+// as a comment on a pull request. Both are deterministic: the same
+// preview always gives the same output, so comments don't change
+// unless the preview does.
+const (
+	// a report as JSON, with field names of its own, which are kept
+	// stable
+	mergeFormatCIJSON = "ci-json"
+	// the same report as markdown
+	mergeFormatMarkdown = "markdown"
+)
+
+// How a resource would be changed by syncing
+const (
+	changeCreate = "create"
+	changeUpdate = "update"
+	changeDelete = "delete"
+)
+
+type mergeReport struct {
+	Branch         string              `json:"branch"`
+	BaseRevision   string              `json:"baseRevision"`
+	BranchRevision string              `json:"branchRevision"`
+	Conflicts      []string            `json:"conflicts"`
+	Summary        mergeReportSummary  `json:"summary"`
+	Changes        []mergeReportChange `json:"changes"`
+}
+
+type mergeReportSummary struct {
+	Create int `json:"create"`
+	Update int `json:"update"`
+	Delete int `json:"delete"`
+}
+
+type mergeReportChange struct {
+	ID        string `json:"id"`
+	Source    string `json:"source,omitempty"`
+	Change    string `json:"change"`
+	Diff      string `json:"diff,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// makeMergeReport gives the report for the preview, with each diff
+// cut down to at most maxDiffLines lines if that's more than
+// zero. Everything is sorted, so that the report doesn't depend on
+// the order the daemon gave things in.
+func makeMergeReport(preview v12.MergePreview, maxDiffLines int) mergeReport {
+	report := mergeReport{
+		Branch:         preview.Branch,
+		BaseRevision:   preview.BaseRevision,
+		BranchRevision: preview.BranchRevision,
+		Conflicts:      append([]string{}, preview.Conflicts...),
+		Changes:        []mergeReportChange{},
+	}
+	sort.Strings(report.Conflicts)
+	for _, c := range preview.Changes {
+		change := mergeReportChange{
+			ID:        c.ID.String(),
+			Source:    c.Source,
+			Change:    changeUpdate,
+			Diff:      c.Diff,
+			Truncated: c.Truncated,
+		}
+		switch {
+		case c.Deleted:
+			change.Change = changeDelete
+			change.Diff, change.Truncated = "", false
+			report.Summary.Delete++
+		case c.Missing:
+			change.Change = changeCreate
+			change.Diff, change.Truncated = "", false
+			report.Summary.Create++
+		default:
+			report.Summary.Update++
+		}
+		if maxDiffLines > 0 {
+			lines := strings.SplitAfter(strings.TrimSuffix(change.Diff, "\n"), "\n")
+			if len(lines) > maxDiffLines {
+				change.Diff = strings.Join(lines[:maxDiffLines], "") + "\n"
+				change.Truncated = true
+			}
+		}
+		report.Changes = append(report.Changes, change)
+	}
+	sort.SliceStable(report.Changes, func(i, j int) bool {
+		if report.Changes[i].ID != report.Changes[j].ID {
+			return report.Changes[i].ID < report.Changes[j].ID
+		}
+		return report.Changes[i].Source < report.Changes[j].Source
+	})
+	return report
+}
+
+func printMergeReportJSON(out io.Writer, report mergeReport) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+func printMergeReportMarkdown(out io.Writer, report mergeReport) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "### Merging `%s` (`%s`) into `%s`\n\n", report.Branch, abbreviateRevision(report.BranchRevision), abbreviateRevision(report.BaseRevision))
+	switch {
+	case len(report.Conflicts) > 0:
+		buf.WriteString("The branch does not merge cleanly; there are conflicts in:\n\n")
+		for _, file := range report.Conflicts {
+			fmt.Fprintf(&buf, "- `%s`\n", file)
+		}
+	case len(report.Changes) == 0:
+		buf.WriteString("Syncing would change nothing in the cluster.\n")
+	default:
+		fmt.Fprintf(&buf, "Syncing would change %d resources: %d created, %d updated, %d deleted.\n\n", len(report.Changes), report.Summary.Create, report.Summary.Update, report.Summary.Delete)
+		buf.WriteString("| Resource | Source | Change |\n|---|---|---|\n")
+		for _, c := range report.Changes {
+			// A resource to be deleted has no source
+			source := ""
+			if c.Source != "" {
+				source = "`" + c.Source + "`"
+			}
+			fmt.Fprintf(&buf, "| `%s` | %s | %s |\n", c.ID, source, c.Change)
+		}
+		for _, c := range report.Changes {
+			if c.Diff == "" {
+				continue
+			}
+			fence := markdownFence(c.Diff)
+			fmt.Fprintf(&buf, "\n<details><summary><code>%s</code></summary>\n\n%sdiff\n%s", c.ID, fence, c.Diff)
+			if !strings.HasSuffix(c.Diff, "\n") {
+				buf.WriteString("\n")
+			}
+			buf.WriteString(fence + "\n")
+			if c.Truncated {
+				buf.WriteString("\n(truncated)\n")
+			}
+			buf.WriteString("\n</details>\n")
+		}
+	}
+	_, err := out.Write(buf.Bytes())
+	return err
+}
+
+// markdownFence gives a code fence longer than any run of backticks
+// in the text given, so the text can't close it.
+func markdownFence(text string) string {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	return fence
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/api/v12"
+)
+
+func TestMergeReport(t *testing.T) {
+	preview := v12.MergePreview{
+		Branch:         "feature",
+		BaseRevision:   "9f4e2d1aaaaaaaa",
+		BranchRevision: "7d1e0b2bbbbbbbb",
+		Changes: []v12.ResourceChange{
+			{ID: flux.MustParseResourceID("default:ingress/hello"), Source: "ingress.yaml", Missing: true},
+			{ID: flux.MustParseResourceID("default:deployment/hello"), Source: "deploy.yaml", Diff: "--- git\n+++ cluster\n-  replicas: 3\n+  replicas: 1\n"},
+			{ID: flux.MustParseResourceID("default:service/old"), Deleted: true},
+		},
+	}
+	report := makeMergeReport(preview, 3)
+	assert.Equal(t, mergeReportSummary{Create: 1, Update: 1, Delete: 1}, report.Summary)
+	if assert.Len(t, report.Changes, 3) {
+		// Sorted by ID, whatever order they came in
+		assert.Equal(t, "default:deployment/hello", report.Changes[0].ID)
+		assert.Equal(t, changeUpdate, report.Changes[0].Change)
+		assert.Equal(t, "--- git\n+++ cluster\n-  replicas: 3\n", report.Changes[0].Diff)
+		assert.True(t, report.Changes[0].Truncated)
+		assert.Equal(t, changeCreate, report.Changes[1].Change)
+		assert.Empty(t, report.Changes[1].Diff)
+		assert.Equal(t, "default:service/old", report.Changes[2].ID)
+		assert.Equal(t, changeDelete, report.Changes[2].Change)
+	}
+
+	// The same preview, with changes in another order, gives the
+	// same output
+	reversed := preview
+	reversed.Changes = []v12.ResourceChange{preview.Changes[2], preview.Changes[1], preview.Changes[0]}
+	for _, printReport := range []func(io.Writer, mergeReport) error{printMergeReportJSON, printMergeReportMarkdown} {
+		var a, b bytes.Buffer
+		assert.NoError(t, printReport(&a, makeMergeReport(preview, 0)))
+		assert.NoError(t, printReport(&b, makeMergeReport(reversed, 0)))
+		assert.Equal(t, a.String(), b.String())
+	}
+
+	var md bytes.Buffer
+	assert.NoError(t, printMergeReportMarkdown(&md, report))
+	assert.True(t, strings.Contains(md.String(), "| `default:ingress/hello` | `ingress.yaml` | create |"), md.String())
+	assert.True(t, strings.Contains(md.String(), "| `default:service/old` |  | delete |"), md.String())
+	assert.True(t, strings.Contains(md.String(), "```diff\n"), md.String())
+}
+
+func TestMarkdownFence(t *testing.T) {
+	assert.Equal(t, "```", markdownFence("no backticks"))
+	assert.Equal(t, "````", markdownFence("has ``` in it"))
+}
@@ -19,9 +19,11 @@ type workloadPolicyOpts struct {
 	workload  string
 	tagAll    string
 	tags      []string
+	excludes  []string
 
 	automate, deautomate bool
 	lock, unlock         bool
+	preview              bool
 
 	cause update.Cause
 
@@ -46,12 +48,23 @@ Surrounding these with single-quotes are recommended to avoid shell expansion.
 
 If both --tag-all and --tag are specified, --tag-all will apply to all
 containers which aren't explicitly named.
+
+Tags can be excluded with --tag-exclude='container=patterns', giving
+patterns separated by spaces; a tag matching an exclusion is never
+used, even if it matches the tag filter. Use 'container=' to remove
+the exclusions.
+
+With --preview, nothing is changed; instead, the images automation
+would select for each container, before and after the change, are
+shown.
         `,
 		Example: makeExample(
 			"fluxctl policy --workload=default:deployment/foo --automate",
 			"fluxctl policy --workload=default:deployment/foo --lock",
 			"fluxctl policy --workload=default:deployment/foo --tag='bar=1.*' --tag='baz=2.*'",
 			"fluxctl policy --workload=default:deployment/foo --tag-all='master-*' --tag='bar=1.*'",
+			"fluxctl policy --workload=default:deployment/foo --tag-exclude='bar=latest nightly-* regexp:^pr-'",
+			"fluxctl policy --workload=default:deployment/foo --tag-all='semver:~2' --preview",
 		),
 		RunE: opts.RunE,
 	}
@@ -63,10 +76,12 @@ containers which aren't explicitly named.
 	flags.StringVarP(&opts.workload, "workload", "w", "", "Workload to modify")
 	flags.StringVar(&opts.tagAll, "tag-all", "", "Tag filter pattern to apply to all containers")
 	flags.StringSliceVar(&opts.tags, "tag", nil, "Tag filter container/pattern pairs")
+	flags.StringSliceVar(&opts.excludes, "tag-exclude", nil, "Tag exclusion container/patterns pairs")
 	flags.BoolVar(&opts.automate, "automate", false, "Automate workload")
 	flags.BoolVar(&opts.deautomate, "deautomate", false, "Deautomate workload")
 	flags.BoolVar(&opts.lock, "lock", false, "Lock workload")
 	flags.BoolVar(&opts.unlock, "unlock", false, "Unlock workload")
+	flags.BoolVar(&opts.preview, "preview", false, "Show the images automation would select after the change, without making it")
 
 	// Deprecated
 	flags.StringVarP(&opts.controller, "controller", "c", "", "Controller to modify")
@@ -107,6 +122,10 @@ func (opts *workloadPolicyOpts) RunE(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	if opts.preview {
+		return opts.previewPolicy(cmd.OutOrStdout(), resourceID, changes)
+	}
+
 	ctx := context.Background()
 	updates := policy.Updates{
 		resourceID: changes,
@@ -164,6 +183,24 @@ func calculatePolicyChanges(opts *workloadPolicyOpts) (policy.Update, error) {
 		}
 	}
 
+	for _, excludePair := range opts.excludes {
+		parts := strings.SplitN(excludePair, "=", 2)
+		if len(parts) != 2 {
+			return policy.Update{}, fmt.Errorf("invalid container/exclusions pair: %q. Expected format is 'container=patterns'", excludePair)
+		}
+
+		container, patterns := parts[0], policy.NewPatterns(parts[1])
+		if len(patterns) == 0 {
+			remove = remove.Add(policy.TagExcludePrefix(container))
+			continue
+		}
+		excludes := make([]string, len(patterns))
+		for i, p := range patterns {
+			excludes[i] = p.String()
+		}
+		add = add.Set(policy.TagExcludePrefix(container), strings.Join(excludes, " "))
+	}
+
 	return policy.Update{
 		Add:    add,
 		Remove: remove,
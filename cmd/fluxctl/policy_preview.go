@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/api/v10"
+	"github.com/weaveworks/flux/api/v11"
+	"github.com/weaveworks/flux/api/v6"
+	"github.com/weaveworks/flux/policy"
+	"github.com/weaveworks/flux/update"
+)
+
+// containerPreview is the image automation would select for a
+// container, before and after a change of policy.
+type containerPreview struct {
+	name    string
+	current string
+	before  string
+	after   string
+}
+
+// previewPolicyUpdate applies the update to the policies given, in
+// memory, and works out which image would be selected for each
+// container before and after, using the images available.
+func previewPolicyUpdate(before policy.Set, u policy.Update, images *v6.ImageStatus) (policy.Set, []containerPreview) {
+	var containers []string
+	if images != nil {
+		for _, c := range images.Containers {
+			containers = append(containers, c.Name)
+		}
+	}
+	after := before.Apply(u.ExpandTagAll(containers))
+
+	var previews []containerPreview
+	if images == nil {
+		return after, nil
+	}
+	for _, c := range images.Containers {
+		previews = append(previews, containerPreview{
+			name:    c.Name,
+			current: c.Current.ID.String(),
+			before:  selectedImage(before, c),
+			after:   selectedImage(after, c),
+		})
+	}
+	return after, previews
+}
+
+// selectedImage gives the newest image available for the container
+// that matches its tag filter under the policies given.
+func selectedImage(policies policy.Set, c v6.Container) string {
+	pattern := policy.GetTagPattern(policies, c.Name)
+	latest, ok := update.ImageInfos(c.Available).FilterAndSort(pattern).Latest()
+	if !ok {
+		return "(no matching image)"
+	}
+	if latest.ID.String() == c.Current.ID.String() {
+		return "(current)"
+	}
+	return latest.ID.String()
+}
+
+// automationState says whether a workload with the policies given is
+// automated, and if not, why not.
+func automationState(policies policy.Set) string {
+	switch {
+	case !policies.Has(policy.Automated):
+		return "no"
+	case policies.Has(policy.Locked):
+		return "no (locked)"
+	case policies.Has(policy.Ignore):
+		return "no (ignored)"
+	}
+	return "yes"
+}
+
+// previewPolicy reports what automation would select for the
+// workload if the update were made, without making it.
+func (opts *workloadPolicyOpts) previewPolicy(out io.Writer, id flux.ResourceID, u policy.Update) error {
+	ctx := context.Background()
+	workloads, err := opts.API.ListServicesWithOptions(ctx, v11.ListServicesOptions{Services: []flux.ResourceID{id}})
+	if err != nil {
+		return err
+	}
+	var before policy.Set
+	found := false
+	for _, w := range workloads {
+		if w.ID == id {
+			found = true
+			before = policy.Set{}
+			for k, v := range w.Policies {
+				before = before.Set(policy.Policy(k), v)
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s was not found in the cluster", id)
+	}
+
+	var images *v6.ImageStatus
+	statuses, err := opts.API.ListImagesWithOptions(ctx, v10.ListImagesOptions{Spec: update.MakeResourceSpec(id)})
+	if err != nil {
+		return err
+	}
+	for i := range statuses {
+		if statuses[i].ID == id {
+			images = &statuses[i]
+		}
+	}
+
+	after, previews := previewPolicyUpdate(before, u, images)
+	fmt.Fprintf(out, "Automated: %s -> %s\n\n", automationState(before), automationState(after))
+	w := tabwriter.NewWriter(out, 0, 2, 2, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER\tCURRENT\tSELECTED BEFORE\tSELECTED AFTER")
+	for _, p := range previews {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.name, p.current, p.before, p.after)
+	}
+	w.Flush()
+	fmt.Fprintln(out, "\nThis is a preview; the policy has not been changed.")
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/api/v6"
+	"github.com/weaveworks/flux/image"
+	"github.com/weaveworks/flux/policy"
+)
+
+func TestPreviewPolicyUpdate(t *testing.T) {
+	ref := func(s string) image.Ref {
+		r, err := image.ParseRef(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	now := time.Now()
+	images := &v6.ImageStatus{
+		ID: flux.MustParseResourceID("default:deployment/foo"),
+		Containers: []v6.Container{{
+			Name:    "foo",
+			Current: image.Info{ID: ref("quay.io/weaveworks/foo:1.0.0"), CreatedAt: now.Add(-3 * time.Hour)},
+			Available: []image.Info{
+				{ID: ref("quay.io/weaveworks/foo:master-abc123"), CreatedAt: now},
+				{ID: ref("quay.io/weaveworks/foo:2.0.0"), CreatedAt: now.Add(-time.Hour)},
+				{ID: ref("quay.io/weaveworks/foo:1.1.0"), CreatedAt: now.Add(-2 * time.Hour)},
+				{ID: ref("quay.io/weaveworks/foo:1.0.0"), CreatedAt: now.Add(-3 * time.Hour)},
+			},
+		}},
+	}
+
+	before := policy.Set{}.Add(policy.Automated)
+	u := policy.Update{
+		Add: policy.Set{}.Set(policy.TagAll, "semver:~1"),
+	}
+	after, previews := previewPolicyUpdate(before, u, images)
+	if v, _ := after.Get(policy.TagPrefix("foo")); v != "semver:~1" {
+		t.Errorf("expected tag filter to be applied to container foo, got %q", v)
+	}
+	if _, ok := before.Get(policy.TagPrefix("foo")); ok {
+		t.Error("expected policies before not to be changed")
+	}
+	if len(previews) != 1 {
+		t.Fatalf("expected one container, got %d", len(previews))
+	}
+	p := previews[0]
+	if p.before != "quay.io/weaveworks/foo:master-abc123" {
+		t.Errorf("expected newest image to be selected before, got %q", p.before)
+	}
+	if p.after != "quay.io/weaveworks/foo:1.1.0" {
+		t.Errorf("expected newest 1.x image to be selected after, got %q", p.after)
+	}
+
+	// Removing the filter again goes back to selecting the newest
+	after, previews = previewPolicyUpdate(after, policy.Update{
+		Add:    policy.Set{}.Set(policy.TagAll, policy.PatternAll.String()),
+		Remove: policy.Set{}.Add(policy.Automated),
+	}, images)
+	if _, ok := after.Get(policy.TagPrefix("foo")); ok {
+		t.Error("expected tag filter to be removed")
+	}
+	if automationState(after) != "no" {
+		t.Errorf("expected workload not to be automated, got %q", automationState(after))
+	}
+	if previews[0].after != "quay.io/weaveworks/foo:master-abc123" {
+		t.Errorf("expected newest image to be selected, got %q", previews[0].after)
+	}
+}
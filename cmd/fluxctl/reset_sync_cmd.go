@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux/update"
+)
+
+type resetSyncOpts struct {
+	syncTagOpts
+}
+
+func newResetSync(parent *rootOpts) *resetSyncOpts {
+	return &resetSyncOpts{syncTagOpts{rootOpts: parent}}
+}
+
+func (opts *resetSyncOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reset-sync",
+		Short: "Move the sync tag back to an earlier revision, so everything changed since then is applied again.",
+		Long: `Move the sync tag back to an earlier revision, so everything changed since then is applied again.
+
+This is for recovering from a bad sync. The revision must be in the
+history of the branch that's synced. Once the tag has been moved, a
+sync is started.`,
+		Example: makeExample(
+			"fluxctl reset-sync --revision=3f2c9a1",
+		),
+		RunE: opts.RunE,
+	}
+	opts.addFlags(cmd)
+	return cmd
+}
+
+func (opts *resetSyncOpts) RunE(cmd *cobra.Command, args []string) error {
+	return opts.moveSyncTag(cmd, args, syncTagMove{
+		spec: func(revision string) update.Spec {
+			return update.Spec{Type: update.Reset, Spec: update.ResetSync{Revision: revision}}
+		},
+		warning: "This moves the sync tag to %s, and everything changed since then will be applied again.",
+		refusal: "not resetting the sync tag",
+		failure: "Failed to reset sync tag",
+		done:    "Moved sync tag to %s; a sync has been started.",
+	})
+}
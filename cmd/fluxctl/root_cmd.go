@@ -89,6 +89,16 @@ func (opts *rootOpts) Command() *cobra.Command {
 		newSave(opts).Command(),
 		newIdentity(opts).Command(),
 		newSync(opts).Command(),
+		newResetSync(opts).Command(),
+		newMarkSynced(opts).Command(),
+		newCheckAutomation(opts).Command(),
+		newLogs(opts).Command(),
+		newSyncStatus(opts).Command(),
+		newMergePreview(opts).Command(),
+		newCompare(opts).Command(),
+		newConfig(opts).Command(),
+		newJobs(opts).Command(),
+		newAutomationPreview(opts).Command(),
 	)
 
 	return cmd
@@ -6,12 +6,15 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/weaveworks/flux/api/v6"
 	"github.com/weaveworks/flux/git"
 	"github.com/weaveworks/flux/update"
 )
 
 type syncOpts struct {
 	*rootOpts
+	outputFormat string
+	namespace    string
 }
 
 func newSync(parent *rootOpts) *syncOpts {
@@ -24,6 +27,8 @@ func (opts *syncOpts) Command() *cobra.Command {
 		Short: "synchronize the cluster with the git repository, now",
 		RunE:  opts.RunE,
 	}
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "", "Only apply the resources in this namespace; the sync tag is not moved, and nothing is garbage collected")
+	cmd.Flags().StringVarP(&opts.outputFormat, "output-format", "o", "", "Output format; \"json\" or \"yaml\" print the result of the sync job as data, once the sync is done")
 	return cmd
 }
 
@@ -31,6 +36,9 @@ func (opts *syncOpts) RunE(cmd *cobra.Command, args []string) error {
 	if len(args) > 0 {
 		return errorWantedNoArgs
 	}
+	if err := checkOutputFormat(opts.outputFormat, ""); err != nil {
+		return err
+	}
 
 	ctx := context.Background()
 
@@ -45,14 +53,24 @@ func (opts *syncOpts) RunE(cmd *cobra.Command, args []string) error {
 	case git.RepoReady:
 		break
 	default:
+		if gitConfig.Error != "" {
+			return fmt.Errorf("git repository %s is not ready to sync (status: %s): %s", gitConfig.Remote.URL, string(gitConfig.Status), gitConfig.Error)
+		}
 		return fmt.Errorf("git repository %s is not ready to sync (status: %s)", gitConfig.Remote.URL, string(gitConfig.Status))
 	}
 
+	if gitConfig.Leadership == v6.SyncLeadershipFollower {
+		if opts.namespace != "" {
+			return fmt.Errorf("this fluxd instance is not the leader, so cannot sync namespace %s", opts.namespace)
+		}
+		fmt.Fprintf(cmd.OutOrStderr(), "This fluxd instance is not the leader; the sync will be done by the leader, when it next looks at the git repository\n")
+	}
+
 	fmt.Fprintf(cmd.OutOrStderr(), "Synchronizing with %s\n", gitConfig.Remote.URL)
 
 	updateSpec := update.Spec{
 		Type: update.Sync,
-		Spec: update.ManualSync{},
+		Spec: update.ManualSync{Namespace: opts.namespace},
 	}
 	jobID, err := opts.API.UpdateManifests(ctx, updateSpec)
 	if err != nil {
@@ -66,11 +84,31 @@ func (opts *syncOpts) RunE(cmd *cobra.Command, args []string) error {
 
 	rev := result.Revision[:7]
 	fmt.Fprintf(cmd.OutOrStderr(), "HEAD of %s is %s\n", gitConfig.Remote.Branch, rev)
+	if opts.namespace != "" {
+		// The job does the sync itself, and the sync tag doesn't
+		// move, so there's nothing more to wait for.
+		for id, res := range result.Result {
+			fmt.Fprintf(cmd.OutOrStderr(), "Failed to apply %s: %s\n", id, res.Error)
+		}
+		if isStructuredOutput(opts.outputFormat) {
+			if err := printStructured(cmd.OutOrStdout(), opts.outputFormat, result); err != nil {
+				return err
+			}
+		}
+		if len(result.Result) > 0 {
+			return fmt.Errorf("%d resources in namespace %s failed to apply", len(result.Result), opts.namespace)
+		}
+		fmt.Fprintf(cmd.OutOrStderr(), "Applied resources in namespace %s at %s.\n", opts.namespace, rev)
+		return nil
+	}
 	fmt.Fprintf(cmd.OutOrStderr(), "Waiting for %s to be applied ...\n", rev)
 	err = awaitSync(ctx, opts.API, rev)
 	if err != nil {
 		return err
 	}
 	fmt.Fprintln(cmd.OutOrStderr(), "Done.")
+	if isStructuredOutput(opts.outputFormat) {
+		return printStructured(cmd.OutOrStdout(), opts.outputFormat, result)
+	}
 	return nil
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type syncStatusOpts struct {
+	*rootOpts
+	outputFormat string
+}
+
+func newSyncStatus(parent *rootOpts) *syncStatusOpts {
+	return &syncStatusOpts{rootOpts: parent}
+}
+
+func (opts *syncStatusOpts) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "sync-status",
+		Short:   "Show the git revision each namespace was last synced from, and any that failed to sync.",
+		Example: makeExample("fluxctl sync-status"),
+		RunE:    opts.RunE,
+	}
+	cmd.Flags().StringVarP(&opts.outputFormat, "output-format", "o", "", "Output format; \"json\" or \"yaml\" print the status as data")
+	return cmd
+}
+
+func (opts *syncStatusOpts) RunE(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		return errorWantedNoArgs
+	}
+	if err := checkOutputFormat(opts.outputFormat, ""); err != nil {
+		return err
+	}
+
+	status, err := opts.API.NamespaceSyncStatus(context.Background())
+	if err != nil {
+		return err
+	}
+	if isStructuredOutput(opts.outputFormat) {
+		return printStructured(cmd.OutOrStdout(), opts.outputFormat, status)
+	}
+
+	if status.Revision == "" {
+		fmt.Fprintln(cmd.OutOrStdout(), "Nothing has been synced yet.")
+		return nil
+	}
+	if status.AllSynced {
+		fmt.Fprintf(cmd.OutOrStdout(), "All namespaces synced at %s.\n", abbreviateRevision(status.Revision))
+	} else {
+		fmt.Fprintf(cmd.OutOrStdout(), "Last synced %s; some namespaces are behind.\n", abbreviateRevision(status.Revision))
+	}
+
+	for _, file := range status.SkippedFiles {
+		fmt.Fprintf(cmd.OutOrStdout(), "Skipped %s, since it is too large.\n", file)
+	}
+
+	w := newTabwriter()
+	fmt.Fprintf(w, "NAMESPACE\tSYNCED\tFAILED\tERRORS\n")
+	for _, ns := range status.Namespaces {
+		synced := abbreviateRevision(ns.Revision)
+		if ns.Revision == "" {
+			synced = "<not synced>"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", ns.Namespace, synced, abbreviateRevision(ns.FailedRevision), strings.Join(ns.Errors, "; "))
+	}
+	w.Flush()
+
+	for _, pending := range status.PendingDeletions {
+		fmt.Fprintf(cmd.OutOrStdout(), "%s is no longer in git, and will be deleted after %s.\n", pending.ID, pending.DeleteAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func abbreviateRevision(rev string) string {
+	if len(rev) > 7 {
+		return rev[:7]
+	}
+	return rev
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/weaveworks/flux/update"
+)
+
+// syncTagOpts are the options shared by the commands that move the
+// sync tag, reset-sync and mark-synced.
+type syncTagOpts struct {
+	*rootOpts
+	revision string
+	yes      bool
+	cause    update.Cause
+}
+
+// syncTagMove says how a command moving the sync tag asks for it,
+// and what it tells the user along the way.
+type syncTagMove struct {
+	// spec gives the job spec for moving the tag to the revision
+	spec func(revision string) update.Spec
+	// warning is shown before asking for confirmation; it's given
+	// the revision
+	warning string
+	// refusal is what's not done, if confirmation isn't given
+	refusal string
+	// failure is shown if the job fails
+	failure string
+	// done is shown once the job has finished; it's given the
+	// revision the tag was moved to
+	done string
+}
+
+func (opts *syncTagOpts) addFlags(cmd *cobra.Command) {
+	AddCauseFlags(cmd, &opts.cause)
+	cmd.Flags().StringVar(&opts.revision, "revision", "", "Revision to move the sync tag to")
+	cmd.Flags().BoolVarP(&opts.yes, "yes", "y", false, "Don't ask for confirmation")
+}
+
+// moveSyncTag asks for confirmation (unless told not to), then
+// submits the job for moving the sync tag and waits for it to finish.
+func (opts *syncTagOpts) moveSyncTag(cmd *cobra.Command, args []string, move syncTagMove) error {
+	if len(args) > 0 {
+		return errorWantedNoArgs
+	}
+	if opts.revision == "" {
+		return newUsageError("--revision is required")
+	}
+
+	if !opts.yes {
+		fmt.Fprintf(cmd.OutOrStderr(), move.warning+"\nContinue? [y/N] ", opts.revision)
+		answer, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+		if err != nil && answer == "" {
+			return fmt.Errorf("no confirmation given; %s", move.refusal)
+		}
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return errors.New(move.refusal)
+		}
+	}
+
+	ctx := context.Background()
+	spec := move.spec(opts.revision)
+	spec.Cause = opts.cause
+	jobID, err := opts.API.UpdateManifests(ctx, spec)
+	if err != nil {
+		return err
+	}
+	result, err := awaitJob(ctx, opts.API, jobID)
+	if err != nil {
+		fmt.Fprintf(cmd.OutOrStderr(), move.failure+" (job ID %q)\n", jobID)
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStderr(), move.done+"\n", result.Revision)
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"github.com/weaveworks/flux/api/v12"
+)
+
+// Flags whose values are reported through the API as they are, since
+// they're known not to be secrets. Flags of types that can't hold a
+// secret (numbers, durations and booleans) are reported regardless;
+// the values of any other flags, including those added later and not
+// listed here, are redacted, so that a secret is never reported by
+// mistake.
+var safeFlags = map[string]bool{
+	"listen":                                 true,
+	"listen-metrics":                         true,
+	"kubernetes-kubectl":                     true,
+	"kubernetes-kubectl-version-skew-action": true,
+	"git-branch":                             true,
+	"git-path":                               true,
+	"git-scope":                              true,
+	"git-user":                               true,
+	"git-email":                              true,
+	"git-label":                              true,
+	"git-sync-tag":                           true,
+	"git-sync-ref":                           true,
+	"git-notes-ref":                          true,
+	"git-ci-skip-message":                    true,
+	"git-ssh-certificate":                    true,
+	"git-gpg-key-import":                     true,
+	"git-signing-key":                        true,
+	"git-signing-format":                     true,
+	"git-ssh-allowed-signers":                true,
+	"git-trusted-authors":                    true,
+	"git-trusted-authors-file":               true,
+	"git-trusted-authors-check":              true,
+	"sync-garbage-collection-selector":       true,
+	"sync-garbage-collection-kinds":          true,
+	"sync-stuck-deletion-action":             true,
+	"sync-remove-finalizers-kinds":           true,
+	"sync-batch-ignore-fields":               true,
+	"sync-keep-status-kinds":                 true,
+	"sync-injected-containers":               true,
+	"sync-injected-volumes":                  true,
+	"sync-missing-namespaces":                true,
+	"sync-leader-election-configmap":         true,
+	"manifest-duplicates":                    true,
+	"manifest-rewrite-api-version":           true,
+	"sync-health-scope":                      true,
+	"sync-health-namespaces":                 true,
+	"sync-verify-failure-action":             true,
+	"sync-wave-timeout-kinds":                true,
+	"sync-wave-timeout-action":               true,
+	"sync-readiness-checks":                  true,
+	"sync-field-manager":                     true,
+	"sync-field-manager-paths":               true,
+	"sync-apply-batch-by":                    true,
+	"sync-force-apply-kinds":                 true,
+	"sync-recreate-kinds":                    true,
+	"sync-bootstrap":                         true,
+	"sync-bootstrap-kinds":                   true,
+	"sync-self-workload":                     true,
+	"sync-validation-kubeconfig":             true,
+	"sync-validation-failure-action":         true,
+	"notify-format":                          true,
+	"notify-template":                        true,
+	"audit-log-file":                         true,
+	"job-history-file":                       true,
+	"jsonnet-path":                           true,
+	"jsonnet-import-path":                    true,
+	"jsonnet-values-configmap":               true,
+	"cue-package":                            true,
+	"cue-path":                               true,
+	"cue-expression":                         true,
+	"validate-policy-namespace":              true,
+	"validate-conftest-path":                 true,
+	"sops-path":                              true,
+	"sops-file-pattern":                      true,
+	"sops-age-key-file":                      true,
+	"vault-token-file":                       true,
+	"vault-allow-path":                       true,
+	"vault-ca-cert":                          true,
+	"vault-client-cert":                      true,
+	"vault-client-key":                       true,
+	"registry-cache-backend":                 true,
+	"memcached-hostname":                     true,
+	"memcached-service":                      true,
+	"redis-address":                          true,
+	"registry-host-concurrency":              true,
+	"registry-insecure-host":                 true,
+	"registry-exclude-image":                 true,
+	"registry-signature-key":                 true,
+	"registry-signature-identity":            true,
+	"registry-cosign-path":                   true,
+	"registry-ecr-region":                    true,
+	"registry-ecr-include-id":                true,
+	"registry-ecr-exclude-id":                true,
+	"k8s-secret-name":                        true,
+	"k8s-secret-volume-mount-path":           true,
+	"k8s-secret-data-key":                    true,
+	"k8s-namespace-whitelist":                true,
+	"k8s-allow-namespace":                    true,
+	"k8s-kubeconfig":                         true,
+	"k8s-user-agent-suffix":                  true,
+	"k8s-ssh-tunnel":                         true,
+	"k8s-ssh-tunnel-key":                     true,
+	"k8s-ssh-tunnel-known-hosts":             true,
+	"ssh-keygen-dir":                         true,
+	"ssh-keygen-type":                        true,
+	"docker-config":                          true,
+}
+
+// Flags that are URLs, which are reported with any user and password
+// in them taken out.
+var urlFlags = map[string]bool{
+	"git-url":              true,
+	"git-lfs-url":          true,
+	"sync-verify-url":      true,
+	"validate-policy":      true,
+	"vault-addr":           true,
+	"notify-commit-url":    true,
+	"notify-dashboard-url": true,
+	"connect":              true,
+}
+
+// Types of flag whose values can't be secrets.
+var safeTypes = map[string]bool{
+	"bool":          true,
+	"int":           true,
+	"int32":         true,
+	"int64":         true,
+	"uint":          true,
+	"uint64":        true,
+	"float32":       true,
+	"float64":       true,
+	"duration":      true,
+	"boolSlice":     true,
+	"intSlice":      true,
+	"durationSlice": true,
+}
+
+const redacted = "redacted"
+
+// daemonConfig gives the configuration in the flags given, for
+// reporting through the API, with secrets redacted.
+func daemonConfig(fs *pflag.FlagSet, version string) v12.DaemonConfig {
+	config := v12.DaemonConfig{Version: version}
+	fs.VisitAll(func(f *pflag.Flag) {
+		// Deprecated flags are shown only if given, since they may
+		// still have effect
+		if f.Hidden || (f.Deprecated != "" && !f.Changed) {
+			return
+		}
+		flag := v12.ConfigFlag{Name: f.Name, Explicit: f.Changed}
+		flag.Values, flag.Repeated = flagValues(f)
+		for i, v := range flag.Values {
+			if v == "" {
+				continue
+			}
+			switch {
+			case safeFlags[f.Name] || safeTypes[f.Value.Type()]:
+			case urlFlags[f.Name]:
+				if safe, ok := redactUserinfo(v); ok {
+					flag.Values[i], flag.Redacted = safe, true
+				}
+			default:
+				flag.Values[i], flag.Redacted = redacted, true
+			}
+		}
+		config.Flags = append(config.Flags, flag)
+	})
+	return config
+}
+
+// flagValues gives each value of the flag, as it would be given on
+// the command line, and whether the flag can be given more than once.
+func flagValues(f *pflag.Flag) ([]string, bool) {
+	typ := f.Value.Type()
+	if !strings.HasSuffix(typ, "Slice") && !strings.HasSuffix(typ, "Array") {
+		return []string{f.Value.String()}, false
+	}
+	// These are shown as [a,b], with each value quoted as for CSV
+	// if need be
+	list := strings.TrimSuffix(strings.TrimPrefix(f.Value.String(), "["), "]")
+	if list == "" {
+		return nil, true
+	}
+	values, err := csv.NewReader(strings.NewReader(list)).Read()
+	if err != nil {
+		return []string{list}, true
+	}
+	return values, true
+}
+
+// redactUserinfo takes the user and password out of the URL given,
+// reporting whether there were any.
+func redactUserinfo(s string) (string, bool) {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s, false
+	}
+	// A username alone may be a token (e.g.,
+	// https://<token>@github.com/...), so it goes too
+	u.User = url.User(redacted)
+	return u.String(), true
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -19,15 +22,23 @@ import (
 	"github.com/spf13/pflag"
 	crd "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 	k8sruntime "k8s.io/apimachinery/pkg/util/runtime"
 	k8sclientdynamic "k8s.io/client-go/dynamic"
 	k8sclient "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/api/v12"
+	"github.com/weaveworks/flux/audit"
 	"github.com/weaveworks/flux/checkpoint"
 	"github.com/weaveworks/flux/cluster"
 	"github.com/weaveworks/flux/cluster/kubernetes"
+	kresource "github.com/weaveworks/flux/cluster/kubernetes/resource"
 	"github.com/weaveworks/flux/daemon"
+	"github.com/weaveworks/flux/event"
+	"github.com/weaveworks/flux/event/notify"
 	"github.com/weaveworks/flux/git"
 	"github.com/weaveworks/flux/gpg"
 	transport "github.com/weaveworks/flux/http"
@@ -36,12 +47,17 @@ import (
 	"github.com/weaveworks/flux/image"
 	integrations "github.com/weaveworks/flux/integrations/client/clientset/versioned"
 	"github.com/weaveworks/flux/job"
+	"github.com/weaveworks/flux/logging"
 	"github.com/weaveworks/flux/registry"
 	"github.com/weaveworks/flux/registry/cache"
 	registryMemcache "github.com/weaveworks/flux/registry/cache/memcached"
+	registryRedis "github.com/weaveworks/flux/registry/cache/redis"
 	registryMiddleware "github.com/weaveworks/flux/registry/middleware"
 	"github.com/weaveworks/flux/remote"
+	"github.com/weaveworks/flux/signature"
 	"github.com/weaveworks/flux/ssh"
+	fluxsync "github.com/weaveworks/flux/sync"
+	"github.com/weaveworks/flux/verify"
 )
 
 var version = "unversioned"
@@ -67,6 +83,77 @@ func optionalVar(fs *pflag.FlagSet, value ssh.OptionalValue, name, usage string)
 	return value
 }
 
+// pathsOverlap says whether one of two paths within a repo is the
+// same as, or contains, the other.
+func pathsOverlap(a, b string) bool {
+	a, b = filepath.Clean(a)+"/", filepath.Clean(b)+"/"
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+func flatten(paths map[string][]string) []string {
+	var all []string
+	for _, ps := range paths {
+		all = append(all, ps...)
+	}
+	return all
+}
+
+// makeClusterClient connects to the Kubernetes API server with the
+// config given, with the clients the cluster needs.
+func makeClusterClient(config *rest.Config, shutdown <-chan struct{}) (kubernetes.ExtendedClient, error) {
+	config.QPS = 50.0
+	config.Burst = 100
+	clientset, err := k8sclient.NewForConfig(config)
+	if err != nil {
+		return kubernetes.ExtendedClient{}, err
+	}
+	dynamicClientset, err := k8sclientdynamic.NewForConfig(config)
+	if err != nil {
+		return kubernetes.ExtendedClient{}, err
+	}
+	integrationsClientset, err := integrations.NewForConfig(config)
+	if err != nil {
+		return kubernetes.ExtendedClient{}, err
+	}
+	crdClient, err := crd.NewForConfig(config)
+	if err != nil {
+		return kubernetes.ExtendedClient{}, err
+	}
+	discoClientset := kubernetes.MakeCachedDiscovery(clientset.Discovery(), crdClient, shutdown)
+	return kubernetes.MakeClusterClientset(clientset, dynamicClientset, integrationsClientset, discoClientset), nil
+}
+
+// How long before an SSH certificate expires to start warning.
+const sshCertificateWarnBefore = 24 * time.Hour
+
+// watchSSHCertificate checks the SSH certificate at the path given
+// every hour, logging a warning if it's about to expire and an error
+// if it has. The certificate is read afresh each time, so a renewed
+// certificate is noticed.
+func watchSSHCertificate(path string, logger log.Logger, shutdown <-chan struct{}, done *sync.WaitGroup) {
+	defer done.Done()
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		cert, err := ssh.ReadCertificate(path)
+		switch now := time.Now(); {
+		case err != nil:
+			logger.Log("err", err)
+		case cert.Expired(now):
+			logger.Log("err", "SSH certificate has expired; git operations will fail until it is renewed", "path", path, "key-id", cert.KeyID, "expired", cert.ValidBefore)
+		case cert.NotYetValid(now):
+			logger.Log("err", "SSH certificate is not valid yet", "path", path, "key-id", cert.KeyID, "valid-after", cert.ValidAfter)
+		case !cert.ValidBefore.IsZero() && cert.ValidBefore.Sub(now) < sshCertificateWarnBefore:
+			logger.Log("warning", "SSH certificate expires soon", "path", path, "key-id", cert.KeyID, "expires", cert.ValidBefore)
+		}
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func main() {
 	// Flag domain.
 	fs := pflag.NewFlagSet("default", pflag.ContinueOnError)
@@ -81,44 +168,197 @@ func main() {
 	var (
 		listenAddr        = fs.StringP("listen", "l", ":3030", "listen address where /metrics and API will be served")
 		listenMetricsAddr = fs.String("listen-metrics", "", "listen address for /metrics endpoint")
+		heartbeatInterval = fs.Duration("metrics-heartbeat-interval", 0, "if non-zero, update the heartbeat metrics at least this often, even when idle, so that alerts can tell an idle daemon from a stuck one")
 		kubernetesKubectl = fs.String("kubernetes-kubectl", "", "optional, explicit path to kubectl tool")
+		kubectlMaxSkew    = fs.Int("kubernetes-kubectl-max-version-skew", 1, "the most minor versions kubectl may be ahead of or behind the API server before --kubernetes-kubectl-version-skew-action is taken")
+		kubectlSkewAction = fs.String("kubernetes-kubectl-version-skew-action", "warn", `what to do when kubectl's version is too far from the API server's: "warn", or "refuse" to start`)
 		versionFlag       = fs.Bool("version", false, "get version number")
+		logCompactRepeats = fs.Int("log-compact-repeats", 0, "if greater than zero, collapse identical consecutive informational log lines, logging the line with a count once this many repeats have been held back")
+		logCompactPeriod  = fs.Duration("log-compact-interval", time.Hour, "with --log-compact-repeats, log a collapsed line with its count of repeats at least this often")
 		// Git repo & key etc.
 		gitURL       = fs.String("git-url", "", "URL of git repo with Kubernetes manifests; e.g., git@github.com:weaveworks/flux-get-started")
 		gitBranch    = fs.String("git-branch", "master", "branch of git repo to use for Kubernetes manifests")
 		gitPath      = fs.StringSlice("git-path", []string{}, "relative paths within the git repo to locate Kubernetes manifests")
+		gitLayers    = fs.Bool("git-path-layers", false, "treat the --git-path values as layers, in the order given: a resource defined in more than one is the result of merging each definition over those before it")
+		gitScopes    = fs.StringSlice("git-scope", []string{}, "sync the given path in the git repo separately, with its own sync tag; given as <name>=<path>, and may be repeated, including with the same name to give a scope several paths")
 		gitUser      = fs.String("git-user", "Weave Flux", "username to use as git committer")
 		gitEmail     = fs.String("git-email", "support@weave.works", "email to use as git committer")
 		gitSetAuthor = fs.Bool("git-set-author", false, "if set, the author of git commits will reflect the user who initiated the commit and will differ from the git committer.")
 		gitLabel     = fs.String("git-label", "", "label to keep track of sync progress; overrides both --git-sync-tag and --git-notes-ref")
 		// Old git config; still used if --git-label is not supplied, but --git-label is preferred.
 		gitSyncTag     = fs.String("git-sync-tag", defaultGitSyncTag, "tag to use to mark sync progress for this cluster")
+		gitSyncRef     = fs.String("git-sync-ref", "", "full ref (e.g., refs/flux/prod/sync) to which the sync tag is written, rather than refs/tags/<git-sync-tag>; useful for keeping the tags of many daemons out of the way")
 		gitNotesRef    = fs.String("git-notes-ref", defaultGitNotesRef, "ref to use for keeping commit annotations in git notes")
 		gitSkip        = fs.Bool("git-ci-skip", false, `append "[ci skip]" to commit messages so that CI will skip builds`)
 		gitSkipMessage = fs.String("git-ci-skip-message", "", "additional text for commit messages, useful for skipping builds in CI. Use this to supply specific text, or set --git-ci-skip")
 
 		gitPollInterval = fs.Duration("git-poll-interval", 5*time.Minute, "period at which to poll git repo for new commits")
 		gitTimeout      = fs.Duration("git-timeout", 20*time.Second, "duration after which git operations time out")
+		gitSyncTagEvery = fs.Int("git-sync-tag-every", 1, "move the sync tag only after every this many syncs of a new revision, rather than after each, so it moves less often; in between, the revision synced is kept in a ref of its own, and the tag moved to it when fluxd shuts down")
+		gitCloneTimeout = fs.Duration("git-clone-timeout", 0, "if non-zero, how long to keep trying to clone the git repo when starting, retrying with backoff, before giving up and exiting; if zero, keep trying")
+
+		gitLFS    = fs.Bool("git-lfs", false, "fetch the objects of files stored with git LFS, so manifests kept in LFS are synced rather than their pointers; needs git-lfs installed")
+		gitLFSURL = fs.String("git-lfs-url", "", "with --git-lfs, the URL of the LFS server to fetch objects from, if it's not the one git LFS works out from --git-url")
 
-		// GPG commit signing
-		gitImportGPG  = fs.String("git-gpg-key-import", "", "keys at the path given (either a file or a directory) will be imported for use in signing commits")
-		gitSigningKey = fs.String("git-signing-key", "", "if set, commits will be signed with this GPG key")
+		gitSSHCertificate = fs.String("git-ssh-certificate", "", "path to an SSH certificate, signed by a certificate authority the git server trusts, to present alongside the SSH key; it's read for each git operation, so can be renewed in place (e.g., by updating a mounted Secret)")
+
+		gitRefuseForcePush = fs.Bool("git-refuse-force-push", false, "refuse to sync, rather than follow, when the branch HEAD is not a descendant of the last synced revision (e.g., because the branch was force-pushed)")
+
+		// commit signing
+		gitImportGPG         = fs.String("git-gpg-key-import", "", "keys at the path given (either a file or a directory) will be imported for use in signing commits")
+		gitSigningKey        = fs.String("git-signing-key", "", "if set, commits will be signed with this GPG key, or with --git-signing-format=ssh, the SSH key at this path")
+		gitSigningFormat     = fs.String("git-signing-format", "openpgp", `how to sign commits: "openpgp" (with GPG) or "ssh" (needs git 2.34 or later)`)
+		gitSSHAllowedSigners = fs.String("git-ssh-allowed-signers", "", "path to a file listing the SSH keys allowed to sign commits and tags, for verifying SSH signatures")
+		gitVerifySignatures  = fs.Bool("git-verify-signatures", false, "refuse to sync, unless every commit since the last synced revision (or the branch HEAD, on the first sync) has a valid signature")
+
+		// commit authors
+		gitTrustedAuthors      = fs.StringSlice("git-trusted-authors", nil, "refuse to sync, unless the commit at the branch HEAD is by an author whose email address matches one of these patterns (e.g., '*@example.com')")
+		gitTrustedAuthorsFile  = fs.String("git-trusted-authors-file", "", "path to a file of more trusted author patterns, one per line; it's read again at each sync, so it can be changed without restarting fluxd")
+		gitTrustedAuthorsCheck = fs.String("git-trusted-authors-check", daemon.TrustedAuthorsCheckAuthor, `which email address of the HEAD commit has to be trusted: "author", "committer", or "both"`)
 
 		// syncing
-		syncInterval = fs.Duration("sync-interval", 5*time.Minute, "apply config in git to cluster at least this often, even if there are no new commits")
-		syncGC       = fs.Bool("sync-garbage-collection", false, "experimental; delete resources that were created by fluxd, but are no longer in the git repo")
+		syncInterval            = fs.Duration("sync-interval", 5*time.Minute, "apply config in git to cluster at least this often, even if there are no new commits")
+		syncGC                  = fs.Bool("sync-garbage-collection", false, "experimental; delete resources that were created by fluxd, but are no longer in the git repo")
+		syncGCGracePeriod       = fs.Duration("sync-garbage-collection-grace-period", 0, "with --sync-garbage-collection, only delete resources that have been missing from the git repo for at least this long, so that resources briefly removed and then restored are not deleted")
+		syncGCSelector          = fs.String("sync-garbage-collection-selector", "", "with --sync-garbage-collection, only delete resources matching this label selector (e.g., 'app.kubernetes.io/managed-by=flux'), as well as having been created by fluxd")
+		syncGCKinds             = fs.StringSlice("sync-garbage-collection-kinds", nil, "with --sync-garbage-collection, only delete resources of these kinds, given as <group>/<version>/<kind> (e.g., 'apps/v1/Deployment', or 'core/v1/ConfigMap' for the core group); other kinds are not even looked at")
+		syncStuckTimeout        = fs.Duration("sync-stuck-deletion-timeout", kubernetes.DefaultStuckDeletionTimeout, "with --sync-garbage-collection, how long a resource being deleted may wait on its finalizers before its deletion is considered stuck")
+		syncStuckAction         = fs.String("sync-stuck-deletion-action", kubernetes.StuckDeletionWait, `what to do with stuck deletions: "wait", reporting the resource as failing to sync until it goes away, or "skip" it and carry on`)
+		syncRemoveFinalizers    = fs.StringSlice("sync-remove-finalizers-kinds", nil, "dangerous; kinds of resource (e.g., configmap) whose finalizers are removed once their deletion is stuck, so it can complete. This skips whatever clean-up the finalizers are for")
+		syncBatchIgnore         = fs.StringSlice("sync-batch-ignore-fields", kubernetes.DefaultBatchIgnoreFields, "fields of Jobs and CronJobs (as dot-separated paths) to disregard when deciding whether they have changed and need to be applied again")
+		syncKeepStatusKinds     = fs.StringSlice("sync-keep-status-kinds", nil, "kinds of resource whose status field is applied as given in their manifests; for other kinds, the status is removed before applying, since it belongs to the resource's controller")
+		syncInjectedContainers  = fs.StringSlice("sync-injected-containers", nil, "glob patterns for the names of containers injected into workloads (e.g., by a service mesh's mutating webhook, as with 'istio-*' or 'linkerd-*'); these are not reported as drift when they're not in a workload's manifest")
+		syncInjectedVolumes     = fs.StringSlice("sync-injected-volumes", nil, "glob patterns for the names of volumes injected into workloads; these, and their mounts, are not reported as drift when they're not in a workload's manifest")
+		syncMissingNamespaces   = fs.String("sync-missing-namespaces", kubernetes.MissingNamespacesApply, "what to do with resources in namespaces that don't exist and aren't defined in the manifests: 'apply' them anyway (so they fail), 'fail' them without applying, listing the missing namespaces, or 'create' the namespaces first")
+		syncLeaderElection      = fs.Bool("sync-leader-election", false, "when running several replicas of fluxd, elect a leader so that only one at a time syncs")
+		syncLeaderConfigMap     = fs.String("sync-leader-election-configmap", "flux-leader", "name of the ConfigMap, in fluxd's namespace, used to record which replica is the leader")
+		syncLeaderLeaseDuration = fs.Duration("sync-leader-election-lease-duration", kubernetes.DefaultLeaseDuration, "how long the leader's lease lasts without being renewed; another replica may take over once it has expired")
+		syncSkipUnchanged       = fs.Bool("sync-skip-unchanged", false, "when a sync is due only because the sync interval elapsed, don't apply the manifests if they are unchanged since the last successful sync; changes made directly to the cluster will then only be reverted by syncs that are otherwise triggered")
+		manifestDuplicates      = fs.String("manifest-duplicates", kresource.DuplicatesFail, `what to do when more than one manifest defines the same resource: "fail", refusing to load the manifests, or use the "first" or "last" definition, in order of file path then position in the file`)
+		manifestAPIVersions     = fs.StringSlice("manifest-rewrite-api-version", nil, "rewrite the API version of manifests before applying them (but not in the files), given as [<kind>:]<from>=<to>, e.g., Deployment:extensions/v1beta1=apps/v1; may be given more than once, and each rewrite is logged")
+		manifestMaxFileSize     = fs.Int64("manifest-max-file-size", 0, "if non-zero, manifest files larger than this many bytes are not loaded; they are reported, and left out of syncs, which then don't garbage collect anything")
+		syncAllowEmpty          = fs.Bool("sync-allow-empty", false, "sync even when no manifests are found, though the last sync found some; otherwise, the sync is refused as a likely misconfiguration, since it could garbage collect everything")
+		syncHealthTimeout       = fs.Duration("sync-health-timeout", 0, "if non-zero, after applying, wait up to this long for workloads to be ready before moving the sync tag; if they aren't by then, the sync fails and the tag stays where it was")
+		syncHealthScope         = fs.String("sync-health-scope", daemon.SyncHealthScopeAll, `with --sync-health-timeout, which workloads to wait for: "all" those in the manifests, or only those "changed" since the last sync`)
+		syncHealthNamespaces    = fs.StringSlice("sync-health-namespaces", nil, "with --sync-health-timeout, only wait for workloads in these namespaces")
+		syncIsolateNamespaces   = fs.Bool("sync-isolate-namespaces", false, "with --sync-health-timeout, when workloads aren't ready in time, report their namespaces as failing to sync rather than failing the whole sync, so other namespaces' syncs aren't held back")
+		syncVerifyURL           = fs.String("sync-verify-url", "", "if set, after syncing a new revision, post it to this URL and wait for it to be verified (e.g., by smoke tests) before moving the sync tag; see the docs for the protocol")
+		syncVerifyTimeout       = fs.Duration("sync-verify-timeout", 10*time.Minute, "with --sync-verify-url, how long to wait for verification to finish")
+		syncVerifyAction        = fs.String("sync-verify-failure-action", verify.FailureBlock, `with --sync-verify-url, what to do when verification fails or times out: "block", failing the sync so the sync tag isn't moved, or "proceed" to move it anyway`)
+		syncWaveTimeout         = fs.Duration("sync-wave-timeout", 5*time.Minute, "how long to wait for the resources in each wave (given with the annotation flux.weave.works/sync-wave) to be ready, before giving up on applying the waves after it")
+		syncWaveTimeoutKinds    = fs.StringSlice("sync-wave-timeout-kinds", nil, "readiness timeouts for particular kinds of resource in waves, as kind=duration (e.g., job=30m), overriding --sync-wave-timeout; a resource can also be annotated with its own, e.g., flux.weave.works/sync-wave-timeout: 10m")
+		syncWaveTimeoutAction   = fs.String("sync-wave-timeout-action", kubernetes.WaveTimeoutFail, `what to do when a resource in a wave isn't ready within its timeout: "fail", not applying the waves after it, or "proceed" to apply them anyway, reporting the resource as failing to sync`)
+		syncReadinessChecks     = fs.StringSlice("sync-readiness-checks", nil, "how to tell resources of particular kinds are ready, in waves, as kind[.group]=check (e.g., certificate.cert-manager.io=condition=Ready), where check is like the argument to kubectl wait --for: condition=<type>[=<status>] or jsonpath={<path>}[=<value>], joined with && to require several; these take precedence over the built-in checks")
+		syncCRDTimeout          = fs.Duration("sync-crd-established-timeout", time.Minute, "how long to wait for CRDs to be established before applying the custom resources they define in the same sync; those whose CRD isn't established by then are reported as failing to sync")
+		syncServerDryRun        = fs.Bool("sync-server-dry-run", false, "check each resource with a server-side dry run before applying it, and only apply those that pass; the others are reported as failing to sync. Needs kubectl 1.12 or later")
+		syncServerSideApply     = fs.Bool("sync-server-side-apply", false, "apply resources with server-side apply, so the API server tracks which field manager owns each field and reports conflicts. Needs kubectl 1.18 or later")
+		syncFieldManager        = fs.String("sync-field-manager", kubernetes.DefaultFieldManager, "with --sync-server-side-apply, the field manager to apply resources as, when neither an annotation flux.weave.works/field-manager nor a --sync-field-manager-paths rule gives one")
+		syncFieldManagerPaths   = fs.StringSlice("sync-field-manager-paths", nil, "with --sync-server-side-apply, rules giving the field manager to apply resources as by their file, as <path>=<manager>, where path is a directory in the repo or a glob pattern (e.g., teams/payments=payments-team); the first that matches is used")
+		syncApplyBatchSize      = fs.Int("sync-apply-batch-size", 0, "if non-zero, apply resources in batches of at most this many with the same API group (or kind; see --sync-apply-batch-by), pausing for --sync-apply-batch-delay between batches, so as not to overwhelm controllers that react to each resource")
+		syncApplyBatchBy        = fs.String("sync-apply-batch-by", kubernetes.ApplyBatchByGroup, `with --sync-apply-batch-size, whether to batch resources by API "group" or by "kind"`)
+		syncApplyBatchDelay     = fs.Duration("sync-apply-batch-delay", 5*time.Second, "with --sync-apply-batch-size, how long to pause between batches")
+		syncApplyRetries        = fs.Int("sync-apply-retries", kubernetes.DefaultApplyRetries, "how many times to try applying a resource again, within the same sync, when it fails because of a conflicting change (HTTP 409); resources still failing after that are retried at the next sync")
+		syncForceApplyKinds     = fs.StringSlice("sync-force-apply-kinds", nil, "kinds of resource (e.g., a custom resource kind whose operator also changes it) to apply with kubectl apply --force, deleting and creating them again if patching keeps conflicting; resources of kinds holding state must also be annotated flux.weave.works/recreate: \"true\"")
+		syncRecreateKinds       = fs.StringSlice("sync-recreate-kinds", nil, "kinds of resource (e.g., service,job) to delete and create again when a change can't be applied because it touches an immutable field; resources of kinds holding state (e.g., statefulset) must also be annotated flux.weave.works/recreate: \"true\"")
+		syncEvents              = fs.Bool("sync-events", false, "emit Kubernetes events on synced resources, saying whether they were applied and from which revision, so they show up in kubectl describe")
+		syncEventsRate          = fs.Float32("sync-events-rate", kubernetes.DefaultEventsPerSecond, "with --sync-events, the average number of events per second to emit about each resource; events beyond this rate are dropped")
+		syncEventsBurst         = fs.Int("sync-events-burst", kubernetes.DefaultEventsBurst, "with --sync-events, the number of events about each resource that may be emitted at once, above the average rate")
+		driftReportInterval     = fs.Duration("drift-report-interval", 0, "if non-zero, compare the resources at the last synced revision with the cluster this often, without applying anything, and report those that differ as a drift event (e.g., to --notify-url)")
+		syncIncremental         = fs.Bool("sync-incremental", false, "only apply the resources in files changed since the last synced revision, along with any missing from the cluster or that failed to sync; all resources are still applied every --sync-full-interval")
+		syncFullInterval        = fs.Duration("sync-full-interval", time.Hour, "with --sync-incremental, apply all resources at least this often, to revert changes made directly to the cluster")
+		syncBootstrap           = fs.String("sync-bootstrap", daemon.SyncBootstrapNever, `when to bootstrap the cluster, applying the kinds in --sync-bootstrap-kinds first, each in a wave of its own, and waiting at least --sync-bootstrap-timeout for resources to be ready, until a full sync succeeds: "never", "auto" when no revision has been synced yet (i.e., there's no sync tag), or "always" each time fluxd starts`)
+		syncBootstrapKinds      = fs.StringSlice("sync-bootstrap-kinds", kubernetes.DefaultBootstrapKinds, "kinds of resource to apply first when bootstrapping the cluster, in the order given")
+		syncSelfWorkload        = fs.String("sync-self-workload", "", "fluxd's own workload, as [<namespace>:]<kind>/<name> (e.g., deployment/flux, in fluxd's namespace if none is given); if given, it's applied after everything else in a sync, only if it passes a dry run, and waited on to be ready for --sync-self-timeout")
+		syncSelfTimeout         = fs.Duration("sync-self-timeout", 5*time.Minute, "with --sync-self-workload, how long to wait for fluxd's own workload to be ready once applied, before reporting it as failing to sync")
+		syncBootstrapTimeout    = fs.Duration("sync-bootstrap-timeout", 15*time.Minute, "when bootstrapping the cluster, the least time to wait for resources in each wave to be ready, and for CRDs to be established")
+
+		// notifications
+		notifyURL          = fs.String("notify-url", "", "if set, post notifications of events (e.g., syncs and releases) to this webhook URL, e.g., a Slack incoming webhook")
+		notifyFormat       = fs.String("notify-format", string(notify.FormatText), `format of notifications: "text" or "slack-blocks"`)
+		notifyTemplates    = fs.StringSlice("notify-template", nil, "use the Go template in the file given to render notifications of an event type, given as <event type>=<path>, e.g., sync=/etc/fluxd/notify/sync.tmpl")
+		notifyCommitURL    = fs.String("notify-commit-url", "", "prefix for links to commits in notifications, e.g., https://github.com/org/repo/commit/")
+		notifyDashboardURL = fs.String("notify-dashboard-url", "", "URL of a dashboard, made available to notification templates")
+		notifySyncRecovery = fs.Bool("notify-sync-recovery", true, "send a sync_recovered event, once, when syncing succeeds after failing, saying how long it was failing for")
+
+		// auditing
+		auditFile       = fs.String("audit-log-file", "", "if set, append a record (as a line of JSON) to this file each time a lock, unlock, automate, deautomate, policy change or release is requested through the API, and again with its outcome")
+		auditWebhookURL = fs.String("audit-webhook-url", "", "if set, post each audit record, as JSON, to this URL")
+		auditEvents     = fs.Bool("audit-k8s-events", false, "emit each audit record as a Kubernetes event on the workloads concerned")
+
+		// job history
+		jobHistorySize = fs.Int("job-history-size", 100, "the number of most recent jobs to keep a record of, to be listed with fluxctl jobs --history; zero to keep none")
+		jobHistoryFile = fs.String("job-history-file", "", "if set, keep the job history in this file, so it outlives a restart of fluxd")
+
+		// evaluating jsonnet
+		jsonnetEnable             = fs.Bool("jsonnet", false, "evaluate .jsonnet files in the git repo into manifests (.libsonnet files are only imported)")
+		jsonnetExe                = fs.String("jsonnet-path", "", "optional, explicit path to the jsonnet tool")
+		jsonnetImportPaths        = fs.StringSlice("jsonnet-import-path", nil, "directories, relative to the git repo, in which jsonnet looks for imports")
+		jsonnetTLAStrs            = fs.StringSlice("jsonnet-tla-str", nil, "top-level arguments to give every .jsonnet file, as <name>=<value>")
+		jsonnetValuesConfigMap    = fs.String("jsonnet-values-configmap", "", "a ConfigMap in the cluster, as <namespace>/<name>, whose data is given to every .jsonnet file as the external variable 'values'; the manifests are applied again when it changes")
+		jsonnetValuesPollInterval = fs.Duration("jsonnet-values-poll-interval", kubernetes.DefaultValuesPollInterval, "how often to check the --jsonnet-values-configmap for changes")
+
+		// evaluating CUE
+		cuePackages   = fs.StringSlice("cue-package", nil, "directories, relative to the git repo, of CUE packages to evaluate into manifests, as well as those in --git-path")
+		cueExe        = fs.String("cue-path", "", "optional, explicit path to the cue tool")
+		cueTags       = fs.StringSlice("cue-tag", nil, "tags to give every CUE package, as <name>=<value> (as with cue export -t)")
+		cueExpression = fs.String("cue-expression", "", "if set, the expression in each CUE package that gives the manifests (as with cue export -e); otherwise, each package must evaluate to a manifest or a list of manifests")
+
+		// validating manifests against policies
+		validatePolicies   = fs.StringSlice("validate-policy", nil, "check manifests with conftest against the Rego policies in these files or directories (or bundles at these URLs) before applying them, and refuse to sync if any are denied")
+		validateNamespaces = fs.StringSlice("validate-policy-namespace", nil, "only check the policies in these Rego packages; if empty, policies in any package are checked")
+		conftestExe        = fs.String("validate-conftest-path", "", "optional, explicit path to the conftest tool")
+
+		// syncing to a validation cluster first
+		syncValidationKubeconfig = fs.String("sync-validation-kubeconfig", "", "if set, sync to the (e.g., staging) cluster in this kubeconfig file before this cluster, and only sync to this cluster if that succeeds")
+		syncValidationAction     = fs.String("sync-validation-failure-action", fluxsync.ValidationBlock, `what to do when syncing to the validation cluster fails: "block", not syncing to this cluster, or "proceed" to sync to it anyway`)
+
+		// decrypting manifests before applying them
+		sopsDecrypt      = fs.Bool("sops-decrypt", false, "decrypt manifests encrypted with sops before applying them")
+		sopsExe          = fs.String("sops-path", "", "optional, explicit path to the sops tool")
+		sopsFilePatterns = fs.StringSlice("sops-file-pattern", nil, "only decrypt files whose path in the git repo matches one of these glob patterns; if empty, any manifest carrying sops metadata is decrypted")
+		sopsAgeKeyFile   = fs.String("sops-age-key-file", "", "path to an age key file for sops to decrypt with; KMS and PGP keys are found by sops from the environment and GPG keyring as usual")
+
+		// resolving secret values kept in Vault before applying them
+		vaultAddr       = fs.String("vault-addr", "", "if set, the address of a Vault server (e.g., https://vault:8200) to fetch values from, for Secrets with values given as vault://<path>#<key>, just before they are applied")
+		vaultTokenFile  = fs.String("vault-token-file", "", "with --vault-addr, a file to read the Vault token from, each time it's needed; if not given, the token is taken from the environment variable VAULT_TOKEN")
+		vaultAllowPaths = fs.StringSlice("vault-allow-path", nil, "with --vault-addr, a path prefix in Vault that Secrets in a namespace may refer to, as <namespace>=<path prefix>, or *=<path prefix> for every namespace; references to other paths aren't resolved. Give at least one")
+		vaultCACert     = fs.String("vault-ca-cert", "", "with --vault-addr, a file of PEM-encoded CA certificates to trust for the Vault server, in place of the system's")
+		vaultClientCert = fs.String("vault-client-cert", "", "with --vault-addr, a PEM-encoded client certificate to present to the Vault server, with --vault-client-key")
+		vaultClientKey  = fs.String("vault-client-key", "", "with --vault-addr, the PEM-encoded key of --vault-client-cert")
 
 		// registry
+		registryCacheBackend = fs.String("registry-cache-backend", "memcached", "key-value store used for caching image metadata; one of 'memcached' or 'redis'")
+
 		memcachedHostname = fs.String("memcached-hostname", "memcached", "hostname for memcached service.")
 		memcachedTimeout  = fs.Duration("memcached-timeout", time.Second, "maximum time to wait before giving up on memcached requests.")
 		memcachedService  = fs.String("memcached-service", "memcached", "SRV service used to discover memcache servers.")
 
-		registryPollInterval = fs.Duration("registry-poll-interval", 5*time.Minute, "period at which to check for updated images")
-		registryRPS          = fs.Float64("registry-rps", 50, "maximum registry requests per second per host")
-		registryBurst        = fs.Int("registry-burst", defaultRemoteConnections, "maximum number of warmer connections to remote and memcache")
-		registryTrace        = fs.Bool("registry-trace", false, "output trace of image registry requests to log")
-		registryInsecure     = fs.StringSlice("registry-insecure-host", []string{}, "let these registry hosts skip TLS host verification and fall back to using HTTP instead of HTTPS; this allows man-in-the-middle attacks, so use with extreme caution")
-		registryExcludeImage = fs.StringSlice("registry-exclude-image", []string{"k8s.gcr.io/*"}, "do not scan images that match these glob expressions; the default is to exclude the 'k8s.gcr.io/*' images")
+		redisAddress  = fs.String("redis-address", "redis:6379", "host:port of the redis server, when using --registry-cache-backend=redis")
+		redisPassword = fs.String("redis-password", "", "password for authenticating with the redis server, if required")
+		redisDB       = fs.Int("redis-db", 0, "redis database number to use for the image metadata cache")
+		redisTimeout  = fs.Duration("redis-timeout", time.Second, "maximum time to wait before giving up on redis requests.")
+
+		registryPollInterval  = fs.Duration("registry-poll-interval", 5*time.Minute, "period at which to check for updated images")
+		registryPollParallel  = fs.Bool("registry-poll-parallel", false, "poll for updated images in parallel with syncing, rather than in turn, so that a long sync doesn't delay noticing new images (or the other way around)")
+		automationRespectPDBs = fs.Bool("automation-respect-pdbs", false, "if set, automation will hold back image updates to workloads whose PodDisruptionBudgets allow no more disruptions, and update no more workloads under a budget at once than it allows")
+		registryRPS           = fs.Float64("registry-rps", 50, "maximum registry requests per second per host")
+		registryBurst         = fs.Int("registry-burst", defaultRemoteConnections, "maximum number of warmer connections to remote and memcache")
+		registryRampUp        = fs.Duration("registry-ramp-up", 0, "if non-zero, spread the first fetch of image metadata after starting over this long, rather than fetching it all at once; images already in the cache are fetched straight away")
+		registryConcurrency   = fs.StringSlice("registry-host-concurrency", nil, "limit the number of image manifests fetched at once from the registry hosts matching a glob, given as <registry glob>=<limit> (e.g., 'quay.io=4'); may be repeated, and the first to match a host is used. Other hosts are limited to --registry-burst")
+		registryThrottleBelow = fs.Float64("registry-throttle-below", 0, "if non-zero, reduce the request rate for a registry host when it reports less than this fraction (e.g., 0.1) of its request quota remains")
+		registryTrace         = fs.Bool("registry-trace", false, "output trace of image registry requests to log")
+		registryIdleConns     = fs.Int("registry-max-idle-conns-per-host", registry.DefaultMaxIdleConnsPerHost, "maximum number of idle connections to keep open to each registry host, to be reused by later requests")
+		registryIdleTimeout   = fs.Duration("registry-idle-conn-timeout", registry.DefaultIdleConnTimeout, "how long a connection to a registry host may be idle before it is closed")
+		registryKeepAlive     = fs.Duration("registry-keepalive", registry.DefaultKeepAlive, "period of TCP keep-alives on connections to registry hosts")
+		registryInsecure      = fs.StringSlice("registry-insecure-host", []string{}, "let these registry hosts skip TLS host verification and fall back to using HTTP instead of HTTPS; this allows man-in-the-middle attacks, so use with extreme caution")
+		registryExcludeImage  = fs.StringSlice("registry-exclude-image", []string{"k8s.gcr.io/*"}, "do not scan images that match these glob expressions; the default is to exclude the 'k8s.gcr.io/*' images")
+		automationMaxRollouts = fs.Int("automation-max-rollouts", 0, "if non-zero, automation will hold back image updates so that no more than this many automated workloads are rolling out at once")
+
+		// Image signatures, checked with cosign
+		registrySignatureKeys       = fs.StringSlice("registry-signature-key", nil, "only automate images from registry hosts matching a glob if they are signed with a key, given as <registry glob>=<public key file>; may be repeated")
+		registrySignatureIdentities = fs.StringSlice("registry-signature-identity", nil, "only automate images from registry hosts matching a glob if they have a keyless signature from an identity, given as <registry glob>=<OIDC issuer>=<identity>; may be repeated")
+		registrySignatureRecheck    = fs.Duration("registry-signature-recheck", signature.DefaultUnsignedTTL, "how long to wait before checking again whether an image that was not signed has since been signed")
+		registryCosignExe           = fs.String("registry-cosign-path", "", "optional, explicit path to the cosign tool")
 
 		// AWS authentication
 		registryAWSRegions         = fs.StringSlice("registry-ecr-region", nil, "restrict ECR scanning to these AWS regions; if empty, only the cluster's region will be scanned")
@@ -131,6 +371,13 @@ func main() {
 		k8sSecretDataKey         = fs.String("k8s-secret-data-key", "identity", "data key holding the private SSH key within the k8s secret")
 		k8sNamespaceWhitelist    = fs.StringSlice("k8s-namespace-whitelist", []string{}, "experimental, optional: restrict the view of the cluster to the namespaces listed. All namespaces are included if this is not set")
 		k8sAllowNamespace        = fs.StringSlice("k8s-allow-namespace", []string{}, "experimental: restrict all operations to the provided namespaces")
+		k8sKubeconfig            = fs.String("k8s-kubeconfig", "", "if set, connect to the cluster in this kubeconfig file (in its current context), rather than the cluster fluxd is running in; a user with a credential plugin (exec) is supported")
+		k8sUserAgentSuffix       = fs.String("k8s-user-agent-suffix", "", "text to append to the user-agent flux identifies itself with to the Kubernetes API server (fluxd/<version>), e.g., to name the cluster; kubectl's requests get only a prefix of it, since kubectl's user-agent can't be set")
+		// reaching the API server through a bastion
+		k8sSSHTunnel           = fs.String("k8s-ssh-tunnel", "", "if set, connect to the Kubernetes API server through an SSH tunnel via this bastion, given as [user@]host[:port]")
+		k8sSSHTunnelKey        = fs.String("k8s-ssh-tunnel-key", "", "path to the private key to use for the SSH tunnel")
+		k8sSSHTunnelKnownHosts = fs.String("k8s-ssh-tunnel-known-hosts", "", "path to a known_hosts file, which must have the bastion's host key, for the SSH tunnel")
+		k8sSSHTunnelLocalPort  = fs.Int("k8s-ssh-tunnel-local-port", kubernetes.DefaultTunnelLocalPort, "local port on which the SSH tunnel listens, as a SOCKS proxy")
 		// SSH key generation
 		sshKeyBits   = optionalVar(fs, &ssh.KeyBitsValue{}, "ssh-keygen-bits", "-b argument to ssh-keygen (default unspecified)")
 		sshKeyType   = optionalVar(fs, &ssh.KeyTypeValue{}, "ssh-keygen-type", "-t argument to ssh-keygen (default unspecified)")
@@ -163,6 +410,9 @@ func main() {
 	var logger log.Logger
 	{
 		logger = log.NewLogfmtLogger(os.Stderr)
+		if *logCompactRepeats > 0 {
+			logger = logging.NewCompactingLogger(logger, *logCompactRepeats, *logCompactPeriod)
+		}
 		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
 		logger = log.With(logger, "caller", log.DefaultCaller)
 	}
@@ -196,6 +446,13 @@ func main() {
 		}
 	}
 
+	if *gitSyncRef != "" {
+		if err := git.ValidateSyncRef(*gitSyncRef); err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+	}
+
 	if *gitSkipMessage == "" && *gitSkip {
 		*gitSkipMessage = defaultGitSkipMessage
 	}
@@ -207,11 +464,261 @@ func main() {
 		}
 	}
 
+	// Scopes are synced separately from the rest of the repo, so
+	// their paths mustn't overlap with one another, or with what the
+	// daemon syncs itself.
+	var scopeNames []string
+	scopePaths := map[string][]string{}
+	if *gitSyncTagEvery < 1 {
+		logger.Log("err", fmt.Sprintf("--git-sync-tag-every must be at least 1, got %d", *gitSyncTagEvery))
+		os.Exit(1)
+	}
+	if *gitLayers && len(*gitPath) < 2 {
+		logger.Log("err", "--git-path-layers needs at least two --git-path values, to layer one over the other")
+		os.Exit(1)
+	}
+	if *gitLayers && len(*gitScopes) > 0 {
+		logger.Log("err", "--git-path-layers cannot be used with --git-scope")
+		os.Exit(1)
+	}
+	if len(*gitScopes) > 0 && len(*gitPath) == 0 {
+		logger.Log("err", "--git-path must be given along with --git-scope, otherwise the scopes would also be synced as part of the whole repo")
+		os.Exit(1)
+	}
+	for _, arg := range *gitScopes {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			logger.Log("err", fmt.Sprintf("--git-scope should be given as <name>=<path>, got %q", arg))
+			os.Exit(1)
+		}
+		name, path := parts[0], filepath.Clean(parts[1])
+		if path[0] == '/' {
+			logger.Log("err", "path given with --git-scope should not have leading forward slash")
+			os.Exit(1)
+		}
+		for _, other := range append(*gitPath, flatten(scopePaths)...) {
+			if pathsOverlap(path, other) {
+				logger.Log("err", fmt.Sprintf("path %q for --git-scope %s overlaps with %q", path, name, other))
+				os.Exit(1)
+			}
+		}
+		if _, ok := scopePaths[name]; !ok {
+			scopeNames = append(scopeNames, name)
+		}
+		scopePaths[name] = append(scopePaths[name], path)
+	}
+
+	switch *registryCacheBackend {
+	case "memcached", "redis":
+	default:
+		logger.Log("err", fmt.Sprintf("unknown --registry-cache-backend %q; expected 'memcached' or 'redis'", *registryCacheBackend))
+		os.Exit(1)
+	}
+
+	switch *syncStuckAction {
+	case kubernetes.StuckDeletionWait, kubernetes.StuckDeletionSkip:
+	default:
+		logger.Log("err", fmt.Sprintf("unknown --sync-stuck-deletion-action %q; expected 'wait' or 'skip'", *syncStuckAction))
+		os.Exit(1)
+	}
+
+	switch *syncValidationAction {
+	case fluxsync.ValidationBlock, fluxsync.ValidationProceed:
+	default:
+		logger.Log("err", fmt.Sprintf("unknown --sync-validation-failure-action %q; expected 'block' or 'proceed'", *syncValidationAction))
+		os.Exit(1)
+	}
+
+	switch *syncVerifyAction {
+	case verify.FailureBlock, verify.FailureProceed:
+	default:
+		logger.Log("err", fmt.Sprintf("unknown --sync-verify-failure-action %q; expected 'block' or 'proceed'", *syncVerifyAction))
+		os.Exit(1)
+	}
+
+	var hostConcurrency []cache.HostConcurrency
+	for _, arg := range *registryConcurrency {
+		c, err := cache.ParseHostConcurrency(arg)
+		if err != nil {
+			logger.Log("err", fmt.Sprintf("--registry-host-concurrency: %s", err))
+			os.Exit(1)
+		}
+		hostConcurrency = append(hostConcurrency, c)
+	}
+
+	var signaturePolicies []signature.Policy
+	for _, arg := range *registrySignatureKeys {
+		p, err := signature.ParseKeyPolicy(arg)
+		if err != nil {
+			logger.Log("err", fmt.Sprintf("--registry-signature-key: %s", err))
+			os.Exit(1)
+		}
+		signaturePolicies = append(signaturePolicies, p)
+	}
+	for _, arg := range *registrySignatureIdentities {
+		p, err := signature.ParseIdentityPolicy(arg)
+		if err != nil {
+			logger.Log("err", fmt.Sprintf("--registry-signature-identity: %s", err))
+			os.Exit(1)
+		}
+		signaturePolicies = append(signaturePolicies, p)
+	}
+
+	if !kresource.ValidDuplicates(*manifestDuplicates) {
+		logger.Log("err", fmt.Sprintf("unknown --manifest-duplicates %q; expected 'fail', 'first' or 'last'", *manifestDuplicates))
+		os.Exit(1)
+	}
+	var apiVersionRewrites []kresource.APIVersionRewrite
+	for _, arg := range *manifestAPIVersions {
+		r, err := kresource.ParseAPIVersionRewrite(arg)
+		if err != nil {
+			logger.Log("err", fmt.Sprintf("--manifest-rewrite-api-version: %s", err))
+			os.Exit(1)
+		}
+		apiVersionRewrites = append(apiVersionRewrites, r)
+	}
+
+	switch *syncHealthScope {
+	case daemon.SyncHealthScopeAll, daemon.SyncHealthScopeChanged:
+	default:
+		logger.Log("err", fmt.Sprintf("unknown --sync-health-scope %q; expected 'all' or 'changed'", *syncHealthScope))
+		os.Exit(1)
+	}
+
+	switch *syncApplyBatchBy {
+	case kubernetes.ApplyBatchByGroup, kubernetes.ApplyBatchByKind:
+	default:
+		logger.Log("err", fmt.Sprintf("unknown --sync-apply-batch-by %q; expected 'group' or 'kind'", *syncApplyBatchBy))
+		os.Exit(1)
+	}
+
+	switch *syncWaveTimeoutAction {
+	case kubernetes.WaveTimeoutFail, kubernetes.WaveTimeoutProceed:
+	default:
+		logger.Log("err", fmt.Sprintf("unknown --sync-wave-timeout-action %q; expected 'fail' or 'proceed'", *syncWaveTimeoutAction))
+		os.Exit(1)
+	}
+
+	switch *syncBootstrap {
+	case daemon.SyncBootstrapNever, daemon.SyncBootstrapAuto, daemon.SyncBootstrapAlways:
+	default:
+		logger.Log("err", fmt.Sprintf("unknown --sync-bootstrap %q; expected 'never', 'auto' or 'always'", *syncBootstrap))
+		os.Exit(1)
+	}
+
+	var trustedAuthors *daemon.TrustedAuthors
+	if len(*gitTrustedAuthors) > 0 || *gitTrustedAuthorsFile != "" {
+		switch *gitTrustedAuthorsCheck {
+		case daemon.TrustedAuthorsCheckAuthor, daemon.TrustedAuthorsCheckCommitter, daemon.TrustedAuthorsCheckBoth:
+		default:
+			logger.Log("err", fmt.Sprintf("unknown --git-trusted-authors-check %q; expected 'author', 'committer' or 'both'", *gitTrustedAuthorsCheck))
+			os.Exit(1)
+		}
+		trustedAuthors = &daemon.TrustedAuthors{
+			Emails: *gitTrustedAuthors,
+			File:   *gitTrustedAuthorsFile,
+			Check:  *gitTrustedAuthorsCheck,
+		}
+	}
+
+	waveTimeoutKinds := map[string]time.Duration{}
+	for _, kt := range *syncWaveTimeoutKinds {
+		parts := strings.SplitN(kt, "=", 2)
+		if len(parts) != 2 {
+			logger.Log("err", fmt.Sprintf("--sync-wave-timeout-kinds entry %q is not of the form kind=duration", kt))
+			os.Exit(1)
+		}
+		timeout, err := time.ParseDuration(parts[1])
+		if err != nil || timeout <= 0 {
+			logger.Log("err", fmt.Sprintf("--sync-wave-timeout-kinds entry %q does not have a positive duration", kt))
+			os.Exit(1)
+		}
+		waveTimeoutKinds[strings.ToLower(strings.TrimSpace(parts[0]))] = timeout
+	}
+
+	readinessChecks := map[string]kubernetes.ReadinessCheck{}
+	for _, kc := range *syncReadinessChecks {
+		parts := strings.SplitN(kc, "=", 2)
+		if len(parts) != 2 {
+			logger.Log("err", fmt.Sprintf("--sync-readiness-checks entry %q is not of the form kind=check", kc))
+			os.Exit(1)
+		}
+		check, err := kubernetes.ParseReadinessCheck(parts[1])
+		if err != nil {
+			logger.Log("err", fmt.Sprintf("--sync-readiness-checks entry %q: %v", kc, err))
+			os.Exit(1)
+		}
+		readinessChecks[strings.ToLower(strings.TrimSpace(parts[0]))] = check
+	}
+
+	if err := kubernetes.ValidateFieldManager(*syncFieldManager); err != nil {
+		logger.Log("err", fmt.Sprintf("invalid --sync-field-manager: %v", err))
+		os.Exit(1)
+	}
+	var fieldManagerRules []kubernetes.FieldManagerRule
+	for _, r := range *syncFieldManagerPaths {
+		rule, err := kubernetes.ParseFieldManagerRule(r)
+		if err != nil {
+			logger.Log("err", fmt.Sprintf("invalid --sync-field-manager-paths: %v", err))
+			os.Exit(1)
+		}
+		fieldManagerRules = append(fieldManagerRules, rule)
+	}
+
+	if *jsonnetValuesConfigMap != "" && !*jsonnetEnable {
+		logger.Log("err", "--jsonnet-values-configmap requires --jsonnet")
+		os.Exit(1)
+	}
+
+	var gcSelector labels.Selector
+	if *syncGCSelector != "" {
+		var err error
+		gcSelector, err = labels.Parse(*syncGCSelector)
+		if err != nil {
+			logger.Log("err", fmt.Sprintf("invalid --sync-garbage-collection-selector %q: %s", *syncGCSelector, err))
+			os.Exit(1)
+		}
+	}
+
+	gcKinds, err := kubernetes.ParseGCKinds(*syncGCKinds)
+	if err != nil {
+		logger.Log("err", fmt.Sprintf("invalid --sync-garbage-collection-kinds: %s", err))
+		os.Exit(1)
+	}
+
+	switch *syncMissingNamespaces {
+	case kubernetes.MissingNamespacesApply, kubernetes.MissingNamespacesFail, kubernetes.MissingNamespacesCreate:
+	default:
+		logger.Log("err", fmt.Sprintf("unknown --sync-missing-namespaces %q; expected 'apply', 'fail' or 'create'", *syncMissingNamespaces))
+		os.Exit(1)
+	}
+
+	switch *kubectlSkewAction {
+	case "warn", "refuse":
+	default:
+		logger.Log("err", fmt.Sprintf("unknown --kubernetes-kubectl-version-skew-action %q; expected 'warn' or 'refuse'", *kubectlSkewAction))
+		os.Exit(1)
+	}
+
 	if *sshKeygenDir == "" {
 		logger.Log("info", fmt.Sprintf("SSH keygen dir (--ssh-keygen-dir) not provided, so using the deploy key volume (--k8s-secret-volume-mount-path=%s); this may cause problems if the deploy key volume is mounted read-only", *k8sSecretVolumeMountPath))
 		*sshKeygenDir = *k8sSecretVolumeMountPath
 	}
 
+	switch *gitSigningFormat {
+	case "openpgp":
+	case "ssh":
+		if *gitSigningKey != "" {
+			if _, err := os.Stat(*gitSigningKey); err != nil {
+				logger.Log("err", fmt.Sprintf("SSH signing key given as --git-signing-key not found: %s", err))
+				os.Exit(1)
+			}
+		}
+	default:
+		logger.Log("err", fmt.Sprintf("unknown --git-signing-format %q; expected 'openpgp' or 'ssh'", *gitSigningFormat))
+		os.Exit(1)
+	}
+
 	// Import GPG keys, if we've been told where to look for them
 	if *gitImportGPG != "" {
 		keyfiles, err := gpg.ImportKeys(*gitImportGPG)
@@ -241,19 +748,70 @@ func main() {
 
 	// Cluster component.
 	var clusterVersion string
+	var kubectlVersion, serverGitVersion string
 	var sshKeyRing ssh.KeyRing
 	var k8s cluster.Cluster
 	var k8sManifests *kubernetes.Manifests
 	var imageCreds func() registry.ImageCreds
+	var leader daemon.Elector
+	var validator cluster.Validator
+	var syncValidation *fluxsync.Validation
+	var auditSinks audit.Sinks
+	var valuesConfigMap *kubernetes.ValuesConfigMap
 	{
-		restClientConfig, err := rest.InClusterConfig()
+		var restClientConfig *rest.Config
+		if *k8sKubeconfig != "" {
+			restClientConfig, err = clientcmd.BuildConfigFromFlags("", *k8sKubeconfig)
+		} else {
+			restClientConfig, err = rest.InClusterConfig()
+		}
 		if err != nil {
 			logger.Log("err", err)
 			os.Exit(1)
 		}
+		// Run any credential plugin once per token, for all the
+		// clients and kubectl, rather than once for each
+		credentials := kubernetes.UseExecCredentials(restClientConfig, log.With(logger, "component", "credentials"))
 
 		restClientConfig.QPS = 50.0
 		restClientConfig.Burst = 100
+		restClientConfig.UserAgent = "fluxd/" + version
+		if *k8sUserAgentSuffix != "" {
+			restClientConfig.UserAgent += " " + *k8sUserAgentSuffix
+		}
+
+		var tunnel *kubernetes.SSHTunnel
+		if *k8sSSHTunnel != "" {
+			sshExe, err := exec.LookPath("ssh")
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			destination, port := *k8sSSHTunnel, 0
+			if host, portStr, err := net.SplitHostPort(destination); err == nil {
+				if port, err = strconv.Atoi(portStr); err != nil {
+					logger.Log("err", fmt.Sprintf("invalid port in --k8s-ssh-tunnel %q", *k8sSSHTunnel))
+					os.Exit(1)
+				}
+				destination = host
+			}
+			tunnel = kubernetes.NewSSHTunnel(kubernetes.SSHTunnelConfig{
+				Exe:            sshExe,
+				Destination:    destination,
+				Port:           port,
+				KeyFile:        *k8sSSHTunnelKey,
+				KnownHostsFile: *k8sSSHTunnelKnownHosts,
+				LocalPort:      *k8sSSHTunnelLocalPort,
+				Logger:         log.With(logger, "component", "tunnel"),
+			})
+			shutdownWg.Add(1)
+			go tunnel.Start(shutdown, shutdownWg)
+			if err := tunnel.Wait(30 * time.Second); err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			tunnel.Apply(restClientConfig)
+		}
 
 		clientset, err := k8sclient.NewForConfig(restClientConfig)
 		if err != nil {
@@ -285,6 +843,7 @@ func main() {
 			os.Exit(1)
 		}
 		clusterVersion = "kubernetes-" + serverVersion.GitVersion
+		serverGitVersion = serverVersion.GitVersion
 
 		namespace, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
 		if err != nil {
@@ -327,9 +886,144 @@ func main() {
 
 		client := kubernetes.MakeClusterClientset(clientset, dynamicClientset, integrationsClientset, discoClientset)
 		kubectlApplier := kubernetes.NewKubectl(kubectl, restClientConfig)
+		kubectlVersion, err = kubectlApplier.ClientVersion()
+		if err != nil {
+			if *kubectlSkewAction == "refuse" {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			logger.Log("warning", "could not get the version of kubectl, so it can't be checked against the API server's", "err", err)
+		} else {
+			logger.Log("kubectl-version", kubectlVersion, "server-version", serverGitVersion)
+			if skew, err := kubernetes.VersionSkew(kubectlVersion, serverGitVersion); err != nil || skew > *kubectlMaxSkew {
+				if err == nil {
+					err = fmt.Errorf("kubectl version %s is %d minor versions from API server version %s; at most %d is allowed", kubectlVersion, skew, serverGitVersion, *kubectlMaxSkew)
+				}
+				if *kubectlSkewAction == "refuse" {
+					logger.Log("err", err)
+					os.Exit(1)
+				}
+				logger.Log("warning", err)
+			}
+		}
+		if tunnel != nil {
+			kubectlApplier.Env = tunnel.Env()
+		}
+		kubectlApplier.Kubeconfig = *k8sKubeconfig
+		kubectlApplier.Credentials = credentials
+		kubectlApplier.RecreateKinds = *syncRecreateKinds
+		kubectlApplier.ForceApplyKinds = *syncForceApplyKinds
+		kubectlApplier.ApplyRetries = *syncApplyRetries
+		kubectlApplier.ApplyBatchSize = *syncApplyBatchSize
+		kubectlApplier.ApplyBatchBy = *syncApplyBatchBy
+		kubectlApplier.ApplyBatchDelay = *syncApplyBatchDelay
+		kubectlApplier.ServerDryRun = *syncServerDryRun
+		kubectlApplier.ServerSideApply = *syncServerSideApply
+		kubectlApplier.FieldManager = *syncFieldManager
+		kubectlApplier.FieldManagerRules = fieldManagerRules
+		kubectlApplier.WaveTimeout = *syncWaveTimeout
+		kubectlApplier.WaveTimeoutKinds = waveTimeoutKinds
+		kubectlApplier.WaveTimeoutAction = *syncWaveTimeoutAction
+		kubectlApplier.CRDEstablishTimeout = *syncCRDTimeout
+		kubectlApplier.ReadinessChecks = readinessChecks
+		if *syncSelfWorkload != "" {
+			self, err := flux.ParseResourceIDOptionalNamespace(string(namespace), *syncSelfWorkload)
+			if err != nil {
+				logger.Log("err", fmt.Sprintf("invalid --sync-self-workload %q: %s", *syncSelfWorkload, err))
+				os.Exit(1)
+			}
+			kubectlApplier.SelfWorkload = self
+			kubectlApplier.SelfTimeout = *syncSelfTimeout
+		}
+		kubectlApplier.BootstrapKinds = *syncBootstrapKinds
+		kubectlApplier.BootstrapTimeout = *syncBootstrapTimeout
+		kubectlApplier.Version = kubectlVersion
+		if err := kubectlApplier.CheckVersion(); err != nil {
+			logger.Log("err", fmt.Sprintf("%s; give a newer kubectl with --kubernetes-kubectl, or leave out the options that need it", err))
+			os.Exit(1)
+		}
 		allowedNamespaces := append(*k8sNamespaceWhitelist, *k8sAllowNamespace...)
 		k8sInst := kubernetes.NewCluster(client, kubectlApplier, sshKeyRing, logger, allowedNamespaces, *registryExcludeImage)
 		k8sInst.GC = *syncGC
+		k8sInst.GCGracePeriod = *syncGCGracePeriod
+		if gcSelector != nil {
+			logger.Log("info", "only garbage collecting resources matching selector", "selector", gcSelector)
+			k8sInst.GCSelector = gcSelector
+		}
+		if len(gcKinds) > 0 {
+			logger.Log("info", "only garbage collecting resources of the kinds given", "kinds", strings.Join(*syncGCKinds, ","))
+			k8sInst.GCKinds = gcKinds
+		}
+		k8sInst.StuckDeletionTimeout = *syncStuckTimeout
+		k8sInst.StuckDeletionAction = *syncStuckAction
+		k8sInst.RemoveFinalizersKinds = *syncRemoveFinalizers
+		k8sInst.BatchIgnoreFields = *syncBatchIgnore
+		k8sInst.KeepStatusKinds = *syncKeepStatusKinds
+		k8sInst.MissingNamespaces = *syncMissingNamespaces
+		k8sInst.InjectedContainers = *syncInjectedContainers
+		k8sInst.InjectedVolumes = *syncInjectedVolumes
+		k8sInst.Tunnel = tunnel
+		if *syncEvents {
+			k8sInst.Events = kubernetes.NewSyncEvents(kubernetes.SyncEventsConfig{
+				EventsAPI: clientset.CoreV1(),
+				PerSecond: *syncEventsRate,
+				Burst:     *syncEventsBurst,
+				Logger:    log.With(logger, "component", "events"),
+			})
+		}
+
+		if *sopsDecrypt {
+			sops := *sopsExe
+			if sops == "" {
+				sops, err = exec.LookPath("sops")
+			} else {
+				_, err = os.Stat(sops)
+			}
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			logger.Log("sops", sops)
+			decrypter := &kubernetes.SOPSDecrypter{
+				Exe:          sops,
+				FilePatterns: *sopsFilePatterns,
+			}
+			if *sopsAgeKeyFile != "" {
+				decrypter.Env = append(decrypter.Env, "SOPS_AGE_KEY_FILE="+*sopsAgeKeyFile)
+			}
+			k8sInst.Decrypter = decrypter
+		}
+
+		if *vaultAddr != "" {
+			if len(*vaultAllowPaths) == 0 {
+				logger.Log("err", "--vault-addr needs at least one --vault-allow-path, to say which paths Secrets may refer to")
+				os.Exit(1)
+			}
+			allowed := map[string][]string{}
+			for _, arg := range *vaultAllowPaths {
+				namespace, prefix, err := kubernetes.ParseVaultAllowedPath(arg)
+				if err != nil {
+					logger.Log("err", fmt.Sprintf("--vault-allow-path: %s", err))
+					os.Exit(1)
+				}
+				allowed[namespace] = append(allowed[namespace], prefix)
+			}
+			client, err := kubernetes.NewVaultClient(*vaultCACert, *vaultClientCert, *vaultClientKey)
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			k8sInst.Secrets = &kubernetes.VaultResolver{
+				Addr:         *vaultAddr,
+				TokenFile:    *vaultTokenFile,
+				Token:        os.Getenv("VAULT_TOKEN"),
+				AllowedPaths: allowed,
+				Client:       client,
+			}
+			if *vaultTokenFile == "" && k8sInst.Secrets.Token == "" {
+				logger.Log("warning", "--vault-addr given, but neither --vault-token-file nor VAULT_TOKEN; requests to Vault will not be authenticated")
+			}
+		}
 
 		if err := k8sInst.Ping(); err != nil {
 			logger.Log("ping", err)
@@ -337,11 +1031,161 @@ func main() {
 			logger.Log("ping", true)
 		}
 
+		if *syncLeaderElection {
+			identity, err := os.Hostname()
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			elector := kubernetes.NewLeaderElector(kubernetes.LeaderElectorConfig{
+				ConfigMapAPI:  clientset.CoreV1().ConfigMaps(string(namespace)),
+				ConfigMapName: *syncLeaderConfigMap,
+				Identity:      identity,
+				LeaseDuration: *syncLeaderLeaseDuration,
+				Logger:        log.With(logger, "component", "leader-election"),
+			})
+			shutdownWg.Add(1)
+			go elector.Start(shutdown, shutdownWg)
+			leader = elector
+		}
+
 		k8s = k8sInst
+		if *auditEvents {
+			auditSinks = append(auditSinks, &kubernetes.AuditEvents{
+				EventsAPI: clientset.CoreV1(),
+				Namespace: string(namespace),
+			})
+		}
 		imageCreds = k8sInst.ImagesToFetch
 		// There is only one way we currently interpret a repo of
 		// files as manifests, and that's as Kubernetes yamels.
-		k8sManifests = &kubernetes.Manifests{}
+		k8sManifests = &kubernetes.Manifests{
+			Layered:     *gitLayers,
+			MaxFileSize: *manifestMaxFileSize,
+			Duplicates:  *manifestDuplicates,
+			APIVersions: apiVersionRewrites,
+			Logger:      log.With(logger, "component", "manifests"),
+		}
+		if *jsonnetEnable {
+			jsonnet := *jsonnetExe
+			if jsonnet == "" {
+				jsonnet, err = exec.LookPath("jsonnet")
+			} else {
+				_, err = os.Stat(jsonnet)
+			}
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			logger.Log("jsonnet", jsonnet)
+			k8sManifests.Jsonnet = &kresource.Jsonnet{
+				Exe:         jsonnet,
+				ImportPaths: *jsonnetImportPaths,
+				TLAStrs:     *jsonnetTLAStrs,
+			}
+			if *jsonnetValuesConfigMap != "" {
+				parts := strings.Split(*jsonnetValuesConfigMap, "/")
+				if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+					logger.Log("err", fmt.Sprintf("invalid --jsonnet-values-configmap %q; expected <namespace>/<name>", *jsonnetValuesConfigMap))
+					os.Exit(1)
+				}
+				valuesConfigMap = &kubernetes.ValuesConfigMap{
+					Client:    clientset.CoreV1(),
+					Namespace: parts[0],
+					Name:      parts[1],
+					Interval:  *jsonnetValuesPollInterval,
+					Logger:    log.With(logger, "component", "jsonnet-values"),
+				}
+				k8sManifests.Jsonnet.Values = valuesConfigMap.Values
+			}
+		}
+		if len(*cuePackages) > 0 {
+			cue := *cueExe
+			if cue == "" {
+				cue, err = exec.LookPath("cue")
+			} else {
+				_, err = os.Stat(cue)
+			}
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			logger.Log("cue", cue, "packages", strings.Join(*cuePackages, ","))
+			k8sManifests.CUE = &kresource.CUE{
+				Exe:        cue,
+				Packages:   *cuePackages,
+				Tags:       *cueTags,
+				Expression: *cueExpression,
+			}
+		}
+		if len(*validatePolicies) > 0 {
+			conftest := *conftestExe
+			if conftest == "" {
+				conftest, err = exec.LookPath("conftest")
+			} else {
+				_, err = os.Stat(conftest)
+			}
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			logger.Log("conftest", conftest)
+			conftestValidator := &kubernetes.ConftestValidator{
+				Exe:        conftest,
+				Namespaces: *validateNamespaces,
+			}
+			// Anything that isn't a local file or directory is
+			// taken to be a bundle to download
+			for _, p := range *validatePolicies {
+				if _, err := os.Stat(p); err == nil {
+					conftestValidator.Policies = append(conftestValidator.Policies, p)
+					continue
+				}
+				dir, err := ioutil.TempDir("", "flux-policy")
+				if err != nil {
+					logger.Log("err", err)
+					os.Exit(1)
+				}
+				if err := conftestValidator.PullBundle(p, dir); err != nil {
+					logger.Log("err", err)
+					os.Exit(1)
+				}
+				logger.Log("info", "pulled policy bundle", "url", p)
+			}
+			validator = conftestValidator
+		}
+
+		if *syncValidationKubeconfig != "" {
+			validationConfig, err := clientcmd.BuildConfigFromFlags("", *syncValidationKubeconfig)
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			validationCredentials := kubernetes.UseExecCredentials(validationConfig, log.With(logger, "component", "credentials", "cluster", "validation"))
+			validationClient, err := makeClusterClient(validationConfig, shutdown)
+			if err != nil {
+				logger.Log("err", fmt.Sprintf("connecting to validation cluster: %v", err))
+				os.Exit(1)
+			}
+			validationApplier := kubectlApplier.ForConfig(validationConfig)
+			validationApplier.Kubeconfig = *syncValidationKubeconfig
+			validationApplier.Credentials = validationCredentials
+			validationLogger := log.With(logger, "component", "validation-cluster")
+			validationInst := k8sInst.ForValidation(validationClient, validationApplier, validationLogger)
+			if err := validationInst.Ping(); err != nil {
+				validationLogger.Log("ping", err)
+			} else {
+				validationLogger.Log("ping", true, "host", validationConfig.Host, "action", *syncValidationAction)
+			}
+			syncValidation = &fluxsync.Validation{
+				Cluster: validationInst,
+				Action:  *syncValidationAction,
+				Report: func(summary cluster.SyncSummary, err error) {
+					validationLogger.Log("info", "synced to validation cluster", "summary", summary.String(), "err", err)
+				},
+			}
+		}
+
 		k8sManifests.Namespacer, err = kubernetes.NewNamespacer(discoClientset)
 
 		if err != nil {
@@ -379,26 +1223,45 @@ func main() {
 	{
 		// Cache client, for use by registry and cache warmer
 		var cacheClient cache.Client
-		var memcacheClient *registryMemcache.MemcacheClient
-		memcacheConfig := registryMemcache.MemcacheConfig{
-			Host:           *memcachedHostname,
-			Service:        *memcachedService,
-			Timeout:        *memcachedTimeout,
-			UpdateInterval: 1 * time.Minute,
-			Logger:         log.With(logger, "component", "memcached"),
-			MaxIdleConns:   *registryBurst,
-		}
-
-		// if no memcached service is specified use the ClusterIP name instead of SRV records
-		if *memcachedService == "" {
-			memcacheClient = registryMemcache.NewFixedServerMemcacheClient(memcacheConfig,
-				fmt.Sprintf("%s:11211", *memcachedHostname))
-		} else {
-			memcacheClient = registryMemcache.NewMemcacheClient(memcacheConfig)
+		var cachePinger cache.Pinger
+		switch *registryCacheBackend {
+		case "redis":
+			redisClient := registryRedis.NewRedisClient(registryRedis.RedisConfig{
+				Address:      *redisAddress,
+				Password:     *redisPassword,
+				DB:           *redisDB,
+				Timeout:      *redisTimeout,
+				Logger:       log.With(logger, "component", "redis"),
+				MaxIdleConns: *registryBurst,
+			})
+			defer redisClient.Stop()
+			cacheClient, cachePinger = redisClient, redisClient
+		default:
+			var memcacheClient *registryMemcache.MemcacheClient
+			memcacheConfig := registryMemcache.MemcacheConfig{
+				Host:           *memcachedHostname,
+				Service:        *memcachedService,
+				Timeout:        *memcachedTimeout,
+				UpdateInterval: 1 * time.Minute,
+				Logger:         log.With(logger, "component", "memcached"),
+				MaxIdleConns:   *registryBurst,
+			}
+
+			// if no memcached service is specified use the ClusterIP name instead of SRV records
+			if *memcachedService == "" {
+				memcacheClient = registryMemcache.NewFixedServerMemcacheClient(memcacheConfig,
+					fmt.Sprintf("%s:11211", *memcachedHostname))
+			} else {
+				memcacheClient = registryMemcache.NewMemcacheClient(memcacheConfig)
+			}
+
+			defer memcacheClient.Stop()
+			cacheClient, cachePinger = memcacheClient, memcacheClient
 		}
+		cacheClient = cache.InstrumentClient(cacheClient)
 
-		defer memcacheClient.Stop()
-		cacheClient = cache.InstrumentClient(memcacheClient)
+		shutdownWg.Add(1)
+		go cache.MonitorHealth(cachePinger, time.Minute, log.With(logger, "component", "cache", "backend", *registryCacheBackend), shutdown, shutdownWg)
 
 		cacheRegistry = &cache.Cache{
 			Reader: cacheClient,
@@ -408,15 +1271,20 @@ func main() {
 		// Remote client, for warmer to refresh entries
 		registryLogger := log.With(logger, "component", "registry")
 		registryLimits := &registryMiddleware.RateLimiters{
-			RPS:    *registryRPS,
-			Burst:  *registryBurst,
-			Logger: log.With(logger, "component", "ratelimiter"),
+			RPS:           *registryRPS,
+			Burst:         *registryBurst,
+			ThrottleBelow: *registryThrottleBelow,
+			Logger:        log.With(logger, "component", "ratelimiter"),
 		}
 		remoteFactory := &registry.RemoteClientFactory{
 			Logger:        registryLogger,
 			Limiters:      registryLimits,
 			Trace:         *registryTrace,
 			InsecureHosts: *registryInsecure,
+
+			MaxIdleConnsPerHost: *registryIdleConns,
+			IdleConnTimeout:     *registryIdleTimeout,
+			KeepAlive:           *registryKeepAlive,
 		}
 
 		// Warmer
@@ -442,18 +1310,51 @@ func main() {
 
 	gitRemote := git.Remote{URL: *gitURL}
 	gitConfig := git.Config{
-		Paths:       *gitPath,
-		Branch:      *gitBranch,
-		SyncTag:     *gitSyncTag,
-		NotesRef:    *gitNotesRef,
-		UserName:    *gitUser,
-		UserEmail:   *gitEmail,
-		SigningKey:  *gitSigningKey,
-		SetAuthor:   *gitSetAuthor,
-		SkipMessage: *gitSkipMessage,
-	}
-
-	repo := git.NewRepo(gitRemote, git.PollInterval(*gitPollInterval), git.Timeout(*gitTimeout))
+		Paths:             *gitPath,
+		Branch:            *gitBranch,
+		SyncTag:           *gitSyncTag,
+		SyncRef:           *gitSyncRef,
+		NotesRef:          *gitNotesRef,
+		UserName:          *gitUser,
+		UserEmail:         *gitEmail,
+		SigningKey:        *gitSigningKey,
+		SigningFormat:     *gitSigningFormat,
+		SSHAllowedSigners: *gitSSHAllowedSigners,
+		SetAuthor:         *gitSetAuthor,
+		SkipMessage:       *gitSkipMessage,
+	}
+
+	repoOpts := []git.Option{git.PollInterval(*gitPollInterval), git.Timeout(*gitTimeout), git.CloneTimeout(*gitCloneTimeout)}
+	if *gitSSHCertificate != "" {
+		cert, err := ssh.ReadCertificate(*gitSSHCertificate)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		logger.Log("ssh-certificate", *gitSSHCertificate, "key-id", cert.KeyID, "valid-after", cert.ValidAfter, "valid-before", cert.ValidBefore)
+		repoOpts = append(repoOpts, git.SSHCertificate(*gitSSHCertificate))
+		shutdownWg.Add(1)
+		go watchSSHCertificate(*gitSSHCertificate, log.With(logger, "component", "ssh"), shutdown, shutdownWg)
+	}
+	if *gitLFSURL != "" && !*gitLFS {
+		logger.Log("err", "--git-lfs-url can only be given with --git-lfs")
+		os.Exit(1)
+	}
+	if *gitLFS {
+		ctx, cancel := context.WithTimeout(context.Background(), *gitTimeout)
+		err := git.CheckLFS(ctx)
+		cancel()
+		if err != nil {
+			logger.Log("err", fmt.Sprintf("--git-lfs needs git-lfs installed: %s", err))
+			os.Exit(1)
+		}
+		repoOpts = append(repoOpts, git.LFS{
+			URL:      *gitLFSURL,
+			Branches: []string{*gitBranch},
+			Logger:   log.With(logger, "component", "git"),
+		})
+	}
+	repo := git.NewRepo(gitRemote, repoOpts...)
 	{
 		shutdownWg.Add(1)
 		go func() {
@@ -464,12 +1365,25 @@ func main() {
 		}()
 	}
 
+	var scopes []*git.Scope
+	for _, name := range scopeNames {
+		scope, err := repo.AddScope(name, scopePaths[name], *gitSyncTag+"-"+name)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		logger.Log("scope", scope, "sync-ref", scope.Config(gitConfig).SyncTagRef())
+		scopes = append(scopes, scope)
+	}
+
 	logger.Log(
 		"url", *gitURL,
 		"user", *gitUser,
 		"email", *gitEmail,
 		"signing-key", *gitSigningKey,
+		"signing-format", *gitSigningFormat,
 		"sync-tag", *gitSyncTag,
+		"sync-ref", gitConfig.SyncTagRef(),
 		"notes-ref", *gitNotesRef,
 		"set-author", *gitSetAuthor,
 	)
@@ -479,6 +1393,48 @@ func main() {
 		jobs = job.NewQueue(shutdown, shutdownWg)
 	}
 
+	var jobHistory *job.History
+	if *jobHistorySize > 0 {
+		jobHistory = &job.History{Size: *jobHistorySize, File: *jobHistoryFile}
+		if err := jobHistory.Load(); err != nil {
+			logger.Log("warning", "could not load job history; starting afresh", "file", *jobHistoryFile, "err", err)
+		}
+	}
+
+	if *auditFile != "" {
+		sink, err := audit.NewFileSink(*auditFile)
+		if err != nil {
+			logger.Log("err", err, "flag", "--audit-log-file")
+			os.Exit(1)
+		}
+		auditSinks = append(auditSinks, sink)
+	}
+	if *auditWebhookURL != "" {
+		auditSinks = append(auditSinks, &audit.WebhookSink{
+			URL:    *auditWebhookURL,
+			Client: &http.Client{Timeout: 10 * time.Second},
+		})
+	}
+
+	var verifier verify.Verifier
+	if *syncVerifyURL != "" {
+		verifier = &verify.Webhook{URL: *syncVerifyURL}
+	}
+
+	var imageSignatures signature.Verifier
+	if len(signaturePolicies) > 0 {
+		cosign := &signature.Cosign{
+			Policies:    signaturePolicies,
+			Exe:         *registryCosignExe,
+			UnsignedTTL: *registrySignatureRecheck,
+		}
+		if err := cosign.Check(); err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		imageSignatures = cosign
+	}
+
 	daemon := &daemon.Daemon{
 		V:              version,
 		Cluster:        k8s,
@@ -489,14 +1445,83 @@ func main() {
 		GitConfig:      gitConfig,
 		Jobs:           jobs,
 		JobStatusCache: &job.StatusCache{Size: 100},
+		History:        jobHistory,
 		Logger:         log.With(logger, "component", "daemon"),
+		Config:         daemonConfig(fs, version),
+		Versions:       v12.ClusterVersions{Kubectl: kubectlVersion, Server: serverGitVersion},
 		LoopVars: &daemon.LoopVars{
-			SyncInterval:         *syncInterval,
-			RegistryPollInterval: *registryPollInterval,
-			GitOpTimeout:         *gitTimeout,
+			LoopConfig: daemon.LoopConfig{
+				SyncInterval:          *syncInterval,
+				RegistryPollInterval:  *registryPollInterval,
+				GitOpTimeout:          *gitTimeout,
+				RefuseForcePush:       *gitRefuseForcePush,
+				GitVerifySignatures:   *gitVerifySignatures,
+				GitTrustedAuthors:     trustedAuthors,
+				SkipUnchangedSyncs:    *syncSkipUnchanged,
+				AllowEmptySync:        *syncAllowEmpty,
+				IncrementalSync:       *syncIncremental,
+				DriftReportInterval:   *driftReportInterval,
+				FullSyncInterval:      *syncFullInterval,
+				Leader:                leader,
+				Validator:             validator,
+				SyncValidation:        syncValidation,
+				SyncHealthTimeout:     *syncHealthTimeout,
+				SyncHealthScope:       *syncHealthScope,
+				SyncHealthNamespaces:  *syncHealthNamespaces,
+				SyncIsolateNamespaces: *syncIsolateNamespaces,
+				Verifier:              verifier,
+				VerifyTimeout:         *syncVerifyTimeout,
+				VerifyFailureAction:   *syncVerifyAction,
+				ConcurrentImagePoll:   *registryPollParallel,
+				HeartbeatInterval:     *heartbeatInterval,
+				AutomationMaxRollouts: *automationMaxRollouts,
+				AutomationRespectPDBs: *automationRespectPDBs,
+				SyncTagEvery:          *gitSyncTagEvery,
+				ImageSignatures:       imageSignatures,
+				NotifySyncRecovery:    *notifySyncRecovery,
+				SyncBootstrap:         *syncBootstrap,
+			},
 		},
 	}
+	if len(auditSinks) > 0 {
+		daemon.Audit = auditSinks
+	}
+
+	var notifier *notify.Notifier
+	if *notifyURL != "" {
+		templates := map[string]string{}
+		for _, arg := range *notifyTemplates {
+			parts := strings.SplitN(arg, "=", 2)
+			if len(parts) != 2 {
+				logger.Log("err", fmt.Sprintf("--notify-template should be given as <event type>=<path>, got %q", arg))
+				os.Exit(1)
+			}
+			src, err := ioutil.ReadFile(parts[1])
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			templates[parts[0]] = string(src)
+		}
+		n, err := notify.New(notify.Config{
+			URL:          *notifyURL,
+			Format:       notify.Format(*notifyFormat),
+			Templates:    templates,
+			CommitURL:    *notifyCommitURL,
+			DashboardURL: *notifyDashboardURL,
+			Timeout:      10 * time.Second,
+			Logger:       log.With(logger, "component", "notify"),
+		})
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		notifier = n
+		shutdownWg.Add(1)
+		go notifier.Start(shutdown, shutdownWg)
+	}
 
+	var eventWriters event.MultiWriter
 	{
 		// Connect to fluxsvc if given an upstream address
 		if *upstreamURL != "" {
@@ -515,7 +1540,7 @@ func main() {
 				logger.Log("err", err)
 				os.Exit(1)
 			}
-			daemon.EventWriter = upstream
+			eventWriters = append(eventWriters, upstream)
 			go func() {
 				<-shutdown
 				upstream.Close()
@@ -524,13 +1549,40 @@ func main() {
 			logger.Log("upstream", "no upstream URL given")
 		}
 	}
+	// Notify after logging upstream, since an event that fails to
+	// be logged upstream will be tried again.
+	if notifier != nil {
+		eventWriters = append(eventWriters, notifier)
+	}
+	if len(eventWriters) > 0 {
+		daemon.EventWriter = eventWriters
+	}
 
 	shutdownWg.Add(1)
 	go daemon.Loop(shutdown, shutdownWg, log.With(logger, "component", "sync-loop"))
+	askForFullSync := []func(){daemon.AskForFullSync}
+	for _, scope := range scopes {
+		scoped := daemon.ForScope(scope)
+		askForFullSync = append(askForFullSync, scoped.AskForFullSync)
+		shutdownWg.Add(1)
+		go scoped.Loop(shutdown, shutdownWg, log.With(logger, "component", "sync-loop", "scope", scope.Name))
+	}
+	if valuesConfigMap != nil {
+		shutdownWg.Add(1)
+		go valuesConfigMap.Loop(shutdown, shutdownWg, func() {
+			// Nothing in git has changed, so an incremental sync
+			// wouldn't apply the newly rendered manifests
+			for _, ask := range askForFullSync {
+				ask()
+			}
+		})
+	}
 
 	cacheWarmer.Notify = daemon.AskForImagePoll
 	cacheWarmer.Priority = daemon.ImageRefresh
 	cacheWarmer.Trace = *registryTrace
+	cacheWarmer.RampUp = *registryRampUp
+	cacheWarmer.HostConcurrency = hostConcurrency
 	shutdownWg.Add(1)
 	go cacheWarmer.Loop(log.With(logger, "component", "warmer"), shutdown, shutdownWg, imageCreds)
 
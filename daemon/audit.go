@@ -0,0 +1,149 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/audit"
+	"github.com/weaveworks/flux/guid"
+	"github.com/weaveworks/flux/job"
+	"github.com/weaveworks/flux/policy"
+	"github.com/weaveworks/flux/update"
+)
+
+// How long to spend writing audit records for each outcome.
+const auditTimeout = 10 * time.Second
+
+// auditRecords gives the audit records for an update, or nil if it's
+// not a kind of update that's audited.
+func auditRecords(spec update.Spec) []audit.Record {
+	base := audit.Record{Actor: spec.Cause.User, Message: spec.Cause.Message}
+	var records []audit.Record
+	switch s := spec.Spec.(type) {
+	case policy.Updates:
+		for id, u := range s {
+			var actions []string
+			other := false
+			for p := range u.Add {
+				switch p {
+				case policy.Locked:
+					actions = append(actions, audit.ActionLock)
+				case policy.Automated:
+					actions = append(actions, audit.ActionAutomate)
+				default:
+					other = true
+				}
+			}
+			for p := range u.Remove {
+				switch p {
+				case policy.Locked:
+					actions = append(actions, audit.ActionUnlock)
+				case policy.Automated:
+					actions = append(actions, audit.ActionDeautomate)
+				default:
+					other = true
+				}
+			}
+			if other {
+				actions = append(actions, audit.ActionPolicy)
+			}
+			sort.Strings(actions)
+			for _, action := range actions {
+				r := base
+				r.Action = action
+				r.Workloads = []flux.ResourceID{id}
+				records = append(records, r)
+			}
+		}
+		sort.SliceStable(records, func(i, j int) bool {
+			return records[i].Workloads[0].String() < records[j].Workloads[0].String()
+		})
+	case update.ReleaseImageSpec:
+		if s.Kind == update.ReleaseKindPlan {
+			return nil
+		}
+		r := base
+		r.Action = audit.ActionRelease
+		var specs []string
+		for _, ss := range s.ServiceSpecs {
+			specs = append(specs, ss.String())
+		}
+		r.Spec = fmt.Sprintf("image %s to %s", s.ImageSpec, strings.Join(specs, ", "))
+		records = append(records, r)
+	case update.ReleaseContainersSpec:
+		if s.Kind == update.ReleaseKindPlan {
+			return nil
+		}
+		r := base
+		r.Action = audit.ActionRelease
+		for id := range s.ContainerSpecs {
+			r.Workloads = append(r.Workloads, id)
+		}
+		sort.Slice(r.Workloads, func(i, j int) bool {
+			return r.Workloads[i].String() < r.Workloads[j].String()
+		})
+		records = append(records, r)
+	case update.ResetSync:
+		r := base
+		r.Action = audit.ActionResetSync
+		r.Spec = fmt.Sprintf("sync tag to %s", s.Revision)
+		records = append(records, r)
+	case update.MarkSynced:
+		r := base
+		r.Action = audit.ActionMarkSynced
+		r.Spec = fmt.Sprintf("sync tag to %s, without applying", s.Revision)
+		records = append(records, r)
+	}
+	return records
+}
+
+// queueAuditedJob queues a job for the update given, recording that
+// it was requested, then its outcome once it's run.
+func (d *Daemon) queueAuditedJob(spec update.Spec, do jobFunc) job.ID {
+	records := auditRecords(spec)
+	if d.Audit == nil || len(records) == 0 {
+		return d.queueJob(spec, do)
+	}
+	id := job.ID(guid.New())
+	// Recorded before the job is queued, so it's on record even
+	// if the job never gets to run
+	d.writeAudit(d.Logger, id, records, audit.OutcomeRequested, job.Result{}, nil)
+	return d.queueJobWithID(id, spec, func(ctx context.Context, jobID job.ID, logger log.Logger) (job.Result, error) {
+		result, err := do(ctx, jobID, logger)
+		outcome := audit.OutcomeSucceeded
+		if err != nil {
+			outcome = audit.OutcomeFailed
+		}
+		d.writeAudit(logger, jobID, records, outcome, result, err)
+		return result, err
+	})
+}
+
+func (d *Daemon) writeAudit(logger log.Logger, id job.ID, records []audit.Record, outcome string, result job.Result, jobErr error) {
+	ctx, cancel := context.WithTimeout(context.Background(), auditTimeout)
+	defer cancel()
+	now := time.Now().UTC()
+	for _, r := range records {
+		r.Time = now
+		r.JobID = id
+		r.Outcome = outcome
+		r.Revision = result.Revision
+		if jobErr != nil {
+			r.Error = jobErr.Error()
+		}
+		// Releases given by image and workload spec only say which
+		// workloads were changed once they're done
+		if r.Action == audit.ActionRelease && r.Workloads == nil && outcome == audit.OutcomeSucceeded {
+			r.Workloads = result.Result.AffectedResources()
+		}
+		if err := d.Audit.Record(ctx, r); err != nil {
+			logger.Log("err", err, "audit", r.Action, "outcome", outcome, "job", id)
+		}
+	}
+}
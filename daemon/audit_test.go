@@ -0,0 +1,56 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/audit"
+	"github.com/weaveworks/flux/policy"
+	"github.com/weaveworks/flux/update"
+)
+
+func TestAuditRecords(t *testing.T) {
+	a := flux.MustParseResourceID("default:deployment/a")
+	b := flux.MustParseResourceID("default:deployment/b")
+	spec := update.Spec{
+		Type:  update.Policy,
+		Cause: update.Cause{User: "jane", Message: "freeze"},
+		Spec: policy.Updates{
+			a: {Add: policy.Set{policy.Locked: "true", policy.TagPrefix("app"): "glob:*"}},
+			b: {Remove: policy.Set{policy.Automated: "true"}},
+		},
+	}
+	records := auditRecords(spec)
+	if assert.Len(t, records, 3) {
+		assert.Equal(t, audit.ActionLock, records[0].Action)
+		assert.Equal(t, audit.ActionPolicy, records[1].Action)
+		assert.Equal(t, []flux.ResourceID{a}, records[1].Workloads)
+		assert.Equal(t, audit.ActionDeautomate, records[2].Action)
+		assert.Equal(t, []flux.ResourceID{b}, records[2].Workloads)
+		assert.Equal(t, "jane", records[0].Actor)
+		assert.Equal(t, "freeze", records[0].Message)
+	}
+
+	release := update.Spec{
+		Type: update.Images,
+		Spec: update.ReleaseImageSpec{
+			ServiceSpecs: []update.ResourceSpec{update.ResourceSpecAll},
+			ImageSpec:    update.ImageSpecLatest,
+			Kind:         update.ReleaseKindExecute,
+		},
+	}
+	records = auditRecords(release)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, audit.ActionRelease, records[0].Action)
+		assert.Contains(t, records[0].Spec, "<all>")
+	}
+
+	plan := release
+	plan.Spec = update.ReleaseImageSpec{Kind: update.ReleaseKindPlan}
+	assert.Nil(t, auditRecords(plan))
+
+	// Automation isn't audited
+	assert.Nil(t, auditRecords(update.Spec{Type: update.Auto, Spec: update.Automated{}}))
+}
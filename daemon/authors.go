@@ -0,0 +1,92 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ryanuber/go-glob"
+)
+
+// Which identities of a commit have to be trusted, for it to be synced
+const (
+	TrustedAuthorsCheckAuthor    = "author"
+	TrustedAuthorsCheckCommitter = "committer"
+	TrustedAuthorsCheckBoth      = "both"
+)
+
+// TrustedAuthors says whose commits may be synced, going by the email
+// addresses of a commit's author and committer. The patterns are
+// globs, e.g., `*@example.com`, and are matched without regard to
+// case.
+type TrustedAuthors struct {
+	Emails []string
+	// If not empty, a file with more patterns, one per line (blank
+	// lines and those starting with `#` are skipped). It's read
+	// again at each sync, so the list can be changed (e.g., by
+	// updating the ConfigMap mounted as the file) without restarting
+	// fluxd. If it can't be read, nothing is synced.
+	File string
+	// Which of the commit's identities has to be trusted; one of
+	// the TrustedAuthorsCheck values, and the author if empty
+	Check string
+}
+
+// patterns gives all the patterns, reading the file afresh.
+func (t *TrustedAuthors) patterns() ([]string, error) {
+	patterns := append([]string(nil), t.Emails...)
+	if t.File == "" {
+		return patterns, nil
+	}
+	contents, err := ioutil.ReadFile(t.File)
+	if err != nil {
+		return nil, fmt.Errorf("reading trusted authors: %s", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// check returns an error naming whichever of the author and committer
+// given, that has to be trusted, isn't.
+func (t *TrustedAuthors) check(author, committer string) error {
+	patterns, err := t.patterns()
+	if err != nil {
+		return err
+	}
+	identities := map[string]string{"author": author}
+	switch t.Check {
+	case TrustedAuthorsCheckCommitter:
+		identities = map[string]string{"committer": committer}
+	case TrustedAuthorsCheckBoth:
+		identities["committer"] = committer
+	}
+	for _, role := range []string{"author", "committer"} {
+		email, ok := identities[role]
+		if !ok {
+			continue
+		}
+		if !matchesAny(patterns, email) {
+			return fmt.Errorf("%s %q is not a trusted author", role, email)
+		}
+	}
+	return nil
+}
+
+func matchesAny(patterns []string, email string) bool {
+	email = strings.ToLower(email)
+	for _, p := range patterns {
+		if glob.Glob(strings.ToLower(p), email) {
+			return true
+		}
+	}
+	return false
+}
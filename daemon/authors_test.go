@@ -0,0 +1,42 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrustedAuthors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-trusted-authors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "authors")
+	if err := ioutil.WriteFile(file, []byte("# bots\nbot@example.org\n\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	trusted := &TrustedAuthors{Emails: []string{"*@example.com"}, File: file}
+	assert.NoError(t, trusted.check("Jane@Example.com", "noreply@github.com"))
+	assert.NoError(t, trusted.check("bot@example.org", "bot@example.org"))
+	assert.Error(t, trusted.check("mallory@example.net", "jane@example.com"))
+
+	trusted.Check = TrustedAuthorsCheckCommitter
+	assert.NoError(t, trusted.check("mallory@example.net", "jane@example.com"))
+	trusted.Check = TrustedAuthorsCheckBoth
+	assert.Error(t, trusted.check("jane@example.com", "noreply@github.com"))
+
+	// The file is read again each time
+	if err := ioutil.WriteFile(file, []byte("noreply@github.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, trusted.check("jane@example.com", "noreply@github.com"))
+	assert.Error(t, trusted.check("bot@example.org", "bot@example.org"))
+
+	os.Remove(file)
+	assert.Error(t, trusted.check("jane@example.com", "jane@example.com"))
+}
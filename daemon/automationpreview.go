@@ -0,0 +1,105 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/api/v12"
+	"github.com/weaveworks/flux/api/v6"
+	"github.com/weaveworks/flux/policy"
+	"github.com/weaveworks/flux/update"
+)
+
+// AutomationPreview gives what automation would do were it to run
+// now: it plans automation as pollForNewImages does, but commits
+// nothing. Each automated workload is given, whether it would be
+// updated or not, with the reasons. The plan is made for all
+// namespaces, even if only one is given, since what's held back
+// depends on the workloads in the others.
+func (d *Daemon) AutomationPreview(ctx context.Context, opts v12.AutomationPreviewOptions) (v12.AutomationPreview, error) {
+	var preview v12.AutomationPreview
+
+	all, readOnly, err := d.getResources(ctx)
+	if err != nil {
+		return preview, err
+	}
+	switch readOnly {
+	case v6.ReadOnlyNotReady:
+		return preview, errors.New("the git repo is not ready, so there are no manifests to preview automation of")
+	case v6.ReadOnlyNoRepo:
+		return preview, errors.New("there is no git repo, so there are no manifests to preview automation of")
+	}
+
+	// The selection is logged as it is when automation runs; a
+	// preview shouldn't look like automation in the logs
+	plan, err := d.planAutomation(log.NewNopLogger(), all)
+	if err != nil {
+		return preview, err
+	}
+
+	previews := map[flux.ResourceID]*v12.WorkloadAutomationPreview{}
+	for id, selected := range plan.selections {
+		wp := &v12.WorkloadAutomationPreview{ID: id}
+		for _, s := range selected {
+			wp.Containers = append(wp.Containers, v12.ContainerAutomationPreview{
+				Name:    s.container.Name,
+				Current: s.container.Image,
+				Update:  s.update,
+				Target:  s.target,
+				Reason:  s.reason,
+			})
+		}
+		previews[id] = wp
+	}
+	for id, reason := range plan.heldBack {
+		wp, ok := previews[id]
+		if !ok {
+			wp = &v12.WorkloadAutomationPreview{ID: id}
+			previews[id] = wp
+		}
+		wp.HeldBack = reason
+	}
+	for id, wp := range previews {
+		if ns, _, _ := id.Components(); opts.Namespace != "" && ns != opts.Namespace {
+			continue
+		}
+		preview.Workloads = append(preview.Workloads, *wp)
+	}
+	sort.Slice(preview.Workloads, func(i, j int) bool {
+		return preview.Workloads[i].ID.String() < preview.Workloads[j].ID.String()
+	})
+	return preview, nil
+}
+
+// heldBackBetween gives the workloads with changes in before that
+// have none in after.
+func heldBackBetween(before, after *update.Automated) []flux.ResourceID {
+	kept := map[flux.ResourceID]bool{}
+	for _, change := range after.Changes {
+		kept[change.WorkloadID] = true
+	}
+	var held []flux.ResourceID
+	for _, id := range changedWorkloads(before) {
+		if !kept[id] {
+			held = append(held, id)
+		}
+	}
+	return held
+}
+
+// scheduleReason says why a workload with the policies given is held
+// back by its automation schedule at the time given.
+func scheduleReason(p policy.Set, now time.Time) string {
+	s, _ := p.Get(policy.AutomationSchedule)
+	schedule, err := policy.ParseSchedule(s)
+	if err != nil {
+		return fmt.Sprintf("invalid automation schedule: %s", err)
+	}
+	return fmt.Sprintf("outside its automation schedule %q, until %s", s, schedule.Next(now).Format(time.RFC3339))
+}
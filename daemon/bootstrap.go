@@ -0,0 +1,30 @@
+package daemon
+
+const (
+	// Never bootstrap (the default)
+	SyncBootstrapNever = "never"
+	// Bootstrap while there's no record of a revision having been
+	// synced, i.e., the sync tag or other sync state doesn't exist
+	// yet
+	SyncBootstrapAuto = "auto"
+	// Bootstrap each time the daemon starts, until it's done a full
+	// sync
+	SyncBootstrapAlways = "always"
+)
+
+// isBootstrap says whether the sync about to be done, after the last
+// synced revision given (if any), is to bootstrap the cluster; see
+// cluster.SyncSet. Once a full sync has succeeded, no sync is a
+// bootstrap, until the daemon is started again.
+func (d *Daemon) isBootstrap(syncedRevision string) bool {
+	if d.bootstrapped {
+		return false
+	}
+	switch d.SyncBootstrap {
+	case SyncBootstrapAlways:
+		return true
+	case SyncBootstrapAuto:
+		return syncedRevision == ""
+	}
+	return false
+}
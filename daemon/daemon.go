@@ -15,8 +15,10 @@ import (
 	"github.com/weaveworks/flux/api"
 	"github.com/weaveworks/flux/api/v10"
 	"github.com/weaveworks/flux/api/v11"
+	"github.com/weaveworks/flux/api/v12"
 	"github.com/weaveworks/flux/api/v6"
 	"github.com/weaveworks/flux/api/v9"
+	"github.com/weaveworks/flux/audit"
 	"github.com/weaveworks/flux/cluster"
 	"github.com/weaveworks/flux/event"
 	"github.com/weaveworks/flux/git"
@@ -27,6 +29,7 @@ import (
 	"github.com/weaveworks/flux/registry"
 	"github.com/weaveworks/flux/release"
 	"github.com/weaveworks/flux/resource"
+	fluxsync "github.com/weaveworks/flux/sync"
 	"github.com/weaveworks/flux/update"
 )
 
@@ -53,6 +56,21 @@ type Daemon struct {
 	JobStatusCache *job.StatusCache
 	EventWriter    event.EventWriter
 	Logger         log.Logger
+	// If not nil, this daemon syncs just the scope given; see
+	// `ForScope`.
+	Scope *git.Scope
+	// If not nil, changes requested through the API (e.g., locking
+	// a workload) are recorded here, along with their outcome
+	Audit audit.Sink
+	// If not nil, a record of each job is kept here, to be looked
+	// at through the API
+	History *job.History
+	// The configuration the daemon was started with, as reported
+	// through the API
+	Config v12.DaemonConfig
+	// The versions of kubectl and the API server, as reported
+	// through the API
+	Versions v12.ClusterVersions
 	// bookkeeping
 	*LoopVars
 }
@@ -64,6 +82,14 @@ func (d *Daemon) Version(ctx context.Context) (string, error) {
 	return d.V, nil
 }
 
+func (d *Daemon) DaemonConfig(ctx context.Context) (v12.DaemonConfig, error) {
+	return d.Config, nil
+}
+
+func (d *Daemon) ClusterVersions(ctx context.Context) (v12.ClusterVersions, error) {
+	return d.Versions, nil
+}
+
 func (d *Daemon) Ping(ctx context.Context) error {
 	return d.Cluster.Ping()
 }
@@ -72,12 +98,23 @@ func (d *Daemon) Export(ctx context.Context) ([]byte, error) {
 	return d.Cluster.Export()
 }
 
+// SyncedResources gives the resources this daemon's syncs have
+// applied to the cluster, of whatever kind; unlike Export, which
+// gives only the namespaces and workloads.
+func (d *Daemon) SyncedResources(ctx context.Context) ([]byte, error) {
+	exporter, ok := d.Cluster.(cluster.SyncedExporter)
+	if !ok {
+		return nil, errors.New("exporting synced resources is not supported for this cluster")
+	}
+	return exporter.ExportSynced(makeGitConfigHash(d.Repo.Origin(), d.GitConfig))
+}
+
 func (d *Daemon) getResources(ctx context.Context) (map[string]resource.Resource, v6.ReadOnlyReason, error) {
 	var resources map[string]resource.Resource
 	var globalReadOnly v6.ReadOnlyReason
 	err := d.WithClone(ctx, func(checkout *git.Checkout) error {
 		var err error
-		resources, err = d.Manifests.LoadManifests(checkout.Dir(), checkout.ManifestDirs())
+		resources, _, err = cluster.LoadManifestsSkipping(d.Manifests, checkout.Dir(), checkout.ManifestDirs())
 		return err
 	})
 
@@ -141,18 +178,21 @@ func (d *Daemon) ListServicesWithOptions(ctx context.Context, opts v11.ListServi
 			syncError = workload.SyncError.Error()
 		}
 		res = append(res, v6.ControllerStatus{
-			ID:         workload.ID,
-			Containers: containers2containers(workload.ContainersOrNil()),
-			ReadOnly:   readOnly,
-			Status:     workload.Status,
-			Rollout:    workload.Rollout,
-			SyncError:  syncError,
-			Antecedent: workload.Antecedent,
-			Labels:     workload.Labels,
-			Automated:  policies.Has(policy.Automated),
-			Locked:     policies.Has(policy.Locked),
-			Ignore:     policies.Has(policy.Ignore),
-			Policies:   policies.ToStringMap(),
+			ID:             workload.ID,
+			Containers:     containers2containers(workload.ContainersOrNil()),
+			ReadOnly:       readOnly,
+			Status:         workload.Status,
+			Rollout:        workload.Rollout,
+			SyncError:      syncError,
+			Antecedent:     workload.Antecedent,
+			Labels:         workload.Labels,
+			Automated:      policies.Has(policy.Automated),
+			Locked:         policies.Has(policy.Locked),
+			Ignore:         policies.Has(policy.Ignore) || workload.Policies.Has(policy.Ignore),
+			Policies:       policies.ToStringMap(),
+			SyncedRevision: d.syncedRevs.revision(workload.ID),
+			DeleteAfter:    workload.DeleteAfter,
+			SyncInterval:   d.resourceSchedule.interval(workload.ID),
 		})
 	}
 
@@ -255,12 +295,28 @@ func (d *Daemon) executeJob(id job.ID, do jobFunc, logger log.Logger) (job.Resul
 	ctx, cancel := context.WithTimeout(context.Background(), defaultJobTimeout)
 	defer cancel()
 	d.JobStatusCache.SetStatus(id, job.Status{StatusString: job.StatusRunning})
+	started := time.Now().UTC()
+	d.recordJob(logger, id, func(r *job.Record) {
+		r.Status = job.StatusRunning
+		r.Started = started
+	})
 	result, err := do(ctx, id, logger)
+	finished := time.Now().UTC()
 	if err != nil {
 		d.JobStatusCache.SetStatus(id, job.Status{StatusString: job.StatusFailed, Err: err.Error()})
+		d.recordJob(logger, id, func(r *job.Record) {
+			r.Status, r.Err = job.StatusFailed, err.Error()
+			r.Revision = result.Revision
+			r.Finished, r.Duration = finished, finished.Sub(started)
+		})
 		return result, err
 	}
 	d.JobStatusCache.SetStatus(id, job.Status{StatusString: job.StatusSucceeded, Result: result})
+	d.recordJob(logger, id, func(r *job.Record) {
+		r.Status = job.StatusSucceeded
+		r.Revision = result.Revision
+		r.Finished, r.Duration = finished, finished.Sub(started)
+	})
 	return result, nil
 }
 
@@ -301,10 +357,16 @@ func (d *Daemon) makeLoggingJobFunc(f jobFunc) jobFunc {
 	}
 }
 
-// queueJob queues a job func to be executed.
-func (d *Daemon) queueJob(do jobFunc) job.ID {
-	id := job.ID(guid.New())
+// queueJob queues a job func to be executed, for the update given.
+func (d *Daemon) queueJob(spec update.Spec, do jobFunc) job.ID {
+	return d.queueJobWithID(job.ID(guid.New()), spec, do)
+}
+
+// queueJobWithID is like queueJob, for when the job ID is needed
+// before the job is queued.
+func (d *Daemon) queueJobWithID(id job.ID, spec update.Spec, do jobFunc) job.ID {
 	enqueuedAt := time.Now()
+	d.recordJobQueued(id, spec, enqueuedAt)
 	d.Jobs.Enqueue(&job.Job{
 		ID: id,
 		Do: func(logger log.Logger) error {
@@ -331,14 +393,22 @@ func (d *Daemon) UpdateManifests(ctx context.Context, spec update.Spec) (job.ID,
 	case release.Changes:
 		if s.ReleaseKind() == update.ReleaseKindPlan {
 			id := job.ID(guid.New())
+			d.recordJobQueued(id, spec, time.Now())
 			_, err := d.executeJob(id, d.makeJobFromUpdate(d.release(spec, s)), d.Logger)
 			return id, err
 		}
-		return d.queueJob(d.makeLoggingJobFunc(d.makeJobFromUpdate(d.release(spec, s)))), nil
+		return d.queueAuditedJob(spec, d.makeLoggingJobFunc(d.makeJobFromUpdate(d.release(spec, s)))), nil
 	case policy.Updates:
-		return d.queueJob(d.makeLoggingJobFunc(d.makeJobFromUpdate(d.updatePolicy(spec, s)))), nil
+		return d.queueAuditedJob(spec, d.makeLoggingJobFunc(d.makeJobFromUpdate(d.updatePolicy(spec, s)))), nil
 	case update.ManualSync:
-		return d.queueJob(d.sync()), nil
+		if s.Namespace != "" {
+			return d.queueJob(spec, d.syncNamespace(s.Namespace)), nil
+		}
+		return d.queueJob(spec, d.sync()), nil
+	case update.ResetSync:
+		return d.queueAuditedJob(spec, d.resetSync(spec, s)), nil
+	case update.MarkSynced:
+		return d.queueAuditedJob(spec, d.markSynced(spec, s)), nil
 	default:
 		return id, fmt.Errorf(`unknown update type "%s"`, spec.Type)
 	}
@@ -362,6 +432,185 @@ func (d *Daemon) sync() jobFunc {
 	}
 }
 
+// syncNamespace applies the resources in a single namespace, as of
+// the HEAD of the branch. Unlike a full sync, this doesn't move the
+// sync tag (since resources elsewhere may not be applied yet), and
+// doesn't garbage collect.
+func (d *Daemon) syncNamespace(namespace string) jobFunc {
+	return func(ctx context.Context, jobID job.ID, logger log.Logger) (job.Result, error) {
+		var result job.Result
+		if !d.isSyncLeader() {
+			return result, errors.New("not syncing namespace; this instance is not the leader")
+		}
+		ctx, cancel := context.WithTimeout(ctx, defaultJobTimeout)
+		defer cancel()
+		if err := d.Repo.Refresh(ctx); err != nil {
+			return result, err
+		}
+		working, err := d.Repo.Clone(ctx, d.GitConfig)
+		if err != nil {
+			return result, err
+		}
+		defer working.Clean()
+		head, err := working.HeadRevision(ctx)
+		if err != nil {
+			return result, err
+		}
+		result.Revision = head
+		// HEAD is applied as it would be by a sync, so it has to
+		// pass the same checks
+		tagRev, err := working.SyncRevision(ctx)
+		if err != nil && !isUnknownRevision(err) {
+			return result, err
+		}
+		if pending := d.pendingSyncTag.get(); pending != "" {
+			tagRev = pending
+		}
+		if err := d.verifyRevision(ctx, logger, working, tagRev, head); err != nil {
+			return result, err
+		}
+
+		resources, skipped, err := cluster.LoadManifestsSkipping(d.Manifests, working.Dir(), working.ManifestDirs())
+		if err != nil {
+			return result, errors.Wrap(err, "loading resources from repo")
+		}
+		if skipped != nil {
+			logger.Log("err", "SKIPPING MANIFEST FILES: files are larger than the limit on their size, so are not synced, and nothing will be garbage collected", "files", skipped.Error())
+		}
+		var found bool
+		for _, res := range resources {
+			if ns, _, _ := res.ResourceID().Components(); ns == namespace {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return result, fmt.Errorf("no resources in namespace %q at revision %s", namespace, head)
+		}
+		logger = log.With(logger, "namespace", namespace, "revision", head)
+		_, err = d.applySync(logger, makeGitConfigHash(d.Repo.Origin(), d.GitConfig), resources, fluxsync.Options{
+			Revision:  head,
+			Namespace: namespace,
+			NoGC:      skipped != nil,
+		})
+		if err != nil {
+			syncerr, ok := err.(cluster.SyncError)
+			if !ok {
+				return result, err
+			}
+			result.Result = update.Result{}
+			for _, e := range syncerr {
+				result.Result[e.ResourceID] = update.WorkloadResult{
+					Status: update.ReleaseStatusFailed,
+					Error:  e.Error.Error(),
+				}
+			}
+		}
+		return result, nil
+	}
+}
+
+// resetSync moves the sync tag back to the revision given, which
+// must be in the history of the branch, then asks for a sync. Since
+// this makes the next sync re-apply everything changed since that
+// revision, it's logged as a warning, along with who asked for it.
+func (d *Daemon) resetSync(spec update.Spec, reset update.ResetSync) jobFunc {
+	return func(ctx context.Context, jobID job.ID, logger log.Logger) (job.Result, error) {
+		var result job.Result
+		if reset.Revision == "" {
+			return result, errors.New("no revision given to reset the sync tag to")
+		}
+		ctx, cancel := context.WithTimeout(ctx, defaultJobTimeout)
+		defer cancel()
+		rev, err := d.moveSyncTagTo(ctx, logger, spec, reset.Revision, "Sync pointer reset",
+			"resetting sync tag; everything changed since the new revision will be applied again")
+		if err != nil {
+			return result, err
+		}
+		d.AskForSync()
+		result.Revision = rev
+		return result, nil
+	}
+}
+
+// markSynced moves the sync tag to the revision given, which must be
+// in the history of the branch, without applying anything; it's for
+// when the manifests have been applied some other way. Nothing
+// changed up to that revision is applied because of it, so it's
+// logged as a warning, along with who asked for it.
+func (d *Daemon) markSynced(spec update.Spec, mark update.MarkSynced) jobFunc {
+	return func(ctx context.Context, jobID job.ID, logger log.Logger) (job.Result, error) {
+		var result job.Result
+		if mark.Revision == "" {
+			return result, errors.New("no revision given to mark as synced")
+		}
+		ctx, cancel := context.WithTimeout(ctx, defaultJobTimeout)
+		defer cancel()
+		rev, err := d.moveSyncTagTo(ctx, logger, spec, mark.Revision, "Sync pointer marked as synced",
+			"MARKING REVISION AS SYNCED WITHOUT APPLYING IT; changes up to the new revision are taken to have been applied already")
+		if err != nil {
+			return result, err
+		}
+		result.Revision = rev
+		return result, nil
+	}
+}
+
+// moveSyncTagTo moves the sync tag to the revision given, so long as
+// it's in the history of the branch, logging the warning given, and
+// returns the full revision.
+func (d *Daemon) moveSyncTagTo(ctx context.Context, logger log.Logger, spec update.Spec, revision, message, warning string) (string, error) {
+	if err := git.ValidateRevision(revision); err != nil {
+		return "", err
+	}
+	if err := d.Repo.Refresh(ctx); err != nil {
+		return "", err
+	}
+	working, err := d.Repo.Clone(ctx, d.GitConfig)
+	if err != nil {
+		return "", err
+	}
+	defer working.Clean()
+
+	rev, err := d.Repo.Revision(ctx, revision)
+	if err != nil {
+		return "", errors.Wrapf(err, "looking up revision %s", revision)
+	}
+	head, err := working.HeadRevision(ctx)
+	if err != nil {
+		return "", err
+	}
+	ok, err := working.IsAncestor(ctx, rev, head)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("revision %s is not in the history of branch %s", rev, d.GitConfig.Branch)
+	}
+	oldTagRev, err := d.lastSyncedRevision(ctx, working)
+	if err != nil {
+		return "", err
+	}
+
+	logger.Log("warning", warning,
+		"tag", d.GitConfig.SyncTagRef(), "old", oldTagRev, "new", rev, "user", spec.Cause.User, "message", spec.Cause.Message)
+	if err := working.MoveSyncTagAndPush(ctx, git.TagAction{
+		Revision: rev,
+		Message:  message,
+	}); err != nil {
+		return "", err
+	}
+	// Whatever was waiting to be pushed is superseded
+	d.pendingSyncTag.reset()
+	if err := dropPendingSyncState(ctx, working); err != nil {
+		logger.Log("warning", "could not remove pending sync revision from git", "err", err)
+	}
+	if err := d.Repo.Refresh(ctx); err != nil {
+		return "", err
+	}
+	return rev, nil
+}
+
 func (d *Daemon) updatePolicy(spec update.Spec, updates policy.Updates) updateFunc {
 	return func(ctx context.Context, jobID job.ID, working *git.Checkout, logger log.Logger) (job.Result, error) {
 		// For each update
@@ -435,7 +684,7 @@ func (d *Daemon) updatePolicy(spec update.Spec, updates policy.Updates) updateFu
 			// On the chance pushing failed because it was not
 			// possible to fast-forward, ask for a sync so the
 			// next attempt is more likely to succeed.
-			d.AskForSync()
+			d.askForSync(syncTriggerJob)
 			return result, err
 		}
 		if anythingAutomated {
@@ -477,6 +726,10 @@ func (d *Daemon) release(spec update.Spec, c release.Changes) updateFunc {
 				Message: commitMsg,
 			}
 			if err := working.CommitAndPush(ctx, commitAction, &note{JobID: jobID, Spec: spec, Result: result}); err != nil {
+				if err == git.ErrNoChanges && spec.Type == update.Auto {
+					// e.g., the manifests had already been updated
+					automationUnchanged.Add(1)
+				}
 				// On the chance pushing failed because it was not
 				// possible to fast-forward, ask the repo to fetch
 				// from upstream ASAP, so the next attempt is more
@@ -488,6 +741,11 @@ func (d *Daemon) release(spec update.Spec, c release.Changes) updateFunc {
 			if err != nil {
 				return zero, err
 			}
+			if spec.Type == update.Auto {
+				for _, id := range result.AffectedResources() {
+					automationCommits.With("workload", id.String()).Add(1)
+				}
+			}
 		}
 		return job.Result{
 			Revision: revision,
@@ -572,7 +830,11 @@ func (d *Daemon) JobStatus(ctx context.Context, jobID job.ID) (job.Status, error
 // you'll get all the commits yet to be applied. If you send a hash
 // and it's applied at or _past_ it, you'll get an empty list.
 func (d *Daemon) SyncStatus(ctx context.Context, commitRef string) ([]string, error) {
-	commits, err := d.Repo.CommitsBetween(ctx, d.GitConfig.SyncTag, commitRef, d.GitConfig.Paths...)
+	synced := d.GitConfig.SyncTagRef()
+	if pending := d.pendingSyncTag.get(); pending != "" {
+		synced = pending
+	}
+	commits, err := d.Repo.CommitsBetween(ctx, synced, commitRef, d.GitConfig.Paths...)
 	if err != nil {
 		return nil, err
 	}
@@ -592,11 +854,22 @@ func (d *Daemon) GitRepoConfig(ctx context.Context, regenerate bool) (v6.GitConf
 	}
 
 	origin := d.Repo.Origin()
-	status, _ := d.Repo.Status()
+	status, repoErr := d.Repo.Status()
+	var errMsg string
+	if repoErr != nil && status != git.RepoReady {
+		errMsg = repoErr.Error()
+	}
 	path := ""
 	if len(d.GitConfig.Paths) > 0 {
 		path = strings.Join(d.GitConfig.Paths, ",")
 	}
+	leadership := v6.SyncLeadershipNone
+	if d.Leader != nil {
+		leadership = v6.SyncLeadershipFollower
+		if d.Leader.IsLeader() {
+			leadership = v6.SyncLeadershipLeader
+		}
+	}
 	return v6.GitConfig{
 		Remote: v6.GitRemoteConfig{
 			URL:    origin.URL,
@@ -605,6 +878,8 @@ func (d *Daemon) GitRepoConfig(ctx context.Context, regenerate bool) (v6.GitConf
 		},
 		PublicSSHKey: publicSSHKey,
 		Status:       status,
+		Error:        errMsg,
+		Leadership:   leadership,
 	}, nil
 }
 
@@ -16,6 +16,7 @@ import (
 	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/api/v10"
 	"github.com/weaveworks/flux/api/v11"
+	"github.com/weaveworks/flux/api/v12"
 	"github.com/weaveworks/flux/api/v6"
 	"github.com/weaveworks/flux/api/v9"
 	"github.com/weaveworks/flux/cluster"
@@ -26,6 +27,8 @@ import (
 	"github.com/weaveworks/flux/git/gittest"
 	"github.com/weaveworks/flux/image"
 	"github.com/weaveworks/flux/job"
+	fluxmetrics "github.com/weaveworks/flux/metrics"
+	"github.com/weaveworks/flux/metrics/metricstest"
 	"github.com/weaveworks/flux/policy"
 	"github.com/weaveworks/flux/registry"
 	registryMock "github.com/weaveworks/flux/registry/mock"
@@ -546,6 +549,80 @@ func TestDaemon_SyncStatus(t *testing.T) {
 	w.ForSyncStatus(d, stat.Result.Revision, 0)
 }
 
+// When I move the sync tag, by resetting it or by marking a revision
+// as synced, it should move to the revision given, so long as that's
+// in the history of the branch; and only resetting should start a
+// sync
+func TestDaemon_MoveSyncTag(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		applies bool
+		job     func(d *Daemon, revision string) jobFunc
+	}{
+		{
+			name:    "reset",
+			applies: true,
+			job: func(d *Daemon, revision string) jobFunc {
+				reset := update.ResetSync{Revision: revision}
+				return d.resetSync(update.Spec{Type: update.Reset, Spec: reset}, reset)
+			},
+		},
+		{
+			name:    "mark",
+			applies: false,
+			job: func(d *Daemon, revision string) jobFunc {
+				mark := update.MarkSynced{Revision: revision}
+				return d.markSynced(update.Spec{Type: update.Mark, Spec: mark}, mark)
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			d, start, clean, _, _, _ := mockDaemon(t)
+			start()
+			defer clean()
+
+			ctx := context.Background()
+			if err := d.Repo.Ready(ctx); err != nil {
+				t.Fatal(err)
+			}
+			head, err := d.Repo.Revision(ctx, d.GitConfig.Branch)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			explicit := map[string]string{fluxmetrics.LabelTrigger: syncTriggerExplicit}
+			asked := metricstest.Value(t, "flux_daemon_sync_requests_total", explicit)
+			result, err := c.job(d, head)(ctx, job.ID(c.name), log.NewNopLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Revision != head {
+				t.Errorf("expected revision %s in result, got %s", head, result.Revision)
+			}
+			co, err := d.Repo.Clone(ctx, d.GitConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer co.Clean()
+			if rev, err := co.SyncRevision(ctx); err != nil {
+				t.Error(err)
+			} else if rev != head {
+				t.Errorf("expected sync tag at %s, got %s", head, rev)
+			}
+			if synced := metricstest.Value(t, "flux_daemon_sync_requests_total", explicit) > asked; synced != c.applies {
+				t.Errorf("expected a sync to be asked for: %v, but was: %v", c.applies, synced)
+			}
+
+			if _, err := c.job(d, "0123456789abcdef0123456789abcdef01234567")(ctx, job.ID("bad-"+c.name), log.NewNopLogger()); err == nil {
+				t.Error("expected error moving the sync tag to a revision that doesn't exist")
+			}
+			if _, err := c.job(d, "--upload-pack=touch /tmp/moved")(ctx, job.ID("option-"+c.name), log.NewNopLogger()); err == nil {
+				t.Error("expected error moving the sync tag to something that isn't a plain ref or SHA")
+			}
+		})
+	}
+}
+
 // When I restart fluxd, there won't be any jobs in the cache
 func TestDaemon_JobStatusWithNoCache(t *testing.T) {
 	d, start, clean, _, _, restart := mockDaemon(t)
@@ -594,6 +671,42 @@ func TestDaemon_Automated(t *testing.T) {
 	w.ForImageTag(t, d, wl, container, "2")
 }
 
+func TestDaemon_AutomationMetrics(t *testing.T) {
+	d, start, clean, k8s, _, _ := mockDaemon(t)
+	workloadLabels := map[string]string{"workload": wl}
+	commitsBefore := metricstest.Value(t, "flux_automation_commits_total", workloadLabels)
+	start()
+	defer clean()
+	w := newWait(t)
+
+	// updates from helloworld:master-xxx to helloworld:2, and counts
+	// the commit against the workload
+	w.ForImageTag(t, d, wl, container, "2")
+	w.Eventually(func() bool {
+		return metricstest.Value(t, "flux_automation_commits_total", workloadLabels) > commitsBefore
+	}, "Waiting for the automation commit to be counted")
+
+	// Once the newest image is running, there's nothing to update
+	k8s.SomeWorkloadsFunc = func([]flux.ResourceID) ([]cluster.Workload, error) {
+		return []cluster.Workload{{
+			ID: flux.MustParseResourceID(wl),
+			Containers: cluster.ContainersOrExcuse{
+				Containers: []resource.Container{
+					{
+						Name:  container,
+						Image: mustParseImageRef(newHelloImage),
+					},
+				},
+			},
+		}}, nil
+	}
+	unchangedBefore := metricstest.Value(t, "flux_automation_unchanged_total", nil)
+	d.pollForNewImages(log.NewNopLogger())
+	if unchanged := metricstest.Value(t, "flux_automation_unchanged_total", nil); unchanged <= unchangedBefore {
+		t.Errorf("expected a run with nothing to update to be counted, count went from %v to %v", unchangedBefore, unchanged)
+	}
+}
+
 func TestDaemon_Automated_semver(t *testing.T) {
 	d, start, clean, k8s, _, _ := mockDaemon(t)
 	start()
@@ -620,6 +733,64 @@ func TestDaemon_Automated_semver(t *testing.T) {
 	w.ForImageTag(t, d, resid.String(), container, "3")
 }
 
+func TestDaemon_AutomationPreview(t *testing.T) {
+	d, start, clean, k8s, _, _ := mockDaemon(t)
+	start()
+	defer clean()
+
+	resid := flux.MustParseResourceID("default:deployment/semver")
+	k8s.SomeWorkloadsFunc = func([]flux.ResourceID) ([]cluster.Workload, error) {
+		return []cluster.Workload{{
+			ID: resid,
+			Containers: cluster.ContainersOrExcuse{
+				Containers: []resource.Container{
+					{
+						Name:  container,
+						Image: mustParseImageRef(currentHelloImage),
+					},
+				},
+			},
+		}}, nil
+	}
+
+	ctx := context.Background()
+	before, err := d.Repo.Revision(ctx, d.GitConfig.Branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	preview, err := d.AutomationPreview(ctx, v12.AutomationPreviewOptions{Namespace: ns})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var semver *v12.WorkloadAutomationPreview
+	var notRunning int
+	for i, wp := range preview.Workloads {
+		if wp.ID == resid {
+			semver = &preview.Workloads[i]
+		}
+		if wp.HeldBack == "not running in the cluster" {
+			notRunning++
+		}
+	}
+	if semver == nil {
+		t.Fatalf("expected %s in the preview, got %#v", resid, preview.Workloads)
+	}
+	if semver.HeldBack != "" || len(semver.Containers) != 1 {
+		t.Fatalf("expected one container to be previewed for %s, got %#v", resid, semver)
+	}
+	// helloworld:3 is older than helloworld:2 but semver orders by version
+	if c := semver.Containers[0]; !c.Update || c.Target.Tag != "3" {
+		t.Errorf("expected an update to tag 3, got %#v", c)
+	}
+	if notRunning == 0 {
+		t.Errorf("expected the automated workloads not in the cluster to be held back, got %#v", preview.Workloads)
+	}
+	// Nothing is committed
+	if after, err := d.Repo.Revision(ctx, d.GitConfig.Branch); err != nil || after != before {
+		t.Errorf("expected the branch to stay at %s, got %s (%v)", before, after, err)
+	}
+}
+
 func makeImageInfo(ref string, t time.Time) image.Info {
 	return image.Info{ID: mustParseImageRef(ref), CreatedAt: t}
 }
@@ -743,7 +914,7 @@ func mockDaemon(t *testing.T) (*Daemon, func(), func(), *cluster.Mock, *mockEven
 		JobStatusCache: &job.StatusCache{Size: 100},
 		EventWriter:    events,
 		Logger:         logger,
-		LoopVars:       &LoopVars{GitOpTimeout: timeout},
+		LoopVars:       &LoopVars{LoopConfig: LoopConfig{GitOpTimeout: timeout}},
 	}
 
 	start := func() {
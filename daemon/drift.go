@@ -0,0 +1,99 @@
+package daemon
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster"
+	"github.com/weaveworks/flux/event"
+	fluxsync "github.com/weaveworks/flux/sync"
+)
+
+// reportDrift compares the resources at the last synced revision
+// with those in the cluster, without applying anything, and posts
+// an event listing those that differ. If any do, it asks for a full
+// sync, which puts them back as they are in git.
+func (d *Daemon) reportDrift(logger log.Logger) error {
+	detector, ok := d.Cluster.(cluster.DriftDetector)
+	if !ok {
+		return errors.New("drift detection is not supported for this cluster")
+	}
+	started := time.Now().UTC()
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.GitOpTimeout)
+	defer cancel()
+	rev, err := d.Repo.Revision(ctx, d.GitConfig.SyncTagRef())
+	if pending := d.pendingSyncTag.get(); pending != "" {
+		rev, err = pending, nil
+	}
+	if err != nil {
+		if isUnknownRevision(err) {
+			logger.Log("info", "not reporting drift; nothing has been synced yet")
+			return nil
+		}
+		return err
+	}
+	export, err := d.Repo.Export(ctx, rev)
+	if err != nil {
+		return err
+	}
+	defer export.Clean()
+
+	resources, skipped, err := cluster.LoadManifestsSkipping(d.Manifests, export.Dir(), d.manifestPaths(export.Dir()))
+	if err != nil {
+		return errors.Wrap(err, "loading resources from repo")
+	}
+	if skipped != nil {
+		logger.Log("warning", "not reporting drift of the resources in manifest files that are too large", "files", skipped.Error())
+	}
+
+	drifts, err := fluxsync.Drift(makeGitConfigHash(d.Repo.Origin(), d.GitConfig), resources, detector)
+	if err != nil {
+		return err
+	}
+	metadata := &event.DriftEventMetadata{Revision: rev}
+	var ids []flux.ResourceID
+	for _, drift := range drifts {
+		// Garbage is for the next sync to delete, rather than drift
+		if drift.Deleted {
+			continue
+		}
+		metadata.Drifted = append(metadata.Drifted, event.DriftedResource{
+			ID:      drift.ResourceID,
+			Path:    drift.Source,
+			Missing: drift.Missing,
+			Diff:    drift.Diff,
+		})
+		ids = append(ids, drift.ResourceID)
+	}
+	logger.Log("info", "drift report", "revision", rev, "drifted", len(ids))
+	if len(ids) > 0 {
+		d.askForFullSync(syncTriggerDrift)
+	}
+	return d.LogEvent(event.Event{
+		ServiceIDs: ids,
+		Type:       event.EventDrift,
+		StartedAt:  started,
+		EndedAt:    time.Now().UTC(),
+		LogLevel:   event.LogLevelInfo,
+		Metadata:   metadata,
+	})
+}
+
+// manifestPaths gives the paths manifests are loaded from, in a
+// clone of the repo in dir.
+func (d *Daemon) manifestPaths(dir string) []string {
+	if len(d.GitConfig.Paths) == 0 {
+		return []string{dir}
+	}
+	paths := make([]string, len(d.GitConfig.Paths))
+	for i, p := range d.GitConfig.Paths {
+		paths[i] = filepath.Join(dir, p)
+	}
+	return paths
+}
@@ -0,0 +1,151 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/cluster"
+	"github.com/weaveworks/flux/resource"
+)
+
+const (
+	// Wait for all the workloads in the manifests to be ready
+	SyncHealthScopeAll = "all"
+	// Wait only for the workloads changed since the last sync
+	SyncHealthScopeChanged = "changed"
+)
+
+// How often workloads are checked while waiting for them to be ready,
+// unless the health poll interval is set.
+const defaultSyncHealthPollInterval = 5 * time.Second
+
+// healthGateWorkloads gives the IDs of the workloads that must be
+// ready before the sync tag is moved: those in the manifests, or
+// only those changed, according to the scope; and only those in the
+// namespaces given, if any are. The other resources in scope are
+// given too, since the cluster may be able to tell whether some of
+// them are ready (see cluster.ReadinessChecker).
+func (d *Daemon) healthGateWorkloads(all map[string]resource.Resource, changed flux.ResourceIDSet) ([]flux.ResourceID, []resource.Resource) {
+	namespaces := map[string]bool{}
+	for _, ns := range d.SyncHealthNamespaces {
+		namespaces[ns] = true
+	}
+	var ids []flux.ResourceID
+	var others []resource.Resource
+	for _, res := range all {
+		id := res.ResourceID()
+		if d.SyncHealthScope == SyncHealthScopeChanged && !changed.Contains(id) {
+			continue
+		}
+		if ns, _, _ := id.Components(); len(namespaces) > 0 && !namespaces[ns] {
+			continue
+		}
+		if _, ok := res.(resource.Workload); !ok {
+			others = append(others, res)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].String() < ids[j].String()
+	})
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].ResourceID().String() < others[j].ResourceID().String()
+	})
+	return ids, others
+}
+
+// unhealthy gives the workloads that aren't ready, with the reason
+// for each.
+func unhealthy(workloads []cluster.Workload) map[flux.ResourceID]string {
+	reasons := map[flux.ResourceID]string{}
+	for _, w := range workloads {
+		switch {
+		case len(w.Rollout.Messages) > 0:
+			reasons[w.ID] = strings.Join(w.Rollout.Messages, "; ")
+		case w.Status == cluster.StatusError:
+			reasons[w.ID] = "in error"
+		case rolloutInProgress(w):
+			reasons[w.ID] = fmt.Sprintf("rollout in progress (%d of %d ready)", w.Rollout.Ready, w.Rollout.Desired)
+		}
+	}
+	return reasons
+}
+
+// unreadyError is returned from awaitHealthy when workloads weren't
+// ready in time.
+type unreadyError struct {
+	// the reason each workload wasn't ready
+	reasons map[flux.ResourceID]string
+	timeout time.Duration
+}
+
+func (e *unreadyError) Error() string {
+	var failed []string
+	for id := range e.reasons {
+		failed = append(failed, id.String())
+	}
+	sort.Strings(failed)
+	return fmt.Sprintf("%d workloads were not ready after %s, so not moving the sync tag: %s", len(failed), e.timeout, strings.Join(failed, ", "))
+}
+
+// awaitHealthy waits until the workloads given are all ready, along
+// with those of the other resources given that the cluster can tell
+// the readiness of, or the health timeout elapses, in which case it
+// returns an error naming those that aren't (an *unreadyError).
+// Resources not found in the cluster are not waited for.
+func (d *Daemon) awaitHealthy(ctx context.Context, logger log.Logger, ids []flux.ResourceID, others []resource.Resource) error {
+	checker, _ := d.Cluster.(cluster.ReadinessChecker)
+	if checker == nil {
+		others = nil
+	}
+	if len(ids) == 0 && len(others) == 0 {
+		return nil
+	}
+	interval := d.syncHealthPollInterval
+	if interval == 0 {
+		interval = defaultSyncHealthPollInterval
+	}
+	started := time.Now()
+	deadline := started.Add(d.SyncHealthTimeout)
+	logger.Log("info", "waiting for workloads to be ready before moving the sync tag", "workloads", len(ids), "timeout", d.SyncHealthTimeout)
+	for {
+		workloads, err := d.Cluster.SomeWorkloads(ids)
+		if err != nil {
+			return err
+		}
+		reasons := unhealthy(workloads)
+		if len(others) > 0 {
+			notReady, err := checker.NotReady(others)
+			if err != nil {
+				return err
+			}
+			for id, reason := range notReady {
+				reasons[id] = reason
+			}
+		}
+		if len(reasons) == 0 {
+			healthGateDuration.Observe(time.Since(started).Seconds())
+			logger.Log("info", "workloads ready", "workloads", len(ids), "took", time.Since(started))
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			healthGateTimeouts.Add(1)
+			for id, reason := range reasons {
+				logger.Log("workload", id, "err", reason)
+			}
+			return &unreadyError{reasons: reasons, timeout: d.SyncHealthTimeout}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -3,14 +3,20 @@ package daemon
 import (
 	"context"
 	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
 
 	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/cluster"
+	"github.com/weaveworks/flux/git"
+	"github.com/weaveworks/flux/image"
 	"github.com/weaveworks/flux/policy"
 	"github.com/weaveworks/flux/resource"
+	"github.com/weaveworks/flux/signature"
 	"github.com/weaveworks/flux/update"
 )
 
@@ -19,32 +25,29 @@ func (d *Daemon) pollForNewImages(logger log.Logger) {
 
 	ctx := context.Background()
 
-	candidateWorkloads, err := d.getAllowedAutomatedResources(ctx)
+	all, _, err := d.getResources(ctx)
 	if err != nil {
-		logger.Log("error", errors.Wrap(err, "getting unlocked automated resources"))
+		logger.Log("error", errors.Wrap(err, "getting automated resources"))
 		return
 	}
-	if len(candidateWorkloads) == 0 {
-		logger.Log("msg", "no automated workloads")
-		return
-	}
-	// Find images to check
-	workloads, err := d.Cluster.SomeWorkloads(candidateWorkloads.IDs())
+	plan, err := d.planAutomation(logger, all)
 	if err != nil {
-		logger.Log("error", errors.Wrap(err, "checking workloads for new images"))
+		logger.Log("error", err)
 		return
 	}
-	// Check the latest available image(s) for each workload
-	imageRepos, err := update.FetchImageRepos(d.Registry, clusterContainers(workloads), logger)
-	if err != nil {
-		logger.Log("error", errors.Wrap(err, "fetching image updates"))
+	automationRollouts.Set(float64(plan.rollouts))
+	if len(plan.candidates) == 0 {
+		logger.Log("msg", "no automated workloads")
 		return
 	}
+	for _, budget := range plan.paced {
+		automationPaced.With("budget", budget).Add(1)
+	}
 
-	changes := calculateChanges(logger, candidateWorkloads, workloads, imageRepos)
-
-	if len(changes.Changes) > 0 {
-		d.UpdateManifests(ctx, update.Spec{Type: update.Auto, Spec: changes})
+	if len(plan.changes.Changes) > 0 {
+		d.UpdateManifests(ctx, update.Spec{Type: update.Auto, Spec: plan.changes})
+	} else {
+		automationUnchanged.Add(1)
 	}
 }
 
@@ -57,59 +60,471 @@ func (r resources) IDs() (ids []flux.ResourceID) {
 	return ids
 }
 
-// getAllowedAutomatedResources returns all the resources that are
-// automated but do not have policies set to restrain them from
-// getting updated.
-func (d *Daemon) getAllowedAutomatedResources(ctx context.Context) (resources, error) {
-	resources, _, err := d.getResources(ctx)
+// automationPlan is what automation would do, were it to run now:
+// the changes it would make, and why it would make none to the other
+// automated workloads.
+type automationPlan struct {
+	// the automated workloads that can be updated, i.e., that aren't
+	// locked or ignored
+	candidates resources
+	// the images selected for the containers of each of the
+	// candidates running in the cluster
+	selections map[flux.ResourceID][]imageSelection
+	changes    *update.Automated
+	// the reason each automated workload without changes is held
+	// back, if it's for other than there being no newer images
+	heldBack map[flux.ResourceID]string
+	// the workloads held back by a disruption budget, with the budget
+	paced map[flux.ResourceID]string
+	// the number of automated workloads with a rollout in progress
+	rollouts int
+}
+
+// planAutomation works out what automation would do with the
+// resources given: it selects images for the automated workloads, and
+// holds updates back for their schedules, disruption budgets and the
+// rollouts in progress. It's used both to run automation and to
+// preview it, so that the preview is what automation would do.
+func (d *Daemon) planAutomation(logger log.Logger, all map[string]resource.Resource) (automationPlan, error) {
+	plan := automationPlan{
+		candidates: resources{},
+		changes:    &update.Automated{},
+		heldBack:   map[flux.ResourceID]string{},
+	}
+	var automated []flux.ResourceID
+	for _, res := range all {
+		id := res.ResourceID()
+		policies := res.Policies()
+		switch {
+		case !policies.Has(policy.Automated):
+			continue
+		case policies.Has(policy.Ignore):
+			plan.heldBack[id] = "ignored"
+		case policies.Has(policy.Locked):
+			plan.heldBack[id] = "locked"
+		default:
+			plan.candidates[id] = res
+		}
+		automated = append(automated, id)
+	}
+	if len(automated) == 0 {
+		return plan, nil
+	}
+
+	// All the automated workloads are looked at, even those that
+	// can't be updated, since any of them can be rolling out
+	workloads, err := d.Cluster.SomeWorkloads(automated)
+	if err != nil {
+		return plan, errors.Wrap(err, "checking workloads for new images")
+	}
+	var candidateWorkloads []cluster.Workload
+	for _, workload := range workloads {
+		if rolloutInProgress(workload) {
+			plan.rollouts++
+		}
+		if _, ok := plan.candidates[workload.ID]; ok {
+			candidateWorkloads = append(candidateWorkloads, workload)
+		}
+	}
+	if len(plan.candidates) == 0 {
+		return plan, nil
+	}
+
+	// Check the latest available image(s) for each workload
+	imageRepos, err := update.FetchImageRepos(d.Registry, clusterContainers(candidateWorkloads), logger)
 	if err != nil {
-		return nil, err
+		return plan, errors.Wrap(err, "fetching image updates")
+	}
+	changes, selections := calculateChanges(logger, plan.candidates, candidateWorkloads, imageRepos, d.ImageSignatures)
+	plan.selections = selections
+	for _, workload := range candidateWorkloads {
+		if _, err := workload.ContainersOrError(); err != nil {
+			plan.heldBack[workload.ID] = err.Error()
+		}
+	}
+	for id := range plan.candidates {
+		if _, ok := selections[id]; !ok {
+			if _, ok := plan.heldBack[id]; !ok {
+				plan.heldBack[id] = "not running in the cluster"
+			}
+		}
 	}
 
-	result := map[flux.ResourceID]resource.Resource{}
-	for _, resource := range resources {
-		policies := resource.Policies()
-		if policies.Has(policy.Automated) && !policies.Has(policy.Locked) && !policies.Has(policy.Ignore) {
-			result[resource.ResourceID()] = resource
+	now := time.Now()
+	scheduled := deferUnscheduled(logger, changes, plan.candidates, now)
+	for _, id := range heldBackBetween(changes, scheduled) {
+		plan.heldBack[id] = scheduleReason(plan.candidates[id].Policies(), now)
+	}
+	changes = scheduled
+	if d.AutomationRespectPDBs && len(changes.Changes) > 0 {
+		if budgeter, ok := d.Cluster.(cluster.DisruptionBudgeter); ok {
+			budgets, err := budgeter.DisruptionBudgets(changedWorkloads(changes))
+			if err != nil {
+				return plan, errors.Wrap(err, "checking disruption budgets")
+			}
+			changes, plan.paced = paceForBudgets(logger, changes, budgets)
+			for id, budget := range plan.paced {
+				plan.heldBack[id] = fmt.Sprintf("disruption budget %s allows no more disruptions", budget)
+			}
+		} else {
+			logger.Log("warning", "cluster does not report disruption budgets; automated updates are not paced")
 		}
 	}
-	return result, nil
+	var unsynced flux.ResourceIDSet
+	if d.AutomationMaxRollouts > 0 && len(changes.Changes) > 0 {
+		unsynced, err = d.unsyncedChanges(context.Background())
+		if err != nil {
+			return plan, errors.Wrap(err, "finding changes not yet synced")
+		}
+	}
+	limited := limitRollouts(logger, changes, workloads, unsynced, d.AutomationMaxRollouts)
+	for _, id := range heldBackBetween(changes, limited) {
+		plan.heldBack[id] = fmt.Sprintf("waiting for rollouts in progress, since no more than %d automated workloads roll out at once", d.AutomationMaxRollouts)
+	}
+	plan.changes = limited
+	return plan, nil
 }
 
-func calculateChanges(logger log.Logger, candidateWorkloads resources, workloads []cluster.Workload, imageRepos update.ImageRepos) *update.Automated {
+// imageSelection is the image selected for a container, if any, and
+// the reason it was or wasn't.
+type imageSelection struct {
+	container resource.Container
+	target    image.Ref
+	update    bool
+	reason    string
+}
+
+// calculateChanges works out which containers in the workloads given
+// can be updated to newer images. If signatures isn't nil, images
+// that don't pass it aren't considered. As well as the changes, it
+// gives what was selected for each container of each workload.
+func calculateChanges(logger log.Logger, candidateWorkloads resources, workloads []cluster.Workload, imageRepos update.ImageRepos, signatures signature.Verifier) (*update.Automated, map[flux.ResourceID][]imageSelection) {
 	changes := &update.Automated{}
+	selections := map[flux.ResourceID][]imageSelection{}
 
 	for _, workload := range workloads {
 		var p policy.Set
 		if resource, ok := candidateWorkloads[workload.ID]; ok {
 			p = resource.Policies()
 		}
-	containers:
+		selected := []imageSelection{}
 		for _, container := range workload.ContainersOrNil() {
-			currentImageID := container.Image
-			pattern := policy.GetTagPattern(p, container.Name)
-			repo := currentImageID.Name
-			logger := log.With(logger, "workload", workload.ID, "container", container.Name, "repo", repo, "pattern", pattern, "current", currentImageID)
-
-			images := imageRepos.GetRepoImages(repo)
-			filteredImages := images.FilterAndSort(pattern)
-
-			if latest, ok := filteredImages.Latest(); ok && latest.ID != currentImageID {
-				if latest.ID.Tag == "" {
-					logger.Log("warning", "untagged image in available images", "action", "skip container")
-					continue containers
-				}
-				current := images.FindWithRef(currentImageID)
-				if current.CreatedAt.IsZero() || latest.CreatedAt.IsZero() {
-					logger.Log("warning", "image with zero created timestamp", "current", fmt.Sprintf("%s (%s)", current.ID, current.CreatedAt), "latest", fmt.Sprintf("%s (%s)", latest.ID, latest.CreatedAt), "action", "skip container")
-					continue containers
-				}
-				newImage := currentImageID.WithNewTag(latest.ID.Tag)
+			newImage, ok, reason := selectImage(logger, workload.ID, p, container, imageRepos, signatures)
+			selected = append(selected, imageSelection{container: container, target: newImage, update: ok, reason: reason})
+			if ok {
 				changes.Add(workload.ID, container, newImage)
-				logger.Log("info", "added update to automation run", "new", newImage, "reason", fmt.Sprintf("latest %s (%s) > current %s (%s)", latest.ID.Tag, latest.CreatedAt, currentImageID.Tag, current.CreatedAt))
 			}
 		}
+		selections[workload.ID] = selected
+	}
+
+	return changes, selections
+}
+
+// selectImage works out whether the container given, in the workload
+// with the policies given, can be updated to a newer image; and gives
+// the image if so, along with the reason it can or can't be.
+func selectImage(logger log.Logger, workloadID flux.ResourceID, p policy.Set, container resource.Container, imageRepos update.ImageRepos, signatures signature.Verifier) (image.Ref, bool, string) {
+	if !policy.ContainerAutomated(p, container.Name) {
+		logger.Log("debug", "container is excluded from automation", "workload", workloadID, "container", container.Name)
+		return image.Ref{}, false, "container is excluded from automation"
 	}
+	currentImageID := container.Image
+	pattern := policy.GetTagPattern(p, container.Name)
+	repo := currentImageID.Name
+	logger = log.With(logger, "workload", workloadID, "container", container.Name, "repo", repo, "pattern", pattern, "current", currentImageID)
+
+	images := imageRepos.GetRepoImages(repo)
+	filteredImages := images.FilterAndSort(pattern)
 
-	return changes
+	latest, ok := filteredImages.Latest()
+	if !ok {
+		return image.Ref{}, false, fmt.Sprintf("no images in the registry match the tag filter %s", pattern)
+	}
+	if signatures != nil {
+		if latest, ok = latestSigned(logger, signatures, filteredImages, currentImageID, images.FindWithRef(currentImageID)); !ok {
+			return image.Ref{}, false, "no image newer than the current one is signed as required"
+		}
+	}
+	pin := update.ShouldPinDigest(workloadID, p)
+	mustSign := signatures != nil && signatures.Requires(repo)
+	if mustSign {
+		// What was verified is the image with the digest; were only
+		// the tag written, it could be moved to an image that isn't
+		// signed, so the digest is pinned whatever the policy
+		if !update.ShouldPinDigest(workloadID, p.Add(policy.PinDigest)) {
+			logger.Log("warning", "images that must be signed are pinned to their digests, which can't be done in a helm release; not updating")
+			return image.Ref{}, false, "images that must be signed are pinned to their digests, which can't be done in a helm release"
+		}
+		pin = true
+	}
+	if p.Has(policy.PinDigest) && !pin {
+		logger.Log("warning", "images in helm releases cannot be pinned to digests; updating tag only")
+	}
+	if pin && latest.Digest == "" {
+		logger.Log("warning", "registry gave no digest for image; not pinning", "image", latest.ID)
+	}
+	newImage, changed := update.TargetImage(currentImageID, latest, pin)
+	if !changed {
+		return image.Ref{}, false, fmt.Sprintf("already running the latest image matching the tag filter %s", pattern)
+	}
+	if mustSign && latest.Digest == "" {
+		return image.Ref{}, false, fmt.Sprintf("the registry gave no digest for %s, to pin the signed image to", latest.ID)
+	}
+	if latest.ID.Tag == "" {
+		logger.Log("warning", "untagged image in available images", "action", "skip container")
+		return image.Ref{}, false, fmt.Sprintf("the latest image, %s, is untagged", latest.ID)
+	}
+	if newImage.Tag == currentImageID.Tag {
+		// Only the digest has changed; i.e., the tag was
+		// pushed again, so the image is newer regardless
+		reason := fmt.Sprintf("digest of %s changed from %s", latest.ID.Tag, currentImageID.Digest)
+		logger.Log("info", "added update to automation run", "new", newImage, "reason", reason)
+		return newImage, true, reason
+	}
+	current := images.FindWithRef(currentImageID)
+	if current.CreatedAt.IsZero() || latest.CreatedAt.IsZero() {
+		logger.Log("warning", "image with zero created timestamp", "current", fmt.Sprintf("%s (%s)", current.ID, current.CreatedAt), "latest", fmt.Sprintf("%s (%s)", latest.ID, latest.CreatedAt), "action", "skip container")
+		return image.Ref{}, false, "the registry gives no created timestamp for the current or the latest image, so they can't be compared"
+	}
+	reason := fmt.Sprintf("latest %s (%s) > current %s (%s)", latest.ID.Tag, latest.CreatedAt, currentImageID.Tag, current.CreatedAt)
+	logger.Log("info", "added update to automation run", "new", newImage, "reason", reason)
+	return newImage, true, reason
+}
+
+// latestSigned returns the newest of the images given that passes
+// signature verification, going no further back than the current
+// image (or, if it isn't among them, than when it was created); so a
+// container is never moved to an older image because the newer ones
+// aren't signed. It returns false if there's no such image newer
+// than the current one. Verification results are cached by the
+// verifier, so it's cheap to ask again about the same images at each
+// poll.
+func latestSigned(logger log.Logger, signatures signature.Verifier, images update.SortedImageInfos, current image.Ref, currentInfo image.Info) (image.Info, bool) {
+	for _, img := range images {
+		if !currentInfo.CreatedAt.IsZero() && img.CreatedAt.Before(currentInfo.CreatedAt) {
+			return image.Info{}, false
+		}
+		isCurrent := img.ID.Tag == current.Tag
+		if isCurrent && (img.Digest == "" || img.Digest == current.Digest) {
+			// nothing newer was signed; this gives no change
+			return img, true
+		}
+		err := signatures.Verify(context.Background(), img)
+		switch err.(type) {
+		case nil:
+			return img, true
+		case *signature.UnsignedError:
+			automationUnsigned.With("registry", img.ID.Registry()).Add(1)
+			logger.Log("info", "passing over image that is not signed as required", "image", img.ID, "digest", img.Digest, "reason", err)
+		default:
+			logger.Log("warning", "could not verify image signature; passing over image", "image", img.ID, "err", err)
+		}
+		if isCurrent {
+			return image.Info{}, false
+		}
+	}
+	return image.Info{}, false
+}
+
+// deferUnscheduled leaves out the changes to workloads that have an
+// automation schedule, if the time given is outside it; they're made
+// by the first automation run once the schedule allows. A workload
+// with a schedule that can't be parsed isn't updated at all, since
+// it's better to hold back than update at a time it shouldn't be.
+func deferUnscheduled(logger log.Logger, changes *update.Automated, candidateWorkloads resources, now time.Time) *update.Automated {
+	allowed := map[flux.ResourceID]bool{}
+	scheduled := &update.Automated{}
+	for _, change := range changes.Changes {
+		id := change.WorkloadID
+		ok, seen := allowed[id]
+		if !seen {
+			ok = true
+			if res, found := candidateWorkloads[id]; found {
+				if s, hasSchedule := res.Policies().Get(policy.AutomationSchedule); hasSchedule {
+					schedule, err := policy.ParseSchedule(s)
+					switch {
+					case err != nil:
+						ok = false
+						logger.Log("warning", "not updating workload with invalid automation schedule", "workload", id, "err", err)
+					case !schedule.Contains(now):
+						ok = false
+						logger.Log("info", "deferring automated update until the workload's schedule allows", "workload", id, "schedule", s, "next", schedule.Next(now).Format(time.RFC3339))
+					}
+				}
+			}
+			allowed[id] = ok
+		}
+		if ok {
+			scheduled.Changes = append(scheduled.Changes, change)
+		}
+	}
+	return scheduled
+}
+
+// rolloutInProgress reports whether a workload is part way through
+// rolling out a new definition.
+func rolloutInProgress(workload cluster.Workload) bool {
+	return workload.Status == cluster.StatusStarted || workload.Status == cluster.StatusUpdating
+}
+
+// unsyncedChanges gives the IDs of the resources in manifest files
+// changed since the last synced revision. Those include workloads
+// updated by earlier automation runs, which will start rolling out
+// once they're synced, even though the cluster doesn't show them as
+// rolling out yet.
+func (d *Daemon) unsyncedChanges(ctx context.Context) (flux.ResourceIDSet, error) {
+	changed := flux.ResourceIDSet{}
+	err := d.WithClone(ctx, func(checkout *git.Checkout) error {
+		rev, err := d.lastSyncedRevision(ctx, checkout)
+		if err != nil || rev == "" {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(ctx, d.GitOpTimeout)
+		files, err := checkout.ChangedFiles(ctx, rev)
+		cancel()
+		if err != nil {
+			return err
+		}
+		var manifests []string
+		for _, file := range files {
+			switch filepath.Ext(file) {
+			case ".yaml", ".yml":
+				manifests = append(manifests, file)
+			}
+		}
+		if len(manifests) == 0 {
+			return nil
+		}
+		resources, _, err := cluster.LoadManifestsSkipping(d.Manifests, checkout.Dir(), manifests)
+		if err != nil {
+			return err
+		}
+		for _, res := range resources {
+			changed.Add([]flux.ResourceID{res.ResourceID()})
+		}
+		return nil
+	})
+	return changed, err
+}
+
+// limitRollouts trims the changes given, if necessary, so that
+// applying them won't result in more than `max` of the workloads
+// rolling out at once. Workloads count as rolling out if the cluster
+// says they are, or if they're among those changed in commits not
+// yet synced, since those will roll out once synced. Changes to
+// workloads that are already rolling out are always kept, since they
+// don't add to the number; other changes are kept in order of
+// workload ID until the limit is reached, and the rest are left for a
+// later automation run. If max is zero, the changes are returned as
+// they are.
+func limitRollouts(logger log.Logger, changes *update.Automated, workloads []cluster.Workload, unsynced flux.ResourceIDSet, max int) *update.Automated {
+	inProgress := map[flux.ResourceID]bool{}
+	for _, workload := range workloads {
+		if rolloutInProgress(workload) || unsynced.Contains(workload.ID) {
+			inProgress[workload.ID] = true
+		}
+	}
+	if max <= 0 {
+		return changes
+	}
+
+	sorted := make([]update.Change, len(changes.Changes))
+	copy(sorted, changes.Changes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].WorkloadID.String() < sorted[j].WorkloadID.String()
+	})
+
+	limited := &update.Automated{}
+	admitted := map[flux.ResourceID]bool{}
+	deferred := map[flux.ResourceID]bool{}
+	for _, change := range sorted {
+		id := change.WorkloadID
+		switch {
+		case inProgress[id] || admitted[id]:
+		case len(inProgress)+len(admitted) < max:
+			admitted[id] = true
+		default:
+			deferred[id] = true
+			continue
+		}
+		limited.Changes = append(limited.Changes, change)
+	}
+	if len(deferred) > 0 {
+		logger.Log("info", "holding back automated updates until rollouts in progress complete", "in-progress", len(inProgress), "max", max, "deferred", len(deferred))
+	}
+	return limited
+}
+
+// changedWorkloads gives the workloads changed, each once.
+func changedWorkloads(changes *update.Automated) []flux.ResourceID {
+	var ids []flux.ResourceID
+	seen := map[flux.ResourceID]bool{}
+	for _, change := range changes.Changes {
+		if !seen[change.WorkloadID] {
+			seen[change.WorkloadID] = true
+			ids = append(ids, change.WorkloadID)
+		}
+	}
+	return ids
+}
+
+// paceForBudgets admits the changes given to workloads in order of
+// ID, holding back those to workloads covered by a budget with no
+// disruptions left to allow. Each change admitted uses up one of the
+// disruptions allowed by each budget covering the workload, so no
+// more workloads under a budget are updated at once than it allows.
+// A budget that allows no disruptions even with all its pods healthy
+// would hold its workloads back for good, so it's taken to allow one,
+// with a warning. The workloads held back are given with the budget
+// that held each back.
+func paceForBudgets(logger log.Logger, changes *update.Automated, budgets []cluster.DisruptionBudget) (*update.Automated, map[flux.ResourceID]string) {
+	remaining := map[string]int{}
+	covering := map[flux.ResourceID][]string{}
+	for _, budget := range budgets {
+		remaining[budget.Name] = budget.Allowed
+		if budget.Allowed <= 0 && budget.Healthy {
+			logger.Log("warning", "disruption budget allows no disruptions with all its pods healthy; updating one automated workload under it at a time regardless", "budget", budget.Name)
+			remaining[budget.Name] = 1
+		}
+		for _, id := range budget.Workloads {
+			covering[id] = append(covering[id], budget.Name)
+		}
+	}
+
+	sorted := make([]update.Change, len(changes.Changes))
+	copy(sorted, changes.Changes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].WorkloadID.String() < sorted[j].WorkloadID.String()
+	})
+
+	paced := &update.Automated{}
+	admitted := map[flux.ResourceID]bool{}
+	deferred := map[flux.ResourceID]string{}
+	for _, change := range sorted {
+		id := change.WorkloadID
+		if _, held := deferred[id]; !admitted[id] && !held {
+			var exhausted string
+			for _, name := range covering[id] {
+				if remaining[name] <= 0 {
+					exhausted = name
+					break
+				}
+			}
+			if exhausted != "" {
+				deferred[id] = exhausted
+				logger.Log("info", "pacing automated update; disruption budget allows no more disruptions", "workload", id, "budget", exhausted)
+			} else {
+				admitted[id] = true
+				for _, name := range covering[id] {
+					remaining[name]--
+				}
+			}
+		}
+		if _, held := deferred[id]; held {
+			continue
+		}
+		paced.Changes = append(paced.Changes, change)
+	}
+	return paced, deferred
 }
@@ -1,6 +1,7 @@
 package daemon
 
 import (
+	"context"
 	"github.com/weaveworks/flux/policy"
 	"testing"
 	"time"
@@ -12,6 +13,7 @@ import (
 	"github.com/weaveworks/flux/registry"
 	registryMock "github.com/weaveworks/flux/registry/mock"
 	"github.com/weaveworks/flux/resource"
+	"github.com/weaveworks/flux/signature"
 	"github.com/weaveworks/flux/update"
 )
 
@@ -88,7 +90,7 @@ func TestCalculateChanges_Automated(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	changes := calculateChanges(logger, candidateWorkloads, workloads, imageRepos)
+	changes, _ := calculateChanges(logger, candidateWorkloads, workloads, imageRepos, nil)
 
 	if len := len(changes.Changes); len != 1 {
 		t.Errorf("Expected exactly 1 change, got %d changes", len)
@@ -144,7 +146,7 @@ func TestCalculateChanges_UntaggedImage(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	changes := calculateChanges(logger, candidateWorkloads, workloads, imageRepos)
+	changes, _ := calculateChanges(logger, candidateWorkloads, workloads, imageRepos, nil)
 
 	if len := len(changes.Changes); len != 1 {
 		t.Errorf("Expected exactly 1 change, got %d changes", len)
@@ -200,7 +202,7 @@ func TestCalculateChanges_ZeroTimestamp(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	changes := calculateChanges(logger, candidateWorkloads, workloads, imageRepos)
+	changes, _ := calculateChanges(logger, candidateWorkloads, workloads, imageRepos, nil)
 
 	if len := len(changes.Changes); len != 1 {
 		t.Errorf("Expected exactly 1 change, got %d changes", len)
@@ -208,3 +210,285 @@ func TestCalculateChanges_ZeroTimestamp(t *testing.T) {
 		t.Errorf("Expected changed image to be %s, got %s", newContainer1Image, newImage)
 	}
 }
+
+func TestCalculateChanges_PinDigest(t *testing.T) {
+	const (
+		oldDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+		newDigest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	)
+	logger := log.NewNopLogger()
+	resourceID := flux.MakeResourceID(ns, "deployment", "application")
+	candidateWorkloads := resources{
+		resourceID: candidate{
+			resourceID: resourceID,
+			policies: policy.Set{
+				policy.Automated: "true",
+				policy.PinDigest: "true",
+			},
+		},
+	}
+	workloads := []cluster.Workload{
+		cluster.Workload{
+			ID: resourceID,
+			Containers: cluster.ContainersOrExcuse{
+				Containers: []resource.Container{
+					{
+						Name:  container1,
+						Image: mustParseImageRef(currentContainer1Image + "@" + oldDigest),
+					},
+				},
+			},
+		},
+	}
+	// The same tag has been pushed again, so the digest has changed
+	repushed := makeImageInfo(currentContainer1Image, time.Now())
+	repushed.Digest = newDigest
+	imageRegistry := &registryMock.Registry{
+		Images: []image.Info{repushed},
+	}
+	imageRepos, err := update.FetchImageRepos(imageRegistry, clusterContainers(workloads), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, _ := calculateChanges(logger, candidateWorkloads, workloads, imageRepos, nil)
+
+	expected := currentContainer1Image + "@" + newDigest
+	if len := len(changes.Changes); len != 1 {
+		t.Errorf("Expected exactly 1 change, got %d changes", len)
+	} else if newImage := changes.Changes[0].ImageID.String(); newImage != expected {
+		t.Errorf("Expected changed image to be %s, got %s", expected, newImage)
+	}
+
+	// Without a digest from the registry, there's nothing to pin to
+	repushed.Digest = ""
+	imageRegistry.Images = []image.Info{repushed}
+	imageRepos, err = update.FetchImageRepos(imageRegistry, clusterContainers(workloads), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, _ = calculateChanges(logger, candidateWorkloads, workloads, imageRepos, nil)
+	if len := len(changes.Changes); len != 0 {
+		t.Errorf("Expected no changes, got %d changes", len)
+	}
+}
+
+// signedImages is a signature.Verifier that passes only the tags it
+// has.
+type signedImages map[string]bool
+
+func (s signedImages) Requires(image.Name) bool {
+	return true
+}
+
+func (s signedImages) Verify(ctx context.Context, info image.Info) error {
+	if s[info.ID.Tag] {
+		return nil
+	}
+	return &signature.UnsignedError{Image: info.ID}
+}
+
+func TestCalculateChanges_Signatures(t *testing.T) {
+	logger := log.NewNopLogger()
+	resourceID := flux.MakeResourceID(ns, "deployment", "application")
+	candidateWorkloads := resources{
+		resourceID: candidate{
+			resourceID: resourceID,
+			policies: policy.Set{
+				policy.Automated: "true",
+			},
+		},
+	}
+	workloads := []cluster.Workload{
+		cluster.Workload{
+			ID: resourceID,
+			Containers: cluster.ContainersOrExcuse{
+				Containers: []resource.Container{
+					{
+						Name:  container1,
+						Image: mustParseImageRef(currentContainer1Image),
+					},
+				},
+			},
+		},
+	}
+	now := time.Now()
+	signed := makeImageInfo("container1/application:signed", now.Add(time.Second))
+	signed.Digest = "sha256:5161e9d4b6f3e2a6c6a1ab0b7e3a9d4d1e0f0c0b6d2a0f7e4b1c9d8e7f6a5b4c"
+	unsigned := makeImageInfo("container1/application:unsigned", now.Add(2*time.Second))
+	unsigned.Digest = "sha256:9d2e4f0c6b8a1e3d5f7a9c0b2d4e6f8a1c3e5b7d9f0a2c4e6b8d0f1a3c5e7b9d"
+	imageRegistry := &registryMock.Registry{
+		Images: []image.Info{
+			makeImageInfo("container1/application:old", now.Add(-time.Second)),
+			makeImageInfo(currentContainer1Image, now),
+			signed,
+			unsigned,
+		},
+	}
+	imageRepos, err := update.FetchImageRepos(imageRegistry, clusterContainers(workloads), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the newest image isn't signed, so the next newest is taken;
+	// and it's pinned to the digest verified, though the workload
+	// doesn't ask for that
+	changes, _ := calculateChanges(logger, candidateWorkloads, workloads, imageRepos, signedImages{"old": true, "signed": true})
+	if len := len(changes.Changes); len != 1 {
+		t.Errorf("Expected exactly 1 change, got %d changes", len)
+	} else if newImage := changes.Changes[0].ImageID.String(); newImage != "container1/application:signed@"+signed.Digest {
+		t.Errorf("Expected changed image to be the newest signed image, pinned to its digest, got %s", newImage)
+	}
+
+	// nothing newer than the current image is signed; an older
+	// signed image mustn't be taken instead
+	changes, _ = calculateChanges(logger, candidateWorkloads, workloads, imageRepos, signedImages{"old": true})
+	if len := len(changes.Changes); len != 0 {
+		t.Errorf("Expected no changes, got %v", changes.Changes)
+	}
+}
+
+func TestLimitRollouts(t *testing.T) {
+	logger := log.NewNopLogger()
+	ids := []flux.ResourceID{
+		flux.MakeResourceID(ns, "deployment", "a"),
+		flux.MakeResourceID(ns, "deployment", "b"),
+		flux.MakeResourceID(ns, "deployment", "c"),
+		flux.MakeResourceID(ns, "deployment", "d"),
+	}
+	workloads := []cluster.Workload{
+		{ID: ids[0], Status: cluster.StatusReady},
+		{ID: ids[1], Status: cluster.StatusUpdating},
+		{ID: ids[2], Status: cluster.StatusReady},
+		{ID: ids[3], Status: cluster.StatusReady},
+	}
+	changes := &update.Automated{}
+	ref := mustParseImageRef(newContainer1Image)
+	// in reverse order, to check they are considered in order of ID
+	for i := len(ids) - 1; i >= 0; i-- {
+		changes.Add(ids[i], resource.Container{Name: container1}, ref)
+	}
+
+	if limited := limitRollouts(logger, changes, workloads, nil, 0); len(limited.Changes) != len(ids) {
+		t.Errorf("expected all changes with no limit, got %v", limited.Changes)
+	}
+
+	// b is already rolling out, so there's room for only one more
+	limited := limitRollouts(logger, changes, workloads, nil, 2)
+	var got []flux.ResourceID
+	for _, c := range limited.Changes {
+		got = append(got, c.WorkloadID)
+	}
+	expected := []flux.ResourceID{ids[0], ids[1]}
+	if len(got) != len(expected) || got[0] != expected[0] || got[1] != expected[1] {
+		t.Errorf("expected changes to %v, got %v", expected, got)
+	}
+
+	// c was updated in a commit not yet synced, so it counts as
+	// rolling out too, leaving no room for a or d
+	unsynced := flux.ResourceIDSet{}
+	unsynced.Add([]flux.ResourceID{ids[2]})
+	limited = limitRollouts(logger, changes, workloads, unsynced, 2)
+	got = nil
+	for _, c := range limited.Changes {
+		got = append(got, c.WorkloadID)
+	}
+	expected = []flux.ResourceID{ids[1], ids[2]}
+	if len(got) != len(expected) || got[0] != expected[0] || got[1] != expected[1] {
+		t.Errorf("expected changes to %v, got %v", expected, got)
+	}
+}
+
+func TestPaceForBudgets(t *testing.T) {
+	logger := log.NewNopLogger()
+	ids := []flux.ResourceID{
+		flux.MakeResourceID(ns, "deployment", "a"),
+		flux.MakeResourceID(ns, "deployment", "b"),
+		flux.MakeResourceID(ns, "deployment", "c"),
+		flux.MakeResourceID(ns, "deployment", "d"),
+	}
+	budgets := []cluster.DisruptionBudget{
+		// a and b share a budget with room for one
+		{Name: ns + "/front", Allowed: 1, Workloads: []flux.ResourceID{ids[0], ids[1]}},
+		// c's budget is used up
+		{Name: ns + "/back", Allowed: 0, Workloads: []flux.ResourceID{ids[2]}},
+	}
+	changes := &update.Automated{}
+	ref := mustParseImageRef(newContainer1Image)
+	for i := len(ids) - 1; i >= 0; i-- {
+		changes.Add(ids[i], resource.Container{Name: container1}, ref)
+	}
+
+	// d has no budget, so it's not held back
+	paced, deferred := paceForBudgets(logger, changes, budgets)
+	var got []flux.ResourceID
+	for _, c := range paced.Changes {
+		got = append(got, c.WorkloadID)
+	}
+	expected := []flux.ResourceID{ids[0], ids[3]}
+	if len(got) != len(expected) || got[0] != expected[0] || got[1] != expected[1] {
+		t.Errorf("expected changes to %v, got %v", expected, got)
+	}
+	if len(deferred) != 2 || deferred[ids[1]] != ns+"/front" || deferred[ids[2]] != ns+"/back" {
+		t.Errorf("expected b and c to be held back by their budgets, got %v", deferred)
+	}
+
+	if paced, _ := paceForBudgets(logger, changes, nil); len(paced.Changes) != len(ids) {
+		t.Errorf("expected all changes with no budgets, got %v", paced.Changes)
+	}
+
+	// a budget that never allows a disruption, though its pods are
+	// healthy, lets one workload through at a time
+	budgets = []cluster.DisruptionBudget{
+		{Name: ns + "/single", Allowed: 0, Healthy: true, Workloads: []flux.ResourceID{ids[0], ids[1]}},
+	}
+	paced, deferred = paceForBudgets(logger, changes, budgets)
+	got = nil
+	for _, c := range paced.Changes {
+		got = append(got, c.WorkloadID)
+	}
+	expected = []flux.ResourceID{ids[0], ids[2], ids[3]}
+	if len(got) != len(expected) || got[0] != expected[0] || got[1] != expected[1] || got[2] != expected[2] {
+		t.Errorf("expected changes to %v, got %v", expected, got)
+	}
+	if len(deferred) != 1 || deferred[ids[1]] != ns+"/single" {
+		t.Errorf("expected b to be held back by its budget, got %v", deferred)
+	}
+}
+
+func TestDeferUnscheduled(t *testing.T) {
+	logger := log.NewNopLogger()
+	anytime := flux.MakeResourceID(ns, "deployment", "anytime")
+	offHours := flux.MakeResourceID(ns, "deployment", "off-hours")
+	invalid := flux.MakeResourceID(ns, "deployment", "invalid")
+	candidateWorkloads := resources{
+		anytime: candidate{resourceID: anytime, policies: policy.Set{policy.Automated: "true"}},
+		offHours: candidate{resourceID: offHours, policies: policy.Set{
+			policy.Automated:          "true",
+			policy.AutomationSchedule: "Mon-Fri 22:00-06:00",
+		}},
+		invalid: candidate{resourceID: invalid, policies: policy.Set{
+			policy.Automated:          "true",
+			policy.AutomationSchedule: "whenever",
+		}},
+	}
+	changes := &update.Automated{}
+	ref := mustParseImageRef(newContainer1Image)
+	for _, id := range []flux.ResourceID{anytime, offHours, invalid} {
+		changes.Add(id, resource.Container{Name: container1}, ref)
+	}
+
+	// A Wednesday afternoon, outside the window
+	afternoon := time.Date(2019, 6, 5, 14, 0, 0, 0, time.UTC)
+	scheduled := deferUnscheduled(logger, changes, candidateWorkloads, afternoon)
+	if len(scheduled.Changes) != 1 || scheduled.Changes[0].WorkloadID != anytime {
+		t.Errorf("expected only the change to %s in the afternoon, got %v", anytime, scheduled.Changes)
+	}
+
+	// The small hours of Thursday are still in Wednesday's window
+	night := time.Date(2019, 6, 6, 2, 0, 0, 0, time.UTC)
+	scheduled = deferUnscheduled(logger, changes, candidateWorkloads, night)
+	if len(scheduled.Changes) != 2 || scheduled.Changes[1].WorkloadID != offHours {
+		t.Errorf("expected the changes to %s and %s at night, got %v", anytime, offHours, scheduled.Changes)
+	}
+}
@@ -0,0 +1,45 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux/api/v12"
+	"github.com/weaveworks/flux/job"
+	"github.com/weaveworks/flux/update"
+)
+
+// JobHistory gives the records of the most recent jobs, most recent
+// first.
+func (d *Daemon) JobHistory(ctx context.Context, opts v12.JobHistoryOptions) ([]job.Record, error) {
+	if d.History == nil {
+		return nil, errors.New("no job history is kept by this daemon")
+	}
+	return d.History.Records(opts.Limit), nil
+}
+
+// recordJobQueued starts the record of the job given, for the update
+// given, in the job history.
+func (d *Daemon) recordJobQueued(id job.ID, spec update.Spec, at time.Time) {
+	d.recordJob(d.Logger, id, func(r *job.Record) {
+		r.Type = spec.Type
+		r.User = spec.Cause.User
+		r.Status = job.StatusQueued
+		r.Queued = at.UTC()
+	})
+}
+
+// recordJob changes the record of the job given in the job history,
+// if one is kept. Failing to save the history is logged, but doesn't
+// fail the job.
+func (d *Daemon) recordJob(logger log.Logger, id job.ID, change func(*job.Record)) {
+	if d.History == nil {
+		return
+	}
+	if err := d.History.Update(id, change); err != nil {
+		logger.Log("warning", "could not save job history", "err", err)
+	}
+}
@@ -5,33 +5,212 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
 
 	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/api/v12"
 	"github.com/weaveworks/flux/cluster"
 	"github.com/weaveworks/flux/event"
 	"github.com/weaveworks/flux/git"
 	fluxmetrics "github.com/weaveworks/flux/metrics"
 	"github.com/weaveworks/flux/resource"
+	"github.com/weaveworks/flux/signature"
 	fluxsync "github.com/weaveworks/flux/sync"
 	"github.com/weaveworks/flux/update"
+	"github.com/weaveworks/flux/verify"
 )
 
-type LoopVars struct {
+// Elector decides whether this instance of the daemon is the one
+// that should be syncing, when there are several of them.
+type Elector interface {
+	IsLeader() bool
+	// Changed is signalled whenever leadership changes
+	Changed() <-chan struct{}
+}
+
+// LoopConfig is how a daemon's loop is configured. A daemon made
+// for a scope (see ForScope) is given a copy, so every option here
+// applies to scoped daemons too.
+type LoopConfig struct {
 	SyncInterval         time.Duration
 	RegistryPollInterval time.Duration
 	GitOpTimeout         time.Duration
+	// Refuse to sync when the branch HEAD isn't a descendant of the
+	// last synced revision (e.g., because the branch was
+	// force-pushed), rather than following it.
+	RefuseForcePush bool
+	// Refuse to sync a revision that doesn't have a valid signature
+	GitVerifySignatures bool
+	// If not nil, refuse to sync a revision unless its commit is by
+	// a trusted author
+	GitTrustedAuthors *TrustedAuthors
+	// Don't apply manifests in syncs triggered only by the sync
+	// interval elapsing, if they are unchanged since the last
+	// successful sync.
+	SkipUnchangedSyncs bool
+	// Only apply the resources in files changed since the last
+	// synced revision (and any that need applying for other
+	// reasons), except for a full sync at least every
+	// FullSyncInterval.
+	IncrementalSync  bool
+	FullSyncInterval time.Duration
+	// If non-zero, how often to report resources that differ from
+	// the last synced revision
+	DriftReportInterval time.Duration
+	// If not nil, only sync while this says we're the leader
+	Leader Elector
+	// If not nil, manifests are checked with this before being
+	// applied, and nothing is applied if any fail
+	Validator cluster.Validator
+	// Sync even when no manifests are found, though the last sync
+	// found some; otherwise, that's taken to be a misconfiguration
+	// and the sync is refused, since it could garbage collect
+	// everything
+	AllowEmptySync bool
+	// If not nil, manifests are synced to a validation cluster
+	// before this daemon's cluster; see fluxsync.Validation
+	SyncValidation *fluxsync.Validation
+	// If non-zero, after applying, wait up to this long for
+	// workloads to be ready before moving the sync tag; if they
+	// aren't ready by then, the sync fails and the tag stays put
+	SyncHealthTimeout time.Duration
+	// Which workloads to wait for; SyncHealthScopeAll (the default)
+	// or SyncHealthScopeChanged
+	SyncHealthScope string
+	// If not empty, only workloads in these namespaces are waited for
+	SyncHealthNamespaces []string
+	// If set, workloads that aren't ready in time fail only their
+	// own namespace, rather than the whole sync; the sync tag is
+	// moved regardless, and the namespace is reported as failing
+	// (see NamespaceSyncStatus) until a revision syncs cleanly
+	SyncIsolateNamespaces bool
+	// If not nil, each newly synced revision is verified with this
+	// before the sync tag is moved to it, waiting up to
+	// VerifyTimeout
+	Verifier      verify.Verifier
+	VerifyTimeout time.Duration
+	// What to do when verification fails; verify.FailureBlock (the
+	// default) or verify.FailureProceed
+	VerifyFailureAction string
+	// Poll for new images in a goroutine of its own, rather than in
+	// the loop, so that a long sync doesn't hold up image polling,
+	// or vice versa. An image poll reads the manifests from a clone
+	// of its own, and makes its changes by queueing a job, which the
+	// loop runs in turn with syncs, so it never touches the sync's
+	// working clone
+	ConcurrentImagePoll bool
+	// If non-zero, the loop comes round at least this often even
+	// when there's nothing to do, updating the heartbeat metrics, so
+	// that an idle daemon can be told from a stuck one
+	HeartbeatInterval time.Duration
+	// If non-zero, automation won't update more workloads than
+	// would bring the number of automated workloads with rollouts in
+	// progress above this
+	AutomationMaxRollouts int
+	// If true, and the cluster reports disruption budgets (e.g.,
+	// PodDisruptionBudgets), automation holds back updates to
+	// workloads whose budgets allow no more disruptions
+	AutomationRespectPDBs bool
+	// If more than one, the sync tag is moved only after every this
+	// many syncs of a new revision, rather than after each; see
+	// pendingSyncTag
+	SyncTagEvery int
+	// If not nil, automation only updates workloads to images that
+	// pass this; newer images that don't are passed over
+	ImageSignatures signature.Verifier
+	// Send an event when syncing succeeds after failing, saying how
+	// long it was failing for
+	NotifySyncRecovery bool
+	// When to bootstrap the cluster, i.e., sync it more carefully
+	// until a full sync succeeds; SyncBootstrapNever (the default),
+	// SyncBootstrapAuto or SyncBootstrapAlways
+	SyncBootstrap string
+}
+
+// LoopVars is the configuration of a daemon's loop, along with the
+// state the loop keeps, which is its own.
+type LoopVars struct {
+	LoopConfig
 
 	initOnce       sync.Once
 	syncSoon       chan struct{}
 	pollImagesSoon chan struct{}
+	syncedRevs     syncedRevisions
+	// set when a sync has been explicitly asked for, rather than
+	// because the sync interval elapsed
+	syncForced int32
+	// set when the next sync should apply everything, even if
+	// incremental syncs are enabled
+	fullSyncForced int32
+	// what asked for the sync waiting to happen, if anything; see
+	// askForSync
+	pendingSyncTrigger   string
+	pendingSyncTriggerMu sync.Mutex
+	// what asked for the sync in progress; only accessed from the
+	// loop goroutine
+	syncTrigger string
+	// hash of the manifests last applied without error, and passing
+	// the health gate and verification after; only accessed from the
+	// loop goroutine
+	syncedContentHash string
+	// the fingerprint of the synced resources in the cluster, as of
+	// the sync that recorded syncedContentHash; only accessed from
+	// the loop goroutine
+	syncedFingerprint string
+	// when all the manifests were last applied; only accessed from
+	// the loop goroutine
+	lastFullSync time.Time
+	// set once a full sync has succeeded, so no more syncs are
+	// bootstraps; only accessed from the loop goroutine
+	bootstrapped bool
+	// how many manifests the last sync found; only accessed from
+	// the loop goroutine
+	lastManifestCount int
+	// the most recent events from the loop, for the API
+	loopEvents loopEvents
+	// how often to check workloads while waiting for them to be
+	// ready; defaults to defaultSyncHealthPollInterval
+	syncHealthPollInterval time.Duration
+	// a synced revision the sync tag hasn't been moved to yet, if
+	// SyncTagEvery is more than one
+	pendingSyncTag pendingSyncTag
+	// the run of failing syncs, if the last sync failed; only
+	// accessed from the loop goroutine
+	syncEpisode syncEpisode
+	// set once the state about syncing kept in git has been loaded;
+	// see loadSyncState. Only accessed from the loop goroutine
+	syncStateLoaded bool
+	// the resources with sync intervals of their own, and when
+	// they're next due to be synced
+	resourceSchedule resourceSchedule
 }
 
+// What can ask for a sync, for attributing syncs in metrics and
+// loop events. This is a fixed set, to keep the number of metric
+// labels bounded.
+const (
+	syncTriggerStartup  = "startup"
+	syncTriggerTimer    = "timer"
+	syncTriggerGit      = "git"
+	syncTriggerJob      = "job"
+	syncTriggerLeader   = "leader"
+	syncTriggerExplicit = "explicit"
+	// a sync of the resources with sync intervals of their own; see
+	// syncDueResources
+	syncTriggerResourceInterval = "resource-interval"
+	// a drift report found resources that differ from their
+	// manifests; see reportDrift
+	syncTriggerDrift = "drift"
+)
+
 func (loop *LoopVars) ensureInit() {
 	loop.initOnce.Do(func() {
 		loop.syncSoon = make(chan struct{}, 1)
@@ -49,36 +228,107 @@ func (d *Daemon) Loop(stop chan struct{}, wg *sync.WaitGroup, logger log.Logger)
 	// Similarly checking to see if any controllers have new images
 	// available.
 	imagePollTimer := time.NewTimer(d.RegistryPollInterval)
+	// Resources with sync intervals of their own are synced in
+	// between; the timer is set after each sync, once it's known
+	// which resources have them.
+	resourceSyncTimer := time.NewTimer(d.SyncInterval)
+	resourceSyncTimer.Stop()
 
 	// Keep track of current HEAD, so we can know when to treat a repo
 	// mirror notification as a change. Otherwise, we'll just sync
 	// every timer tick as well as every mirror refresh.
 	syncHead := ""
 
+	// A scoped daemon only syncs; the jobs and image polling are
+	// left to the daemon it was made from, and it's told about
+	// refreshes of the repo via the scope.
+	repoRefreshed := d.Repo.C
+	jobsReady := d.Jobs.Ready()
+	if d.Scope != nil {
+		repoRefreshed = d.Scope.C
+		jobsReady = nil
+	}
+
 	// Ask for a sync, and to poll images, straight away
-	d.AskForSync()
-	d.AskForImagePoll()
+	d.askForSync(syncTriggerStartup)
+	if d.Scope == nil {
+		d.AskForImagePoll()
+	}
+
+	// If image polling has a goroutine of its own, this loop doesn't
+	// see the image poll channels; a nil channel never receives.
+	pollImagesSoon, imagePollTime := d.pollImagesSoon, imagePollTimer.C
+	if d.ConcurrentImagePoll && d.Scope == nil {
+		wg.Add(1)
+		go d.imagePollLoop(stop, wg, imagePollTimer, logger)
+		pollImagesSoon, imagePollTime = nil, nil
+	}
+
+	// Find out when we become, or stop being, the leader. A nil
+	// channel never receives, so this case is never taken if
+	// there's no leader election.
+	var leaderChanged <-chan struct{}
+	if d.Leader != nil {
+		leaderChanged = d.Leader.Changed()
+	}
+	syncLeader.Set(boolToFloat(d.isSyncLeader()))
+
+	// Drift reports are only made if asked for; a nil channel never
+	// receives.
+	var driftReport <-chan time.Time
+	if d.DriftReportInterval > 0 {
+		driftTicker := time.NewTicker(d.DriftReportInterval)
+		defer driftTicker.Stop()
+		driftReport = driftTicker.C
+	}
+
+	// The heartbeat metrics are for the main loop; a nil channel
+	// never receives, so there are no heartbeats for scoped loops,
+	// or without a heartbeat interval.
+	var heartbeat <-chan time.Time
+	if d.HeartbeatInterval > 0 && d.Scope == nil {
+		heartbeatTicker := time.NewTicker(d.HeartbeatInterval)
+		defer heartbeatTicker.Stop()
+		heartbeat = heartbeatTicker.C
+	}
+	lastActivity := time.Now()
+	idle := false
+
+	// Set when the loop itself refreshes the repo after a job, so
+	// that a sync following from the refresh is attributed to the
+	// job rather than to git.
+	refreshedAfterJob := false
 
 	for {
 		var (
 			lastKnownSyncTagRev      string
 			warnedAboutSyncTagChange bool
 		)
+		if heartbeat != nil {
+			// This is each time round, whatever happened last time
+			// and however it turned out.
+			now := time.Now()
+			if !idle {
+				lastActivity = now
+			}
+			idle = false
+			loopHeartbeats.Add(1)
+			loopHeartbeatTime.Set(float64(now.Unix()))
+			loopSinceActivity.Set(now.Sub(lastActivity).Seconds())
+		}
 		select {
 		case <-stop:
 			logger.Log("stopping", "true")
+			d.flushSyncTag(logger)
 			return
-		case <-d.pollImagesSoon:
-			if !imagePollTimer.Stop() {
-				select {
-				case <-imagePollTimer.C:
-				default:
-				}
+		case <-heartbeat:
+			idle = true
+		case <-pollImagesSoon:
+			d.pollImages(logger, imagePollTimer)
+		case <-imagePollTime:
+			if d.Scope == nil {
+				d.AskForImagePoll()
 			}
-			d.pollForNewImages(logger)
-			imagePollTimer.Reset(d.RegistryPollInterval)
-		case <-imagePollTimer.C:
-			d.AskForImagePoll()
 		case <-d.syncSoon:
 			if !syncTimer.Stop() {
 				select {
@@ -86,29 +336,80 @@ func (d *Daemon) Loop(stop chan struct{}, wg *sync.WaitGroup, logger log.Logger)
 				default:
 				}
 			}
-			if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange); err != nil {
-				logger.Log("err", err)
+			skipUnchanged := d.SkipUnchangedSyncs && atomic.SwapInt32(&d.syncForced, 0) == 0
+			d.syncTrigger = d.takeSyncTrigger()
+			if !d.isSyncLeader() {
+				logger.Log("info", "not syncing; another instance is the leader")
+			} else {
+				d.loopEvents.record(v12.LoopEventSync, fmt.Sprintf("sync started (%s)", d.syncTrigger), nil)
+				started := time.Now()
+				err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, skipUnchanged)
+				if err != nil {
+					logger.Log("err", err)
+					d.loopEvents.record(v12.LoopEventSync, "sync failed", err)
+				} else {
+					d.loopEvents.record(v12.LoopEventSync, "sync succeeded", nil)
+				}
+				d.noteSyncOutcome(logger, started, err)
 			}
 			syncTimer.Reset(d.SyncInterval)
+			d.resetResourceSyncTimer(resourceSyncTimer)
+		case <-resourceSyncTimer.C:
+			d.syncDueResources(logger)
+			d.resetResourceSyncTimer(resourceSyncTimer)
+		case <-leaderChanged:
+			isLeader := d.isSyncLeader()
+			syncLeader.Set(boolToFloat(isLeader))
+			if isLeader {
+				// Take over syncing straight away
+				d.askForSync(syncTriggerLeader)
+			}
 		case <-syncTimer.C:
-			d.AskForSync()
-		case <-d.Repo.C:
+			d.askForTimedSync()
+		case <-driftReport:
+			if !d.isSyncLeader() {
+				logger.Log("info", "not reporting drift; another instance is the leader")
+			} else if err := d.reportDrift(logger); err != nil {
+				logger.Log("err", errors.Wrap(err, "reporting drift"))
+			}
+		case <-repoRefreshed:
 			ctx, cancel := context.WithTimeout(context.Background(), d.GitOpTimeout)
-			newSyncHead, err := d.Repo.Revision(ctx, d.GitConfig.Branch)
+			var newSyncHead string
+			var err error
+			if d.Scope != nil {
+				// Only commits touching the scope's paths are of
+				// interest, so other scopes' changes don't
+				// cause a sync
+				newSyncHead, err = d.Repo.LastRevision(ctx, d.GitConfig.Branch, d.GitConfig.Paths...)
+			} else {
+				newSyncHead, err = d.Repo.Revision(ctx, d.GitConfig.Branch)
+			}
 			cancel()
 			if err != nil {
 				logger.Log("url", d.Repo.Origin().URL, "err", err)
+				d.loopEvents.record(v12.LoopEventGit, "could not find the branch HEAD after refreshing the repo", err)
 				continue
 			}
-			logger.Log("event", "refreshed", "url", d.Repo.Origin().URL, "branch", d.GitConfig.Branch, "HEAD", newSyncHead)
 			if newSyncHead != syncHead {
 				syncHead = newSyncHead
-				d.AskForSync()
+				logger.Log("event", "refreshed", "url", d.Repo.Origin().URL, "branch", d.GitConfig.Branch, "HEAD", newSyncHead)
+				d.loopEvents.record(v12.LoopEventGit, fmt.Sprintf("branch %s has new HEAD %s", d.GitConfig.Branch, newSyncHead), nil)
+				trigger := syncTriggerGit
+				if refreshedAfterJob {
+					trigger = syncTriggerJob
+				}
+				d.askForSync(trigger)
+			} else {
+				// Nothing has changed, so this is only of interest
+				// when debugging.
+				logger.Log("debug", "refreshed", "url", d.Repo.Origin().URL, "branch", d.GitConfig.Branch, "HEAD", newSyncHead)
 			}
-		case job := <-d.Jobs.Ready():
+			refreshedAfterJob = false
+		case job := <-jobsReady:
 			queueLength.Set(float64(d.Jobs.Len()))
 			jobLogger := log.With(logger, "jobID", job.ID)
 			jobLogger.Log("state", "in-progress")
+			d.loopEvents.record(v12.LoopEventJob, fmt.Sprintf("job %s started", job.ID), nil)
 			// It's assumed that (successful) jobs will push commits
 			// to the upstream repo, and therefore we probably want to
 			// pull from there and sync the cluster afterwards.
@@ -119,12 +420,18 @@ func (d *Daemon) Loop(stop chan struct{}, wg *sync.WaitGroup, logger log.Logger)
 			).Observe(time.Since(start).Seconds())
 			if err != nil {
 				jobLogger.Log("state", "done", "success", "false", "err", err)
+				d.loopEvents.record(v12.LoopEventJob, fmt.Sprintf("job %s failed", job.ID), err)
 			} else {
 				jobLogger.Log("state", "done", "success", "true")
+				d.loopEvents.record(v12.LoopEventJob, fmt.Sprintf("job %s succeeded", job.ID), nil)
 				ctx, cancel := context.WithTimeout(context.Background(), d.GitOpTimeout)
 				err := d.Repo.Refresh(ctx)
 				if err != nil {
 					logger.Log("err", err)
+				} else {
+					// The refresh will be seen next time round, and
+					// any sync it causes put down to the job
+					refreshedAfterJob = true
 				}
 				cancel()
 			}
@@ -132,15 +439,110 @@ func (d *Daemon) Loop(stop chan struct{}, wg *sync.WaitGroup, logger log.Logger)
 	}
 }
 
+// imagePollLoop polls for new images whenever asked to, or the
+// registry poll interval elapses, until told to stop. It's used in
+// place of the main loop doing so, when image polling is concurrent.
+func (d *Daemon) imagePollLoop(stop chan struct{}, wg *sync.WaitGroup, imagePollTimer *time.Timer, logger log.Logger) {
+	defer wg.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-d.pollImagesSoon:
+			d.pollImages(logger, imagePollTimer)
+		case <-imagePollTimer.C:
+			d.AskForImagePoll()
+		}
+	}
+}
+
+// pollImages polls for new images, then schedules the next poll.
+func (d *Daemon) pollImages(logger log.Logger, imagePollTimer *time.Timer) {
+	if !imagePollTimer.Stop() {
+		select {
+		case <-imagePollTimer.C:
+		default:
+		}
+	}
+	d.loopEvents.record(v12.LoopEventImagePoll, "polling for new images", nil)
+	d.pollForNewImages(logger)
+	imagePollTimer.Reset(d.RegistryPollInterval)
+}
+
+// isSyncLeader says whether this instance should be syncing.
+func (d *LoopVars) isSyncLeader() bool {
+	return d.Leader == nil || d.Leader.IsLeader()
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 // Ask for a sync, or if there's one waiting, let that happen.
 func (d *LoopVars) AskForSync() {
+	d.askForSync(syncTriggerExplicit)
+}
+
+// askForSync asks for a sync on behalf of the trigger given. If a
+// sync is already waiting, it's put down to whatever asked for it
+// first, other than the timer.
+func (d *LoopVars) askForSync(trigger string) {
 	d.ensureInit()
+	atomic.StoreInt32(&d.syncForced, 1)
+	d.askForSyncBecause(trigger)
+}
+
+// askForFullSync is AskForFullSync on behalf of the trigger given.
+func (d *LoopVars) askForFullSync(trigger string) {
+	atomic.StoreInt32(&d.fullSyncForced, 1)
+	d.askForSync(trigger)
+}
+
+// AskForFullSync asks for a sync that applies all the manifests,
+// rather than only those changed in git; e.g., because something the
+// manifests are rendered from has changed outside git.
+func (d *LoopVars) AskForFullSync() {
+	d.askForFullSync(syncTriggerExplicit)
+}
+
+// Ask for a sync because the sync interval has elapsed; unlike
+// AskForSync, this lets the sync be skipped if nothing has changed.
+func (d *LoopVars) askForTimedSync() {
+	d.askForSyncBecause(syncTriggerTimer)
+}
+
+// askForSyncBecause records that the trigger given asked for a sync,
+// and lets the loop know one is wanted.
+func (d *LoopVars) askForSyncBecause(trigger string) {
+	d.ensureInit()
+	syncRequests.With(fluxmetrics.LabelTrigger, trigger).Add(1)
+	d.pendingSyncTriggerMu.Lock()
+	if d.pendingSyncTrigger == "" || d.pendingSyncTrigger == syncTriggerTimer {
+		d.pendingSyncTrigger = trigger
+	}
+	d.pendingSyncTriggerMu.Unlock()
 	select {
 	case d.syncSoon <- struct{}{}:
 	default:
 	}
 }
 
+// takeSyncTrigger gives what asked for the sync that's about to
+// happen, and clears it so the next ask is recorded.
+func (d *LoopVars) takeSyncTrigger() string {
+	d.pendingSyncTriggerMu.Lock()
+	defer d.pendingSyncTriggerMu.Unlock()
+	trigger := d.pendingSyncTrigger
+	d.pendingSyncTrigger = ""
+	if trigger == "" {
+		trigger = syncTriggerExplicit
+	}
+	return trigger
+}
+
 // Ask for an image poll, or if there's one waiting, let that happen.
 func (d *LoopVars) AskForImagePoll() {
 	d.ensureInit()
@@ -152,11 +554,17 @@ func (d *LoopVars) AskForImagePoll() {
 
 // -- extra bits the loop needs
 
-func (d *Daemon) doSync(logger log.Logger, lastKnownSyncTagRev *string, warnedAboutSyncTagChange *bool) (retErr error) {
+func (d *Daemon) doSync(logger log.Logger, lastKnownSyncTagRev *string, warnedAboutSyncTagChange *bool, skipUnchanged bool) (retErr error) {
 	started := time.Now().UTC()
+	d.syncEpisode.failures = 0
 	defer func() {
+		trigger := d.syncTrigger
+		if trigger == "" {
+			trigger = syncTriggerExplicit
+		}
 		syncDuration.With(
 			fluxmetrics.LabelSuccess, fmt.Sprint(retErr == nil),
+			fluxmetrics.LabelTrigger, trigger,
 		).Observe(time.Since(started).Seconds())
 	}()
 
@@ -181,45 +589,153 @@ func (d *Daemon) doSync(logger log.Logger, lastKnownSyncTagRev *string, warnedAb
 	}
 
 	// For comparison later.
-	oldTagRev, err := working.SyncRevision(ctx)
+	tagRev, err := working.SyncRevision(ctx)
 	if err != nil && !isUnknownRevision(err) {
 		return err
 	}
 	// Check if something other than the current instance of fluxd changed the sync tag.
 	// This is likely to be caused by another fluxd instance using the same tag.
 	// Having multiple instances fighting for the same tag can lead to fluxd missing manifest changes.
-	if *lastKnownSyncTagRev != "" && oldTagRev != *lastKnownSyncTagRev && !*warnedAboutSyncTagChange {
+	if *lastKnownSyncTagRev != "" && tagRev != *lastKnownSyncTagRev && !*warnedAboutSyncTagChange {
 		logger.Log("warning",
 			"detected external change in git sync tag; the sync tag should not be shared by fluxd instances")
 		*warnedAboutSyncTagChange = true
 	}
+	d.loadSyncState(ctx, logger, working, tagRev)
+	// If moving the tag has been put off, it's behind what's been
+	// synced
+	oldTagRev := tagRev
+	if pending := d.pendingSyncTag.get(); pending != "" {
+		oldTagRev = pending
+	}
 
 	newTagRev, err := working.HeadRevision(ctx)
 	if err != nil {
 		return err
 	}
+	d.syncEpisode.revision = newTagRev
+
+	if err := d.verifyRevision(ctx, logger, working, oldTagRev, newTagRev); err != nil {
+		return err
+	}
 
-	// Get a map of all resources defined in the repo
-	allResources, err := d.Manifests.LoadManifests(working.Dir(), working.ManifestDirs())
+	// Check whether the branch has been rewritten since we last
+	// synced, e.g., by a force-push.
+	if oldTagRev != "" && oldTagRev != newTagRev {
+		ctx, cancel := context.WithTimeout(ctx, d.GitOpTimeout)
+		fastForward, err := working.IsAncestor(ctx, oldTagRev, newTagRev)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if !fastForward {
+			nonFastForwardCount.Add(1)
+			logger.Log("event", "non-fast-forward", "branch", d.GitConfig.Branch, "old", oldTagRev, "new", newTagRev, "refused", d.RefuseForcePush)
+			if d.RefuseForcePush {
+				return fmt.Errorf("refusing to sync: HEAD of branch %s (%s) is not a descendant of the last synced revision %s", d.GitConfig.Branch, newTagRev, oldTagRev)
+			}
+		}
+	}
+
+	// Get a map of all resources defined in the repo. Files too
+	// large to load are left out, and the rest synced, but without
+	// garbage collection, since what's in the files left out would
+	// look to have been removed
+	allResources, skipped, err := cluster.LoadManifestsSkipping(d.Manifests, working.Dir(), working.ManifestDirs())
 	if err != nil {
 		return errors.Wrap(err, "loading resources from repo")
 	}
+	if skipped != nil {
+		skippedFilesCount.Add(float64(len(skipped.Files)))
+		logger.Log("err", "SKIPPING MANIFEST FILES: files are larger than the limit on their size, so are not synced, and nothing will be garbage collected", "files", skipped.Error())
+		d.loopEvents.record(v12.LoopEventSync, "skipped manifest files that are too large", skipped)
+	}
+	d.syncedRevs.recordSkipped(skipped)
 
-	var resourceErrors []event.ResourceError
-	if err := fluxsync.Sync(syncSetName, allResources, d.Cluster); err != nil {
-		logger.Log("err", err)
-		switch syncerr := err.(type) {
-		case cluster.SyncError:
-			for _, e := range syncerr {
-				resourceErrors = append(resourceErrors, event.ResourceError{
-					ID:    e.ResourceID,
-					Path:  e.Source,
-					Error: e.Error.Error(),
-				})
+	if len(allResources) == 0 && !d.AllowEmptySync {
+		// Since fluxd may have restarted since the last sync (quite
+		// possibly with the misconfiguration), look at what's in the
+		// cluster from earlier syncs if need be
+		previous := d.lastManifestCount
+		if counter, ok := d.Cluster.(cluster.SyncedCounter); ok && previous == 0 {
+			if previous, err = counter.SyncedCount(syncSetName); err != nil {
+				logger.Log("warning", "could not count resources synced before", "err", err)
 			}
-		default:
+		}
+		if previous > 0 {
+			emptySyncRefusedCount.Add(1)
+			logger.Log("err", "REFUSING TO SYNC: no manifests found, but the last sync found some; this is likely a misconfiguration (e.g., of --git-path). Use --sync-allow-empty if it's intended", "revision", newTagRev, "previous", previous)
+			return fmt.Errorf("refusing to sync: no manifests found at revision %s, but %d were found at the last sync", newTagRev, previous)
+		}
+	}
+	d.lastManifestCount = len(allResources)
+
+	var resourceErrors []event.ResourceError
+	// what failed to sync, by namespace; see recordNamespaces
+	failures := map[flux.ResourceID]string{}
+	var summary cluster.SyncSummary
+	var applied bool
+	// whether what was applied can be skipped next time, if it's
+	// unchanged; it can't be until the gates below are passed
+	var syncedHash string
+	contentHash := hashResources(allResources)
+	resolver, ok := d.Cluster.(cluster.ValueResolver)
+	resolvesValues := ok && resolver.ResolvesValues(allResources)
+	if skipUnchanged && contentHash == d.syncedContentHash && !resolvesValues && d.clusterUnchanged(logger, syncSetName) {
+		noopSyncCount.Add(1)
+		logger.Log("info", "manifests and cluster unchanged since last sync; not applying")
+	} else {
+		applied = true
+		d.syncedContentHash = ""
+		changed, err := d.incrementalChanges(ctx, working, oldTagRev)
+		if err != nil {
 			return err
 		}
+		failedResources := flux.ResourceIDSet{}
+		bootstrap := d.isBootstrap(oldTagRev)
+		if bootstrap {
+			// A bootstrap has to apply everything
+			changed = nil
+			logger.Log("info", "bootstrap sync", "mode", d.SyncBootstrap)
+		}
+		if changed != nil {
+			logger.Log("info", "incremental sync", "since", oldTagRev, "changed", len(changed))
+		}
+		summary, err = d.applySync(logger, syncSetName, allResources, fluxsync.Options{
+			Revision:  newTagRev,
+			Changed:   changed,
+			NoGC:      skipped != nil,
+			Bootstrap: bootstrap,
+		})
+		if err != nil {
+			logger.Log("err", err)
+			switch syncerr := err.(type) {
+			case cluster.SyncError:
+				for _, e := range syncerr {
+					resourceErrors = append(resourceErrors, event.ResourceError{
+						ID:    e.ResourceID,
+						Path:  e.Source,
+						Error: e.Error.Error(),
+					})
+					failedResources.Add([]flux.ResourceID{e.ResourceID})
+					failures[e.ResourceID] = e.Error.Error()
+				}
+			default:
+				return err
+			}
+		}
+		if changed == nil {
+			d.lastFullSync = started
+		}
+		if bootstrap && len(failures) == 0 && skipped == nil {
+			d.bootstrapped = true
+			logger.Log("info", "bootstrap sync succeeded; syncing as usual from now on")
+		}
+		d.syncedRevs.record(newTagRev, allResources, failedResources)
+		d.resourceSchedule.reset(logger, newTagRev, allResources, d.SyncInterval, time.Now())
+		if len(resourceErrors) == 0 && skipped == nil {
+			syncedHash = contentHash
+		}
 	}
 
 	// update notes and emit events for applied commits
@@ -253,7 +769,7 @@ func (d *Daemon) doSync(logger log.Logger, lastKnownSyncTagRev *string, warnedAb
 		if err == nil && len(changedFiles) > 0 {
 			// We had some changed files, we're syncing a diff
 			// FIXME(michael): this won't be accurate when a file can have more than one resource
-			changedResources, err = d.Manifests.LoadManifests(working.Dir(), changedFiles)
+			changedResources, _, err = cluster.LoadManifestsSkipping(d.Manifests, working.Dir(), changedFiles)
 		}
 		cancel()
 		if err != nil {
@@ -266,6 +782,51 @@ func (d *Daemon) doSync(logger log.Logger, lastKnownSyncTagRev *string, warnedAb
 		workloadIDs.Add([]flux.ResourceID{r.ResourceID()})
 	}
 
+	// If configured to, don't move the sync tag until the workloads
+	// are ready; failing here means this revision is synced again
+	// next time, and the events for it are sent then. Unless
+	// namespaces are isolated, in which case only the namespaces of
+	// those that aren't ready are reported as failing.
+	var unready *unreadyError
+	if applied && d.SyncHealthTimeout > 0 {
+		ids, others := d.healthGateWorkloads(allResources, workloadIDs)
+		err := d.awaitHealthy(ctx, logger, ids, others)
+		if u, ok := err.(*unreadyError); ok {
+			unready = u
+			for id, reason := range unready.reasons {
+				failures[id] = "not ready: " + reason
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+	if applied {
+		d.syncedRevs.recordNamespaces(newTagRev, allResources, failures)
+	}
+	d.syncEpisode.failures = len(failures)
+	if unready != nil {
+		if !d.SyncIsolateNamespaces {
+			return unready
+		}
+		logger.Log("warning", "workloads not ready; reporting their namespaces as failing, and moving the sync tag regardless", "workloads", len(unready.reasons))
+	}
+	// Likewise, if there's a verifier, until each new revision has
+	// passed verification
+	if oldTagRev != newTagRev && d.Verifier != nil {
+		if err := d.verifySync(ctx, logger, newTagRev, workloadIDs.ToSlice()); err != nil {
+			return err
+		}
+	}
+	// Only once it's passed the gates is what was applied known to
+	// be good, and so not worth applying again if it's unchanged;
+	// until then, it's applied again, so it's checked again
+	if applied && unready == nil && syncedHash != "" {
+		if fingerprint, ok := d.fingerprintSynced(logger, syncSetName); ok {
+			d.syncedContentHash = syncedHash
+			d.syncedFingerprint = fingerprint
+		}
+	}
+
 	var notes map[string]struct{}
 	{
 		ctx, cancel := context.WithTimeout(ctx, d.GitOpTimeout)
@@ -407,6 +968,7 @@ func (d *Daemon) doSync(logger log.Logger, lastKnownSyncTagRev *string, warnedAb
 				InitialSync: initialSync,
 				Includes:    includes,
 				Errors:      resourceErrors,
+				Summary:     summary,
 			},
 		}); err != nil {
 			logger.Log("err", err)
@@ -423,8 +985,15 @@ func (d *Daemon) doSync(logger log.Logger, lastKnownSyncTagRev *string, warnedAb
 		}
 	}
 
-	// Move the tag and push it so we know how far we've gotten.
+	// Move the tag and push it so we know how far we've gotten;
+	// unless that's being done only every so often, and it's not
+	// time yet.
 	if oldTagRev != newTagRev {
+		if d.SyncTagEvery > 1 && d.pendingSyncTag.deferTo(newTagRev, d.SyncTagEvery) {
+			logger.Log("tag", d.GitConfig.SyncTagRef(), "old", tagRev, "pending", newTagRev)
+			d.saveSyncState(ctx, logger, working)
+			return nil
+		}
 		{
 			ctx, cancel := context.WithTimeout(ctx, d.GitOpTimeout)
 			tagAction := git.TagAction{
@@ -437,8 +1006,10 @@ func (d *Daemon) doSync(logger log.Logger, lastKnownSyncTagRev *string, warnedAb
 				return err
 			}
 			*lastKnownSyncTagRev = newTagRev
+			d.pendingSyncTag.reset()
 		}
-		logger.Log("tag", d.GitConfig.SyncTag, "old", oldTagRev, "new", newTagRev)
+		logger.Log("tag", d.GitConfig.SyncTagRef(), "old", tagRev, "new", newTagRev)
+		d.saveSyncState(ctx, logger, working)
 		{
 			ctx, cancel := context.WithTimeout(ctx, d.GitOpTimeout)
 			err := d.Repo.Refresh(ctx)
@@ -446,9 +1017,156 @@ func (d *Daemon) doSync(logger log.Logger, lastKnownSyncTagRev *string, warnedAb
 			return err
 		}
 	}
+	d.saveSyncState(ctx, logger, working)
 	return nil
 }
 
+// incrementalChanges gives the IDs of the resources in files changed
+// since the revision given, or nil if all the resources should be
+// applied -- because incremental syncs aren't enabled, because it's
+// time for a full sync (or one was asked for), or because changes
+// can't be attributed to particular resources.
+func (d *Daemon) incrementalChanges(ctx context.Context, working *git.Checkout, oldTagRev string) (flux.ResourceIDSet, error) {
+	fullSyncForced := atomic.SwapInt32(&d.fullSyncForced, 0) == 1
+	if fullSyncForced || !d.IncrementalSync || oldTagRev == "" || d.lastFullSync.IsZero() || time.Since(d.lastFullSync) >= d.FullSyncInterval {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.GitOpTimeout)
+	changedFiles, err := working.ChangedFiles(ctx, oldTagRev)
+	cancel()
+	if err != nil {
+		return nil, errors.Wrap(err, "finding files changed since last sync")
+	}
+	changed := flux.ResourceIDSet{}
+	if len(changedFiles) == 0 {
+		return changed, nil
+	}
+	for _, file := range changedFiles {
+		switch filepath.Ext(file) {
+		case ".yaml", ".yml":
+		default:
+			// Something like a Jsonnet library may have changed,
+			// which could affect any resource.
+			return nil, nil
+		}
+	}
+	// Files skipped for being too large aren't synced at all, so
+	// needn't be counted as changed
+	resources, _, err := cluster.LoadManifestsSkipping(d.Manifests, working.Dir(), changedFiles)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading resources from changed files")
+	}
+	for _, res := range resources {
+		changed.Add([]flux.ResourceID{res.ResourceID()})
+	}
+	return changed, nil
+}
+
+// fingerprintSynced gives the fingerprint of the resources in the
+// cluster from syncs of the sync set named, and whether there is one;
+// there isn't if the cluster can't give one.
+func (d *Daemon) fingerprintSynced(logger log.Logger, syncSetName string) (string, bool) {
+	fingerprinter, ok := d.Cluster.(cluster.SyncedFingerprinter)
+	if !ok {
+		return "", false
+	}
+	fingerprint, err := fingerprinter.SyncedFingerprint(syncSetName)
+	if err != nil {
+		logger.Log("warning", "could not fingerprint the synced resources in the cluster, so the next sync will apply them", "err", err)
+		return "", false
+	}
+	return fingerprint, true
+}
+
+// clusterUnchanged says whether the resources in the cluster from
+// syncs of the sync set named are as they were after the last sync
+// that wasn't skipped; if they're not (e.g., someone edited or
+// deleted one), a sync has to apply to put them back.
+func (d *Daemon) clusterUnchanged(logger log.Logger, syncSetName string) bool {
+	fingerprint, ok := d.fingerprintSynced(logger, syncSetName)
+	if ok && fingerprint != d.syncedFingerprint {
+		logger.Log("info", "synced resources changed in the cluster since last sync; applying")
+	}
+	return ok && fingerprint == d.syncedFingerprint
+}
+
+// applySync applies the resources given as every sync does: once
+// they've passed the Validator, if there is one, and with
+// SyncValidation; and logs a summary of what was done.
+func (d *Daemon) applySync(logger log.Logger, syncSetName string, resources map[string]resource.Resource, opts fluxsync.Options) (cluster.SyncSummary, error) {
+	if d.Validator != nil {
+		if err := d.Validator.Validate(resources); err != nil {
+			if syncerr, ok := err.(cluster.SyncError); ok {
+				for _, e := range syncerr {
+					logger.Log("resource", e.ResourceID, "path", e.Source, "err", e.Error)
+				}
+				return nil, fmt.Errorf("refusing to sync: %d resources failed validation", len(syncerr))
+			}
+			return nil, errors.Wrap(err, "validating resources")
+		}
+	}
+	opts.Validation = d.SyncValidation
+	summary, err := fluxsync.SyncWithOptions(syncSetName, resources, d.Cluster, opts)
+	logSyncSummary(logger, summary)
+	return summary, err
+}
+
+// logSyncSummary logs the counts of what happened in a sync, then
+// for each outcome, the counts by kind.
+func logSyncSummary(logger log.Logger, summary cluster.SyncSummary) {
+	logger.Log("info", "sync summary", "summary", summary.String())
+	for _, outcome := range cluster.SyncOutcomes {
+		if kinds := summary.KindsString(outcome); kinds != "" {
+			logger.Log("debug", "sync summary by kind", "outcome", outcome, "kinds", kinds)
+		}
+	}
+}
+
+// verifyRevision checks that the revision newRev, at the head of the
+// working clone, may be synced: that its commits since oldRev are
+// signed, if signatures are verified, and that it's from a trusted
+// author, if there are any.
+func (d *Daemon) verifyRevision(ctx context.Context, logger log.Logger, working *git.Checkout, oldRev, newRev string) error {
+	if d.GitVerifySignatures {
+		ctx, cancel := context.WithTimeout(ctx, d.GitOpTimeout)
+		err := working.VerifyRevisions(ctx, oldRev, newRev)
+		cancel()
+		if err != nil {
+			return errors.Wrap(err, "refusing to sync unverified revision")
+		}
+	}
+
+	if d.GitTrustedAuthors != nil {
+		ctx, cancel := context.WithTimeout(ctx, d.GitOpTimeout)
+		author, committer, err := working.CommitEmails(ctx, newRev)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if err := d.GitTrustedAuthors.check(author, committer); err != nil {
+			logger.Log("warning", "refusing to sync revision from untrusted author", "revision", newRev, "author", author, "committer", committer, "err", err)
+			return errors.Wrapf(err, "refusing to sync revision %s", newRev)
+		}
+	}
+	return nil
+}
+
+// hashResources gives a digest of the manifests given, so they can be
+// compared with those from another sync.
+func hashResources(resources map[string]resource.Resource) string {
+	ids := make([]string, 0, len(resources))
+	for id := range resources {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	h := sha256.New()
+	for _, id := range ids {
+		h.Write([]byte(id))
+		h.Write(resources[id].Bytes())
+	}
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
 func isUnknownRevision(err error) bool {
 	return err != nil &&
 		(strings.Contains(err.Error(), "unknown revision or path not in the working tree.") ||
@@ -1,9 +1,13 @@
 package daemon
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
+	"regexp"
 	"strings"
 	"sync"
 	"testing"
@@ -21,7 +25,9 @@ import (
 	"github.com/weaveworks/flux/git"
 	"github.com/weaveworks/flux/git/gittest"
 	"github.com/weaveworks/flux/job"
+	"github.com/weaveworks/flux/metrics/metricstest"
 	registryMock "github.com/weaveworks/flux/registry/mock"
+	"github.com/weaveworks/flux/resource"
 )
 
 const (
@@ -71,7 +77,7 @@ func daemon(t *testing.T) (*Daemon, func()) {
 		JobStatusCache: &job.StatusCache{Size: 100},
 		EventWriter:    events,
 		Logger:         log.NewLogfmtLogger(os.Stdout),
-		LoopVars:       &LoopVars{GitOpTimeout: 5 * time.Second},
+		LoopVars:       &LoopVars{LoopConfig: LoopConfig{GitOpTimeout: 5 * time.Second}},
 	}
 	return d, func() {
 		close(shutdown)
@@ -105,7 +111,7 @@ func TestPullAndSync_InitialSync(t *testing.T) {
 		lastKnownSyncTagRev      string
 		warnedAboutSyncTagChange bool
 	)
-	d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange)
+	d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false)
 
 	// It applies everything
 	if syncCalled != 1 {
@@ -180,7 +186,7 @@ func TestDoSync_NoNewCommits(t *testing.T) {
 		lastKnownSyncTagRev      string
 		warnedAboutSyncTagChange bool
 	)
-	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange); err != nil {
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false); err != nil {
 		t.Error(err)
 	}
 
@@ -280,7 +286,7 @@ func TestDoSync_WithNewCommit(t *testing.T) {
 		lastKnownSyncTagRev      string
 		warnedAboutSyncTagChange bool
 	)
-	d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange)
+	d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false)
 
 	// It applies everything
 	if syncCalled != 1 {
@@ -318,3 +324,771 @@ func TestDoSync_WithNewCommit(t *testing.T) {
 		t.Errorf("Should have moved sync tag to HEAD (%s), but was moved to: %s", newRevision, revs[len(revs)-1].Revision)
 	}
 }
+
+func TestDoSync_RefuseForcePush(t *testing.T) {
+	d, cleanup := daemon(t)
+	defer cleanup()
+	d.RefuseForcePush = true
+
+	ctx := context.Background()
+	// Point the sync tag at a commit that isn't on the branch, as
+	// though the branch had been rewritten since the last sync.
+	err := d.WithClone(ctx, func(checkout *git.Checkout) error {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, "git", "commit", "--allow-empty", "-m", "diverged")
+		cmd.Dir = checkout.Dir()
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %s", err, out)
+		}
+		return checkout.MoveSyncTagAndPush(ctx, git.TagAction{
+			Revision: "HEAD",
+			Message:  "Sync pointer",
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Repo.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	syncCalled := 0
+	k8s.SyncFunc = func(def cluster.SyncSet) error {
+		syncCalled++
+		return nil
+	}
+	// This daemon hasn't synced before, as though it had just
+	// restarted; but there are resources in the cluster from the
+	// syncs before
+	k8s.SyncedCountFunc = func(string) (int, error) {
+		return 2, nil
+	}
+	var (
+		logger                   = log.NewLogfmtLogger(ioutil.Discard)
+		lastKnownSyncTagRev      string
+		warnedAboutSyncTagChange bool
+	)
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false); err == nil {
+		t.Error("expected sync to be refused")
+	}
+	if syncCalled != 0 {
+		t.Errorf("Sync should not have been called, was called %d times", syncCalled)
+	}
+
+	// When following force-pushes, it syncs anyway
+	d.RefuseForcePush = false
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false); err != nil {
+		t.Error(err)
+	}
+	if syncCalled != 1 {
+		t.Errorf("Sync was not called once, was called %d times", syncCalled)
+	}
+}
+
+func TestDoSync_RecordsSyncedRevisions(t *testing.T) {
+	d, cleanup := daemon(t)
+	defer cleanup()
+
+	failing := flux.MustParseResourceID("default:deployment/helloworld")
+	k8s.SyncFunc = func(def cluster.SyncSet) error {
+		return cluster.SyncError{
+			{ResourceID: failing, Source: "helloworld-deploy.yaml", Error: fmt.Errorf("apply failed")},
+		}
+	}
+	var (
+		logger                   = log.NewLogfmtLogger(ioutil.Discard)
+		lastKnownSyncTagRev      string
+		warnedAboutSyncTagChange bool
+	)
+
+	ctx := context.Background()
+	head, err := d.Repo.Revision(ctx, d.GitConfig.Branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rev := d.syncedRevs.revision(failing); rev != "" {
+		t.Errorf("expected no revision before syncing, got %q", rev)
+	}
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if rev := d.syncedRevs.revision(failing); rev != "" {
+		t.Errorf("expected no revision for resource that failed to sync, got %q", rev)
+	}
+	for id := range testfiles.ResourceMap {
+		if id == failing {
+			continue
+		}
+		if rev := d.syncedRevs.revision(id); rev != head {
+			t.Errorf("expected %s to be synced at %s, got %q", id, head, rev)
+		}
+	}
+}
+
+func TestDoSync_SkipUnchanged(t *testing.T) {
+	d, cleanup := daemon(t)
+	defer cleanup()
+
+	syncCalled := 0
+	k8s.SyncFunc = func(def cluster.SyncSet) error {
+		syncCalled++
+		return nil
+	}
+	var (
+		logger                   = log.NewLogfmtLogger(ioutil.Discard)
+		lastKnownSyncTagRev      string
+		warnedAboutSyncTagChange bool
+	)
+
+	// Nothing has been synced yet, so this has to apply
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, true); err != nil {
+		t.Fatal(err)
+	}
+	if syncCalled != 1 {
+		t.Fatalf("Sync was not called once, was called %d times", syncCalled)
+	}
+
+	// Nothing has changed, so this can skip applying
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, true); err != nil {
+		t.Fatal(err)
+	}
+	if syncCalled != 1 {
+		t.Errorf("Sync should not have been called again, was called %d times", syncCalled)
+	}
+
+	// The synced resources have been changed in the cluster, so this
+	// has to apply, after which it can skip again
+	k8s.SyncedFingerprintFunc = func(string) (string, error) {
+		return "edited", nil
+	}
+	for i := 0; i < 2; i++ {
+		if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if syncCalled != 2 {
+		t.Errorf("Sync was not called once more after the cluster changed, was called %d times", syncCalled)
+	}
+
+	// An explicit sync applies regardless
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false); err != nil {
+		t.Fatal(err)
+	}
+	if syncCalled != 3 {
+		t.Errorf("Sync was not called three times, was called %d times", syncCalled)
+	}
+
+	// A failed sync means the next one has to apply
+	k8s.SyncFunc = func(def cluster.SyncSet) error {
+		syncCalled++
+		return cluster.SyncError{
+			{ResourceID: flux.MustParseResourceID("default:deployment/helloworld"), Error: fmt.Errorf("apply failed")},
+		}
+	}
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, true); err != nil {
+		t.Fatal(err)
+	}
+	if syncCalled != 5 {
+		t.Errorf("Sync was not called five times, was called %d times", syncCalled)
+	}
+}
+
+func TestDoSync_RefuseEmpty(t *testing.T) {
+	d, cleanup := daemon(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	// Sync as normal, then remove all the manifests, as though the
+	// repo (or the path) were misconfigured.
+	err := d.WithClone(ctx, func(checkout *git.Checkout) error {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		if err := checkout.MoveSyncTagAndPush(ctx, git.TagAction{
+			Revision: "HEAD",
+			Message:  "Sync pointer",
+		}); err != nil {
+			return err
+		}
+		files, err := ioutil.ReadDir(checkout.Dir())
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if f.Name() != ".git" {
+				if err := os.RemoveAll(filepath.Join(checkout.Dir(), f.Name())); err != nil {
+					return err
+				}
+			}
+		}
+		return checkout.CommitAndPush(ctx, git.CommitAction{Message: "remove everything"}, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Repo.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	syncCalled := 0
+	k8s.SyncFunc = func(def cluster.SyncSet) error {
+		syncCalled++
+		return nil
+	}
+	var (
+		logger                   = log.NewLogfmtLogger(ioutil.Discard)
+		lastKnownSyncTagRev      string
+		warnedAboutSyncTagChange bool
+	)
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false); err == nil {
+		t.Error("expected sync to be refused")
+	}
+	if syncCalled != 0 {
+		t.Errorf("Sync should not have been called, was called %d times", syncCalled)
+	}
+
+	// When empty syncs are allowed, it syncs anyway
+	d.AllowEmptySync = true
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false); err != nil {
+		t.Error(err)
+	}
+	if syncCalled != 1 {
+		t.Errorf("Sync was not called once, was called %d times", syncCalled)
+	}
+}
+
+func TestDoSync_HealthGate(t *testing.T) {
+	d, cleanup := daemon(t)
+	defer cleanup()
+
+	d.SyncHealthTimeout = 50 * time.Millisecond
+	d.syncHealthPollInterval = 10 * time.Millisecond
+	syncCalled := 0
+	k8s.SyncFunc = func(def cluster.SyncSet) error {
+		syncCalled++
+		return nil
+	}
+	var waitedFor []flux.ResourceID
+	k8s.SomeWorkloadsFunc = func(ids []flux.ResourceID) ([]cluster.Workload, error) {
+		waitedFor = ids
+		var workloads []cluster.Workload
+		for _, id := range ids {
+			workloads = append(workloads, cluster.Workload{ID: id, Status: cluster.StatusUpdating})
+		}
+		return workloads, nil
+	}
+
+	var (
+		logger                   = log.NewLogfmtLogger(ioutil.Discard)
+		lastKnownSyncTagRev      string
+		warnedAboutSyncTagChange bool
+	)
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false); err == nil {
+		t.Fatal("expected sync to fail while workloads are not ready")
+	}
+	if len(waitedFor) == 0 {
+		t.Error("expected to wait for some workloads")
+	}
+	for _, id := range waitedFor {
+		if _, ok := testfiles.WorkloadMap(d.Repo.Dir())[id]; !ok {
+			t.Errorf("waited for %s, which is not a workload", id)
+		}
+	}
+	ctx := context.Background()
+	if _, err := d.Repo.Revision(ctx, d.GitConfig.SyncTagRef()); err == nil {
+		t.Error("expected the sync tag not to have been created")
+	}
+
+	// Failing to look at the workloads at all fails the gate too; in
+	// either case, the manifests are applied again next time, even
+	// though they're unchanged
+	k8s.SomeWorkloadsFunc = func(ids []flux.ResourceID) ([]cluster.Workload, error) {
+		return nil, fmt.Errorf("cluster unreachable")
+	}
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, true); err == nil {
+		t.Fatal("expected sync to fail when workloads can't be looked at")
+	}
+	if syncCalled != 2 {
+		t.Errorf("expected unchanged manifests to be applied again after the gate failed, Sync was called %d times", syncCalled)
+	}
+
+	// Once they're ready, the tag is moved
+	k8s.SomeWorkloadsFunc = func(ids []flux.ResourceID) ([]cluster.Workload, error) {
+		var workloads []cluster.Workload
+		for _, id := range ids {
+			workloads = append(workloads, cluster.Workload{ID: id, Status: cluster.StatusReady})
+		}
+		return workloads, nil
+	}
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false); err != nil {
+		t.Fatal(err)
+	}
+	head, err := d.Repo.Revision(ctx, d.GitConfig.Branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastKnownSyncTagRev != head {
+		t.Errorf("expected sync tag to be at %s, got %q", head, lastKnownSyncTagRev)
+	}
+	if syncCalled != 3 {
+		t.Errorf("expected Sync to be called three times, was called %d times", syncCalled)
+	}
+
+	// Having passed the gate, unchanged manifests can be skipped
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, true); err != nil {
+		t.Fatal(err)
+	}
+	if syncCalled != 3 {
+		t.Errorf("expected unchanged manifests not to be applied again, Sync was called %d times", syncCalled)
+	}
+}
+
+// readinessCluster is a mock cluster that can tell whether resources
+// other than workloads are ready.
+type readinessCluster struct {
+	*cluster.Mock
+	notReady func([]resource.Resource) (map[flux.ResourceID]string, error)
+}
+
+func (c readinessCluster) NotReady(resources []resource.Resource) (map[flux.ResourceID]string, error) {
+	return c.notReady(resources)
+}
+
+func TestDoSync_HealthGateReadinessChecks(t *testing.T) {
+	d, cleanup := daemon(t)
+	defer cleanup()
+
+	d.SyncHealthTimeout = 50 * time.Millisecond
+	d.syncHealthPollInterval = 10 * time.Millisecond
+	k8s.SyncFunc = func(def cluster.SyncSet) error {
+		return nil
+	}
+	k8s.SomeWorkloadsFunc = func(ids []flux.ResourceID) ([]cluster.Workload, error) {
+		var workloads []cluster.Workload
+		for _, id := range ids {
+			workloads = append(workloads, cluster.Workload{ID: id, Status: cluster.StatusReady})
+		}
+		return workloads, nil
+	}
+	var checked []flux.ResourceID
+	ready := false
+	d.Cluster = readinessCluster{Mock: k8s, notReady: func(resources []resource.Resource) (map[flux.ResourceID]string, error) {
+		reasons := map[flux.ResourceID]string{}
+		for _, res := range resources {
+			checked = append(checked, res.ResourceID())
+			if !ready {
+				reasons[res.ResourceID()] = "not ready yet"
+			}
+		}
+		return reasons, nil
+	}}
+
+	var (
+		logger                   = log.NewLogfmtLogger(ioutil.Discard)
+		lastKnownSyncTagRev      string
+		warnedAboutSyncTagChange bool
+	)
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false); err == nil {
+		t.Fatal("expected sync to fail while resources are not ready")
+	}
+	if len(checked) == 0 {
+		t.Error("expected the readiness of some resources to be checked")
+	}
+	for _, id := range checked {
+		if _, ok := testfiles.WorkloadMap(d.Repo.Dir())[id]; ok {
+			t.Errorf("checked the readiness of %s, which is a workload", id)
+		}
+	}
+
+	ready = true
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false); err != nil {
+		t.Fatal(err)
+	}
+	head, err := d.Repo.Revision(context.Background(), d.GitConfig.Branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastKnownSyncTagRev != head {
+		t.Errorf("expected sync tag to be at %s, got %q", head, lastKnownSyncTagRev)
+	}
+}
+
+func TestDoSync_IsolateNamespaces(t *testing.T) {
+	d, cleanup := daemon(t)
+	defer cleanup()
+
+	d.SyncHealthTimeout = 50 * time.Millisecond
+	d.syncHealthPollInterval = 10 * time.Millisecond
+	d.SyncIsolateNamespaces = true
+	k8s.SyncFunc = func(def cluster.SyncSet) error {
+		return nil
+	}
+	unready := flux.MustParseResourceID("default:deployment/helloworld")
+	k8s.SomeWorkloadsFunc = func(ids []flux.ResourceID) ([]cluster.Workload, error) {
+		var workloads []cluster.Workload
+		for _, id := range ids {
+			status := cluster.StatusReady
+			if id == unready {
+				status = cluster.StatusError
+			}
+			workloads = append(workloads, cluster.Workload{ID: id, Status: status})
+		}
+		return workloads, nil
+	}
+
+	var (
+		logger                   = log.NewLogfmtLogger(ioutil.Discard)
+		lastKnownSyncTagRev      string
+		warnedAboutSyncTagChange bool
+	)
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false); err != nil {
+		t.Fatalf("expected sync to succeed with namespaces isolated, got %v", err)
+	}
+	ctx := context.Background()
+	head, err := d.Repo.Revision(ctx, d.GitConfig.Branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastKnownSyncTagRev != head {
+		t.Errorf("expected sync tag to be at %s, got %q", head, lastKnownSyncTagRev)
+	}
+
+	status, err := d.NamespaceSyncStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Revision != head || status.AllSynced {
+		t.Errorf("expected aggregate at %s with namespaces behind, got %+v", head, status)
+	}
+	if len(status.Namespaces) != 1 {
+		t.Fatalf("expected one namespace, got %+v", status.Namespaces)
+	}
+	ns := status.Namespaces[0]
+	if ns.Namespace != "default" || ns.Revision != "" || ns.FailedRevision != head || len(ns.Errors) != 1 {
+		t.Errorf("expected default to have failed at %s, got %+v", head, ns)
+	}
+
+	// Once it's ready, the namespace catches up
+	k8s.SomeWorkloadsFunc = func(ids []flux.ResourceID) ([]cluster.Workload, error) {
+		var workloads []cluster.Workload
+		for _, id := range ids {
+			workloads = append(workloads, cluster.Workload{ID: id, Status: cluster.StatusReady})
+		}
+		return workloads, nil
+	}
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := d.NamespaceSyncStatus(ctx); !status.AllSynced || status.Namespaces[0].Revision != head {
+		t.Errorf("expected all namespaces synced at %s, got %+v", head, status)
+	}
+
+	// The namespaces' revisions are kept in git, so they're restored
+	// after a restart
+	d.syncedRevs = syncedRevisions{}
+	d.syncState = nil
+	if err := d.Repo.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	err = d.WithClone(ctx, func(checkout *git.Checkout) error {
+		d.loadSyncState(ctx, logger, checkout, head)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := d.NamespaceSyncStatus(ctx); !status.AllSynced || len(status.Namespaces) != 1 || status.Namespaces[0].Revision != head {
+		t.Errorf("expected namespaces to be restored as synced at %s, got %+v", head, status)
+	}
+}
+
+func TestDoSync_SyncTagEvery(t *testing.T) {
+	d, cleanup := daemon(t)
+	defer cleanup()
+
+	d.SyncTagEvery = 2
+	k8s.SyncFunc = func(def cluster.SyncSet) error {
+		return nil
+	}
+	ctx := context.Background()
+	pushCommit := func(replicas string) string {
+		var rev string
+		err := d.WithClone(ctx, func(checkout *git.Checkout) error {
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			err := cluster.UpdateManifest(d.Manifests, checkout.Dir(), checkout.ManifestDirs(), flux.MustParseResourceID("default:deployment/helloworld"), func(def []byte) ([]byte, error) {
+				return []byte(regexp.MustCompile("replicas: [0-9]+").ReplaceAllString(string(def), "replicas: "+replicas)), nil
+			})
+			if err != nil {
+				return err
+			}
+			if err := checkout.CommitAndPush(ctx, git.CommitAction{Message: "test commit"}, nil); err != nil {
+				return err
+			}
+			rev, err = checkout.HeadRevision(ctx)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Repo.Refresh(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return rev
+	}
+
+	var (
+		logger                   = log.NewLogfmtLogger(ioutil.Discard)
+		lastKnownSyncTagRev      string
+		warnedAboutSyncTagChange bool
+	)
+	// The first sync of a new revision doesn't move the tag, but the
+	// revision counts as synced
+	first, err := d.Repo.Revision(ctx, d.GitConfig.Branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Repo.Revision(ctx, gitSyncTag); err == nil {
+		t.Error("expected the sync tag not to have been written")
+	}
+	if pending := d.pendingSyncTag.get(); pending != first {
+		t.Errorf("expected %s to be pending, got %q", first, pending)
+	}
+	if revs, err := d.SyncStatus(ctx, "HEAD"); err != nil || len(revs) != 0 {
+		t.Errorf("expected HEAD to be reported as synced, got %v (err: %v)", revs, err)
+	}
+
+	// The second moves it
+	second := pushCommit("4")
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false); err != nil {
+		t.Fatal(err)
+	}
+	if rev, err := d.Repo.Revision(ctx, gitSyncTag); err != nil || rev != second {
+		t.Errorf("expected the sync tag to be at %s, got %q (err: %v)", second, rev, err)
+	}
+	if pending := d.pendingSyncTag.get(); pending != "" {
+		t.Errorf("expected nothing pending, got %q", pending)
+	}
+
+	// A pending revision is pushed when the loop stops
+	third := pushCommit("3")
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// It's kept in git too, so it's restored after a restart, rather
+	// than the revisions since the tag being synced again
+	d.pendingSyncTag.reset()
+	d.syncStateLoaded = false
+	if err := d.Repo.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false); err != nil {
+		t.Fatal(err)
+	}
+	if pending := d.pendingSyncTag.get(); pending != third {
+		t.Errorf("expected %s to be pending after a restart, got %q", third, pending)
+	}
+	d.flushSyncTag(logger)
+	if err := d.Repo.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if rev, err := d.Repo.Revision(ctx, gitSyncTag); err != nil || rev != third {
+		t.Errorf("expected the sync tag to be at %s, got %q (err: %v)", third, rev, err)
+	}
+}
+
+func TestDoSync_NotifySyncRecovery(t *testing.T) {
+	d, cleanup := daemon(t)
+	defer cleanup()
+
+	d.NotifySyncRecovery = true
+	var syncErr error
+	k8s.SyncFunc = func(def cluster.SyncSet) error {
+		return syncErr
+	}
+
+	var (
+		logger                   = log.NewLogfmtLogger(ioutil.Discard)
+		lastKnownSyncTagRev      string
+		warnedAboutSyncTagChange bool
+	)
+	syncOnce := func() {
+		started := time.Now()
+		err := d.doSync(logger, &lastKnownSyncTagRev, &warnedAboutSyncTagChange, false)
+		d.noteSyncOutcome(logger, started, err)
+	}
+	recoveries := func() []*event.SyncRecoveredEventMetadata {
+		es, err := events.AllEvents(time.Time{}, -1, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found []*event.SyncRecoveredEventMetadata
+		for _, e := range es {
+			if e.Type == event.EventSyncRecovered {
+				found = append(found, e.Metadata.(*event.SyncRecoveredEventMetadata))
+			}
+		}
+		return found
+	}
+
+	// A resource failing, and the sync failing outright, both count
+	// as failures
+	syncErr = cluster.SyncError{
+		{ResourceID: flux.MustParseResourceID("default:deployment/helloworld"), Error: fmt.Errorf("apply failed")},
+	}
+	syncOnce()
+	syncErr = fmt.Errorf("cluster unreachable")
+	syncOnce()
+	if len(recoveries()) != 0 {
+		t.Fatal("expected no recovery event while syncs are failing")
+	}
+
+	syncErr = nil
+	syncOnce()
+	syncOnce()
+	found := recoveries()
+	if len(found) != 1 {
+		t.Fatalf("expected one recovery event, got %d", len(found))
+	}
+	head, err := d.Repo.Revision(context.Background(), d.GitConfig.Branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovered := found[0]
+	if recovered.Revision != head || recovered.FailedSyncs != 2 || recovered.LastError != "cluster unreachable" {
+		t.Errorf("unexpected recovery event %+v", recovered)
+	}
+	if !recovered.FailingSince.Before(recovered.RecoveredAt) {
+		t.Errorf("expected failures to start before the recovery, got %+v", recovered)
+	}
+
+	// A new run of failures gets its own event
+	syncErr = fmt.Errorf("cluster unreachable")
+	syncOnce()
+	syncErr = nil
+	syncOnce()
+	if found := recoveries(); len(found) != 2 || found[1].FailedSyncs != 1 {
+		t.Errorf("expected a second recovery event after one failed sync, got %+v", found)
+	}
+}
+
+func TestLoop_ConcurrentImagePoll(t *testing.T) {
+	d, cleanup := daemon(t)
+	defer cleanup()
+
+	d.SyncInterval = time.Hour
+	d.RegistryPollInterval = time.Hour
+	d.ConcurrentImagePoll = true
+
+	syncStarted := make(chan struct{}, 1)
+	releaseSync := make(chan struct{})
+	k8s.SyncFunc = func(def cluster.SyncSet) error {
+		select {
+		case syncStarted <- struct{}{}:
+		default:
+		}
+		<-releaseSync
+		return nil
+	}
+	polled := make(chan struct{}, 1)
+	k8s.SomeWorkloadsFunc = func([]flux.ResourceID) ([]cluster.Workload, error) {
+		select {
+		case polled <- struct{}{}:
+		default:
+		}
+		return nil, nil
+	}
+
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go d.Loop(stop, wg, log.NewLogfmtLogger(ioutil.Discard))
+	defer func() {
+		close(releaseSync)
+		close(stop)
+		wg.Wait()
+	}()
+
+	select {
+	case <-syncStarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for sync to start")
+	}
+	// The sync is now blocked; images should be polled regardless
+	select {
+	case <-polled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("images were not polled while the sync was in progress")
+	}
+}
+
+func TestLoop_Heartbeat(t *testing.T) {
+	d, cleanup := daemon(t)
+	defer cleanup()
+
+	d.SyncInterval = time.Hour
+	d.RegistryPollInterval = time.Hour
+	d.HeartbeatInterval = 20 * time.Millisecond
+
+	started := time.Now()
+	heartbeatsBefore := metricstest.Value(t, "flux_daemon_loop_heartbeats_total", nil)
+
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go d.Loop(stop, wg, log.NewLogfmtLogger(ioutil.Discard))
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	// With nothing to do after starting up, the loop still comes
+	// round, and counts the time since it last did something
+	w := newWait(t)
+	w.Eventually(func() bool {
+		return metricstest.Value(t, "flux_daemon_loop_heartbeats_total", nil) >= heartbeatsBefore+3
+	}, "Waiting for heartbeats")
+	w.Eventually(func() bool {
+		return metricstest.Value(t, "flux_daemon_loop_seconds_since_activity", nil) > 0
+	}, "Waiting for the time since activity to be counted")
+	if last := metricstest.Value(t, "flux_daemon_loop_heartbeat_timestamp_seconds", nil); last < float64(started.Unix()) {
+		t.Errorf("expected the last heartbeat to be after %d, got %v", started.Unix(), last)
+	}
+}
+
+func TestSyncTrigger(t *testing.T) {
+	loop := &LoopVars{}
+
+	// A timed sync is put down to the timer, unless something else
+	// asks before it starts
+	loop.askForTimedSync()
+	if trigger := loop.takeSyncTrigger(); trigger != syncTriggerTimer {
+		t.Errorf("expected trigger %q, got %q", syncTriggerTimer, trigger)
+	}
+	<-loop.syncSoon
+
+	loop.askForTimedSync()
+	loop.askForSync(syncTriggerGit)
+	loop.AskForSync()
+	if trigger := loop.takeSyncTrigger(); trigger != syncTriggerGit {
+		t.Errorf("expected trigger %q, got %q", syncTriggerGit, trigger)
+	}
+	<-loop.syncSoon
+
+	// Once taken, the next ask is recorded afresh
+	loop.AskForSync()
+	if trigger := loop.takeSyncTrigger(); trigger != syncTriggerExplicit {
+		t.Errorf("expected trigger %q, got %q", syncTriggerExplicit, trigger)
+	}
+}
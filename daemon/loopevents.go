@@ -0,0 +1,67 @@
+package daemon
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/flux/api/v12"
+)
+
+// How many of the most recent loop events are kept, for LoopEvents.
+const loopEventsKept = 500
+
+// loopEvents keeps the most recent events from the daemon's loop, so
+// they can be fetched through the API (e.g., by `fluxctl logs`), by
+// those who can't see the daemon's logs. The zero value is ready to
+// use.
+type loopEvents struct {
+	mu     sync.Mutex
+	events []v12.LoopEvent
+}
+
+// record adds an event of the type given; if err is not nil, it's
+// included as the event's error.
+func (l *loopEvents) record(eventType, message string, err error) {
+	e := v12.LoopEvent{
+		Time:    time.Now().UTC(),
+		Type:    eventType,
+		Message: message,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+	if len(l.events) > loopEventsKept {
+		l.events = append([]v12.LoopEvent(nil), l.events[len(l.events)-loopEventsKept:]...)
+	}
+}
+
+// matching gives the events after the time given (if it's not zero)
+// and of the types given (if there are any), oldest first.
+func (l *loopEvents) matching(opts v12.LoopEventsOptions) []v12.LoopEvent {
+	types := map[string]bool{}
+	for _, t := range opts.Types {
+		types[t] = true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	result := []v12.LoopEvent{}
+	for _, e := range l.events {
+		if !opts.Since.IsZero() && !e.Time.After(opts.Since) {
+			continue
+		}
+		if len(types) > 0 && !types[e.Type] {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+// LoopEvents gives the most recent events from the daemon's loop.
+func (d *Daemon) LoopEvents(ctx context.Context, opts v12.LoopEventsOptions) ([]v12.LoopEvent, error) {
+	return d.loopEvents.matching(opts), nil
+}
@@ -0,0 +1,86 @@
+package daemon
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux/api/v12"
+	"github.com/weaveworks/flux/cluster"
+	fluxerr "github.com/weaveworks/flux/errors"
+	"github.com/weaveworks/flux/git"
+	fluxsync "github.com/weaveworks/flux/sync"
+)
+
+// MergePreview gives what syncing would change, were the branch
+// given merged into the branch that's synced: the resources in the
+// merged manifests that differ from those in the cluster. Nothing is
+// merged or applied. If the branch doesn't merge cleanly, the files
+// with conflicts are given instead.
+func (d *Daemon) MergePreview(ctx context.Context, opts v12.MergePreviewOptions) (v12.MergePreview, error) {
+	preview := v12.MergePreview{Branch: opts.Branch}
+	if opts.Branch == "" {
+		return preview, &fluxerr.Error{
+			Type: fluxerr.User,
+			Err:  errors.New("no branch given to preview merging"),
+			Help: "Give the branch to preview merging into the synced branch.",
+		}
+	}
+	if err := git.ValidateBranch(opts.Branch); err != nil {
+		return preview, &fluxerr.Error{
+			Type: fluxerr.User,
+			Err:  err,
+			Help: "Give the name of the branch to preview merging, e.g., feature/foo.",
+		}
+	}
+	detector, ok := d.Cluster.(cluster.DriftDetector)
+	if !ok {
+		return preview, errors.New("previewing merges is not supported for this cluster")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.GitOpTimeout)
+	defer cancel()
+	var err error
+	if preview.BaseRevision, err = d.Repo.Revision(ctx, d.GitConfig.Branch); err != nil {
+		return preview, err
+	}
+	if preview.BranchRevision, err = d.Repo.Revision(ctx, opts.Branch); err != nil {
+		return preview, &fluxerr.Error{
+			Type: fluxerr.User,
+			Err:  errors.Wrapf(err, "finding branch %s", opts.Branch),
+			Help: "The branch to preview merging must be in the git repo; if it was pushed recently, try again once fluxd has fetched it.",
+		}
+	}
+
+	export, err := d.Repo.ExportMerge(ctx, preview.BaseRevision, opts.Branch)
+	if conflict, ok := err.(*git.MergeConflictError); ok {
+		preview.Conflicts = conflict.Files
+		return preview, nil
+	}
+	if err != nil {
+		return preview, err
+	}
+	defer export.Clean()
+
+	// The resources in files too large to load would be skipped by
+	// a sync, too, so they're left out of the preview
+	resources, _, err := cluster.LoadManifestsSkipping(d.Manifests, export.Dir(), d.manifestPaths(export.Dir()))
+	if err != nil {
+		return preview, manifestLoadError(err)
+	}
+	drifts, err := fluxsync.Drift(makeGitConfigHash(d.Repo.Origin(), d.GitConfig), resources, detector)
+	if err != nil {
+		return preview, err
+	}
+	for _, drift := range drifts {
+		preview.Changes = append(preview.Changes, v12.ResourceChange{
+			ID:        drift.ResourceID,
+			Source:    drift.Source,
+			Missing:   drift.Missing,
+			Deleted:   drift.Deleted,
+			Diff:      drift.Diff,
+			Truncated: drift.Truncated,
+		})
+	}
+	return preview, nil
+}
@@ -16,7 +16,16 @@ var (
 		Name:      "sync_duration_seconds",
 		Help:      "Duration of git-to-cluster synchronisation, in seconds.",
 		Buckets:   []float64{0.5, 5, 10, 20, 30, 40, 50, 60, 75, 90, 120, 240},
-	}, []string{fluxmetrics.LabelSuccess})
+	}, []string{fluxmetrics.LabelSuccess, fluxmetrics.LabelTrigger})
+
+	// Several requests for a sync may be answered by the same sync,
+	// so these can add up to more than the syncs counted above.
+	syncRequests = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "daemon",
+		Name:      "sync_requests_total",
+		Help:      "Count of requests for a sync, by what made the request.",
+	}, []string{fluxmetrics.LabelTrigger})
 
 	// For most jobs, the majority of the time will be spent pushing
 	// changes (git objects and refs) upstream.
@@ -38,6 +47,121 @@ var (
 		Buckets:   []float64{0.1, 0.5, 1, 2, 5, 10, 15, 20, 30, 45, 60, 120},
 	}, []string{})
 
+	nonFastForwardCount = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "daemon",
+		Name:      "non_fast_forward_total",
+		Help:      "Count of syncs in which the branch HEAD was not a descendant of the last synced revision.",
+	}, []string{})
+
+	noopSyncCount = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "daemon",
+		Name:      "sync_skipped_total",
+		Help:      "Count of syncs in which applying was skipped because the manifests were unchanged.",
+	}, []string{})
+
+	emptySyncRefusedCount = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "daemon",
+		Name:      "sync_empty_refused_total",
+		Help:      "Count of syncs refused because no manifests were found, though the last sync found some.",
+	}, []string{})
+
+	skippedFilesCount = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "daemon",
+		Name:      "sync_skipped_files_total",
+		Help:      "Count of manifest files left out of syncs for being larger than the limit on their size.",
+	}, []string{})
+
+	healthGateDuration = prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+		Namespace: "flux",
+		Subsystem: "daemon",
+		Name:      "sync_health_wait_seconds",
+		Help:      "Time spent waiting for workloads to be ready after a sync, before moving the sync tag, in seconds.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{})
+
+	healthGateTimeouts = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "daemon",
+		Name:      "sync_health_timeouts_total",
+		Help:      "Count of syncs failed because workloads were not ready before the health timeout.",
+	}, []string{})
+
+	syncVerifications = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "daemon",
+		Name:      "sync_verifications_total",
+		Help:      "Count of verifications of synced revisions, by outcome.",
+	}, []string{"outcome"})
+
+	syncLeader = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "flux",
+		Subsystem: "daemon",
+		Name:      "sync_leader",
+		Help:      "Whether this instance is the one syncing (1) or not (0), when there are several.",
+	}, []string{})
+
+	loopHeartbeats = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "daemon",
+		Name:      "loop_heartbeats_total",
+		Help:      "Count of times round the daemon loop, including heartbeats when there is nothing to do.",
+	}, []string{})
+
+	loopHeartbeatTime = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "flux",
+		Subsystem: "daemon",
+		Name:      "loop_heartbeat_timestamp_seconds",
+		Help:      "When the daemon loop last came round, as a Unix timestamp.",
+	}, []string{})
+
+	loopSinceActivity = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "flux",
+		Subsystem: "daemon",
+		Name:      "loop_seconds_since_activity",
+		Help:      "Seconds since the daemon loop last did something other than a heartbeat, as of the last time round.",
+	}, []string{})
+
+	automationRollouts = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "flux",
+		Subsystem: "daemon",
+		Name:      "automation_rollouts_in_progress",
+		Help:      "Count of automated workloads with a rollout in progress, as of the last image poll.",
+	}, []string{})
+
+	// A workload getting commits at every poll suggests its tag
+	// filter matches tags it shouldn't.
+	automationCommits = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "automation",
+		Name:      "commits_total",
+		Help:      "Count of automated image updates committed, by workload.",
+	}, []string{"workload"})
+
+	automationUnchanged = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "automation",
+		Name:      "unchanged_total",
+		Help:      "Count of automation runs that found nothing to update.",
+	}, []string{})
+
+	automationUnsigned = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "automation",
+		Name:      "unsigned_images_total",
+		Help:      "Count of times an image was passed over by automation because it isn't signed as required, by registry.",
+	}, []string{"registry"})
+
+	automationPaced = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "automation",
+		Name:      "paced_total",
+		Help:      "Count of times an automated image update was held back to respect a disruption budget, by budget.",
+	}, []string{"budget"})
+
 	queueLength = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
 		Namespace: "flux",
 		Subsystem: "daemon",
@@ -0,0 +1,76 @@
+package daemon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux/event"
+)
+
+// syncEpisode keeps track of a run of failing syncs, so that when
+// syncing works again, a recovery event can be sent, once for the
+// whole run. A sync fails if it returns an error, or if any resources
+// fail to sync or (with a health timeout) to become ready. It's only
+// accessed from the loop goroutine, and is kept in memory only, so a
+// run of failures that spans a restart of the daemon is taken to
+// start at the first failure after the restart.
+type syncEpisode struct {
+	// The revision of the sync in progress, and how many resources
+	// failed in it; set by doSync
+	revision string
+	failures int
+
+	// When the first failing sync of this run was, or zero if the
+	// last sync succeeded
+	failingSince time.Time
+	failedSyncs  int
+	lastError    string
+}
+
+// noteSyncOutcome records the outcome of the sync just done, that
+// started at the time given, and sends a recovery event if it
+// succeeded after syncs that failed.
+func (d *Daemon) noteSyncOutcome(logger log.Logger, started time.Time, err error) {
+	e := &d.syncEpisode
+	if err != nil || e.failures > 0 {
+		if e.failingSince.IsZero() {
+			e.failingSince = started
+		}
+		e.failedSyncs++
+		if err != nil {
+			e.lastError = err.Error()
+		} else {
+			e.lastError = fmt.Sprintf("%d resource(s) failed to sync", e.failures)
+		}
+		return
+	}
+	if e.failingSince.IsZero() {
+		return
+	}
+
+	metadata := &event.SyncRecoveredEventMetadata{
+		Revision:     e.revision,
+		FailingSince: e.failingSince.UTC(),
+		RecoveredAt:  time.Now().UTC(),
+		FailedSyncs:  e.failedSyncs,
+		LastError:    e.lastError,
+	}
+	// Whether or not the event is sent, this run of failures is
+	// over; sending it again after the next failure would be noise
+	e.failingSince, e.failedSyncs, e.lastError = time.Time{}, 0, ""
+	logger.Log("info", "sync succeeded after failing", "revision", metadata.Revision, "failed-syncs", metadata.FailedSyncs, "failing-for", metadata.FailingFor())
+	if !d.NotifySyncRecovery {
+		return
+	}
+	if err := d.LogEvent(event.Event{
+		Type:      event.EventSyncRecovered,
+		StartedAt: metadata.FailingSince,
+		EndedAt:   metadata.RecoveredAt,
+		LogLevel:  event.LogLevelInfo,
+		Metadata:  metadata,
+	}); err != nil {
+		logger.Log("err", err)
+	}
+}
@@ -0,0 +1,165 @@
+package daemon
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/api/v12"
+	fluxmetrics "github.com/weaveworks/flux/metrics"
+	"github.com/weaveworks/flux/policy"
+	"github.com/weaveworks/flux/resource"
+	fluxsync "github.com/weaveworks/flux/sync"
+)
+
+// The shortest sync interval a resource can have; shorter intervals
+// are taken to be this. Resources due to be synced within this long
+// of one another are synced together, so that the intervals of
+// several resources that (nearly) line up share syncs.
+const minResourceSyncInterval = 10 * time.Second
+
+// resourceSchedule keeps track of the resources with a sync interval
+// of their own (the sync-interval annotation) that's shorter than
+// the loop's, and when each is next due to be synced. Between full
+// syncs, these resources are synced again on their own, as of the
+// revision last synced; a full sync applies them along with
+// everything else, and starts their intervals again.
+type resourceSchedule struct {
+	mu        sync.Mutex
+	revision  string
+	resources map[string]resource.Resource
+	intervals map[flux.ResourceID]time.Duration
+	due       map[flux.ResourceID]time.Time
+}
+
+// resourceSyncInterval gives the sync interval of the resource given,
+// if it has one that's shorter than the loop's sync interval given;
+// otherwise zero.
+func resourceSyncInterval(logger log.Logger, res resource.Resource, loopInterval time.Duration) time.Duration {
+	value, ok := res.Policies().Get(policy.SyncInterval)
+	if !ok {
+		return 0
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		logger.Log("warning", "ignoring invalid sync interval", "resource", res.ResourceID(), "interval", value)
+		return 0
+	}
+	if interval < minResourceSyncInterval {
+		interval = minResourceSyncInterval
+	}
+	if interval >= loopInterval {
+		return 0
+	}
+	return interval
+}
+
+// reset starts the intervals of the resources given that have one,
+// after a full sync of the revision given.
+func (s *resourceSchedule) reset(logger log.Logger, revision string, resources map[string]resource.Resource, loopInterval time.Duration, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revision = revision
+	s.resources = map[string]resource.Resource{}
+	s.intervals = map[flux.ResourceID]time.Duration{}
+	s.due = map[flux.ResourceID]time.Time{}
+	for id, res := range resources {
+		interval := resourceSyncInterval(logger, res, loopInterval)
+		if interval == 0 {
+			continue
+		}
+		s.resources[id] = res
+		s.intervals[res.ResourceID()] = interval
+		s.due[res.ResourceID()] = now.Add(interval)
+	}
+}
+
+// next gives when the next resource is due to be synced, or false if
+// there are no resources with intervals of their own.
+func (s *resourceSchedule) next() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var next time.Time
+	for _, t := range s.due {
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// takeDue gives the resources due to be synced by now, or within
+// minResourceSyncInterval of now, and the revision they come from,
+// and starts their intervals again.
+func (s *resourceSchedule) takeDue(now time.Time) (string, map[string]resource.Resource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	due := map[string]resource.Resource{}
+	for id, res := range s.resources {
+		resID := res.ResourceID()
+		if s.due[resID].After(now.Add(minResourceSyncInterval)) {
+			continue
+		}
+		due[id] = res
+		s.due[resID] = now.Add(s.intervals[resID])
+	}
+	return s.revision, due
+}
+
+// interval gives the sync interval of the resource with the ID
+// given, as of the last full sync, if it has one of its own;
+// otherwise zero.
+func (s *resourceSchedule) interval(id flux.ResourceID) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.intervals[id]
+}
+
+// resetResourceSyncTimer sets the timer given to go off when the
+// next resource is due to be synced, if any are.
+func (d *Daemon) resetResourceSyncTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	if next, ok := d.resourceSchedule.next(); ok {
+		timer.Reset(time.Until(next))
+	}
+}
+
+// syncDueResources applies the resources that are due to be synced
+// because of their own sync intervals, as of the revision last
+// synced, in the same way as a full sync (see applySync), and with
+// the same loop events. Nothing is garbage collected, and the sync
+// tag isn't moved.
+func (d *Daemon) syncDueResources(logger log.Logger) {
+	if !d.isSyncLeader() {
+		return
+	}
+	revision, due := d.resourceSchedule.takeDue(time.Now())
+	if len(due) == 0 {
+		return
+	}
+	logger = log.With(logger, "revision", revision, "trigger", syncTriggerResourceInterval)
+	d.loopEvents.record(v12.LoopEventSync, fmt.Sprintf("sync started (%s)", syncTriggerResourceInterval), nil)
+	started := time.Now()
+	_, err := d.applySync(logger, makeGitConfigHash(d.Repo.Origin(), d.GitConfig), due, fluxsync.Options{
+		Revision: revision,
+		Partial:  true,
+	})
+	syncDuration.With(
+		fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		fluxmetrics.LabelTrigger, syncTriggerResourceInterval,
+	).Observe(time.Since(started).Seconds())
+	if err != nil {
+		logger.Log("err", err, "resources", len(due))
+		d.loopEvents.record(v12.LoopEventSync, "sync failed", err)
+	} else {
+		d.loopEvents.record(v12.LoopEventSync, "sync succeeded", nil)
+	}
+}
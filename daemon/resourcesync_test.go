@@ -0,0 +1,133 @@
+package daemon
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/api/v12"
+	"github.com/weaveworks/flux/cluster"
+	kresource "github.com/weaveworks/flux/cluster/kubernetes/resource"
+	"github.com/weaveworks/flux/resource"
+)
+
+const intervalManifests = `---
+apiVersion: v1
+kind: Service
+metadata:
+  name: often
+  namespace: default
+  annotations:
+    flux.weave.works/sync-interval: 1m
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: sometimes
+  namespace: default
+  annotations:
+    flux.weave.works/sync-interval: 2m
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: rarely
+  namespace: default
+  annotations:
+    flux.weave.works/sync-interval: 1h
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: usual
+  namespace: default
+`
+
+func TestResourceSchedule(t *testing.T) {
+	manifests, err := kresource.ParseMultidoc([]byte(intervalManifests), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources := map[string]resource.Resource{}
+	for id, m := range manifests {
+		resources[id] = m
+	}
+	often := flux.MustParseResourceID("default:service/often")
+	sometimes := flux.MustParseResourceID("default:service/sometimes")
+
+	var s resourceSchedule
+	start := time.Now()
+	s.reset(log.NewNopLogger(), "abc123", resources, 5*time.Minute, start)
+
+	// Intervals no shorter than the loop's make no difference
+	assert.Equal(t, time.Minute, s.interval(often))
+	assert.Equal(t, 2*time.Minute, s.interval(sometimes))
+	assert.Equal(t, time.Duration(0), s.interval(flux.MustParseResourceID("default:service/rarely")))
+	assert.Equal(t, time.Duration(0), s.interval(flux.MustParseResourceID("default:service/usual")))
+
+	next, ok := s.next()
+	assert.True(t, ok)
+	assert.Equal(t, start.Add(time.Minute), next)
+
+	revision, due := s.takeDue(start.Add(time.Minute))
+	assert.Equal(t, "abc123", revision)
+	assert.Len(t, due, 1)
+	assert.Contains(t, due, often.String())
+
+	// At two minutes, both are due, and are synced together
+	_, due = s.takeDue(start.Add(2 * time.Minute))
+	assert.Len(t, due, 2)
+}
+
+type validatorFunc func(map[string]resource.Resource) error
+
+func (f validatorFunc) Validate(resources map[string]resource.Resource) error {
+	return f(resources)
+}
+
+// Resources synced on their own intervals go through the same checks
+// as a full sync, and are reported in the same loop events
+func TestSyncDueResources(t *testing.T) {
+	d, _, clean, k8s, _, _ := mockDaemon(t)
+	defer clean()
+
+	manifests, err := kresource.ParseMultidoc([]byte(intervalManifests), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources := map[string]resource.Resource{}
+	for id, m := range manifests {
+		resources[id] = m
+	}
+	var synced []cluster.SyncSet
+	k8s.SyncFunc = func(syncSet cluster.SyncSet) error {
+		synced = append(synced, syncSet)
+		return nil
+	}
+	d.Validator = validatorFunc(func(map[string]resource.Resource) error {
+		return errors.New("invalid")
+	})
+
+	logger := log.NewNopLogger()
+	d.resourceSchedule.reset(logger, "abc123", resources, 5*time.Minute, time.Now().Add(-time.Hour))
+	d.syncDueResources(logger)
+	assert.Empty(t, synced)
+	events := d.loopEvents.matching(v12.LoopEventsOptions{})
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "sync started (resource-interval)", events[0].Message)
+		assert.Equal(t, "sync failed", events[1].Message)
+	}
+
+	d.Validator = nil
+	d.resourceSchedule.reset(logger, "abc123", resources, 5*time.Minute, time.Now().Add(-time.Hour))
+	d.syncDueResources(logger)
+	if assert.Len(t, synced, 1) {
+		assert.Len(t, synced[0].Resources, 2)
+	}
+	events = d.loopEvents.matching(v12.LoopEventsOptions{})
+	assert.Equal(t, "sync succeeded", events[len(events)-1].Message)
+}
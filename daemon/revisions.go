@@ -0,0 +1,184 @@
+package daemon
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/api/v12"
+	"github.com/weaveworks/flux/cluster"
+	"github.com/weaveworks/flux/policy"
+	"github.com/weaveworks/flux/resource"
+)
+
+// syncedRevisions keeps track of the git revision each resource was
+// last successfully applied from. Since a sync can partially fail,
+// resources can lag behind the sync tag (and each other); this is
+// for reporting which.
+//
+// It's kept in memory only, so after a restart resources are
+// reported as not (yet) synced until the next sync; but the revision
+// each namespace was last synced at is kept in git as well (see
+// saveSyncState), and restored from there.
+type syncedRevisions struct {
+	mu        sync.RWMutex
+	revisions map[flux.ResourceID]string
+	// how far each namespace has been synced, and the revision most
+	// recently synced (across namespaces)
+	namespaces map[string]v12.NamespaceSync
+	latest     string
+	// the files skipped in the last sync, for being too large
+	skipped []string
+}
+
+// record notes that the resources given were applied at the
+// revision given, except for those that failed, or were ignored and
+// therefore not applied.
+func (s *syncedRevisions) record(revision string, resources map[string]resource.Resource, failed flux.ResourceIDSet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.revisions == nil {
+		s.revisions = map[flux.ResourceID]string{}
+	}
+	for _, res := range resources {
+		id := res.ResourceID()
+		if failed.Contains(id) || res.Policies().Has(policy.Ignore) {
+			continue
+		}
+		s.revisions[id] = revision
+	}
+}
+
+// revision returns the revision the resource was last synced from,
+// or the empty string if it hasn't been synced by this daemon.
+func (s *syncedRevisions) revision(id flux.ResourceID) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.revisions[id]
+}
+
+// recordNamespaces notes, for each namespace with resources, whether
+// it synced cleanly at the revision given: that is, none of its
+// resources are among the failures given (which gives the reason for
+// each). Namespaces that no longer have any resources are forgotten.
+func (s *syncedRevisions) recordNamespaces(revision string, resources map[string]resource.Resource, failures map[flux.ResourceID]string) {
+	errs := map[string][]string{}
+	for id, reason := range failures {
+		ns, _, _ := id.Components()
+		errs[ns] = append(errs[ns], id.String()+": "+reason)
+	}
+	seen := map[string]bool{}
+	for _, res := range resources {
+		if res.Policies().Has(policy.Ignore) {
+			continue
+		}
+		ns, _, _ := res.ResourceID().Components()
+		seen[ns] = true
+	}
+	for ns := range errs {
+		seen[ns] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.namespaces
+	s.namespaces = map[string]v12.NamespaceSync{}
+	for ns := range seen {
+		status := previous[ns]
+		status.Namespace = ns
+		if nsErrs, ok := errs[ns]; ok {
+			sort.Strings(nsErrs)
+			status.FailedRevision = revision
+			status.Errors = nsErrs
+		} else {
+			status.Revision = revision
+			status.FailedRevision = ""
+			status.Errors = nil
+		}
+		s.namespaces[ns] = status
+	}
+	s.latest = revision
+}
+
+// namespaceRevisions gives the revision each namespace was last
+// synced cleanly at, for those that have been.
+func (s *syncedRevisions) namespaceRevisions() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	revisions := map[string]string{}
+	for ns, status := range s.namespaces {
+		if status.Revision != "" {
+			revisions[ns] = status.Revision
+		}
+	}
+	return revisions
+}
+
+// restoreNamespaces sets the revision each namespace was last synced
+// cleanly at, and the revision most recently synced, as they were
+// before a restart; unless a sync has been recorded since.
+func (s *syncedRevisions) restoreNamespaces(latest string, revisions map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.latest != "" {
+		return
+	}
+	s.latest = latest
+	s.namespaces = map[string]v12.NamespaceSync{}
+	for ns, rev := range revisions {
+		s.namespaces[ns] = v12.NamespaceSync{Namespace: ns, Revision: rev}
+	}
+}
+
+// namespaceStatus gives the status of each namespace, sorted by name,
+// and the aggregate.
+func (s *syncedRevisions) namespaceStatus() v12.NamespacesSync {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := v12.NamespacesSync{
+		Revision:     s.latest,
+		AllSynced:    len(s.skipped) == 0,
+		Namespaces:   []v12.NamespaceSync{},
+		SkippedFiles: s.skipped,
+	}
+	for _, status := range s.namespaces {
+		if status.Revision != s.latest || status.FailedRevision != "" {
+			result.AllSynced = false
+		}
+		result.Namespaces = append(result.Namespaces, status)
+	}
+	sort.Slice(result.Namespaces, func(i, j int) bool {
+		return result.Namespaces[i].Namespace < result.Namespaces[j].Namespace
+	})
+	return result
+}
+
+// recordSkipped notes the files skipped in the last sync, replacing
+// those noted before.
+func (s *syncedRevisions) recordSkipped(skipped *cluster.SkippedFilesError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipped = nil
+	if skipped == nil {
+		return
+	}
+	for _, f := range skipped.Files {
+		s.skipped = append(s.skipped, f.Source)
+	}
+}
+
+// NamespaceSyncStatus gives how far each namespace has been synced.
+func (d *Daemon) NamespaceSyncStatus(ctx context.Context) (v12.NamespacesSync, error) {
+	status := d.syncedRevs.namespaceStatus()
+	if deleter, ok := d.Cluster.(cluster.PendingDeleter); ok {
+		syncSetName := makeGitConfigHash(d.Repo.Origin(), d.GitConfig)
+		for _, pending := range deleter.PendingDeletions(syncSetName) {
+			status.PendingDeletions = append(status.PendingDeletions, v12.PendingDeletion{
+				ID:          pending.ID,
+				DeleteAfter: pending.DeleteAfter,
+			})
+		}
+	}
+	return status, nil
+}
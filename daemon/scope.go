@@ -0,0 +1,22 @@
+package daemon
+
+import (
+	"github.com/weaveworks/flux/git"
+)
+
+// ForScope makes a daemon that syncs only the scope given, sharing
+// the repo mirror, cluster and event writer with this daemon. Since
+// the scope has its own paths, it also has its own sync set, so
+// garbage collection in one scope won't touch the resources of
+// another.
+//
+// The daemon returned only runs syncs in its loop; jobs and image
+// polling are still done by this daemon.
+func (d *Daemon) ForScope(scope *git.Scope) *Daemon {
+	scoped := *d
+	scoped.GitConfig = scope.Config(d.GitConfig)
+	scoped.Scope = scope
+	// The loop's state is its own, but not its configuration
+	scoped.LoopVars = &LoopVars{LoopConfig: d.LoopConfig}
+	return &scoped
+}
@@ -0,0 +1,39 @@
+package daemon
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux/api/v12"
+	"github.com/weaveworks/flux/git"
+)
+
+func TestForScope(t *testing.T) {
+	d := &Daemon{
+		GitConfig: git.Config{Branch: "master", SyncTag: "flux-sync", Paths: []string{"all"}},
+		Config:    v12.DaemonConfig{Version: "test", Flags: []v12.ConfigFlag{{Name: "git-branch", Values: []string{"master"}}}},
+		LoopVars: &LoopVars{LoopConfig: LoopConfig{
+			SyncInterval:       time.Minute,
+			SkipUnchangedSyncs: true,
+			SyncTagEvery:       3,
+		}},
+	}
+	d.AskForSync()
+	scope := &git.Scope{Name: "team", Paths: []string{"team"}, SyncTag: "flux-sync-team"}
+
+	scoped := d.ForScope(scope)
+	if !reflect.DeepEqual(d.LoopConfig, scoped.LoopConfig) {
+		t.Errorf("expected the loop config to be copied, got %+v", scoped.LoopConfig)
+	}
+	if !reflect.DeepEqual(d.Config, scoped.Config) {
+		t.Errorf("expected the daemon config to be copied, got %+v", scoped.Config)
+	}
+	if scoped.Scope != scope || scoped.GitConfig.SyncTag != "flux-sync-team" || !reflect.DeepEqual(scoped.GitConfig.Paths, []string{"team"}) {
+		t.Errorf("expected the git config for the scope, got %+v", scoped.GitConfig)
+	}
+	// The loop state is its own
+	if scoped.LoopVars == d.LoopVars || scoped.syncForced != 0 {
+		t.Error("expected the scoped daemon to have loop state of its own")
+	}
+}
@@ -0,0 +1,130 @@
+package daemon
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux/git"
+)
+
+// The state about syncing that would otherwise be lost when the
+// daemon restarts is kept in git, in refs alongside the sync tag (see
+// git.Config.SyncStateRef); each names a revision. There's a ref for
+// each namespace, pointing at the revision it was last synced cleanly
+// at, named for the namespace (escaped, since cluster-scoped
+// resources are given as in `<cluster>`); and one for the revision
+// the sync tag is yet to be moved to, if any (see pendingSyncTag).
+const (
+	namespaceStatePrefix = "namespaces/"
+	pendingStateName     = "pending"
+)
+
+// loadSyncState restores the state kept in git about syncing, given
+// the revision the sync tag points at. It's done once, at the first
+// sync after the daemon starts; if the state can't be loaded, it's
+// tried again at the next sync.
+func (d *Daemon) loadSyncState(ctx context.Context, logger log.Logger, working *git.Checkout, tagRev string) {
+	if d.syncStateLoaded {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.GitOpTimeout)
+	defer cancel()
+	state, err := working.SyncState(ctx)
+	if err != nil {
+		logger.Log("warning", "could not load sync state from git", "err", err)
+		return
+	}
+	namespaces := map[string]string{}
+	for name, rev := range state {
+		if !strings.HasPrefix(name, namespaceStatePrefix) {
+			continue
+		}
+		if ns, err := url.PathUnescape(strings.TrimPrefix(name, namespaceStatePrefix)); err == nil {
+			namespaces[ns] = rev
+		}
+	}
+
+	// The pending revision only counts if it's ahead of the sync
+	// tag; if the tag was moved since (e.g., by another fluxd, or a
+	// rollback), it's out of date
+	latest := tagRev
+	if pending := state[pendingStateName]; pending != "" && pending != tagRev {
+		ahead := tagRev == ""
+		if !ahead {
+			if ahead, err = working.IsAncestor(ctx, tagRev, pending); err != nil {
+				logger.Log("warning", "could not compare pending sync revision with the sync tag; ignoring it", "pending", pending, "err", err)
+			}
+		}
+		if ahead {
+			d.pendingSyncTag.restore(pending)
+			latest = pending
+			logger.Log("info", "restored sync revision pending since before restart", "tag", d.GitConfig.SyncTagRef(), "pending", pending)
+		}
+	}
+	d.syncedRevs.restoreNamespaces(latest, namespaces)
+	d.syncStateLoaded = true
+}
+
+// saveSyncState pushes the state about syncing to git, where it's
+// changed from what's there, going by the working clone given.
+// Failing to is logged, but isn't a failure to sync; it's tried again
+// at the next sync.
+func (d *Daemon) saveSyncState(ctx context.Context, logger log.Logger, working *git.Checkout) {
+	if !d.syncStateLoaded {
+		// what's there hasn't been taken into account, so mustn't be
+		// overwritten
+		return
+	}
+	state := map[string]string{}
+	for ns, rev := range d.syncedRevs.namespaceRevisions() {
+		state[namespaceStatePrefix+url.PathEscape(ns)] = rev
+	}
+	if pending := d.pendingSyncTag.get(); pending != "" {
+		state[pendingStateName] = pending
+	}
+	if err := pushSyncState(ctx, d.GitOpTimeout, working, state); err != nil {
+		logger.Log("warning", "could not push sync state to git", "err", err)
+	}
+}
+
+// pushSyncState pushes the refs for the state given, and deletes
+// those for state not given, where they differ from what's in the
+// working clone given.
+func pushSyncState(ctx context.Context, timeout time.Duration, working *git.Checkout, state map[string]string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	current, err := working.SyncState(ctx)
+	if err != nil {
+		return err
+	}
+	changes := map[string]string{}
+	for name, rev := range state {
+		if current[name] != rev {
+			changes[name] = rev
+		}
+	}
+	for name := range current {
+		if _, ok := state[name]; !ok {
+			changes[name] = ""
+		}
+	}
+	return working.PushSyncState(ctx, changes)
+}
+
+// dropPendingSyncState deletes the ref for the pending revision, if
+// there is one, for when the sync tag has been moved regardless of
+// it.
+func dropPendingSyncState(ctx context.Context, working *git.Checkout) error {
+	current, err := working.SyncState(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := current[pendingStateName]; !ok {
+		return nil
+	}
+	return working.PushSyncState(ctx, map[string]string{pendingStateName: ""})
+}
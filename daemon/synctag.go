@@ -0,0 +1,110 @@
+package daemon
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/weaveworks/flux/git"
+)
+
+// pendingSyncTag keeps track of a revision that has been synced, but
+// that the sync tag hasn't been moved to yet, when moving it is
+// batched (see LoopVars.SyncTagEvery). Until the tag catches up, the
+// pending revision is taken to be the last synced revision.
+//
+// It's pushed to the tag when the loop stops; and in the meantime,
+// it's kept in git in a ref of its own (see saveSyncState), so if
+// the daemon dies without stopping, it's restored when the daemon
+// starts again, rather than everything since the tag being synced
+// (and notified) again. A ref is pushed for it at each sync, but
+// it's not the sync tag, so doesn't disturb anything watching that.
+type pendingSyncTag struct {
+	mu       sync.Mutex
+	revision string
+	// how many revisions have been synced since the tag was last
+	// moved
+	syncs int
+}
+
+// get returns the pending revision, or the empty string if the sync
+// tag is up to date.
+func (p *pendingSyncTag) get() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.revision
+}
+
+// deferTo records the revision given as synced, and says whether the
+// sync tag can be left where it is for now, given that it's to be
+// moved only every `every` revisions.
+func (p *pendingSyncTag) deferTo(revision string, every int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.syncs++
+	if p.syncs < every {
+		p.revision = revision
+		return true
+	}
+	return false
+}
+
+// restore sets the pending revision, as it was before a restart.
+func (p *pendingSyncTag) restore(revision string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.revision = revision
+	p.syncs = 1
+}
+
+// reset forgets any pending revision, once the sync tag has been
+// moved.
+func (p *pendingSyncTag) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.revision = ""
+	p.syncs = 0
+}
+
+// lastSyncedRevision gives the revision most recently synced: the
+// pending revision if there is one, otherwise where the sync tag
+// points (which may be the empty string, if it doesn't exist yet).
+func (d *Daemon) lastSyncedRevision(ctx context.Context, working *git.Checkout) (string, error) {
+	if rev := d.pendingSyncTag.get(); rev != "" {
+		return rev, nil
+	}
+	rev, err := working.SyncRevision(ctx)
+	if err != nil && !isUnknownRevision(err) {
+		return "", err
+	}
+	return rev, nil
+}
+
+// flushSyncTag moves the sync tag to the pending revision, if there
+// is one. It's for when the loop stops, so the tag is as up to date
+// as possible when the daemon starts again.
+func (d *Daemon) flushSyncTag(logger log.Logger) {
+	rev := d.pendingSyncTag.get()
+	if rev == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.GitOpTimeout)
+	defer cancel()
+	working, err := d.Repo.Clone(ctx, d.GitConfig)
+	if err != nil {
+		logger.Log("err", err, "pending", rev)
+		return
+	}
+	defer working.Clean()
+	if err := working.MoveSyncTagAndPush(ctx, git.TagAction{
+		Revision: rev,
+		Message:  "Sync pointer",
+	}); err != nil {
+		logger.Log("err", err, "pending", rev)
+		return
+	}
+	d.pendingSyncTag.reset()
+	logger.Log("tag", d.GitConfig.SyncTagRef(), "new", rev, "pending", "pushed")
+	d.saveSyncState(ctx, logger, working)
+}
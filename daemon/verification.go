@@ -0,0 +1,52 @@
+package daemon
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/verify"
+)
+
+// Outcomes of verifying a sync, for metrics.
+const (
+	verifyPassed = "passed"
+	verifyFailed = "failed"
+	verifyError  = "error"
+)
+
+// verifySync asks the verifier whether the sync of the revision given
+// worked, waiting up to the verify timeout. It returns an error if
+// verification doesn't pass, unless the failure action is to proceed,
+// in which case the failure is only logged.
+func (d *Daemon) verifySync(ctx context.Context, logger log.Logger, revision string, workloads []flux.ResourceID) error {
+	if d.VerifyTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.VerifyTimeout)
+		defer cancel()
+	}
+	started := time.Now()
+	logger.Log("info", "verifying sync", "revision", revision)
+	err := d.Verifier.Verify(ctx, verify.Request{Revision: revision, Workloads: workloads})
+	switch err.(type) {
+	case nil:
+		syncVerifications.With("outcome", verifyPassed).Add(1)
+		logger.Log("info", "sync verified", "revision", revision, "took", time.Since(started))
+		return nil
+	case *verify.FailedError:
+		syncVerifications.With("outcome", verifyFailed).Add(1)
+	default:
+		syncVerifications.With("outcome", verifyError).Add(1)
+		if ctx.Err() == context.DeadlineExceeded {
+			err = errors.Errorf("verification did not finish within %s", d.VerifyTimeout)
+		}
+	}
+	if d.VerifyFailureAction == verify.FailureProceed {
+		logger.Log("warning", "sync did not pass verification; moving the sync tag anyway", "revision", revision, "err", err)
+		return nil
+	}
+	return errors.Wrapf(err, "sync of %s did not pass verification, so not moving the sync tag", revision)
+}
@@ -24,6 +24,9 @@ const (
 	EventLock         = "lock"
 	EventUnlock       = "unlock"
 	EventUpdatePolicy = "update_policy"
+	EventDrift        = "drift"
+	// Syncing works again, after failing
+	EventSyncRecovered = "sync_recovered"
 
 	// This is used to label e.g., commits that we _don't_ consider an event in themselves.
 	NoneOfTheAbove = "other"
@@ -34,6 +37,21 @@ const (
 	LogLevelError = "error"
 )
 
+// EventTypes are all the types of events there are.
+var EventTypes = []string{
+	EventCommit,
+	EventSync,
+	EventRelease,
+	EventAutoRelease,
+	EventAutomate,
+	EventDeautomate,
+	EventLock,
+	EventUnlock,
+	EventUpdatePolicy,
+	EventDrift,
+	EventSyncRecovered,
+}
+
 type EventID int64
 
 type Event struct {
@@ -74,6 +92,19 @@ type EventWriter interface {
 	LogEvent(Event) error
 }
 
+// MultiWriter is an EventWriter that logs each event to all the
+// EventWriters it contains, in order, stopping at the first error.
+type MultiWriter []EventWriter
+
+func (ws MultiWriter) LogEvent(e Event) error {
+	for _, w := range ws {
+		if err := w.LogEvent(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (e Event) WorkloadIDStrings() []string {
 	var strWorkloadIDs []string
 	for _, workloadID := range e.ServiceIDs {
@@ -156,6 +187,15 @@ func (e Event) String() string {
 			svcStr = strings.Join(strWorkloadIDs, ", ")
 		}
 		return fmt.Sprintf("Sync: %s, %s", revStr, svcStr)
+	case EventDrift:
+		metadata := e.Metadata.(*DriftEventMetadata)
+		if len(metadata.Drifted) == 0 {
+			return fmt.Sprintf("Drift: no resources differ from %s", shortRevision(metadata.Revision))
+		}
+		return fmt.Sprintf("Drift: %d resource(s) differ from %s", len(metadata.Drifted), shortRevision(metadata.Revision))
+	case EventSyncRecovered:
+		metadata := e.Metadata.(*SyncRecoveredEventMetadata)
+		return fmt.Sprintf("Sync recovered: %s synced, after %d failed sync(s) over %s", shortRevision(metadata.Revision), metadata.FailedSyncs, metadata.FailingFor())
 	case EventAutomate:
 		return fmt.Sprintf("Automated: %s", strings.Join(strWorkloadIDs, ", "))
 	case EventDeautomate:
@@ -217,6 +257,49 @@ type SyncEventMetadata struct {
 	Errors []ResourceError `json:"errors,omitempty"`
 	// `true` if we have no record of having synced before
 	InitialSync bool `json:"initialSync,omitempty"`
+	// Counts of what happened to resources (created, configured,
+	// unchanged, deleted, skipped, failed), by kind
+	Summary map[string]map[string]int `json:"summary,omitempty"`
+}
+
+// DriftEventMetadata is the metadata for a periodic report of the
+// resources in the cluster that differ from the last synced revision.
+type DriftEventMetadata struct {
+	Revision string            `json:"revision,omitempty"`
+	Drifted  []DriftedResource `json:"drifted,omitempty"`
+}
+
+// DriftedResource describes a resource that differs from its
+// manifest.
+type DriftedResource struct {
+	ID   flux.ResourceID `json:"id"`
+	Path string          `json:"path"`
+	// The resource is missing from the cluster
+	Missing bool `json:"missing,omitempty"`
+	// A diff of the manifest with the resource in the cluster,
+	// possibly truncated
+	Diff string `json:"diff,omitempty"`
+}
+
+// SyncRecoveredEventMetadata is the metadata for when a sync succeeds
+// after one or more syncs failed, either outright or for some of the
+// resources. It's sent once for each run of failures.
+type SyncRecoveredEventMetadata struct {
+	// The revision that synced
+	Revision string `json:"revision,omitempty"`
+	// When the first of the failing syncs was
+	FailingSince time.Time `json:"failingSince"`
+	// When the sync that succeeded was
+	RecoveredAt time.Time `json:"recoveredAt"`
+	FailedSyncs int       `json:"failedSyncs"`
+	// The error from the last sync that failed
+	LastError string `json:"lastError,omitempty"`
+}
+
+// FailingFor gives how long syncs were failing, rounded to the
+// second.
+func (m *SyncRecoveredEventMetadata) FailingFor() time.Duration {
+	return m.RecoveredAt.Sub(m.FailingSince).Round(time.Second)
 }
 
 // Account for old events, which used the revisions field rather than commits
@@ -365,6 +448,20 @@ func (e *Event) UnmarshalJSON(in []byte) error {
 		}
 		e.Metadata = &metadata
 		break
+	case EventDrift:
+		var metadata DriftEventMetadata
+		if err := json.Unmarshal(wireEvent.MetadataBytes, &metadata); err != nil {
+			return err
+		}
+		e.Metadata = &metadata
+		break
+	case EventSyncRecovered:
+		var metadata SyncRecoveredEventMetadata
+		if err := json.Unmarshal(wireEvent.MetadataBytes, &metadata); err != nil {
+			return err
+		}
+		e.Metadata = &metadata
+		break
 	default:
 		if len(wireEvent.MetadataBytes) > 0 {
 			var metadata UnknownEventMetadata
@@ -404,6 +501,14 @@ func (rem *AutoReleaseEventMetadata) Type() string {
 	return EventAutoRelease
 }
 
+func (dem *DriftEventMetadata) Type() string {
+	return EventDrift
+}
+
+func (srm *SyncRecoveredEventMetadata) Type() string {
+	return EventSyncRecovered
+}
+
 // Special exception from pointer receiver rule, as UnknownEventMetadata is a
 // type alias for a map
 func (uem UnknownEventMetadata) Type() string {
@@ -0,0 +1,380 @@
+/*
+Package notify sends notifications of events (syncs, releases and so
+on) to a webhook, e.g., a Slack incoming webhook, with messages
+rendered from Go templates.
+
+Each event type can have its own template, which is given a `Data`
+value to render. Event types without a template are not notified.
+
+Notifications are posted in the background, so a slow webhook
+doesn't hold up whatever wrote the event; if they pile up faster than
+they can be posted, the extras are dropped.
+*/
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/event"
+	"github.com/weaveworks/flux/update"
+)
+
+// Format says how a rendered template is sent.
+type Format string
+
+const (
+	// The template renders plain text, sent as `{"text": ...}`
+	FormatText Format = "text"
+	// The template renders a JSON array of Slack layout blocks, sent
+	// as `{"blocks": ...}`
+	FormatSlackBlocks Format = "slack-blocks"
+)
+
+// DefaultTemplates are used for event types that aren't given a
+// template, per format.
+var DefaultTemplates = map[Format]map[string]string{
+	FormatText: {
+		event.EventSync: `{{if .Errors}}Sync of {{short .Revision}} failed for {{len .Errors}} resource(s){{range .Errors}}
+- {{.ID}} ({{.Path}}): {{.Error}}{{end}}{{else}}Synced {{short .Revision}}{{end}}{{if .CommitURL}}
+{{.CommitURL}}{{end}}`,
+		event.EventRelease: `{{.Message}}{{if .Error}}
+Error: {{.Error}}{{end}}{{if .CommitURL}}
+{{.CommitURL}}{{end}}`,
+		event.EventAutoRelease: `{{.Message}}{{if .Error}}
+Error: {{.Error}}{{end}}{{if .CommitURL}}
+{{.CommitURL}}{{end}}`,
+		event.EventDrift: `{{.Message}}{{range .Drift}}
+- {{.ID}} ({{.Path}}){{if .Missing}}: missing from the cluster{{else}}
+{{.Diff}}{{end}}{{end}}`,
+		event.EventSyncRecovered: `Syncing works again: synced {{short .Revision}}, after failing for {{.FailingFor}}{{if .Error}}
+Last error: {{.Error}}{{end}}{{if .CommitURL}}
+{{.CommitURL}}{{end}}`,
+	},
+	FormatSlackBlocks: {
+		event.EventSync: `[{"type": "section", "text": {"type": "mrkdwn", "text": {{if .Errors}}{{json (printf "*Sync of %s failed* for %d resource(s)" (short .Revision) (len .Errors))}}{{else}}{{json (printf "Synced %s" (short .Revision))}}{{end}}}}
+{{- range .Errors}},
+{"type": "section", "text": {"type": "mrkdwn", "text": {{json (printf "%s (%s): %s" .ID .Path .Error)}}}}{{end}}
+{{- if .CommitURL}},
+{"type": "context", "elements": [{"type": "mrkdwn", "text": {{json (printf "<%s|%s>" .CommitURL (short .Revision))}}}]}{{end}}]`,
+		event.EventRelease: `[{"type": "section", "text": {"type": "mrkdwn", "text": {{json .Message}}}}
+{{- if .Error}},
+{"type": "section", "text": {"type": "mrkdwn", "text": {{json (printf "*Error:* %s" .Error)}}}}{{end}}
+{{- if .CommitURL}},
+{"type": "context", "elements": [{"type": "mrkdwn", "text": {{json (printf "<%s|%s>" .CommitURL (short .Revision))}}}]}{{end}}]`,
+		event.EventAutoRelease: `[{"type": "section", "text": {"type": "mrkdwn", "text": {{json .Message}}}}
+{{- if .Error}},
+{"type": "section", "text": {"type": "mrkdwn", "text": {{json (printf "*Error:* %s" .Error)}}}}{{end}}
+{{- if .CommitURL}},
+{"type": "context", "elements": [{"type": "mrkdwn", "text": {{json (printf "<%s|%s>" .CommitURL (short .Revision))}}}]}{{end}}]`,
+		event.EventDrift: `[{"type": "section", "text": {"type": "mrkdwn", "text": {{json .Message}}}}
+{{- range .Drift}},
+{"type": "section", "text": {"type": "mrkdwn", "text": {{if .Missing}}{{json (printf "*%s* (%s): missing from the cluster" .ID .Path)}}{{else}}{{json (printf "*%s* (%s)\n` + "```" + `%s` + "```" + `" .ID .Path .Diff)}}{{end}}}}{{end}}]`,
+		event.EventSyncRecovered: `[{"type": "section", "text": {"type": "mrkdwn", "text": {{json (printf "*Syncing works again:* synced %s, after failing for %s" (short .Revision) .FailingFor)}}}}
+{{- if .Error}},
+{"type": "section", "text": {"type": "mrkdwn", "text": {{json (printf "*Last error:* %s" .Error)}}}}{{end}}
+{{- if .CommitURL}},
+{"type": "context", "elements": [{"type": "mrkdwn", "text": {{json (printf "<%s|%s>" .CommitURL (short .Revision))}}}]}{{end}}]`,
+	},
+}
+
+// Config is used to construct a Notifier.
+type Config struct {
+	URL    string // where to post notifications
+	Format Format
+	// Templates per event type, overriding the defaults
+	Templates map[string]string
+	// Prefix for links to commits, e.g.,
+	// https://github.com/org/repo/commit/; the revision is appended
+	CommitURL string
+	// Made available to templates, for linking back to dashboards
+	DashboardURL string
+	Timeout      time.Duration
+	// How many notifications may be waiting to be posted; more
+	// than that are dropped. If zero, DefaultQueueSize is used.
+	QueueSize int
+	Logger    log.Logger
+}
+
+const DefaultQueueSize = 100
+
+// Data is what templates are given to render.
+type Data struct {
+	Event event.Event
+	// The default message for the event
+	Message string
+	// The workloads affected, as strings
+	Workloads []string
+	// The revision synced or committed, if there is one
+	Revision string
+	// The link to Revision, if a CommitURL was configured
+	CommitURL    string
+	DashboardURL string
+	// The error for a release or automated release, if it failed;
+	// or for a sync recovery, the error from the last sync that
+	// failed
+	Error string
+	// Per-resource errors for a sync
+	Errors []event.ResourceError
+	// The resources that differ from their manifests, for a drift
+	// report
+	Drift []event.DriftedResource
+	// For a sync recovery, how long syncs were failing (e.g., "1h5m0s")
+	FailingFor string
+}
+
+// Notifier is an event.EventWriter that posts notifications.
+type Notifier struct {
+	config    Config
+	templates map[string]*template.Template
+	client    *http.Client
+	queue     chan notification
+}
+
+type notification struct {
+	eventType string
+	payload   []byte
+}
+
+var funcs = template.FuncMap{
+	"short": func(rev string) string {
+		if len(rev) > 7 {
+			return rev[:7]
+		}
+		return rev
+	},
+	"json": func(v interface{}) (string, error) {
+		bytes, err := json.Marshal(v)
+		return string(bytes), err
+	},
+	"join": strings.Join,
+}
+
+// New constructs a Notifier. All the templates are parsed, and
+// rendered with an example event, so that any mistakes in them come
+// to light straight away rather than when there's something to
+// notify.
+func New(config Config) (*Notifier, error) {
+	switch config.Format {
+	case FormatText, FormatSlackBlocks:
+	default:
+		return nil, fmt.Errorf("unknown notification format %q", config.Format)
+	}
+	if config.Logger == nil {
+		config.Logger = log.NewNopLogger()
+	}
+	if config.QueueSize == 0 {
+		config.QueueSize = DefaultQueueSize
+	}
+	for eventType := range config.Templates {
+		if !isEventType(eventType) {
+			return nil, fmt.Errorf("notification template given for unknown event type %q; known types are %s", eventType, strings.Join(event.EventTypes, ", "))
+		}
+	}
+
+	sources := map[string]string{}
+	for eventType, src := range DefaultTemplates[config.Format] {
+		sources[eventType] = src
+	}
+	for eventType, src := range config.Templates {
+		sources[eventType] = src
+	}
+
+	n := &Notifier{
+		config:    config,
+		templates: map[string]*template.Template{},
+		client:    &http.Client{Timeout: config.Timeout},
+		queue:     make(chan notification, config.QueueSize),
+	}
+	for eventType, src := range sources {
+		tmpl, err := template.New(eventType).Funcs(funcs).Parse(src)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing notification template for %s events", eventType)
+		}
+		n.templates[eventType] = tmpl
+		if _, err := n.render(exampleEvent(eventType)); err != nil {
+			return nil, errors.Wrapf(err, "checking notification template for %s events", eventType)
+		}
+	}
+	return n, nil
+}
+
+func isEventType(eventType string) bool {
+	for _, t := range event.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// LogEvent queues a notification for the event to be posted, if
+// there's a template for its type. Failing to notify is logged, but
+// not returned as an error, since it shouldn't hold up syncing.
+func (n *Notifier) LogEvent(ev event.Event) error {
+	if _, ok := n.templates[ev.Type]; !ok {
+		return nil
+	}
+	payload, err := n.render(ev)
+	if err != nil {
+		n.config.Logger.Log("err", errors.Wrap(err, "rendering notification"), "event", ev.Type)
+		return nil
+	}
+	select {
+	case n.queue <- notification{eventType: ev.Type, payload: payload}:
+	default:
+		n.config.Logger.Log("err", "too many notifications waiting to be posted; dropping this one", "event", ev.Type)
+	}
+	return nil
+}
+
+// Start posts the notifications queued by LogEvent, one at a time,
+// until told to stop.
+func (n *Notifier) Start(stop <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		case note := <-n.queue:
+			if err := n.post(note.payload); err != nil {
+				n.config.Logger.Log("err", errors.Wrap(err, "posting notification"), "event", note.eventType)
+			}
+		}
+	}
+}
+
+// render gives the body to post for an event.
+func (n *Notifier) render(ev event.Event) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := n.templates[ev.Type].Execute(buf, n.makeData(ev)); err != nil {
+		return nil, err
+	}
+
+	switch n.config.Format {
+	case FormatSlackBlocks:
+		var blocks []interface{}
+		if err := json.Unmarshal(buf.Bytes(), &blocks); err != nil {
+			return nil, errors.Wrap(err, "template did not produce a JSON array of blocks")
+		}
+		return json.Marshal(map[string]interface{}{"blocks": blocks})
+	default:
+		return json.Marshal(map[string]string{"text": buf.String()})
+	}
+}
+
+func (n *Notifier) makeData(ev event.Event) Data {
+	data := Data{
+		Event:        ev,
+		Message:      ev.String(),
+		Workloads:    ev.WorkloadIDStrings(),
+		DashboardURL: n.config.DashboardURL,
+	}
+	switch metadata := ev.Metadata.(type) {
+	case *event.SyncEventMetadata:
+		if len(metadata.Commits) > 0 {
+			// commits are given most recent first
+			data.Revision = metadata.Commits[0].Revision
+		}
+		data.Errors = metadata.Errors
+	case *event.ReleaseEventMetadata:
+		data.Revision = metadata.Revision
+		data.Error = metadata.Error
+	case *event.AutoReleaseEventMetadata:
+		data.Revision = metadata.Revision
+		data.Error = metadata.Error
+	case *event.CommitEventMetadata:
+		data.Revision = metadata.Revision
+	case *event.DriftEventMetadata:
+		data.Revision = metadata.Revision
+		data.Drift = metadata.Drifted
+	case *event.SyncRecoveredEventMetadata:
+		data.Revision = metadata.Revision
+		data.Error = metadata.LastError
+		data.FailingFor = metadata.FailingFor().String()
+	}
+	if data.Revision != "" && n.config.CommitURL != "" {
+		data.CommitURL = n.config.CommitURL + data.Revision
+	}
+	return data
+}
+
+func (n *Notifier) post(payload []byte) error {
+	resp, err := n.client.Post(n.config.URL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
+
+// exampleEvent makes an event of the type given, with everything
+// filled in, for checking templates.
+func exampleEvent(eventType string) event.Event {
+	id := flux.MustParseResourceID("default:deployment/example")
+	rev := "0123456789abcdef0123456789abcdef01234567"
+	now := time.Now().UTC()
+	ev := event.Event{
+		ServiceIDs: []flux.ResourceID{id},
+		Type:       eventType,
+		StartedAt:  now,
+		EndedAt:    now,
+		LogLevel:   event.LogLevelInfo,
+	}
+	common := event.ReleaseEventCommon{
+		Revision: rev,
+		Result:   update.Result{id: update.WorkloadResult{Status: update.ReleaseStatusSuccess}},
+		Error:    "example error",
+	}
+	switch eventType {
+	case event.EventSync:
+		ev.Metadata = &event.SyncEventMetadata{
+			Commits: []event.Commit{{Revision: rev, Message: "Example commit"}},
+			Errors:  []event.ResourceError{{ID: id, Path: "example.yaml", Error: "example error"}},
+		}
+	case event.EventRelease:
+		ev.Metadata = &event.ReleaseEventMetadata{
+			ReleaseEventCommon: common,
+			Spec: event.ReleaseSpec{
+				Type:             event.ReleaseImageSpecType,
+				ReleaseImageSpec: &update.ReleaseImageSpec{ServiceSpecs: []update.ResourceSpec{update.MakeResourceSpec(id)}},
+			},
+		}
+	case event.EventAutoRelease:
+		ev.Metadata = &event.AutoReleaseEventMetadata{ReleaseEventCommon: common}
+	case event.EventCommit:
+		ev.Metadata = &event.CommitEventMetadata{Revision: rev, Spec: &update.Spec{Type: update.Policy}}
+	case event.EventDrift:
+		ev.Metadata = &event.DriftEventMetadata{
+			Revision: rev,
+			Drifted: []event.DriftedResource{
+				{ID: id, Path: "example.yaml", Diff: "--- git\n+++ cluster\n@@ -1 +1 @@\n-replicas: 2\n+replicas: 3\n"},
+				{ID: flux.MustParseResourceID("default:service/example"), Path: "example.yaml", Missing: true},
+			},
+		}
+	case event.EventSyncRecovered:
+		ev.Metadata = &event.SyncRecoveredEventMetadata{
+			Revision:     rev,
+			FailingSince: now.Add(-time.Hour),
+			RecoveredAt:  now,
+			FailedSyncs:  12,
+			LastError:    "example error",
+		}
+	default:
+		ev.Message = "Example event"
+	}
+	return ev
+}
@@ -0,0 +1,177 @@
+package notify
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux"
+	"github.com/weaveworks/flux/event"
+)
+
+func TestNew_InvalidTemplate(t *testing.T) {
+	_, err := New(Config{
+		Format:    FormatText,
+		Templates: map[string]string{event.EventSync: "{{.Revision"},
+	})
+	if err == nil {
+		t.Error("expected error for template that doesn't parse")
+	}
+
+	_, err = New(Config{
+		Format:    FormatText,
+		Templates: map[string]string{event.EventSync: "{{.NoSuchField}}"},
+	})
+	if err == nil {
+		t.Error("expected error for template that doesn't render")
+	}
+
+	_, err = New(Config{
+		Format:    FormatSlackBlocks,
+		Templates: map[string]string{event.EventSync: "not JSON"},
+	})
+	if err == nil {
+		t.Error("expected error for blocks template that doesn't render JSON")
+	}
+
+	_, err = New(Config{
+		Format:    FormatText,
+		Templates: map[string]string{"snyc": "{{.Revision}}"},
+	})
+	if err == nil {
+		t.Error("expected error for template for an unknown event type")
+	}
+
+	_, err = New(Config{Format: "carrier-pigeon"})
+	if err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestNew_Defaults(t *testing.T) {
+	for _, format := range []Format{FormatText, FormatSlackBlocks} {
+		if _, err := New(Config{Format: format, CommitURL: "https://example.com/commit/"}); err != nil {
+			t.Errorf("default templates for %s: %s", format, err)
+		}
+	}
+}
+
+func TestLogEvent(t *testing.T) {
+	payloads := make(chan map[string]interface{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var payload map[string]interface{}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Error(err)
+		}
+		payloads <- payload
+	}))
+	defer server.Close()
+
+	n, err := New(Config{
+		URL:       server.URL,
+		Format:    FormatText,
+		CommitURL: "https://example.com/commit/",
+		Templates: map[string]string{
+			event.EventSync: `{{.Revision}} {{join .Workloads ","}} {{.CommitURL}}{{range .Errors}} {{.Error}}{{end}}`,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go n.Start(stop, wg)
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	id := flux.MustParseResourceID("default:deployment/helloworld")
+	rev := "abcdef0123456789"
+	if err := n.LogEvent(event.Event{
+		Type:       event.EventSync,
+		ServiceIDs: []flux.ResourceID{id},
+		Metadata: &event.SyncEventMetadata{
+			Commits: []event.Commit{{Revision: rev}},
+			Errors:  []event.ResourceError{{ID: id, Path: "helloworld.yaml", Error: "apply failed"}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// There's no template for this type
+	if err := n.LogEvent(event.Event{Type: event.EventLock, ServiceIDs: []flux.ResourceID{id}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var payload map[string]interface{}
+	select {
+	case payload = <-payloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for notification")
+	}
+	expected := rev + " default:deployment/helloworld https://example.com/commit/" + rev + " apply failed"
+	if text, _ := payload["text"].(string); text != expected {
+		t.Errorf("expected %q, got %q", expected, text)
+	}
+	// Only the one notification is posted
+	select {
+	case payload = <-payloads:
+		t.Errorf("expected one notification, got another: %v", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// A notification is queued rather than posted straight away, so a
+// slow webhook doesn't hold up writing the event; and if the queue is
+// full, more are dropped rather than waited for.
+func TestLogEvent_Queued(t *testing.T) {
+	n, err := New(Config{URL: "http://example.invalid/", Format: FormatText, QueueSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := event.Event{
+		Type:     event.EventSync,
+		Metadata: &event.SyncEventMetadata{Commits: []event.Commit{{Revision: "abcdef0"}}},
+	}
+	// Nothing is posting what's queued, so these would block were
+	// they not dropped
+	for i := 0; i < 3; i++ {
+		if err := n.LogEvent(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(n.queue) != 1 {
+		t.Errorf("expected one notification queued, got %d", len(n.queue))
+	}
+}
+
+func TestRender_SlackBlocks(t *testing.T) {
+	n, err := New(Config{Format: FormatSlackBlocks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := n.render(exampleEvent(event.EventSync))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Blocks []map[string]interface{} `json:"blocks"`
+	}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		t.Fatal(err)
+	}
+	// One for the summary, and one for the error
+	if len(body.Blocks) != 2 {
+		t.Errorf("expected two blocks, got %d: %s", len(body.Blocks), payload)
+	}
+	if !strings.Contains(string(payload), "example error") {
+		t.Errorf("expected error to be included in blocks: %s", payload)
+	}
+}
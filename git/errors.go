@@ -2,9 +2,12 @@ package git
 
 import (
 	"errors"
+	"fmt"
 	"strings"
+	"time"
 
 	fluxerr "github.com/weaveworks/flux/errors"
+	"github.com/weaveworks/flux/ssh"
 )
 
 var NoRepoError = &fluxerr.Error{
@@ -119,3 +122,46 @@ check the box to allow write access.
 `,
 	}
 }
+
+// certificateError explains an error talking to the upstream, if an
+// SSH certificate is being presented and might be the reason; git
+// itself only says that it "Could not read from remote repository".
+// Otherwise, the error is returned as it is.
+func certificateError(certificate string, actual error) error {
+	if certificate == "" || actual == nil || !strings.Contains(actual.Error(), "Could not read from remote repository") {
+		return actual
+	}
+	var reason string
+	now := time.Now()
+	cert, err := ssh.ReadCertificate(certificate)
+	switch {
+	case err != nil:
+		reason = fmt.Sprintf("the SSH certificate could not be read (%s)", err)
+	case cert.Expired(now):
+		reason = fmt.Sprintf("the SSH certificate %s (key ID %q) expired at %s", certificate, cert.KeyID, cert.ValidBefore.Format(time.RFC3339))
+	case cert.NotYetValid(now):
+		reason = fmt.Sprintf("the SSH certificate %s (key ID %q) is not valid until %s", certificate, cert.KeyID, cert.ValidAfter.Format(time.RFC3339))
+	default:
+		reason = fmt.Sprintf("the git server may have rejected the SSH certificate %s (key ID %q)", certificate, cert.KeyID)
+	}
+	return &fluxerr.Error{
+		Type: fluxerr.User,
+		Err:  fmt.Errorf("%s; %s", actual, reason),
+		Help: `Could not use the SSH certificate to reach the git repository
+
+The daemon presents an SSH certificate alongside its key, but the git
+server did not accept them. Please check that the certificate
+
+ - is for the daemon's key, which you can see with
+
+    fluxctl identity
+
+ - has not expired (the daemon logs a warning before it does); a
+   renewed certificate is used as soon as it's in place, without
+   restarting the daemon;
+
+ - is signed by a certificate authority the git server trusts, and
+   names a principal the server accepts.
+`,
+	}
+}
@@ -28,5 +28,9 @@ func (r *Repo) Export(ctx context.Context, ref string) (*Export, error) {
 	if err = checkout(ctx, dir, ref); err != nil {
 		return nil, err
 	}
+	if err = r.lfsCheckout(ctx, dir); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
 	return &Export{dir}, nil
 }
@@ -0,0 +1,221 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+// LFS makes the repo fetch the objects of files stored with git LFS,
+// so that clones and exports have the contents of those files rather
+// than their pointers. The objects are fetched with the mirror's
+// remote, and so with the same credentials; if URL is not empty, it's
+// used as the LFS endpoint in place of the one git LFS works out from
+// the remote's URL (e.g., for an LFS server that's elsewhere).
+//
+// The objects for the heads of Branches are fetched ahead of time,
+// each time the repo is refreshed, to save fetching them at each
+// checkout; a failure to do so is logged to Logger, if given, and the
+// objects fetched again when a revision is checked out.
+type LFS struct {
+	URL      string
+	Branches []string
+	Logger   log.Logger
+}
+
+func (l LFS) apply(r *Repo) {
+	r.lfs = true
+	r.lfsURL = l.URL
+	r.lfsBranches = l.Branches
+	r.lfsLogger = l.Logger
+	if r.lfsLogger == nil {
+		r.lfsLogger = log.NewNopLogger()
+	}
+}
+
+// CheckLFS returns an error if git LFS is not installed.
+func CheckLFS(ctx context.Context) error {
+	if err := execGitCmd(ctx, []string{"lfs", "version"}, gitCmdConfig{}); err != nil {
+		return errors.Wrap(err, "running git lfs")
+	}
+	return nil
+}
+
+// lfsConfig sets the LFS endpoint for the mirror, if one is given.
+func lfsConfig(ctx context.Context, mirrorDir, url string) error {
+	if url == "" {
+		return nil
+	}
+	args := []string{"config", "lfs.url", url}
+	if err := execGitCmd(ctx, args, gitCmdConfig{dir: mirrorDir}); err != nil {
+		return errors.Wrap(err, "setting git lfs config")
+	}
+	return nil
+}
+
+// lfsFetch fetches the LFS objects needed for the refs or revisions
+// given into the mirror, from its remote. Objects already in the
+// mirror aren't fetched again.
+func lfsFetch(ctx context.Context, mirrorDir string, refs ...string) error {
+	args := append([]string{"lfs", "fetch", "origin"}, refs...)
+	errOut := &bytes.Buffer{}
+	if err := execGitCmd(ctx, args, gitCmdConfig{dir: mirrorDir, errOut: errOut}); err != nil {
+		// git lfs doesn't say why it failed in a way git would; the
+		// reasons are in what it printed
+		if msg := lfsErrorMessage(errOut.String()); msg != "" && ctx.Err() == nil {
+			err = errors.New(msg)
+		}
+		return errors.Wrap(err, "git lfs fetch")
+	}
+	return nil
+}
+
+// lfsInstall sets up a working clone of the mirror given to use the
+// LFS objects kept in the mirror. The filters are installed, so that
+// files stored with LFS are read as pointers, as they should be; but
+// not the hooks, since nothing pushed from a working clone adds LFS
+// objects (see lfsCheckCommit).
+func lfsInstall(ctx context.Context, workingDir, mirrorDir string) error {
+	args := []string{"lfs", "install", "--local", "--skip-repo"}
+	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir}); err != nil {
+		return errors.Wrap(err, "git lfs install")
+	}
+	// The mirror is bare, so its LFS objects are in lfs/ directly
+	args = []string{"config", "lfs.storage", filepath.Join(mirrorDir, "lfs")}
+	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir}); err != nil {
+		return errors.Wrap(err, "setting git lfs config")
+	}
+	return nil
+}
+
+// lfsTracked gives those of the files given (relative to the working
+// clone given) that are stored with LFS, going by the attributes in
+// the working clone.
+func lfsTracked(ctx context.Context, workingDir string, files []string) ([]string, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	out := &bytes.Buffer{}
+	args := append([]string{"check-attr", "filter", "--"}, files...)
+	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir, out: out}); err != nil {
+		return nil, errors.Wrap(err, "git check-attr")
+	}
+	var tracked []string
+	// Each line is `<path>: filter: <value>`
+	for _, line := range splitList(out.String()) {
+		if path := strings.TrimSuffix(line, ": filter: lfs"); path != line {
+			tracked = append(tracked, path)
+		}
+	}
+	return tracked, nil
+}
+
+// lfsCheckCommit returns an error if any of the changes in the working
+// clone given is to a file stored with LFS. Nothing pushed from a
+// working clone takes its LFS objects with it, so committing such a
+// change would push a pointer to an object the LFS server doesn't
+// have.
+func lfsCheckCommit(ctx context.Context, workingDir string) error {
+	files, err := changed(ctx, workingDir, "HEAD", nil)
+	if err != nil {
+		return err
+	}
+	tracked, err := lfsTracked(ctx, workingDir, files)
+	if err != nil {
+		return err
+	}
+	if len(tracked) > 0 {
+		return fmt.Errorf("refusing to commit changes to files stored with git LFS, since their contents would not be pushed: %s", strings.Join(tracked, ", "))
+	}
+	return nil
+}
+
+// lfsPointers gives the files stored with LFS in the working clone
+// given that are still pointers, that is, that don't have their
+// contents checked out.
+func lfsPointers(ctx context.Context, workingDir string) ([]string, error) {
+	out := &bytes.Buffer{}
+	if err := execGitCmd(ctx, []string{"lfs", "ls-files"}, gitCmdConfig{dir: workingDir, out: out}); err != nil {
+		return nil, errors.Wrap(err, "git lfs ls-files")
+	}
+	var pointers []string
+	// Each line is `<oid> <*|-> <path>`, with `-` for a pointer
+	for _, line := range splitList(out.String()) {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) == 3 && fields[1] == "-" {
+			pointers = append(pointers, fields[2])
+		}
+	}
+	return pointers, nil
+}
+
+// lfsPrefetch fetches the LFS objects needed for the heads of the
+// branches given with LFS into the mirror. A failure (e.g., because the
+// LFS server is unavailable) is not a failure to refresh, so is only
+// logged.
+func (r *Repo) lfsPrefetch(ctx context.Context) {
+	if !r.lfs || len(r.lfsBranches) == 0 {
+		return
+	}
+	var refs []string
+	for _, branch := range r.lfsBranches {
+		refs = append(refs, "refs/heads/"+branch)
+	}
+	r.mu.RLock()
+	err := lfsFetch(ctx, r.dir, refs...)
+	r.mu.RUnlock()
+	if err != nil {
+		r.lfsLogger.Log("warning", "could not fetch LFS objects ahead of checkout", "branches", strings.Join(r.lfsBranches, ","), "err", err)
+	}
+}
+
+// lfsCheckout fills in the contents of the files stored with LFS in
+// the working clone given, at the revision checked out, fetching the
+// objects needed into the mirror first. If any can't be fetched, it
+// returns an error giving the files left as pointers, rather than
+// leave a pointer to be synced in place of a file.
+func (r *Repo) lfsCheckout(ctx context.Context, workingDir string) error {
+	if !r.lfs {
+		return nil
+	}
+	rev, err := refRevision(ctx, workingDir, "HEAD")
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	fetchErr := lfsFetch(ctx, r.dir, rev)
+	r.mu.RUnlock()
+	// Whether or not everything was fetched, fill in what there is,
+	// so that what's missing can be named
+	if err := execGitCmd(ctx, []string{"lfs", "checkout"}, gitCmdConfig{dir: workingDir}); err != nil {
+		return errors.Wrap(err, "git lfs checkout")
+	}
+	pointers, err := lfsPointers(ctx, workingDir)
+	if err != nil {
+		return err
+	}
+	if len(pointers) > 0 {
+		msg := fmt.Sprintf("LFS objects missing for revision %s, so these files are only pointers: %s", rev, strings.Join(pointers, ", "))
+		if fetchErr != nil {
+			msg = fmt.Sprintf("%s (fetching LFS objects failed: %s)", msg, fetchErr)
+		}
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// lfsErrorMessage picks out what git lfs said went wrong.
+func lfsErrorMessage(output string) string {
+	var lines []string
+	for _, line := range splitList(output) {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "; ")
+}
@@ -0,0 +1,124 @@
+package git
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux/cluster/kubernetes/testfiles"
+)
+
+const lfsManifest = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: large
+`
+
+// lfsUpstream makes a repo, with its branch master, in which the
+// files in large/ are stored with git LFS, and commits one there.
+func lfsUpstream(t *testing.T, dir string) {
+	for _, args := range [][]string{
+		{"init"},
+		{"symbolic-ref", "HEAD", "refs/heads/master"},
+		{"lfs", "install", "--local"},
+		{"lfs", "track", "large/*.yaml"},
+	} {
+		if err := execCommand("git", append([]string{"-C", dir}, args...)...); err != nil {
+			t.Fatalf("git %s: %v", strings.Join(args, " "), err)
+		}
+	}
+	if err := config(context.Background(), dir, "lfs_test_user", "example@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "large"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "large", "configmap.yaml"), []byte(lfsManifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lfsCommit(t, dir, "Add file stored with LFS")
+}
+
+func lfsCommit(t *testing.T, dir, message string) {
+	if err := execCommand("git", "-C", dir, "add", "--all"); err != nil {
+		t.Fatal(err)
+	}
+	if err := execCommand("git", "-C", dir, "commit", "-m", message); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLFS(t *testing.T) {
+	if err := CheckLFS(context.Background()); err != nil {
+		t.Skipf("git lfs is not available: %v", err)
+	}
+	upstream, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+	lfsUpstream(t, upstream)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	repo := NewRepo(Remote{URL: upstream}, LFS{Branches: []string{"master"}})
+	if err := repo.Ready(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("export fills in the contents of files stored with LFS", func(t *testing.T) {
+		export, err := repo.Export(ctx, "master")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer export.Clean()
+		content, err := ioutil.ReadFile(filepath.Join(export.Dir(), "large", "configmap.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != lfsManifest {
+			t.Errorf("expected file contents to be checked out, got %q", content)
+		}
+	})
+
+	t.Run("commits to files stored with LFS are refused", func(t *testing.T) {
+		working, err := repo.Clone(ctx, Config{Branch: "master", NotesRef: "flux", UserName: "flux", UserEmail: "flux@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer working.Clean()
+		path := filepath.Join(working.Dir(), "large", "configmap.yaml")
+		if err := ioutil.WriteFile(path, []byte(lfsManifest+"data: {}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err = working.CommitAndPush(ctx, CommitAction{Message: "Change file stored with LFS"}, nil)
+		if err == nil || !strings.Contains(err.Error(), "large/configmap.yaml") {
+			t.Errorf("expected commit to be refused, naming the file, got %v", err)
+		}
+	})
+
+	t.Run("missing objects are an error naming the files", func(t *testing.T) {
+		if err := ioutil.WriteFile(filepath.Join(upstream, "large", "missing.yaml"), []byte(lfsManifest+"data: {}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		lfsCommit(t, upstream, "Add file whose LFS object is lost")
+		// Lose the new object, as though it were never pushed
+		if err := os.RemoveAll(filepath.Join(upstream, ".git", "lfs", "objects")); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Refresh(ctx); err != nil {
+			t.Fatal(err)
+		}
+		_, err := repo.Export(ctx, "master")
+		if err == nil {
+			t.Fatal("expected export to fail with an LFS object missing")
+		}
+		if !strings.Contains(err.Error(), "large/missing.yaml") {
+			t.Errorf("expected error to name the file that's a pointer, got %v", err)
+		}
+		if strings.Contains(err.Error(), "large/configmap.yaml") {
+			t.Errorf("expected error not to name the file already fetched, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package git
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// MergeConflictError is returned from ExportMerge when the branch
+// can't be merged cleanly.
+type MergeConflictError struct {
+	Branch string
+	// The files with conflicts
+	Files []string
+}
+
+func (err *MergeConflictError) Error() string {
+	return fmt.Sprintf("merging branch %s has conflicts in %d files", err.Branch, len(err.Files))
+}
+
+// ExportMerge creates a minimal clone of the repo, at the ref given,
+// with the branch given merged into it (but not committed), so the
+// result of the merge can be looked at without it being made. If the
+// merge has conflicts, it returns a *MergeConflictError naming the
+// files.
+func (r *Repo) ExportMerge(ctx context.Context, ref, branch string) (*Export, error) {
+	export, err := r.Export(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	// The export is cloned from the mirror, so the branch is there
+	// as a remote-tracking branch
+	remoteBranch := "refs/remotes/origin/" + branch
+	if ok, err := refExists(ctx, export.Dir(), remoteBranch); err != nil || !ok {
+		export.Clean()
+		if err == nil {
+			err = fmt.Errorf("no branch %s in the repo", branch)
+		}
+		return nil, err
+	}
+	if err := merge(ctx, export.Dir(), remoteBranch); err != nil {
+		conflicts, conflictsErr := unmerged(ctx, export.Dir())
+		export.Clean()
+		if conflictsErr == nil && len(conflicts) > 0 {
+			return nil, &MergeConflictError{Branch: branch, Files: conflicts}
+		}
+		return nil, errors.Wrapf(err, "merging branch %s", branch)
+	}
+	return export, nil
+}
@@ -0,0 +1,77 @@
+package git
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux/cluster/kubernetes/testfiles"
+)
+
+func TestExportMerge(t *testing.T) {
+	newDir, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := createRepo(newDir, []string{"config"}); err != nil {
+		t.Fatal(err)
+	}
+	commitFile := func(branch, file, content string, from ...string) {
+		args := append([]string{"-C", newDir, "checkout", "-b", branch}, from...)
+		if err := execCommand("git", args...); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(newDir, "config", file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, args := range [][]string{{"add", "--all"}, {"commit", "-m", "change " + file}} {
+			if err := execCommand("git", append([]string{"-C", newDir}, args...)...); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := execCommand("git", "-C", newDir, "checkout", "-b", "base"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile("feature", "feature.yaml", "kind: ConfigMap\n", "base")
+	commitFile("conflicting", "shared.yaml", "from: conflicting\n", "base")
+	commitFile("synced", "shared.yaml", "from: synced\n", "base")
+
+	repo := NewRepo(Remote{URL: newDir}, ReadOnly)
+	if err := repo.Ready(ctx); err != nil {
+		t.Fatal(err)
+	}
+	synced, err := repo.Revision(ctx, "synced")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	export, err := repo.ExportMerge(ctx, synced, "feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer export.Clean()
+	for _, file := range []string{"feature.yaml", "shared.yaml"} {
+		if _, err := os.Stat(filepath.Join(export.Dir(), "config", file)); err != nil {
+			t.Errorf("expected %s in the merged export: %v", file, err)
+		}
+	}
+
+	_, err = repo.ExportMerge(ctx, synced, "conflicting")
+	conflict, ok := err.(*MergeConflictError)
+	if !ok {
+		t.Fatalf("expected a merge conflict, got %v", err)
+	}
+	if len(conflict.Files) != 1 || conflict.Files[0] != "config/shared.yaml" {
+		t.Errorf("expected a conflict in config/shared.yaml, got %v", conflict.Files)
+	}
+
+	if _, err = repo.ExportMerge(ctx, synced, "nonexistent"); err == nil {
+		t.Error("expected an error for a branch that doesn't exist")
+	}
+}
@@ -0,0 +1,27 @@
+package git
+
+import (
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+
+	fluxmetrics "github.com/weaveworks/flux/metrics"
+)
+
+var (
+	cloneAttempts = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "git",
+		Name:      "clone_attempts_total",
+		Help:      "Count of attempts to clone the git repo.",
+	}, []string{fluxmetrics.LabelSuccess})
+
+	// A timed out fetch takes as long as the timeout (20 seconds by
+	// default)
+	refreshDuration = prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+		Namespace: "flux",
+		Subsystem: "git",
+		Name:      "refresh_duration_seconds",
+		Help:      "Duration of fetches from the upstream of a git repo, by repo.",
+		Buckets:   []float64{0.1, 0.5, 1, 2, 5, 10, 15, 20, 30, 60},
+	}, []string{fluxmetrics.LabelRepo, fluxmetrics.LabelSuccess})
+)
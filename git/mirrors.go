@@ -2,6 +2,7 @@ package git
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 )
@@ -19,15 +20,27 @@ type Mirrors struct {
 	changesMu sync.Mutex
 	changes   chan map[string]struct{}
 
+	// shared by the repos, so only so many refresh at once; nil if
+	// there's no limit
+	slots refreshSlots
+
 	wg *sync.WaitGroup
 }
 
-func NewMirrors() *Mirrors {
-	return &Mirrors{
+// NewMirrors constructs an empty set of mirrors. If concurrency is
+// more than zero, no more than that many of the mirrors fetch from
+// their upstreams at once, whether because their poll interval has
+// elapsed or because of RefreshAll; otherwise, there's no limit.
+func NewMirrors(concurrency int) *Mirrors {
+	m := &Mirrors{
 		repos:   make(map[string]mirroringState),
 		changes: make(chan map[string]struct{}, 1),
 		wg:      &sync.WaitGroup{},
 	}
+	if concurrency > 0 {
+		m.slots = make(refreshSlots, concurrency)
+	}
+	return m
 }
 
 // Changes gets a channel upon which notifications of which repos have
@@ -55,23 +68,35 @@ func (m *Mirrors) signalChange(name string) {
 // Mirror instructs the Mirrors to track a particular repo; if there
 // is already a repo with the name given, nothing is done. Otherwise,
 // the repo given will be mirrored, and changes signalled on the
-// channel obtained with `Changes()`.  The return value indicates
-// whether the repo was already present (`true` if so, `false` otherwise).
+// channel obtained with `Changes()` -- but only when a refresh has
+// changed its refs, so mirrors that are polled often don't cause
+// needless work. The return value indicates whether the repo was
+// already present (`true` if so, `false` otherwise).
 func (m *Mirrors) Mirror(name string, remote Remote, options ...Option) bool {
 	m.reposMu.Lock()
 	defer m.reposMu.Unlock()
 
 	_, ok := m.repos[name]
 	if !ok {
-		repo := NewRepo(remote, options...)
+		repo := NewRepo(remote, append(options, m.slots)...)
 		stop := make(chan struct{})
 		mir := mirroringState{stop: stop, repo: repo}
 		m.repos[name] = mir
-		// Forward any notifications from the repo
+		// Forward notifications from the repo, when its refs have
+		// changed (or can't be read, in which case it's safer to
+		// assume they have)
 		go func() {
+			var refs string
 			for {
 				select {
 				case <-mir.repo.C:
+					ctx, cancel := context.WithTimeout(context.Background(), mir.repo.timeout)
+					newRefs, err := mir.repo.refsFingerprint(ctx)
+					cancel()
+					if err == nil && newRefs == refs {
+						continue
+					}
+					refs = newRefs
 					m.signalChange(name)
 				case <-stop:
 					return
@@ -123,22 +148,43 @@ func (m *Mirrors) StopOne(name string) {
 }
 
 // RefreshAll instructs all the repos to refresh, this means
-// fetching updated refs, and associated objects. The given
-// timeout is the timeout per mirror and _not_ the timeout
-// for the whole operation. It returns a collection of
-// eventual errors it encountered.
+// fetching updated refs, and associated objects. The repos are
+// refreshed concurrently, up to the limit given to NewMirrors, so a
+// slow or failing repo doesn't hold up the others. The given timeout
+// is the timeout per mirror, from when it starts refreshing, and
+// _not_ the timeout for the whole operation. It returns a collection
+// of eventual errors it encountered, each naming the repo.
 func (m *Mirrors) RefreshAll(timeout time.Duration) []error {
+	// Refreshing can take a while, so don't hold the lock: mirrors
+	// may be added or stopped meanwhile
 	m.reposMu.Lock()
-	defer m.reposMu.Unlock()
-
-	var errs []error
+	repos := make([]*Repo, 0, len(m.repos))
 	for _, state := range m.repos {
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		if err := state.repo.Refresh(ctx); err != nil {
-			errs = append(errs, err)
-		}
-		cancel()
+		repos = append(repos, state.repo)
+	}
+	m.reposMu.Unlock()
+
+	var (
+		wg     sync.WaitGroup
+		errsMu sync.Mutex
+		errs   []error
+	)
+	for _, repo := range repos {
+		wg.Add(1)
+		go func(repo *Repo) {
+			defer wg.Done()
+			m.slots.acquire(nil)
+			defer m.slots.release()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if err := repo.Refresh(ctx); err != nil {
+				errsMu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %s", repo.Origin().SafeURL(), err))
+				errsMu.Unlock()
+			}
+		}(repo)
 	}
+	wg.Wait()
 	return errs
 }
 
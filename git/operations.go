@@ -3,6 +3,8 @@ package git
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -32,6 +34,9 @@ type gitCmdConfig struct {
 	dir string
 	env []string
 	out io.Writer
+	// If not nil, gets a copy of what's written to stderr, for
+	// commands that don't report errors as git does
+	errOut io.Writer
 }
 
 func config(ctx context.Context, workingDir, user, email string) error {
@@ -47,22 +52,66 @@ func config(ctx context.Context, workingDir, user, email string) error {
 	return nil
 }
 
-func clone(ctx context.Context, workingDir, repoURL, repoBranch string) (path string, err error) {
+// sshCommand gives the command git should use for ssh, to present
+// the certificate given.
+func sshCommand(certificate string) string {
+	return "ssh -o CertificateFile='" + certificate + "'"
+}
+
+// sshConfig sets the configuration needed to present the SSH
+// certificate given, if not empty, when pushing from a working clone.
+func sshConfig(ctx context.Context, workingDir, certificate string) error {
+	if certificate == "" {
+		return nil
+	}
+	args := []string{"config", "core.sshCommand", sshCommand(certificate)}
+	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir}); err != nil {
+		return errors.Wrap(err, "setting git ssh config")
+	}
+	return nil
+}
+
+// signingConfig sets the configuration needed to sign, and verify
+// signatures, with an SSH key rather than with GPG. This relies on
+// git 2.34 or later.
+func signingConfig(ctx context.Context, workingDir, format, allowedSigners string) error {
+	settings := map[string]string{}
+	if format != "" {
+		settings["gpg.format"] = format
+	}
+	if allowedSigners != "" {
+		settings["gpg.ssh.allowedSignersFile"] = allowedSigners
+	}
+	for k, v := range settings {
+		args := []string{"config", k, v}
+		if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir}); err != nil {
+			return errors.Wrap(err, "setting git signing config")
+		}
+	}
+	return nil
+}
+
+func clone(ctx context.Context, workingDir, repoURL, repoBranch string, env ...string) (path string, err error) {
 	repoPath := workingDir
 	args := []string{"clone"}
 	if repoBranch != "" {
 		args = append(args, "--branch", repoBranch)
 	}
 	args = append(args, repoURL, repoPath)
-	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir}); err != nil {
+	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir, env: env}); err != nil {
 		return "", errors.Wrap(err, "git clone")
 	}
 	return repoPath, nil
 }
 
-func mirror(ctx context.Context, workingDir, repoURL string) (path string, err error) {
+func mirror(ctx context.Context, workingDir, repoURL, sshCertificate string) (path string, err error) {
 	repoPath := workingDir
 	args := []string{"clone", "--mirror"}
+	if sshCertificate != "" {
+		// This is kept in the mirror's config, so fetches and pushes
+		// from the mirror use it too
+		args = append(args, "--config", "core.sshCommand="+sshCommand(sshCertificate))
+	}
 	args = append(args, repoURL, repoPath)
 	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir}); err != nil {
 		return "", errors.Wrap(err, "git clone --mirror")
@@ -75,6 +124,25 @@ func checkout(ctx context.Context, workingDir, ref string) error {
 	return execGitCmd(ctx, args, gitCmdConfig{dir: workingDir})
 }
 
+// merge merges the ref given into what's checked out, without
+// committing the result, so it can be looked at. It's run as a
+// placeholder identity, since the merge isn't committed.
+func merge(ctx context.Context, workingDir, ref string) error {
+	args := []string{"merge", "--no-commit", "--no-ff", ref}
+	env := []string{"GIT_AUTHOR_NAME=flux", "GIT_AUTHOR_EMAIL=flux@localhost", "GIT_COMMITTER_NAME=flux", "GIT_COMMITTER_EMAIL=flux@localhost"}
+	return execGitCmd(ctx, args, gitCmdConfig{dir: workingDir, env: env})
+}
+
+// unmerged gives the files with conflicts left by a merge.
+func unmerged(ctx context.Context, workingDir string) ([]string, error) {
+	out := &bytes.Buffer{}
+	args := []string{"diff", "--name-only", "--diff-filter=U"}
+	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir, out: out}); err != nil {
+		return nil, err
+	}
+	return splitList(out.String()), nil
+}
+
 // checkPush sanity-checks that we can write to the upstream repo
 // (being able to `clone` is an adequate check that we can read the
 // upstream).
@@ -202,6 +270,62 @@ func refRevision(ctx context.Context, workingDir, ref string) (string, error) {
 	return strings.TrimSpace(out.String()), nil
 }
 
+// refRevisions gives the commit each ref under the prefix given
+// (ending in `/`) points at, by what follows the prefix in its name.
+func refRevisions(ctx context.Context, workingDir, prefix string) (map[string]string, error) {
+	out := &bytes.Buffer{}
+	args := []string{"for-each-ref", "--format=%(objectname) %(refname)", prefix}
+	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir, out: out}); err != nil {
+		return nil, errors.Wrap(err, "listing refs under "+prefix)
+	}
+	revisions := map[string]string{}
+	for _, line := range splitList(out.String()) {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) == 2 {
+			revisions[strings.TrimPrefix(fields[1], prefix)] = fields[0]
+		}
+	}
+	return revisions, nil
+}
+
+// pushRefs points the full refs given at the revisions given,
+// upstream, deleting those given an empty revision.
+func pushRefs(ctx context.Context, workingDir, upstream string, revisions map[string]string) error {
+	args := []string{"push", "--force", upstream}
+	for ref, rev := range revisions {
+		args = append(args, rev+":"+ref)
+	}
+	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir}); err != nil {
+		return errors.Wrap(err, "pushing refs to origin")
+	}
+	return nil
+}
+
+// refsFingerprint gives a digest of all the refs and what they point
+// at, so that it's easy to tell whether any have changed.
+func refsFingerprint(ctx context.Context, workingDir string) (string, error) {
+	out := &bytes.Buffer{}
+	args := []string{"for-each-ref", "--format=%(objectname) %(refname)"}
+	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir, out: out}); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(out.Bytes())
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// isAncestor reports whether the commit `ancestor` is reachable from
+// the commit `descendant`; i.e., whether moving from one to the other
+// is a fast-forward.
+func isAncestor(ctx context.Context, workingDir, ancestor, descendant string) (bool, error) {
+	args := []string{"merge-base", "--is-ancestor", ancestor, descendant}
+	err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir})
+	if _, ok := err.(*exec.ExitError); ok {
+		// A non-zero exit without a fatal error message means "no"
+		return false, nil
+	}
+	return err == nil, err
+}
+
 // Return the revisions and one-line log commit messages
 func onelinelog(ctx context.Context, workingDir, refspec string, subdirs []string) ([]Commit, error) {
 	out := &bytes.Buffer{}
@@ -218,6 +342,18 @@ func onelinelog(ctx context.Context, workingDir, refspec string, subdirs []strin
 	return splitLog(out.String())
 }
 
+// Return the revision of the last commit in refspec touching any of
+// the subdirs, or the empty string if there isn't one
+func lastRevision(ctx context.Context, workingDir, refspec string, subdirs []string) (string, error) {
+	out := &bytes.Buffer{}
+	args := []string{"log", "-1", "--pretty=format:%H", refspec, "--"}
+	args = append(args, subdirs...)
+	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir, out: out}); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
 func splitLog(s string) ([]Commit, error) {
 	lines := splitList(s)
 	commits := make([]Commit, len(lines))
@@ -238,8 +374,9 @@ func splitList(s string) []string {
 	return strings.Split(outStr, "\n")
 }
 
-// Move the tag to the ref given and push that tag upstream
-func moveTagAndPush(ctx context.Context, workingDir, tag, upstream string, tagAction TagAction) error {
+// Move the tag to the revision given and push that tag upstream, to
+// the ref given (usually refs/tags/<tag>)
+func moveTagAndPush(ctx context.Context, workingDir, tag, ref, upstream string, tagAction TagAction) error {
 	args := []string{"tag", "--force", "-a", "-m", tagAction.Message}
 	var env []string
 	if tagAction.SigningKey != "" {
@@ -249,13 +386,23 @@ func moveTagAndPush(ctx context.Context, workingDir, tag, upstream string, tagAc
 	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir, env: env}); err != nil {
 		return errors.Wrap(err, "moving tag "+tag)
 	}
-	args = []string{"push", "--force", upstream, "tag", tag}
+	args = []string{"push", "--force", upstream, "refs/tags/" + tag + ":" + ref}
 	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir}); err != nil {
 		return errors.Wrap(err, "pushing tag to origin")
 	}
 	return nil
 }
 
+// checkRefFormat checks that the ref given is a valid, full ref
+// name, e.g., refs/flux/prod/sync.
+func checkRefFormat(ctx context.Context, ref string) error {
+	args := []string{"check-ref-format", ref}
+	if err := execGitCmd(ctx, args, gitCmdConfig{}); err != nil {
+		return fmt.Errorf("%q is not a valid git ref", ref)
+	}
+	return nil
+}
+
 func verifyTag(ctx context.Context, workingDir, tag string) error {
 	var env []string
 	args := []string{"verify-tag", tag}
@@ -265,6 +412,60 @@ func verifyTag(ctx context.Context, workingDir, tag string) error {
 	return nil
 }
 
+func verifyCommit(ctx context.Context, workingDir, rev string) error {
+	args := []string{"verify-commit", rev}
+	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir}); err != nil {
+		return errors.Wrap(err, "verifying commit "+rev)
+	}
+	return nil
+}
+
+// commitEmails gives the author and committer email addresses of the
+// commit at the revision given.
+func commitEmails(ctx context.Context, workingDir, rev string) (author, committer string, err error) {
+	out := &bytes.Buffer{}
+	args := []string{"show", "--no-patch", "--format=%ae%n%ce", rev}
+	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir, out: out}); err != nil {
+		return "", "", errors.Wrap(err, "reading author of commit "+rev)
+	}
+	lines := strings.SplitN(strings.TrimSpace(out.String()), "\n", 2)
+	if len(lines) != 2 {
+		return "", "", fmt.Errorf("unexpected output reading author of commit %s: %q", rev, out.String())
+	}
+	return strings.TrimSpace(lines[0]), strings.TrimSpace(lines[1]), nil
+}
+
+// isShallow reports whether the repo is a shallow clone, i.e.,
+// is missing some history.
+func isShallow(ctx context.Context, workingDir string) (bool, error) {
+	out := &bytes.Buffer{}
+	args := []string{"rev-parse", "--is-shallow-repository"}
+	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir, out: out}); err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out.String()) == "true", nil
+}
+
+// unshallow fetches the history missing from a shallow clone.
+func unshallow(ctx context.Context, workingDir, upstream string) error {
+	args := []string{"fetch", "--unshallow", upstream}
+	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir}); err != nil {
+		return errors.Wrap(err, "git fetch --unshallow")
+	}
+	return nil
+}
+
+// revList gives the revisions of the commits in the refspec given
+// (e.g., a range, old..new), newest first.
+func revList(ctx context.Context, workingDir, refspec string) ([]string, error) {
+	out := &bytes.Buffer{}
+	args := []string{"rev-list", refspec, "--"}
+	if err := execGitCmd(ctx, args, gitCmdConfig{dir: workingDir, out: out}); err != nil {
+		return nil, err
+	}
+	return splitList(out.String()), nil
+}
+
 func changed(ctx context.Context, workingDir, ref string, subPaths []string) ([]string, error) {
 	out := &bytes.Buffer{}
 	// This uses --diff-filter to only look at changes for file _in
@@ -325,6 +526,9 @@ func execGitCmd(ctx context.Context, args []string, config gitCmdConfig) error {
 	}
 	errOut := &bytes.Buffer{}
 	c.Stderr = errOut
+	if config.errOut != nil {
+		c.Stderr = io.MultiWriter(errOut, config.errOut)
+	}
 
 	traceStdout := &bytes.Buffer{}
 	traceStderr := &bytes.Buffer{}
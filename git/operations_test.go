@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -199,6 +200,43 @@ func TestOnelinelog_WithGitpath(t *testing.T) {
 	}
 }
 
+func TestLastRevision(t *testing.T) {
+	newDir, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+
+	err := createRepo(newDir, []string{"dev", "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = updateDirAndCommit(newDir, "dev", testfiles.FilesUpdated); err != nil {
+		t.Fatal(err)
+	}
+	if err = updateDirAndCommit(newDir, "prod", testfiles.FilesUpdated); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	devCommit, err := refRevision(ctx, newDir, "HEAD~1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev, err := lastRevision(ctx, newDir, "HEAD", []string{"dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, devCommit, rev)
+
+	head, err := refRevision(ctx, newDir, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev, err = lastRevision(ctx, newDir, "HEAD", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, head, rev)
+}
+
 func TestCheckPush(t *testing.T) {
 	upstreamDir, upstreamCleanup := testfiles.TempDir(t)
 	defer upstreamCleanup()
@@ -219,8 +257,224 @@ func TestCheckPush(t *testing.T) {
 	}
 }
 
+func TestMoveTagAndPush_SyncRef(t *testing.T) {
+	upstreamDir, upstreamCleanup := testfiles.TempDir(t)
+	defer upstreamCleanup()
+	if err := createRepo(upstreamDir, []string{"config"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cloneDir, cloneCleanup := testfiles.TempDir(t)
+	defer cloneCleanup()
+
+	ctx := context.Background()
+	working, err := clone(ctx, cloneDir, upstreamDir, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config(ctx, working, "operations_test_user", "example@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	head, err := refRevision(ctx, working, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tagAction := TagAction{Revision: head, Message: "Sync pointer"}
+	if err := moveTagAndPush(ctx, working, "flux-sync", "refs/flux/dev/sync", upstreamDir, tagAction); err != nil {
+		t.Fatal(err)
+	}
+	rev, err := refRevision(ctx, upstreamDir, "refs/flux/dev/sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, head, rev)
+	ok, err := refExists(ctx, upstreamDir, "refs/tags/flux-sync")
+	assert.NoError(t, err)
+	assert.False(t, ok, "expected the sync tag not to be pushed to refs/tags")
+}
+
+// TestCloneWithoutSyncRef checks that the notes are fetched into a
+// clone even when the sync ref doesn't exist yet, as before the
+// first sync.
+func TestCloneWithoutSyncRef(t *testing.T) {
+	upstreamDir, upstreamCleanup := testfiles.TempDir(t)
+	defer upstreamCleanup()
+	if err := createRepo(upstreamDir, []string{"config"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	head, err := refRevision(ctx, upstreamDir, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addNote(ctx, upstreamDir, head, "refs/notes/flux", &Note{ID: "note"}); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewRepo(Remote{URL: upstreamDir})
+	if err := repo.Ready(ctx); err != nil {
+		t.Fatal(err)
+	}
+	working, err := repo.Clone(ctx, Config{Branch: "master", NotesRef: "flux", SyncRef: "refs/flux/dev/sync", UserName: "flux", UserEmail: "flux@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer working.Clean()
+	notes, err := working.NoteRevList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := notes[head]; !ok {
+		t.Errorf("expected the note on %s to be fetched, got notes on %v", head, notes)
+	}
+}
+
+func TestValidateSyncRef(t *testing.T) {
+	for ref, valid := range map[string]bool{
+		"refs/flux/prod/sync": true,
+		"refs/tags/flux-sync": true,
+		"flux-sync":           false,
+		"refs/heads/master":   false,
+		"refs/flux/bad..ref":  false,
+	} {
+		err := ValidateSyncRef(ref)
+		if valid && err != nil {
+			t.Errorf("expected %q to be valid, got %s", ref, err)
+		} else if !valid && err == nil {
+			t.Errorf("expected %q to be invalid", ref)
+		}
+	}
+}
+
+func TestValidateBranch(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"master":           true,
+		"feature/ingress":  true,
+		"--upload-pack=sh": false,
+		"master~1":         false,
+		"a..b":             false,
+		"master:other":     false,
+		"has space":        false,
+	} {
+		err := ValidateBranch(name)
+		if valid && err != nil {
+			t.Errorf("expected %q to be valid, got %s", name, err)
+		} else if !valid && err == nil {
+			t.Errorf("expected %q to be invalid", name)
+		}
+	}
+}
+
+func TestValidateRevision(t *testing.T) {
+	for rev, valid := range map[string]bool{
+		"9f4e2d1": true,
+		"9f4e2d1a0c1b2d3e4f5a6b7c8d9e0f1a2b3c4d5e": true,
+		"master":            true,
+		"refs/tags/v1.0":    true,
+		"-n":                false,
+		"--output=/tmp/x":   false,
+		"HEAD~2":            false,
+		"master^":           false,
+		"master@{upstream}": false,
+		"a..b":              false,
+	} {
+		err := ValidateRevision(rev)
+		if valid && err != nil {
+			t.Errorf("expected %q to be valid, got %s", rev, err)
+		} else if !valid && err == nil {
+			t.Errorf("expected %q to be invalid", rev)
+		}
+	}
+}
+
 // ---
 
+func TestSigningConfig_SSH(t *testing.T) {
+	newDir, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+
+	err := createRepo(newDir, []string{"another"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err = signingConfig(ctx, newDir, "ssh", "/etc/fluxd/allowed_signers"); err != nil {
+		t.Fatal(err)
+	}
+	for k, expected := range map[string]string{
+		"gpg.format":                 "ssh",
+		"gpg.ssh.allowedSignersFile": "/etc/fluxd/allowed_signers",
+	} {
+		out, err := exec.Command("git", "-C", newDir, "config", "--get", k).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, expected, strings.TrimSpace(string(out)))
+	}
+
+	// The commits made by createRepo aren't signed, so shouldn't
+	// pass verification
+	if err = verifyCommit(ctx, newDir, "HEAD"); err == nil {
+		t.Error("expected unsigned commit to fail verification")
+	}
+}
+
+func TestVerifyRevisions_Shallow(t *testing.T) {
+	upstreamDir, upstreamCleanup := testfiles.TempDir(t)
+	defer upstreamCleanup()
+	if err := createRepo(upstreamDir, []string{"dev", "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	from, err := refRevision(ctx, upstreamDir, "HEAD~2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := refRevision(ctx, upstreamDir, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shallowClone := func(t *testing.T) (string, func()) {
+		dir, cleanup := testfiles.TempDir(t)
+		// --depth is ignored for plain local paths, so use a URL
+		if err := execCommand("git", "clone", "--depth", "1", "file://"+upstreamDir, dir); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		shallow, err := isShallow(ctx, dir)
+		if err != nil || !shallow {
+			cleanup()
+			t.Fatalf("expected shallow clone, got shallow=%v, err=%v", shallow, err)
+		}
+		return dir, cleanup
+	}
+
+	// When the history can't be fetched, refuse rather than
+	// verifying only the commits present
+	dir, cleanup := shallowClone(t)
+	defer cleanup()
+	co := &Checkout{dir: dir, upstream: Remote{URL: "file:///nonexistent"}}
+	if err := co.VerifyRevisions(ctx, from, to); err == nil || !strings.Contains(err.Error(), ErrIncompleteHistory.Error()) {
+		t.Errorf("expected incomplete history error, got %v", err)
+	}
+
+	// When it can be fetched, the clone is deepened and all the
+	// commits are checked; these aren't signed, so fail
+	dir, cleanup = shallowClone(t)
+	defer cleanup()
+	co = &Checkout{dir: dir, upstream: Remote{URL: "file://" + upstreamDir}}
+	err = co.VerifyRevisions(ctx, from, to)
+	if err == nil || strings.Contains(err.Error(), ErrIncompleteHistory.Error()) {
+		t.Errorf("expected verification to fail on an unsigned commit, got %v", err)
+	}
+	shallow, err := isShallow(ctx, dir)
+	assert.NoError(t, err)
+	assert.False(t, shallow, "expected clone to have been deepened")
+}
+
 func createRepo(dir string, subdirs []string) error {
 	var (
 		err      error
@@ -2,18 +2,28 @@ package git
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"sync"
 
 	"context"
 	"time"
+
+	"github.com/go-kit/kit/log"
+
+	fluxmetrics "github.com/weaveworks/flux/metrics"
 )
 
 const (
 	defaultInterval = 5 * time.Minute
 	defaultTimeout  = 20 * time.Second
 
+	// When cloning fails, it's tried again after this long, doubling
+	// each time up to the maximum
+	initialCloneBackoff = time.Second
+	maxCloneBackoff     = 30 * time.Second
+
 	CheckPushTag = "flux-write-check"
 )
 
@@ -40,6 +50,7 @@ type GitRepoStatus string
 const (
 	RepoNoConfig GitRepoStatus = "unconfigured" // configuration is empty
 	RepoNew      GitRepoStatus = "new"          // no attempt made to clone it yet
+	RepoWaiting  GitRepoStatus = "waiting"      // cloning failed; waiting to try again
 	RepoCloned   GitRepoStatus = "cloned"       // has been read (cloned); no attempt made to write
 	RepoReady    GitRepoStatus = "ready"        // has been written to, so ready to sync
 )
@@ -50,6 +61,19 @@ type Repo struct {
 	interval time.Duration
 	timeout  time.Duration
 	readonly bool
+	// If not empty, the SSH certificate to present alongside the key
+	sshCertificate string
+	// If non-zero, how long to keep trying to clone the repo for the
+	// first time, before giving up
+	cloneTimeout time.Duration
+	// If not nil, shared with other repos so that only so many
+	// refresh at once; see Mirrors
+	refreshSlots refreshSlots
+	// If true, fetch the objects of files stored with git LFS; see LFS
+	lfs         bool
+	lfsURL      string
+	lfsBranches []string
+	lfsLogger   log.Logger
 
 	// State
 	mu     sync.RWMutex
@@ -59,6 +83,9 @@ type Repo struct {
 
 	notify chan struct{}
 	C      chan struct{}
+
+	scopesMu sync.Mutex
+	scopes   []*Scope
 }
 
 type Option interface {
@@ -83,6 +110,51 @@ func (t Timeout) apply(r *Repo) {
 	r.timeout = time.Duration(t)
 }
 
+// SSHCertificate is the path to an SSH certificate, to be presented
+// alongside the SSH key when talking to the upstream. It's read by
+// ssh for each command, so it can be renewed in place.
+type SSHCertificate string
+
+func (c SSHCertificate) apply(r *Repo) {
+	r.sshCertificate = string(c)
+}
+
+// CloneTimeout is how long to keep trying to clone the repo for the
+// first time, before Start gives up; if zero, it never does.
+type CloneTimeout time.Duration
+
+func (t CloneTimeout) apply(r *Repo) {
+	r.cloneTimeout = time.Duration(t)
+}
+
+// refreshSlots limits how many of the repos given it refresh from
+// their upstreams at once; a nil refreshSlots doesn't limit them.
+type refreshSlots chan struct{}
+
+func (s refreshSlots) apply(r *Repo) {
+	r.refreshSlots = s
+}
+
+// acquire waits for a free slot, and takes it, returning true; or
+// returns false if stop is closed first.
+func (s refreshSlots) acquire(stop <-chan struct{}) bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+func (s refreshSlots) release() {
+	if s != nil {
+		<-s
+	}
+}
+
 var ReadOnly optionFunc = func(r *Repo) {
 	r.readonly = true
 }
@@ -175,6 +247,7 @@ func (r *Repo) refreshed() {
 	case r.C <- struct{}{}:
 	default:
 	}
+	r.signalScopes()
 }
 
 // errorIfNotReady returns the appropriate error if the repo is not
@@ -234,30 +307,36 @@ func (r *Repo) step(bg context.Context) bool {
 		// process, so just exit.
 		return false
 
-	case RepoNew:
+	case RepoNew, RepoWaiting:
 		rootdir, err := ioutil.TempDir(os.TempDir(), "flux-gitclone")
 		if err != nil {
 			panic(err)
 		}
 
 		ctx, cancel := context.WithTimeout(bg, r.timeout)
-		dir, err = mirror(ctx, rootdir, url)
+		dir, err = mirror(ctx, rootdir, url, r.sshCertificate)
 		cancel()
 		if err == nil {
 			r.mu.Lock()
 			r.dir = dir
 			ctx, cancel := context.WithTimeout(bg, r.timeout)
-			err = r.fetch(ctx)
+			if r.lfs {
+				err = lfsConfig(ctx, dir, r.lfsURL)
+			}
+			if err == nil {
+				err = r.fetch(ctx)
+			}
 			cancel()
 			r.mu.Unlock()
 		}
+		cloneAttempts.With(fluxmetrics.LabelSuccess, fmt.Sprint(err == nil)).Add(1)
 		if err == nil {
 			r.setUnready(RepoCloned, ErrClonedOnly)
 			return true
 		}
 		dir = ""
 		os.RemoveAll(rootdir)
-		r.setUnready(RepoNew, err)
+		r.setUnready(RepoWaiting, certificateError(r.sshCertificate, err))
 		return false
 
 	case RepoCloned:
@@ -266,7 +345,7 @@ func (r *Repo) step(bg context.Context) bool {
 			err := checkPush(ctx, dir, url)
 			cancel()
 			if err != nil {
-				r.setUnready(RepoCloned, err)
+				r.setUnready(RepoCloned, certificateError(r.sshCertificate, err))
 				return false
 			}
 		}
@@ -296,10 +375,15 @@ func (r *Repo) Ready(ctx context.Context) error {
 }
 
 // Start begins synchronising the repo by cloning it, then fetching
-// the required tags and so on.
+// the required tags and so on. If cloning fails, it's tried again
+// with backoff; if the repo has never been cloned, and the clone
+// timeout has elapsed, Start gives up and returns an error.
 func (r *Repo) Start(shutdown <-chan struct{}, done *sync.WaitGroup) error {
 	defer done.Done()
 
+	started := time.Now()
+	backoff := initialCloneBackoff
+	var cloned bool
 	for {
 		ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
 		advanced := r.step(ctx)
@@ -309,17 +393,29 @@ func (r *Repo) Start(shutdown <-chan struct{}, done *sync.WaitGroup) error {
 			continue
 		}
 
-		status, _ := r.Status()
-		if status == RepoReady {
+		status, err := r.Status()
+		wait := 10 * time.Second
+		switch status {
+		case RepoReady:
+			cloned = true
+			backoff = initialCloneBackoff
 			if err := r.refreshLoop(shutdown); err != nil {
-				r.setUnready(RepoNew, err)
+				r.setUnready(RepoNew, certificateError(r.sshCertificate, err))
 				continue // with new status, skipping timer
 			}
-		} else if status == RepoNoConfig {
+		case RepoNoConfig:
 			return nil
+		case RepoWaiting:
+			if !cloned && r.cloneTimeout > 0 && time.Since(started) >= r.cloneTimeout {
+				return fmt.Errorf("giving up on cloning git repo after %s: %v", r.cloneTimeout, err)
+			}
+			wait = backoff
+			if backoff *= 2; backoff > maxCloneBackoff {
+				backoff = maxCloneBackoff
+			}
 		}
 
-		tryAgain := time.NewTimer(10 * time.Second)
+		tryAgain := time.NewTimer(wait)
 		select {
 		case <-shutdown:
 			if !tryAgain.Stop() {
@@ -334,6 +430,16 @@ func (r *Repo) Start(shutdown <-chan struct{}, done *sync.WaitGroup) error {
 }
 
 func (r *Repo) Refresh(ctx context.Context) error {
+	if err := r.refresh(ctx); err != nil {
+		return err
+	}
+	// This can take a while, so it's done holding only a read lock
+	r.lfsPrefetch(ctx)
+	r.refreshed()
+	return nil
+}
+
+func (r *Repo) refresh(ctx context.Context) (err error) {
 	// the lock here and below is difficult to avoid; possibly we
 	// could clone to another repo and pull there, then swap when complete.
 	r.mu.Lock()
@@ -341,11 +447,14 @@ func (r *Repo) Refresh(ctx context.Context) error {
 	if err := r.errorIfNotReady(); err != nil {
 		return err
 	}
-	if err := r.fetch(ctx); err != nil {
-		return err
-	}
-	r.refreshed()
-	return nil
+	started := time.Now()
+	defer func() {
+		refreshDuration.With(
+			fluxmetrics.LabelRepo, r.origin.SafeURL(),
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(started).Seconds())
+	}()
+	return r.fetch(ctx)
 }
 
 func (r *Repo) refreshLoop(shutdown <-chan struct{}) error {
@@ -366,9 +475,15 @@ func (r *Repo) refreshLoop(shutdown <-chan struct{}) error {
 				default:
 				}
 			}
+			// The timeout is from when there's a free slot, so
+			// that waiting for other repos doesn't count against it
+			if !r.refreshSlots.acquire(shutdown) {
+				return nil
+			}
 			ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
 			err := r.Refresh(ctx)
 			cancel()
+			r.refreshSlots.release()
 			if err != nil {
 				return err
 			}
@@ -377,12 +492,20 @@ func (r *Repo) refreshLoop(shutdown <-chan struct{}) error {
 	}
 }
 
+// refsFingerprint gives a digest of the refs in the repo, which
+// changes whenever a ref is added, removed or moved.
+func (r *Repo) refsFingerprint(ctx context.Context) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if err := r.errorIfNotReady(); err != nil {
+		return "", err
+	}
+	return refsFingerprint(ctx, r.dir)
+}
+
 // fetch gets updated refs, and associated objects, from the upstream.
 func (r *Repo) fetch(ctx context.Context) error {
-	if err := fetch(ctx, r.dir, "origin"); err != nil {
-		return err
-	}
-	return nil
+	return fetch(ctx, r.dir, "origin")
 }
 
 // workingClone makes a non-bare clone, at `ref` (probably a branch),
@@ -397,5 +520,18 @@ func (r *Repo) workingClone(ctx context.Context, ref string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return clone(ctx, working, r.dir, ref)
+	if !r.lfs {
+		return clone(ctx, working, r.dir, ref)
+	}
+	// The LFS objects are filled in from the mirror afterwards (see
+	// lfsCheckout), rather than by git LFS fetching them from the
+	// mirror as it would its remote
+	if _, err := clone(ctx, working, r.dir, ref, "GIT_LFS_SKIP_SMUDGE=1"); err != nil {
+		return "", err
+	}
+	if err := lfsInstall(ctx, working, r.dir); err != nil {
+		os.RemoveAll(working)
+		return "", err
+	}
+	return working, nil
 }
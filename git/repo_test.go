@@ -0,0 +1,38 @@
+package git
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux/cluster/kubernetes/testfiles"
+)
+
+func TestStartGivesUpCloning(t *testing.T) {
+	dir, cleanup := testfiles.TempDir(t)
+	defer cleanup()
+
+	// Nothing to clone here
+	repo := NewRepo(Remote{URL: dir + "/nonexistent"}, ReadOnly, CloneTimeout(50*time.Millisecond))
+	shutdown := make(chan struct{})
+	defer close(shutdown)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- repo.Start(shutdown, &wg)
+	}()
+
+	select {
+	case err := <-errc:
+		if err == nil || !strings.Contains(err.Error(), "giving up") {
+			t.Errorf("expected to give up cloning, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for Start to give up")
+	}
+	if status, err := repo.Status(); status != RepoWaiting || err == nil {
+		t.Errorf("expected status %q with the clone error, got %q (%v)", RepoWaiting, status, err)
+	}
+}
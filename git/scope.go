@@ -0,0 +1,93 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Scope is a logical sync root within a repo: a set of paths which
+// are synced, and have their progress tracked with a sync tag,
+// independently of the rest of the repo. Several scopes can share
+// the one mirror, which saves cloning (and storing) a large repo
+// once per scope.
+type Scope struct {
+	Name    string
+	Paths   []string
+	SyncTag string
+	// C receives a value whenever the mirror has been refreshed;
+	// like `Repo.C`, any pending value is not replaced.
+	C chan struct{}
+}
+
+// AddScope registers a scope with the repo, so that it will be
+// signalled when the mirror is refreshed. Each scope must have a name
+// and sync tag of its own, so that scopes don't overwrite one
+// another's progress.
+func (r *Repo) AddScope(name string, paths []string, syncTag string) (*Scope, error) {
+	if name == "" {
+		return nil, fmt.Errorf("scope must have a name")
+	}
+	if syncTag == "" {
+		return nil, fmt.Errorf("scope %s must have a sync tag", name)
+	}
+	r.scopesMu.Lock()
+	defer r.scopesMu.Unlock()
+	for _, s := range r.scopes {
+		if s.Name == name {
+			return nil, fmt.Errorf("scope %s already exists", name)
+		}
+		if s.SyncTag == syncTag {
+			return nil, fmt.Errorf("scopes %s and %s cannot both use the sync tag %s", s.Name, name, syncTag)
+		}
+	}
+	s := &Scope{
+		Name:    name,
+		Paths:   paths,
+		SyncTag: syncTag,
+		C:       make(chan struct{}, 1),
+	}
+	r.scopes = append(r.scopes, s)
+	return s, nil
+}
+
+// Config gives the config to use for working clones of the scope;
+// that is, the config given with the paths and sync tag replaced by
+// those of the scope. If the sync tag is kept at a ref of its own,
+// the scope's is that ref suffixed with the scope's name.
+func (s *Scope) Config(conf Config) Config {
+	conf.Paths = s.Paths
+	conf.SyncTag = s.SyncTag
+	if conf.SyncRef != "" {
+		conf.SyncRef = conf.SyncRef + "-" + s.Name
+	}
+	return conf
+}
+
+func (s *Scope) String() string {
+	return s.Name + "=" + strings.Join(s.Paths, ",")
+}
+
+func (r *Repo) signalScopes() {
+	r.scopesMu.Lock()
+	defer r.scopesMu.Unlock()
+	for _, s := range r.scopes {
+		select {
+		case s.C <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// LastRevision returns the revision (SHA1) of the most recent commit
+// reachable from the ref given that touches any of the paths given,
+// or the empty string if there is no such commit. With no paths, it
+// is the same as `Revision`.
+func (r *Repo) LastRevision(ctx context.Context, ref string, paths ...string) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if err := r.errorIfNotReady(); err != nil {
+		return "", err
+	}
+	return lastRevision(ctx, r.dir, ref, paths)
+}
@@ -0,0 +1,38 @@
+package git
+
+import (
+	"testing"
+)
+
+func TestAddScope(t *testing.T) {
+	repo := NewRepo(Remote{URL: "git@example.com:org/repo"})
+	a, err := repo.AddScope("team-a", []string{"teams/a"}, "flux-sync-team-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.AddScope("team-a", []string{"teams/b"}, "flux-sync-team-b"); err == nil {
+		t.Error("expected error adding scope with the same name")
+	}
+	if _, err := repo.AddScope("team-b", []string{"teams/b"}, "flux-sync-team-a"); err == nil {
+		t.Error("expected error adding scope with the same sync tag")
+	}
+	b, err := repo.AddScope("team-b", []string{"teams/b"}, "flux-sync-team-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := a.Config(Config{Branch: "master", Paths: []string{"."}, SyncTag: "flux-sync"})
+	if conf.Branch != "master" || conf.SyncTag != "flux-sync-team-a" || len(conf.Paths) != 1 || conf.Paths[0] != "teams/a" {
+		t.Errorf("unexpected config for scope: %+v", conf)
+	}
+
+	repo.refreshed()
+	repo.refreshed()
+	for _, s := range []*Scope{a, b} {
+		select {
+		case <-s.C:
+		default:
+			t.Errorf("expected scope %s to be signalled", s.Name)
+		}
+	}
+}
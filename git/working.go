@@ -3,36 +3,112 @@ package git
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 )
 
 var (
 	ErrReadOnly = errors.New("cannot make a working clone of a read-only git repo")
+	// Returned when commits can't be verified because the clone is
+	// shallow, and can't be deepened
+	ErrIncompleteHistory = errors.New("the history needed to verify commits is missing from the clone, and could not be fetched")
 )
 
 // Config holds some values we use when working in the working clone of
 // a repo.
 type Config struct {
-	Branch      string   // branch we're syncing to
-	Paths       []string // paths within the repo containing files we care about
-	SyncTag     string
-	NotesRef    string
-	UserName    string
-	UserEmail   string
-	SigningKey  string
-	SetAuthor   bool
-	SkipMessage string
+	Branch  string   // branch we're syncing to
+	Paths   []string // paths within the repo containing files we care about
+	SyncTag string
+	// The full ref (e.g., refs/flux/prod/sync) to which the sync tag
+	// is written; if empty, it's refs/tags/<SyncTag>
+	SyncRef    string
+	NotesRef   string
+	UserName   string
+	UserEmail  string
+	SigningKey string
+	// How commits and tags are signed; either "openpgp" (the
+	// default, if empty) or "ssh"
+	SigningFormat string
+	// For verifying SSH signatures, a file listing the keys allowed
+	// to sign (as for `ssh-keygen -Y verify`)
+	SSHAllowedSigners string
+	SetAuthor         bool
+	SkipMessage       string
+}
+
+// SyncTagRef gives the full ref of the sync tag.
+func (c Config) SyncTagRef() string {
+	if c.SyncRef != "" {
+		return c.SyncRef
+	}
+	return "refs/tags/" + c.SyncTag
+}
+
+// SyncStateRef gives the full ref for the state named kept about
+// syncing alongside the sync tag, e.g., for the sync tag flux-sync
+// and the name "pending", refs/flux-state/tags/flux-sync/pending.
+// Given the empty name, it gives the prefix of all such refs.
+func (c Config) SyncStateRef(name string) string {
+	return "refs/flux-state/" + strings.TrimPrefix(c.SyncTagRef(), "refs/") + "/" + name
+}
+
+// ValidateSyncRef checks that the ref given can be used for the sync
+// tag: it must be a valid, full ref name, and not a branch.
+func ValidateSyncRef(ref string) error {
+	if !strings.HasPrefix(ref, "refs/") {
+		return fmt.Errorf("sync ref %q must be a full ref, starting with refs/", ref)
+	}
+	if strings.HasPrefix(ref, "refs/heads/") || strings.HasPrefix(ref, "refs/remotes/") {
+		return fmt.Errorf("sync ref %q must not be a branch", ref)
+	}
+	return checkRefFormat(context.Background(), ref)
+}
+
+// A commit SHA, in full or abbreviated
+var commitSHARE = regexp.MustCompile(`^[0-9a-fA-F]{4,40}$`)
+
+// ValidateBranch checks that the name given is a plain branch name,
+// e.g., feature/foo, and not something git would take as an option or
+// a revision expression.
+func ValidateBranch(name string) error {
+	if strings.HasPrefix(name, "-") || checkRefFormat(context.Background(), "refs/heads/"+name) != nil {
+		return fmt.Errorf("%q is not a valid branch name", name)
+	}
+	return nil
+}
+
+// ValidateRevision checks that the revision given is a commit SHA, or
+// a plain ref, either full (refs/tags/v1) or short (master); and not
+// something git would take as an option or a revision expression
+// (e.g., HEAD~2).
+func ValidateRevision(rev string) error {
+	if commitSHARE.MatchString(rev) {
+		return nil
+	}
+	ref := rev
+	if !strings.HasPrefix(ref, "refs/") {
+		ref = "refs/heads/" + ref
+	}
+	if strings.HasPrefix(rev, "-") || checkRefFormat(context.Background(), ref) != nil {
+		return fmt.Errorf("%q is not a plain ref or commit SHA", rev)
+	}
+	return nil
 }
 
 // Checkout is a local working clone of the remote repo. It is
 // intended to be used for one-off "transactions", e.g,. committing
 // changes then pushing upstream. It has no locking.
 type Checkout struct {
-	dir          string
-	config       Config
-	upstream     Remote
-	realNotesRef string // cache the notes ref, since we use it to push as well
+	dir            string
+	config         Config
+	upstream       Remote
+	realNotesRef   string // cache the notes ref, since we use it to push as well
+	sshCertificate string
+	lfs            bool
 }
 
 type Commit struct {
@@ -68,11 +144,26 @@ func (r *Repo) Clone(ctx context.Context, conf Config) (*Checkout, error) {
 		return nil, err
 	}
 
+	if err := r.lfsCheckout(ctx, repoDir); err != nil {
+		os.RemoveAll(repoDir)
+		return nil, err
+	}
+
 	if err := config(ctx, repoDir, conf.UserName, conf.UserEmail); err != nil {
 		os.RemoveAll(repoDir)
 		return nil, err
 	}
 
+	if err := signingConfig(ctx, repoDir, conf.SigningFormat, conf.SSHAllowedSigners); err != nil {
+		os.RemoveAll(repoDir)
+		return nil, err
+	}
+
+	if err := sshConfig(ctx, repoDir, r.sshCertificate); err != nil {
+		os.RemoveAll(repoDir)
+		return nil, err
+	}
+
 	// We'll need the notes ref for pushing it, so make sure we have
 	// it. This assumes we're syncing it (otherwise we'll likely get conflicts)
 	realNotesRef, err := getNotesRef(ctx, repoDir, conf.NotesRef)
@@ -81,19 +172,34 @@ func (r *Repo) Clone(ctx context.Context, conf Config) (*Checkout, error) {
 		return nil, err
 	}
 
+	// Tags are fetched anyway, but a sync tag kept elsewhere has to
+	// be asked for. The notes ref and sync ref may not exist yet
+	// (e.g., before the first sync), and git gives up on a whole
+	// fetch if any ref is missing, so each is fetched on its own.
+	refspecs := []string{realNotesRef + ":" + realNotesRef}
+	if conf.SyncRef != "" {
+		refspecs = append(refspecs, conf.SyncRef+":"+conf.SyncRef)
+	}
+	stateRefs := conf.SyncStateRef("*")
+	refspecs = append(refspecs, stateRefs+":"+stateRefs)
+
 	r.mu.RLock()
-	if err := fetch(ctx, repoDir, r.dir, realNotesRef+":"+realNotesRef); err != nil {
-		os.RemoveAll(repoDir)
-		r.mu.RUnlock()
-		return nil, err
+	for _, refspec := range refspecs {
+		if err := fetch(ctx, repoDir, r.dir, refspec); err != nil {
+			os.RemoveAll(repoDir)
+			r.mu.RUnlock()
+			return nil, err
+		}
 	}
 	r.mu.RUnlock()
 
 	return &Checkout{
-		dir:          repoDir,
-		upstream:     upstream,
-		realNotesRef: realNotesRef,
-		config:       conf,
+		dir:            repoDir,
+		upstream:       upstream,
+		realNotesRef:   realNotesRef,
+		config:         conf,
+		sshCertificate: r.sshCertificate,
+		lfs:            r.lfs,
 	}, nil
 }
 
@@ -130,6 +236,11 @@ func (c *Checkout) CommitAndPush(ctx context.Context, commitAction CommitAction,
 	if !check(ctx, c.dir, c.config.Paths) {
 		return ErrNoChanges
 	}
+	if c.lfs {
+		if err := lfsCheckCommit(ctx, c.dir); err != nil {
+			return err
+		}
+	}
 
 	commitAction.Message += c.config.SkipMessage
 	if commitAction.SigningKey == "" {
@@ -159,7 +270,7 @@ func (c *Checkout) CommitAndPush(ctx context.Context, commitAction CommitAction,
 	}
 
 	if err := push(ctx, c.dir, c.upstream.URL, refs); err != nil {
-		return PushError(c.upstream.URL, err)
+		return PushError(c.upstream.URL, certificateError(c.sshCertificate, err))
 	}
 	return nil
 }
@@ -173,19 +284,97 @@ func (c *Checkout) HeadRevision(ctx context.Context) (string, error) {
 	return refRevision(ctx, c.dir, "HEAD")
 }
 
+// IsAncestor reports whether the revision `ancestor` is reachable
+// from the revision `descendant`.
+func (c *Checkout) IsAncestor(ctx context.Context, ancestor, descendant string) (bool, error) {
+	return isAncestor(ctx, c.dir, ancestor, descendant)
+}
+
 func (c *Checkout) SyncRevision(ctx context.Context) (string, error) {
-	return refRevision(ctx, c.dir, "tags/"+c.config.SyncTag)
+	return refRevision(ctx, c.dir, c.config.SyncTagRef())
 }
 
 func (c *Checkout) MoveSyncTagAndPush(ctx context.Context, tagAction TagAction) error {
 	if tagAction.SigningKey == "" {
 		tagAction.SigningKey = c.config.SigningKey
 	}
-	return moveTagAndPush(ctx, c.dir, c.config.SyncTag, c.upstream.URL, tagAction)
+	return moveTagAndPush(ctx, c.dir, c.config.SyncTag, c.config.SyncTagRef(), c.upstream.URL, tagAction)
+}
+
+// SyncState gives the revisions the refs kept alongside the sync
+// tag point at, by name (see Config.SyncStateRef).
+func (c *Checkout) SyncState(ctx context.Context) (map[string]string, error) {
+	return refRevisions(ctx, c.dir, c.config.SyncStateRef(""))
+}
+
+// PushSyncState points the refs kept alongside the sync tag at the
+// revisions given, by name, and pushes them; those given an empty
+// revision are deleted.
+func (c *Checkout) PushSyncState(ctx context.Context, revisions map[string]string) error {
+	if len(revisions) == 0 {
+		return nil
+	}
+	refs := map[string]string{}
+	for name, rev := range revisions {
+		refs[c.config.SyncStateRef(name)] = rev
+	}
+	if err := pushRefs(ctx, c.dir, c.upstream.URL, refs); err != nil {
+		return PushError(c.upstream.URL, certificateError(c.sshCertificate, err))
+	}
+	return nil
 }
 
 func (c *Checkout) VerifySyncTag(ctx context.Context) error {
-	return verifyTag(ctx, c.dir, c.config.SyncTag)
+	return verifyTag(ctx, c.dir, c.config.SyncTagRef())
+}
+
+// VerifyRevision checks that the commit at the revision given has a
+// valid signature.
+func (c *Checkout) VerifyRevision(ctx context.Context, rev string) error {
+	return verifyCommit(ctx, c.dir, rev)
+}
+
+// CommitEmails gives the author and committer email addresses of the
+// commit at the revision given.
+func (c *Checkout) CommitEmails(ctx context.Context, rev string) (author, committer string, err error) {
+	return commitEmails(ctx, c.dir, rev)
+}
+
+// VerifyRevisions checks that every commit after `from`, up to and
+// including `to`, has a valid signature; if `from` is empty, only
+// `to` is checked. Checking just the tip isn't enough, since an
+// unsigned commit could be followed by a signed one, so if the clone
+// is shallow it is deepened first; and if that fails,
+// ErrIncompleteHistory is returned rather than checking only the
+// commits to hand.
+func (c *Checkout) VerifyRevisions(ctx context.Context, from, to string) error {
+	if from == "" {
+		return verifyCommit(ctx, c.dir, to)
+	}
+	shallow, err := isShallow(ctx, c.dir)
+	if err != nil {
+		return err
+	}
+	if shallow {
+		if err := unshallow(ctx, c.dir, c.upstream.URL); err != nil {
+			return fmt.Errorf("%s: %s", ErrIncompleteHistory, err)
+		}
+		if shallow, err = isShallow(ctx, c.dir); err != nil {
+			return err
+		} else if shallow {
+			return ErrIncompleteHistory
+		}
+	}
+	revs, err := revList(ctx, c.dir, from+".."+to)
+	if err != nil {
+		return err
+	}
+	for _, rev := range revs {
+		if err := verifyCommit(ctx, c.dir, rev); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // ChangedFiles does a git diff listing changed files
@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
@@ -15,6 +17,7 @@ import (
 	"github.com/weaveworks/flux/api"
 	"github.com/weaveworks/flux/api/v10"
 	"github.com/weaveworks/flux/api/v11"
+	"github.com/weaveworks/flux/api/v12"
 	"github.com/weaveworks/flux/api/v6"
 	fluxerr "github.com/weaveworks/flux/errors"
 	"github.com/weaveworks/flux/event"
@@ -115,6 +118,62 @@ func (c *Client) GitRepoConfig(ctx context.Context, regenerate bool) (v6.GitConf
 	return res, err
 }
 
+func (c *Client) LoopEvents(ctx context.Context, opts v12.LoopEventsOptions) ([]v12.LoopEvent, error) {
+	var res []v12.LoopEvent
+	var since string
+	if !opts.Since.IsZero() {
+		since = opts.Since.Format(time.RFC3339Nano)
+	}
+	err := c.Get(ctx, &res, transport.LoopEvents, "since", since, "types", strings.Join(opts.Types, ","))
+	return res, err
+}
+
+func (c *Client) NamespaceSyncStatus(ctx context.Context) (v12.NamespacesSync, error) {
+	var res v12.NamespacesSync
+	err := c.Get(ctx, &res, transport.NamespaceSyncStatus)
+	return res, err
+}
+
+func (c *Client) MergePreview(ctx context.Context, opts v12.MergePreviewOptions) (v12.MergePreview, error) {
+	var res v12.MergePreview
+	err := c.Get(ctx, &res, transport.MergePreview, "branch", opts.Branch)
+	return res, err
+}
+
+func (c *Client) DaemonConfig(ctx context.Context) (v12.DaemonConfig, error) {
+	var res v12.DaemonConfig
+	err := c.Get(ctx, &res, transport.DaemonConfig)
+	return res, err
+}
+
+func (c *Client) JobHistory(ctx context.Context, opts v12.JobHistoryOptions) ([]job.Record, error) {
+	var res []job.Record
+	var limit string
+	if opts.Limit > 0 {
+		limit = strconv.Itoa(opts.Limit)
+	}
+	err := c.Get(ctx, &res, transport.JobHistory, "limit", limit)
+	return res, err
+}
+
+func (c *Client) AutomationPreview(ctx context.Context, opts v12.AutomationPreviewOptions) (v12.AutomationPreview, error) {
+	var res v12.AutomationPreview
+	err := c.Get(ctx, &res, transport.AutomationPreview, "namespace", opts.Namespace)
+	return res, err
+}
+
+func (c *Client) SyncedResources(ctx context.Context) ([]byte, error) {
+	var res []byte
+	err := c.Get(ctx, &res, transport.SyncedResources)
+	return res, err
+}
+
+func (c *Client) ClusterVersions(ctx context.Context) (v12.ClusterVersions, error) {
+	var res v12.ClusterVersions
+	err := c.Get(ctx, &res, transport.ClusterVersions)
+	return res, err
+}
+
 // --- Request helpers
 
 // post is a simple query-param only post request
@@ -3,7 +3,9 @@ package daemon
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
@@ -14,6 +16,7 @@ import (
 	"github.com/weaveworks/flux/api"
 	"github.com/weaveworks/flux/api/v10"
 	"github.com/weaveworks/flux/api/v11"
+	"github.com/weaveworks/flux/api/v12"
 	transport "github.com/weaveworks/flux/http"
 	"github.com/weaveworks/flux/job"
 	fluxmetrics "github.com/weaveworks/flux/metrics"
@@ -57,6 +60,14 @@ func NewHandler(s api.Server, r *mux.Router) http.Handler {
 	r.Get(transport.SyncStatus).HandlerFunc(handle.SyncStatus)
 	r.Get(transport.Export).HandlerFunc(handle.Export)
 	r.Get(transport.GitRepoConfig).HandlerFunc(handle.GitRepoConfig)
+	r.Get(transport.LoopEvents).HandlerFunc(handle.LoopEvents)
+	r.Get(transport.NamespaceSyncStatus).HandlerFunc(handle.NamespaceSyncStatus)
+	r.Get(transport.MergePreview).HandlerFunc(handle.MergePreview)
+	r.Get(transport.DaemonConfig).HandlerFunc(handle.DaemonConfig)
+	r.Get(transport.JobHistory).HandlerFunc(handle.JobHistory)
+	r.Get(transport.AutomationPreview).HandlerFunc(handle.AutomationPreview)
+	r.Get(transport.SyncedResources).HandlerFunc(handle.SyncedResources)
+	r.Get(transport.ClusterVersions).HandlerFunc(handle.ClusterVersions)
 
 	// These handlers persist to support requests from older fluxctls. In general we
 	// should avoid adding references to them so that they can eventually be removed.
@@ -169,6 +180,102 @@ func (s HTTPServer) ListServicesWithOptions(w http.ResponseWriter, r *http.Reque
 	transport.JSONResponse(w, r, res)
 }
 
+func (s HTTPServer) LoopEvents(w http.ResponseWriter, r *http.Request) {
+	var opts v12.LoopEventsOptions
+	if since := r.URL.Query().Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "parsing since %q", since))
+			return
+		}
+		opts.Since = t
+	}
+	if types := r.URL.Query().Get("types"); types != "" {
+		opts.Types = strings.Split(types, ",")
+	}
+
+	res, err := s.server.LoopEvents(r.Context(), opts)
+	if err != nil {
+		transport.ErrorResponse(w, r, err)
+		return
+	}
+	transport.JSONResponse(w, r, res)
+}
+
+func (s HTTPServer) NamespaceSyncStatus(w http.ResponseWriter, r *http.Request) {
+	res, err := s.server.NamespaceSyncStatus(r.Context())
+	if err != nil {
+		transport.ErrorResponse(w, r, err)
+		return
+	}
+	transport.JSONResponse(w, r, res)
+}
+
+func (s HTTPServer) MergePreview(w http.ResponseWriter, r *http.Request) {
+	opts := v12.MergePreviewOptions{Branch: mux.Vars(r)["branch"]}
+	res, err := s.server.MergePreview(r.Context(), opts)
+	if err != nil {
+		transport.ErrorResponse(w, r, err)
+		return
+	}
+	transport.JSONResponse(w, r, res)
+}
+
+func (s HTTPServer) DaemonConfig(w http.ResponseWriter, r *http.Request) {
+	res, err := s.server.DaemonConfig(r.Context())
+	if err != nil {
+		transport.ErrorResponse(w, r, err)
+		return
+	}
+	transport.JSONResponse(w, r, res)
+}
+
+func (s HTTPServer) JobHistory(w http.ResponseWriter, r *http.Request) {
+	var opts v12.JobHistoryOptions
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			transport.WriteError(w, r, http.StatusBadRequest, errors.Wrapf(err, "parsing limit %q", limit))
+			return
+		}
+		opts.Limit = n
+	}
+	res, err := s.server.JobHistory(r.Context(), opts)
+	if err != nil {
+		transport.ErrorResponse(w, r, err)
+		return
+	}
+	transport.JSONResponse(w, r, res)
+}
+
+func (s HTTPServer) AutomationPreview(w http.ResponseWriter, r *http.Request) {
+	opts := v12.AutomationPreviewOptions{Namespace: r.URL.Query().Get("namespace")}
+	res, err := s.server.AutomationPreview(r.Context(), opts)
+	if err != nil {
+		transport.ErrorResponse(w, r, err)
+		return
+	}
+	transport.JSONResponse(w, r, res)
+}
+
+func (s HTTPServer) SyncedResources(w http.ResponseWriter, r *http.Request) {
+	res, err := s.server.SyncedResources(r.Context())
+	if err != nil {
+		transport.ErrorResponse(w, r, err)
+		return
+	}
+	transport.JSONResponse(w, r, res)
+}
+
+func (s HTTPServer) ClusterVersions(w http.ResponseWriter, r *http.Request) {
+	res, err := s.server.ClusterVersions(r.Context())
+	if err != nil {
+		transport.ErrorResponse(w, r, err)
+		return
+	}
+	transport.JSONResponse(w, r, res)
+}
+
 func (s HTTPServer) Export(w http.ResponseWriter, r *http.Request) {
 	status, err := s.server.Export(r.Context())
 	if err != nil {
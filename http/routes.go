@@ -10,6 +10,14 @@ const (
 	SyncStatus              = "SyncStatus"
 	Export                  = "Export"
 	GitRepoConfig           = "GitRepoConfig"
+	LoopEvents              = "LoopEvents"
+	NamespaceSyncStatus     = "NamespaceSyncStatus"
+	MergePreview            = "MergePreview"
+	DaemonConfig            = "DaemonConfig"
+	JobHistory              = "JobHistory"
+	AutomationPreview       = "AutomationPreview"
+	SyncedResources         = "SyncedResources"
+	ClusterVersions         = "ClusterVersions"
 
 	UpdateImages           = "UpdateImages"
 	UpdatePolicies         = "UpdatePolicies"
@@ -24,5 +32,6 @@ var (
 	RegisterDaemonV9  = "RegisterDaemonV9"
 	RegisterDaemonV10 = "RegisterDaemonV10"
 	RegisterDaemonV11 = "RegisterDaemonV11"
+	RegisterDaemonV12 = "RegisterDaemonV12"
 	LogEvent          = "LogEvent"
 )
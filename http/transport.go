@@ -39,6 +39,14 @@ func NewAPIRouter() *mux.Router {
 	r.NewRoute().Name(SyncStatus).Methods("GET").Path("/v6/sync").Queries("ref", "{ref}")
 	r.NewRoute().Name(Export).Methods("HEAD", "GET").Path("/v6/export")
 	r.NewRoute().Name(GitRepoConfig).Methods("POST").Path("/v9/git-repo-config")
+	r.NewRoute().Name(LoopEvents).Methods("GET").Path("/v12/loop-events")
+	r.NewRoute().Name(NamespaceSyncStatus).Methods("GET").Path("/v12/sync-namespaces")
+	r.NewRoute().Name(MergePreview).Methods("GET").Path("/v12/merge-preview").Queries("branch", "{branch}")
+	r.NewRoute().Name(DaemonConfig).Methods("GET").Path("/v12/config")
+	r.NewRoute().Name(JobHistory).Methods("GET").Path("/v12/jobs/history")
+	r.NewRoute().Name(AutomationPreview).Methods("GET").Path("/v12/automation-preview")
+	r.NewRoute().Name(SyncedResources).Methods("GET").Path("/v12/synced-resources")
+	r.NewRoute().Name(ClusterVersions).Methods("GET").Path("/v12/cluster-versions")
 
 	// These routes persist to support requests from older fluxctls. In general we
 	// should avoid adding references to them so that they can eventually be removed.
@@ -57,6 +65,7 @@ func UpstreamRoutes(r *mux.Router) {
 	r.NewRoute().Name(RegisterDaemonV9).Methods("GET").Path("/v9/daemon")
 	r.NewRoute().Name(RegisterDaemonV10).Methods("GET").Path("/v10/daemon")
 	r.NewRoute().Name(RegisterDaemonV11).Methods("GET").Path("/v11/daemon")
+	r.NewRoute().Name(RegisterDaemonV12).Methods("GET").Path("/v12/daemon")
 	r.NewRoute().Name(LogEvent).Methods("POST").Path("/v6/events")
 }
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -109,9 +110,14 @@ func (i Name) ToRef(tag string) Ref {
 //  * library/alpine:3.5
 //  * quay.io/weaveworks/flux:1.1.0
 //  * localhost:5000/arbitrary/path/to/repo:revision-sha1
+//  * quay.io/weaveworks/flux:1.1.0@sha256:2f6d...
 type Ref struct {
 	Name
 	Tag string
+	// If not empty, the digest the image is pinned to (e.g.,
+	// "sha256:2f6d..."), which takes precedence over the tag when
+	// pulling
+	Digest string
 }
 
 // CanonicalRef is an image ref with none of the fields left to be
@@ -122,11 +128,14 @@ type CanonicalRef struct {
 
 // String returns the Ref as a string (i.e., unparsed) without canonicalising it.
 func (i Ref) String() string {
-	var tag string
+	var tag, digest string
 	if i.Tag != "" {
 		tag = ":" + i.Tag
 	}
-	return fmt.Sprintf("%s%s", i.Name.String(), tag)
+	if i.Digest != "" {
+		digest = "@" + i.Digest
+	}
+	return fmt.Sprintf("%s%s%s", i.Name.String(), tag, digest)
 }
 
 // ParseRef parses a string representation of an image id into an
@@ -141,6 +150,13 @@ func ParseRef(s string) (Ref, error) {
 	if strings.HasPrefix(s, "/") || strings.HasSuffix(s, "/") {
 		return id, errors.Wrapf(ErrMalformedImageID, "parsing %q", s)
 	}
+	if at := strings.LastIndex(s, "@"); at >= 0 {
+		if !digestRegexp.MatchString(s[at+1:]) {
+			return id, errors.Wrapf(ErrMalformedImageID, "parsing digest in %q", s)
+		}
+		id.Digest = s[at+1:]
+		s = s[:at]
+	}
 
 	elements := strings.Split(s, "/")
 	switch len(elements) {
@@ -182,6 +198,7 @@ var (
 	domainComponent = `([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])`
 	domain          = fmt.Sprintf(`localhost|(%s([.]%s)+)(:[0-9]+)?`, domainComponent, domainComponent)
 	domainRegexp    = regexp.MustCompile(domain)
+	digestRegexp    = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-fA-F0-9]{32,}$`)
 )
 
 // ImageID is serialized/deserialized as a string
@@ -205,8 +222,9 @@ func (i Ref) CanonicalRef() CanonicalRef {
 	name := i.CanonicalName()
 	return CanonicalRef{
 		Ref: Ref{
-			Name: name.Name,
-			Tag:  i.Tag,
+			Name:   name.Name,
+			Tag:    i.Tag,
+			Digest: i.Digest,
 		},
 	}
 }
@@ -215,14 +233,30 @@ func (i Ref) Components() (domain, repo, tag string) {
 	return i.Domain, i.Image, i.Tag
 }
 
-// WithNewTag makes a new copy of an ImageID with a new tag
+// WithNewTag makes a new copy of an ImageID with a new tag. Any
+// digest is dropped, since it belongs to the old tag.
 func (i Ref) WithNewTag(t string) Ref {
 	var img Ref
 	img = i
 	img.Tag = t
+	img.Digest = ""
+	return img
+}
+
+// WithDigest makes a new copy of an ImageID pinned to the digest
+// given (or not pinned, if the digest is empty).
+func (i Ref) WithDigest(d string) Ref {
+	img := i
+	img.Digest = d
 	return img
 }
 
+// WithoutDigest makes a new copy of an ImageID without any digest,
+// e.g., for comparing with the tagged images in a registry.
+func (i Ref) WithoutDigest() Ref {
+	return i.WithDigest("")
+}
+
 // Info has the metadata we are able to determine about an image ref,
 // from its registry.
 type Info struct {
@@ -306,6 +340,50 @@ func NewerByCreated(lhs, rhs *Info) bool {
 // NewerBySemver returns true if lhs image should be sorted
 // before rhs with regard to their semver order descending.
 func NewerBySemver(lhs, rhs *Info) bool {
+	return newerBySemver(lhs, rhs, "")
+}
+
+// NewerBySemverBuild is like NewerBySemver, but when two versions
+// have the same precedence, it uses their build metadata (e.g.,
+// `1.2.3+build.45`) to break the tie. This is not standard semver,
+// which says build metadata must be ignored when determining
+// precedence. Build metadata is compared identifier by identifier,
+// with numeric identifiers compared numerically, and other
+// identifiers compared lexically; a numeric identifier is considered
+// older than a non-numeric identifier, and a shorter list of
+// identifiers older than a longer one that it's a prefix of (the
+// same rules semver uses for pre-release versions).
+func NewerBySemverBuild(lhs, rhs *Info) bool {
+	return newerBySemver(lhs, rhs, BuildOrderNumeric)
+}
+
+// BuildOrder says how build metadata is ordered, when it's used to
+// break a tie between versions; see NewerBySemverBuild.
+type BuildOrder string
+
+const (
+	// Numeric identifiers are compared numerically, and others
+	// lexically, so `build.45` is newer than `build.5`
+	BuildOrderNumeric BuildOrder = "numeric"
+	// All identifiers are compared lexically, so `build.5` is newer
+	// than `build.45`; e.g., for zero-padded numbers, or
+	// identifiers like commit hashes that may happen to be all
+	// digits
+	BuildOrderLexical BuildOrder = "lexical"
+)
+
+// BuildOrders are the ways build metadata can be ordered.
+var BuildOrders = []BuildOrder{BuildOrderNumeric, BuildOrderLexical}
+
+// NewerBySemverBuildOrder is like NewerBySemverBuild, but orders build
+// metadata as given.
+func NewerBySemverBuildOrder(order BuildOrder) func(lhs, rhs *Info) bool {
+	return func(lhs, rhs *Info) bool {
+		return newerBySemver(lhs, rhs, order)
+	}
+}
+
+func newerBySemver(lhs, rhs *Info, buildOrder BuildOrder) bool {
 	lv, lerr := semver.NewVersion(lhs.ID.Tag)
 	rv, rerr := semver.NewVersion(rhs.ID.Tag)
 	if (lerr != nil && rerr != nil) || (lv == rv) {
@@ -318,6 +396,9 @@ func NewerBySemver(lhs, rhs *Info) bool {
 		return true
 	}
 	cmp := lv.Compare(rv)
+	if cmp == 0 && buildOrder != "" {
+		cmp = compareBuildMetadata(lv.Metadata(), rv.Metadata(), buildOrder)
+	}
 	// In semver, `1.10` and `1.10.0` is the same but in favor of explicitness
 	// we should consider the latter newer.
 	if cmp == 0 {
@@ -326,6 +407,55 @@ func NewerBySemver(lhs, rhs *Info) bool {
 	return cmp > 0
 }
 
+// compareBuildMetadata compares two (dot-separated) build metadata
+// strings in the order given, returning -1, 0 or 1 for lhs being
+// older than, equal to, or newer than rhs respectively.
+func compareBuildMetadata(lhs, rhs string, order BuildOrder) int {
+	if lhs == rhs {
+		return 0
+	}
+	// No build metadata is older than any build metadata
+	if lhs == "" {
+		return -1
+	}
+	if rhs == "" {
+		return 1
+	}
+	lparts, rparts := strings.Split(lhs, "."), strings.Split(rhs, ".")
+	for i := 0; i < len(lparts) && i < len(rparts); i++ {
+		l, r := lparts[i], rparts[i]
+		ln, lerr := strconv.ParseUint(l, 10, 64)
+		rn, rerr := strconv.ParseUint(r, 10, 64)
+		lnum := order == BuildOrderNumeric && lerr == nil
+		rnum := order == BuildOrderNumeric && rerr == nil
+		switch {
+		case lnum && rnum:
+			if ln != rn {
+				if ln < rn {
+					return -1
+				}
+				return 1
+			}
+		case lnum:
+			return -1
+		case rnum:
+			return 1
+		case l != r:
+			if l < r {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(lparts) < len(rparts):
+		return -1
+	case len(lparts) > len(rparts):
+		return 1
+	}
+	return 0
+}
+
 // Sort orders the given image infos according to `newer` func.
 func Sort(infos []Info, newer func(a, b *Info) bool) {
 	if newer == nil {
@@ -52,6 +52,8 @@ func TestParseRef(t *testing.T) {
 		{"quay.io/library/alpine:latest", "quay.io", "library/alpine", "quay.io/library/alpine:latest"},
 		{"quay.io/library/alpine:mytag", "quay.io", "library/alpine", "quay.io/library/alpine:mytag"},
 		{"localhost:5000/path/to/repo/alpine:mytag", "localhost:5000", "path/to/repo/alpine", "localhost:5000/path/to/repo/alpine:mytag"},
+		// A digest can follow the tag
+		{"alpine:mytag@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", dockerHubHost, "library/alpine", "index.docker.io/library/alpine:mytag@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
 	} {
 		i, err := ParseRef(x.test)
 		if err != nil {
@@ -80,6 +82,7 @@ func TestParseRefErrorCases(t *testing.T) {
 		{":tag"},
 		{"/leading/slash"},
 		{"trailing/slash/"},
+		{"alpine:mytag@notadigest"},
 	} {
 		_, err := ParseRef(x.test)
 		if err == nil {
@@ -233,6 +236,47 @@ func TestImage_OrderBySemverTagDesc(t *testing.T) {
 	assert.Equal(t, tags(expected), tags(imgs))
 }
 
+func TestImage_OrderBySemverBuildTagDesc(t *testing.T) {
+	ti := time.Time{}
+	aa := mustMakeInfo("my/image:1.2.3+build.45", ti)
+	bb := mustMakeInfo("my/image:1.2.3+build.5", ti)
+	cc := mustMakeInfo("my/image:1.2.3", ti)
+	dd := mustMakeInfo("my/image:1.2.4", ti)
+	ee := mustMakeInfo("my/image:1.2.3+build.45.1", ti)
+	ff := mustMakeInfo("my/image:1.2.3+build.rc", ti)
+
+	imgs := []Info{aa, bb, cc, dd, ee, ff}
+	Sort(imgs, NewerBySemverBuild)
+
+	expected := []Info{dd, ff, ee, aa, bb, cc}
+	assert.Equal(t, tags(expected), tags(imgs))
+
+	// stable?
+	reverse(imgs)
+	Sort(imgs, NewerBySemverBuild)
+	assert.Equal(t, tags(expected), tags(imgs))
+}
+
+func TestImage_OrderBySemverBuildLexicalTagDesc(t *testing.T) {
+	ti := time.Time{}
+	aa := mustMakeInfo("my/image:1.2.3+build.45", ti)
+	bb := mustMakeInfo("my/image:1.2.3+build.5", ti)
+	cc := mustMakeInfo("my/image:1.2.3", ti)
+	dd := mustMakeInfo("my/image:1.2.3+build.rc", ti)
+
+	imgs := []Info{aa, bb, cc, dd}
+	newer := NewerBySemverBuildOrder(BuildOrderLexical)
+	Sort(imgs, newer)
+
+	// "5" sorts after "45", and "rc" after both
+	expected := []Info{dd, bb, aa, cc}
+	assert.Equal(t, tags(expected), tags(imgs))
+
+	// numeric ordering is the same as NewerBySemverBuild
+	Sort(imgs, NewerBySemverBuildOrder(BuildOrderNumeric))
+	assert.Equal(t, tags([]Info{dd, aa, bb, cc}), tags(imgs))
+}
+
 func tags(imgs []Info) []string {
 	var vs []string
 	for _, i := range imgs {
@@ -89,6 +89,9 @@ type Config struct {
 	LogDiffs   bool
 	UpdateDeps bool
 	GitTimeout time.Duration
+	// How many git mirrors to refresh at once; if zero, there's no
+	// limit
+	GitConcurrency int
 }
 
 func (c Config) WithDefaults() Config {
@@ -129,7 +132,7 @@ func New(logger log.Logger, polling Polling, clients Clients, release *release.R
 		ifClient:   clients.IfClient,
 		release:    release,
 		config:     config.WithDefaults(),
-		mirrors:    git.NewMirrors(),
+		mirrors:    git.NewMirrors(config.GitConcurrency),
 		clones:     make(map[string]clone),
 		namespace:  namespace,
 	}
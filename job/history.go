@@ -0,0 +1,184 @@
+package job
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Record is what's kept in the job history about a job: what it was,
+// who asked for it, and how it went.
+type Record struct {
+	ID ID
+	// The type of update the job was for (e.g., "image", "policy",
+	// "sync")
+	Type string
+	// The user given as the cause of the update, if any
+	User     string
+	Status   StatusString
+	Err      string `json:",omitempty"`
+	Revision string `json:",omitempty"`
+	Queued   time.Time
+	Started  time.Time
+	Finished time.Time
+	// How long the job took to run, once finished; not counting the
+	// time it was queued
+	Duration time.Duration `json:",omitempty"`
+}
+
+// StatusAbandoned is the status in the history of a job that was
+// queued or running when the daemon stopped, so will never finish.
+const StatusAbandoned StatusString = "abandoned"
+
+// finished says whether the job recorded has finished, one way or
+// another.
+func (r Record) finished() bool {
+	switch r.Status {
+	case StatusSucceeded, StatusFailed, StatusAbandoned:
+		return true
+	}
+	return false
+}
+
+// History keeps records of the most recent jobs, so that it's
+// possible to find out what a job did after the fact. It's like the
+// StatusCache, but for people rather than for polling clients.
+type History struct {
+	// Size is the number of records to keep; when full, the oldest
+	// records of finished jobs are dropped to make room. The records
+	// of jobs yet to finish are kept, even if that means keeping
+	// more than Size.
+	Size int
+	// If not empty, the records are written to this file whenever
+	// they change, and read back with Load, so they outlive a restart
+	File string
+
+	mu      sync.Mutex
+	records []Record
+}
+
+// Load reads the records kept in the history's file, if it has one
+// and it exists. Since the jobs of this history are gone with the
+// daemon that ran them, those that were queued or running are marked
+// as abandoned.
+func (h *History) Load() error {
+	if h.File == "" {
+		return nil
+	}
+	bytes, err := ioutil.ReadFile(h.File)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var records []Record
+	if err := json.Unmarshal(bytes, &records); err != nil {
+		return err
+	}
+	var abandoned bool
+	for i := range records {
+		if !records[i].finished() {
+			records[i].Status = StatusAbandoned
+			records[i].Err = "the daemon stopped before the job finished"
+			abandoned = true
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = records
+	h.trim()
+	if abandoned {
+		return h.save()
+	}
+	return nil
+}
+
+// Update changes the record of the job with the ID given, adding one
+// if there isn't one yet, and saves the history if it has a file.
+func (h *History) Update(id ID, change func(*Record)) error {
+	if h.Size <= 0 {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := h.index(id)
+	if i < 0 {
+		h.records = append(h.records, Record{ID: id})
+		i = len(h.records) - 1
+	}
+	change(&h.records[i])
+	h.trim()
+	return h.save()
+}
+
+// Records gives the records kept, most recent first; at most limit
+// of them, if that's more than zero.
+func (h *History) Records(limit int) []Record {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := len(h.records)
+	if limit > 0 && limit < n {
+		n = limit
+	}
+	records := make([]Record, 0, n)
+	for i := len(h.records) - 1; i >= 0 && len(records) < n; i-- {
+		records = append(records, h.records[i])
+	}
+	return records
+}
+
+func (h *History) index(id ID) int {
+	for i := range h.records {
+		if h.records[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// trim drops the oldest records of finished jobs, until there are
+// no more than Size records or no more records of finished jobs.
+func (h *History) trim() {
+	excess := len(h.records) - h.Size
+	if h.Size <= 0 || excess <= 0 {
+		return
+	}
+	kept := make([]Record, 0, len(h.records)-excess)
+	for _, r := range h.records {
+		if excess > 0 && r.finished() {
+			excess--
+			continue
+		}
+		kept = append(kept, r)
+	}
+	h.records = kept
+}
+
+// save writes the records to the file, if there is one, via a
+// temporary file so that a reader never sees it half written.
+func (h *History) save() error {
+	if h.File == "" {
+		return nil
+	}
+	bytes, err := json.Marshal(h.records)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(h.File), ".job-history")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bytes); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), h.File)
+}
@@ -0,0 +1,83 @@
+package job
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	h := &History{Size: 2}
+	for _, id := range []ID{"one", "two", "three"} {
+		assert.NoError(t, h.Update(id, func(r *Record) { r.Status = StatusQueued }))
+		assert.NoError(t, h.Update(id, func(r *Record) { r.Status = StatusSucceeded }))
+	}
+
+	records := h.Records(0)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, ID("three"), records[0].ID)
+		assert.Equal(t, StatusSucceeded, records[0].Status)
+		assert.Equal(t, ID("two"), records[1].ID)
+	}
+	assert.Len(t, h.Records(1), 1)
+}
+
+func TestHistoryKeepsUnfinished(t *testing.T) {
+	h := &History{Size: 2}
+	assert.NoError(t, h.Update("running", func(r *Record) { r.Type, r.Status = "image", StatusRunning }))
+	for _, id := range []ID{"one", "two", "three"} {
+		assert.NoError(t, h.Update(id, func(r *Record) { r.Status = StatusSucceeded }))
+	}
+
+	// The running job is kept, however many have finished since
+	records := h.Records(0)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, ID("three"), records[0].ID)
+		assert.Equal(t, ID("running"), records[1].ID)
+	}
+	assert.NoError(t, h.Update("running", func(r *Record) { r.Status = StatusSucceeded }))
+	records = h.Records(0)
+	assert.Equal(t, "image", records[1].Type)
+
+	// Unfinished jobs are kept even when they are more than Size
+	for _, id := range []ID{"four", "five", "six"} {
+		assert.NoError(t, h.Update(id, func(r *Record) { r.Status = StatusQueued }))
+	}
+	assert.Len(t, h.Records(0), 3)
+}
+
+func TestHistoryFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flux-job-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "history.json")
+
+	h := &History{Size: 10, File: file}
+	assert.NoError(t, h.Load())
+	assert.NoError(t, h.Update("one", func(r *Record) { r.Type, r.User, r.Status = "image", "alice", StatusSucceeded }))
+
+	reloaded := &History{Size: 10, File: file}
+	assert.NoError(t, reloaded.Load())
+	assert.Equal(t, h.Records(0), reloaded.Records(0))
+
+	// Jobs queued or running when the daemon stopped are abandoned
+	// once it starts again, and that's saved
+	assert.NoError(t, h.Update("two", func(r *Record) { r.Status = StatusRunning }))
+	assert.NoError(t, h.Update("three", func(r *Record) { r.Status = StatusSucceeded }))
+	reloaded = &History{Size: 10, File: file}
+	assert.NoError(t, reloaded.Load())
+	records := reloaded.Records(0)
+	if assert.Len(t, records, 3) {
+		assert.Equal(t, StatusSucceeded, records[0].Status)
+		assert.Equal(t, StatusAbandoned, records[1].Status)
+		assert.NotEmpty(t, records[1].Err)
+	}
+	again := &History{Size: 10, File: file}
+	assert.NoError(t, again.Load())
+	assert.Equal(t, records, again.Records(0))
+}
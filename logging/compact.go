@@ -0,0 +1,121 @@
+package logging
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// These keys are left out when deciding whether two log lines are the
+// same, since they will differ from one line to the next.
+var ignoredKeys = map[string]bool{
+	"ts":     true,
+	"caller": true,
+}
+
+// These keys mark a line as something other than informational; such
+// lines are never compacted.
+var uncompactedKeys = map[string]bool{
+	"err":     true,
+	"error":   true,
+	"warning": true,
+}
+
+// CompactingLogger collapses identical consecutive informational log
+// lines. The first occurrence of a line is logged as it is; the
+// repeats that follow are held back, and logged as a single line with
+// the count of repeats in the key "repeated". That line is logged
+// once MaxRepeats repeats have been held back, once Interval has
+// passed since the line was last logged, or when a different line
+// comes along, whichever is first.
+type CompactingLogger struct {
+	next       log.Logger
+	maxRepeats int
+	interval   time.Duration
+	now        func() time.Time
+
+	mu         sync.Mutex
+	last       string
+	lastLine   []interface{}
+	lastLogged time.Time
+	repeated   int
+}
+
+// NewCompactingLogger wraps the logger given so that repeated lines
+// are compacted. It should wrap the logger that writes the lines,
+// before timestamps and the like are added. A maxRepeats or interval
+// of zero means that threshold is not used.
+func NewCompactingLogger(next log.Logger, maxRepeats int, interval time.Duration) *CompactingLogger {
+	return &CompactingLogger{
+		next:       next,
+		maxRepeats: maxRepeats,
+		interval:   interval,
+		now:        time.Now,
+	}
+}
+
+func (l *CompactingLogger) Log(keyvals ...interface{}) error {
+	key, ok := compactionKey(keyvals)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+
+	if ok && l.lastLine != nil && key == l.last {
+		l.repeated++
+		l.lastLine = append([]interface{}{}, keyvals...)
+		if (l.maxRepeats > 0 && l.repeated >= l.maxRepeats) ||
+			(l.interval > 0 && now.Sub(l.lastLogged) >= l.interval) {
+			return l.flush(now)
+		}
+		return nil
+	}
+
+	var err error
+	if l.repeated > 0 {
+		err = l.flush(now)
+	}
+	if ok {
+		l.last = key
+		l.lastLine = append([]interface{}{}, keyvals...)
+	} else {
+		l.last, l.lastLine = "", nil
+	}
+	l.lastLogged = now
+	if nextErr := l.next.Log(keyvals...); nextErr != nil {
+		err = nextErr
+	}
+	return err
+}
+
+// flush logs the last line with the count of repeats held back. It
+// must be called with the lock held.
+func (l *CompactingLogger) flush(now time.Time) error {
+	line := append(l.lastLine, "repeated", l.repeated)
+	l.repeated = 0
+	l.lastLogged = now
+	return l.next.Log(line...)
+}
+
+// compactionKey gives a string identifying the log line, and whether
+// it may be compacted at all.
+func compactionKey(keyvals []interface{}) (string, bool) {
+	var key string
+	for i := 0; i < len(keyvals); i += 2 {
+		k := fmt.Sprint(keyvals[i])
+		if uncompactedKeys[k] {
+			return "", false
+		}
+		if ignoredKeys[k] {
+			continue
+		}
+		var v interface{}
+		if i+1 < len(keyvals) {
+			v = keyvals[i+1]
+		}
+		key += fmt.Sprintf("%q=%q ", k, fmt.Sprint(v))
+	}
+	return key, true
+}
@@ -0,0 +1,69 @@
+package logging
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (r *recordingLogger) Log(keyvals ...interface{}) error {
+	r.lines = append(r.lines, fmt.Sprint(keyvals...))
+	return nil
+}
+
+func TestCompactingLogger(t *testing.T) {
+	rec := &recordingLogger{}
+	logger := NewCompactingLogger(rec, 3, 0)
+
+	logger.Log("ts", 1, "event", "refreshed", "HEAD", "abc")
+	logger.Log("ts", 2, "event", "refreshed", "HEAD", "abc")
+	logger.Log("ts", 3, "event", "refreshed", "HEAD", "abc")
+	logger.Log("ts", 4, "event", "refreshed", "HEAD", "abc")
+	logger.Log("ts", 5, "event", "refreshed", "HEAD", "abc")
+	logger.Log("ts", 6, "event", "refreshed", "HEAD", "def")
+	logger.Log("ts", 7, "err", "oops")
+	logger.Log("ts", 8, "err", "oops")
+
+	expected := []string{
+		fmt.Sprint("ts", 1, "event", "refreshed", "HEAD", "abc"),
+		fmt.Sprint("ts", 4, "event", "refreshed", "HEAD", "abc", "repeated", 3),
+		fmt.Sprint("ts", 5, "event", "refreshed", "HEAD", "abc", "repeated", 1),
+		fmt.Sprint("ts", 6, "event", "refreshed", "HEAD", "def"),
+		fmt.Sprint("ts", 7, "err", "oops"),
+		fmt.Sprint("ts", 8, "err", "oops"),
+	}
+	if len(rec.lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %d:\n%v", len(expected), len(rec.lines), rec.lines)
+	}
+	for i := range expected {
+		if rec.lines[i] != expected[i] {
+			t.Errorf("line %d: expected %q, got %q", i, expected[i], rec.lines[i])
+		}
+	}
+}
+
+func TestCompactingLoggerInterval(t *testing.T) {
+	rec := &recordingLogger{}
+	logger := NewCompactingLogger(rec, 0, time.Minute)
+	now := time.Now()
+	logger.now = func() time.Time { return now }
+
+	logger.Log("event", "refreshed")
+	for i := 0; i < 10; i++ {
+		now = now.Add(10 * time.Second)
+		logger.Log("event", "refreshed")
+	}
+
+	// The first line, then the line with the repeats after a minute,
+	// then another four repeats held back.
+	if len(rec.lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d:\n%v", len(rec.lines), rec.lines)
+	}
+	if expected := fmt.Sprint("event", "refreshed", "repeated", 6); rec.lines[1] != expected {
+		t.Errorf("expected %q, got %q", expected, rec.lines[1])
+	}
+}
@@ -8,6 +8,8 @@ const (
 	LabelRoute   = "route"
 	LabelMethod  = "method"
 	LabelSuccess = "success"
+	LabelTrigger = "trigger"
+	LabelRepo    = "repo"
 
 	// Labels for release metrics
 	LabelAction      = "action"
@@ -0,0 +1,46 @@
+package metricstest
+
+import (
+	"testing"
+
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Value gives the value of the counter or gauge of the name given
+// that has (at least) the labels given, or zero if there's no such
+// metric (yet). It looks in the default registry, which is where the
+// metrics of the packages under test are registered.
+func Value(t *testing.T, name string, labels map[string]string) float64 {
+	families, err := stdprometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			if !hasLabels(m, labels) {
+				continue
+			}
+			switch {
+			case m.Counter != nil:
+				return m.Counter.GetValue()
+			case m.Gauge != nil:
+				return m.Gauge.GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func hasLabels(m *dto.Metric, labels map[string]string) bool {
+	var matched int
+	for _, pair := range m.GetLabel() {
+		if v, ok := labels[pair.GetName()]; ok && v == pair.GetValue() {
+			matched++
+		}
+	}
+	return matched == len(labels)
+}
@@ -4,14 +4,19 @@ import (
 	"github.com/Masterminds/semver"
 	"github.com/ryanuber/go-glob"
 	"github.com/weaveworks/flux/image"
-	"strings"
 	"regexp"
+	"strings"
 )
 
 const (
 	globPrefix   = "glob:"
 	semverPrefix = "semver:"
-	regexpPrefix = "regexp:"
+	// Like semver, but also using build metadata to order versions
+	// (which is non-standard); the ordering of build metadata can be
+	// given, as in `semver-build-lexical:`
+	semverBuildPrefix      = "semver-build:"
+	semverBuildOrderPrefix = "semver-build-"
+	regexpPrefix           = "regexp:"
 )
 
 var (
@@ -39,23 +44,43 @@ type GlobPattern string
 type SemverPattern struct {
 	pattern     string // pattern without prefix
 	constraints *semver.Constraints
+	// if not empty, versions with the same precedence are ordered
+	// by their build metadata, in this order
+	buildOrder image.BuildOrder
 }
 
 // RegexpPattern matches by regular expression.
 type RegexpPattern struct {
-	pattern	string // pattern without prefix
-	regexp	*regexp.Regexp
+	pattern string // pattern without prefix
+	regexp  *regexp.Regexp
+}
+
+// ExcludingPattern matches the tags its pattern matches, except
+// those matching any of the exclusions; i.e., exclusions take
+// precedence.
+type ExcludingPattern struct {
+	Pattern
+	Excludes []Pattern
 }
 
 // NewPattern instantiates a Pattern according to the prefix
 // it finds. The prefix can be either `glob:` (default if omitted),
-// `semver:` or `regexp:`.
+// `semver:`, `semver-build:` (or `semver-build-<order>:`, with an
+// order from image.BuildOrders) or `regexp:`.
 func NewPattern(pattern string) Pattern {
 	switch {
 	case strings.HasPrefix(pattern, semverPrefix):
 		pattern = strings.TrimPrefix(pattern, semverPrefix)
 		c, _ := semver.NewConstraint(pattern)
-		return SemverPattern{pattern, c}
+		return SemverPattern{pattern, c, ""}
+	case strings.HasPrefix(pattern, semverBuildPrefix):
+		pattern = strings.TrimPrefix(pattern, semverBuildPrefix)
+		c, _ := semver.NewConstraint(pattern)
+		return SemverPattern{pattern, c, image.BuildOrderNumeric}
+	case strings.HasPrefix(pattern, semverBuildOrderPrefix) && strings.Contains(pattern, ":"):
+		parts := strings.SplitN(strings.TrimPrefix(pattern, semverBuildOrderPrefix), ":", 2)
+		c, _ := semver.NewConstraint(parts[1])
+		return SemverPattern{parts[1], c, image.BuildOrder(parts[0])}
 	case strings.HasPrefix(pattern, regexpPrefix):
 		pattern = strings.TrimPrefix(pattern, regexpPrefix)
 		r, _ := regexp.Compile(pattern)
@@ -65,6 +90,16 @@ func NewPattern(pattern string) Pattern {
 	}
 }
 
+// NewPatterns instantiates the patterns in a list separated by
+// whitespace, as used for excluding tags.
+func NewPatterns(patterns string) []Pattern {
+	var ps []Pattern
+	for _, p := range strings.Fields(patterns) {
+		ps = append(ps, NewPattern(p))
+	}
+	return ps
+}
+
 func (g GlobPattern) Matches(tag string) bool {
 	return glob.Glob(string(g), tag)
 }
@@ -94,15 +129,30 @@ func (s SemverPattern) Matches(tag string) bool {
 }
 
 func (s SemverPattern) String() string {
-	return semverPrefix + s.pattern
+	switch s.buildOrder {
+	case "":
+		return semverPrefix + s.pattern
+	case image.BuildOrderNumeric:
+		return semverBuildPrefix + s.pattern
+	default:
+		return semverBuildOrderPrefix + string(s.buildOrder) + ":" + s.pattern
+	}
 }
 
 func (s SemverPattern) Newer(a, b *image.Info) bool {
+	if s.buildOrder != "" {
+		return image.NewerBySemverBuildOrder(s.buildOrder)(a, b)
+	}
 	return image.NewerBySemver(a, b)
 }
 
 func (s SemverPattern) Valid() bool {
-	return s.constraints != nil
+	for _, order := range image.BuildOrders {
+		if s.buildOrder == order {
+			return s.constraints != nil
+		}
+	}
+	return s.buildOrder == "" && s.constraints != nil
 }
 
 func (r RegexpPattern) Matches(tag string) bool {
@@ -124,3 +174,29 @@ func (r RegexpPattern) Newer(a, b *image.Info) bool {
 func (r RegexpPattern) Valid() bool {
 	return r.regexp != nil
 }
+
+func (e ExcludingPattern) Matches(tag string) bool {
+	for _, exclude := range e.Excludes {
+		if exclude.Matches(tag) {
+			return false
+		}
+	}
+	return e.Pattern.Matches(tag)
+}
+
+func (e ExcludingPattern) String() string {
+	excludes := make([]string, len(e.Excludes))
+	for i, exclude := range e.Excludes {
+		excludes[i] = exclude.String()
+	}
+	return e.Pattern.String() + " excluding " + strings.Join(excludes, " ")
+}
+
+func (e ExcludingPattern) Valid() bool {
+	for _, exclude := range e.Excludes {
+		if !exclude.Valid() {
+			return false
+		}
+	}
+	return e.Pattern.Valid()
+}
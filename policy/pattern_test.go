@@ -71,6 +71,18 @@ func TestSemverPattern_Matches(t *testing.T) {
 			true:    []string{"2.0.1-alpha.1"},
 			false:   []string{"2.0.1"},
 		},
+		{
+			name:    "semver with build metadata",
+			pattern: "semver-build:~1.2",
+			true:    []string{"1.2.3", "1.2.3+build.45"},
+			false:   []string{"", "latest", "2.0.0+build.1"},
+		},
+		{
+			name:    "semver with lexically ordered build metadata",
+			pattern: "semver-build-lexical:~1.2",
+			true:    []string{"1.2.3", "1.2.3+20190102.abc"},
+			false:   []string{"", "latest", "2.0.0+20190102.abc"},
+		},
 	} {
 		pattern := NewPattern(tt.pattern)
 		assert.IsType(t, SemverPattern{}, pattern)
@@ -87,6 +99,25 @@ func TestSemverPattern_Matches(t *testing.T) {
 	}
 }
 
+func TestSemverPattern_BuildOrder(t *testing.T) {
+	for _, tt := range []struct {
+		pattern string
+		valid   bool
+		str     string
+	}{
+		{"semver:~1", true, "semver:~1"},
+		{"semver-build:~1", true, "semver-build:~1"},
+		{"semver-build-numeric:~1", true, "semver-build:~1"},
+		{"semver-build-lexical:~1", true, "semver-build-lexical:~1"},
+		{"semver-build-backwards:~1", false, "semver-build-backwards:~1"},
+	} {
+		pattern := NewPattern(tt.pattern)
+		assert.IsType(t, SemverPattern{}, pattern)
+		assert.Equal(t, tt.valid, pattern.Valid(), tt.pattern)
+		assert.Equal(t, tt.str, pattern.String(), tt.pattern)
+	}
+}
+
 func TestRegexpPattern_Matches(t *testing.T) {
 	for _, tt := range []struct {
 		name    string
@@ -121,3 +152,23 @@ func TestRegexpPattern_Matches(t *testing.T) {
 		}
 	}
 }
+
+func TestExcludingPattern_Matches(t *testing.T) {
+	pattern := ExcludingPattern{
+		Pattern:  NewPattern("glob:*"),
+		Excludes: NewPatterns("latest  nightly-* regexp:^pr-[0-9]+$"),
+	}
+	assert.Len(t, pattern.Excludes, 3)
+	for _, tag := range []string{"1.0.0", "master-a000001", "pr-branch"} {
+		assert.True(t, pattern.Matches(tag), tag)
+	}
+	for _, tag := range []string{"latest", "nightly-20190101", "pr-123"} {
+		assert.False(t, pattern.Matches(tag), tag)
+	}
+
+	// Exclusions take precedence over the tag filter
+	pattern.Pattern = NewPattern("semver:*")
+	pattern.Excludes = NewPatterns("semver:~1.2")
+	assert.True(t, pattern.Matches("1.3.0"))
+	assert.False(t, pattern.Matches("1.2.1"))
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"strings"
 
+	"github.com/ryanuber/go-glob"
+
 	"github.com/weaveworks/flux"
 )
 
@@ -14,6 +16,17 @@ const (
 	LockedMsg  = Policy("locked_msg")
 	Automated  = Policy("automated")
 	TagAll     = Policy("tag_all")
+	PinDigest  = Policy("pin_digest")
+	ConfigHash = Policy("config_hash")
+	// When automated updates may be made; see Schedule
+	AutomationSchedule = Policy("automation_schedule")
+	// Which containers automated updates are made to, or not made
+	// to; see ContainerAutomated
+	AutomationContainers        = Policy("automation_containers")
+	AutomationExcludeContainers = Policy("automation_exclude_containers")
+	// How often the resource is synced, if more often than
+	// everything else; a duration, e.g., "1m"
+	SyncInterval = Policy("sync-interval")
 )
 
 // Policy is an string, denoting the current deployment policy of a service,
@@ -22,7 +35,7 @@ type Policy string
 
 func Boolean(policy Policy) bool {
 	switch policy {
-	case Locked, Automated, Ignore:
+	case Locked, Automated, Ignore, PinDigest, ConfigHash:
 		return true
 	}
 	return false
@@ -36,15 +49,66 @@ func Tag(policy Policy) bool {
 	return strings.HasPrefix(string(policy), "tag.")
 }
 
+// TagExcludePrefix gives the policy listing the tags to exclude for
+// the container given, as patterns separated by spaces.
+func TagExcludePrefix(container string) Policy {
+	return Policy("tag_exclude." + container)
+}
+
+func TagExclude(policy Policy) bool {
+	return strings.HasPrefix(string(policy), "tag_exclude.")
+}
+
+// GetTagPattern gives the pattern for the tags of the container
+// given: the tag filter, if there is one, less any excluded tags. A
+// tag that matches both the filter and an exclusion is excluded.
 func GetTagPattern(policies Set, container string) Pattern {
 	if policies == nil {
 		return PatternAll
 	}
-	pattern, ok := policies.Get(TagPrefix(container))
-	if !ok {
-		return PatternAll
+	pattern := PatternAll
+	if p, ok := policies.Get(TagPrefix(container)); ok {
+		pattern = NewPattern(p)
 	}
-	return NewPattern(pattern)
+	if excludes, ok := policies.Get(TagExcludePrefix(container)); ok {
+		var valid []Pattern
+		for _, exclude := range NewPatterns(excludes) {
+			// An invalid pattern would match everything; better to
+			// leave it out than exclude every tag
+			if exclude.Valid() {
+				valid = append(valid, exclude)
+			}
+		}
+		if len(valid) > 0 {
+			return ExcludingPattern{Pattern: pattern, Excludes: valid}
+		}
+	}
+	return pattern
+}
+
+// ContainerAutomated says whether automated updates are made to the
+// container given (which may be an init container). If there's an
+// AutomationContainers policy, the container must match one of the
+// names in it; and it mustn't match any of the names in an
+// AutomationExcludeContainers policy. Names are separated by commas
+// or spaces, and may be globs, e.g., `init-*`.
+func ContainerAutomated(policies Set, container string) bool {
+	if include, ok := policies.Get(AutomationContainers); ok && !matchesAnyName(include, container) {
+		return false
+	}
+	if exclude, ok := policies.Get(AutomationExcludeContainers); ok && matchesAnyName(exclude, container) {
+		return false
+	}
+	return true
+}
+
+func matchesAnyName(names, container string) bool {
+	for _, name := range strings.FieldsFunc(names, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if glob.Glob(name, container) {
+			return true
+		}
+	}
+	return false
 }
 
 type Updates map[flux.ResourceID]Update
@@ -54,6 +118,25 @@ type Update struct {
 	Remove Set `json:"remove"`
 }
 
+// ExpandTagAll gives the update with the pseudo-policy TagAll, if it
+// has it, replaced by the same tag filter for each of the containers
+// given; or, if the filter is PatternAll, by removing their filters.
+func (u Update) ExpandTagAll(containers []string) Update {
+	tagAll, ok := u.Add.Get(TagAll)
+	if !ok {
+		return u
+	}
+	add, remove := u.Add.Without(TagAll), clone(u.Remove)
+	for _, container := range containers {
+		if tagAll == PatternAll.String() {
+			remove = remove.Add(TagPrefix(container))
+		} else {
+			add = add.Set(TagPrefix(container), tagAll)
+		}
+	}
+	return Update{Add: add, Remove: remove}
+}
+
 type Set map[Policy]string
 
 // We used to specify a set of policies as []Policy, and in some places
@@ -130,6 +213,20 @@ func (s Set) Without(omit Policy) Set {
 	return newMap
 }
 
+// Apply gives the policies resulting from the update; policies in
+// both the additions and removals are removed. The update should
+// have had TagAll expanded.
+func (s Set) Apply(u Update) Set {
+	s = clone(s)
+	for p, v := range u.Add {
+		s[p] = v
+	}
+	for p := range u.Remove {
+		delete(s, p)
+	}
+	return s
+}
+
 func (s Set) ToStringMap() map[string]string {
 	m := map[string]string{}
 	for p, v := range s {
@@ -81,6 +81,33 @@ func Test_GetTagPattern(t *testing.T) {
 			},
 			want: NewPattern("master-*"),
 		},
+		{
+			name: "Match with exclusions",
+			args: args{
+				policies: Set{
+					Policy(fmt.Sprintf("tag.%s", container)):         "glob:master-*",
+					Policy(fmt.Sprintf("tag_exclude.%s", container)): "glob:master-pr-* regexp:(",
+				},
+				container: container,
+			},
+			want: ExcludingPattern{
+				Pattern:  NewPattern("master-*"),
+				Excludes: []Pattern{NewPattern("master-pr-*")},
+			},
+		},
+		{
+			name: "Exclusions only",
+			args: args{
+				policies: Set{
+					Policy(fmt.Sprintf("tag_exclude.%s", container)): "latest",
+				},
+				container: container,
+			},
+			want: ExcludingPattern{
+				Pattern:  PatternAll,
+				Excludes: []Pattern{NewPattern("latest")},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -88,3 +115,24 @@ func Test_GetTagPattern(t *testing.T) {
 		})
 	}
 }
+
+func TestContainerAutomated(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		policies  Set
+		container string
+		want      bool
+	}{
+		{"no policies", nil, "app", true},
+		{"included", Set{AutomationContainers: "app, sidecar"}, "sidecar", true},
+		{"not included", Set{AutomationContainers: "app,sidecar"}, "migrate", false},
+		{"included by glob", Set{AutomationContainers: "app init-*"}, "init-db", true},
+		{"excluded", Set{AutomationExcludeContainers: "init-*"}, "init-db", false},
+		{"not excluded", Set{AutomationExcludeContainers: "init-*"}, "app", true},
+		{"included and excluded", Set{AutomationContainers: "*", AutomationExcludeContainers: "debug"}, "debug", false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, ContainerAutomated(c.policies, c.container))
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule is a set of windows in the week, e.g., for when automated
+// updates may be made. It's written as one or more windows separated
+// by semicolons, each of which is an optional list of days, a range
+// of times, and an optional time zone:
+//
+//	Mon-Fri 22:00-06:00 Europe/London; Sat,Sun 00:00-24:00
+//
+// Days may be given singly or as ranges, separated by commas; if
+// none are given, the window is every day. A range of times that
+// ends before it starts runs past midnight, into the day after the
+// day it starts on. Times are in UTC unless a time zone (as in the
+// IANA time zone database) is given.
+type Schedule []window
+
+type window struct {
+	days     [7]bool // indexed by time.Weekday
+	start    time.Duration
+	end      time.Duration
+	location *time.Location
+}
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseSchedule parses a schedule as described for Schedule.
+func ParseSchedule(s string) (Schedule, error) {
+	var schedule Schedule
+	for _, w := range strings.Split(s, ";") {
+		if strings.TrimSpace(w) == "" {
+			continue
+		}
+		parsed, err := parseWindow(w)
+		if err != nil {
+			return nil, err
+		}
+		schedule = append(schedule, parsed)
+	}
+	if len(schedule) == 0 {
+		return nil, fmt.Errorf("schedule %q has no windows", s)
+	}
+	return schedule, nil
+}
+
+func parseWindow(s string) (window, error) {
+	w := window{location: time.UTC}
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 3 {
+		return w, fmt.Errorf("expected [days] <start>-<end> [time zone] in schedule window, got %q", strings.TrimSpace(s))
+	}
+
+	// The times are the one field that must be there; find them
+	// by looking for the one starting with a digit
+	timesAt := -1
+	for i, f := range fields {
+		if f[0] >= '0' && f[0] <= '9' {
+			timesAt = i
+			break
+		}
+	}
+	if timesAt < 0 || timesAt > 1 || len(fields)-timesAt > 2 {
+		return w, fmt.Errorf("expected [days] <start>-<end> [time zone] in schedule window, got %q", strings.TrimSpace(s))
+	}
+
+	if timesAt == 1 {
+		if err := w.parseDays(fields[0]); err != nil {
+			return w, err
+		}
+	} else {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	}
+	times := strings.SplitN(fields[timesAt], "-", 2)
+	if len(times) != 2 {
+		return w, fmt.Errorf("expected a range of times <start>-<end> in schedule window, got %q", fields[timesAt])
+	}
+	var err error
+	if w.start, err = parseTimeOfDay(times[0]); err != nil {
+		return w, err
+	}
+	if w.end, err = parseTimeOfDay(times[1]); err != nil {
+		return w, err
+	}
+	if timesAt+1 < len(fields) {
+		if w.location, err = time.LoadLocation(fields[timesAt+1]); err != nil {
+			return w, fmt.Errorf("unknown time zone %q in schedule window", fields[timesAt+1])
+		}
+	}
+	return w, nil
+}
+
+func (w *window) parseDays(s string) error {
+	for _, item := range strings.Split(s, ",") {
+		ends := strings.SplitN(item, "-", 2)
+		first, ok := dayNames[strings.ToLower(ends[0])]
+		if !ok {
+			return fmt.Errorf("unknown day %q in schedule window; expected e.g., Mon", ends[0])
+		}
+		last := first
+		if len(ends) == 2 {
+			if last, ok = dayNames[strings.ToLower(ends[1])]; !ok {
+				return fmt.Errorf("unknown day %q in schedule window; expected e.g., Fri", ends[1])
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseTimeOfDay parses a time of day as HH:MM, including 24:00 for
+// the end of the day.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	var h, m int
+	if n, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || n != 2 || len(s) != 5 || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("expected a time of day as HH:MM in schedule window, got %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// midnight gives the start of the day t falls on, in t's location.
+func midnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+func (w window) contains(t time.Time) bool {
+	t = t.In(w.location)
+	day := midnight(t)
+	// By the clock, rather than elapsed, so it's right on days when
+	// the clocks change
+	h, m, sec := t.Clock()
+	sinceMidnight := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second + time.Duration(t.Nanosecond())
+	if w.start < w.end {
+		return w.days[t.Weekday()] && sinceMidnight >= w.start && sinceMidnight < w.end
+	}
+	// Past midnight: either in the part on the day it starts, or the
+	// part on the day after
+	yesterday := day.AddDate(0, 0, -1).Weekday()
+	return (w.days[t.Weekday()] && sinceMidnight >= w.start) || (w.days[yesterday] && sinceMidnight < w.end)
+}
+
+// Contains says whether the time given is in one of the schedule's
+// windows.
+func (s Schedule) Contains(t time.Time) bool {
+	for _, w := range s {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next gives the time at which the next window in the schedule opens
+// after the time given, or t itself if it's already in a window.
+func (s Schedule) Next(t time.Time) time.Time {
+	if s.Contains(t) {
+		return t
+	}
+	var next time.Time
+	for _, w := range s {
+		day := midnight(t.In(w.location))
+		for i := 0; i <= 7; i++ {
+			d := day.AddDate(0, 0, i)
+			if !w.days[d.Weekday()] {
+				continue
+			}
+			opens := time.Date(d.Year(), d.Month(), d.Day(), int(w.start/time.Hour), int(w.start%time.Hour/time.Minute), 0, 0, w.location)
+			if opens.After(t) {
+				if next.IsZero() || opens.Before(next) {
+					next = opens
+				}
+				break
+			}
+		}
+	}
+	return next
+}
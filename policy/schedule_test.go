@@ -0,0 +1,73 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"whenever",
+		"Mon-Fri",
+		"Funday 10:00-12:00",
+		"Mon 10:00",
+		"Mon 10-12",
+		"Mon 25:00-26:00",
+		"Mon 10:00-12:00 Nowhere/Special",
+		"Mon 10:00-12:00 UTC extra",
+	} {
+		if _, err := ParseSchedule(s); err == nil {
+			t.Errorf("expected error parsing schedule %q", s)
+		}
+	}
+}
+
+func TestScheduleContains(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	schedule, err := ParseSchedule("Mon-Fri 22:00-06:00 Europe/London; Sat,Sun 00:00-24:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for when, expected := range map[time.Time]bool{
+		// Wednesday 5 June 2019
+		time.Date(2019, 6, 5, 14, 0, 0, 0, london):  false,
+		time.Date(2019, 6, 5, 22, 30, 0, 0, london): true,
+		// 21:30 UTC is 22:30 in London, in summer
+		time.Date(2019, 6, 5, 21, 30, 0, 0, time.UTC): true,
+		time.Date(2019, 6, 6, 5, 59, 0, 0, london):    true,
+		time.Date(2019, 6, 6, 6, 0, 0, 0, london):     false,
+		// Saturday, and Monday morning after Sunday (which isn't in
+		// the first window)
+		time.Date(2019, 6, 8, 12, 0, 0, 0, time.UTC): true,
+		time.Date(2019, 6, 10, 2, 0, 0, 0, london):   false,
+	} {
+		if got := schedule.Contains(when); got != expected {
+			t.Errorf("expected Contains(%s) to be %v", when, expected)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	schedule, err := ParseSchedule("Mon-Fri 22:00-06:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Wednesday afternoon opens the same evening
+	wednesday := time.Date(2019, 6, 5, 14, 0, 0, 0, time.UTC)
+	if next := schedule.Next(wednesday); !next.Equal(time.Date(2019, 6, 5, 22, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected next window %s", next)
+	}
+	// Saturday afternoon waits until Monday evening
+	saturday := time.Date(2019, 6, 8, 14, 0, 0, 0, time.UTC)
+	if next := schedule.Next(saturday); !next.Equal(time.Date(2019, 6, 10, 22, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected next window %s", next)
+	}
+	// Already in a window
+	if next := schedule.Next(time.Date(2019, 6, 5, 23, 0, 0, 0, time.UTC)); !next.Equal(time.Date(2019, 6, 5, 23, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the time given when in a window, got %s", next)
+	}
+}
@@ -22,6 +22,12 @@ type Client interface {
 	Writer
 }
 
+// Pinger is implemented by clients that can check whether the backing
+// store is reachable.
+type Pinger interface {
+	Ping() error
+}
+
 // An interface to provide the key under which to store the data
 // Use the full path to image for the memcache key because there
 // might be duplicates from other registries
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ryanuber/go-glob"
+)
+
+// HostConcurrency limits the number of image manifests fetched at
+// once from the registry hosts matching a glob. Some registries cope
+// with many requests in parallel, while others throttle at a few.
+type HostConcurrency struct {
+	Host  string
+	Limit int
+}
+
+// ParseHostConcurrency parses a concurrency limit given as `<registry
+// glob>=<limit>`.
+func ParseHostConcurrency(s string) (HostConcurrency, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return HostConcurrency{}, fmt.Errorf("expected <registry glob>=<limit>, got %q", s)
+	}
+	limit, err := strconv.Atoi(parts[1])
+	if err != nil || limit <= 0 {
+		return HostConcurrency{}, fmt.Errorf("expected a limit greater than zero, got %q", s)
+	}
+	return HostConcurrency{Host: parts[0], Limit: limit}, nil
+}
+
+// concurrencyFor gives the number of manifests that may be fetched at
+// once from the registry host given: the limit of the first of
+// HostConcurrency to match, or the warmer's burst if none do.
+func (w *Warmer) concurrencyFor(host string) int {
+	for _, c := range w.HostConcurrency {
+		if glob.Glob(c.Host, host) {
+			return c.Limit
+		}
+	}
+	return w.burst
+}
+
+// fetchersFor gives the semaphore for fetching from the registry host
+// given, which is shared by everything fetching from that host.
+func (w *Warmer) fetchersFor(host string) chan struct{} {
+	w.fetchersMu.Lock()
+	defer w.fetchersMu.Unlock()
+	if w.fetchers == nil {
+		w.fetchers = map[string]chan struct{}{}
+	}
+	fetchers, ok := w.fetchers[host]
+	if !ok {
+		fetchers = make(chan struct{}, w.concurrencyFor(host))
+		w.fetchers[host] = fetchers
+	}
+	return fetchers
+}
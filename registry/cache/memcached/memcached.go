@@ -136,6 +136,16 @@ func (c *MemcacheClient) SetKey(k cache.Keyer, refreshDeadline time.Time, v []by
 	return nil
 }
 
+// Ping checks that the memcache servers can be reached, by fetching
+// a key that is not expected to exist.
+func (c *MemcacheClient) Ping() error {
+	_, err := c.client.Get("flux-healthcheck")
+	if err == memcache.ErrCacheMiss {
+		return nil
+	}
+	return err
+}
+
 // Stop the memcache client.
 func (c *MemcacheClient) Stop() {
 	close(c.quit)
@@ -2,12 +2,15 @@ package cache
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/metrics/prometheus"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
 
 	fluxmetrics "github.com/weaveworks/flux/metrics"
+	"github.com/weaveworks/flux/registry"
 )
 
 var (
@@ -20,6 +23,35 @@ var (
 	}, []string{fluxmetrics.LabelMethod, fluxmetrics.LabelSuccess})
 )
 
+const (
+	LabelRegistry = "registry"
+)
+
+var (
+	cacheHits = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "cache",
+		Name:      "hits_total",
+		Help:      "Count of image metadata lookups that were answered from the cache.",
+	}, []string{LabelRegistry, registry.LabelRequestKind})
+	cacheMisses = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "cache",
+		Name:      "misses_total",
+		Help:      "Count of image metadata lookups that found nothing (yet) in the cache.",
+	}, []string{LabelRegistry, registry.LabelRequestKind})
+)
+
+// recordLookup counts a cache lookup for image metadata from the
+// registry given as a hit or a miss.
+func recordLookup(registryHost, kind string, hit bool) {
+	counter := cacheMisses
+	if hit {
+		counter = cacheHits
+	}
+	counter.With(LabelRegistry, registryHost, registry.LabelRequestKind, kind).Add(1)
+}
+
 type instrumentedClient struct {
 	next Client
 }
@@ -49,3 +81,41 @@ func (i *instrumentedClient) SetKey(k Keyer, d time.Time, v []byte) (err error)
 	}(time.Now())
 	return i.next.SetKey(k, d, v)
 }
+
+var (
+	cacheHealthy = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "flux",
+		Subsystem: "cache",
+		Name:      "healthy",
+		Help:      "Whether the cache backend could be reached on the last health check (1) or not (0).",
+	}, []string{})
+)
+
+// MonitorHealth pings the cache backend every interval, recording
+// the outcome in a metric and logging whenever it changes.
+func MonitorHealth(p Pinger, interval time.Duration, logger log.Logger, stop <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	healthy := true
+	for {
+		err := p.Ping()
+		switch {
+		case err != nil && healthy:
+			logger.Log("healthy", false, "err", err)
+		case err == nil && !healthy:
+			logger.Log("healthy", true)
+		}
+		healthy = err == nil
+		if healthy {
+			cacheHealthy.Set(1)
+		} else {
+			cacheHealthy.Set(0)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,226 @@
+/* This package implements an image DB cache using redis.
+
+Entries are stored in the same format, and given the same expiry, as
+in the memcached implementation: the value is prefixed with its
+refresh deadline, and the key expires some while after the deadline
+(with a minimum duration), so that redis only garbage collects entries
+that truly need it.
+
+Only the handful of commands needed (GET, SET and PING) are
+implemented, speaking the redis serialisation protocol (RESP)
+directly.
+
+*/
+package redis
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux/registry/cache"
+)
+
+const (
+	// The minimum expiry given to an entry.
+	MinExpiry = time.Hour
+)
+
+var errNil = errors.New("redis: nil reply")
+
+// RedisConfig defines how a RedisClient should be constructed.
+type RedisConfig struct {
+	Address      string
+	Password     string
+	DB           int
+	Timeout      time.Duration
+	Logger       log.Logger
+	MaxIdleConns int
+}
+
+// RedisClient is a redis client keeping a pool of idle connections
+// to a single server.
+type RedisClient struct {
+	config RedisConfig
+	logger log.Logger
+
+	mu   sync.Mutex
+	idle []*conn
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func NewRedisClient(config RedisConfig) *RedisClient {
+	return &RedisClient{
+		config: config,
+		logger: config.Logger,
+	}
+}
+
+// GetKey gets the value and its refresh deadline from the cache.
+func (c *RedisClient) GetKey(k cache.Keyer) ([]byte, time.Time, error) {
+	value, err := c.do("GET", k.Key())
+	if err != nil {
+		if err == errNil {
+			// Don't log on cache miss
+			return []byte{}, time.Time{}, cache.ErrNotCached
+		}
+		c.logger.Log("err", errors.Wrap(err, "Fetching tag from redis"))
+		return []byte{}, time.Time{}, err
+	}
+	if len(value) < 4 {
+		return []byte{}, time.Time{}, cache.ErrNotCached
+	}
+	deadlineTime := binary.BigEndian.Uint32(value)
+	return value[4:], time.Unix(int64(deadlineTime), 0), nil
+}
+
+// SetKey sets the value and its refresh deadline at a key. NB the key
+// expiry is set _longer_ than the deadline, to give us a grace period
+// in which to refresh the value.
+func (c *RedisClient) SetKey(k cache.Keyer, refreshDeadline time.Time, v []byte) error {
+	expiry := refreshDeadline.Sub(time.Now()) * 2
+	if expiry < MinExpiry {
+		expiry = MinExpiry
+	}
+
+	deadlineBytes := make([]byte, 4, 4)
+	binary.BigEndian.PutUint32(deadlineBytes, uint32(refreshDeadline.Unix()))
+	value := string(append(deadlineBytes, v...))
+	if _, err := c.do("SET", k.Key(), value, "EX", strconv.Itoa(int(expiry.Seconds()))); err != nil {
+		c.logger.Log("err", errors.Wrap(err, "storing in redis"))
+		return err
+	}
+	return nil
+}
+
+// Ping checks that the redis server can be reached.
+func (c *RedisClient) Ping() error {
+	_, err := c.do("PING")
+	return err
+}
+
+// Stop the redis client, closing any idle connections.
+func (c *RedisClient) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cn := range c.idle {
+		cn.Close()
+	}
+	c.idle = nil
+}
+
+func (c *RedisClient) do(cmd string, args ...string) ([]byte, error) {
+	cn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := cn.roundtrip(c.config.Timeout, cmd, args...)
+	if err != nil && err != errNil {
+		// The connection may have been left in an unknown state,
+		// so don't reuse it.
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+func (c *RedisClient) get() (*conn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	nc, err := net.DialTimeout("tcp", c.config.Address, c.config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	if c.config.Password != "" {
+		if _, err := cn.roundtrip(c.config.Timeout, "AUTH", c.config.Password); err != nil {
+			cn.Close()
+			return nil, errors.Wrap(err, "authenticating with redis")
+		}
+	}
+	if c.config.DB != 0 {
+		if _, err := cn.roundtrip(c.config.Timeout, "SELECT", strconv.Itoa(c.config.DB)); err != nil {
+			cn.Close()
+			return nil, errors.Wrap(err, "selecting redis database")
+		}
+	}
+	return cn, nil
+}
+
+func (c *RedisClient) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle) >= c.config.MaxIdleConns {
+		cn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+// roundtrip writes a command as an array of bulk strings, and reads
+// a single reply.
+func (cn *conn) roundtrip(timeout time.Duration, cmd string, args ...string) ([]byte, error) {
+	if timeout > 0 {
+		cn.SetDeadline(time.Now().Add(timeout))
+	}
+	buf := []byte(fmt.Sprintf("*%d\r\n$%d\r\n%s\r\n", len(args)+1, len(cmd), cmd))
+	for _, arg := range args {
+		buf = append(buf, fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)...)
+	}
+	if _, err := cn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readReply(cn.r)
+}
+
+func readReply(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+', ':':
+		return append([]byte{}, line[1:]...), nil
+	case '-':
+		return nil, errors.New("redis: " + string(line[1:]))
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, errors.Wrap(err, "redis: malformed bulk string length")
+		}
+		if n < 0 {
+			return nil, errNil
+		}
+		value := make([]byte, n+2)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, err
+		}
+		return value[:n], nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply type %q", line[0])
+	}
+}
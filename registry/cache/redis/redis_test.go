@@ -0,0 +1,196 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weaveworks/flux/registry/cache"
+)
+
+type testKey string
+
+func (t testKey) Key() string {
+	return string(t)
+}
+
+// fakeRedis is a redis server that keeps values in memory, answering
+// the commands the client uses, and recording each command it's sent.
+type fakeRedis struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	values   map[string]string
+	commands [][]string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeRedis{listener: listener, values: map[string]string{}}
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		command, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, command)
+		var reply string
+		switch command[0] {
+		case "PING":
+			reply = "+PONG\r\n"
+		case "AUTH", "SELECT":
+			reply = "+OK\r\n"
+		case "SET":
+			s.values[command[1]] = command[2]
+			reply = "+OK\r\n"
+		case "GET":
+			if v, ok := s.values[command[1]]; ok {
+				reply = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+			} else {
+				reply = "$-1\r\n"
+			}
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		s.mu.Unlock()
+		if _, err := io.WriteString(c, reply); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	n, err := readLength(r, '*')
+	if err != nil {
+		return nil, err
+	}
+	command := make([]string, n)
+	for i := range command {
+		size, err := readLength(r, '$')
+		if err != nil {
+			return nil, err
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		command[i] = string(arg[:size])
+	}
+	return command, nil
+}
+
+// readLength reads a line giving the length of an array or bulk
+// string, e.g., `*3\r\n`.
+func readLength(r *bufio.Reader, prefix byte) (int, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	if len(line) < 3 || line[0] != prefix {
+		return 0, fmt.Errorf("unexpected line %q", line)
+	}
+	return strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+}
+
+func (s *fakeRedis) sent(name string) [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sent [][]string
+	for _, command := range s.commands {
+		if command[0] == name {
+			sent = append(sent, command)
+		}
+	}
+	return sent
+}
+
+func TestRedis_ReadWrite(t *testing.T) {
+	server := newFakeRedis(t)
+	defer server.listener.Close()
+
+	client := NewRedisClient(RedisConfig{
+		Address:      server.listener.Addr().String(),
+		Password:     "secret",
+		DB:           2,
+		Timeout:      time.Second,
+		Logger:       log.NewNopLogger(),
+		MaxIdleConns: 2,
+	})
+	defer client.Stop()
+
+	assert.NoError(t, client.Ping())
+
+	_, _, err := client.GetKey(testKey("missing"))
+	assert.Equal(t, cache.ErrNotCached, err)
+
+	deadline := time.Now().Add(10 * time.Minute).Round(time.Second)
+	value := []byte("test bytes\r\nwith a line break")
+	assert.NoError(t, client.SetKey(testKey("test"), deadline, value))
+	cached, cachedDeadline, err := client.GetKey(testKey("test"))
+	assert.NoError(t, err)
+	assert.Equal(t, value, cached)
+	assert.True(t, deadline.Equal(cachedDeadline), "expected deadline %s, got %s", deadline, cachedDeadline)
+
+	// The key is given at least the minimum expiry, however soon the
+	// deadline
+	if sets := server.sent("SET"); assert.Len(t, sets, 1) {
+		assert.Equal(t, "EX", sets[0][3])
+		expiry, err := strconv.Atoi(sets[0][4])
+		assert.NoError(t, err)
+		assert.True(t, time.Duration(expiry)*time.Second >= MinExpiry, "expected expiry of at least %s, got %ds", MinExpiry, expiry)
+	}
+
+	// Connections are reused, and each new one is authenticated
+	// and has the database selected
+	auths, selects := server.sent("AUTH"), server.sent("SELECT")
+	assert.Len(t, auths, 1)
+	assert.Len(t, selects, 1)
+	assert.Equal(t, []string{"AUTH", "secret"}, auths[0])
+	assert.Equal(t, []string{"SELECT", "2"}, selects[0])
+}
+
+func TestReadReply(t *testing.T) {
+	for reply, expected := range map[string]string{
+		"+OK\r\n":         "OK",
+		":42\r\n":         "42",
+		"$5\r\nhello\r\n": "hello",
+		"$0\r\n\r\n":      "",
+	} {
+		value, err := readReply(bufio.NewReader(strings.NewReader(reply)))
+		assert.NoError(t, err, reply)
+		assert.Equal(t, expected, string(value))
+	}
+
+	_, err := readReply(bufio.NewReader(strings.NewReader("$-1\r\n")))
+	assert.Equal(t, errNil, err)
+	_, err = readReply(bufio.NewReader(strings.NewReader("-ERR wrong\r\n")))
+	assert.EqualError(t, err, "redis: ERR wrong")
+}
@@ -34,9 +34,13 @@ type Cache struct {
 // GetRepositoryImages returns the list of image manifests in an image
 // repository (e.g,. at "quay.io/weaveworks/flux")
 func (c *Cache) GetRepositoryImages(id image.Name) ([]image.Info, error) {
-	repoKey := NewRepositoryKey(id.CanonicalName())
+	name := id.CanonicalName()
+	repoKey := NewRepositoryKey(name)
 	bytes, _, err := c.Reader.GetKey(repoKey)
 	if err != nil {
+		if err == ErrNotCached {
+			recordLookup(name.Domain, registry.RequestKindTags, false)
+		}
 		return nil, err
 	}
 	var repo ImageRepository
@@ -47,11 +51,13 @@ func (c *Cache) GetRepositoryImages(id image.Name) ([]image.Info, error) {
 	// We only care about the error if we've never successfully
 	// updated the result.
 	if repo.LastUpdate.IsZero() {
+		recordLookup(name.Domain, registry.RequestKindTags, false)
 		if repo.LastError != "" {
 			return nil, errors.New(repo.LastError)
 		}
 		return nil, ErrNotCached
 	}
+	recordLookup(name.Domain, registry.RequestKindTags, true)
 
 	images := make([]image.Info, len(repo.Images))
 	var i int
@@ -65,12 +71,17 @@ func (c *Cache) GetRepositoryImages(id image.Name) ([]image.Info, error) {
 // GetImage gets the manifest of a specific image ref, from its
 // registry.
 func (c *Cache) GetImage(id image.Ref) (image.Info, error) {
-	key := NewManifestKey(id.CanonicalRef())
+	ref := id.CanonicalRef()
+	key := NewManifestKey(ref)
 
 	val, _, err := c.Reader.GetKey(key)
 	if err != nil {
+		if err == ErrNotCached {
+			recordLookup(ref.Domain, registry.RequestKindMetadata, false)
+		}
 		return image.Info{}, err
 	}
+	recordLookup(ref.Domain, registry.RequestKindMetadata, true)
 	var img registry.ImageEntry
 	err = json.Unmarshal(val, &img)
 	if err != nil {
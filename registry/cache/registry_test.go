@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weaveworks/flux/image"
+	"github.com/weaveworks/flux/metrics/metricstest"
+	"github.com/weaveworks/flux/registry"
+)
+
+// lookups gives the number of cache hits or misses counted so far
+// for the registry and kind of lookup given.
+func lookups(t *testing.T, metric, registryHost, kind string) float64 {
+	return metricstest.Value(t, metric, map[string]string{LabelRegistry: registryHost, registry.LabelRequestKind: kind})
+}
+
+func TestCacheLookupMetrics(t *testing.T) {
+	const host = "lookups.example.com"
+	ref, err := image.ParseRef(host + "/path/image:tag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := &mem{}
+	c := &Cache{Reader: reader}
+
+	expect := func(kind string, hits, misses float64) {
+		assert.Equal(t, hits, lookups(t, "flux_cache_hits_total", host, kind), "hits of kind %s", kind)
+		assert.Equal(t, misses, lookups(t, "flux_cache_misses_total", host, kind), "misses of kind %s", kind)
+	}
+	put := func(k Keyer, v interface{}) {
+		bytes, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader.SetKey(k, time.Now().Add(time.Hour), bytes)
+	}
+
+	// Nothing has been cached yet
+	_, err = c.GetRepositoryImages(ref.Name)
+	assert.Equal(t, ErrNotCached, err)
+	_, err = c.GetImage(ref)
+	assert.Equal(t, ErrNotCached, err)
+	expect(registry.RequestKindTags, 0, 1)
+	expect(registry.RequestKindMetadata, 0, 1)
+
+	// A repository that has only ever failed to be fetched is a miss
+	put(NewRepositoryKey(ref.CanonicalName()), ImageRepository{LastError: "fetching failed"})
+	_, err = c.GetRepositoryImages(ref.Name)
+	assert.EqualError(t, err, "fetching failed")
+	expect(registry.RequestKindTags, 0, 2)
+
+	put(NewRepositoryKey(ref.CanonicalName()), ImageRepository{
+		LastUpdate: time.Now(),
+		Images:     map[string]image.Info{ref.Tag: {ID: ref}},
+	})
+	_, err = c.GetRepositoryImages(ref.Name)
+	assert.NoError(t, err)
+	expect(registry.RequestKindTags, 1, 2)
+
+	put(NewManifestKey(ref.CanonicalRef()), registry.ImageEntry{Info: image.Info{ID: ref}})
+	_, err = c.GetImage(ref)
+	assert.NoError(t, err)
+	expect(registry.RequestKindMetadata, 1, 1)
+}
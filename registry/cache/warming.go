@@ -54,6 +54,19 @@ type Warmer struct {
 	Trace         bool
 	Priority      chan image.Name
 	Notify        func()
+	// If non-zero, the first pass over the images in use is spread
+	// over this long, rather than being done as fast as possible, so
+	// that starting up doesn't trip registries' rate limits. Images
+	// already in the cache (e.g., because it outlived a restart) are
+	// not held back.
+	RampUp time.Duration
+	// Limits on the number of manifests fetched at once from
+	// particular registry hosts; hosts not matched by any of these
+	// are limited to burst.
+	HostConcurrency []HostConcurrency
+
+	fetchersMu sync.Mutex
+	fetchers   map[string]chan struct{}
 }
 
 // NewWarmer creates cache warmer that (when Loop is invoked) will
@@ -84,6 +97,17 @@ func (w *Warmer) Loop(logger log.Logger, stop <-chan struct{}, wg *sync.WaitGrou
 	imageCreds := imagesToFetchFunc()
 	backlog := imageCredsToBacklog(imageCreds)
 
+	// While ramping up, images not yet in the cache are fetched at
+	// intervals, so the first pass over the backlog takes about
+	// w.RampUp.
+	var rampInterval time.Duration
+	var rampRemaining int
+	if w.RampUp > 0 && len(backlog) > 0 {
+		rampInterval = w.RampUp / time.Duration(len(backlog))
+		rampRemaining = len(backlog)
+		logger.Log("info", "ramping up fetching of image metadata", "images", len(backlog), "over", w.RampUp)
+	}
+
 	// We have some fine control over how long to spend on each fetch
 	// operation, since they are given a `context`. For now though,
 	// just rattle through them one by one, however long they take.
@@ -121,6 +145,22 @@ func (w *Warmer) Loop(logger log.Logger, stop <-chan struct{}, wg *sync.WaitGrou
 		if len(backlog) > 0 {
 			im := backlog[0]
 			backlog = backlog[1:]
+			if rampRemaining > 0 {
+				rampRemaining--
+				if !w.isCached(im.Name) {
+					select {
+					case <-stop:
+						logger.Log("stopping", "true")
+						return
+					case name := <-w.Priority:
+						priorityWarm(name)
+					case <-time.After(rampInterval):
+					}
+				}
+				if rampRemaining == 0 {
+					logger.Log("info", "finished ramping up fetching of image metadata")
+				}
+			}
 			w.warm(ctx, time.Now(), logger, im.Name, im.Credentials)
 		} else {
 			select {
@@ -137,6 +177,13 @@ func (w *Warmer) Loop(logger log.Logger, stop <-chan struct{}, wg *sync.WaitGrou
 	}
 }
 
+// isCached reports whether there's an entry in the cache for the
+// image repository given.
+func (w *Warmer) isCached(name image.Name) bool {
+	_, _, err := w.cache.GetKey(NewRepositoryKey(name.CanonicalName()))
+	return err == nil
+}
+
 func imageCredsToBacklog(imageCreds registry.ImageCreds) []backlogItem {
 	backlog := make([]backlogItem, len(imageCreds))
 	var i int
@@ -267,9 +314,11 @@ func (w *Warmer) warm(ctx context.Context, now time.Time, logger log.Logger, id
 	if len(toUpdate) > 0 {
 		logger.Log("info", "refreshing image", "image", id, "tag_count", len(tags), "to_update", len(toUpdate), "of_which_refresh", refresh, "of_which_missing", missing)
 
-		// The upper bound for concurrent fetches against a single host is
-		// w.Burst, so limit the number of fetching goroutines to that.
-		fetchers := make(chan struct{}, w.burst)
+		// The upper bound for concurrent fetches against a single host
+		// is its concurrency limit (by default, w.burst), so limit the
+		// number of fetching goroutines to that, counting any fetching
+		// from the same host for other images.
+		fetchers := w.fetchersFor(id.CanonicalName().Domain)
 		awaitFetchers := &sync.WaitGroup{}
 
 		ctxc, cancel := context.WithCancel(ctx)
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -115,6 +116,113 @@ func TestRefreshDeadline(t *testing.T) {
 	assert.True(t, deadline1.Sub(now1) > deadline2.Sub(now2), "%s > %s", deadline1.Sub(now1), deadline2.Sub(now2))
 }
 
+func TestHostConcurrency(t *testing.T) {
+	var tags []string
+	for i := 0; i < 20; i++ {
+		tags = append(tags, fmt.Sprintf("tag%d", i))
+	}
+	var mx sync.Mutex
+	var inFlight, most int
+	client := &mock.Client{
+		TagsFn: func() ([]string, error) {
+			return tags, nil
+		},
+		ManifestFn: func(tag string) (registry.ImageEntry, error) {
+			mx.Lock()
+			inFlight++
+			if inFlight > most {
+				most = inFlight
+			}
+			mx.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mx.Lock()
+			inFlight--
+			mx.Unlock()
+			return registry.ImageEntry{Info: image.Info{ID: repo.ToRef(tag), Digest: tag}}, nil
+		},
+	}
+	limit, err := ParseHostConcurrency("*.com=2")
+	assert.NoError(t, err)
+	warmer := &Warmer{
+		clientFactory:   &mock.ClientFactory{Client: client},
+		cache:           &mem{},
+		burst:           10,
+		HostConcurrency: []HostConcurrency{limit},
+	}
+	warmer.warm(context.TODO(), time.Now(), log.NewNopLogger(), repo, registry.NoCredentials())
+	assert.True(t, most > 0 && most <= 2, "at most 2 fetches at once, got %d", most)
+
+	assert.Equal(t, 10, warmer.concurrencyFor("quay.io"))
+	for _, bad := range []string{"quay.io", "quay.io=", "=4", "quay.io=0", "quay.io=four"} {
+		_, err := ParseHostConcurrency(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+// recordingFactory gives the same client for every repository, and
+// sends the name of each repository a client is asked for.
+type recordingFactory struct {
+	mock.ClientFactory
+	asked chan image.CanonicalName
+}
+
+func (f *recordingFactory) ClientFor(repository image.CanonicalName, creds registry.Credentials) (registry.Client, error) {
+	f.asked <- repository
+	return f.ClientFactory.ClientFor(repository, creds)
+}
+
+func TestRampUp(t *testing.T) {
+	client := &mock.Client{
+		TagsFn: func() ([]string, error) {
+			return nil, nil
+		},
+	}
+	factory := &recordingFactory{
+		ClientFactory: mock.ClientFactory{Client: client},
+		asked:         make(chan image.CanonicalName),
+	}
+	imageCreds := registry.ImageCreds{}
+	for _, s := range []string{"example.com/cached", "example.com/first", "example.com/second"} {
+		ref, err := image.ParseRef(s)
+		assert.NoError(t, err)
+		imageCreds[ref.Name] = registry.NoCredentials()
+	}
+	cached, _ := image.ParseRef("example.com/cached")
+	c := &mem{}
+	c.SetKey(NewRepositoryKey(cached.Name.CanonicalName()), time.Now().Add(time.Hour), []byte(`{}`))
+
+	// Three images over 600ms is an image every 200ms, except for
+	// that already cached, which needn't wait, so comes first. Only
+	// the order and the least time between fetches are checked,
+	// since a loaded machine can make anything take longer.
+	interval := 200 * time.Millisecond
+	warmer := &Warmer{clientFactory: factory, cache: c, burst: 10, RampUp: 3 * interval}
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go warmer.Loop(log.NewNopLogger(), stop, wg, func() registry.ImageCreds { return imageCreds })
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	last := time.Now()
+	for i := 0; i < len(imageCreds); i++ {
+		select {
+		case name := <-factory.asked:
+			waited := time.Since(last)
+			last = time.Now()
+			if i == 0 {
+				assert.Equal(t, cached.Name.CanonicalName(), name, "expected the image already cached to be fetched first")
+			} else {
+				assert.True(t, waited >= interval, "image %s was fetched after only %s", name, waited)
+			}
+		case <-time.After(10 * interval):
+			t.Fatal("timed out waiting for images to be fetched")
+		}
+	}
+}
+
 func setup(t *testing.T, digest *string) (*Warmer, Client) {
 	client := &mock.Client{
 		TagsFn: func() ([]string, error) {
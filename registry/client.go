@@ -100,8 +100,19 @@ func (a *Remote) Tags(ctx context.Context) ([]string, error) {
 	return repository.Tags(ctx).All(ctx)
 }
 
+// The platform of image chosen from a manifest list.
+const (
+	manifestListOS   = "linux"
+	manifestListArch = "amd64"
+)
+
 // Manifest fetches the metadata for an image reference; currently
 // assumed to be in the same repo as that provided to `NewRemote(...)`
+//
+// If the reference is to a manifest list (i.e., a multi-arch image),
+// the digest reported is that of the list, since that's what the tag
+// points at and what changes when the tag is moved; the other
+// metadata is from the image in the list for the platform we run on.
 func (a *Remote) Manifest(ctx context.Context, ref string) (ImageEntry, error) {
 	repository, err := client.NewRepository(named{a.repo}, a.base, a.transport)
 	if err != nil {
@@ -113,15 +124,52 @@ func (a *Remote) Manifest(ctx context.Context, ref string) (ImageEntry, error) {
 	}
 	var manifestDigest digest.Digest
 	digestOpt := client.ReturnContentDigest(&manifestDigest)
-	manifest, fetchErr := manifests.Get(ctx, digest.Digest(ref), digestOpt, distribution.WithTagOption{ref})
-
-interpret:
-	if fetchErr != nil {
-		return ImageEntry{}, fetchErr
+	manifest, err := manifests.Get(ctx, digest.Digest(ref), digestOpt, distribution.WithTagOption{ref})
+	if err != nil {
+		return ImageEntry{}, err
 	}
 
 	info := image.Info{ID: a.repo.ToRef(ref), Digest: manifestDigest.String()}
 
+	if deserialised, ok := manifest.(*manifestlist.DeserializedManifestList); ok {
+		platformDigest, ok := platformManifest(deserialised.ManifestList)
+		if !ok {
+			entry := ImageEntry{}
+			entry.ExcludedReason = "no suitable manifest (" + manifestListOS + " " + manifestListArch + ") in manifestlist"
+			return entry, nil
+		}
+		// Fetched by digest, so there's no need to ask for it; and
+		// the list's digest is kept in any case.
+		manifest, err = manifests.Get(ctx, platformDigest)
+		if err != nil {
+			return ImageEntry{}, err
+		}
+		if _, ok := manifest.(*manifestlist.DeserializedManifestList); ok {
+			return ImageEntry{}, errors.New("manifest list refers to another manifest list")
+		}
+	}
+
+	if err := interpretManifest(ctx, repository, manifest, &info); err != nil {
+		return ImageEntry{}, err
+	}
+	return ImageEntry{Info: info}, nil
+}
+
+// platformManifest gives the digest of the manifest in the list for
+// the platform we use, if there is one.
+func platformManifest(list manifestlist.ManifestList) (digest.Digest, bool) {
+	// TODO(michael): is it valid to just pick the first one that matches?
+	for _, m := range list.Manifests {
+		if m.Platform.OS == manifestListOS && m.Platform.Architecture == manifestListArch {
+			return m.Digest, true
+		}
+	}
+	return "", false
+}
+
+// interpretManifest fills in the image ID and creation time from an
+// image manifest.
+func interpretManifest(ctx context.Context, repository distribution.Repository, manifest distribution.Manifest, info *image.Info) error {
 	// TODO(michael): can we type switch? Not sure how dependable the
 	// underlying types are.
 	switch deserialised := manifest.(type) {
@@ -135,8 +183,8 @@ interpret:
 			Arch    string    `json:"architecture"`
 		}
 
-		if err = json.Unmarshal([]byte(man.History[0].V1Compatibility), &v1); err != nil {
-			return ImageEntry{}, err
+		if err := json.Unmarshal([]byte(man.History[0].V1Compatibility), &v1); err != nil {
+			return err
 		}
 		// This is not the ImageID that Docker uses, but assumed to
 		// identify the image as it's the topmost layer.
@@ -146,7 +194,7 @@ interpret:
 		var man schema2.Manifest = deserialised.Manifest
 		configBytes, err := repository.Blobs(ctx).Get(ctx, man.Config.Digest)
 		if err != nil {
-			return ImageEntry{}, err
+			return err
 		}
 
 		var config struct {
@@ -155,26 +203,14 @@ interpret:
 			OS      string    `json:"os"`
 		}
 		if err = json.Unmarshal(configBytes, &config); err != nil {
-			return ImageEntry{}, err
+			return err
 		}
 		// This _is_ what Docker uses as its Image ID.
 		info.ImageID = man.Config.Digest.String()
 		info.CreatedAt = config.Created
-	case *manifestlist.DeserializedManifestList:
-		var list manifestlist.ManifestList = deserialised.ManifestList
-		// TODO(michael): is it valid to just pick the first one that matches?
-		for _, m := range list.Manifests {
-			if m.Platform.OS == "linux" && m.Platform.Architecture == "amd64" {
-				manifest, fetchErr = manifests.Get(ctx, m.Digest, digestOpt)
-				goto interpret
-			}
-		}
-		entry := ImageEntry{}
-		entry.ExcludedReason = "no suitable manifest (linux amd64) in manifestlist"
-		return entry, nil
 	default:
 		t := reflect.TypeOf(manifest)
-		return ImageEntry{}, errors.New("unknown manifest type: " + t.String())
+		return errors.New("unknown manifest type: " + t.String())
 	}
-	return ImageEntry{Info: info}, nil
+	return nil
 }
@@ -3,6 +3,7 @@ package registry
 import (
 	"context"
 	"crypto/tls"
+	"net"
 	"net/http"
 	"net/url"
 	"sync"
@@ -26,10 +27,30 @@ type RemoteClientFactory struct {
 	// TLS_INSECURE_SKIP_VERIFY, or as a fallback, using HTTP).
 	InsecureHosts []string
 
+	// How many idle connections to keep open to each registry host,
+	// to be reused by later requests; DefaultMaxIdleConnsPerHost if
+	// zero
+	MaxIdleConnsPerHost int
+	// How long a connection may be idle before it's closed;
+	// DefaultIdleConnTimeout if zero
+	IdleConnTimeout time.Duration
+	// The period of TCP keep-alives on connections; DefaultKeepAlive
+	// if zero
+	KeepAlive time.Duration
+
 	mu               sync.Mutex
 	challengeManager challenge.Manager
+	// a transport for each registry host, so connections are pooled
+	// across the clients for all the repos at the host
+	transports map[string]*http.Transport
 }
 
+const (
+	DefaultMaxIdleConnsPerHost = 10
+	DefaultIdleConnTimeout     = 90 * time.Second
+	DefaultKeepAlive           = 30 * time.Second
+)
+
 type logging struct {
 	logger    log.Logger
 	transport http.RoundTripper
@@ -100,29 +121,19 @@ func (f *RemoteClientFactory) ClientFor(repo image.CanonicalName, creds Credenti
 		}
 	}
 
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: insecure,
-	}
-	// Since we construct one of these per scan, be fairly ruthless
-	// about throttling the number, and closing of, idle connections.
-	baseTx := &http.Transport{
-		TLSClientConfig: tlsConfig,
-		MaxIdleConns:    10,
-		IdleConnTimeout: 10 * time.Second,
-		Proxy:           http.ProxyFromEnvironment,
-	}
-	tx := f.Limiters.RoundTripper(baseTx, repo.Domain)
-	if f.Trace {
-		tx = &logging{f.Logger, tx}
-	}
-
 	f.mu.Lock()
 	if f.challengeManager == nil {
 		f.challengeManager = challenge.NewSimpleManager()
 	}
 	manager := f.challengeManager
+	baseTx := f.transportFor(repo.Domain, insecure)
 	f.mu.Unlock()
 
+	tx := f.Limiters.RoundTripper(&countConnections{baseTx}, repo.Domain)
+	if f.Trace {
+		tx = &logging{f.Logger, tx}
+	}
+
 	registryURL, err := f.doChallenge(manager, tx, repo.Domain, insecure)
 	if err != nil {
 		return nil, err
@@ -145,6 +156,50 @@ func (f *RemoteClientFactory) ClientFor(repo image.CanonicalName, creds Credenti
 	return NewInstrumentedClient(client), nil
 }
 
+// transportFor gives the transport for connections to the registry
+// host given, creating it the first time. It must be called with the
+// lock held.
+func (f *RemoteClientFactory) transportFor(domain string, insecure bool) *http.Transport {
+	if tx, ok := f.transports[domain]; ok {
+		return tx
+	}
+	maxIdle := f.MaxIdleConnsPerHost
+	if maxIdle == 0 {
+		maxIdle = DefaultMaxIdleConnsPerHost
+	}
+	idleTimeout := f.IdleConnTimeout
+	if idleTimeout == 0 {
+		idleTimeout = DefaultIdleConnTimeout
+	}
+	keepAlive := f.KeepAlive
+	if keepAlive == 0 {
+		keepAlive = DefaultKeepAlive
+	}
+	// There's one of these per host, so the number of idle
+	// connections is limited per host; and connections are closed
+	// once idle for long enough, so that hosts that are only polled
+	// now and then don't hold connections open in between
+	tx := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: keepAlive,
+		}).DialContext,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: insecure,
+		},
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        maxIdle,
+		MaxIdleConnsPerHost: maxIdle,
+		IdleConnTimeout:     idleTimeout,
+	}
+	if f.transports == nil {
+		f.transports = map[string]*http.Transport{}
+	}
+	f.transports[domain] = tx
+	return tx
+}
+
 // Succeed exists merely so that the user of the ClientFactory can
 // bump rate limits up if a repo's metadata has successfully been
 // fetched.
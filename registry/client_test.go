@@ -0,0 +1,154 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/weaveworks/flux/image"
+)
+
+const (
+	amd64Config = `{"architecture":"amd64","os":"linux","created":"2019-01-02T03:04:05Z"}`
+	arm64Config = `{"architecture":"arm64","os":"linux","created":"2019-02-03T04:05:06Z"}`
+)
+
+func imageManifest(config string) string {
+	return fmt.Sprintf(`{
+  "schemaVersion": 2,
+  "mediaType": %q,
+  "config": {"mediaType": "application/vnd.docker.container.image.v1+json", "size": %d, "digest": %q},
+  "layers": []
+}`, schema2.MediaTypeManifest, len(config), digest.FromString(config))
+}
+
+func manifestList(platforms map[string]string) string {
+	var entries []string
+	for arch, manifest := range platforms {
+		entries = append(entries, fmt.Sprintf(`{"mediaType": %q, "size": %d, "digest": %q, "platform": {"architecture": %q, "os": "linux"}}`,
+			schema2.MediaTypeManifest, len(manifest), digest.FromString(manifest), arch))
+	}
+	return fmt.Sprintf(`{
+  "schemaVersion": 2,
+  "mediaType": %q,
+  "manifests": [%s]
+}`, manifestlist.MediaTypeManifestList, strings.Join(entries, ","))
+}
+
+// registryServer serves the manifests given, by tag and by digest,
+// and the image configs, as blobs.
+func registryServer(t *testing.T, tags map[string]string, manifests []string) *httptest.Server {
+	type doc struct{ mediaType, body string }
+	byRef := map[string]doc{}
+	for _, m := range manifests {
+		byRef[digest.FromString(m).String()] = doc{schema2.MediaTypeManifest, m}
+	}
+	for tag, m := range tags {
+		mediaType := schema2.MediaTypeManifest
+		if strings.Contains(m, manifestlist.MediaTypeManifestList) {
+			mediaType = manifestlist.MediaTypeManifestList
+		}
+		byRef[tag] = doc{mediaType, m}
+		byRef[digest.FromString(m).String()] = doc{mediaType, m}
+	}
+	for _, config := range []string{amd64Config, arm64Config} {
+		byRef[digest.FromString(config).String()] = doc{"application/octet-stream", config}
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.URL.Path, "/")
+		d, ok := byRef[parts[len(parts)-1]]
+		if !ok {
+			t.Logf("not found: %s", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", d.mediaType)
+		w.Header().Set("Content-Length", fmt.Sprint(len(d.body)))
+		w.Header().Set("Docker-Content-Digest", digest.FromString(d.body).String())
+		if r.Method != "HEAD" {
+			w.Write([]byte(d.body))
+		}
+	}))
+}
+
+func TestManifestList(t *testing.T) {
+	amd64 := imageManifest(amd64Config)
+	arm64 := imageManifest(arm64Config)
+	multiarch := manifestList(map[string]string{"amd64": amd64, "arm64": arm64})
+	armOnly := manifestList(map[string]string{"arm64": arm64})
+
+	server := registryServer(t, map[string]string{
+		"multi": multiarch,
+		"arm":   armOnly,
+		"plain": amd64,
+	}, []string{amd64, arm64})
+	defer server.Close()
+
+	remote := &Remote{
+		transport: http.DefaultTransport,
+		repo:      image.CanonicalName{Name: image.Name{Domain: "example.com", Image: "foo/bar"}},
+		base:      server.URL,
+	}
+	created, _ := time.Parse(time.RFC3339, "2019-01-02T03:04:05Z")
+
+	// The digest is that of the list, and the rest is from the image
+	// for linux/amd64
+	entry, err := remote.Manifest(context.Background(), "multi")
+	assert.NoError(t, err)
+	assert.Equal(t, "", entry.ExcludedReason)
+	assert.Equal(t, digest.FromString(multiarch).String(), entry.Digest)
+	assert.Equal(t, digest.FromString(amd64Config).String(), entry.ImageID)
+	assert.True(t, created.Equal(entry.CreatedAt))
+
+	// The same image, not in a list, has a different digest but the
+	// same image ID
+	plain, err := remote.Manifest(context.Background(), "plain")
+	assert.NoError(t, err)
+	assert.Equal(t, digest.FromString(amd64).String(), plain.Digest)
+	assert.Equal(t, entry.ImageID, plain.ImageID)
+
+	// A list without an image for linux/amd64 is excluded
+	entry, err = remote.Manifest(context.Background(), "arm")
+	assert.NoError(t, err)
+	assert.NotEqual(t, "", entry.ExcludedReason)
+}
+
+func TestPlatformManifest(t *testing.T) {
+	var list manifestlist.ManifestList
+	list.Manifests = []manifestlist.ManifestDescriptor{
+		{Platform: manifestlist.PlatformSpec{OS: "linux", Architecture: "arm64"}},
+		{Platform: manifestlist.PlatformSpec{OS: "windows", Architecture: "amd64"}},
+	}
+	list.Manifests[0].Digest = "sha256:arm"
+	list.Manifests[1].Digest = "sha256:windows"
+	if _, ok := platformManifest(list); ok {
+		t.Error("expected no manifest for linux/amd64")
+	}
+	list.Manifests = append(list.Manifests, manifestlist.ManifestDescriptor{
+		Platform: manifestlist.PlatformSpec{OS: "linux", Architecture: "amd64"},
+	})
+	list.Manifests[2].Digest = "sha256:amd64"
+	d, ok := platformManifest(list)
+	assert.True(t, ok)
+	assert.Equal(t, digest.Digest("sha256:amd64"), d)
+}
+
+func TestTransportPerHost(t *testing.T) {
+	f := &RemoteClientFactory{IdleConnTimeout: time.Minute}
+	a := f.transportFor("index.docker.io", false)
+	assert.True(t, a == f.transportFor("index.docker.io", false), "expected the transport for a host to be reused")
+	assert.False(t, a == f.transportFor("quay.io", false), "expected each host to have its own transport")
+	assert.Equal(t, DefaultMaxIdleConnsPerHost, a.MaxIdleConnsPerHost)
+	assert.Equal(t, time.Minute, a.IdleConnTimeout)
+	assert.True(t, f.transportFor("localhost:5000", true).TLSClientConfig.InsecureSkipVerify)
+}
@@ -3,10 +3,13 @@ package middleware
 import (
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
 	"github.com/pkg/errors"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/time/rate"
 )
 
@@ -14,6 +17,23 @@ const (
 	minLimit  = 0.1
 	backOffBy = 2.0
 	recoverBy = 1.5
+
+	LabelHost = "host"
+)
+
+var (
+	rateLimitLimit = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "flux",
+		Subsystem: "registry",
+		Name:      "ratelimit_limit",
+		Help:      "Request quota for the registry host, as last reported in its response headers.",
+	}, []string{LabelHost})
+	rateLimitRemaining = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "flux",
+		Subsystem: "registry",
+		Name:      "ratelimit_remaining",
+		Help:      "Requests remaining in the quota for the registry host, as last reported in its response headers.",
+	}, []string{LabelHost})
 )
 
 // RateLimiters keeps track of per-host rate limiting for an arbitrary
@@ -28,12 +48,20 @@ const (
 // Call `*RateLimiter.Recover(host)` when an operation has succeeded
 // without incident, which will increase the rate limit modestly back
 // towards the given ideal.
+//
+// Registries that report a request quota in their response headers
+// (e.g., `RateLimit-Remaining`, as sent by Docker Hub) have it
+// recorded in metrics. If `ThrottleBelow` is non-zero, a response
+// saying less than that fraction of the quota remains is treated
+// like a `HTTP 429`, so the limit is reduced before the registry
+// starts refusing requests.
 type RateLimiters struct {
-	RPS     float64
-	Burst   int
-	Logger  log.Logger
-	perHost map[string]*rate.Limiter
-	mu      sync.Mutex
+	RPS           float64
+	Burst         int
+	ThrottleBelow float64
+	Logger        log.Logger
+	perHost       map[string]*rate.Limiter
+	mu            sync.Mutex
 }
 
 func (limiters *RateLimiters) clip(limit float64) float64 {
@@ -106,8 +134,10 @@ func (limiters *RateLimiters) RoundTripper(rt http.RoundTripper, host string) ht
 	}
 	var reduceOnce sync.Once
 	return &RoundTripRateLimiter{
-		rl: limiters.perHost[host],
-		tx: rt,
+		rl:            limiters.perHost[host],
+		tx:            rt,
+		host:          host,
+		throttleBelow: limiters.ThrottleBelow,
 		slowDown: func() {
 			reduceOnce.Do(func() { limiters.BackOff(host) })
 		},
@@ -115,9 +145,11 @@ func (limiters *RateLimiters) RoundTripper(rt http.RoundTripper, host string) ht
 }
 
 type RoundTripRateLimiter struct {
-	rl       *rate.Limiter
-	tx       http.RoundTripper
-	slowDown func()
+	rl            *rate.Limiter
+	tx            http.RoundTripper
+	host          string
+	throttleBelow float64
+	slowDown      func()
 }
 
 func (t *RoundTripRateLimiter) RoundTrip(r *http.Request) (*http.Response, error) {
@@ -134,5 +166,37 @@ func (t *RoundTripRateLimiter) RoundTrip(r *http.Request) (*http.Response, error
 	if resp.StatusCode == http.StatusTooManyRequests {
 		t.slowDown()
 	}
+	if limit, remaining, ok := quotaFromHeaders(resp.Header); ok {
+		rateLimitLimit.With(LabelHost, t.host).Set(limit)
+		rateLimitRemaining.With(LabelHost, t.host).Set(remaining)
+		if t.throttleBelow > 0 && limit > 0 && remaining/limit < t.throttleBelow {
+			t.slowDown()
+		}
+	}
 	return resp, err
 }
+
+// quotaFromHeaders gets the request quota and how much of it
+// remains from the response headers, if both are present. Values may
+// have a quota policy appended, as in `RateLimit-Limit: 100;w=21600`.
+func quotaFromHeaders(header http.Header) (limit, remaining float64, ok bool) {
+	for _, prefix := range []string{"RateLimit-", "X-RateLimit-"} {
+		limit, limitOK := parseQuota(header.Get(prefix + "Limit"))
+		remaining, remainingOK := parseQuota(header.Get(prefix + "Remaining"))
+		if limitOK && remainingOK {
+			return limit, remaining, true
+		}
+	}
+	return 0, 0, false
+}
+
+func parseQuota(value string) (float64, bool) {
+	if i := strings.IndexAny(value, ";,"); i >= 0 {
+		value = value[:i]
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestQuotaFromHeaders(t *testing.T) {
+	for _, c := range []struct {
+		header           http.Header
+		limit, remaining float64
+		ok               bool
+	}{
+		{http.Header{"Ratelimit-Limit": {"100;w=21600"}, "Ratelimit-Remaining": {"76;w=21600"}}, 100, 76, true},
+		{http.Header{"X-Ratelimit-Limit": {"5000"}, "X-Ratelimit-Remaining": {"0"}}, 5000, 0, true},
+		{http.Header{"Ratelimit-Limit": {"100"}}, 0, 0, false},
+		{http.Header{}, 0, 0, false},
+	} {
+		limit, remaining, ok := quotaFromHeaders(c.header)
+		if ok != c.ok || limit != c.limit || remaining != c.remaining {
+			t.Errorf("%v: expected (%v, %v, %v), got (%v, %v, %v)", c.header, c.limit, c.remaining, c.ok, limit, remaining, ok)
+		}
+	}
+}
@@ -4,6 +4,9 @@ package registry
 
 import (
 	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
 	"strconv"
 	"time"
 
@@ -34,8 +37,41 @@ var (
 		Name:      "fetch_duration_seconds",
 		Help:      "Duration of remote image metadata requests, in seconds",
 	}, []string{LabelRequestKind, fluxmetrics.LabelSuccess})
+	remoteConnections = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "client",
+		Name:      "connections_total",
+		Help:      "Count of connections used for remote image metadata requests, by whether they were reused from the pool of idle connections.",
+	}, []string{LabelReused})
+	remoteTLSHandshakes = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "flux",
+		Subsystem: "client",
+		Name:      "tls_handshakes_total",
+		Help:      "Count of TLS handshakes made for remote image metadata requests.",
+	}, []string{fluxmetrics.LabelSuccess})
 )
 
+const LabelReused = "reused"
+
+// countConnections counts the connections used for requests, and
+// whether they were reused, as well as the TLS handshakes for new
+// connections.
+type countConnections struct {
+	next http.RoundTripper
+}
+
+func (t *countConnections) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			remoteConnections.With(LabelReused, strconv.FormatBool(info.Reused)).Add(1)
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			remoteTLSHandshakes.With(fluxmetrics.LabelSuccess, strconv.FormatBool(err == nil)).Add(1)
+		},
+	}
+	return t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
 type instrumentedRegistry struct {
 	next Registry
 }
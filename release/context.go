@@ -36,8 +36,11 @@ func (rc *ReleaseContext) Registry() registry.Registry {
 	return rc.registry
 }
 
+// LoadManifests loads the manifests in the repo. Files skipped for
+// being too large can't be updated, so they're left out.
 func (rc *ReleaseContext) LoadManifests() (map[string]resource.Resource, error) {
-	return rc.manifests.LoadManifests(rc.repo.Dir(), rc.repo.ManifestDirs())
+	resources, _, err := cluster.LoadManifestsSkipping(rc.manifests, rc.repo.Dir(), rc.repo.ManifestDirs())
+	return resources, err
 }
 
 func (rc *ReleaseContext) WriteUpdates(updates []*update.WorkloadUpdate) error {
@@ -8,6 +8,7 @@ import (
 	"github.com/weaveworks/flux/api"
 	"github.com/weaveworks/flux/api/v10"
 	"github.com/weaveworks/flux/api/v11"
+	"github.com/weaveworks/flux/api/v12"
 	"github.com/weaveworks/flux/api/v6"
 	"github.com/weaveworks/flux/api/v9"
 	"github.com/weaveworks/flux/job"
@@ -108,6 +109,78 @@ func (p *ErrorLoggingServer) GitRepoConfig(ctx context.Context, regenerate bool)
 	return p.server.GitRepoConfig(ctx, regenerate)
 }
 
+func (p *ErrorLoggingServer) LoopEvents(ctx context.Context, opts v12.LoopEventsOptions) (_ []v12.LoopEvent, err error) {
+	defer func() {
+		if err != nil {
+			p.logger.Log("method", "LoopEvents", "error", err)
+		}
+	}()
+	return p.server.LoopEvents(ctx, opts)
+}
+
+func (p *ErrorLoggingServer) NamespaceSyncStatus(ctx context.Context) (_ v12.NamespacesSync, err error) {
+	defer func() {
+		if err != nil {
+			p.logger.Log("method", "NamespaceSyncStatus", "error", err)
+		}
+	}()
+	return p.server.NamespaceSyncStatus(ctx)
+}
+
+func (p *ErrorLoggingServer) MergePreview(ctx context.Context, opts v12.MergePreviewOptions) (_ v12.MergePreview, err error) {
+	defer func() {
+		if err != nil {
+			p.logger.Log("method", "MergePreview", "error", err)
+		}
+	}()
+	return p.server.MergePreview(ctx, opts)
+}
+
+func (p *ErrorLoggingServer) DaemonConfig(ctx context.Context) (_ v12.DaemonConfig, err error) {
+	defer func() {
+		if err != nil {
+			p.logger.Log("method", "DaemonConfig", "error", err)
+		}
+	}()
+	return p.server.DaemonConfig(ctx)
+}
+
+func (p *ErrorLoggingServer) JobHistory(ctx context.Context, opts v12.JobHistoryOptions) (_ []job.Record, err error) {
+	defer func() {
+		if err != nil {
+			p.logger.Log("method", "JobHistory", "error", err)
+		}
+	}()
+	return p.server.JobHistory(ctx, opts)
+}
+
+func (p *ErrorLoggingServer) AutomationPreview(ctx context.Context, opts v12.AutomationPreviewOptions) (_ v12.AutomationPreview, err error) {
+	defer func() {
+		if err != nil {
+			p.logger.Log("method", "AutomationPreview", "error", err)
+		}
+	}()
+	return p.server.AutomationPreview(ctx, opts)
+}
+
+func (p *ErrorLoggingServer) SyncedResources(ctx context.Context) (_ []byte, err error) {
+	defer func() {
+		if err != nil {
+			p.logger.Log("method", "SyncedResources", "error", err)
+		}
+	}()
+	return p.server.SyncedResources(ctx)
+}
+
+func (p *ErrorLoggingServer) ClusterVersions(ctx context.Context) (_ v12.ClusterVersions, err error) {
+	defer func() {
+		if err != nil {
+			p.logger.Log("method", "ClusterVersions", "error", err)
+		}
+	}()
+	return p.server.ClusterVersions(ctx)
+}
+
 type ErrorLoggingUpstreamServer struct {
 	*ErrorLoggingServer
 	server api.UpstreamServer
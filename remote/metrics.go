@@ -11,6 +11,7 @@ import (
 	"github.com/weaveworks/flux/api"
 	"github.com/weaveworks/flux/api/v10"
 	"github.com/weaveworks/flux/api/v11"
+	"github.com/weaveworks/flux/api/v12"
 	"github.com/weaveworks/flux/api/v6"
 	"github.com/weaveworks/flux/api/v9"
 	"github.com/weaveworks/flux/job"
@@ -128,6 +129,86 @@ func (i *instrumentedServer) GitRepoConfig(ctx context.Context, regenerate bool)
 	return i.s.GitRepoConfig(ctx, regenerate)
 }
 
+func (i *instrumentedServer) LoopEvents(ctx context.Context, opts v12.LoopEventsOptions) (_ []v12.LoopEvent, err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			fluxmetrics.LabelMethod, "LoopEvents",
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.s.LoopEvents(ctx, opts)
+}
+
+func (i *instrumentedServer) NamespaceSyncStatus(ctx context.Context) (_ v12.NamespacesSync, err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			fluxmetrics.LabelMethod, "NamespaceSyncStatus",
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.s.NamespaceSyncStatus(ctx)
+}
+
+func (i *instrumentedServer) MergePreview(ctx context.Context, opts v12.MergePreviewOptions) (_ v12.MergePreview, err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			fluxmetrics.LabelMethod, "MergePreview",
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.s.MergePreview(ctx, opts)
+}
+
+func (i *instrumentedServer) DaemonConfig(ctx context.Context) (_ v12.DaemonConfig, err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			fluxmetrics.LabelMethod, "DaemonConfig",
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.s.DaemonConfig(ctx)
+}
+
+func (i *instrumentedServer) JobHistory(ctx context.Context, opts v12.JobHistoryOptions) (_ []job.Record, err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			fluxmetrics.LabelMethod, "JobHistory",
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.s.JobHistory(ctx, opts)
+}
+
+func (i *instrumentedServer) AutomationPreview(ctx context.Context, opts v12.AutomationPreviewOptions) (_ v12.AutomationPreview, err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			fluxmetrics.LabelMethod, "AutomationPreview",
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.s.AutomationPreview(ctx, opts)
+}
+
+func (i *instrumentedServer) SyncedResources(ctx context.Context) (_ []byte, err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			fluxmetrics.LabelMethod, "SyncedResources",
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.s.SyncedResources(ctx)
+}
+
+func (i *instrumentedServer) ClusterVersions(ctx context.Context) (_ v12.ClusterVersions, err error) {
+	defer func(begin time.Time) {
+		requestDuration.With(
+			fluxmetrics.LabelMethod, "ClusterVersions",
+			fluxmetrics.LabelSuccess, fmt.Sprint(err == nil),
+		).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	return i.s.ClusterVersions(ctx)
+}
+
 var _ api.UpstreamServer = &instrumentedUpstreamServer{}
 
 type instrumentedUpstreamServer struct {
@@ -12,6 +12,7 @@ import (
 	"github.com/weaveworks/flux/api"
 	"github.com/weaveworks/flux/api/v10"
 	"github.com/weaveworks/flux/api/v11"
+	"github.com/weaveworks/flux/api/v12"
 	"github.com/weaveworks/flux/api/v6"
 	"github.com/weaveworks/flux/api/v9"
 	"github.com/weaveworks/flux/guid"
@@ -49,6 +50,32 @@ type MockServer struct {
 
 	GitRepoConfigAnswer v6.GitConfig
 	GitRepoConfigError  error
+
+	LoopEventsAnswer []v12.LoopEvent
+	LoopEventsError  error
+
+	NamespaceSyncStatusAnswer v12.NamespacesSync
+	NamespaceSyncStatusError  error
+
+	MergePreviewArgTest func(v12.MergePreviewOptions) error
+	MergePreviewAnswer  v12.MergePreview
+	MergePreviewError   error
+
+	DaemonConfigAnswer v12.DaemonConfig
+	DaemonConfigError  error
+
+	JobHistoryAnswer []job.Record
+	JobHistoryError  error
+
+	AutomationPreviewArgTest func(v12.AutomationPreviewOptions) error
+	AutomationPreviewAnswer  v12.AutomationPreview
+	AutomationPreviewError   error
+
+	SyncedResourcesAnswer []byte
+	SyncedResourcesError  error
+
+	ClusterVersionsAnswer v12.ClusterVersions
+	ClusterVersionsError  error
 }
 
 func (p *MockServer) Ping(ctx context.Context) error {
@@ -104,6 +131,48 @@ func (p *MockServer) GitRepoConfig(ctx context.Context, regenerate bool) (v6.Git
 	return p.GitRepoConfigAnswer, p.GitRepoConfigError
 }
 
+func (p *MockServer) LoopEvents(context.Context, v12.LoopEventsOptions) ([]v12.LoopEvent, error) {
+	return p.LoopEventsAnswer, p.LoopEventsError
+}
+
+func (p *MockServer) NamespaceSyncStatus(context.Context) (v12.NamespacesSync, error) {
+	return p.NamespaceSyncStatusAnswer, p.NamespaceSyncStatusError
+}
+
+func (p *MockServer) MergePreview(ctx context.Context, opts v12.MergePreviewOptions) (v12.MergePreview, error) {
+	if p.MergePreviewArgTest != nil {
+		if err := p.MergePreviewArgTest(opts); err != nil {
+			return v12.MergePreview{}, err
+		}
+	}
+	return p.MergePreviewAnswer, p.MergePreviewError
+}
+
+func (p *MockServer) DaemonConfig(context.Context) (v12.DaemonConfig, error) {
+	return p.DaemonConfigAnswer, p.DaemonConfigError
+}
+
+func (p *MockServer) JobHistory(context.Context, v12.JobHistoryOptions) ([]job.Record, error) {
+	return p.JobHistoryAnswer, p.JobHistoryError
+}
+
+func (p *MockServer) AutomationPreview(ctx context.Context, opts v12.AutomationPreviewOptions) (v12.AutomationPreview, error) {
+	if p.AutomationPreviewArgTest != nil {
+		if err := p.AutomationPreviewArgTest(opts); err != nil {
+			return v12.AutomationPreview{}, err
+		}
+	}
+	return p.AutomationPreviewAnswer, p.AutomationPreviewError
+}
+
+func (p *MockServer) SyncedResources(context.Context) ([]byte, error) {
+	return p.SyncedResourcesAnswer, p.SyncedResourcesError
+}
+
+func (p *MockServer) ClusterVersions(context.Context) (v12.ClusterVersions, error) {
+	return p.ClusterVersionsAnswer, p.ClusterVersionsError
+}
+
 var _ api.UpstreamServer = &MockServer{}
 
 // -- Battery of tests for an api.Server implementation. Since these
@@ -174,12 +243,96 @@ func ServerTestBattery(t *testing.T, wrap func(mock api.UpstreamServer) api.Upst
 		return nil
 	}
 
+	loopEventsAnswer := []v12.LoopEvent{
+		{Time: now, Type: v12.LoopEventSync, Message: "sync started"},
+		{Time: now, Type: v12.LoopEventSync, Message: "sync failed", Error: "no git"},
+	}
+
+	nsSyncAnswer := v12.NamespacesSync{
+		Revision: "def456",
+		Namespaces: []v12.NamespaceSync{
+			{Namespace: "default", Revision: "def456"},
+			{Namespace: namespace, Revision: "abc123", FailedRevision: "def456", Errors: []string{namespace + ":deployment/service: not ready"}},
+		},
+	}
+
+	mergePreviewAnswer := v12.MergePreview{
+		Branch:         "feature",
+		BaseRevision:   "abc123",
+		BranchRevision: "def456",
+		Changes: []v12.ResourceChange{
+			{ID: serviceID, Source: "service.yaml", Diff: "-replicas: 1\n+replicas: 2\n"},
+		},
+	}
+	checkMergePreview := func(opts v12.MergePreviewOptions) error {
+		if opts.Branch != "feature" {
+			return fmt.Errorf("expected branch %q, got %q", "feature", opts.Branch)
+		}
+		return nil
+	}
+
+	daemonConfigAnswer := v12.DaemonConfig{
+		Version: "1.2.3",
+		Flags: []v12.ConfigFlag{
+			{Name: "git-url", Values: []string{"git@example.com:org/repo"}, Explicit: true},
+			{Name: "git-path", Values: []string{"a", "b"}, Repeated: true},
+			{Name: "token", Values: []string{""}, Explicit: true, Redacted: true},
+		},
+	}
+
+	jobHistoryAnswer := []job.Record{
+		{
+			ID:       job.ID("job1"),
+			Type:     update.Images,
+			User:     "alice",
+			Status:   job.StatusSucceeded,
+			Revision: "abc123",
+			Queued:   time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+			Started:  time.Date(2019, 1, 1, 0, 0, 1, 0, time.UTC),
+			Finished: time.Date(2019, 1, 1, 0, 0, 3, 0, time.UTC),
+			Duration: 2 * time.Second,
+		},
+	}
+
+	checkAutomationPreview := func(opts v12.AutomationPreviewOptions) error {
+		if opts.Namespace != "default" {
+			return fmt.Errorf("expected namespace %q, got %q", "default", opts.Namespace)
+		}
+		return nil
+	}
+
+	newImageID, _ := image.ParseRef("quay.io/example.com/frob:v0.5.0")
+	automationPreviewAnswer := v12.AutomationPreview{
+		Workloads: []v12.WorkloadAutomationPreview{
+			{
+				ID: flux.MustParseResourceID("default:deployment/frob"),
+				Containers: []v12.ContainerAutomationPreview{
+					{Name: "frobnicator", Current: imageID, Update: true, Target: newImageID, Reason: "latest v0.5.0 > current v0.4.5"},
+				},
+			},
+			{
+				ID:       flux.MustParseResourceID("default:deployment/locked"),
+				HeldBack: "locked",
+			},
+		},
+	}
+
 	mock := &MockServer{
-		ListServicesAnswer:     serviceAnswer,
-		ListImagesAnswer:       imagesAnswer,
-		UpdateManifestsArgTest: checkUpdateSpec,
-		UpdateManifestsAnswer:  job.ID(guid.New()),
-		SyncStatusAnswer:       syncStatusAnswer,
+		ListServicesAnswer:        serviceAnswer,
+		ListImagesAnswer:          imagesAnswer,
+		UpdateManifestsArgTest:    checkUpdateSpec,
+		UpdateManifestsAnswer:     job.ID(guid.New()),
+		SyncStatusAnswer:          syncStatusAnswer,
+		LoopEventsAnswer:          loopEventsAnswer,
+		NamespaceSyncStatusAnswer: nsSyncAnswer,
+		MergePreviewArgTest:       checkMergePreview,
+		MergePreviewAnswer:        mergePreviewAnswer,
+		DaemonConfigAnswer:        daemonConfigAnswer,
+		JobHistoryAnswer:          jobHistoryAnswer,
+		AutomationPreviewArgTest:  checkAutomationPreview,
+		AutomationPreviewAnswer:   automationPreviewAnswer,
+		SyncedResourcesAnswer:     []byte("---\napiVersion: v1\nkind: Namespace\nmetadata:\n  name: default\n"),
+		ClusterVersionsAnswer:     v12.ClusterVersions{Kubectl: "v1.11.3", Server: "v1.12.1"},
 	}
 
 	ctx := context.Background()
@@ -244,4 +397,68 @@ func ServerTestBattery(t *testing.T, wrap func(mock api.UpstreamServer) api.Upst
 	if !reflect.DeepEqual(mock.SyncStatusAnswer, syncSt) {
 		t.Errorf("expected: %#v\ngot: %#v", mock.SyncStatusAnswer, syncSt)
 	}
+
+	loopEvents, err := client.LoopEvents(ctx, v12.LoopEventsOptions{Since: now, Types: []string{v12.LoopEventSync}})
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(mock.LoopEventsAnswer, loopEvents) {
+		t.Errorf("expected: %#v\ngot: %#v", mock.LoopEventsAnswer, loopEvents)
+	}
+
+	nsSync, err := client.NamespaceSyncStatus(ctx)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(mock.NamespaceSyncStatusAnswer, nsSync) {
+		t.Errorf("expected: %#v\ngot: %#v", mock.NamespaceSyncStatusAnswer, nsSync)
+	}
+
+	preview, err := client.MergePreview(ctx, v12.MergePreviewOptions{Branch: "feature"})
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(mock.MergePreviewAnswer, preview) {
+		t.Errorf("expected: %#v\ngot: %#v", mock.MergePreviewAnswer, preview)
+	}
+
+	config, err := client.DaemonConfig(ctx)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(mock.DaemonConfigAnswer, config) {
+		t.Errorf("expected: %#v\ngot: %#v", mock.DaemonConfigAnswer, config)
+	}
+
+	history, err := client.JobHistory(ctx, v12.JobHistoryOptions{Limit: 10})
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(mock.JobHistoryAnswer, history) {
+		t.Errorf("expected: %#v\ngot: %#v", mock.JobHistoryAnswer, history)
+	}
+
+	automationPreview, err := client.AutomationPreview(ctx, v12.AutomationPreviewOptions{Namespace: "default"})
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(mock.AutomationPreviewAnswer, automationPreview) {
+		t.Errorf("expected: %#v\ngot: %#v", mock.AutomationPreviewAnswer, automationPreview)
+	}
+
+	synced, err := client.SyncedResources(ctx)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(mock.SyncedResourcesAnswer, synced) {
+		t.Errorf("expected: %#v\ngot: %#v", mock.SyncedResourcesAnswer, synced)
+	}
+
+	versions, err := client.ClusterVersions(ctx)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(mock.ClusterVersionsAnswer, versions) {
+		t.Errorf("expected: %#v\ngot: %#v", mock.ClusterVersionsAnswer, versions)
+	}
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/weaveworks/flux/api"
 	"github.com/weaveworks/flux/api/v10"
 	"github.com/weaveworks/flux/api/v11"
+	"github.com/weaveworks/flux/api/v12"
 	"github.com/weaveworks/flux/api/v6"
 	"github.com/weaveworks/flux/api/v9"
 	"github.com/weaveworks/flux/job"
@@ -67,3 +68,35 @@ func (bc baseClient) SyncStatus(context.Context, string) ([]string, error) {
 func (bc baseClient) GitRepoConfig(context.Context, bool) (v6.GitConfig, error) {
 	return v6.GitConfig{}, remote.UpgradeNeededError(errors.New("GitRepoConfig method not implemented"))
 }
+
+func (bc baseClient) LoopEvents(context.Context, v12.LoopEventsOptions) ([]v12.LoopEvent, error) {
+	return nil, remote.UpgradeNeededError(errors.New("LoopEvents method not implemented"))
+}
+
+func (bc baseClient) NamespaceSyncStatus(context.Context) (v12.NamespacesSync, error) {
+	return v12.NamespacesSync{}, remote.UpgradeNeededError(errors.New("NamespaceSyncStatus method not implemented"))
+}
+
+func (bc baseClient) MergePreview(context.Context, v12.MergePreviewOptions) (v12.MergePreview, error) {
+	return v12.MergePreview{}, remote.UpgradeNeededError(errors.New("MergePreview method not implemented"))
+}
+
+func (bc baseClient) DaemonConfig(context.Context) (v12.DaemonConfig, error) {
+	return v12.DaemonConfig{}, remote.UpgradeNeededError(errors.New("DaemonConfig method not implemented"))
+}
+
+func (bc baseClient) JobHistory(context.Context, v12.JobHistoryOptions) ([]job.Record, error) {
+	return nil, remote.UpgradeNeededError(errors.New("JobHistory method not implemented"))
+}
+
+func (bc baseClient) AutomationPreview(context.Context, v12.AutomationPreviewOptions) (v12.AutomationPreview, error) {
+	return v12.AutomationPreview{}, remote.UpgradeNeededError(errors.New("AutomationPreview method not implemented"))
+}
+
+func (bc baseClient) SyncedResources(context.Context) ([]byte, error) {
+	return nil, remote.UpgradeNeededError(errors.New("SyncedResources method not implemented"))
+}
+
+func (bc baseClient) ClusterVersions(context.Context) (v12.ClusterVersions, error) {
+	return v12.ClusterVersions{}, remote.UpgradeNeededError(errors.New("ClusterVersions method not implemented"))
+}
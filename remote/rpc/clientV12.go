@@ -0,0 +1,135 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"net/rpc"
+
+	"github.com/weaveworks/flux/api/v12"
+	"github.com/weaveworks/flux/job"
+	"github.com/weaveworks/flux/remote"
+)
+
+// RPCClientV12 is the rpc-backed implementation of a server, for
+// talking to remote daemons. This version introduces LoopEvents,
+// NamespaceSyncStatus, MergePreview, DaemonConfig, JobHistory,
+// AutomationPreview, SyncedResources and ClusterVersions.
+type RPCClientV12 struct {
+	*RPCClientV11
+}
+
+type clientV12 interface {
+	v12.Server
+	v12.Upstream
+}
+
+var _ clientV12 = &RPCClientV12{}
+
+// NewClientV12 creates a new rpc-backed implementation of the server.
+func NewClientV12(conn io.ReadWriteCloser) *RPCClientV12 {
+	return &RPCClientV12{NewClientV11(conn)}
+}
+
+func (p *RPCClientV12) LoopEvents(ctx context.Context, opts v12.LoopEventsOptions) ([]v12.LoopEvent, error) {
+	var resp LoopEventsResponse
+	err := p.client.Call("RPCServer.LoopEvents", opts, &resp)
+	if err != nil {
+		if _, ok := err.(rpc.ServerError); !ok && err != nil {
+			err = remote.FatalError{err}
+		}
+	} else if resp.ApplicationError != nil {
+		err = resp.ApplicationError
+	}
+	return resp.Result, err
+}
+
+func (p *RPCClientV12) NamespaceSyncStatus(ctx context.Context) (v12.NamespacesSync, error) {
+	var resp NamespaceSyncStatusResponse
+	err := p.client.Call("RPCServer.NamespaceSyncStatus", struct{}{}, &resp)
+	if err != nil {
+		if _, ok := err.(rpc.ServerError); !ok && err != nil {
+			err = remote.FatalError{err}
+		}
+	} else if resp.ApplicationError != nil {
+		err = resp.ApplicationError
+	}
+	return resp.Result, err
+}
+
+func (p *RPCClientV12) MergePreview(ctx context.Context, opts v12.MergePreviewOptions) (v12.MergePreview, error) {
+	var resp MergePreviewResponse
+	err := p.client.Call("RPCServer.MergePreview", opts, &resp)
+	if err != nil {
+		if _, ok := err.(rpc.ServerError); !ok && err != nil {
+			err = remote.FatalError{err}
+		}
+	} else if resp.ApplicationError != nil {
+		err = resp.ApplicationError
+	}
+	return resp.Result, err
+}
+
+func (p *RPCClientV12) DaemonConfig(ctx context.Context) (v12.DaemonConfig, error) {
+	var resp DaemonConfigResponse
+	err := p.client.Call("RPCServer.DaemonConfig", struct{}{}, &resp)
+	if err != nil {
+		if _, ok := err.(rpc.ServerError); !ok && err != nil {
+			err = remote.FatalError{err}
+		}
+	} else if resp.ApplicationError != nil {
+		err = resp.ApplicationError
+	}
+	return resp.Result, err
+}
+
+func (p *RPCClientV12) JobHistory(ctx context.Context, opts v12.JobHistoryOptions) ([]job.Record, error) {
+	var resp JobHistoryResponse
+	err := p.client.Call("RPCServer.JobHistory", opts, &resp)
+	if err != nil {
+		if _, ok := err.(rpc.ServerError); !ok && err != nil {
+			err = remote.FatalError{err}
+		}
+	} else if resp.ApplicationError != nil {
+		err = resp.ApplicationError
+	}
+	return resp.Result, err
+}
+
+func (p *RPCClientV12) AutomationPreview(ctx context.Context, opts v12.AutomationPreviewOptions) (v12.AutomationPreview, error) {
+	var resp AutomationPreviewResponse
+	err := p.client.Call("RPCServer.AutomationPreview", opts, &resp)
+	if err != nil {
+		if _, ok := err.(rpc.ServerError); !ok && err != nil {
+			err = remote.FatalError{err}
+		}
+	} else if resp.ApplicationError != nil {
+		err = resp.ApplicationError
+	}
+	return resp.Result, err
+}
+
+func (p *RPCClientV12) SyncedResources(ctx context.Context) ([]byte, error) {
+	var resp SyncedResourcesResponse
+	err := p.client.Call("RPCServer.SyncedResources", struct{}{}, &resp)
+	if err != nil {
+		if _, ok := err.(rpc.ServerError); !ok && err != nil {
+			err = remote.FatalError{err}
+		}
+	} else if resp.ApplicationError != nil {
+		err = resp.ApplicationError
+	}
+	return resp.Result, err
+}
+
+func (p *RPCClientV12) ClusterVersions(ctx context.Context) (v12.ClusterVersions, error) {
+	var resp ClusterVersionsResponse
+	err := p.client.Call("RPCServer.ClusterVersions", struct{}{}, &resp)
+	if err != nil {
+		if _, ok := err.(rpc.ServerError); !ok && err != nil {
+			err = remote.FatalError{err}
+		}
+	} else if resp.ApplicationError != nil {
+		err = resp.ApplicationError
+	}
+	return resp.Result, err
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/weaveworks/flux/api"
+	"github.com/weaveworks/flux/api/v12"
 	"github.com/weaveworks/flux/api/v6"
 	"github.com/weaveworks/flux/api/v9"
 	fluxerr "github.com/weaveworks/flux/errors"
@@ -196,3 +197,139 @@ func (p *RPCServer) GitRepoConfig(regenerate bool, resp *GitRepoConfigResponse)
 	}
 	return err
 }
+
+type LoopEventsResponse struct {
+	Result           []v12.LoopEvent
+	ApplicationError *fluxerr.Error
+}
+
+func (p *RPCServer) LoopEvents(opts v12.LoopEventsOptions, resp *LoopEventsResponse) error {
+	v, err := p.s.LoopEvents(context.Background(), opts)
+	resp.Result = v
+	if err != nil {
+		if err, ok := errors.Cause(err).(*fluxerr.Error); ok {
+			resp.ApplicationError = err
+			return nil
+		}
+	}
+	return err
+}
+
+type NamespaceSyncStatusResponse struct {
+	Result           v12.NamespacesSync
+	ApplicationError *fluxerr.Error
+}
+
+func (p *RPCServer) NamespaceSyncStatus(_ struct{}, resp *NamespaceSyncStatusResponse) error {
+	v, err := p.s.NamespaceSyncStatus(context.Background())
+	resp.Result = v
+	if err != nil {
+		if err, ok := errors.Cause(err).(*fluxerr.Error); ok {
+			resp.ApplicationError = err
+			return nil
+		}
+	}
+	return err
+}
+
+type MergePreviewResponse struct {
+	Result           v12.MergePreview
+	ApplicationError *fluxerr.Error
+}
+
+func (p *RPCServer) MergePreview(opts v12.MergePreviewOptions, resp *MergePreviewResponse) error {
+	v, err := p.s.MergePreview(context.Background(), opts)
+	resp.Result = v
+	if err != nil {
+		if err, ok := errors.Cause(err).(*fluxerr.Error); ok {
+			resp.ApplicationError = err
+			return nil
+		}
+	}
+	return err
+}
+
+type DaemonConfigResponse struct {
+	Result           v12.DaemonConfig
+	ApplicationError *fluxerr.Error
+}
+
+func (p *RPCServer) DaemonConfig(_ struct{}, resp *DaemonConfigResponse) error {
+	v, err := p.s.DaemonConfig(context.Background())
+	resp.Result = v
+	if err != nil {
+		if err, ok := errors.Cause(err).(*fluxerr.Error); ok {
+			resp.ApplicationError = err
+			return nil
+		}
+	}
+	return err
+}
+
+type JobHistoryResponse struct {
+	Result           []job.Record
+	ApplicationError *fluxerr.Error
+}
+
+func (p *RPCServer) JobHistory(opts v12.JobHistoryOptions, resp *JobHistoryResponse) error {
+	v, err := p.s.JobHistory(context.Background(), opts)
+	resp.Result = v
+	if err != nil {
+		if err, ok := errors.Cause(err).(*fluxerr.Error); ok {
+			resp.ApplicationError = err
+			return nil
+		}
+	}
+	return err
+}
+
+type AutomationPreviewResponse struct {
+	Result           v12.AutomationPreview
+	ApplicationError *fluxerr.Error
+}
+
+func (p *RPCServer) AutomationPreview(opts v12.AutomationPreviewOptions, resp *AutomationPreviewResponse) error {
+	v, err := p.s.AutomationPreview(context.Background(), opts)
+	resp.Result = v
+	if err != nil {
+		if err, ok := errors.Cause(err).(*fluxerr.Error); ok {
+			resp.ApplicationError = err
+			return nil
+		}
+	}
+	return err
+}
+
+type SyncedResourcesResponse struct {
+	Result           []byte
+	ApplicationError *fluxerr.Error
+}
+
+func (p *RPCServer) SyncedResources(_ struct{}, resp *SyncedResourcesResponse) error {
+	v, err := p.s.SyncedResources(context.Background())
+	resp.Result = v
+	if err != nil {
+		if err, ok := errors.Cause(err).(*fluxerr.Error); ok {
+			resp.ApplicationError = err
+			return nil
+		}
+	}
+	return err
+}
+
+type ClusterVersionsResponse struct {
+	Result           v12.ClusterVersions
+	ApplicationError *fluxerr.Error
+}
+
+func (p *RPCServer) ClusterVersions(_ struct{}, resp *ClusterVersionsResponse) error {
+	v, err := p.s.ClusterVersions(context.Background())
+	resp.Result = v
+	if err != nil {
+		if err, ok := errors.Cause(err).(*fluxerr.Error); ok {
+			resp.ApplicationError = err
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,245 @@
+// Package signature checks that images are signed, using cosign, so
+// that automation only updates workloads to images that were signed
+// by someone trusted.
+package signature
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ryanuber/go-glob"
+
+	"github.com/weaveworks/flux/image"
+)
+
+// How long an image that failed verification is remembered as such,
+// when UnsignedTTL isn't set. Images that were verified are
+// remembered for as long as the daemon runs, since an image digest
+// can't change; but a signature can be pushed for an image after the
+// image, so a failure is tried again after a while.
+const DefaultUnsignedTTL = 10 * time.Minute
+
+// How long to let cosign run, for each image, when Timeout isn't set.
+const DefaultTimeout = time.Minute
+
+// Policy says how images from the registry hosts matching a glob
+// (e.g., `*.gcr.io`) must be signed: either with the key of which
+// Key is the public key file, or (keyless, with a certificate from
+// Sigstore) by Identity, as issued by the OIDC provider Issuer.
+type Policy struct {
+	Registry string
+	Key      string
+	Identity string
+	Issuer   string
+}
+
+func (p Policy) String() string {
+	if p.Key != "" {
+		return p.Registry + "=" + p.Key
+	}
+	return p.Registry + "=" + p.Issuer + "=" + p.Identity
+}
+
+// ParseKeyPolicy parses a policy requiring a key signature, given as
+// `<registry glob>=<public key file>`.
+func ParseKeyPolicy(s string) (Policy, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Policy{}, fmt.Errorf("expected <registry glob>=<public key file>, got %q", s)
+	}
+	return Policy{Registry: parts[0], Key: parts[1]}, nil
+}
+
+// ParseIdentityPolicy parses a policy requiring a keyless signature,
+// given as `<registry glob>=<OIDC issuer>=<identity>`.
+func ParseIdentityPolicy(s string) (Policy, error) {
+	parts := strings.SplitN(s, "=", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return Policy{}, fmt.Errorf("expected <registry glob>=<OIDC issuer>=<identity>, got %q", s)
+	}
+	return Policy{Registry: parts[0], Issuer: parts[1], Identity: parts[2]}, nil
+}
+
+// Verifier checks image signatures.
+type Verifier interface {
+	// Requires says whether images in the repository given must be
+	// signed, i.e., whether there's a policy for its registry.
+	Requires(name image.Name) bool
+	// Verify returns nil if the image is signed as the policy for
+	// its registry requires (or there's no policy for its
+	// registry), an *UnsignedError if it isn't, or some other error
+	// if it couldn't be checked.
+	Verify(ctx context.Context, info image.Info) error
+}
+
+// UnsignedError is returned when an image has no signature that
+// satisfies the policy for its registry.
+type UnsignedError struct {
+	Image  image.Ref
+	Policy Policy
+	Output string
+}
+
+func (e *UnsignedError) Error() string {
+	msg := fmt.Sprintf("image %s is not signed as required by %s", e.Image, e.Policy)
+	if e.Output != "" {
+		msg += ": " + e.Output
+	}
+	return msg
+}
+
+type cacheEntry struct {
+	err     *UnsignedError // nil if verified
+	checked time.Time
+}
+
+// Cosign verifies images by running `cosign verify` against the image
+// digest, with the first of Policies whose registry glob matches the
+// image's registry host. Results are cached by digest.
+type Cosign struct {
+	Policies []Policy
+	// The cosign executable; if empty, `cosign` is looked for in the
+	// PATH
+	Exe         string
+	Timeout     time.Duration
+	UnsignedTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+	// for testing; if nil, cosign is run. It returns false, with
+	// the output, if verification failed.
+	run func(ctx context.Context, args ...string) (bool, []byte, error)
+}
+
+// PolicyFor returns the policy that applies to images in the
+// repository given, and false if no policy applies.
+func (c *Cosign) PolicyFor(name image.Name) (Policy, bool) {
+	host := name.Registry()
+	for _, p := range c.Policies {
+		if glob.Glob(p.Registry, host) {
+			return p, true
+		}
+	}
+	return Policy{}, false
+}
+
+func (c *Cosign) Requires(name image.Name) bool {
+	_, ok := c.PolicyFor(name)
+	return ok
+}
+
+// Check returns an error if cosign can't be found, or the key of any
+// of the policies isn't there to be read. Keys given as URIs (e.g.,
+// of a KMS) are left to cosign.
+func (c *Cosign) Check() error {
+	exe := c.Exe
+	if exe == "" {
+		exe = "cosign"
+	}
+	if _, err := exec.LookPath(exe); err != nil {
+		return fmt.Errorf("cosign is needed to verify image signatures: %s", err)
+	}
+	for _, p := range c.Policies {
+		if p.Key == "" || strings.Contains(p.Key, "://") {
+			continue
+		}
+		if _, err := os.Stat(p.Key); err != nil {
+			return fmt.Errorf("key for image signatures from %s: %s", p.Registry, err)
+		}
+	}
+	return nil
+}
+
+func (c *Cosign) Verify(ctx context.Context, info image.Info) error {
+	policy, ok := c.PolicyFor(info.ID.Name)
+	if !ok {
+		return nil
+	}
+	if info.Digest == "" {
+		// A tag can be moved, so there's nothing to tie a
+		// signature to
+		return &UnsignedError{Image: info.ID, Policy: policy, Output: "the registry gave no digest for the image"}
+	}
+	ref := info.ID.Name.String() + "@" + info.Digest
+	key := policy.String() + " " + ref
+
+	ttl := c.UnsignedTTL
+	if ttl == 0 {
+		ttl = DefaultUnsignedTTL
+	}
+	c.mu.Lock()
+	entry, found := c.cache[key]
+	c.mu.Unlock()
+	if found && (entry.err == nil || time.Since(entry.checked) < ttl) {
+		if entry.err == nil {
+			return nil
+		}
+		return entry.err
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	args := []string{"verify"}
+	if policy.Key != "" {
+		args = append(args, "--key", policy.Key)
+	} else {
+		args = append(args, "--certificate-identity", policy.Identity, "--certificate-oidc-issuer", policy.Issuer)
+	}
+	args = append(args, ref)
+	run := c.run
+	if run == nil {
+		run = c.cosign
+	}
+	signed, output, err := run(ctx, args...)
+	if ctx.Err() != nil {
+		// Don't remember a result that's down to running out of
+		// time
+		return fmt.Errorf("verifying signature of %s: %s", ref, ctx.Err())
+	}
+	if err != nil {
+		return fmt.Errorf("running cosign: %s", err)
+	}
+	entry = cacheEntry{checked: time.Now()}
+	if !signed {
+		entry.err = &UnsignedError{Image: info.ID, Policy: policy, Output: strings.TrimSpace(string(output))}
+	}
+	c.mu.Lock()
+	if c.cache == nil {
+		c.cache = map[string]cacheEntry{}
+	}
+	c.cache[key] = entry
+	c.mu.Unlock()
+	if entry.err != nil {
+		return entry.err
+	}
+	return nil
+}
+
+// cosign runs cosign with the arguments given. cosign exits non-zero
+// if verification fails, saying why on stderr; any other error means
+// it couldn't be run at all.
+func (c *Cosign) cosign(ctx context.Context, args ...string) (bool, []byte, error) {
+	exe := c.Exe
+	if exe == "" {
+		exe = "cosign"
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Stderr = &stderr
+	_, err := cmd.Output()
+	if _, failed := err.(*exec.ExitError); failed {
+		return false, stderr.Bytes(), nil
+	}
+	return err == nil, stderr.Bytes(), err
+}
@@ -0,0 +1,113 @@
+package signature
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/flux/image"
+)
+
+func mustParseRef(t *testing.T, s string) image.Ref {
+	ref, err := image.ParseRef(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func TestParsePolicies(t *testing.T) {
+	p, err := ParseKeyPolicy("ghcr.io=/etc/cosign/release.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != (Policy{Registry: "ghcr.io", Key: "/etc/cosign/release.pub"}) {
+		t.Errorf("unexpected key policy %+v", p)
+	}
+	p, err = ParseIdentityPolicy("*.gcr.io=https://accounts.google.com=release@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != (Policy{Registry: "*.gcr.io", Issuer: "https://accounts.google.com", Identity: "release@example.com"}) {
+		t.Errorf("unexpected identity policy %+v", p)
+	}
+	for _, bad := range []string{"ghcr.io", "=key.pub", "ghcr.io="} {
+		if _, err := ParseKeyPolicy(bad); err == nil {
+			t.Errorf("expected %q to be rejected as a key policy", bad)
+		}
+	}
+	if _, err := ParseIdentityPolicy("ghcr.io=https://accounts.google.com"); err == nil {
+		t.Error("expected an identity policy without an identity to be rejected")
+	}
+}
+
+func TestCosignVerify(t *testing.T) {
+	var calls [][]string
+	signed := map[string]bool{}
+	c := &Cosign{
+		Policies: []Policy{
+			{Registry: "ghcr.io", Key: "release.pub"},
+			{Registry: "*.gcr.io", Issuer: "https://accounts.google.com", Identity: "release@example.com"},
+		},
+		run: func(ctx context.Context, args ...string) (bool, []byte, error) {
+			calls = append(calls, args)
+			ref := args[len(args)-1]
+			if signed[ref] {
+				return true, nil, nil
+			}
+			return false, []byte("no matching signatures\n"), nil
+		},
+	}
+
+	ghcr := image.Info{ID: mustParseRef(t, "ghcr.io/example/app:1.0"), Digest: "sha256:aaa"}
+	signed["ghcr.io/example/app@sha256:aaa"] = true
+	if err := c.Verify(context.Background(), ghcr); err != nil {
+		t.Fatalf("expected signed image to verify, got %v", err)
+	}
+	if err := c.Verify(context.Background(), ghcr); err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{{"verify", "--key", "release.pub", "ghcr.io/example/app@sha256:aaa"}}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected verified image to be checked once, as %v; got %v", expected, calls)
+	}
+
+	gcr := image.Info{ID: mustParseRef(t, "eu.gcr.io/example/app:1.0"), Digest: "sha256:bbb"}
+	err := c.Verify(context.Background(), gcr)
+	unsigned, ok := err.(*UnsignedError)
+	if !ok {
+		t.Fatalf("expected an UnsignedError, got %v", err)
+	}
+	if unsigned.Output != "no matching signatures" {
+		t.Errorf("expected cosign output in error, got %q", unsigned.Output)
+	}
+	if args := calls[len(calls)-1]; !reflect.DeepEqual(args, []string{"verify", "--certificate-identity", "release@example.com", "--certificate-oidc-issuer", "https://accounts.google.com", "eu.gcr.io/example/app@sha256:bbb"}) {
+		t.Errorf("unexpected args for keyless verification: %v", args)
+	}
+
+	// an unsigned image is remembered until the recheck is due, and
+	// then checked again
+	signed["eu.gcr.io/example/app@sha256:bbb"] = true
+	if _, ok := c.Verify(context.Background(), gcr).(*UnsignedError); !ok {
+		t.Error("expected unsigned image to be remembered as unsigned")
+	}
+	c.UnsignedTTL = time.Nanosecond
+	if err := c.Verify(context.Background(), gcr); err != nil {
+		t.Errorf("expected image to be checked again and verify, got %v", err)
+	}
+
+	// no digest, no verification
+	if _, ok := c.Verify(context.Background(), image.Info{ID: mustParseRef(t, "ghcr.io/example/app:2.0")}).(*UnsignedError); !ok {
+		t.Error("expected an image without a digest not to verify")
+	}
+
+	// no policy, nothing to check
+	before := len(calls)
+	if err := c.Verify(context.Background(), image.Info{ID: mustParseRef(t, "quay.io/example/app:1.0"), Digest: "sha256:ccc"}); err != nil {
+		t.Errorf("expected image from a registry without a policy to pass, got %v", err)
+	}
+	if len(calls) != before {
+		t.Error("expected cosign not to be run for a registry without a policy")
+	}
+}
@@ -0,0 +1,100 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// The format ssh-keygen uses for a certificate's validity, in local
+// time.
+const certificateTimeFormat = "2006-01-02T15:04:05"
+
+// Certificate gives the details of an SSH certificate needed to tell
+// whether it's valid.
+type Certificate struct {
+	KeyID string
+	// Zero if the certificate is valid from any time
+	ValidAfter time.Time
+	// Zero if the certificate never expires
+	ValidBefore time.Time
+}
+
+// Expired says whether the certificate has expired, as of the time
+// given.
+func (c Certificate) Expired(now time.Time) bool {
+	return !c.ValidBefore.IsZero() && !now.Before(c.ValidBefore)
+}
+
+// NotYetValid says whether the certificate is not valid until after
+// the time given.
+func (c Certificate) NotYetValid(now time.Time) bool {
+	return !c.ValidAfter.IsZero() && now.Before(c.ValidAfter)
+}
+
+// ReadCertificate reads the SSH certificate at the path given, with
+// ssh-keygen.
+func ReadCertificate(path string) (Certificate, error) {
+	output, err := exec.Command("ssh-keygen", "-L", "-f", path).CombinedOutput()
+	if err != nil {
+		return Certificate{}, fmt.Errorf("reading SSH certificate %s: %s", path, strings.TrimSpace(string(output)))
+	}
+	return parseCertificate(output, time.Local)
+}
+
+// parseCertificate parses the output of `ssh-keygen -L`, e.g.,
+//
+//	identity-cert.pub:
+//	        Type: ssh-ed25519-cert-v01@openssh.com user certificate
+//	        ...
+//	        Key ID: "flux"
+//	        Serial: 1
+//	        Valid: from 2019-01-01T00:00:00 to 2019-02-01T00:00:00
+//
+// The validity may also be "forever", "after <time>" or "before <time>".
+func parseCertificate(output []byte, loc *time.Location) (Certificate, error) {
+	var cert Certificate
+	var valid string
+	sc := bufio.NewScanner(bytes.NewReader(output))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case strings.HasPrefix(line, "Key ID: "):
+			cert.KeyID = strings.Trim(strings.TrimPrefix(line, "Key ID: "), `"`)
+		case strings.HasPrefix(line, "Valid: "):
+			valid = strings.TrimPrefix(line, "Valid: ")
+		}
+	}
+	if valid == "" {
+		return cert, errors.New("could not find the validity of the SSH certificate")
+	}
+
+	parse := func(s string) (time.Time, error) {
+		t, err := time.ParseInLocation(certificateTimeFormat, s, loc)
+		if err != nil {
+			return t, fmt.Errorf("could not parse the validity of the SSH certificate: %q", valid)
+		}
+		return t, nil
+	}
+	var err error
+	fields := strings.Fields(valid)
+	switch {
+	case valid == "forever":
+	case len(fields) == 4 && fields[0] == "from" && fields[2] == "to":
+		if cert.ValidAfter, err = parse(fields[1]); err != nil {
+			return cert, err
+		}
+		cert.ValidBefore, err = parse(fields[3])
+	case len(fields) == 2 && fields[0] == "after":
+		cert.ValidAfter, err = parse(fields[1])
+	case len(fields) == 2 && fields[0] == "before":
+		cert.ValidBefore, err = parse(fields[1])
+	default:
+		err = fmt.Errorf("could not parse the validity of the SSH certificate: %q", valid)
+	}
+	return cert, err
+}
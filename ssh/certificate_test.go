@@ -0,0 +1,53 @@
+package ssh
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCertificate(t *testing.T) {
+	output := []byte(`identity-cert.pub:
+        Type: ssh-ed25519-cert-v01@openssh.com user certificate
+        Public key: ED25519-CERT SHA256:aBcDeF
+        Signing CA: ED25519 SHA256:gHiJkL (using ssh-ed25519)
+        Key ID: "flux@cluster-1"
+        Serial: 7
+        Valid: from 2019-01-01T00:00:00 to 2019-02-01T12:30:00
+        Principals:
+                git
+        Critical Options: (none)
+        Extensions:
+                permit-pty
+`)
+	cert, err := parseCertificate(output, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.KeyID != "flux@cluster-1" {
+		t.Errorf("unexpected key ID %q", cert.KeyID)
+	}
+	if want := time.Date(2019, 2, 1, 12, 30, 0, 0, time.UTC); !cert.ValidBefore.Equal(want) {
+		t.Errorf("expected to be valid before %s, got %s", want, cert.ValidBefore)
+	}
+	if !cert.Expired(time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("expected certificate to have expired")
+	}
+	if !cert.NotYetValid(time.Date(2018, 12, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("expected certificate not to be valid yet")
+	}
+	if now := time.Date(2019, 1, 15, 0, 0, 0, 0, time.UTC); cert.Expired(now) || cert.NotYetValid(now) {
+		t.Error("expected certificate to be valid")
+	}
+
+	cert, err = parseCertificate([]byte("        Valid: forever\n"), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Expired(time.Now()) || cert.NotYetValid(time.Now()) {
+		t.Error("expected certificate valid forever to be valid")
+	}
+
+	if _, err = parseCertificate([]byte("        Valid: sometimes\n"), time.UTC); err == nil {
+		t.Error("expected error parsing unknown validity")
+	}
+}
@@ -1,22 +1,87 @@
 package sync
 
 import (
+	"github.com/pkg/errors"
+
+	"github.com/weaveworks/flux"
 	"github.com/weaveworks/flux/cluster"
 	"github.com/weaveworks/flux/resource"
 )
 
 // Syncer has the methods we need to be able to compile and run a sync
 type Syncer interface {
-	Sync(cluster.SyncSet) error
+	Sync(cluster.SyncSet) (cluster.SyncSummary, error)
+}
+
+// Sync synchronises the cluster to the files under a directory, and
+// reports what was done. A summary is returned even if there's an
+// error, since some resources may have been synced nonetheless.
+func Sync(setName string, repoResources map[string]resource.Resource, clus Syncer) (cluster.SyncSummary, error) {
+	return SyncWithOptions(setName, repoResources, clus, Options{})
 }
 
-// Sync synchronises the cluster to the files under a directory.
-func Sync(setName string, repoResources map[string]resource.Resource, clus Syncer) error {
+// Options for a sync, beyond the resources to sync.
+type Options struct {
+	// The revision the resources come from, if known
+	Revision string
+	// If not nil, only resources with these IDs need be applied. All
+	// the resources must still be given, since missing resources may
+	// be garbage collected.
+	Changed flux.ResourceIDSet
+	// If not empty, only resources in this namespace are synced;
+	// see cluster.SyncSet.
+	Namespace string
+	// If not nil, the resources are synced to this validation cluster
+	// first; see Validation.
+	Validation *Validation
+	// If set, nothing is garbage collected; see cluster.SyncSet.
+	NoGC bool
+	// If set, the sync bootstraps a fresh cluster; see
+	// cluster.SyncSet.
+	Bootstrap bool
+	// If set, only some of the resources are given; see
+	// cluster.SyncSet.
+	Partial bool
+}
+
+// What to do when syncing to the validation cluster fails.
+const (
+	// Don't sync to the cluster proper
+	ValidationBlock = "block"
+	// Report the failure, and sync to the cluster proper anyway
+	ValidationProceed = "proceed"
+)
+
+// Validation is a cluster (e.g., a staging cluster) to which the
+// resources are synced before the cluster proper, as a safety gate.
+type Validation struct {
+	Cluster Syncer
+	// ValidationBlock (the default) or ValidationProceed
+	Action string
+	// Called with the result of each sync to the validation
+	// cluster, if not nil
+	Report func(cluster.SyncSummary, error)
+}
+
+// SyncWithOptions is like Sync, with the options given.
+func SyncWithOptions(setName string, repoResources map[string]resource.Resource, clus Syncer, opts Options) (cluster.SyncSummary, error) {
 	set := makeSet(setName, repoResources)
-	if err := clus.Sync(set); err != nil {
-		return err
+	set.Revision = opts.Revision
+	set.Changed = opts.Changed
+	set.Namespace = opts.Namespace
+	set.NoGC = opts.NoGC
+	set.Bootstrap = opts.Bootstrap
+	set.Partial = opts.Partial
+	if v := opts.Validation; v != nil {
+		summary, err := v.Cluster.Sync(set)
+		if v.Report != nil {
+			v.Report(summary, err)
+		}
+		if err != nil && v.Action != ValidationProceed {
+			return cluster.SyncSummary{}, errors.Wrap(err, "syncing to the validation cluster failed, so not syncing to the cluster")
+		}
 	}
-	return nil
+	return clus.Sync(set)
 }
 
 func makeSet(name string, repoResources map[string]resource.Resource) cluster.SyncSet {
@@ -28,3 +93,9 @@ func makeSet(name string, repoResources map[string]resource.Resource) cluster.Sy
 	s.Resources = resources
 	return s
 }
+
+// Drift compares the resources given with what's running in the
+// cluster, without changing anything, and reports those that differ.
+func Drift(setName string, repoResources map[string]resource.Resource, detector cluster.DriftDetector) ([]cluster.Drift, error) {
+	return detector.Drift(makeSet(setName, repoResources))
+}
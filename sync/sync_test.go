@@ -1,6 +1,7 @@
 package sync
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -28,12 +29,62 @@ func TestSync(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := Sync("synctest", resources, clus); err != nil {
+	summary, err := Sync("synctest", resources, clus)
+	if err != nil {
 		t.Fatal(err)
 	}
+	if summary.Count(cluster.SyncConfigured) != len(resources) {
+		t.Errorf("expected summary to count %d resources, got %s", len(resources), summary)
+	}
 	checkClusterMatchesFiles(t, manifests, clus.resources, checkout.Dir(), dirs)
 }
 
+type failingCluster struct{ syncs int }
+
+func (f *failingCluster) Sync(cluster.SyncSet) (cluster.SyncSummary, error) {
+	f.syncs++
+	return cluster.SyncSummary{}, errors.New("validation failed")
+}
+
+// Test that the cluster proper is only synced when syncing to the
+// validation cluster succeeds, unless told to proceed regardless.
+func TestSyncWithValidation(t *testing.T) {
+	checkout, cleanup := setup(t)
+	defer cleanup()
+
+	manifests := &kubernetes.Manifests{}
+	resources, err := manifests.LoadManifests(checkout.Dir(), checkout.ManifestDirs())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validation := &syncCluster{map[string]string{}}
+	clus := &syncCluster{map[string]string{}}
+	_, err = SyncWithOptions("synctest", resources, clus, Options{Validation: &Validation{Cluster: validation}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, resourcesToStrings(resources), validation.resources)
+	assert.Equal(t, resourcesToStrings(resources), clus.resources)
+
+	failing := &failingCluster{}
+	clus = &syncCluster{map[string]string{}}
+	_, err = SyncWithOptions("synctest", resources, clus, Options{Validation: &Validation{Cluster: failing, Action: ValidationBlock}})
+	assert.Error(t, err)
+	assert.Equal(t, 1, failing.syncs)
+	assert.Empty(t, clus.resources)
+
+	var reported error
+	_, err = SyncWithOptions("synctest", resources, clus, Options{Validation: &Validation{
+		Cluster: failing,
+		Action:  ValidationProceed,
+		Report:  func(_ cluster.SyncSummary, err error) { reported = err },
+	}})
+	assert.NoError(t, err)
+	assert.Error(t, reported)
+	assert.Equal(t, resourcesToStrings(resources), clus.resources)
+}
+
 // ---
 
 var gitconf = git.Config{
@@ -53,14 +104,17 @@ func setup(t *testing.T) (*git.Checkout, func()) {
 
 type syncCluster struct{ resources map[string]string }
 
-func (p *syncCluster) Sync(def cluster.SyncSet) error {
+func (p *syncCluster) Sync(def cluster.SyncSet) (cluster.SyncSummary, error) {
 	println("=== Syncing ===")
+	summary := cluster.SyncSummary{}
 	for _, resource := range def.Resources {
 		println("Applying " + resource.ResourceID().String())
 		p.resources[resource.ResourceID().String()] = string(resource.Bytes())
+		_, kind, _ := resource.ResourceID().Components()
+		summary.Add(cluster.SyncConfigured, kind)
 	}
 	println("=== Done syncing ===")
-	return nil
+	return summary, nil
 }
 
 func resourcesToStrings(resources map[string]resource.Resource) map[string]string {
@@ -7,6 +7,7 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
 
+	"github.com/weaveworks/flux"
 	fluxerr "github.com/weaveworks/flux/errors"
 	"github.com/weaveworks/flux/image"
 	"github.com/weaveworks/flux/policy"
@@ -63,14 +64,45 @@ func (ii ImageInfos) FilterAndSort(pattern policy.Pattern) SortedImageInfos {
 // FindWithRef returns image.Info given an image ref. If the image cannot be
 // found, it returns the image.Info with the ID provided.
 func (ii ImageInfos) FindWithRef(ref image.Ref) image.Info {
+	// Images from the registry are by tag, so a digest in the ref
+	// is disregarded
+	tagged := ref.WithoutDigest()
 	for _, img := range ii {
-		if img.ID == ref {
+		if img.ID == tagged {
 			return img
 		}
 	}
 	return image.Info{ID: ref}
 }
 
+// TargetImage gives the image a container using the current image
+// should be updated to, given the latest image available, and
+// whether that's different to the current image. If pin is true, the
+// image is pinned to the digest of the latest image, so long as the
+// registry reported one; otherwise, only the tag is changed.
+func TargetImage(current image.Ref, latest image.Info, pin bool) (image.Ref, bool) {
+	target := current
+	if current.Tag != latest.ID.Tag {
+		target = current.WithNewTag(latest.ID.Tag)
+	}
+	if pin && latest.Digest != "" {
+		target = target.WithDigest(latest.Digest)
+	}
+	return target, target != current
+}
+
+// ShouldPinDigest reports whether images in the workload should be
+// pinned to their digests, according to its policies. Helm releases
+// are never pinned, since their values may give the tag separately,
+// with nowhere to put a digest.
+func ShouldPinDigest(id flux.ResourceID, policies policy.Set) bool {
+	if !policies.Has(policy.PinDigest) {
+		return false
+	}
+	_, kind, _ := id.Components()
+	return kind != "helmrelease" && kind != "fluxhelmrelease"
+}
+
 // Latest returns the latest image from SortedImageInfos. If no such image exists,
 // returns a zero value and `false`, and the caller can decide whether
 // that's an error or not.
@@ -245,15 +245,14 @@ func (s ReleaseImageSpec) calculateImageUpdates(rc ReleaseContext, candidates []
 				continue
 			}
 
-			if currentImageID == latestImage.ID {
-				ignoredOrSkipped = ReleaseStatusSkipped
-				continue
-			}
-
 			// We want to update the image with respect to the form it
 			// appears in the manifest, whereas what we have is the
 			// canonical form.
-			newImageID := currentImageID.WithNewTag(latestImage.ID.Tag)
+			newImageID, changed := TargetImage(currentImageID, latestImage, ShouldPinDigest(u.ResourceID, u.Resource.Policies()))
+			if !changed {
+				ignoredOrSkipped = ReleaseStatusSkipped
+				continue
+			}
 			containerUpdates = append(containerUpdates, ContainerUpdate{
 				Container: container.Name,
 				Current:   currentImageID,
@@ -13,6 +13,8 @@ const (
 	Auto       = "auto"
 	Sync       = "sync"
 	Containers = "containers"
+	Reset      = "reset_sync"
+	Mark       = "mark_synced"
 )
 
 // How did this update get triggered?
@@ -72,6 +74,18 @@ func (spec *Spec) UnmarshalJSON(in []byte) error {
 			return err
 		}
 		spec.Spec = update
+	case Reset:
+		var update ResetSync
+		if err := json.Unmarshal(wire.SpecBytes, &update); err != nil {
+			return err
+		}
+		spec.Spec = update
+	case Mark:
+		var update MarkSynced
+		if err := json.Unmarshal(wire.SpecBytes, &update); err != nil {
+			return err
+		}
+		spec.Spec = update
 	default:
 		return errors.New("unknown spec type: " + wire.Type)
 	}
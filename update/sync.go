@@ -1,4 +1,21 @@
 package update
 
 type ManualSync struct {
+	// If not empty, only resources in this namespace are synced,
+	// and the sync tag is left where it is.
+	Namespace string `json:",omitempty"`
+}
+
+// ResetSync moves the sync tag back to an earlier revision in the
+// history of the branch, so that the next sync re-applies everything
+// changed since then.
+type ResetSync struct {
+	Revision string
+}
+
+// MarkSynced moves the sync tag to a revision in the history of the
+// branch without applying anything, for when the manifests have been
+// applied some other way.
+type MarkSynced struct {
+	Revision string
 }
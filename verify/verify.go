@@ -0,0 +1,143 @@
+// Package verify asks something outside fluxd (e.g., a system running
+// smoke tests) whether a sync worked, before the sync tag is moved.
+package verify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/weaveworks/flux"
+)
+
+// What to do when verification fails, or doesn't finish in time.
+const (
+	// Fail the sync, so the sync tag stays where it was
+	FailureBlock = "block"
+	// Carry on, moving the sync tag
+	FailureProceed = "proceed"
+)
+
+// The statuses a verifier can respond with.
+const (
+	StatusPending = "pending"
+	StatusPassed  = "passed"
+	StatusFailed  = "failed"
+)
+
+// How often a status URL is polled, when the poll interval isn't set.
+const DefaultPollInterval = 10 * time.Second
+
+// Request describes a sync, to be verified.
+type Request struct {
+	Revision  string            `json:"revision"`
+	Workloads []flux.ResourceID `json:"workloads,omitempty"`
+}
+
+// Response is what a verifier responds with, both to a request and
+// when its status URL is polled. If the status is pending, fluxd
+// polls the status URL (which must then be given) until it's not.
+type Response struct {
+	Status    string `json:"status"`
+	StatusURL string `json:"statusURL,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// Verifier verifies syncs.
+type Verifier interface {
+	// Verify returns nil if the sync passed verification, a
+	// FailedError if it failed, or some other error if it couldn't
+	// be verified (e.g., because the context is done).
+	Verify(context.Context, Request) error
+}
+
+// FailedError is returned when a verifier says a sync failed.
+type FailedError struct {
+	Message string
+}
+
+func (e *FailedError) Error() string {
+	if e.Message == "" {
+		return "verification failed"
+	}
+	return "verification failed: " + e.Message
+}
+
+// Webhook posts the request, as JSON, to a URL, and follows the
+// status URL in the response if verification is pending.
+type Webhook struct {
+	URL          string
+	Client       *http.Client
+	PollInterval time.Duration
+}
+
+func (w *Webhook) Verify(ctx context.Context, r Request) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.do(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	interval := w.PollInterval
+	if interval == 0 {
+		interval = DefaultPollInterval
+	}
+	for {
+		switch resp.Status {
+		case StatusPassed:
+			return nil
+		case StatusFailed:
+			return &FailedError{Message: resp.Message}
+		case StatusPending:
+			if resp.StatusURL == "" {
+				return fmt.Errorf("verification is pending, but no status URL was given")
+			}
+		default:
+			return fmt.Errorf("unknown verification status %q", resp.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		req, err := http.NewRequest("GET", resp.StatusURL, nil)
+		if err != nil {
+			return err
+		}
+		if resp, err = w.do(ctx, req); err != nil {
+			return err
+		}
+	}
+}
+
+func (w *Webhook) do(ctx context.Context, req *http.Request) (Response, error) {
+	var r Response
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return r, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return r, fmt.Errorf("verification webhook responded with %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return r, fmt.Errorf("decoding response from verification webhook: %s", err)
+	}
+	return r, nil
+}
@@ -0,0 +1,78 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookPollsStatus(t *testing.T) {
+	var got Request
+	polls := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/verify":
+			if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+				t.Error(err)
+			}
+			json.NewEncoder(w).Encode(Response{Status: StatusPending, StatusURL: server.URL + "/status"})
+		case "/status":
+			polls++
+			status := StatusPending
+			if polls == 2 {
+				status = StatusPassed
+			}
+			json.NewEncoder(w).Encode(Response{Status: status, StatusURL: server.URL + "/status"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	webhook := &Webhook{URL: server.URL + "/verify", PollInterval: time.Millisecond}
+	if err := webhook.Verify(context.Background(), Request{Revision: "abc123"}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Revision != "abc123" {
+		t.Errorf("expected revision abc123 to be posted, got %q", got.Revision)
+	}
+	if polls != 2 {
+		t.Errorf("expected status to be polled twice, was polled %d times", polls)
+	}
+}
+
+func TestWebhookFailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Response{Status: StatusFailed, Message: "smoke tests failed"})
+	}))
+	defer server.Close()
+
+	webhook := &Webhook{URL: server.URL}
+	err := webhook.Verify(context.Background(), Request{Revision: "abc123"})
+	failed, ok := err.(*FailedError)
+	if !ok {
+		t.Fatalf("expected a FailedError, got %v", err)
+	}
+	if failed.Message != "smoke tests failed" {
+		t.Errorf("unexpected message %q", failed.Message)
+	}
+}
+
+func TestWebhookTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Response{Status: StatusPending, StatusURL: "http://" + r.Host + "/status"})
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	webhook := &Webhook{URL: server.URL, PollInterval: time.Millisecond}
+	err := webhook.Verify(ctx, Request{Revision: "abc123"})
+	if _, failed := err.(*FailedError); err == nil || failed {
+		t.Fatalf("expected verification to time out, got %v", err)
+	}
+}